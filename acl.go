@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Role grants access to key prefixes. Patterns ending in * match by prefix,
+// a lone * matches every key, anything else must match exactly.
+type Role struct {
+	Read   []string `yaml:"read,omitempty" json:"read,omitempty"`
+	Write  []string `yaml:"write,omitempty" json:"write,omitempty"`
+	Admin  bool     `yaml:"admin,omitempty" json:"admin,omitempty"`
+	Reveal bool     `yaml:"reveal,omitempty" json:"reveal,omitempty"`
+}
+
+// ACLConfig is the on-disk and /admin/acl format of access control rules
+type ACLConfig struct {
+	Roles     map[string]Role     `yaml:"roles" json:"roles"`
+	APIKeys   map[string][]string `yaml:"api_keys" json:"api_keys"`                       // API key -> role names
+	Anonymous []string            `yaml:"anonymous,omitempty" json:"anonymous,omitempty"` // Roles for requests without an API key
+}
+
+// Principal is the effective set of permissions for a request
+type Principal struct {
+	Read   []string
+	Write  []string
+	Admin  bool
+	Reveal bool
+}
+
+// ACL enforces role-restricted key prefixes
+type ACL struct {
+	sync.RWMutex
+	path   string
+	config ACLConfig
+}
+
+// LoadACL reads access control rules from path. An empty path disables ACLs.
+func LoadACL(path string) (*ACL, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read acl file: %v", err)
+	}
+
+	var config ACLConfig
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse acl file: %v", err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &ACL{path: path, config: config}, nil
+}
+
+func (ac ACLConfig) validate() error {
+	for key, roles := range ac.APIKeys {
+		for _, role := range roles {
+			if _, exists := ac.Roles[role]; !exists {
+				return fmt.Errorf("api key %s...: unknown role %s", maskKey(key), role)
+			}
+		}
+	}
+	for _, role := range ac.Anonymous {
+		if _, exists := ac.Roles[role]; !exists {
+			return fmt.Errorf("anonymous: unknown role %s", role)
+		}
+	}
+	return nil
+}
+
+// Replace swaps the rules and persists them back to the ACL file
+func (a *ACL) Replace(config ACLConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	raw, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to encode acl: %v", err)
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if err := os.WriteFile(a.path, raw, 0600); err != nil {
+		return fmt.Errorf("failed to write acl file: %v", err)
+	}
+	a.config = config
+	return nil
+}
+
+// Principal resolves the permissions for an API key
+func (a *ACL) Principal(apiKey string) (*Principal, bool) {
+	a.RLock()
+	defer a.RUnlock()
+
+	roles := a.config.Anonymous
+	if apiKey != "" {
+		var exists bool
+		if roles, exists = a.config.APIKeys[apiKey]; !exists {
+			return nil, false
+		}
+	}
+	if len(roles) == 0 {
+		return nil, false
+	}
+
+	p := &Principal{}
+	for _, name := range roles {
+		role := a.config.Roles[name]
+		p.Read = append(p.Read, role.Read...)
+		p.Write = append(p.Write, role.Write...)
+		p.Admin = p.Admin || role.Admin
+		p.Reveal = p.Reveal || role.Reveal
+	}
+	return p, true
+}
+
+// knows reports whether the rules give roles to an API key
+func (a *ACL) knows(key string) bool {
+	if a == nil {
+		return false
+	}
+	a.RLock()
+	defer a.RUnlock()
+	_, exists := a.config.APIKeys[key]
+	return exists
+}
+
+// CanRead reports whether the principal may read key
+func (p *Principal) CanRead(key string) bool {
+	return p.Admin || matchesAny(p.Read, key) || matchesAny(p.Write, key)
+}
+
+// CanWrite reports whether the principal may write or delete key
+func (p *Principal) CanWrite(key string) bool {
+	return p.Admin || matchesAny(p.Write, key)
+}
+
+func matchesAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if pattern == key {
+			return true
+		}
+	}
+	return false
+}
+
+func maskKey(key string) string {
+	if len(key) > 4 {
+		return key[:4]
+	}
+	return key
+}
+
+// aclMiddleware resolves the request principal when ACLs are enabled
+func aclMiddleware(acl *ACL) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if acl == nil {
+			c.Next()
+			return
+		}
+
+		principal, ok := acl.Principal(apiKeyFrom(c))
+		if !ok {
+			respondError(c, 401, CodeUnauthorized, "missing or unknown API key")
+			return
+		}
+
+		c.Set("principal", principal)
+		c.Next()
+	}
+}
+
+// requireAdmin rejects requests whose principal lacks the admin role.
+// Without ACLs, requests need the API key of their tenant or an admin key
+// once tenants have API keys, see TenantRegistry.isAdmin.
+func requireAdmin() gin.HandlerFunc {
+	return adminCheck("tenantAdmin")
+}
+
+// requireServerAdmin is requireAdmin for routes acting on the whole server
+// rather than the store of the request's tenant, which without ACLs need an
+// admin key once tenants have API keys
+func requireServerAdmin() gin.HandlerFunc {
+	return adminCheck("serverAdmin")
+}
+
+// adminCheck rejects requests whose principal lacks the admin role, or
+// without ACLs those tenantMiddleware did not flag with flag
+func adminCheck(flag string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p := principalFrom(c); p != nil {
+			if !p.Admin {
+				respondError(c, 403, CodeForbidden, "admin role required")
+				return
+			}
+		} else if !c.GetBool(flag) {
+			respondError(c, 403, CodeForbidden, "admin API key required")
+			return
+		}
+		c.Next()
+	}
+}
+
+// principalFrom returns the request principal, or nil when ACLs are disabled
+func principalFrom(c *gin.Context) *Principal {
+	if v, exists := c.Get("principal"); exists {
+		return v.(*Principal)
+	}
+	return nil
+}
+
+// canRead reports whether the request may read key
+func canRead(c *gin.Context, key string) bool {
+	p := principalFrom(c)
+	return p == nil || p.CanRead(key)
+}
+
+// canWrite reports whether the request may write or delete key
+func canWrite(c *gin.Context, key string) bool {
+	p := principalFrom(c)
+	return p == nil || p.CanWrite(key)
+}
+
+// filterReadable drops search results the request is not allowed to read
+func filterReadable(c *gin.Context, results []storage.SearchResult) []storage.SearchResult {
+	p := principalFrom(c)
+	if p == nil {
+		return results
+	}
+
+	filtered := results[:0]
+	for _, result := range results {
+		if p.CanRead(result.Key) {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+func handleGetACL(acl *ACL) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if acl == nil {
+			respondError(c, 404, CodeNotFound, "acl not enabled")
+			return
+		}
+
+		acl.RLock()
+		defer acl.RUnlock()
+		c.JSON(200, acl.config)
+	}
+}
+
+func handleSetACL(acl *ACL) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if acl == nil {
+			respondError(c, 404, CodeNotFound, "acl not enabled")
+			return
+		}
+
+		var config ACLConfig
+		if err := parseRequestBody(c, &config); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		if err := config.validate(); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		if err := acl.Replace(config); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// Alert rules
+const (
+	alertFileSize    = "file_size"    // The data file is near the maximum size
+	alertSyncLatency = "sync_latency" // Syncs of the data file are slow
+	alertErrorRate   = "error_rate"   // Many requests fail with a server error
+)
+
+// minAlertRequests is the number of requests an interval needs before its
+// error rate can fire an alert, so a single failure on an idle server does
+// not
+const minAlertRequests = 20
+
+// AlertRules are the thresholds of the self-monitoring alerts. A zero
+// threshold disables its rule.
+type AlertRules struct {
+	FileUsage   float64       // Fraction of the maximum size the data file may use
+	SyncLatency time.Duration // Mean sync time over an interval
+	ErrorRate   float64       // Fraction of requests answered with 5xx over an interval
+	Webhook     string        // URL posted an AlertEvent when an alert fires or resolves
+	Interval    time.Duration // Between evaluations of the rules
+}
+
+func (r AlertRules) enabled() bool {
+	return r.FileUsage > 0 || r.SyncLatency > 0 || r.ErrorRate > 0
+}
+
+// Alert is a rule whose threshold is exceeded
+type Alert struct {
+	Rule      string    `json:"rule" yaml:"rule"`
+	Tenant    string    `json:"tenant,omitempty" yaml:"tenant,omitempty"` // Of store rules
+	Message   string    `json:"message" yaml:"message"`
+	Value     float64   `json:"value" yaml:"value"`
+	Threshold float64   `json:"threshold" yaml:"threshold"`
+	Since     time.Time `json:"since" yaml:"since"`
+}
+
+// AlertEvent is the body posted to the webhook when an alert fires or
+// resolves
+type AlertEvent struct {
+	Status string `json:"status"` // firing or resolved
+	Alert
+}
+
+// AlertsResponse is the result of GET /admin/alerts: the thresholds, zero
+// for disabled rules, and the alerts firing
+type AlertsResponse struct {
+	FileUsage   float64 `json:"file_usage" yaml:"file_usage"`
+	SyncLatency string  `json:"sync_latency" yaml:"sync_latency"`
+	ErrorRate   float64 `json:"error_rate" yaml:"error_rate"`
+	Webhook     bool    `json:"webhook" yaml:"webhook"` // Whether events are posted to a webhook
+	Interval    string  `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Firing      []Alert `json:"firing" yaml:"firing"`
+}
+
+// Monitor evaluates the alert rules over the stores of every tenant and the
+// requests the server answered, logging alerts as they fire and resolve and
+// posting them to the webhook. A nil Monitor monitors nothing.
+type Monitor struct {
+	rules    AlertRules
+	registry *TenantRegistry
+	maxSize  int64 // Of stores without a quota of their own
+	client   *http.Client
+
+	requests     atomic.Uint64
+	serverErrors atomic.Uint64
+
+	mu           sync.Mutex
+	firing       map[string]*Alert               // By rule and tenant
+	syncs        map[string]storage.LatencyStats // By tenant, at the last evaluation
+	lastRequests uint64
+	lastErrors   uint64
+}
+
+// NewMonitor returns a monitor of rules, or nil when no rule is enabled
+func NewMonitor(rules AlertRules, registry *TenantRegistry, maxSize int64) (*Monitor, error) {
+	if !rules.enabled() {
+		return nil, nil
+	}
+	if rules.Interval <= 0 {
+		return nil, fmt.Errorf("alert interval must be positive, not %s", rules.Interval)
+	}
+	if rules.FileUsage > 1 || rules.ErrorRate > 1 {
+		return nil, fmt.Errorf("alert file usage and error rate are fractions from 0 to 1")
+	}
+	return &Monitor{
+		rules:    rules,
+		registry: registry,
+		maxSize:  maxSize,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		firing:   make(map[string]*Alert),
+		syncs:    make(map[string]storage.LatencyStats),
+	}, nil
+}
+
+// Start evaluates the rules every interval
+func (m *Monitor) Start() {
+	if m == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(m.rules.Interval)
+		for now := range ticker.C {
+			m.evaluate(now)
+		}
+	}()
+}
+
+// countRequests counts the requests answered and those failing with a
+// server error. It must run outside the recovery middleware to count panics.
+func (m *Monitor) countRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if m == nil {
+			return
+		}
+		m.requests.Add(1)
+		if c.Writer.Status() >= 500 {
+			m.serverErrors.Add(1)
+		}
+	}
+}
+
+// Firing returns the alerts firing, sorted by rule and tenant
+func (m *Monitor) Firing() []Alert {
+	if m == nil {
+		return []Alert{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(m.firing))
+	for _, alert := range m.firing {
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Tenant < alerts[j].Tenant
+	})
+	return alerts
+}
+
+// evaluate checks every rule, firing the alerts whose threshold is exceeded
+// and resolving the others
+func (m *Monitor) evaluate(now time.Time) {
+	var exceeded []Alert
+
+	for _, tenant := range m.registry.Stats() {
+		stats := tenant.Store
+		if m.rules.FileUsage > 0 {
+			maxSize := tenant.Quotas.MaxSize
+			if maxSize <= 0 {
+				maxSize = m.maxSize
+			}
+			if usage := float64(stats.FileSize) / float64(maxSize); maxSize > 0 && usage > m.rules.FileUsage {
+				exceeded = append(exceeded, Alert{
+					Rule: alertFileSize, Tenant: tenant.Name, Value: usage, Threshold: m.rules.FileUsage,
+					Message: fmt.Sprintf("data file of %d bytes uses %.0f%% of the maximum size of %d bytes", stats.FileSize, usage*100, maxSize),
+				})
+			}
+		}
+		if m.rules.SyncLatency > 0 {
+			current := stats.PerformanceStats.Sync
+			m.mu.Lock()
+			previous := m.syncs[tenant.Name]
+			m.syncs[tenant.Name] = current
+			m.mu.Unlock()
+			if current.Count < previous.Count {
+				previous = storage.LatencyStats{} // Reset since
+			}
+			threshold := float64(m.rules.SyncLatency) / float64(time.Millisecond)
+			if n := current.Count - previous.Count; n > 0 {
+				if mean := (current.Sum - previous.Sum) / float64(n); mean > threshold {
+					exceeded = append(exceeded, Alert{
+						Rule: alertSyncLatency, Tenant: tenant.Name, Value: mean, Threshold: threshold,
+						Message: fmt.Sprintf("syncs took %.1fms on average, over %s", mean, m.rules.SyncLatency),
+					})
+				}
+			}
+		}
+	}
+
+	if m.rules.ErrorRate > 0 {
+		requests, serverErrors := m.requests.Load(), m.serverErrors.Load()
+		m.mu.Lock()
+		n, failed := requests-m.lastRequests, serverErrors-m.lastErrors
+		m.lastRequests, m.lastErrors = requests, serverErrors
+		m.mu.Unlock()
+		if rate := float64(failed) / float64(n); n >= minAlertRequests && rate > m.rules.ErrorRate {
+			exceeded = append(exceeded, Alert{
+				Rule: alertErrorRate, Value: rate, Threshold: m.rules.ErrorRate,
+				Message: fmt.Sprintf("%d of %d requests failed with a server error", failed, n),
+			})
+		}
+	}
+
+	m.update(now, exceeded)
+}
+
+// update fires the exceeded alerts not firing yet and resolves the firing
+// alerts no longer exceeded
+func (m *Monitor) update(now time.Time, exceeded []Alert) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := make(map[string]bool, len(exceeded))
+	for _, alert := range exceeded {
+		id := alert.Rule + "/" + alert.Tenant
+		current[id] = true
+		if firing, exists := m.firing[id]; exists {
+			// Still firing: refresh the value, keep the start
+			alert.Since = firing.Since
+			*firing = alert
+			continue
+		}
+		alert.Since = now
+		m.firing[id] = &alert
+		log.Printf("Alert %s firing%s: %s", alert.Rule, tenantSuffix(alert.Tenant), alert.Message)
+		m.notify(AlertEvent{Status: "firing", Alert: alert})
+	}
+	for id, alert := range m.firing {
+		if current[id] {
+			continue
+		}
+		delete(m.firing, id)
+		log.Printf("Alert %s resolved%s", alert.Rule, tenantSuffix(alert.Tenant))
+		m.notify(AlertEvent{Status: "resolved", Alert: *alert})
+	}
+}
+
+func tenantSuffix(tenant string) string {
+	if tenant == "" || tenant == DefaultTenant {
+		return ""
+	}
+	return " for tenant " + tenant
+}
+
+// notify posts an event to the webhook in the background
+func (m *Monitor) notify(event AlertEvent) {
+	if m.rules.Webhook == "" {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	go func() {
+		resp, err := m.client.Post(m.rules.Webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Alert webhook failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Alert webhook failed: %s", resp.Status)
+		}
+	}()
+}
+
+// handleAlerts returns the alert rules and the alerts firing
+func handleAlerts(monitor *Monitor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := AlertsResponse{SyncLatency: "0s", Firing: monitor.Firing()}
+		if monitor != nil {
+			rules := monitor.rules
+			response.FileUsage = rules.FileUsage
+			response.SyncLatency = rules.SyncLatency.String()
+			response.ErrorRate = rules.ErrorRate
+			response.Webhook = rules.Webhook != ""
+			response.Interval = rules.Interval.String()
+		}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, response)
+		} else {
+			c.JSON(200, response)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// antiEntropyBatch is the number of nodes or entries sent per request, so a
+// failed request over a poor link loses little work
+const antiEntropyBatch = 256
+
+// errPeerFailed marks errors of requests to the peer of an anti-entropy sync
+var errPeerFailed = errors.New("peer request failed")
+
+// MerkleRequest asks for the digests below nodes of the Merkle tree
+type MerkleRequest struct {
+	Nodes []string `json:"nodes" binding:"required"`
+}
+
+// MerkleResponse holds the children digests of each requested inner node
+// and the entry digests of each requested leaf
+type MerkleResponse struct {
+	Nodes  map[string][]string          `json:"nodes,omitempty"`
+	Leaves map[string]map[string]string `json:"leaves,omitempty"`
+}
+
+// SyncEntry is an entry transferred between servers with its write time,
+// TTL and metadata, so both keep the same expiry and labels
+type SyncEntry struct {
+	Value     interface{}       `json:"value"`
+	Timestamp int64             `json:"timestamp"`
+	TTL       int64             `json:"ttl,omitempty"`
+	Metadata  *storage.Metadata `json:"metadata,omitempty"`
+	Sliding   int64             `json:"sliding,omitempty"`
+}
+
+// EntriesRequest asks for the entries of keys
+type EntriesRequest struct {
+	Keys []string `json:"keys" binding:"required"`
+}
+
+// PutEntriesRequest holds entries to apply under the conflict policy
+type PutEntriesRequest struct {
+	Entries map[string]SyncEntry `json:"entries" binding:"required"`
+}
+
+// SyncWithRequest starts an anti-entropy sync with a peer
+type SyncWithRequest struct {
+	Peer   string `json:"peer" binding:"required"`
+	Mode   string `json:"mode"`    // pull, push or both (default)
+	APIKey string `json:"api_key"` // Default: -peer-api-key
+}
+
+// SyncWithResult reports an anti-entropy sync
+type SyncWithResult struct {
+	Peer      string `json:"peer"`
+	Mode      string `json:"mode"`
+	InSync    bool   `json:"in_sync"`   // The trees matched before any transfer
+	Rounds    int    `json:"rounds"`    // Digest requests sent to the peer
+	Buckets   int    `json:"buckets"`   // Differing leaves compared key by key
+	Differing int    `json:"differing"` // Keys present on both with different values
+	Pulled    int    `json:"pulled"`
+	Pushed    int    `json:"pushed"`
+	Duration  string `json:"duration"`
+}
+
+func toSyncEntries(entries map[string]*storage.Entry) map[string]SyncEntry {
+	out := make(map[string]SyncEntry, len(entries))
+	for key, entry := range entries {
+		out[key] = SyncEntry{Value: entry.Value, Timestamp: entry.Timestamp, TTL: entry.TTL, Metadata: entry.Metadata, Sliding: entry.Sliding}
+	}
+	return out
+}
+
+func fromSyncEntries(entries map[string]SyncEntry) map[string]*storage.Entry {
+	out := make(map[string]*storage.Entry, len(entries))
+	for key, entry := range entries {
+		out[key] = &storage.Entry{Value: entry.Value, Timestamp: entry.Timestamp, TTL: entry.TTL, Metadata: entry.Metadata, Sliding: entry.Sliding}
+	}
+	return out
+}
+
+// merkleDigests answers a MerkleRequest from a tree
+func merkleDigests(tree *storage.MerkleTree, nodes []string) (*MerkleResponse, error) {
+	resp := &MerkleResponse{}
+	for _, node := range nodes {
+		if len(node) == storage.MerkleDepth {
+			keys, err := tree.Leaf(node)
+			if err != nil {
+				return nil, err
+			}
+			if resp.Leaves == nil {
+				resp.Leaves = make(map[string]map[string]string)
+			}
+			resp.Leaves[node] = keys
+			continue
+		}
+
+		children, err := tree.Children(node)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Nodes == nil {
+			resp.Nodes = make(map[string][]string)
+		}
+		resp.Nodes[node] = children
+	}
+	return resp, nil
+}
+
+// handleMerkle returns digests of the keyspace for a peer comparing its own
+func handleMerkle(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		var req MerkleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		resp, err := merkleDigests(store.MerkleTree(), req.Nodes)
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// handleGetEntries returns the entries of keys with their timestamps; keys
+// that do not exist are left out
+func handleGetEntries(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		var req EntriesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": toSyncEntries(store.Entries(req.Keys))})
+	}
+}
+
+// handlePutEntries applies entries from a peer, resolving those that differ
+// from local ones by the conflict policy
+func handlePutEntries(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		var req PutEntriesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		applied, err := store.PutEntries(fromSyncEntries(req.Entries))
+		if err != nil {
+			respondStoreErrorDetails(c, err, gin.H{"applied": applied})
+			return
+		}
+		if applied == nil {
+			applied = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{"applied": applied})
+	}
+}
+
+// handleSyncWith reconciles the store with a peer by anti-entropy
+func handleSyncWith(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		var req SyncWithRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		if req.Mode == "" {
+			req.Mode = "both"
+		}
+		if req.Mode != "pull" && req.Mode != "push" && req.Mode != "both" {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid mode %q: use pull, push or both", req.Mode))
+			return
+		}
+		if req.APIKey == "" {
+			req.APIKey = *PeerAPIKey
+		}
+
+		peer := &antiEntropyPeer{
+			url:    strings.TrimRight(req.Peer, "/"),
+			apiKey: req.APIKey,
+			tenant: c.GetHeader("X-Tenant"),
+			client: &http.Client{Timeout: *PeerTimeout},
+		}
+		result, err := syncWith(c.Request.Context(), store, peer, req.Mode)
+		if errors.Is(err, errPeerFailed) {
+			respondErrorDetails(c, http.StatusBadGateway, CodeUpstreamFailed, err.Error(), result)
+			return
+		}
+		if err != nil {
+			respondStoreErrorDetails(c, err, result)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// startSyncWith runs an anti-entropy sync with peer every interval
+func startSyncWith(store *storage.Store, peerURL, apiKey string, timeout, interval time.Duration) {
+	peer := &antiEntropyPeer{
+		url:    strings.TrimRight(peerURL, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: timeout},
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			result, err := syncWith(context.Background(), store, peer, "both")
+			if err != nil {
+				log.Printf("Anti-entropy sync with %s failed: %v", peer.url, err)
+				continue
+			}
+			if !result.InSync {
+				log.Printf("Anti-entropy sync with %s: pulled %d, pushed %d", peer.url, result.Pulled, result.Pushed)
+			}
+		}
+	}()
+}
+
+// antiEntropyPeer is the server at the other end of an anti-entropy sync
+type antiEntropyPeer struct {
+	url    string
+	apiKey string
+	tenant string
+	client *http.Client
+}
+
+func (p *antiEntropyPeer) do(ctx context.Context, method, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.url+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPeerFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("X-API-Key", p.apiKey)
+	}
+	if p.tenant != "" {
+		req.Header.Set("X-Tenant", p.tenant)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPeerFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Message != "" {
+			return fmt.Errorf("%w: %s %s: status %d: %s", errPeerFailed, method, path, resp.StatusCode, e.Message)
+		}
+		return fmt.Errorf("%w: %s %s: status %d", errPeerFailed, method, path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: %s %s: %v", errPeerFailed, method, path, err)
+	}
+	return nil
+}
+
+// digests fetches the digests below nodes in batches
+func (p *antiEntropyPeer) digests(ctx context.Context, nodes []string, result *SyncWithResult) (*MerkleResponse, error) {
+	merged := &MerkleResponse{Nodes: make(map[string][]string), Leaves: make(map[string]map[string]string)}
+	for start := 0; start < len(nodes); start += antiEntropyBatch {
+		end := min(start+antiEntropyBatch, len(nodes))
+		var resp MerkleResponse
+		if err := p.do(ctx, http.MethodPost, "/admin/merkle", MerkleRequest{Nodes: nodes[start:end]}, &resp); err != nil {
+			return nil, err
+		}
+		result.Rounds++
+		for node, children := range resp.Nodes {
+			merged.Nodes[node] = children
+		}
+		for leaf, keys := range resp.Leaves {
+			merged.Leaves[leaf] = keys
+		}
+	}
+	return merged, nil
+}
+
+// pull fetches the peer's entries of keys and applies them locally
+func (p *antiEntropyPeer) pull(ctx context.Context, store *storage.Store, keys []string) (int, error) {
+	var pulled int
+	for start := 0; start < len(keys); start += antiEntropyBatch {
+		end := min(start+antiEntropyBatch, len(keys))
+		var resp struct {
+			Entries map[string]SyncEntry `json:"entries"`
+		}
+		if err := p.do(ctx, http.MethodPost, "/admin/entries", EntriesRequest{Keys: keys[start:end]}, &resp); err != nil {
+			return pulled, err
+		}
+		applied, err := store.PutEntries(fromSyncEntries(resp.Entries))
+		pulled += len(applied)
+		if err != nil {
+			return pulled, err
+		}
+	}
+	return pulled, nil
+}
+
+// push sends the local entries of keys to the peer, which keeps those newer
+// than its own
+func (p *antiEntropyPeer) push(ctx context.Context, store *storage.Store, keys []string) (int, error) {
+	var pushed int
+	for start := 0; start < len(keys); start += antiEntropyBatch {
+		end := min(start+antiEntropyBatch, len(keys))
+		entries := store.Entries(keys[start:end])
+		if len(entries) == 0 {
+			continue
+		}
+		var resp struct {
+			Applied []string `json:"applied"`
+		}
+		if err := p.do(ctx, http.MethodPut, "/admin/entries", PutEntriesRequest{Entries: toSyncEntries(entries)}, &resp); err != nil {
+			return pushed, err
+		}
+		pushed += len(resp.Applied)
+	}
+	return pushed, nil
+}
+
+// syncWith reconciles store with peer. Both sides build a Merkle tree of
+// their live entries; the trees are walked down from the root one level per
+// round, descending only into subtrees whose digests differ, and the keys of
+// the differing leaves are compared by entry digest. Only the entries that
+// differ are transferred: in pull mode keys missing or different locally
+// are fetched, in push mode keys missing or different on the peer are sent,
+// and both sides keep the newer entry of a key present on both.
+func syncWith(ctx context.Context, store *storage.Store, peer *antiEntropyPeer, mode string) (*SyncWithResult, error) {
+	start := time.Now()
+	result := &SyncWithResult{Peer: peer.url, Mode: mode}
+	defer func() {
+		result.Duration = time.Since(start).String()
+	}()
+
+	tree := store.MerkleTree()
+
+	// Walk the inner levels, keeping the nodes whose children differ
+	level := []string{""}
+	for depth := 0; depth < storage.MerkleDepth && len(level) > 0; depth++ {
+		remote, err := peer.digests(ctx, level, result)
+		if err != nil {
+			return result, err
+		}
+
+		var next []string
+		for _, node := range level {
+			local, _ := tree.Children(node)
+			theirs := remote.Nodes[node]
+			if len(theirs) != len(local) {
+				return result, fmt.Errorf("%w: peer returned %d digests for node %q", errPeerFailed, len(theirs), node)
+			}
+			for i := range local {
+				if local[i] != theirs[i] {
+					next = append(next, node+strconv.FormatInt(int64(i), 16))
+				}
+			}
+		}
+		level = next
+	}
+	if len(level) == 0 {
+		result.InSync = true
+		return result, nil
+	}
+
+	// Compare the differing leaves key by key
+	remote, err := peer.digests(ctx, level, result)
+	if err != nil {
+		return result, err
+	}
+	result.Buckets = len(level)
+
+	var pullKeys, pushKeys []string
+	for _, leaf := range level {
+		local, _ := tree.Leaf(leaf)
+		theirs := remote.Leaves[leaf]
+		for key, digest := range theirs {
+			if mine, ok := local[key]; !ok {
+				pullKeys = append(pullKeys, key)
+			} else if mine != digest {
+				result.Differing++
+				pullKeys = append(pullKeys, key)
+				pushKeys = append(pushKeys, key)
+			}
+		}
+		for key := range local {
+			if _, ok := theirs[key]; !ok {
+				pushKeys = append(pushKeys, key)
+			}
+		}
+	}
+	sort.Strings(pullKeys)
+	sort.Strings(pushKeys)
+
+	// Pull first, so a differing key pushed afterwards is the winner of
+	// both copies and the peer only takes it if its own copy lost
+	if mode != "push" {
+		if result.Pulled, err = peer.pull(ctx, store, pullKeys); err != nil {
+			return result, err
+		}
+	}
+	if mode != "pull" {
+		if result.Pushed, err = peer.push(ctx, store, pushKeys); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// hasTechnique reports whether a document lists technique or one of its sub-techniques
+func hasTechnique(value interface{}, technique string) bool {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	techniques, _ := m[storage.AttackField].([]interface{})
+	for _, t := range techniques {
+		if id, ok := t.(string); ok && (id == technique || strings.HasPrefix(id, technique+".")) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleAttackSearch(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		technique := strings.ToUpper(c.Param("technique"))
+		if !storage.IsAttackTechnique(technique) {
+			respondError(c, 400, CodeInvalidRequest, "invalid ATT&CK technique id")
+			return
+		}
+
+		maxResults, _ := strconv.Atoi(c.Query("max_results"))
+
+		results, err := store.SearchReadable(storage.SearchQuery{
+			Text:       technique,
+			TextFields: []string{storage.AttackField},
+		}, readableKeys(c))
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		// Trigram matching is fuzzy; keep only documents tagged with the technique
+		matched := results[:0]
+		for _, result := range results {
+			if hasTechnique(result.Value, technique) {
+				matched = append(matched, result)
+			}
+		}
+		if maxResults > 0 && len(matched) > maxResults {
+			matched = matched[:maxResults]
+		}
+
+		c.JSON(200, redactResults(c, matched))
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// BenchANNReport is the result of the bench-ann command: the recall and
+// speed of every configuration of a vector index searching the queries
+type BenchANNReport struct {
+	Vectors    int              `json:"vectors" yaml:"vectors"`
+	Dimensions int              `json:"dimensions" yaml:"dimensions"`
+	Queries    int              `json:"queries" yaml:"queries"`
+	K          int              `json:"k" yaml:"k"`
+	Results    []BenchANNResult `json:"results" yaml:"results"`
+}
+
+// BenchANNResult is how one configuration of a vector index searched the
+// queries. Lists and probes are 0 when every vector is compared.
+type BenchANNResult struct {
+	Lists     int     `json:"lists" yaml:"lists"`
+	Probes    int     `json:"probes" yaml:"probes"`
+	Recall    float64 `json:"recall" yaml:"recall"`         // Mean fraction of the true k nearest found
+	QPS       float64 `json:"qps" yaml:"qps"`               // Queries searched per second, one at a time
+	Latency   float64 `json:"latency_ms" yaml:"latency_ms"` // Mean time of a query
+	Compared  float64 `json:"compared" yaml:"compared"`     // Mean vectors compared per query
+	TrainTime float64 `json:"train_ms" yaml:"train_ms"`     // Of the centroids of the lists
+}
+
+func (r BenchANNReport) table() ([]string, [][]string) {
+	rows := make([][]string, len(r.Results))
+	for i, result := range r.Results {
+		lists, probes := "-", "-"
+		if result.Lists > 0 {
+			lists, probes = strconv.Itoa(result.Lists), strconv.Itoa(result.Probes)
+		}
+		rows[i] = []string{lists, probes, strconv.FormatFloat(result.Recall, 'f', 4, 64),
+			strconv.FormatFloat(result.QPS, 'f', 0, 64), formatMillis(result.Latency),
+			strconv.FormatFloat(result.Compared, 'f', 0, 64), formatMillis(result.TrainTime)}
+	}
+	return []string{"LISTS", "PROBES", "RECALL", "QPS", "LATENCY", "COMPARED", "TRAIN"}, rows
+}
+
+// runBenchANN implements the bench-ann command, which searches the queries
+// of a dataset with vector indexes partitioned in different ways and
+// reports the recall@k and speed of each against the true nearest
+// neighbors, to choose the lists and probes of POST /index/:field/optimize
+func runBenchANN(args []string) error {
+	fs := flag.NewFlagSet("bench-ann", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench-ann [flags] -vectors base.fvecs\n\n"+
+			"Measure the recall@k and queries per second of vector indexes partitioned into each\n"+
+			"number of -lists and searching each number of -probes of them. Vector files are\n"+
+			".fvecs or JSON lines of arrays, ground truth files .ivecs or JSON lines of arrays\n"+
+			"of the positions of the nearest vectors in -vectors, nearest first.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	vectorsFile := fs.String("vectors", "", "Vectors to index")
+	queriesFile := fs.String("queries", "", "Query vectors (default: -sample vectors held out of -vectors)")
+	truthFile := fs.String("truth", "", "True nearest neighbors of the queries (default: computed by comparing every vector)")
+	writeTruth := fs.String("write-truth", "", "Write the computed true nearest neighbors to this file, to pass as -truth later")
+	sample := fs.Int("sample", storage.DefaultOptimizeSample, "Vectors held out as queries without -queries")
+	k := fs.Int("k", storage.DefaultOptimizeK, "Nearest neighbors searched and compared for recall")
+	listsFlag := fs.String("lists", "", "Comma-separated numbers of lists to partition the index into (default: the square root of the vectors)")
+	probesFlag := fs.String("probes", "1,2,4,8,16,32", "Comma-separated numbers of lists searched per query, up to the lists")
+	seed := fs.Int64("seed", 1, "Seed of the sample held out as queries")
+	output := outputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	switch {
+	case *vectorsFile == "":
+		fs.Usage()
+		return invalidInput(errors.New("give the vectors to index with -vectors"))
+	case *k < 1:
+		return invalidInput(fmt.Errorf("k must be positive, not %d", *k))
+	case *sample < 1 && *queriesFile == "":
+		return invalidInput(fmt.Errorf("sample must be positive, not %d", *sample))
+	case *truthFile != "" && *queriesFile == "":
+		return invalidInput(errors.New("-truth needs the -queries it was computed for"))
+	case *truthFile != "" && *writeTruth != "":
+		return invalidInput(errors.New("-write-truth writes computed truth, which -truth replaces"))
+	}
+	lists, err := parseCounts("lists", *listsFlag)
+	if err != nil {
+		return err
+	}
+	probes, err := parseCounts("probes", *probesFlag)
+	if err != nil {
+		return err
+	}
+
+	vectors, err := readVectors(*vectorsFile)
+	if err != nil {
+		return err
+	}
+	if len(vectors) == 0 {
+		return invalidInput(fmt.Errorf("%s holds no vectors", *vectorsFile))
+	}
+	indexed := make([]int, len(vectors)) // Positions in vectors of the indexed vectors
+	for i := range indexed {
+		indexed[i] = i
+	}
+	var queries [][]float32
+	if *queriesFile != "" {
+		if queries, err = readVectors(*queriesFile); err != nil {
+			return err
+		}
+	} else {
+		rng := rand.New(rand.NewSource(*seed))
+		rng.Shuffle(len(indexed), func(i, j int) { indexed[i], indexed[j] = indexed[j], indexed[i] })
+		held := indexed[:min(*sample, len(indexed)/2)]
+		for _, i := range held {
+			queries = append(queries, vectors[i])
+		}
+		indexed = indexed[len(held):]
+		sort.Ints(indexed)
+	}
+	if len(queries) == 0 {
+		return invalidInput(errors.New("no queries to search"))
+	}
+
+	idx := storage.NewVectorIndex(len(vectors[0]))
+	for _, i := range indexed {
+		if err := idx.Update(strconv.Itoa(i), vectors[i]); err != nil {
+			return invalidInput(fmt.Errorf("%s: vector %d: %w", *vectorsFile, i+1, err))
+		}
+	}
+	for i, query := range queries {
+		if len(query) != idx.Dimensions() {
+			return invalidInput(fmt.Errorf("query %d: %w: expected %d, got %d", i+1, storage.ErrDimensionMismatch, idx.Dimensions(), len(query)))
+		}
+	}
+	log.Printf("Indexed %d vectors of %d dimensions, searching %d queries", len(indexed), idx.Dimensions(), len(queries))
+
+	report := BenchANNReport{Vectors: len(indexed), Dimensions: idx.Dimensions(), Queries: len(queries), K: *k}
+	exhaustive, found := benchQueries(idx, queries, *k)
+	report.Results = append(report.Results, exhaustive)
+
+	var truth [][]int
+	if *truthFile != "" {
+		if truth, err = readTruth(*truthFile, len(queries), *k); err != nil {
+			return err
+		}
+	} else {
+		truth = make([][]int, len(found))
+		for q, results := range found {
+			truth[q] = make([]int, len(results))
+			for i, r := range results {
+				truth[q][i], _ = strconv.Atoi(r.Key)
+			}
+		}
+		if *writeTruth != "" {
+			if err := writeTruthFile(*writeTruth, truth); err != nil {
+				return err
+			}
+		}
+	}
+	report.Results[0].Recall = recallAt(found, truth, *k)
+
+	if len(lists) == 0 {
+		lists = []int{max(1, int(math.Sqrt(float64(len(indexed)))))}
+	}
+	for _, n := range lists {
+		start := time.Now()
+		if _, err := idx.Partition(context.Background(), n, 1, nil); err != nil {
+			return invalidInput(fmt.Errorf("lists %d: %w", n, err))
+		}
+		trainTime := milliseconds(time.Since(start))
+		log.Printf("Trained %d lists in %s ms", n, formatMillis(trainTime))
+		for _, p := range probes {
+			if p > n {
+				continue
+			}
+			if err := idx.SetProbes(p); err != nil {
+				return err
+			}
+			result, found := benchQueries(idx, queries, *k)
+			result.Lists, result.Probes, result.TrainTime = n, p, trainTime
+			result.Recall = recallAt(found, truth, *k)
+			report.Results = append(report.Results, result)
+		}
+	}
+
+	return printResult(os.Stdout, *output, report, func() {
+		fmt.Printf("Searched %d queries for the %d nearest of %d vectors of %d dimensions\n",
+			report.Queries, report.K, report.Vectors, report.Dimensions)
+		printResult(os.Stdout, outputTable, report, nil)
+	})
+}
+
+// parseCounts parses a comma-separated list of positive numbers
+func parseCounts(name, list string) ([]int, error) {
+	var counts []int
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			return nil, invalidInput(fmt.Errorf("%s: %q is not a positive number", name, field))
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// benchQueries searches the queries one at a time and returns their speed
+// and the results found
+func benchQueries(idx *storage.VectorIndex, queries [][]float32, k int) (BenchANNResult, [][]storage.VectorSearchResult) {
+	found := make([][]storage.VectorSearchResult, len(queries))
+	compared := 0
+	start := time.Now()
+	for q, query := range queries {
+		results, n, _ := idx.SearchCompared(query, k)
+		found[q] = results
+		compared += n
+	}
+	elapsed := time.Since(start)
+	n := float64(len(queries))
+	return BenchANNResult{
+		QPS:      n / max(elapsed.Seconds(), 1e-9),
+		Latency:  milliseconds(elapsed) / n,
+		Compared: float64(compared) / n,
+	}, found
+}
+
+// recallAt returns the mean fraction of the true k nearest neighbors of
+// each query found among its results
+func recallAt(found [][]storage.VectorSearchResult, truth [][]int, k int) float64 {
+	recall := 0.0
+	for q, results := range found {
+		want := make(map[string]struct{}, k)
+		for _, i := range truth[q][:min(k, len(truth[q]))] {
+			want[strconv.Itoa(i)] = struct{}{}
+		}
+		if len(want) == 0 {
+			recall++
+			continue
+		}
+		hits := 0
+		for _, r := range results {
+			if _, ok := want[r.Key]; ok {
+				hits++
+			}
+		}
+		recall += float64(hits) / float64(len(want))
+	}
+	return recall / float64(len(found))
+}
+
+// readVectors reads a .fvecs file, in which each vector is its dimensions
+// as a little-endian int32 followed by as many float32, or JSON lines of
+// arrays of numbers
+func readVectors(path string) ([][]float32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var vectors [][]float32
+	if strings.HasSuffix(path, ".fvecs") {
+		r := bufio.NewReader(file)
+		for {
+			var dim int32
+			if err := binary.Read(r, binary.LittleEndian, &dim); errors.Is(err, io.EOF) {
+				return vectors, nil
+			} else if err != nil {
+				return nil, err
+			}
+			if dim < 1 || dim > 1<<16 {
+				return nil, invalidInput(fmt.Errorf("%s: vector %d: %d dimensions", path, len(vectors)+1, dim))
+			}
+			vector := make([]float32, dim)
+			if err := binary.Read(r, binary.LittleEndian, vector); err != nil {
+				return nil, invalidInput(fmt.Errorf("%s: vector %d: %v", path, len(vectors)+1, err))
+			}
+			vectors = append(vectors, vector)
+		}
+	}
+	err = readJSONLines(file, func(n int, line []byte) error {
+		var vector []float32
+		if err := json.Unmarshal(line, &vector); err != nil {
+			return invalidInput(fmt.Errorf("%s:%d: %v", path, n, err))
+		}
+		vectors = append(vectors, vector)
+		return nil
+	})
+	return vectors, err
+}
+
+// readTruth reads the true nearest neighbors of each query from a .ivecs
+// file, laid out like .fvecs with int32 positions, or JSON lines of arrays
+// of positions. Each query needs at least k of them.
+func readTruth(path string, queries, k int) ([][]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var truth [][]int
+	if strings.HasSuffix(path, ".ivecs") {
+		r := bufio.NewReader(file)
+		for {
+			var n int32
+			if err := binary.Read(r, binary.LittleEndian, &n); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if n < 0 || n > 1<<16 {
+				return nil, invalidInput(fmt.Errorf("%s: query %d: %d neighbors", path, len(truth)+1, n))
+			}
+			positions := make([]int32, n)
+			if err := binary.Read(r, binary.LittleEndian, positions); err != nil {
+				return nil, invalidInput(fmt.Errorf("%s: query %d: %v", path, len(truth)+1, err))
+			}
+			neighbors := make([]int, n)
+			for i, p := range positions {
+				neighbors[i] = int(p)
+			}
+			truth = append(truth, neighbors)
+		}
+	} else {
+		err = readJSONLines(file, func(n int, line []byte) error {
+			var neighbors []int
+			if err := json.Unmarshal(line, &neighbors); err != nil {
+				return invalidInput(fmt.Errorf("%s:%d: %v", path, n, err))
+			}
+			truth = append(truth, neighbors)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(truth) != queries {
+		return nil, invalidInput(fmt.Errorf("%s holds the neighbors of %d queries, not %d", path, len(truth), queries))
+	}
+	for q, neighbors := range truth {
+		if len(neighbors) < k {
+			return nil, invalidInput(fmt.Errorf("%s: query %d has %d neighbors, fewer than k = %d", path, q+1, len(neighbors), k))
+		}
+	}
+	return truth, nil
+}
+
+// writeTruthFile writes the true nearest neighbors of each query as .ivecs
+// or, for other extensions, JSON lines
+func writeTruthFile(path string, truth [][]int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, neighbors := range truth {
+		if strings.HasSuffix(path, ".ivecs") {
+			positions := make([]int32, 0, len(neighbors)+1)
+			positions = append(positions, int32(len(neighbors)))
+			for _, n := range neighbors {
+				positions = append(positions, int32(n))
+			}
+			binary.Write(w, binary.LittleEndian, positions)
+			continue
+		}
+		line, _ := json.Marshal(neighbors)
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readJSONLines calls fn with each line of r that is not blank
+func readJSONLines(r io.Reader, fn func(n int, line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), int(storage.DefaultDecodeLimits.MaxSize))
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
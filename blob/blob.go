@@ -0,0 +1,154 @@
+// Package blob stores large binary attachments outside the data file, in a
+// local directory or an S3 bucket.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for blobs that do not exist
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs by name. Names are slash-separated paths without empty,
+// "." or ".." segments.
+type Store interface {
+	// Put stores the content of r as name, replacing any blob of that name.
+	// size is the content length, or -1 if unknown.
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	// Open returns the content of name
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Delete removes name; removing a missing blob is not an error
+	Delete(ctx context.Context, name string) error
+	// List returns the blobs whose names start with prefix
+	List(ctx context.Context, prefix string) ([]Info, error)
+}
+
+// Info describes a stored blob
+type Info struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// CheckName returns an error for names a Store does not accept
+func CheckName(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid blob name: empty")
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid blob name %q", name)
+		}
+	}
+	return nil
+}
+
+// Dir stores blobs as files below a local directory
+type Dir struct {
+	root string
+}
+
+// NewDir returns a Store keeping blobs below root, creating it if needed
+func NewDir(root string) (*Dir, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &Dir{root: root}, nil
+}
+
+func (d *Dir) path(name string) (string, error) {
+	if err := CheckName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(d.root, filepath.FromSlash(name)), nil
+}
+
+// Put writes the blob to a temporary file and renames it into place, so
+// readers never see a partial blob
+func (d *Dir) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d *Dir) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return f, err
+}
+
+func (d *Dir) Delete(ctx context.Context, name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks the directory, skipping uploads in progress
+func (d *Dir) List(ctx context.Context, prefix string) ([]Info, error) {
+	var blobs []Info
+	err := filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, Info{Name: name, Size: info.Size(), Modified: info.ModTime()})
+		return ctx.Err()
+	})
+	return blobs, err
+}
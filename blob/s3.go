@@ -0,0 +1,326 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// unsignedPayload signs requests without hashing their body, which S3
+// accepts over HTTPS and lets uploads stream
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3 stores blobs in an S3-compatible bucket, addressed path-style as
+// endpoint/bucket/name so it works with MinIO and other compatible servers
+type S3 struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// NewS3 returns a Store keeping blobs in bucket at endpoint, such as
+// https://s3.us-east-1.amazonaws.com, signing requests with the given
+// credentials
+func NewS3(endpoint, bucket, region, accessKey, secretKey string) (*S3, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+	return &S3{
+		endpoint:  u,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{},
+	}, nil
+}
+
+// ParseS3URL splits an s3 location such as https://minio:9000/bucket into
+// its endpoint and bucket
+func ParseS3URL(location string) (endpoint, bucket string, err error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("invalid S3 location %q: use https://host/bucket", location)
+	}
+	bucket = strings.Trim(u.Path, "/")
+	if bucket == "" || strings.Contains(bucket, "/") {
+		return "", "", fmt.Errorf("invalid S3 location %q: use https://host/bucket", location)
+	}
+	u.Path = ""
+	return u.String(), bucket, nil
+}
+
+// NewS3FromEnv is NewS3 with the credentials of AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY
+func NewS3FromEnv(endpoint, bucket, region string) (*S3, error) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("S3 blob storage needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return NewS3(endpoint, bucket, region, accessKey, secretKey)
+}
+
+// Put uploads the blob in a single request. S3 needs the content length up
+// front, so content of unknown size is spooled to a temporary file first.
+func (s *S3) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	if size < 0 {
+		tmp, err := os.CreateTemp("", "blob-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if size, err = io.Copy(tmp, r); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = tmp
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, name, nil, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, name, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, name, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listResult is the part of a ListObjectsV2 response List reads
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2
+func (s *S3) List(ctx context.Context, prefix string) ([]Info, error) {
+	var blobs []Info
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode S3 listing: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			blobs = append(blobs, Info{Name: object.Key, Size: object.Size, Modified: object.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return blobs, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends a signed request for an object, or for the bucket when name is
+// empty, and returns successful responses
+func (s *S3) do(ctx context.Context, method, name string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket
+	if name != "" {
+		u.Path += "/" + name
+	}
+	u.RawPath = uriEncode(u.Path, false) // Sent as signed
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound && name != "":
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %s %s: %s: %s", method, u.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// signedHeaders are the headers signed besides host and the x-amz-*
+// headers, when a request has them
+var signedHeaders = map[string]bool{"content-md5": true, "content-type": true, "date": true, "range": true}
+
+// sign adds an AWS Signature Version 4 Authorization header to req. The
+// payload is unsigned unless X-Amz-Content-Sha256 already holds its hash.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	date := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", timestamp)
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || signedHeaders[name] {
+			// Values are trimmed, with inner runs of spaces collapsed
+			for i, v := range values {
+				values[i] = strings.Join(strings.Fields(v), " ")
+			}
+			headers[name] = strings.Join(values, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedNames := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedNames,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		timestamp,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedNames, signature))
+}
+
+// canonicalQuery encodes the parameters sorted by name and value, with
+// empty values kept as name=
+func canonicalQuery(query url.Values) string {
+	var params [][2]string
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, [2]string{uriEncode(name, true), uriEncode(v, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	encoded := make([]string, len(params))
+	for i, param := range params {
+		encoded[i] = param[0] + "=" + param[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// uriEncode percent-encodes every byte but the unreserved characters of
+// RFC 3986 and, in paths, the slashes, as Signature Version 4 requires
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~',
+			c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
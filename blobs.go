@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/blob"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// blobSuffix is the last path segment addressing the blob of the key
+// before it, e.g. /data/reports/q3.pdf/_blob
+const blobSuffix = "/_blob"
+
+// Fields of a document describing its blob. They are plain fields, so they
+// can be indexed and searched like any other.
+const (
+	blobIDField          = "blob_id"
+	blobSizeField        = "blob_size"
+	blobSHA256Field      = "blob_sha256"
+	blobContentTypeField = "blob_content_type"
+)
+
+// defaultBlobGCAge is the age below which unreferenced blobs are kept, as
+// their upload may still be in progress
+const defaultBlobGCAge = time.Hour
+
+// errNotADocument is returned when a blob is attached to a key whose value
+// is not a map
+var errNotADocument = errors.New("value is not a map")
+
+// BlobInfo describes the blob of a key
+type BlobInfo struct {
+	ID          string `json:"blob_id"`
+	Size        int64  `json:"blob_size"`
+	SHA256      string `json:"blob_sha256"`
+	ContentType string `json:"blob_content_type"`
+}
+
+// BlobGCResult is the body of POST /admin/blobs/gc
+type BlobGCResult struct {
+	Scanned    int   `json:"scanned"`
+	Deleted    int   `json:"deleted"`
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// openBlobStore returns the blob store configured by -blob-dir or -blob-s3,
+// or nil when neither is set
+func openBlobStore() (blob.Store, error) {
+	switch {
+	case *BlobDir != "" && *BlobS3 != "":
+		return nil, fmt.Errorf("set either -blob-dir or -blob-s3")
+	case *BlobDir != "":
+		return blob.NewDir(*BlobDir)
+	case *BlobS3 != "":
+		endpoint, bucket, err := blob.ParseS3URL(*BlobS3)
+		if err != nil {
+			return nil, err
+		}
+		return blob.NewS3FromEnv(endpoint, bucket, *BlobS3Region)
+	}
+	return nil, nil
+}
+
+// blobName is the name of a blob in the blob store. Blobs are kept apart
+// by tenant.
+func blobName(c *gin.Context, id string) string {
+	return tenantName(c) + "/" + id
+}
+
+// documentBlob returns the blob a document describes
+func documentBlob(value interface{}) (BlobInfo, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return BlobInfo{}, false
+	}
+	id, ok := m[blobIDField].(string)
+	if !ok || id == "" {
+		return BlobInfo{}, false
+	}
+	info := BlobInfo{ID: id}
+	info.SHA256, _ = m[blobSHA256Field].(string)
+	info.ContentType, _ = m[blobContentTypeField].(string)
+	if size, ok := m[blobSizeField].(int); ok {
+		info.Size = int64(size)
+	} else if size, ok := m[blobSizeField].(float64); ok {
+		info.Size = int64(size)
+	}
+	return info, true
+}
+
+// withBlob returns a copy of a document describing info, or with no blob
+// when info is nil
+func withBlob(value interface{}, info *BlobInfo) (interface{}, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok && value != nil {
+		return nil, errNotADocument
+	}
+
+	doc := make(map[string]interface{}, len(m)+4)
+	for k, v := range m {
+		doc[k] = v
+	}
+	if info == nil {
+		delete(doc, blobIDField)
+		delete(doc, blobSizeField)
+		delete(doc, blobSHA256Field)
+		delete(doc, blobContentTypeField)
+		return doc, nil
+	}
+	doc[blobIDField] = info.ID
+	doc[blobSizeField] = int(info.Size)
+	doc[blobSHA256Field] = info.SHA256
+	doc[blobContentTypeField] = info.ContentType
+	return doc, nil
+}
+
+// handleBlob serves /data/:key/_blob paths and passes others to next
+func handleBlob(store *storage.Store, blobs blob.Store, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := strings.CutSuffix(dataKey(c), blobSuffix)
+		if !ok {
+			next(c)
+			return
+		}
+		if blobs == nil {
+			respondError(c, 404, CodeNotFound, "blob storage not enabled")
+			return
+		}
+		if isDirectory(key) {
+			respondError(c, 400, CodeInvalidKey, "invalid key: directories have no blobs")
+			return
+		}
+
+		store := tenantStore(c, store)
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			readBlob(c, store, blobs, key)
+		case http.MethodPost:
+			writeBlob(c, store, blobs, key)
+		case http.MethodDelete:
+			deleteBlob(c, store, key)
+		}
+	}
+}
+
+// readBlob streams the blob of key with its content type, length and
+// SHA-256 as ETag
+func readBlob(c *gin.Context, store *storage.Store, blobs blob.Store, key string) {
+	if !canRead(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	entry, exists := store.Get(key)
+	if !exists {
+		respondError(c, 404, CodeNotFound, "key not found")
+		return
+	}
+	info, ok := documentBlob(entry.Value)
+	if !ok {
+		respondError(c, 404, CodeNotFound, "key has no blob")
+		return
+	}
+
+	c.Header("Content-Type", info.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	c.Header("ETag", `"`+info.SHA256+`"`)
+	if c.Request.Method == http.MethodHead {
+		c.Status(200)
+		return
+	}
+
+	r, err := blobs.Open(c.Request.Context(), blobName(c, info.ID))
+	if errors.Is(err, blob.ErrNotFound) {
+		respondError(c, 404, CodeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(c, 502, CodeUpstreamFailed, fmt.Sprintf("failed to read blob: %v", err))
+		return
+	}
+	defer r.Close()
+
+	c.Status(200)
+	io.Copy(c.Writer, r) // The client may go away mid-stream
+}
+
+// writeBlob streams the request body to the blob store and points the
+// document of key at it, creating the key if needed. The blob it replaces
+// is left to POST /admin/blobs/gc, as copies of the key may still use it.
+func writeBlob(c *gin.Context, store *storage.Store, blobs blob.Store, key string) {
+	if !canWrite(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	if !checkWritableKey(c, key) {
+		return
+	}
+	if store.ReadOnly() {
+		respondStoreError(c, storage.ErrReadOnly)
+		return
+	}
+
+	var id [16]byte
+	rand.Read(id[:])
+	info := BlobInfo{ID: hex.EncodeToString(id[:]), ContentType: c.ContentType()}
+	if info.ContentType == "" {
+		info.ContentType = "application/octet-stream"
+	}
+
+	body := c.Request.Body
+	if *MaxBlobSize > 0 {
+		body = http.MaxBytesReader(c.Writer, body, *MaxBlobSize)
+	}
+	hash := sha256.New()
+	counter := &countingWriter{}
+	name := blobName(c, info.ID)
+	err := blobs.Put(c.Request.Context(), name, io.TeeReader(body, io.MultiWriter(hash, counter)), c.Request.ContentLength)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		blobs.Delete(c.Request.Context(), name)
+		respondError(c, 413, CodeTooLarge, fmt.Sprintf("blob exceeds %d bytes", *MaxBlobSize))
+		return
+	}
+	if err != nil {
+		blobs.Delete(c.Request.Context(), name)
+		respondError(c, 502, CodeUpstreamFailed, fmt.Sprintf("failed to store blob: %v", err))
+		return
+	}
+	info.Size = counter.n
+	info.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	err = store.Update(key, func(key string, value interface{}) (interface{}, error) {
+		return withBlob(value, &info)
+	})
+	if err != nil {
+		blobs.Delete(c.Request.Context(), name)
+		if errors.Is(err, errNotADocument) {
+			respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("cannot attach a blob to %s: %v", key, err))
+			return
+		}
+		respondStoreError(c, err)
+		return
+	}
+	c.JSON(200, info)
+}
+
+// deleteBlob removes the blob from the document of key. The blob itself is
+// removed by POST /admin/blobs/gc.
+func deleteBlob(c *gin.Context, store *storage.Store, key string) {
+	if !canWrite(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	if !checkWritableKey(c, key) {
+		return
+	}
+
+	var found bool
+	err := store.Update(key, func(key string, value interface{}) (interface{}, error) {
+		if _, found = documentBlob(value); !found {
+			return nil, storage.ErrSkipUpdate
+		}
+		return withBlob(value, nil)
+	})
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	if !found {
+		respondError(c, 404, CodeNotFound, "key has no blob")
+		return
+	}
+	c.JSON(200, gin.H{"status": "ok"})
+}
+
+// blobGCError is a failure of the blob store during a collection
+type blobGCError struct {
+	msg string
+}
+
+func (e *blobGCError) Error() string {
+	return e.msg
+}
+
+// collectBlobs deletes the blobs under prefix no document of store refers
+// to, once they are older than age. It stops between deletions once ctx is
+// cancelled, returning what it deleted so far.
+func collectBlobs(ctx context.Context, store *storage.Store, blobs blob.Store, prefix string, age time.Duration, progress func(done, total int)) (BlobGCResult, error) {
+	// List before collecting references, so a blob uploaded in between
+	// is either not listed or already referenced
+	listed, err := blobs.List(ctx, prefix)
+	if err != nil {
+		return BlobGCResult{}, &blobGCError{fmt.Sprintf("failed to list blobs: %v", err)}
+	}
+	referenced := make(map[string]struct{})
+	store.Range(func(key string, entry *storage.Entry) bool {
+		if info, ok := documentBlob(entry.Value); ok {
+			referenced[info.ID] = struct{}{}
+		}
+		return true
+	})
+
+	result := BlobGCResult{Scanned: len(listed)}
+	cutoff := time.Now().Add(-age)
+	for i, b := range listed {
+		progress(i, len(listed))
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if _, ok := referenced[strings.TrimPrefix(b.Name, prefix)]; ok || b.Modified.After(cutoff) {
+			continue
+		}
+		if err := blobs.Delete(ctx, b.Name); err != nil {
+			return result, &blobGCError{fmt.Sprintf("failed to delete blob: %v", err)}
+		}
+		result.Deleted++
+		result.FreedBytes += b.Size
+	}
+	progress(len(listed), len(listed))
+	return result, nil
+}
+
+// handleBlobGC deletes the blobs of the tenant no document refers to any
+// more, once they are older than older_than (default 1h). With async=true
+// it runs as a job, which can be cancelled between deletions.
+func handleBlobGC(store *storage.Store, blobs blob.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		if blobs == nil {
+			respondError(c, 404, CodeNotFound, "blob storage not enabled")
+			return
+		}
+		age := defaultBlobGCAge
+		if v := c.Query("older_than"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				respondError(c, 400, CodeInvalidRequest, "older_than must be a duration such as 1h")
+				return
+			}
+			age = d
+		}
+		prefix := blobName(c, "")
+
+		if runAsync(c) {
+			job := store.StartJob(storage.JobBlobGC, nil, func(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+				return collectBlobs(ctx, store, blobs, prefix, age, progress)
+			})
+			respondJobStarted(c, job)
+			return
+		}
+		result, err := collectBlobs(c.Request.Context(), store, blobs, prefix, age, func(done, total int) {})
+		if err != nil {
+			var gcErr *blobGCError
+			if errors.As(err, &gcErr) {
+				respondErrorDetails(c, 502, CodeUpstreamFailed, gcErr.msg, result)
+			} else {
+				respondStoreError(c, err)
+			}
+			return
+		}
+		c.JSON(200, result)
+	}
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
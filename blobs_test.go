@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/blob"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// TestBlobGC collects the blobs no document refers to, in the request and
+// as a job
+func TestBlobGC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := storage.NewMemStore()
+	t.Cleanup(func() { store.Close() })
+	blobs, err := blob.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/admin/blobs/gc", handleBlobGC(store, blobs))
+
+	ctx := context.Background()
+	put := func(ids ...string) {
+		for _, id := range ids {
+			if err := blobs.Put(ctx, DefaultTenant+"/"+id, strings.NewReader("content"), 7); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := store.Set("reports/q3", map[string]interface{}{blobIDField: "kept"}); err != nil {
+		t.Fatal(err)
+	}
+	gc := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/admin/blobs/gc?older_than=0s"+query, nil))
+		return w
+	}
+
+	put("kept", "orphan1", "orphan2")
+	w := gc("")
+	var result BlobGCResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("collection: %d %s", w.Code, w.Body)
+	}
+	if want := (BlobGCResult{Scanned: 3, Deleted: 2, FreedBytes: 14}); result != want {
+		t.Errorf("collection = %+v, want %+v", result, want)
+	}
+
+	put("orphan3")
+	w = gc("&async=true")
+	var started struct{ Job string }
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("async collection: %d %s", w.Code, w.Body)
+	}
+	job, err := store.WaitJob(ctx, started.Job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Kind != storage.JobBlobGC || job.State != storage.JobDone || job.Done != 2 || job.Total != 2 {
+		t.Errorf("job = %+v, want a done blob_gc job of 2 blobs", job)
+	}
+	if want := (BlobGCResult{Scanned: 2, Deleted: 1, FreedBytes: 7}); job.Result != want {
+		t.Errorf("job result = %+v, want %+v", job.Result, want)
+	}
+
+	listed, err := blobs.List(ctx, DefaultTenant+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Name != DefaultTenant+"/kept" {
+		t.Errorf("blobs left = %+v, want only the referenced one", listed)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/pipeline"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// Keys under /data naming bulk endpoints rather than entries. Gin cannot
+// route static paths next to /data/*key, so handleDataPost dispatches them,
+// and these keys cannot be written through the API.
+const (
+	deleteByQueryAction = "_delete_by_query"
+	updateByQueryAction = "_update_by_query"
+)
+
+// dataActions lists the bulk endpoints under /data
+var dataActions = []string{deleteByQueryAction, updateByQueryAction}
+
+// DeleteByQueryResponse is the body of POST /data/_delete_by_query
+type DeleteByQueryResponse struct {
+	Status  string `json:"status"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+	Matched int    `json:"matched"`
+	Deleted int    `json:"deleted"`
+}
+
+// UpdateByQueryRequest is the body of POST /data/_update_by_query. Patch is
+// a JSON merge patch (RFC 7386): its fields replace those of each document,
+// and null fields remove them. With both, the pipeline runs on the patched
+// document.
+type UpdateByQueryRequest struct {
+	Query    storage.SearchQuery    `json:"query"`
+	Patch    map[string]interface{} `json:"patch,omitempty"`
+	Pipeline string                 `json:"pipeline,omitempty"`
+}
+
+// keyDeniedError aborts a bulk operation on a key the request may not write
+type keyDeniedError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *keyDeniedError) Error() string {
+	return e.msg
+}
+
+// bulkKeyCheck returns a check failing with a keyDeniedError for the keys
+// the request may not write. It holds on to the principal rather than the
+// request, so jobs may call it after the request ends.
+func bulkKeyCheck(c *gin.Context) func(key string) error {
+	p := principalFrom(c)
+	return func(key string) error {
+		if p != nil && !p.CanWrite(key) {
+			return &keyDeniedError{403, CodeForbidden, fmt.Sprintf("access denied to %s", key)}
+		}
+		if prefix, reserved := reservedKeyPrefix(key); reserved {
+			return &keyDeniedError{400, CodeInvalidKey, fmt.Sprintf("invalid key %s: prefix %q is reserved", key, prefix)}
+		}
+		return nil
+	}
+}
+
+// respondBulkError responds with the error of a bulk operation
+func respondBulkError(c *gin.Context, err error) {
+	var denied *keyDeniedError
+	if errors.As(err, &denied) {
+		respondError(c, denied.status, denied.code, denied.msg)
+		return
+	}
+	respondStoreError(c, err)
+}
+
+// handleDataPost serves POST /data/*key: the bulk endpoints at their
+// reserved keys, copies and renames of keys and writes of every other key
+func handleDataPost(store *storage.Store, pipelines *Pipelines, chunker *Chunker) gin.HandlerFunc {
+	actions := map[string]gin.HandlerFunc{
+		deleteByQueryAction: handleDeleteByQuery(store),
+		updateByQueryAction: handleUpdateByQuery(store, pipelines),
+	}
+	set := handleSet(store, pipelines, chunker)
+	return func(c *gin.Context) {
+		path := dataKey(c)
+		if action, ok := actions[path]; ok {
+			action(c)
+			return
+		}
+		if key, move, ok := keyAction(path); ok {
+			copyKey(c, tenantStore(c, store), key, move)
+			return
+		}
+		set(c)
+	}
+}
+
+// handleDeleteByQuery deletes every key matching a search query at once.
+// The request is rejected as a whole when it may not delete any one of the
+// matches. With dry_run=true it only counts them, and with async=true it
+// runs as a job.
+func handleDeleteByQuery(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var query storage.SearchQuery
+		if err := c.ShouldBindJSON(&query); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		dryRun := c.Query("dry_run") == "true"
+		check := bulkKeyCheck(c)
+
+		deleteByQuery := func() (DeleteByQueryResponse, error) {
+			keys, err := store.DeleteByQuery(query, check, dryRun)
+			if err != nil {
+				return DeleteByQueryResponse{}, err
+			}
+			resp := DeleteByQueryResponse{Status: "ok", DryRun: dryRun, Matched: len(keys)}
+			if !dryRun {
+				resp.Deleted = len(keys)
+			}
+			return resp, nil
+		}
+
+		if runAsync(c) {
+			job := store.StartJob(storage.JobDeleteByQuery, nil, func(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+				return deleteByQuery()
+			})
+			respondJobStarted(c, job)
+			return
+		}
+		resp, err := deleteByQuery()
+		if err != nil {
+			respondBulkError(c, err)
+			return
+		}
+		c.JSON(200, resp)
+	}
+}
+
+// handleUpdateByQuery applies a merge patch, an ingest pipeline or both to
+// every document matching a search query. The request is rejected as a
+// whole when it may not write any one of the matches. With progress=true
+// the response is a stream of server-sent "progress" events, one per batch
+// of storage.UpdateBatchSize documents, ending with a "done" event. With
+// async=true it runs as a job, which can be cancelled between batches.
+func handleUpdateByQuery(store *storage.Store, pipelines *Pipelines) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var request UpdateByQueryRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		if request.Patch == nil && request.Pipeline == "" {
+			respondError(c, 400, CodeInvalidRequest, "set a patch, a pipeline or both")
+			return
+		}
+
+		var p *pipeline.Pipeline
+		if request.Pipeline != "" {
+			var exists bool
+			if p, exists = pipelines.Get(request.Pipeline); !exists {
+				respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("unknown pipeline: %s", request.Pipeline))
+				return
+			}
+		}
+		update := func(key string, value interface{}) (interface{}, error) {
+			if request.Patch != nil {
+				value = mergePatch(value, request.Patch)
+			}
+			if p == nil {
+				return value, nil
+			}
+			value, err := p.Run(value)
+			if errors.Is(err, pipeline.ErrDropped) {
+				return nil, storage.ErrSkipUpdate
+			}
+			return value, err
+		}
+		check := bulkKeyCheck(c)
+
+		if runAsync(c) {
+			job := store.StartJob(storage.JobUpdateByQuery, nil, func(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+				return store.UpdateByQuery(ctx, request.Query, check, update, func(p storage.UpdateProgress) {
+					progress(p.Processed, p.Matched)
+				})
+			})
+			respondJobStarted(c, job)
+			return
+		}
+		if c.Query("progress") != "true" {
+			result, err := store.UpdateByQuery(context.Background(), request.Query, check, update, nil)
+			if err != nil {
+				respondBulkError(c, err)
+				return
+			}
+			c.JSON(200, result)
+			return
+		}
+
+		// Batches run in the background and are reported as they finish.
+		// The update completes even if the client goes away.
+		events := make(chan storage.UpdateProgress)
+		done := c.Request.Context().Done()
+		var result storage.UpdateProgress
+		var err error
+		go func() {
+			defer close(events)
+			result, err = store.UpdateByQuery(context.Background(), request.Query, check, update, func(p storage.UpdateProgress) {
+				select {
+				case events <- p:
+				case <-done:
+				}
+			})
+		}()
+		c.Stream(func(w io.Writer) bool {
+			if progress, ok := <-events; ok {
+				c.SSEvent("progress", progress)
+				return true
+			}
+			if err != nil {
+				c.SSEvent("error", gin.H{"error": err.Error()})
+				return false
+			}
+			c.SSEvent("done", result)
+			return false
+		})
+	}
+}
+
+// mergePatch returns target with a JSON merge patch (RFC 7386) applied,
+// leaving target unchanged
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	fields, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = nil
+	}
+
+	merged := make(map[string]interface{}, len(doc)+len(fields))
+	for k, v := range doc {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = mergePatch(merged[k], v)
+	}
+	return merged
+}
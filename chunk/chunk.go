@@ -0,0 +1,162 @@
+// Package chunk splits long text into overlapping chunks, the passages RAG
+// pipelines retrieve and embed instead of whole documents, and ranks chunks
+// by how many terms of a query they contain.
+package chunk
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Chunk is a passage of a text
+type Chunk struct {
+	Index int    `json:"index"`
+	Text  string `json:"text"`
+	Start int    `json:"start"` // Byte offsets of the passage in the text
+	End   int    `json:"end"`
+}
+
+// Options are the size of chunks and their overlap, in bytes
+type Options struct {
+	Size    int `json:"size" yaml:"size"`
+	Overlap int `json:"overlap" yaml:"overlap"`
+}
+
+// Validate checks that chunks have a size and overlap less than it
+func (o Options) Validate() error {
+	if o.Size <= 0 {
+		return fmt.Errorf("chunk size must be positive")
+	}
+	if o.Overlap < 0 || o.Overlap >= o.Size {
+		return fmt.Errorf("chunk overlap must be at least 0 and less than the size %d", o.Size)
+	}
+	return nil
+}
+
+// Split splits text into chunks of at most o.Size bytes, each repeating
+// about o.Overlap bytes of the end of the one before. Chunks end at
+// whitespace where there is any in their second half, so words are not cut,
+// and never inside a UTF-8 sequence. Text of at most o.Size bytes is one
+// chunk. Leading and trailing whitespace is trimmed from chunks.
+func Split(text string, o Options) []Chunk {
+	var chunks []Chunk
+	for start := skipSpace(text, 0); start < len(text); {
+		end := len(text)
+		if end-start > o.Size {
+			end = cutPoint(text, start, start+o.Size)
+		}
+		if c := trimmed(text, start, end); c.Text != "" {
+			c.Index = len(chunks)
+			chunks = append(chunks, c)
+		}
+		if end == len(text) {
+			break
+		}
+
+		next := end
+		if o.Overlap > 0 {
+			// Begin the overlap at a word, but after start so chunks advance
+			next = max(end-o.Overlap, start+1)
+			for next < end && !utf8.RuneStart(text[next]) {
+				next++
+			}
+			if w := wordStart(text, next, end); w < end {
+				next = w
+			}
+		}
+		start = skipSpace(text, next)
+	}
+	return chunks
+}
+
+// cutPoint returns where a chunk from start to at most limit ends: after the
+// last whitespace in its second half, or at the last rune boundary
+func cutPoint(text string, start, limit int) int {
+	for i := limit; i > start+(limit-start)/2; i-- {
+		if isSpaceByte(text[i-1]) {
+			return i
+		}
+	}
+	for i := limit; i > start+1; i-- {
+		if utf8.RuneStart(text[i]) {
+			return i
+		}
+	}
+	return limit
+}
+
+// wordStart returns the first position from i to end that begins a word
+// after whitespace, or end
+func wordStart(text string, i, end int) int {
+	if i == 0 || isSpaceByte(text[i-1]) {
+		return i
+	}
+	for ; i < end; i++ {
+		if isSpaceByte(text[i-1]) && !isSpaceByte(text[i]) {
+			return i
+		}
+	}
+	return end
+}
+
+func skipSpace(text string, i int) int {
+	for i < len(text) && isSpaceByte(text[i]) {
+		i++
+	}
+	return i
+}
+
+func isSpaceByte(b byte) bool {
+	return b < utf8.RuneSelf && unicode.IsSpace(rune(b))
+}
+
+// trimmed returns the chunk of text from start to end without surrounding
+// whitespace
+func trimmed(text string, start, end int) Chunk {
+	for start < end && isSpaceByte(text[start]) {
+		start++
+	}
+	for end > start && isSpaceByte(text[end-1]) {
+		end--
+	}
+	return Chunk{Text: text[start:end], Start: start, End: end}
+}
+
+// Scored is a chunk with the share of a query's terms it contains
+type Scored struct {
+	Chunk
+	Score float64 `json:"score"`
+}
+
+// Rank scores chunks by the share of the distinct query terms each contains,
+// as tokenize splits both, and returns the best n, best first and in text
+// order among equal scores. Without query terms every chunk scores 0, so
+// the first n are returned.
+func Rank(chunks []Chunk, query string, tokenize func(string) []string, n int) []Scored {
+	terms := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		terms[term] = true
+	}
+
+	scored := make([]Scored, len(chunks))
+	for i, c := range chunks {
+		scored[i] = Scored{Chunk: c}
+		if len(terms) == 0 {
+			continue
+		}
+		found := make(map[string]bool)
+		for _, term := range tokenize(c.Text) {
+			if terms[term] {
+				found[term] = true
+			}
+		}
+		scored[i].Score = float64(len(found)) / float64(len(terms))
+	}
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].Score > scored[j].Score })
+	if n > 0 && len(scored) > n {
+		scored = scored[:n]
+	}
+	return scored
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/chunk"
+	"github.com/threatflux/searchyaml/embedding"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// Default chunking of writes naming only a chunk_field, in bytes
+const (
+	defaultChunkSize    = 1000
+	defaultChunkOverlap = 200
+)
+
+// Chunker splits a long text field of documents written with chunk_field
+// into chunk entries, keyed parent#0, parent#1 and so on, so searches match
+// passages rather than whole documents. Chunks carry their passage in the
+// same field, and their embedding in the embedding field when an embedder is
+// configured, for text and vector indexes on those fields.
+type Chunker struct {
+	embedder embedding.Embedder // Nil stores chunks without embeddings
+	field    string             // Field of chunks holding their embedding
+	timeout  time.Duration
+}
+
+// NewChunker returns a chunker embedding chunks with the embedder of spec,
+// see embedding.New, or not embedding them when spec is empty
+func NewChunker(spec string, opts embedding.Options, field string) (*Chunker, error) {
+	ch := &Chunker{field: field, timeout: opts.Timeout}
+	if spec != "" {
+		embedder, err := embedding.New(spec, opts)
+		if err != nil {
+			return nil, err
+		}
+		ch.embedder = embedder
+	}
+	return ch, nil
+}
+
+// chunkRequest is the chunking a write asks for
+type chunkRequest struct {
+	field string
+	opts  chunk.Options
+}
+
+// requestedChunking returns the chunking of the chunk_field, chunk_size and
+// chunk_overlap query parameters, or nil without chunk_field
+func requestedChunking(c *gin.Context) (*chunkRequest, error) {
+	field := c.Query("chunk_field")
+	if field == "" {
+		return nil, nil
+	}
+	req := &chunkRequest{field: field, opts: chunk.Options{Size: defaultChunkSize, Overlap: defaultChunkOverlap}}
+	if size := c.Query("chunk_size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk_size: %q", size)
+		}
+		req.opts.Size = n
+		// A smaller size than the default overlap keeps its proportion
+		if req.opts.Overlap >= n {
+			req.opts.Overlap = n / 5
+		}
+	}
+	if overlap := c.Query("chunk_overlap"); overlap != "" {
+		n, err := strconv.Atoi(overlap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk_overlap: %q", overlap)
+		}
+		req.opts.Overlap = n
+	}
+	if err := req.opts.Validate(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// text returns the text of a document to split
+func (req *chunkRequest) text(value interface{}) (string, error) {
+	m, _ := value.(map[string]interface{})
+	text, ok := m[req.field].(string)
+	if !ok {
+		return "", fmt.Errorf("chunk_field %s must be a string field of the document", req.field)
+	}
+	return text, nil
+}
+
+// split returns the chunk documents of the document of parent, embedded
+// when the chunker has an embedder. Embedding errors are errEmbedding.
+func (ch *Chunker) split(ctx context.Context, parent string, value interface{}, req *chunkRequest) ([]map[string]interface{}, error) {
+	text, err := req.text(value)
+	if err != nil {
+		return nil, err
+	}
+	chunks := chunk.Split(text, req.opts)
+
+	var vectors [][]float32
+	if ch.embedder != nil && len(chunks) > 0 {
+		texts := make([]string, len(chunks))
+		for i, c := range chunks {
+			texts[i] = c.Text
+		}
+		if ch.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, ch.timeout)
+			defer cancel()
+		}
+		if vectors, err = ch.embedder.Embed(ctx, texts); err != nil {
+			return nil, fmt.Errorf("%w: %v", errEmbedding, err)
+		}
+	}
+
+	docs := make([]map[string]interface{}, len(chunks))
+	for i, c := range chunks {
+		docs[i] = map[string]interface{}{
+			storage.ChunkField: map[string]interface{}{
+				"parent": parent,
+				"index":  c.Index,
+				"field":  req.field,
+				"start":  c.Start,
+				"end":    c.End,
+			},
+			req.field: c.Text,
+		}
+		if vectors != nil {
+			docs[i][ch.field] = vectors[i]
+		}
+	}
+	return docs, nil
+}
+
+// errEmbedding is returned when the embedder fails
+var errEmbedding = errors.New("failed to embed chunks")
+
+// writeChunks stores the chunk documents of parent, with the TTL and
+// metadata of the parent, naming it as their parent, and deletes chunks
+// left from a longer version of it
+func writeChunks(store *storage.Store, parent string, docs []map[string]interface{}, ttl time.Duration, meta *storage.Metadata) error {
+	chunkMeta := *meta
+	chunkMeta.Parent = parent
+	stale := store.ChunkKeys(parent)
+	for i, doc := range docs {
+		if err := store.SetWithMetadata(storage.ChunkKey(parent, i), doc, ttl, &chunkMeta); err != nil {
+			return err
+		}
+	}
+	for _, key := range stale[min(len(docs), len(stale)):] {
+		store.Delete(key)
+	}
+	return nil
+}
+
+// deleteChunks deletes the chunks of parent, as when it is deleted or
+// written again without chunking
+func deleteChunks(store *storage.Store, parent string) {
+	for _, key := range store.ChunkKeys(parent) {
+		store.Delete(key)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/threatflux/searchyaml/client"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Exit codes of the commands, so scripts can tell failures apart
+const (
+	exitError      = 1 // Any other failure
+	exitValidation = 2 // Invalid flags, arguments or input, as flag parsing exits
+	exitNotFound   = 3 // A file, key or other resource does not exist
+	exitServer     = 4 // The server failed or could not be reached
+)
+
+// Output formats of command results, chosen with -output
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+var outputFormats = []string{outputText, outputJSON, outputYAML, outputTable}
+
+// shells are the shells `searchyaml completion` writes scripts for
+var shells = []string{"bash", "zsh", "fish"}
+
+// invalidInputError is a command failing on its flags, arguments or input
+// rather than along the way
+type invalidInputError struct {
+	err error
+}
+
+func (e *invalidInputError) Error() string { return e.err.Error() }
+func (e *invalidInputError) Unwrap() error { return e.err }
+
+// invalidInput marks err as caused by the command line or its input
+func invalidInput(err error) error {
+	return &invalidInputError{err}
+}
+
+// exitCode returns the exit code of a command failing with err
+func exitCode(err error) int {
+	var invalid *invalidInputError
+	var apiErr *client.Error
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &invalid):
+		return exitValidation
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, storage.ErrNotFound):
+		return exitNotFound
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.StatusCode == 404:
+			return exitNotFound
+		case apiErr.StatusCode == 400, apiErr.StatusCode == 413, apiErr.StatusCode == 422:
+			return exitValidation
+		case apiErr.StatusCode >= 500:
+			return exitServer
+		}
+	case errors.As(err, &urlErr):
+		return exitServer // No response at all
+	case errors.Is(err, storage.ErrInvalidQuery), errors.Is(err, storage.ErrInvalidKey),
+		errors.Is(err, storage.ErrUnsupportedFormat), errors.Is(err, storage.ErrDecodeLimit):
+		return exitValidation
+	}
+	return exitError
+}
+
+// outputFlag adds -output to the flags of a command
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", outputText, "Output format: text, json, yaml or table")
+}
+
+// checkOutput rejects unknown output formats
+func checkOutput(format string) error {
+	for _, f := range outputFormats {
+		if format == f {
+			return nil
+		}
+	}
+	return invalidInput(fmt.Errorf("unknown output format %q: use %s", format, strings.Join(outputFormats, ", ")))
+}
+
+// tabular is a command result that can be printed as a table
+type tabular interface {
+	table() (header []string, rows [][]string)
+}
+
+// printResult prints the result of a command to w in format. The text
+// format, for people rather than scripts, is printed by text.
+func printResult(w io.Writer, format string, result tabular, text func()) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+		return enc.Close()
+	case outputTable:
+		header, rows := result.table()
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+	text()
+	return nil
+}
+
+// completingFlags, when set, receives the flags of the command being
+// completed instead of parsing them
+var completingFlags func(fs *flag.FlagSet)
+
+// errCompleting stops a command whose flags were taken for completion
+var errCompleting = errors.New("completing")
+
+// parseFlags parses the flags of a command. While completing, it hands the
+// flags to the completion instead and returns errCompleting, so the command
+// stops before doing anything.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if completingFlags != nil {
+		completingFlags(fs)
+		return errCompleting
+	}
+	return fs.Parse(args)
+}
+
+// The completion commands complete the others, so they join commands when
+// it is initialized
+func init() {
+	commands["completion"] = runCompletion
+	commands["__complete"] = runComplete
+}
+
+// runCompletion implements the completion command, which writes a shell
+// script completing the commands and flags of searchyaml
+func runCompletion(args []string) error {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s completion bash|zsh|fish\n\nWrite a shell completion script to stdout, for example:\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "  source <(%s completion bash)\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "  %s completion fish > ~/.config/fish/completions/searchyaml.fish\n", os.Args[0])
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return invalidInput(fmt.Errorf("give one shell: %s", strings.Join(shells, ", ")))
+	}
+
+	name := filepath.Base(os.Args[0])
+	var script string
+	switch fs.Arg(0) {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return invalidInput(fmt.Errorf("unknown shell %q: use %s", fs.Arg(0), strings.Join(shells, ", ")))
+	}
+	_, err := io.WriteString(os.Stdout, strings.ReplaceAll(script, "searchyaml", name))
+	return err
+}
+
+// The completion scripts ask `searchyaml __complete <words>` for the
+// candidates of the last word, so they follow the commands and flags of the
+// installed binary. Without candidates, such as for the value of -data,
+// they complete file names.
+const bashCompletion = `_searchyaml() {
+	local IFS=$'\n'
+	COMPREPLY=($("${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:$COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _searchyaml searchyaml
+`
+
+const zshCompletion = `#compdef searchyaml
+_searchyaml() {
+	local -a candidates
+	candidates=("${(@f)$("${words[1]}" __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if (( ${#candidates} )) && [[ -n ${candidates[1]} ]]; then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _searchyaml searchyaml
+`
+
+const fishCompletion = `function __searchyaml_complete
+	set -l words (commandline -opc) (commandline -ct)
+	$words[1] __complete $words[2..-1] 2>/dev/null
+end
+complete -c searchyaml -a '(__searchyaml_complete)'
+`
+
+// runComplete prints the candidates of the last of args, the words after
+// the program name, one per line
+func runComplete(args []string) error {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	current, previous := args[len(args)-1], args[:len(args)-1]
+
+	var candidates []string
+	fs := flag.CommandLine // The server's
+	if len(previous) > 0 {
+		if _, ok := commands[previous[0]]; ok {
+			fs = commandFlags(previous[0])
+			if previous[0] == "completion" && len(previous) == 1 {
+				candidates = shells
+			}
+		}
+	} else if !strings.HasPrefix(current, "-") {
+		for name := range commands {
+			if !strings.HasPrefix(name, "__") {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+
+	if last := ""; len(previous) > 0 {
+		last = previous[len(previous)-1]
+		if f := fs.Lookup(strings.TrimLeft(last, "-")); f != nil && strings.HasPrefix(last, "-") && !isBoolFlag(f) {
+			// The value of the flag
+			candidates = nil
+			if f.Name == "output" {
+				candidates = outputFormats
+			}
+			printCandidates(candidates, current)
+			return nil
+		}
+	}
+	if strings.HasPrefix(current, "-") {
+		dashes := "-"
+		if strings.HasPrefix(current, "--") {
+			dashes = "--"
+		}
+		fs.VisitAll(func(f *flag.Flag) {
+			candidates = append(candidates, dashes+f.Name)
+		})
+	}
+	printCandidates(candidates, current)
+	return nil
+}
+
+// commandFlags returns the flags of a command, without running it
+func commandFlags(name string) *flag.FlagSet {
+	var flags *flag.FlagSet
+	completingFlags = func(fs *flag.FlagSet) { flags = fs }
+	defer func() { completingFlags = nil }()
+	commands[name]([]string{})
+	if flags == nil {
+		return flag.NewFlagSet(name, flag.ContinueOnError)
+	}
+	return flags
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func printCandidates(candidates []string, prefix string) {
+	candidates = append([]string(nil), candidates...)
+	sort.Strings(candidates)
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			fmt.Println(c)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -24,9 +25,15 @@ func NewClient(baseURL string) *Client {
 	}
 }
 
+// dataURL returns the URL of a key. Keys are percent-encoded, so keys holding
+// slashes or other reserved characters address a single entry.
+func (c *Client) dataURL(key string) string {
+	return fmt.Sprintf("%s/data/%s", c.baseURL, url.PathEscape(key))
+}
+
 // Set stores a value with an optional TTL
 func (c *Client) Set(key string, value interface{}, ttl time.Duration) error {
-	url := fmt.Sprintf("%s/data/%s", c.baseURL, key)
+	url := c.dataURL(key)
 	body, err := json.Marshal(value)
 	if err != nil {
 		return err
@@ -49,7 +56,7 @@ func (c *Client) Set(key string, value interface{}, ttl time.Duration) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return responseError(resp)
 	}
 
 	return nil
@@ -57,7 +64,7 @@ func (c *Client) Set(key string, value interface{}, ttl time.Duration) error {
 
 // Get retrieves a value by key
 func (c *Client) Get(key string) (interface{}, error) {
-	url := fmt.Sprintf("%s/data/%s", c.baseURL, key)
+	url := c.dataURL(key)
 	resp, err := c.httpClient.Get(url)
 	if err != nil {
 		return nil, err
@@ -69,7 +76,7 @@ func (c *Client) Get(key string) (interface{}, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, responseError(resp)
 	}
 
 	var result map[string]interface{}
@@ -82,7 +89,7 @@ func (c *Client) Get(key string) (interface{}, error) {
 
 // Delete removes a value by key
 func (c *Client) Delete(key string) error {
-	url := fmt.Sprintf("%s/data/%s", c.baseURL, key)
+	url := c.dataURL(key)
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
 		return err
@@ -95,7 +102,7 @@ func (c *Client) Delete(key string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return responseError(resp)
 	}
 
 	return nil
@@ -131,6 +138,39 @@ func (c *Client) CombinedSearch(query SearchQuery) ([]SearchResult, error) {
 	return c.search(url, query)
 }
 
+// ExplainSearch performs a combined search and returns a breakdown of how it
+// was executed along with the results
+func (c *Client) ExplainSearch(query SearchQuery) ([]SearchResult, *Explanation, error) {
+	url := fmt.Sprintf("%s/search/combined", c.baseURL)
+	jsonBody, err := json.Marshal(struct {
+		SearchQuery
+		Explain bool `json:"explain"`
+	}{query, true})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := c.httpClient.Post(url, "application/json", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, responseError(resp)
+	}
+
+	var response struct {
+		Results []SearchResult `json:"results"`
+		Explain *Explanation   `json:"explain"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, nil, err
+	}
+
+	return response.Results, response.Explain, nil
+}
+
 // Helper function for search requests
 func (c *Client) search(url string, body interface{}) ([]SearchResult, error) {
 	jsonBody, err := json.Marshal(body)
@@ -145,7 +185,7 @@ func (c *Client) search(url string, body interface{}) ([]SearchResult, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, responseError(resp)
 	}
 
 	var results []SearchResult
@@ -160,16 +200,44 @@ func (c *Client) search(url string, body interface{}) ([]SearchResult, error) {
 
 type SearchQuery struct {
 	Text       string                 `json:"text,omitempty"`
+	TextFields []string               `json:"text_fields,omitempty"`
 	Vector     []float32              `json:"vector,omitempty"`
 	Filters    map[string]interface{} `json:"filters,omitempty"`
+	Prefixes   map[string]string      `json:"prefixes,omitempty"`
 	MaxResults int                    `json:"max_results,omitempty"`
 	MinScore   float64                `json:"min_score,omitempty"`
+	Expr       string                 `json:"expr,omitempty"`
+	Fields     map[string]string      `json:"fields,omitempty"`
+	Sample     int                    `json:"sample,omitempty"`
+
+	ReturnValues *bool `json:"return_values,omitempty"`
 }
 
 type SearchResult struct {
-	Key       string      `json:"key"`
-	Value     interface{} `json:"value"`
-	TextScore float64     `json:"text_score,omitempty"`
-	VecScore  float32     `json:"vector_score,omitempty"`
-	Combined  float64     `json:"combined_score"`
+	Key       string                 `json:"key"`
+	Value     interface{}            `json:"value,omitempty"`
+	TextScore float64                `json:"text_score,omitempty"`
+	VecScore  float32                `json:"vector_score,omitempty"`
+	Combined  float64                `json:"combined_score"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Source    string                 `json:"source,omitempty"`
+}
+
+type QueryStage struct {
+	Stage      string         `json:"stage"`
+	Index      string         `json:"index,omitempty"`
+	Duration   float64        `json:"duration"`
+	Results    int            `json:"results"`
+	Trigrams   int            `json:"trigrams,omitempty"`
+	IOCs       int            `json:"iocs,omitempty"`
+	Postings   int            `json:"postings,omitempty"`
+	Matched    int            `json:"matched,omitempty"`
+	Compared   int            `json:"compared,omitempty"`
+	Candidates map[string]int `json:"candidates,omitempty"`
+}
+
+type Explanation struct {
+	Duration float64      `json:"duration"`
+	Stages   []QueryStage `json:"stages"`
+	Results  int          `json:"results"`
 }
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Errors matched by *Error through errors.Is, one per server error code
+var (
+	ErrInvalidRequest    = errors.New("invalid request")
+	ErrInvalidQuery      = errors.New("invalid query")
+	ErrInvalidKey        = errors.New("invalid key")
+	ErrInvalidTTL        = errors.New("invalid TTL")
+	ErrDimensionMismatch = errors.New("vector dimension mismatch")
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrForbidden         = errors.New("forbidden")
+	ErrReadOnly          = errors.New("server is read-only")
+	ErrNotFound          = errors.New("not found")
+	ErrKeyExists         = errors.New("key exists")
+	ErrTooLarge          = errors.New("request too large")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrQuotaExceeded     = errors.New("quota exceeded")
+	ErrStoreFull         = errors.New("store is full")
+)
+
+var errorCodes = map[string]error{
+	"invalid_request":    ErrInvalidRequest,
+	"invalid_query":      ErrInvalidQuery,
+	"invalid_key":        ErrInvalidKey,
+	"invalid_ttl":        ErrInvalidTTL,
+	"dimension_mismatch": ErrDimensionMismatch,
+	"coercion_failed":    ErrInvalidRequest,
+	"indexing_failed":    ErrInvalidRequest,
+	"unauthorized":       ErrUnauthorized,
+	"forbidden":          ErrForbidden,
+	"read_only":          ErrReadOnly,
+	"not_found":          ErrNotFound,
+	"key_exists":         ErrKeyExists,
+	"too_large":          ErrTooLarge,
+	"rate_limited":       ErrRateLimited,
+	"quota_exceeded":     ErrQuotaExceeded,
+	"store_full":         ErrStoreFull,
+}
+
+// Error is an error response from the server
+type Error struct {
+	StatusCode int             `json:"-"`
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// Is matches the sentinel error for the error code, so callers can test
+// errors.Is(err, client.ErrNotFound)
+func (e *Error) Is(target error) bool {
+	err, ok := errorCodes[e.Code]
+	return ok && err == target
+}
+
+// responseError reads the error response of a request that failed. Bodies
+// that are not an error response still produce an *Error with the status.
+func responseError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err == nil {
+		json.Unmarshal(body, apiErr)
+	}
+	return apiErr
+}
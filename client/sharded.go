@@ -0,0 +1,186 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// virtualNodes is the number of points each server has on the hash ring;
+// more points spread keys more evenly
+const virtualNodes = 160
+
+// ShardedClient spreads keys across several SearchYAML servers by consistent
+// hashing and fans searches out to all of them. Adding or removing a server
+// moves only the keys of the ring segments it gains or loses, about 1/n of
+// them.
+type ShardedClient struct {
+	shards []*Client
+	ring   []ringPoint // Sorted by hash
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+// NewShardedClient creates a client for the servers at baseURLs. The ring
+// depends only on the URLs, so clients created with the same URLs in any
+// order route keys the same way.
+func NewShardedClient(baseURLs []string) (*ShardedClient, error) {
+	if len(baseURLs) == 0 {
+		return nil, errors.New("no servers")
+	}
+
+	c := &ShardedClient{}
+	seen := make(map[string]bool, len(baseURLs))
+	for i, url := range baseURLs {
+		if seen[url] {
+			return nil, fmt.Errorf("duplicate server %s", url)
+		}
+		seen[url] = true
+
+		c.shards = append(c.shards, NewClient(url))
+		for v := 0; v < virtualNodes; v++ {
+			c.ring = append(c.ring, ringPoint{hash: hashKey(url + "#" + strconv.Itoa(v)), shard: i})
+		}
+	}
+	sort.Slice(c.ring, func(i, j int) bool {
+		if c.ring[i].hash != c.ring[j].hash {
+			return c.ring[i].hash < c.ring[j].hash
+		}
+		return c.shards[c.ring[i].shard].baseURL < c.shards[c.ring[j].shard].baseURL
+	})
+	return c, nil
+}
+
+// hashKey hashes with FNV-1a and a finalizer that spreads similar strings,
+// such as keys differing only in a trailing number, across the ring
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// shard returns the client of the server owning key: the first ring point
+// at or after the key's hash
+func (c *ShardedClient) shard(key string) *Client {
+	h := hashKey(key)
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.shards[c.ring[i].shard]
+}
+
+// ServerFor returns the base URL of the server owning key
+func (c *ShardedClient) ServerFor(key string) string {
+	return c.shard(key).baseURL
+}
+
+// Set stores a value on the server owning key
+func (c *ShardedClient) Set(key string, value interface{}, ttl time.Duration) error {
+	return c.shard(key).Set(key, value, ttl)
+}
+
+// Get retrieves a value from the server owning key
+func (c *ShardedClient) Get(key string) (interface{}, error) {
+	return c.shard(key).Get(key)
+}
+
+// Delete removes a value from the server owning key
+func (c *ShardedClient) Delete(key string) error {
+	return c.shard(key).Delete(key)
+}
+
+// TextSearch runs a text search on every server and merges the results
+func (c *ShardedClient) TextSearch(text string, maxResults int, minScore float64) ([]SearchResult, error) {
+	return c.fanOut(maxResults, func(shard *Client) ([]SearchResult, error) {
+		return shard.TextSearch(text, maxResults, minScore)
+	})
+}
+
+// VectorSearch runs a vector search on every server and merges the results
+func (c *ShardedClient) VectorSearch(vector []float32, maxResults int, minScore float64) ([]SearchResult, error) {
+	return c.fanOut(maxResults, func(shard *Client) ([]SearchResult, error) {
+		return shard.VectorSearch(vector, maxResults, minScore)
+	})
+}
+
+// CombinedSearch runs a combined search on every server and merges the
+// results
+func (c *ShardedClient) CombinedSearch(query SearchQuery) ([]SearchResult, error) {
+	return c.fanOut(query.MaxResults, func(shard *Client) ([]SearchResult, error) {
+		return shard.CombinedSearch(query)
+	})
+}
+
+// fanOut runs search on every server concurrently. Each server returns its
+// own top maxResults; scores are computed per document, so they compare
+// across servers and the merged results are re-ranked by combined score and
+// cut to maxResults. A key found on several servers, as happens while keys
+// move after the server list changes, is kept once with its best score. The
+// search fails if any server fails, with the errors of every failed server.
+func (c *ShardedClient) fanOut(maxResults int, search func(shard *Client) ([]SearchResult, error)) ([]SearchResult, error) {
+	results := make([][]SearchResult, len(c.shards))
+	errs := make([]error, len(c.shards))
+
+	var wg sync.WaitGroup
+	for i, shard := range c.shards {
+		wg.Add(1)
+		go func(i int, shard *Client) {
+			defer wg.Done()
+			results[i], errs[i] = search(shard)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", shard.baseURL, errs[i])
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return mergeResults(results, maxResults), nil
+}
+
+// mergeResults merges per-server results by descending combined score,
+// keeping the best result per key, with ties broken by key so the order is
+// stable
+func mergeResults(results [][]SearchResult, maxResults int) []SearchResult {
+	best := make(map[string]int)
+	var merged []SearchResult
+	for _, shardResults := range results {
+		for _, r := range shardResults {
+			if i, ok := best[r.Key]; ok {
+				if r.Combined > merged[i].Combined {
+					merged[i] = r
+				}
+				continue
+			}
+			best[r.Key] = len(merged)
+			merged = append(merged, r)
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Combined != merged[j].Combined {
+			return merged[i].Combined > merged[j].Combined
+		}
+		return merged[i].Key < merged[j].Key
+	})
+	if maxResults > 0 && len(merged) > maxResults {
+		merged = merged[:maxResults]
+	}
+	return merged
+}
@@ -0,0 +1,39 @@
+#!/bin/bash
+
+# Builds the Python and TypeScript client packages for a release
+# usage: clients/build.sh <version> [output directory]
+
+set -euo pipefail
+
+VERSION=${1:?usage: $0 <version> [output directory]}
+OUT_DIR=$(realpath -m "${2:-build_clients}")
+CLIENTS_DIR=$(cd "$(dirname "$0")" && pwd)
+
+# Release tags are YYYY.MM.DD.N; packages need three-part versions without
+# leading zeros, so they use YYYY.MMDD.N
+IFS=. read -r YEAR MONTH DAY COUNTER <<< "$VERSION"
+if [ -n "${COUNTER:-}" ]; then
+    VERSION="$YEAR.$((10#$MONTH$DAY)).$COUNTER"
+fi
+
+mkdir -p "$OUT_DIR"
+echo "Building clients version $VERSION into $OUT_DIR"
+
+# Python: sdist and wheel
+PY_DIR=$(mktemp -d)
+trap 'rm -rf "$PY_DIR"' EXIT
+cp -r "$CLIENTS_DIR/python/." "$PY_DIR"
+sed -i "s/^__version__ = .*/__version__ = \"$VERSION\"/" "$PY_DIR/searchyaml/__init__.py"
+python3 -m pip wheel --no-deps --wheel-dir "$OUT_DIR" "$PY_DIR"
+(cd "$PY_DIR" && python3 -m build --sdist --outdir "$OUT_DIR")
+
+# TypeScript: npm tarball with compiled output and type declarations
+(
+    cd "$CLIENTS_DIR/typescript"
+    npm install --no-save --no-audit --no-fund
+    npm version "$VERSION" --no-git-tag-version --allow-same-version
+    npm pack --pack-destination "$OUT_DIR"
+    git checkout -- package.json 2>/dev/null || true
+)
+
+ls -l "$OUT_DIR"
@@ -0,0 +1,8 @@
+"""Python client for SearchYAML."""
+
+from .client import Client
+from .errors import *  # noqa: F401,F403
+from .errors import __all__ as _errors
+
+__all__ = ["Client"] + _errors
+__version__ = "0.0.0"
@@ -0,0 +1,85 @@
+"""SearchYAML HTTP client with the same surface as the Go client."""
+
+import json
+import urllib.error
+import urllib.parse
+import urllib.request
+
+from .errors import error_from_response
+
+__all__ = ["Client"]
+
+
+class Client:
+    """Client for a SearchYAML server.
+
+    >>> client = Client("http://localhost:8080")
+    >>> client.set("user1", {"name": "John"}, ttl="24h")
+    >>> client.get("user1")
+    {'name': 'John'}
+    """
+
+    def __init__(self, base_url, timeout=10.0, api_key=None):
+        self.base_url = base_url.rstrip("/")
+        self.timeout = timeout
+        self.api_key = api_key
+
+    def set(self, key, value, ttl=None):
+        """Store a value. ttl is a duration such as "90s" or "24h"."""
+        headers = {}
+        if ttl:
+            headers["X-TTL"] = ttl
+        self._request("POST", self._data_path(key), value, headers)
+
+    def get(self, key):
+        """Return the value of key, or None if it does not exist."""
+        status, body = self._request("GET", self._data_path(key), allow_not_found=True)
+        if status == 404:
+            return None
+        return body[key]["Value"]
+
+    def delete(self, key):
+        """Delete a key."""
+        self._request("DELETE", self._data_path(key))
+
+    def text_search(self, text, max_results=0, min_score=0.0):
+        """Full-text search."""
+        query = {"text": text, "max_results": max_results, "min_score": min_score}
+        return self._request("POST", "/search/text", query)[1]
+
+    def vector_search(self, vector, max_results=0, min_score=0.0):
+        """Vector similarity search."""
+        query = {"vector": list(vector), "max_results": max_results, "min_score": min_score}
+        return self._request("POST", "/search/vector", query)[1]
+
+    def combined_search(self, query):
+        """Search with a query dict holding any of text, text_fields, vector,
+        filters, max_results, min_score, expr and fields."""
+        return self._request("POST", "/search/combined", query)[1]
+
+    def explain_search(self, query):
+        """Run a combined search and return (results, explanation)."""
+        body = self._request("POST", "/search/combined", dict(query, explain=True))[1]
+        return body["results"], body["explain"]
+
+    def _data_path(self, key):
+        # Keys are percent-encoded, including slashes
+        return "/data/" + urllib.parse.quote(key, safe="")
+
+    def _request(self, method, path, body=None, headers=None, allow_not_found=False):
+        headers = dict(headers or {})
+        data = None
+        if body is not None:
+            data = json.dumps(body).encode("utf-8")
+            headers["Content-Type"] = "application/json"
+        if self.api_key:
+            headers["X-API-Key"] = self.api_key
+
+        request = urllib.request.Request(self.base_url + path, data=data, headers=headers, method=method)
+        try:
+            with urllib.request.urlopen(request, timeout=self.timeout) as response:
+                return response.status, json.loads(response.read() or b"null")
+        except urllib.error.HTTPError as e:
+            if allow_not_found and e.code == 404:
+                return 404, None
+            raise error_from_response(e.code, e.read()) from None
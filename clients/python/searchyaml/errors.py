@@ -0,0 +1,121 @@
+"""Exceptions for SearchYAML error responses.
+
+Every error response carries {"code", "message", "details"}; each code maps
+to an exception class so callers can catch the failures they handle.
+"""
+
+import json
+
+__all__ = [
+    "SearchYAMLError",
+    "InvalidRequestError",
+    "InvalidQueryError",
+    "InvalidKeyError",
+    "InvalidTTLError",
+    "DimensionMismatchError",
+    "UnauthorizedError",
+    "ForbiddenError",
+    "ReadOnlyError",
+    "NotFoundError",
+    "TooLargeError",
+    "RateLimitedError",
+    "QuotaExceededError",
+    "StoreFullError",
+    "error_from_response",
+]
+
+
+class SearchYAMLError(Exception):
+    """An error response from the server."""
+
+    def __init__(self, status, code, message, details=None):
+        super().__init__(f"{message} ({status} {code})")
+        self.status = status
+        self.code = code
+        self.message = message
+        self.details = details
+
+
+class InvalidRequestError(SearchYAMLError):
+    pass
+
+
+class InvalidQueryError(InvalidRequestError):
+    pass
+
+
+class InvalidKeyError(InvalidRequestError):
+    pass
+
+
+class InvalidTTLError(InvalidRequestError):
+    pass
+
+
+class DimensionMismatchError(InvalidRequestError):
+    pass
+
+
+class UnauthorizedError(SearchYAMLError):
+    pass
+
+
+class ForbiddenError(SearchYAMLError):
+    pass
+
+
+class ReadOnlyError(ForbiddenError):
+    pass
+
+
+class NotFoundError(SearchYAMLError):
+    pass
+
+
+class TooLargeError(SearchYAMLError):
+    pass
+
+
+class RateLimitedError(SearchYAMLError):
+    pass
+
+
+class QuotaExceededError(SearchYAMLError):
+    pass
+
+
+class StoreFullError(QuotaExceededError):
+    pass
+
+
+_ERRORS = {
+    "invalid_request": InvalidRequestError,
+    "invalid_query": InvalidQueryError,
+    "invalid_key": InvalidKeyError,
+    "invalid_ttl": InvalidTTLError,
+    "dimension_mismatch": DimensionMismatchError,
+    "coercion_failed": InvalidRequestError,
+    "indexing_failed": InvalidRequestError,
+    "unauthorized": UnauthorizedError,
+    "forbidden": ForbiddenError,
+    "read_only": ReadOnlyError,
+    "not_found": NotFoundError,
+    "too_large": TooLargeError,
+    "rate_limited": RateLimitedError,
+    "quota_exceeded": QuotaExceededError,
+    "store_full": StoreFullError,
+}
+
+
+def error_from_response(status, body):
+    """Build the exception for an error response body."""
+    try:
+        payload = json.loads(body)
+    except ValueError:
+        payload = None
+    if not isinstance(payload, dict):
+        return SearchYAMLError(status, "", f"unexpected status code: {status}")
+
+    code = payload.get("code", "")
+    cls = _ERRORS.get(code, SearchYAMLError)
+    return cls(status, code, payload.get("message", ""), payload.get("details"))
@@ -0,0 +1,175 @@
+// TypeScript client for SearchYAML with the same surface as the Go client.
+// Uses the global fetch of Node.js 18+ and browsers.
+
+export interface SearchQuery {
+  text?: string;
+  text_fields?: string[];
+  vector?: number[];
+  filters?: Record<string, unknown>;
+  max_results?: number;
+  min_score?: number;
+  expr?: string;
+  fields?: Record<string, string>;
+}
+
+export interface SearchResult {
+  key: string;
+  value: unknown;
+  text_score?: number;
+  vector_score?: number;
+  combined_score: number;
+  fields?: Record<string, unknown>;
+  /** Federation peer that returned the result */
+  source?: string;
+}
+
+export interface QueryStage {
+  stage: string;
+  index?: string;
+  duration: number;
+  results: number;
+  trigrams?: number;
+  iocs?: number;
+  postings?: number;
+  matched?: number;
+  compared?: number;
+  candidates?: Record<string, number>;
+}
+
+export interface Explanation {
+  duration: number;
+  stages: QueryStage[];
+  results: number;
+}
+
+/** Error codes returned by the server in the error envelope */
+export type ErrorCode =
+  | "invalid_request"
+  | "invalid_query"
+  | "invalid_key"
+  | "invalid_ttl"
+  | "dimension_mismatch"
+  | "coercion_failed"
+  | "indexing_failed"
+  | "unauthorized"
+  | "forbidden"
+  | "read_only"
+  | "not_found"
+  | "too_large"
+  | "rate_limited"
+  | "quota_exceeded"
+  | "store_full"
+  | "upstream_failed"
+  | "internal";
+
+/** An error response from the server */
+export class SearchYAMLError extends Error {
+  constructor(
+    readonly status: number,
+    readonly code: ErrorCode | "",
+    message: string,
+    readonly details?: unknown,
+  ) {
+    super(message ? `${message} (${status} ${code})` : `unexpected status code: ${status}`);
+    this.name = "SearchYAMLError";
+  }
+}
+
+export interface ClientOptions {
+  /** Request timeout in milliseconds, default 10000 */
+  timeout?: number;
+  /** API key sent as X-API-Key */
+  apiKey?: string;
+}
+
+export class Client {
+  private readonly baseURL: string;
+  private readonly timeout: number;
+  private readonly apiKey?: string;
+
+  constructor(baseURL: string, options: ClientOptions = {}) {
+    this.baseURL = baseURL.replace(/\/+$/, "");
+    this.timeout = options.timeout ?? 10000;
+    this.apiKey = options.apiKey;
+  }
+
+  /** Store a value. ttl is a duration such as "90s" or "24h". */
+  async set(key: string, value: unknown, ttl?: string): Promise<void> {
+    const headers: Record<string, string> = {};
+    if (ttl) {
+      headers["X-TTL"] = ttl;
+    }
+    await this.request("POST", this.dataPath(key), value, headers);
+  }
+
+  /** Return the value of key, or undefined if it does not exist. */
+  async get<T = unknown>(key: string): Promise<T | undefined> {
+    try {
+      const body = await this.request<Record<string, { Value: T }>>("GET", this.dataPath(key));
+      return body[key].Value;
+    } catch (err) {
+      if (err instanceof SearchYAMLError && err.status === 404) {
+        return undefined;
+      }
+      throw err;
+    }
+  }
+
+  /** Delete a key. */
+  async delete(key: string): Promise<void> {
+    await this.request("DELETE", this.dataPath(key));
+  }
+
+  /** Full-text search. */
+  textSearch(text: string, maxResults = 0, minScore = 0): Promise<SearchResult[]> {
+    return this.request("POST", "/search/text", { text, max_results: maxResults, min_score: minScore });
+  }
+
+  /** Vector similarity search. */
+  vectorSearch(vector: number[], maxResults = 0, minScore = 0): Promise<SearchResult[]> {
+    return this.request("POST", "/search/vector", { vector, max_results: maxResults, min_score: minScore });
+  }
+
+  /** Search with any combination of text, vector, filters and expressions. */
+  combinedSearch(query: SearchQuery): Promise<SearchResult[]> {
+    return this.request("POST", "/search/combined", query);
+  }
+
+  /** Run a combined search and return the results with an explanation of how it ran. */
+  async explainSearch(query: SearchQuery): Promise<{ results: SearchResult[]; explain: Explanation }> {
+    return this.request("POST", "/search/combined", { ...query, explain: true });
+  }
+
+  // Keys are percent-encoded, including slashes
+  private dataPath(key: string): string {
+    return `/data/${encodeURIComponent(key)}`;
+  }
+
+  private async request<T>(method: string, path: string, body?: unknown, headers: Record<string, string> = {}): Promise<T> {
+    if (body !== undefined) {
+      headers["Content-Type"] = "application/json";
+    }
+    if (this.apiKey) {
+      headers["X-API-Key"] = this.apiKey;
+    }
+
+    const response = await fetch(this.baseURL + path, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+      signal: AbortSignal.timeout(this.timeout),
+    });
+
+    const text = await response.text();
+    if (!response.ok) {
+      let payload: { code?: ErrorCode; message?: string; details?: unknown } = {};
+      try {
+        payload = JSON.parse(text);
+      } catch {
+        // Not an error envelope
+      }
+      throw new SearchYAMLError(response.status, payload.code ?? "", payload.message ?? "", payload.details);
+    }
+    return (text ? JSON.parse(text) : undefined) as T;
+  }
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// Last path segments copying and renaming the key before them, e.g.
+// POST /data/configs/prod/api.yaml/_copy
+const (
+	copySuffix   = "/_copy"
+	renameSuffix = "/_rename"
+)
+
+// CopyRequest is the body of POST /data/:key/_copy and /_rename. The
+// destination keeps the expiry and metadata of the source unless reset;
+// reset metadata is taken from the headers of the request as on a write.
+type CopyRequest struct {
+	Destination   string `json:"destination" binding:"required"`
+	Overwrite     bool   `json:"overwrite"`
+	ResetTTL      bool   `json:"reset_ttl"`
+	ResetMetadata bool   `json:"reset_metadata"`
+}
+
+// keyAction returns the copy or rename action a /data path names and the
+// key it applies to
+func keyAction(path string) (key string, move bool, ok bool) {
+	if key, ok := strings.CutSuffix(path, copySuffix); ok {
+		return key, false, true
+	}
+	if key, ok := strings.CutSuffix(path, renameSuffix); ok {
+		return key, true, true
+	}
+	return "", false, false
+}
+
+// isActionKey reports whether a key names a bulk endpoint, a key action, a
+// blob, a hash, links or tags and so cannot be written
+func isActionKey(key string) bool {
+	if _, _, ok := keyAction(key); ok || strings.HasSuffix(key, blobSuffix) || strings.HasSuffix(key, hashSuffix) || strings.HasSuffix(key, linksSuffix) {
+		return true
+	}
+	if _, _, ok := tagsPath(key); ok {
+		return true
+	}
+	for _, action := range dataActions {
+		if key == action {
+			return true
+		}
+	}
+	return false
+}
+
+// copyKey copies or, with move, renames key to the destination of the
+// request body
+func copyKey(c *gin.Context, store *storage.Store, key string, move bool) {
+	var request CopyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+	dst := request.Destination
+	if isDirectory(key) || isDirectory(dst) || isActionKey(dst) {
+		respondError(c, 400, CodeInvalidKey, "invalid key: copies and renames take a key, not a directory or action")
+		return
+	}
+	if !canRead(c, key) || !canWrite(c, dst) || (move && !canWrite(c, key)) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	if !checkWritableKey(c, dst) || (move && !checkWritableKey(c, key)) {
+		return
+	}
+
+	opts := storage.CopyOptions{
+		Overwrite:     request.Overwrite,
+		ResetTTL:      request.ResetTTL,
+		ResetMetadata: request.ResetMetadata,
+	}
+	if opts.ResetMetadata {
+		meta, err := requestMetadata(c)
+		if err != nil {
+			respondError(c, 400, CodeInvalidRequest, err.Error())
+			return
+		}
+		opts.Metadata = meta
+	}
+
+	write := store.Copy
+	if move {
+		write = store.Rename
+	}
+	if err := write(key, dst, opts); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"status": "ok"})
+}
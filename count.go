@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// CountResponse is the body of POST /search/count
+type CountResponse struct {
+	Count int `json:"count"`
+}
+
+// ExistsResponse is the body of POST /search/exists
+type ExistsResponse struct {
+	Exists bool `json:"exists"`
+}
+
+// handleCount counts the readable documents matching a search query without
+// returning them. Counts are local; they are not federated.
+func handleCount(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var query storage.SearchQuery
+		if err := c.ShouldBindJSON(&query); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		n, err := store.Count(query, readableKeys(c))
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		c.JSON(200, CountResponse{Count: n})
+	}
+}
+
+// handleExists reports whether any readable document matches a search query
+func handleExists(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var query storage.SearchQuery
+		if err := c.ShouldBindJSON(&query); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		exists, err := store.Exists(query, readableKeys(c))
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		c.JSON(200, ExistsResponse{Exists: exists})
+	}
+}
+
+// handleAggregate computes statistics of a numeric field over the readable
+// documents, or those matching a query. Aggregations are local; they are not
+// federated.
+func handleAggregate(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var request storage.AggregateRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		result, err := store.Aggregate(request, readableKeys(c))
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		c.JSON(200, result)
+	}
+}
+
+// readableKeys returns the filter of the keys the request may read, or nil
+// when ACLs are disabled
+func readableKeys(c *gin.Context) func(key string) bool {
+	p := principalFrom(c)
+	if p == nil {
+		return nil
+	}
+	return p.CanRead
+}
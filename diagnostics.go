@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// configureProfiling enables the block and mutex profiles, which the runtime
+// leaves off because they cost time on every contended operation
+func configureProfiling(blockRate, mutexFraction int) {
+	if blockRate > 0 {
+		runtime.SetBlockProfileRate(blockRate)
+	}
+	if mutexFraction > 0 {
+		runtime.SetMutexProfileFraction(mutexFraction)
+	}
+}
+
+// handlePprof serves net/http/pprof under /admin/pprof/. The index page and
+// named profiles (heap, goroutine, block, mutex, allocs, threadcreate) are
+// served by pprof's handlers; profile, trace, cmdline and symbol have their
+// own endpoints.
+func handlePprof() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := strings.TrimPrefix(c.Param("profile"), "/")
+		switch name {
+		case "":
+			pprof.Index(c.Writer, c.Request)
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			if runtimepprof.Lookup(name) == nil {
+				respondError(c, 404, CodeNotFound, "unknown profile: "+name)
+				return
+			}
+			pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+		}
+	}
+}
+
+// handleGoroutines writes the stack of every goroutine as plain text
+func handleGoroutines() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Status(200)
+		runtimepprof.Lookup("goroutine").WriteTo(c.Writer, 2)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/pipeline"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// requestScope is a request with its tenant store and ingest pipelines, for
+// the APIs that read and write documents outside the REST handlers, GraphQL
+// and MCP. Its methods check access and keys as the REST handlers do, and
+// return errors with the codes of REST responses, see errorCode.
+type requestScope struct {
+	c         *gin.Context
+	store     *storage.Store
+	pipelines *Pipelines
+}
+
+// newRequestScope returns the scope of a request, using its tenant's store
+func newRequestScope(c *gin.Context, store *storage.Store, pipelines *Pipelines) *requestScope {
+	return &requestScope{c: c, store: tenantStore(c, store), pipelines: pipelines}
+}
+
+// get returns the redacted entry of key, or nil when it does not exist
+func (r *requestScope) get(key string) (*storage.Entry, error) {
+	if !canRead(r.c, key) {
+		return nil, &codedError{CodeForbidden, "access denied"}
+	}
+	entry, exists := r.store.Get(key)
+	if !exists {
+		return nil, nil
+	}
+	return redactEntry(r.c, entry), nil
+}
+
+// keys returns a page of the readable keys starting with prefix after the
+// key after, and the key to pass as after for the next page, if any
+func (r *requestScope) keys(prefix, after string, limit int) ([]string, string, error) {
+	if limit <= 0 {
+		return nil, "", &codedError{CodeInvalidRequest, "limit must be a positive integer"}
+	}
+	keys, _ := r.store.Keys(prefix, after, 0)
+	page := []string{}
+	for _, key := range keys {
+		if !canRead(r.c, key) {
+			continue
+		}
+		if len(page) == limit {
+			return page, page[limit-1], nil
+		}
+		page = append(page, key)
+	}
+	return page, "", nil
+}
+
+// search returns the readable, redacted results of a query
+func (r *requestScope) search(query storage.SearchQuery) ([]storage.SearchResult, error) {
+	results, err := r.store.SearchReadable(query, readableKeys(r.c))
+	if err != nil {
+		return nil, err
+	}
+	return redactResults(r.c, results), nil
+}
+
+// checkWrite returns the error of writing or deleting key, as handleSet and
+// handleDelete check it
+func (r *requestScope) checkWrite(key string) error {
+	if isDirectory(key) {
+		return &codedError{CodeInvalidKey, "invalid key: keys may not be empty or end with /, which names a directory"}
+	}
+	if !canWrite(r.c, key) {
+		return &codedError{CodeForbidden, "access denied"}
+	}
+	if prefix, reserved := reservedKeyPrefix(key); reserved {
+		return &codedError{CodeInvalidKey, fmt.Sprintf("invalid key: prefix %q is reserved", prefix)}
+	}
+	return nil
+}
+
+// documentWrite is a document to store with the options of POST /data/:key
+type documentWrite struct {
+	Key         string
+	Value       interface{}
+	TTL         string            // Duration, e.g. 1h
+	ContentType string            // Instead of X-Content-Type
+	Labels      map[string]string // Instead of X-Labels
+	Pipeline    string            // Ingest pipeline the document runs through
+}
+
+// set stores a document as handleSet does. It returns false when the
+// pipeline dropped the document.
+func (r *requestScope) set(w documentWrite) (bool, error) {
+	if err := r.checkWrite(w.Key); err != nil {
+		return false, err
+	}
+
+	value := w.Value
+	if w.Pipeline != "" {
+		p, exists := r.pipelines.Get(w.Pipeline)
+		if !exists {
+			return false, &codedError{CodeInvalidRequest, fmt.Sprintf("unknown pipeline: %s", w.Pipeline)}
+		}
+		processed, err := p.Run(value)
+		if errors.Is(err, pipeline.ErrDropped) {
+			return false, nil
+		}
+		if err != nil {
+			return false, &codedError{CodeInvalidRequest, err.Error()}
+		}
+		value = processed
+	}
+
+	rules, _, err := parseYARADocument(value)
+	if err != nil {
+		return false, &codedError{CodeInvalidRequest, err.Error()}
+	}
+
+	var ttl time.Duration
+	if w.TTL != "" {
+		if ttl, err = storage.ParseTTL(w.TTL); err != nil {
+			return false, err
+		}
+	}
+
+	meta, err := requestMetadata(r.c)
+	if err != nil {
+		return false, &codedError{CodeInvalidRequest, err.Error()}
+	}
+	if w.ContentType != "" {
+		meta.ContentType = w.ContentType
+	}
+	if w.Labels != nil {
+		if len(w.Labels) > maxLabels {
+			return false, &codedError{CodeInvalidRequest, fmt.Sprintf("too many labels: %d, at most %d", len(w.Labels), maxLabels)}
+		}
+		meta.Labels = w.Labels
+	}
+
+	if err := r.store.SetWithMetadata(w.Key, value, ttl, meta); err != nil {
+		return false, err
+	}
+	return true, indexYARARules(r.store, w.Key, rules)
+}
+
+// delete deletes the entry of key, returning whether it existed
+func (r *requestScope) delete(key string) (bool, error) {
+	if err := r.checkWrite(key); err != nil {
+		return false, err
+	}
+	if r.store.ReadOnly() {
+		return false, storage.ErrReadOnly
+	}
+	_, existed := r.store.Get(key)
+	r.store.Delete(key)
+	deleteChunks(r.store, key)
+	return existed, nil
+}
+
+// labelsArgument converts labels given as an object of strings, as GraphQL
+// and MCP clients send them
+func labelsArgument(v interface{}) (map[string]string, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &codedError{CodeInvalidRequest, "labels must be an object of names to values"}
+	}
+	labels := make(map[string]string, len(m))
+	for name, value := range m {
+		s, ok := value.(string)
+		if !ok || name == "" {
+			return nil, &codedError{CodeInvalidRequest, fmt.Sprintf("invalid label %q: values must be strings", name)}
+		}
+		labels[name] = s
+	}
+	return labels, nil
+}
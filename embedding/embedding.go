@@ -0,0 +1,146 @@
+// Package embedding computes embeddings of text for vector indexes, either
+// locally by feature hashing or by an OpenAI-compatible embeddings API.
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Embedder computes the embeddings of texts
+type Embedder interface {
+	// Embed returns an embedding of each text, in order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Options configure embedders calling an API
+type Options struct {
+	Model   string // Model name sent to the API
+	APIKey  string // Bearer token sent to the API
+	Timeout time.Duration
+}
+
+// New returns the embedder described by spec: "hash:<dims>" for local
+// feature hashing of words into dims dimensions, or the URL of an
+// OpenAI-compatible embeddings endpoint such as
+// http://localhost:11434/v1/embeddings.
+func New(spec string, opts Options) (Embedder, error) {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &apiEmbedder{url: spec, opts: opts, client: &http.Client{Timeout: opts.Timeout}}, nil
+	}
+	name, arg, _ := strings.Cut(spec, ":")
+	if name != "hash" {
+		return nil, fmt.Errorf("unknown embedder %q: use hash:<dims> or an embeddings API URL", spec)
+	}
+	dims, err := strconv.Atoi(arg)
+	if err != nil || dims <= 0 {
+		return nil, fmt.Errorf("embedder %s: dimensions must be a positive integer", spec)
+	}
+	return hashEmbedder(dims), nil
+}
+
+// hashEmbedder hashes the lowercased words of a text into a vector of its
+// dimensions, each word adding or subtracting 1 by a bit of its hash. Texts
+// sharing words are similar; it knows no synonyms, but needs no model.
+type hashEmbedder int
+
+func (h hashEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vec := make([]float32, h)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			f := fnv.New64a()
+			f.Write([]byte(word))
+			sum := f.Sum64()
+			if sum>>63 == 0 {
+				vec[sum%uint64(h)]++
+			} else {
+				vec[sum%uint64(h)]--
+			}
+		}
+		normalize(vec)
+		vectors[i] = vec
+	}
+	return vectors, nil
+}
+
+func normalize(vec []float32) {
+	var sum float64
+	for _, v := range vec {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range vec {
+		vec[i] /= norm
+	}
+}
+
+// apiEmbedder calls an OpenAI-compatible embeddings endpoint
+type apiEmbedder struct {
+	url    string
+	opts   Options
+	client *http.Client
+}
+
+func (a *apiEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": a.opts.Model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.opts.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.opts.APIKey)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embeddings API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("embeddings API: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("embeddings API: invalid response: %v", err)
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings API: invalid response: index %d of %d inputs", d.Index, len(texts))
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, vec := range vectors {
+		if len(vec) == 0 {
+			return nil, fmt.Errorf("embeddings API: invalid response: no embedding of input %d", i)
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// Error codes returned in ErrorResponse.Code
+const (
+	CodeInvalidRequest    = "invalid_request"
+	CodeInvalidQuery      = "invalid_query"
+	CodeInvalidKey        = "invalid_key"
+	CodeInvalidTTL        = "invalid_ttl"
+	CodeInvalidIndex      = "invalid_index"
+	CodeDimensionMismatch = "dimension_mismatch"
+	CodeCoercionFailed    = "coercion_failed"
+	CodeIndexingFailed    = "indexing_failed"
+	CodeTooLarge          = "too_large"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeReadOnly          = "read_only"
+	CodeNotFound          = "not_found"
+	CodeKeyExists         = "key_exists"
+	CodeRateLimited       = "rate_limited"
+	CodeQuotaExceeded     = "quota_exceeded"
+	CodeStoreFull         = "store_full"
+	CodeUpstreamFailed    = "upstream_failed"
+	CodeInternal          = "internal"
+)
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// respondError aborts the request with an error response
+func respondError(c *gin.Context, status int, code, message string) {
+	respondErrorDetails(c, status, code, message, nil)
+}
+
+// respondErrorDetails aborts the request with an error response carrying
+// additional details, such as the objects processed before the failure
+func respondErrorDetails(c *gin.Context, status int, code, message string, details interface{}) {
+	c.AbortWithStatusJSON(status, ErrorResponse{Code: code, Message: message, Details: details})
+}
+
+// respondStoreError responds with the status and code of a store error.
+// Errors without a specific mapping are internal errors.
+func respondStoreError(c *gin.Context, err error) {
+	respondStoreErrorDetails(c, err, nil)
+}
+
+// respondStoreErrorDetails is respondStoreError with additional details.
+// Index update errors always report the rejected fields as details.
+func respondStoreErrorDetails(c *gin.Context, err error, details interface{}) {
+	status, code := errorStatus(err)
+
+	var updateErr *storage.IndexUpdateError
+	if details == nil && errors.As(err, &updateErr) {
+		details = updateErr.Errors
+	}
+	respondErrorDetails(c, status, code, err.Error(), details)
+}
+
+// respondBadRequest responds to a request body or parameter that could not
+// be parsed. Bodies exceeding the decode limits or -max-doc-size are
+// rejected with 413, and bodies arriving while too many are being parsed
+// with 429.
+func respondBadRequest(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.Is(err, storage.ErrParsesBusy) {
+		respondError(c, 429, CodeRateLimited, err.Error())
+		return
+	}
+	if errors.Is(err, storage.ErrDecodeLimit) || errors.As(err, &tooLarge) {
+		respondError(c, 413, CodeTooLarge, err.Error())
+		return
+	}
+	respondError(c, 400, CodeInvalidRequest, err.Error())
+}
+
+// errorStatus maps store errors to HTTP status codes and error codes
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidQuery):
+		return 400, CodeInvalidQuery
+	case errors.Is(err, storage.ErrInvalidKey):
+		return 400, CodeInvalidKey
+	case errors.Is(err, storage.ErrInvalidTTL):
+		return 400, CodeInvalidTTL
+	case errors.Is(err, storage.ErrInvalidIndex):
+		return 400, CodeInvalidIndex
+	case errors.Is(err, storage.ErrDimensionMismatch):
+		return 400, CodeDimensionMismatch
+	case errors.Is(err, storage.ErrCoercion):
+		return 400, CodeCoercionFailed
+	case errors.Is(err, storage.ErrIndexing):
+		return 400, CodeIndexingFailed
+	case errors.Is(err, storage.ErrDecodeLimit):
+		return 413, CodeTooLarge
+	case errors.Is(err, storage.ErrParsesBusy):
+		return 429, CodeRateLimited
+	case errors.Is(err, storage.ErrReadOnly):
+		return 403, CodeReadOnly
+	case errors.Is(err, storage.ErrNotFound):
+		return 404, CodeNotFound
+	case errors.Is(err, storage.ErrKeyExists):
+		return 409, CodeKeyExists
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return 507, CodeQuotaExceeded
+	case errors.Is(err, storage.ErrStoreFull):
+		return 507, CodeStoreFull
+	}
+	return 500, CodeInternal
+}
+
+// codedError is an error with the code its response carries, returned by
+// code shared between the REST handlers and the other APIs
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+// errorCode returns the code of an error: its own, or that of the store
+// error, see errorStatus
+func errorCode(err error) string {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	_, code := errorStatus(err)
+	return code
+}
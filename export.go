@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/expr"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// Tabular formats of search results and views, chosen with the Accept
+// header or ?format=
+const (
+	exportCSV     = "csv"
+	exportParquet = "parquet"
+
+	csvContentType     = "text/csv"
+	parquetContentType = "application/vnd.apache.parquet"
+)
+
+// exportRow is a search result or view entry being exported
+type exportRow struct {
+	key    string
+	value  interface{}
+	fields map[string]interface{} // Computed fields
+	scores map[string]float64
+}
+
+// exportFormat returns the tabular format a request asks for, or "" for
+// the usual JSON or YAML
+func exportFormat(c *gin.Context) string {
+	switch strings.ToLower(c.Query("format")) {
+	case exportCSV:
+		return exportCSV
+	case exportParquet:
+		return exportParquet
+	}
+	accept := c.GetHeader("Accept")
+	switch {
+	case strings.Contains(accept, csvContentType):
+		return exportCSV
+	case strings.Contains(accept, parquetContentType), strings.Contains(accept, "application/x-parquet"):
+		return exportParquet
+	}
+	return ""
+}
+
+// searchRows converts search results for export
+func searchRows(results []storage.SearchResult) []exportRow {
+	rows := make([]exportRow, len(results))
+	for i, r := range results {
+		rows[i] = exportRow{
+			key:    r.Key,
+			value:  r.Value,
+			fields: r.Fields,
+			scores: map[string]float64{
+				"score":        r.Combined,
+				"text_score":   r.TextScore,
+				"vector_score": float64(r.VecScore),
+			},
+		}
+	}
+	return rows
+}
+
+// viewRows converts the entries of a view for export
+func viewRows(results []storage.ViewResult) []exportRow {
+	rows := make([]exportRow, len(results))
+	for i, r := range results {
+		rows[i] = exportRow{key: r.Key, value: r.Value}
+	}
+	return rows
+}
+
+// exportColumns returns the columns of an export: those of ?columns= when
+// given, or else key, score for search results, the computed fields and
+// the top-level fields of the values, sorted
+func exportColumns(c *gin.Context, rows []exportRow, scored bool) []string {
+	if columns := c.Query("columns"); columns != "" {
+		var out []string
+		for _, column := range strings.Split(columns, ",") {
+			if column = strings.TrimSpace(column); column != "" {
+				out = append(out, column)
+			}
+		}
+		return out
+	}
+
+	columns := []string{"key"}
+	if scored {
+		columns = append(columns, "score")
+	}
+	computed := make(map[string]struct{})
+	fields := make(map[string]struct{})
+	for _, row := range rows {
+		for name := range row.fields {
+			computed["fields."+name] = struct{}{}
+		}
+		if m, ok := row.value.(map[string]interface{}); ok {
+			for name := range m {
+				if isExportColumn(name) {
+					name = "value." + name
+				}
+				fields[name] = struct{}{}
+			}
+		}
+	}
+	columns = append(columns, sortedSet(computed)...)
+	return append(columns, sortedSet(fields)...)
+}
+
+// isExportColumn reports whether a column names a part of a result rather
+// than a field of its value
+func isExportColumn(column string) bool {
+	switch column {
+	case "key", "score", "text_score", "vector_score":
+		return true
+	}
+	return strings.HasPrefix(column, "fields.") || strings.HasPrefix(column, "value.")
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	out := make([]string, 0, len(set))
+	for name := range set {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// cell returns the value of a column of a row: key, score, text_score and
+// vector_score of the result, fields.<name> for a computed field, and a
+// dotted path into the value otherwise, optionally prefixed with value.
+func (row exportRow) cell(column string) interface{} {
+	switch column {
+	case "key":
+		return row.key
+	case "score", "text_score", "vector_score":
+		if row.scores == nil {
+			return nil
+		}
+		return row.scores[column]
+	}
+	if name, ok := strings.CutPrefix(column, "fields."); ok {
+		return row.fields[name]
+	}
+	m, ok := row.value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return expr.Lookup(m, strings.TrimPrefix(column, "value."))
+}
+
+// respondExport writes rows as CSV or Parquet with the given columns
+func respondExport(c *gin.Context, format string, rows []exportRow, columns []string) {
+	cells := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		cells[i] = make([]interface{}, len(columns))
+		for j, column := range columns {
+			cells[i][j] = row.cell(column)
+		}
+	}
+	respondTable(c, format, columns, cells)
+}
+
+// respondTable writes the cells of a table as CSV or Parquet
+func respondTable(c *gin.Context, format string, columns []string, rows [][]interface{}) {
+	if format == exportCSV {
+		c.Header("Content-Type", csvContentType+"; charset=utf-8")
+		c.Status(200)
+		w := csv.NewWriter(c.Writer)
+		w.Write(columns)
+		record := make([]string, len(columns))
+		for _, row := range rows {
+			for i, cell := range row {
+				record[i] = csvCell(cell)
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		return
+	}
+
+	parquetColumns := make([]parquetColumn, len(columns))
+	for i, column := range columns {
+		values := make([]interface{}, len(rows))
+		for j, row := range rows {
+			values[j] = row[i]
+		}
+		parquetColumns[i] = newParquetColumn(column, values)
+	}
+	c.Header("Content-Type", parquetContentType)
+	c.Status(200)
+	if err := writeParquet(c.Writer, parquetColumns, len(rows)); err != nil {
+		c.Error(err)
+	}
+}
+
+// csvCell formats a value for CSV: scalars as text, nested values as JSON
+func csvCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	if f, ok := exportNumber(v); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// newParquetColumn types a column by its values: booleans or numbers if all
+// its non-null values are, strings otherwise, with nested values as JSON.
+// Columns of nulls only are strings.
+func newParquetColumn(name string, values []interface{}) parquetColumn {
+	allBool, allNumber, found := true, true, false
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		found = true
+		if _, ok := v.(bool); !ok {
+			allBool = false
+		}
+		if _, ok := exportNumber(v); !ok {
+			allNumber = false
+		}
+	}
+
+	col := parquetColumn{name: name, kind: parquetByteArray, values: values}
+	switch {
+	case found && allBool:
+		col.kind = parquetBoolean
+	case found && allNumber:
+		col.kind = parquetDouble
+		for i, v := range values {
+			if v != nil {
+				values[i], _ = exportNumber(v)
+			}
+		}
+	default:
+		for i, v := range values {
+			if v != nil {
+				values[i] = csvCell(v)
+			}
+		}
+	}
+	return col
+}
+
+// exportNumber converts a decoded number to float64
+func exportNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,315 @@
+// Package expr implements a small expression language over YAML/JSON
+// documents, used by ingest pipeline scripts and query-time computed fields.
+//
+// Expressions support number, string, boolean and null literals, dotted field
+// paths (value.price), arithmetic (+ - * / %), comparison (== != < <= > >=),
+// logical operators (&& || ! and their and/or/not spellings), parentheses and
+// a fixed set of functions (see Functions).
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Program is a parsed expression
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression
+func Compile(source string) (*Program, error) {
+	if len(source) > maxLength {
+		return nil, fmt.Errorf("expr: longer than %d bytes", maxLength)
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("expr: unexpected %q", p.peek().text)
+	}
+	return &Program{source: source, root: root}, nil
+}
+
+// String returns the source of the program
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the program against env. Identifiers resolve to env entries;
+// dotted paths descend into nested maps. Missing fields evaluate to nil.
+func (p *Program) Eval(env map[string]interface{}) (interface{}, error) {
+	return p.root.eval(env)
+}
+
+// EvalBool evaluates the program and reports whether the result is truthy
+func (p *Program) EvalBool(env map[string]interface{}) (bool, error) {
+	v, err := p.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	return Truthy(v), nil
+}
+
+// Truthy reports whether a value counts as true: non-zero numbers, non-empty
+// strings and collections, and true
+func Truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	if f, ok := ToNumber(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+// ToNumber converts numeric values (and numeric strings) to float64
+func ToNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Lookup resolves a dotted path within env
+func Lookup(env map[string]interface{}, path string) interface{} {
+	var current interface{} = env
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+type node interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+type literal struct{ value interface{} }
+
+func (n literal) eval(map[string]interface{}) (interface{}, error) { return n.value, nil }
+
+type field struct{ path string }
+
+func (n field) eval(env map[string]interface{}) (interface{}, error) {
+	return Lookup(env, n.path), nil
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+func (n unary) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !Truthy(v), nil
+	}
+	f, ok := ToNumber(v)
+	if !ok {
+		return nil, fmt.Errorf("expr: cannot negate %v", v)
+	}
+	return -f, nil
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n binary) eval(env map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short-circuit logical operators
+	switch n.op {
+	case "&&":
+		if !Truthy(l) {
+			return false, nil
+		}
+		r, err := n.right.eval(env)
+		return Truthy(r), err
+	case "||":
+		if Truthy(l) {
+			return true, nil
+		}
+		r, err := n.right.eval(env)
+		return Truthy(r), err
+	}
+
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "<", "<=", ">", ">=":
+		return compare(n.op, l, r)
+	case "+":
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok || rok {
+			if !lok {
+				ls = toString(l)
+			}
+			if !rok {
+				rs = toString(r)
+			}
+			return ls + rs, nil
+		}
+	}
+
+	lf, lok := ToNumber(l)
+	rf, rok := ToNumber(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("expr: %s requires numbers, got %v and %v", n.op, l, r)
+	}
+
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("expr: division by zero")
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, fmt.Errorf("expr: division by zero")
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("expr: unknown operator %s", n.op)
+}
+
+func equal(l, r interface{}) bool {
+	lf, lok := ToNumber(l)
+	rf, rok := ToNumber(r)
+	_, lstr := l.(string)
+	_, rstr := r.(string)
+	if lok && rok && !(lstr && rstr) {
+		return lf == rf
+	}
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	return toString(l) == toString(r)
+}
+
+func compare(op string, l, r interface{}) (interface{}, error) {
+	var c int
+	ls, lstr := l.(string)
+	rs, rstr := r.(string)
+	if lstr && rstr {
+		c = strings.Compare(ls, rs)
+	} else {
+		lf, lok := ToNumber(l)
+		rf, rok := ToNumber(r)
+		if !lok || !rok {
+			return false, nil
+		}
+		switch {
+		case lf < rf:
+			c = -1
+		case lf > rf:
+			c = 1
+		}
+	}
+
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+type call struct {
+	name string
+	fn   Function
+	args []node
+}
+
+func (n call) eval(env map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn(args...)
+	if err != nil {
+		return nil, fmt.Errorf("expr: %s: %v", n.name, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,309 @@
+package expr
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEnv is the environment the expressions of the tests run against
+func testEnv() map[string]interface{} {
+	return map[string]interface{}{
+		"value": map[string]interface{}{
+			"price":    12.5,
+			"quantity": 4,
+			"count":    int64(3),
+			"code":     "42",
+			"name":     "Widget",
+			"empty":    "",
+			"tags":     []interface{}{"a", "b", 3.0},
+			"nested":   map[string]interface{}{"deep": true},
+			"none":     nil,
+		},
+		"key":   "items/widget",
+		"@meta": "at",
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		// Literals and fields
+		{"1.5e2", 150.0},
+		{".5", 0.5},
+		{`"double" + 'single'`, "doublesingle"},
+		{`"esc\"aped"`, `esc"aped`},
+		{"true", true},
+		{"null", nil},
+		{"nil", nil},
+		{"key", "items/widget"},
+		{"value.nested.deep", true},
+		{"value.missing.field", nil},
+		{"value.name.length", nil},
+		{"@meta", "at"},
+
+		// Precedence and associativity
+		{"2 + 3 * 4", 14.0},
+		{"(2 + 3) * 4", 20.0},
+		{"10 - 4 - 3", 3.0},
+		{"48 / 4 / 2", 6.0},
+		{"2 * 7 % 4", 2.0},
+		{"2 + 7 % 4 * 2", 8.0},
+		{"-2 * 3", -6.0},
+		{"- -2", 2.0},
+		{"-(2 + 3)", -5.0},
+		{"1 + 2 < 4", true},
+		{"1 < 2 == true", true},
+		{"2 > 1 > 0", false}, // (2 > 1) is true, which is not a number
+		{"!true == false", true},
+		{"!(1 == 2)", true},
+		{"not 1 == 2", false},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"false || true and 1 == 1", true},
+		{"1 == 1 and 2 == 2 or false", true},
+		{"1 + 1 == 2 && 3 * 2 >= 6", true},
+
+		// Coercion
+		{"value.quantity * value.price", 50.0},
+		{"value.count + 1", 4.0},
+		{"value.code * 2", 84.0},
+		{`"3" * "2"`, 6.0},
+		{`" 7 " - 2`, 5.0},
+		{"-value.code", -42.0},
+		{`1 + "2"`, "12"},
+		{`"n=" + 1.5`, "n=1.5"},
+		{`"x" + true`, "xtrue"},
+		{`"x" + null`, "x"},
+		{`value.code == 42`, true},
+		{`"1" == 1.0`, true},
+		{`"1" == "1.0"`, false},
+		{`"abc" == "abc"`, true},
+		{`true == 1`, false},
+		{`true == "true"`, true},
+		{"null == null", true},
+		{"null == 0", false},
+		{`null == ""`, false},
+		{"value.missing == null", true},
+		{"value.none != null", false},
+		{`"10" < "9"`, true},
+		{`"10" < 9`, false},
+		{`value.code > 9`, true},
+		{`"apple" <= "banana"`, true},
+		{`"x" < 1`, false},
+		{"null < 1", false},
+		{"true > false", false},
+		{"5 % 3", 2.0},
+		{"-5 % 3", -2.0},
+		{"7.5 % 2", 1.5},
+
+		// Logical operators return booleans and short-circuit
+		{`1 && "x"`, true},
+		{`0 || ""`, false},
+		{"value.empty || value.name", true},
+		{"false && 1 / 0", false},
+		{"true || 1 / 0", true},
+		{"value.none && 1 / 0", false},
+
+		// Functions
+		{"len(value.name)", 6.0},
+		{"len(value.tags)", 3.0},
+		{"len(value.nested)", 1.0},
+		{"len(value.missing)", 0.0},
+		{"lower(value.name) + upper('x')", "widgetX"},
+		{"contains(value.tags, 3)", true},
+		{"contains(value.tags, '3')", true},
+		{"contains(value.tags, 'c')", false},
+		{"contains(key, 'widget')", true},
+		{"startsWith(key, 'items/') && endsWith(key, 'get')", true},
+		{"number(value.code) + 1", 43.0},
+		{"string(1.5) + string(null)", "1.5"},
+		{"abs(-2.5)", 2.5},
+		{"round(2.5)", 3.0},
+		{"exists(value.price) && !exists(value.missing)", true},
+		{"len(lower(value.name)) * 2", 12.0},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		p, err := Compile(tt.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.source, err)
+			continue
+		}
+		got, err := p.Eval(env)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Eval(%q) = %#v, want %#v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		source, err string
+	}{
+		{"1 / 0", "expr: division by zero"},
+		{"1 % 0", "expr: division by zero"},
+		{"0 / 0", "expr: division by zero"},
+		{"1 / (2 - 2)", "expr: division by zero"},
+		{`value.price / "0"`, "expr: division by zero"},
+		{"1 / value.missing", "expr: / requires numbers, got 1 and <nil>"},
+		{`1 - "x"`, "expr: - requires numbers, got 1 and x"},
+		{"true * 2", "expr: * requires numbers, got true and 2"},
+		{"value.tags * 2", "expr: * requires numbers"},
+		{"-value.name", "expr: cannot negate Widget"},
+		{"-true", "expr: cannot negate true"},
+		{"1 + 1 / 0 > 0", "expr: division by zero"},
+		{"true && 1 % 0", "expr: division by zero"},
+		{"abs(1 / 0)", "expr: division by zero"},
+		{`number("x")`, "expr: number: not a number: x"},
+		{"abs(value.name)", "expr: abs: not a number: Widget"},
+		{"len(1)", "expr: len: unsupported argument 1"},
+		{"lower(1, 2)", "expr: lower: expected 1 arguments, got 2"},
+		{"contains()", "expr: contains: expected 2 arguments, got 0"},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		p, err := Compile(tt.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.source, err)
+			continue
+		}
+		if got, err := p.Eval(env); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Eval(%q) = %v, %v; want error %q", tt.source, got, err, tt.err)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source, err string
+	}{
+		{"", "expr: unexpected end of expression"},
+		{"1 +", "expr: unexpected end of expression"},
+		{"1 2", `expr: unexpected "2"`},
+		{"(1 + 2", "expr: missing )"},
+		{"1 + 2)", `expr: unexpected ")"`},
+		{"* 2", `expr: unexpected "*"`},
+		{"1..2", `expr: invalid number "1..2"`},
+		{"1e", `expr: invalid number "1e"`},
+		{`"open`, "expr: unterminated string"},
+		{"1 # 2", "expr: unexpected character '#'"},
+		{"a = 1", "expr: unexpected character '='"},
+		{"a & b", "expr: unexpected character '&'"},
+		{"nosuch(1)", "expr: unknown function nosuch"},
+		{"len(1", "expr: missing ) after len arguments"},
+		{"len(1,)", "expr: unexpected \")\""},
+		{strings.Repeat("(", maxDepth) + "1" + strings.Repeat(")", maxDepth), "nested more than 64 levels deep"},
+		{strings.Repeat("-", maxDepth+1) + "1", "nested more than 64 levels deep"},
+		{strings.Repeat("1+", maxLength/2) + "1", "expr: longer than 65536 bytes"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			source := tt.source
+			if len(source) > 40 {
+				source = source[:40] + "..."
+			}
+			t.Errorf("Compile(%q): error %v, want %q", source, err, tt.err)
+		}
+	}
+}
+
+func TestEvalBool(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"value.price", true},
+		{"value.price - 12.5", false},
+		{"value.empty", false},
+		{"value.name", true},
+		{"value.tags", true},
+		{"value.nested", true},
+		{"value.missing", false},
+		{`"0"`, true},
+		{`"false"`, true},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		p, err := Compile(tt.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := p.EvalBool(env); err != nil || got != tt.want {
+			t.Errorf("EvalBool(%q) = %v, %v; want %v", tt.source, got, err, tt.want)
+		}
+	}
+	if _, err := mustCompile(t, "1 / 0").EvalBool(env); err == nil {
+		t.Error("EvalBool(1 / 0) returned no error")
+	}
+}
+
+func TestToNumber(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  float64
+		ok    bool
+	}{
+		{1.5, 1.5, true},
+		{float32(0.5), 0.5, true},
+		{7, 7, true},
+		{int32(-3), -3, true},
+		{int64(1 << 40), 1 << 40, true},
+		{uint32(9), 9, true},
+		{uint64(10), 10, true},
+		{" 2.5 ", 2.5, true},
+		{"1e3", 1000, true},
+		{"Inf", math.Inf(1), true},
+		{"", 0, false},
+		{"twelve", 0, false},
+		{true, 0, false},
+		{nil, 0, false},
+		{[]interface{}{1}, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ToNumber(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ToNumber(%#v) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func mustCompile(t *testing.T, source string) *Program {
+	t.Helper()
+	p, err := Compile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != source {
+		t.Errorf("String() = %q, want the source %q", p.String(), source)
+	}
+	return p
+}
+
+// FuzzCompile checks that compiling and evaluating arbitrary expressions
+// neither panics nor exhausts the stack
+func FuzzCompile(f *testing.F) {
+	for _, source := range []string{
+		"value.price * (1 + value.tax) > 100 && contains(value.tags, 'x')",
+		`!exists(a.b) or lower("A") == 'a' and -len(c) % 2 != 0`,
+		"((((1 / 0))))",
+	} {
+		f.Add(source)
+	}
+	env := testEnv()
+	f.Fuzz(func(t *testing.T, source string) {
+		p, err := Compile(source)
+		if err != nil {
+			return
+		}
+		p.Eval(env)
+	})
+}
@@ -0,0 +1,117 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Function is a callable available to expressions
+type Function func(args ...interface{}) (interface{}, error)
+
+// Functions is the registry of functions callable from expressions. Callers
+// may register additional functions before compiling expressions.
+var Functions = map[string]Function{
+	"len": func(args ...interface{}) (interface{}, error) {
+		if err := arity(args, 1); err != nil {
+			return nil, err
+		}
+		switch v := args[0].(type) {
+		case string:
+			return float64(len(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return nil, fmt.Errorf("unsupported argument %v", args[0])
+	},
+	"lower": func(args ...interface{}) (interface{}, error) {
+		if err := arity(args, 1); err != nil {
+			return nil, err
+		}
+		return strings.ToLower(toString(args[0])), nil
+	},
+	"upper": func(args ...interface{}) (interface{}, error) {
+		if err := arity(args, 1); err != nil {
+			return nil, err
+		}
+		return strings.ToUpper(toString(args[0])), nil
+	},
+	"contains": func(args ...interface{}) (interface{}, error) {
+		if err := arity(args, 2); err != nil {
+			return nil, err
+		}
+		if list, ok := args[0].([]interface{}); ok {
+			for _, item := range list {
+				if equal(item, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return strings.Contains(toString(args[0]), toString(args[1])), nil
+	},
+	"startsWith": func(args ...interface{}) (interface{}, error) {
+		if err := arity(args, 2); err != nil {
+			return nil, err
+		}
+		return strings.HasPrefix(toString(args[0]), toString(args[1])), nil
+	},
+	"endsWith": func(args ...interface{}) (interface{}, error) {
+		if err := arity(args, 2); err != nil {
+			return nil, err
+		}
+		return strings.HasSuffix(toString(args[0]), toString(args[1])), nil
+	},
+	"number": func(args ...interface{}) (interface{}, error) {
+		if err := arity(args, 1); err != nil {
+			return nil, err
+		}
+		f, ok := ToNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("not a number: %v", args[0])
+		}
+		return f, nil
+	},
+	"string": func(args ...interface{}) (interface{}, error) {
+		if err := arity(args, 1); err != nil {
+			return nil, err
+		}
+		return toString(args[0]), nil
+	},
+	"abs": func(args ...interface{}) (interface{}, error) {
+		f, err := numberArg(args)
+		return math.Abs(f), err
+	},
+	"round": func(args ...interface{}) (interface{}, error) {
+		f, err := numberArg(args)
+		return math.Round(f), err
+	},
+	"exists": func(args ...interface{}) (interface{}, error) {
+		if err := arity(args, 1); err != nil {
+			return nil, err
+		}
+		return args[0] != nil, nil
+	},
+}
+
+func arity(args []interface{}, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	return nil
+}
+
+func numberArg(args []interface{}) (float64, error) {
+	if err := arity(args, 1); err != nil {
+		return 0, err
+	}
+	f, ok := ToNumber(args[0])
+	if !ok {
+		return 0, fmt.Errorf("not a number: %v", args[0])
+	}
+	return f, nil
+}
@@ -0,0 +1,258 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// keywordOps maps word operators to their symbolic form
+var keywordOps = map[string]string{"and": "&&", "or": "||", "not": "!"}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == 'e' || src[i] == 'E') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i]})
+		case c == '"' || c == '\'':
+			quote := c
+			var b strings.Builder
+			i++
+			for i < len(src) && src[i] != quote {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("expr: unterminated string")
+			}
+			i++
+			tokens = append(tokens, token{tokString, b.String()})
+		case c == '_' || c == '@' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (isWordChar(src[i]) || src[i] == '.' || src[i] == '@') {
+				i++
+			}
+			word := src[start:i]
+			if op, ok := keywordOps[word]; ok {
+				tokens = append(tokens, token{tokOp, op})
+			} else {
+				tokens = append(tokens, token{tokIdent, word})
+			}
+		default:
+			two := ""
+			if i+1 < len(src) {
+				two = src[i : i+2]
+			}
+			switch two {
+			case "==", "!=", "<=", ">=", "&&", "||":
+				tokens = append(tokens, token{tokOp, two})
+				i += 2
+				continue
+			}
+			if strings.IndexByte("+-*/%<>!(),", c) < 0 {
+				return nil, fmt.Errorf("expr: unexpected character %q", c)
+			}
+			tokens = append(tokens, token{tokOp, string(c)})
+			i++
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Bounds of compiled expressions, so untrusted input cannot exhaust the
+// stack: operators chained without parentheses nest as deeply as the
+// expression is long
+const (
+	maxLength = 64 << 10 // Bytes of an expression
+	maxDepth  = 64       // Nesting of parentheses, calls and unary operators
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// binaryLevel parses a left-associative chain of operators at one precedence level
+func (p *parser) binaryLevel(next func() (node, error), ops ...string) (node, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.isOp(ops...)
+		if !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) or() (node, error)  { return p.binaryLevel(p.and, "||") }
+func (p *parser) and() (node, error) { return p.binaryLevel(p.comparison, "&&") }
+func (p *parser) comparison() (node, error) {
+	return p.binaryLevel(p.additive, "==", "!=", "<", "<=", ">", ">=")
+}
+func (p *parser) additive() (node, error) { return p.binaryLevel(p.multiplicative, "+", "-") }
+func (p *parser) multiplicative() (node, error) {
+	return p.binaryLevel(p.unary, "*", "/", "%")
+}
+
+// enter bounds the nesting of the expression
+func (p *parser) enter() error {
+	if p.depth++; p.depth > maxDepth {
+		return fmt.Errorf("expr: nested more than %d levels deep", maxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) unary() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	if op, ok := p.isOp("!", "-"); ok {
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: op, operand: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expr: invalid number %q", t.text)
+		}
+		return literal{f}, nil
+	case tokString:
+		return literal{t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null", "nil":
+			return literal{nil}, nil
+		}
+		if _, ok := p.isOp("("); ok {
+			return p.call(t.text)
+		}
+		return field{path: t.text}, nil
+	case tokOp:
+		if t.text == "(" {
+			n, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.isOp(")"); !ok {
+				return nil, fmt.Errorf("expr: missing )")
+			}
+			p.next()
+			return n, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("expr: unexpected end of expression")
+	}
+	return nil, fmt.Errorf("expr: unexpected %q", t.text)
+}
+
+func (p *parser) call(name string) (node, error) {
+	fn, ok := Functions[name]
+	if !ok {
+		return nil, fmt.Errorf("expr: unknown function %s", name)
+	}
+	p.next() // (
+
+	var args []node
+	if _, ok := p.isOp(")"); !ok {
+		for {
+			arg, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.isOp(","); ok {
+				p.next()
+				continue
+			}
+			break
+		}
+	}
+	if _, ok := p.isOp(")"); !ok {
+		return nil, fmt.Errorf("expr: missing ) after %s arguments", name)
+	}
+	p.next()
+	return call{name: name, fn: fn, args: args}, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// FaultConfig is a fault injected at one point of the store, as set and
+// listed by /admin/faults in builds with the chaos tag
+type FaultConfig struct {
+	Point       string  `json:"point" binding:"required"` // sync, index or flush
+	Delay       string  `json:"delay,omitempty"`          // e.g. 500ms
+	Fail        bool    `json:"fail,omitempty"`
+	Probability float64 `json:"probability,omitempty"`
+	Count       int     `json:"count,omitempty"`
+	Triggered   int     `json:"triggered"`
+}
+
+func faultConfig(f storage.Fault) FaultConfig {
+	config := FaultConfig{
+		Point:       string(f.Point),
+		Fail:        f.Fail,
+		Probability: f.Probability,
+		Count:       f.Count,
+		Triggered:   f.Triggered,
+	}
+	if f.Delay > 0 {
+		config.Delay = f.Delay.String()
+	}
+	return config
+}
+
+func handleFaults() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		faults := storage.Faults()
+		configs := make([]FaultConfig, len(faults))
+		for i, f := range faults {
+			configs[i] = faultConfig(f)
+		}
+		c.JSON(http.StatusOK, configs)
+	}
+}
+
+func handleSetFault() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req FaultConfig
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		fault := storage.Fault{
+			Point:       storage.FaultPoint(req.Point),
+			Fail:        req.Fail,
+			Probability: req.Probability,
+			Count:       req.Count,
+		}
+		if req.Delay != "" {
+			delay, err := time.ParseDuration(req.Delay)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid delay %q", req.Delay))
+				return
+			}
+			fault.Delay = delay
+		}
+
+		if err := storage.SetFault(fault); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// handleClearFaults removes the fault at ?point=, or every fault
+func handleClearFaults() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if point := c.Query("point"); point != "" {
+			storage.ClearFault(storage.FaultPoint(point))
+		} else {
+			storage.ClearFaults()
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
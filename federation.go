@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// federatedHeader marks searches forwarded by a federating node. Peers
+// answer them from their own data only, so peers that federate too do not
+// forward them again.
+const federatedHeader = "X-SearchYAML-Federated"
+
+// federationFailedHeader lists the peers that failed to answer a federated
+// search; the results come from the others
+const federationFailedHeader = "X-Federation-Failed"
+
+// federatedTenantHeader is set by peers on their answers to federated
+// searches to the tenant whose data they searched
+const federatedTenantHeader = "X-SearchYAML-Tenant"
+
+// Federation fans searches out to peer servers and merges their results
+// with the local ones
+type Federation struct {
+	peers  []string
+	apiKey string
+	client *http.Client
+}
+
+// NewFederation returns a federation over a comma-separated list of peer
+// URLs, or nil when the list is empty
+func NewFederation(peerList string, timeout time.Duration, apiKey string) *Federation {
+	var peers []string
+	for _, peer := range strings.Split(peerList, ",") {
+		if peer = strings.TrimRight(strings.TrimSpace(peer), "/"); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+	return &Federation{
+		peers:  peers,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// search runs query on every peer concurrently and returns the results of
+// each peer that answered, and the peers that did not. Peers search the
+// tenant of the request, which is named in X-Tenant. Tenants that require an
+// API key are not federated, since the peer API key cannot authorize them.
+func (f *Federation) search(c *gin.Context, query storage.SearchQuery) ([][]storage.SearchResult, []string) {
+	tenant := tenantName(c)
+	if v, exists := c.Get("tenant"); exists && tenant != DefaultTenant && len(v.(*Tenant).Config.APIKeys) > 0 {
+		log.Printf("Not federating search of tenant %s, which requires an API key", tenant)
+		return nil, f.peers
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, f.peers
+	}
+
+	results := make([][]storage.SearchResult, len(f.peers))
+	errs := make([]error, len(f.peers))
+	var wg sync.WaitGroup
+	for i, peer := range f.peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			results[i], errs[i] = f.searchPeer(c.Request.Context(), peer, body, tenant)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	var answered [][]storage.SearchResult
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("Federated search on %s failed: %v", f.peers[i], err)
+			failed = append(failed, f.peers[i])
+			continue
+		}
+		answered = append(answered, results[i])
+	}
+	return answered, failed
+}
+
+func (f *Federation) searchPeer(ctx context.Context, peer string, body []byte, tenant string) ([]storage.SearchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+"/search/combined", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(federatedHeader, "true")
+	if f.apiKey != "" {
+		req.Header.Set("X-API-Key", f.apiKey)
+	}
+	req.Header.Set("X-Tenant", tenant)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Message != "" {
+			return nil, fmt.Errorf("status %d: %s", resp.StatusCode, e.Message)
+		}
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	// A peer without the tenant, or whose API key belongs to another
+	// tenant, would answer from the wrong data
+	if answered := resp.Header.Get(federatedTenantHeader); answered != tenant {
+		return nil, fmt.Errorf("peer searched tenant %q instead of %q", answered, tenant)
+	}
+
+	var results []storage.SearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Source = peer
+	}
+	return results, nil
+}
+
+// mergeFederated merges the results of several servers. Each server's
+// combined scores are divided by its best score, so servers whose data
+// scores differently contribute their best matches alike. A key returned by
+// several servers is kept once with its best normalized score. Results are
+// ordered by score, ties broken by key, and cut to maxResults.
+func mergeFederated(sources [][]storage.SearchResult, maxResults int) []storage.SearchResult {
+	best := make(map[string]int)
+	var merged []storage.SearchResult
+	for _, results := range sources {
+		var top float64
+		for _, r := range results {
+			top = max(top, r.Combined)
+		}
+
+		for _, r := range results {
+			if top > 0 {
+				r.Combined /= top
+			}
+			if i, ok := best[r.Key]; ok {
+				if r.Combined > merged[i].Combined {
+					merged[i] = r
+				}
+				continue
+			}
+			best[r.Key] = len(merged)
+			merged = append(merged, r)
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Combined != merged[j].Combined {
+			return merged[i].Combined > merged[j].Combined
+		}
+		return merged[i].Key < merged[j].Key
+	})
+	if maxResults > 0 && len(merged) > maxResults {
+		merged = merged[:maxResults]
+	}
+	return merged
+}
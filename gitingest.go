@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// gitField is the field added to ingested documents recording their origin
+const gitField = "_git"
+
+// defaultGitPaths are ingested when a request does not specify paths
+var defaultGitPaths = []string{"**/*.yaml", "**/*.yml"}
+
+// GitIngestRequest is the body of POST /admin/ingest/git
+type GitIngestRequest struct {
+	URL     string   `json:"url" yaml:"url"`
+	Branch  string   `json:"branch,omitempty" yaml:"branch,omitempty"`
+	Paths   []string `json:"paths,omitempty" yaml:"paths,omitempty"`     // Globs relative to the repository root; ** matches any number of directories
+	Prefix  string   `json:"prefix,omitempty" yaml:"prefix,omitempty"`   // Key prefix, defaults to "<repo name>/"
+	Refresh string   `json:"refresh,omitempty" yaml:"refresh,omitempty"` // Refresh interval, e.g. "15m"; empty ingests once
+}
+
+// GitSourceStatus describes an ingested repository
+type GitSourceStatus struct {
+	GitIngestRequest
+	Tenant    string    `json:"tenant"`
+	Commit    string    `json:"commit,omitempty"`
+	Entries   int       `json:"entries"`
+	LastSync  time.Time `json:"last_sync,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// gitSource is a repository mirrored into a store
+type gitSource struct {
+	mu       sync.Mutex
+	store    *storage.Store
+	dir      string
+	patterns []*regexp.Regexp
+	status   GitSourceStatus
+	stop     chan struct{}
+}
+
+// GitIngester clones repositories and ingests their YAML files
+type GitIngester struct {
+	sync.Mutex
+	cacheDir string
+	sources  map[string]*gitSource // tenant + url + branch -> source
+}
+
+// NewGitIngester creates an ingester that keeps clones under cacheDir
+func NewGitIngester(cacheDir string) *GitIngester {
+	return &GitIngester{cacheDir: cacheDir, sources: make(map[string]*gitSource)}
+}
+
+var scpLikeURL = regexp.MustCompile(`^[\w.-]+@[\w.-]+:[\w./~-]+$`)
+
+// validateGitURL only allows network and file transports; helpers such as
+// ext:: could otherwise run arbitrary commands
+func validateGitURL(url string) error {
+	for _, scheme := range []string{"https://", "http://", "ssh://", "git://", "file://"} {
+		if strings.HasPrefix(url, scheme) {
+			return nil
+		}
+	}
+	if scpLikeURL.MatchString(url) {
+		return nil
+	}
+	return fmt.Errorf("unsupported repository url: %s", url)
+}
+
+// globPattern converts a slash-separated glob with ** support to a regexp
+func globPattern(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				b.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func repoName(url string) string {
+	name := path.Base(strings.TrimSuffix(strings.TrimRight(url, "/"), ".git"))
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// Ingest clones or updates a repository and ingests it into store. With a
+// refresh interval the repository is re-synced in the background until it
+// is ingested again with different settings.
+func (g *GitIngester) Ingest(store *storage.Store, tenant string, req GitIngestRequest) (GitSourceStatus, error) {
+	if err := validateGitURL(req.URL); err != nil {
+		return GitSourceStatus{}, err
+	}
+	if len(req.Paths) == 0 {
+		req.Paths = defaultGitPaths
+	}
+	if req.Prefix == "" {
+		req.Prefix = repoName(req.URL) + "/"
+	}
+
+	var interval time.Duration
+	if req.Refresh != "" {
+		var err error
+		if interval, err = time.ParseDuration(req.Refresh); err != nil || interval < time.Second {
+			return GitSourceStatus{}, fmt.Errorf("invalid refresh interval: %s", req.Refresh)
+		}
+	}
+
+	patterns := make([]*regexp.Regexp, 0, len(req.Paths))
+	for _, glob := range req.Paths {
+		re, err := globPattern(glob)
+		if err != nil {
+			return GitSourceStatus{}, fmt.Errorf("invalid path glob %s: %v", glob, err)
+		}
+		patterns = append(patterns, re)
+	}
+
+	id := tenant + "\x00" + req.URL + "\x00" + req.Branch
+	sum := sha1.Sum([]byte(id))
+
+	source := &gitSource{
+		store:    store,
+		dir:      filepath.Join(g.cacheDir, hex.EncodeToString(sum[:8])),
+		patterns: patterns,
+		status:   GitSourceStatus{GitIngestRequest: req, Tenant: tenant},
+	}
+	if interval > 0 {
+		source.stop = make(chan struct{})
+	}
+
+	g.Lock()
+	if previous, exists := g.sources[id]; exists && previous.stop != nil {
+		close(previous.stop)
+	}
+	g.sources[id] = source
+	g.Unlock()
+
+	err := source.sync()
+	if interval > 0 {
+		go source.refresh(interval)
+	}
+	return source.snapshot(), err
+}
+
+// Sources returns the status of every ingested repository
+func (g *GitIngester) Sources() []GitSourceStatus {
+	g.Lock()
+	defer g.Unlock()
+
+	statuses := make([]GitSourceStatus, 0, len(g.sources))
+	for _, source := range g.sources {
+		statuses = append(statuses, source.snapshot())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].URL < statuses[j].URL
+	})
+	return statuses
+}
+
+func (s *gitSource) snapshot() GitSourceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *gitSource) refresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.sync(); err != nil {
+				log.Printf("Git refresh of %s failed: %v", s.status.URL, err)
+			}
+		}
+	}
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-c", "protocol.ext.allow=never"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// checkout clones the repository or fetches the latest commit of the branch
+func (s *gitSource) checkout() (string, error) {
+	req := s.status.GitIngestRequest
+
+	if _, err := os.Stat(filepath.Join(s.dir, ".git")); err != nil {
+		if err := os.MkdirAll(filepath.Dir(s.dir), 0755); err != nil {
+			return "", fmt.Errorf("failed to create git cache: %v", err)
+		}
+		args := []string{"clone", "--depth", "1", "--single-branch"}
+		if req.Branch != "" {
+			args = append(args, "--branch", req.Branch)
+		}
+		if _, err := runGit("", append(args, "--", req.URL, s.dir)...); err != nil {
+			os.RemoveAll(s.dir)
+			return "", err
+		}
+	} else {
+		ref := req.Branch
+		if ref == "" {
+			ref = "HEAD"
+		}
+		if _, err := runGit(s.dir, "fetch", "--depth", "1", "origin", ref); err != nil {
+			return "", err
+		}
+		if _, err := runGit(s.dir, "reset", "--hard", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+	}
+
+	return runGit(s.dir, "rev-parse", "HEAD")
+}
+
+func (s *gitSource) matches(rel string) bool {
+	for _, re := range s.patterns {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// sync checks out the latest commit, stores every matching YAML document and
+// deletes entries from this repository that no longer exist
+func (s *gitSource) sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.ingest()
+	s.status.LastSync = time.Now().UTC()
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	return err
+}
+
+func (s *gitSource) ingest() error {
+	commit, err := s.checkout()
+	if err != nil {
+		return err
+	}
+	req := s.status.GitIngestRequest
+
+	loaded := make(map[string]struct{})
+	err = filepath.WalkDir(s.dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if p != s.dir && skipDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !s.matches(rel) {
+			return nil
+		}
+
+		docs, err := readYAMLDocuments(p)
+		if err != nil {
+			log.Printf("Git ingest: %s: %v", rel, err)
+			return nil
+		}
+
+		for i, doc := range docs {
+			key := req.Prefix + rel
+			if len(docs) > 1 {
+				key = fmt.Sprintf("%s#%d", key, i)
+			}
+
+			origin := map[string]interface{}{
+				"repo":   req.URL,
+				"branch": req.Branch,
+				"path":   rel,
+				"commit": commit,
+			}
+			if err := s.store.Set(key, withGitOrigin(doc, origin)); err != nil {
+				return fmt.Errorf("failed to store %s: %v", key, err)
+			}
+			loaded[key] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove entries from earlier commits whose files were deleted or renamed
+	var stale []string
+	s.store.Range(func(key string, entry *storage.Entry) bool {
+		if _, ok := loaded[key]; ok || !strings.HasPrefix(key, req.Prefix) {
+			return true
+		}
+		if m, ok := entry.Value.(map[string]interface{}); ok {
+			if origin, ok := m[gitField].(map[string]interface{}); ok && origin["repo"] == req.URL && origin["branch"] == req.Branch {
+				stale = append(stale, key)
+			}
+		}
+		return true
+	})
+	for _, key := range stale {
+		s.store.Delete(key)
+	}
+
+	s.status.Commit = commit
+	s.status.Entries = len(loaded)
+	log.Printf("Ingested %d documents from %s at %s", len(loaded), req.URL, commit)
+	return nil
+}
+
+// readYAMLDocuments decodes every document of a (possibly multi-document)
+// YAML file within the configured decode limits
+func readYAMLDocuments(p string) ([]interface{}, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	limits := decodeLimits()
+	raw, err := limits.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []interface{}
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			return nil, err
+		}
+		if err := limits.Check(&node); err != nil {
+			return nil, err
+		}
+
+		var doc interface{}
+		if err := node.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// withGitOrigin records the origin of a document in its _git field. Documents
+// that are not maps are wrapped as {"_git": ..., "content": doc}.
+func withGitOrigin(doc interface{}, origin map[string]interface{}) interface{} {
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{gitField: origin, "content": doc}
+	}
+	m[gitField] = origin
+	return m
+}
+
+func handleGitIngest(ingester *GitIngester, store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		var req GitIngestRequest
+		if err := parseRequestBody(c, &req); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		if req.URL == "" {
+			respondError(c, 400, CodeInvalidRequest, "url is required")
+			return
+		}
+
+		status, err := ingester.Ingest(store, tenantName(c), req)
+		if err != nil {
+			if status.URL == "" {
+				respondBadRequest(c, err)
+			} else {
+				respondErrorDetails(c, 502, CodeUpstreamFailed, err.Error(), gin.H{"source": status})
+			}
+			return
+		}
+
+		c.JSON(200, status)
+	}
+}
+
+func handleGitSources(ingester *GitIngester) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := tenantName(c)
+
+		var statuses []GitSourceStatus
+		for _, status := range ingester.Sources() {
+			if status.Tenant == tenant {
+				statuses = append(statuses, status)
+			}
+		}
+		c.JSON(200, statuses)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// linksSuffix is the last path segment addressing the links of the key
+// before it, e.g. GET /data/actors/apt28/_links
+const linksSuffix = "/_links"
+
+// maxLinks is the number of links an entry may carry
+const maxLinks = 1000
+
+// linkTypePattern is what link types may look like, e.g. uses or
+// attributed-to
+var linkTypePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// LinkRequest is the body of POST /data/:key/_links: one link as type and
+// target, or several as links
+type LinkRequest struct {
+	Type   string         `json:"type"`
+	Target string         `json:"target"`
+	Links  []storage.Link `json:"links"`
+}
+
+// LinksResponse is the result of GET /data/:key/_links
+type LinksResponse struct {
+	Key      string         `json:"key" yaml:"key"`
+	Links    []storage.Link `json:"links" yaml:"links"`       // From the key to others
+	Incoming []storage.Edge `json:"incoming" yaml:"incoming"` // From readable keys to the key
+}
+
+// handleLinks serves /data/:key/_links paths and passes others to next
+func handleLinks(store *storage.Store, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := strings.CutSuffix(dataKey(c), linksSuffix)
+		if !ok {
+			next(c)
+			return
+		}
+		if isDirectory(key) {
+			respondError(c, 400, CodeInvalidKey, "invalid key: directories have no links")
+			return
+		}
+
+		store := tenantStore(c, store)
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			readLinks(c, store, key)
+		case http.MethodPost:
+			addLinks(c, store, key)
+		case http.MethodDelete:
+			removeLinks(c, store, key)
+		}
+	}
+}
+
+func readLinks(c *gin.Context, store *storage.Store, key string) {
+	if !canRead(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	links, incoming, exists := store.Links(key)
+	if !exists {
+		respondError(c, 404, CodeNotFound, "key not found")
+		return
+	}
+	if t := c.Query("type"); t != "" {
+		links = filterLinks(links, func(l storage.Link) bool { return l.Type == t })
+		incoming = filterEdges(incoming, func(e storage.Edge) bool { return e.Type == t })
+	}
+	// Links from keys the caller cannot read would reveal those keys
+	incoming = filterEdges(incoming, func(e storage.Edge) bool { return canRead(c, e.Source) })
+
+	response := LinksResponse{Key: key, Links: links, Incoming: incoming}
+	if c.GetHeader("Accept") == "application/x-yaml" {
+		c.YAML(200, response)
+	} else {
+		c.JSON(200, response)
+	}
+}
+
+func addLinks(c *gin.Context, store *storage.Store, key string) {
+	if !canWrite(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	if !checkWritableKey(c, key) {
+		return
+	}
+	var request LinkRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+	links := request.Links
+	if request.Type != "" || request.Target != "" {
+		links = append(links, storage.Link{Type: request.Type, Target: request.Target})
+	}
+	if len(links) == 0 {
+		respondError(c, 400, CodeInvalidRequest, "give a link as type and target, or several as links")
+		return
+	}
+	for _, link := range links {
+		if !linkTypePattern.MatchString(link.Type) {
+			respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("invalid link type %q: use up to 64 letters, digits and _.:-", link.Type))
+			return
+		}
+		if link.Target == "" || isDirectory(link.Target) {
+			respondError(c, 400, CodeInvalidKey, fmt.Sprintf("invalid link target %q", link.Target))
+			return
+		}
+	}
+
+	added, err := store.AddLinks(key, links, maxLinks)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"status": "ok", "added": added})
+}
+
+func removeLinks(c *gin.Context, store *storage.Store, key string) {
+	if !canWrite(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	if !checkWritableKey(c, key) {
+		return
+	}
+	linkType, target := c.Query("type"), c.Query("target")
+	if linkType == "" && target == "" {
+		respondError(c, 400, CodeInvalidRequest, "give the type, the target or both of the links to remove")
+		return
+	}
+
+	removed, err := store.RemoveLinks(key, func(l storage.Link) bool {
+		return (linkType == "" || l.Type == linkType) && (target == "" || l.Target == target)
+	})
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"status": "ok", "removed": removed})
+}
+
+func filterLinks(links []storage.Link, keep func(storage.Link) bool) []storage.Link {
+	kept := []storage.Link{}
+	for _, l := range links {
+		if keep(l) {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+func filterEdges(edges []storage.Edge, keep func(storage.Edge) bool) []storage.Edge {
+	kept := []storage.Edge{}
+	for _, e := range edges {
+		if keep(e) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// handleTraverse walks the links from some keys, returning the readable
+// keys reached and the links between them. Values are redacted.
+func handleTraverse(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var query storage.TraverseQuery
+		if err := c.ShouldBindJSON(&query); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		graph, err := store.Traverse(query, func(key string) bool { return canRead(c, key) })
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		for i := range graph.Nodes {
+			graph.Nodes[i].Value = redactValue(c, graph.Nodes[i].Value)
+		}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, graph)
+		} else {
+			c.JSON(200, graph)
+		}
+	}
+}
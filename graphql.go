@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/expr"
+	"github.com/threatflux/searchyaml/graphql"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// graphQLEntry is the source of the Entry type
+type graphQLEntry struct {
+	key   string
+	entry *storage.Entry
+}
+
+// graphQLError returns an error of a resolver with the code REST responses
+// give it, or nil
+func graphQLError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &graphql.Error{Message: err.Error(), Extensions: map[string]interface{}{"code": errorCode(err)}}
+}
+
+// graphQLEntryOf returns the source of an Entry, or nil
+func graphQLEntryOf(key string, entry *storage.Entry) interface{} {
+	if entry == nil {
+		return nil
+	}
+	return graphQLEntry{key, entry}
+}
+
+// jsonScalar carries documents and other free-form values as JSON
+var jsonScalar = graphql.NewScalar("JSON", "Any JSON value", func(v interface{}) (interface{}, error) {
+	return v, nil
+}, func(v interface{}) (interface{}, error) {
+	return v, nil
+})
+
+// lookupPath resolves a dotted path within a document, or returns the
+// document for an empty path
+func lookupPath(value interface{}, path string) interface{} {
+	if path == "" {
+		return value
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return expr.Lookup(m, path)
+}
+
+// pathField returns the field(path:) of a type, which selects part of the
+// document of the source instead of all of it
+func pathField(value func(source interface{}) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Name:        "field",
+		Description: "The value at a dotted path of the document, e.g. meta.author",
+		Args:        []*graphql.Argument{{Name: "path", Type: graphql.NonNull(graphql.String)}},
+		Type:        jsonScalar,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return lookupPath(value(p.Source), p.Args["path"].(string)), nil
+		},
+	}
+}
+
+// entryField returns a field of Entry computed from the entry
+func entryField(name, description string, t *graphql.Type, value func(e graphQLEntry) interface{}) *graphql.Field {
+	return &graphql.Field{Name: name, Description: description, Type: t, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return value(p.Source.(graphQLEntry)), nil
+	}}
+}
+
+// formatUnix formats seconds since the epoch as RFC 3339
+func formatUnix(seconds int64) string {
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+var graphQLEntryType = graphql.NewObject("Entry", "A stored document",
+	entryField("key", "", graphql.NonNull(graphql.String), func(e graphQLEntry) interface{} { return e.key }),
+	entryField("value", "The whole document", jsonScalar, func(e graphQLEntry) interface{} { return e.entry.Value }),
+	pathField(func(source interface{}) interface{} { return source.(graphQLEntry).entry.Value }),
+	entryField("updatedAt", "When the entry was last written, in RFC 3339", graphql.String, func(e graphQLEntry) interface{} {
+		if e.entry.Timestamp == 0 {
+			return nil
+		}
+		return formatUnix(e.entry.Timestamp)
+	}),
+	entryField("expiresAt", "When the entry expires, in RFC 3339, or null", graphql.String, func(e graphQLEntry) interface{} {
+		if e.entry.TTL <= 0 {
+			return nil
+		}
+		return formatUnix(e.entry.Timestamp + e.entry.TTL)
+	}),
+	entryField("hash", "The content hash of the value when it was written", graphql.String, func(e graphQLEntry) interface{} {
+		return optionalString(e.entry.Hash)
+	}),
+	entryField("contentType", "", graphql.String, func(e graphQLEntry) interface{} {
+		if e.entry.Metadata == nil {
+			return nil
+		}
+		return optionalString(e.entry.Metadata.ContentType)
+	}),
+	entryField("createdBy", "The fingerprint of the API key that wrote the entry", graphql.String, func(e graphQLEntry) interface{} {
+		if e.entry.Metadata == nil {
+			return nil
+		}
+		return optionalString(e.entry.Metadata.CreatedBy)
+	}),
+	entryField("labels", "The name=value labels of the entry, as an object", jsonScalar, func(e graphQLEntry) interface{} {
+		if e.entry.Metadata == nil || len(e.entry.Metadata.Labels) == 0 {
+			return nil
+		}
+		return e.entry.Metadata.Labels
+	}),
+	entryField("parent", "The key of the parent document, set with X-Parent", graphql.String, func(e graphQLEntry) interface{} {
+		if e.entry.Metadata == nil {
+			return nil
+		}
+		return optionalString(e.entry.Metadata.Parent)
+	}),
+)
+
+// optionalString returns a string, or nil when it is empty
+func optionalString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// searchResultField returns a field of SearchResult computed from the result
+func searchResultField(name, description string, t *graphql.Type, value func(r storage.SearchResult) interface{}) *graphql.Field {
+	return &graphql.Field{Name: name, Description: description, Type: t, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return value(p.Source.(storage.SearchResult)), nil
+	}}
+}
+
+var graphQLSearchResultType = graphql.NewObject("SearchResult", "A search match, redacted for the caller",
+	searchResultField("key", "", graphql.NonNull(graphql.String), func(r storage.SearchResult) interface{} { return r.Key }),
+	searchResultField("score", "The combined score", graphql.NonNull(graphql.Float), func(r storage.SearchResult) interface{} { return r.Combined }),
+	searchResultField("textScore", "", graphql.Float, func(r storage.SearchResult) interface{} { return r.TextScore }),
+	searchResultField("vectorScore", "", graphql.Float, func(r storage.SearchResult) interface{} { return float64(r.VecScore) }),
+	searchResultField("value", "The whole document", jsonScalar, func(r storage.SearchResult) interface{} { return r.Value }),
+	pathField(func(source interface{}) interface{} { return source.(storage.SearchResult).Value }),
+	searchResultField("fields", "The computed fields requested by the search", jsonScalar, func(r storage.SearchResult) interface{} {
+		if len(r.Fields) == 0 {
+			return nil
+		}
+		return r.Fields
+	}),
+)
+
+var graphQLKeyPageType = graphql.NewObject("KeyPage", "A page of readable keys in sorted order",
+	&graphql.Field{Name: "keys", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(graphql.String)))},
+	&graphql.Field{Name: "next", Description: "Pass as after to fetch the next page, or null after the last", Type: graphql.String},
+)
+
+var graphQLQueryType = graphql.NewObject("Query", "",
+	&graphql.Field{
+		Name:        "entry",
+		Description: "The entry of a key, or null when it does not exist",
+		Args:        []*graphql.Argument{{Name: "key", Type: graphql.NonNull(graphql.String)}},
+		Type:        graphQLEntryType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			key := p.Args["key"].(string)
+			entry, err := p.Source.(*requestScope).get(key)
+			return graphQLEntryOf(key, entry), graphQLError(err)
+		},
+	},
+	&graphql.Field{
+		Name:        "entries",
+		Description: "The entries of several keys, in order, with null for keys that do not exist or are not readable",
+		Args:        []*graphql.Argument{{Name: "keys", Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(graphql.String)))}},
+		Type:        graphql.NonNull(graphql.ListOf(graphQLEntryType)),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			scope := p.Source.(*requestScope)
+			keys := p.Args["keys"].([]interface{})
+			entries := make([]interface{}, len(keys))
+			for i, key := range keys {
+				entry, _ := scope.get(key.(string))
+				entries[i] = graphQLEntryOf(key.(string), entry)
+			}
+			return entries, nil
+		},
+	},
+	&graphql.Field{
+		Name:        "keys",
+		Description: "A page of the readable keys starting with prefix",
+		Args: []*graphql.Argument{
+			{Name: "prefix", Type: graphql.String, Default: ""},
+			{Name: "after", Description: "Start after this key", Type: graphql.String, Default: ""},
+			{Name: "limit", Type: graphql.Int, Default: defaultKeyListLimit},
+		},
+		Type: graphql.NonNull(graphQLKeyPageType),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			prefix, _ := p.Args["prefix"].(string)
+			after, _ := p.Args["after"].(string)
+			limit, _ := p.Args["limit"].(int)
+			keys, next, err := p.Source.(*requestScope).keys(prefix, after, limit)
+			if err != nil {
+				return nil, graphQLError(err)
+			}
+			return map[string]interface{}{"keys": keys, "next": optionalString(next)}, nil
+		},
+	},
+	&graphql.Field{
+		Name:        "search",
+		Description: "Searches the indexes like POST /search/combined, returning the readable, redacted matches",
+		Args: []*graphql.Argument{
+			{Name: "text", Type: graphql.String},
+			{Name: "textFields", Description: "Restrict the text search to these indexed fields", Type: graphql.ListOf(graphql.NonNull(graphql.String))},
+			{Name: "vector", Type: graphql.ListOf(graphql.NonNull(graphql.Float))},
+			{Name: "filters", Description: "Field to value, or list of values, on keyword and numeric indexes", Type: jsonScalar},
+			{Name: "prefixes", Description: "Field to prefix of its value on keyword indexes", Type: jsonScalar},
+			{Name: "maxResults", Type: graphql.Int},
+			{Name: "minScore", Type: graphql.Float},
+			{Name: "expr", Description: "Filter expression, e.g. value.price * value.qty > 100", Type: graphql.String},
+			{Name: "fields", Description: "Computed fields, name to expression", Type: jsonScalar},
+			{Name: "sample", Description: "Return this many matches chosen at random instead of the top scored", Type: graphql.Int},
+		},
+		Type: graphql.NonNull(graphql.ListOf(graphql.NonNull(graphQLSearchResultType))),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			query, err := graphQLSearchQuery(p.Args)
+			if err != nil {
+				return nil, graphQLError(&codedError{CodeInvalidQuery, err.Error()})
+			}
+			results, err := p.Source.(*requestScope).search(query)
+			return results, graphQLError(err)
+		},
+	},
+)
+
+// graphQLSearchQuery converts the arguments of the search field to a query.
+// The free-form arguments go through JSON, so they are checked as the REST
+// body of the same query would be.
+func graphQLSearchQuery(args map[string]interface{}) (storage.SearchQuery, error) {
+	var query storage.SearchQuery
+	fields := map[string]string{
+		"text": "text", "textFields": "text_fields", "vector": "vector", "filters": "filters",
+		"prefixes": "prefixes", "maxResults": "max_results", "minScore": "min_score",
+		"expr": "expr", "fields": "fields", "sample": "sample",
+	}
+	body := make(map[string]interface{})
+	for arg, name := range fields {
+		if v, ok := args[arg]; ok && v != nil {
+			body[name] = v
+		}
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return query, err
+	}
+	if err := json.Unmarshal(raw, &query); err != nil {
+		return query, fmt.Errorf("invalid search arguments: %v", err)
+	}
+	return query, nil
+}
+
+var graphQLMutationType = graphql.NewObject("Mutation", "",
+	&graphql.Field{
+		Name:        "set",
+		Description: "Writes a document like POST /data/{key}. Returns null when the pipeline drops it.",
+		Args: []*graphql.Argument{
+			{Name: "key", Type: graphql.NonNull(graphql.String)},
+			{Name: "value", Type: graphql.NonNull(jsonScalar)},
+			{Name: "ttl", Description: "Expire after this duration, e.g. 1h", Type: graphql.String},
+			{Name: "contentType", Type: graphql.String},
+			{Name: "labels", Description: "Label names to values", Type: jsonScalar},
+			{Name: "pipeline", Description: "The ingest pipeline to run the document through", Type: graphql.String},
+		},
+		Type: graphQLEntryType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			scope := p.Source.(*requestScope)
+			w := documentWrite{Key: p.Args["key"].(string), Value: p.Args["value"]}
+			w.TTL, _ = p.Args["ttl"].(string)
+			w.ContentType, _ = p.Args["contentType"].(string)
+			w.Pipeline, _ = p.Args["pipeline"].(string)
+			if labels := p.Args["labels"]; labels != nil {
+				var err error
+				if w.Labels, err = labelsArgument(labels); err != nil {
+					return nil, graphQLError(err)
+				}
+			}
+			stored, err := scope.set(w)
+			if err != nil || !stored {
+				return nil, graphQLError(err)
+			}
+			entry, err := scope.get(w.Key)
+			return graphQLEntryOf(w.Key, entry), graphQLError(err)
+		},
+	},
+	&graphql.Field{
+		Name:        "delete",
+		Description: "Deletes the entry of a key, returning whether it existed",
+		Args:        []*graphql.Argument{{Name: "key", Type: graphql.NonNull(graphql.String)}},
+		Type:        graphql.NonNull(graphql.Boolean),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			existed, err := p.Source.(*requestScope).delete(p.Args["key"].(string))
+			return existed, graphQLError(err)
+		},
+	},
+)
+
+// graphQLSchema is the schema served at /graphql
+var graphQLSchema = func() *graphql.Schema {
+	schema, err := graphql.NewSchema(graphQLQueryType, graphQLMutationType)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}()
+
+// handleGraphQL runs a GraphQL request against the tenant store. POST takes
+// {"query", "operationName", "variables"}; GET takes them as query
+// parameters and runs queries only. Errors of the document and its fields
+// are returned in the errors of a 200 response, as GraphQL clients expect.
+func handleGraphQL(store *storage.Store, pipelines *Pipelines) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request graphql.Request
+		if c.Request.Method == "GET" {
+			request.Query = c.Query("query")
+			request.OperationName = c.Query("operationName")
+			if variables := c.Query("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+					respondBadRequest(c, fmt.Errorf("invalid variables: %v", err))
+					return
+				}
+			}
+			request.QueryOnly = true
+		} else if err := c.ShouldBindJSON(&request); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		if request.Query == "" {
+			respondError(c, 400, CodeInvalidRequest, "query is required")
+			return
+		}
+
+		request.Root = newRequestScope(c, store, pipelines)
+		c.JSON(200, graphQLSchema.Execute(c.Request.Context(), request))
+	}
+}
+
+// handleGraphQLSchema returns the schema in the GraphQL schema definition
+// language, for code generators
+func handleGraphQLSchema() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.String(200, graphQLSchema.SDL())
+	}
+}
@@ -0,0 +1,728 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// fieldDef returns the definition of a field of t, including the
+// introspection fields of the query type
+func (s *Schema) fieldDef(t *Type, name string) *Field {
+	if t == s.Query {
+		switch name {
+		case "__schema":
+			return s.schemaField
+		case "__type":
+			return s.typeField
+		}
+	}
+	return t.field(name)
+}
+
+// typeOf returns the schema type of a variable's type
+func (s *Schema) typeOf(ref *typeRef) (*Type, error) {
+	var t *Type
+	if ref.list != nil {
+		inner, err := s.typeOf(ref.list)
+		if err != nil {
+			return nil, err
+		}
+		t = ListOf(inner)
+	} else {
+		var exists bool
+		if t, exists = s.types[ref.name]; !exists {
+			return nil, fmt.Errorf("unknown type %q", ref.name)
+		}
+	}
+	if ref.nonNull {
+		t = NonNull(t)
+	}
+	return t, nil
+}
+
+// validator checks a document against the schema before it runs, so a
+// mutation never runs halfway before an error in a later field is found
+type validator struct {
+	schema    *Schema
+	doc       *document
+	errors    []*Error
+	spreading map[string]bool // Fragments being validated, to find cycles
+}
+
+func (v *validator) errorf(loc Location, format string, args ...interface{}) {
+	v.errors = append(v.errors, errorAt(loc, format, args...))
+}
+
+func (v *validator) validate(op *operation, root *Type) {
+	seen := make(map[string]bool)
+	for _, def := range op.variables {
+		if seen[def.name] {
+			v.errorf(def.loc, "there can be only one variable named $%s", def.name)
+		}
+		seen[def.name] = true
+		t, err := v.schema.typeOf(def.typ)
+		if err != nil {
+			v.errorf(def.loc, "variable $%s: %v", def.name, err)
+		} else if !t.isInput() {
+			v.errorf(def.loc, "variable $%s cannot be of non-input type %s", def.name, t)
+		}
+	}
+	v.spreading = make(map[string]bool)
+	v.selections(root, op.selections, seen)
+}
+
+func (v *validator) selections(t *Type, selections []selection, variables map[string]bool) {
+	for _, s := range selections {
+		switch s := s.(type) {
+		case *field:
+			v.directives(s.directives, variables)
+			if s.name == "__typename" {
+				if len(s.selections) > 0 {
+					v.errorf(s.loc, "field __typename must not have a selection since type String! has no subfields")
+				}
+				continue
+			}
+			def := v.schema.fieldDef(t, s.name)
+			if def == nil {
+				v.errorf(s.loc, "cannot query field %q on type %q", s.name, t.Name)
+				continue
+			}
+			v.arguments(s, def, variables)
+			switch named := def.Type.named(); {
+			case def.Type.isLeaf() && len(s.selections) > 0:
+				v.errorf(s.loc, "field %q must not have a selection since type %s has no subfields", s.name, def.Type)
+			case !def.Type.isLeaf() && len(s.selections) == 0:
+				v.errorf(s.loc, "field %q of type %s must have a selection of subfields", s.name, def.Type)
+			case !def.Type.isLeaf():
+				v.selections(named, s.selections, variables)
+			}
+
+		case *fragmentSpread:
+			v.directives(s.directives, variables)
+			f, exists := v.doc.fragments[s.name]
+			switch {
+			case !exists:
+				v.errorf(s.loc, "unknown fragment %q", s.name)
+			case v.spreading[s.name]:
+				v.errorf(s.loc, "cannot spread fragment %q within itself", s.name)
+			case f.on != t.Name:
+				v.errorf(s.loc, "fragment %q on %s cannot be spread on type %s", s.name, f.on, t.Name)
+			default:
+				v.spreading[s.name] = true
+				v.selections(t, f.selections, variables)
+				delete(v.spreading, s.name)
+			}
+
+		case *inlineFragment:
+			v.directives(s.directives, variables)
+			if s.on != "" && s.on != t.Name {
+				v.errorf(s.loc, "fragment on %s cannot be spread on type %s", s.on, t.Name)
+				continue
+			}
+			v.selections(t, s.selections, variables)
+		}
+	}
+}
+
+func (v *validator) arguments(f *field, def *Field, variables map[string]bool) {
+	given := make(map[string]*argument, len(f.args))
+	for _, arg := range f.args {
+		given[arg.name] = arg
+		argDef := findArgument(def.Args, arg.name)
+		if argDef == nil {
+			v.errorf(arg.loc, "unknown argument %q on field %q", arg.name, def.Name)
+		}
+		if v.variablesDefined(arg.value, variables) && argDef != nil {
+			// Literals are checked now; variables once they are coerced
+			literals := &executor{schema: v.schema}
+			if _, err := literals.valueFromAST(argDef.Type, arg.value); err != nil {
+				v.errorf(arg.loc, "argument %q of field %q: %v", arg.name, def.Name, err)
+			}
+		}
+	}
+	for _, arg := range def.Args {
+		if _, exists := given[arg.Name]; !exists && arg.Type.Kind == KindNonNull && arg.Default == nil {
+			v.errorf(f.loc, "field %q argument %q of type %s is required, but it was not provided", def.Name, arg.Name, arg.Type)
+		}
+	}
+}
+
+// variablesDefined checks that the variables a value uses are defined by
+// the operation, and reports whether it is constant, using none
+func (v *validator) variablesDefined(val *value, variables map[string]bool) bool {
+	constant := true
+	switch val.kind {
+	case valueVariable:
+		if !variables[val.raw] {
+			v.errorf(val.loc, "variable $%s is not defined", val.raw)
+		}
+		constant = false
+	case valueList:
+		for _, item := range val.list {
+			constant = v.variablesDefined(item, variables) && constant
+		}
+	case valueObject:
+		for _, f := range val.fields {
+			constant = v.variablesDefined(f.value, variables) && constant
+		}
+	}
+	return constant
+}
+
+func (v *validator) directives(directives []*directive, variables map[string]bool) {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			v.errorf(d.loc, "unknown directive @%s", d.name)
+			continue
+		}
+		if len(d.args) != 1 || d.args[0].name != "if" {
+			v.errorf(d.loc, "directive @%s takes one argument, if: Boolean!", d.name)
+			continue
+		}
+		v.variablesDefined(d.args[0].value, variables)
+	}
+}
+
+func findArgument(args []*Argument, name string) *Argument {
+	for _, arg := range args {
+		if arg.Name == name {
+			return arg
+		}
+	}
+	return nil
+}
+
+type executor struct {
+	schema    *Schema
+	doc       *document
+	ctx       context.Context
+	variables map[string]interface{} // Provided or defaulted variables only
+	errors    []*Error
+}
+
+// fieldError records an error of a field
+func (e *executor) fieldError(f *field, path []interface{}, err error) {
+	out := &Error{Message: err.Error(), Locations: []Location{f.loc}, Path: path}
+	if resolverErr, ok := err.(*Error); ok {
+		out.Extensions = resolverErr.Extensions
+	}
+	e.errors = append(e.errors, out)
+}
+
+// withPath returns a copy of path with one more segment
+func withPath(path []interface{}, segment interface{}) []interface{} {
+	out := make([]interface{}, len(path), len(path)+1)
+	copy(out, path)
+	return append(out, segment)
+}
+
+// collectedFields are the fields of a selection set by response name, in
+// the order of their first occurrence
+type collectedFields struct {
+	names  []string
+	fields map[string][]*field
+}
+
+// collect gathers the fields selected on an object, following fragments
+// and the @skip and @include directives
+func (e *executor) collect(t *Type, selections []selection, out *collectedFields, visited map[string]bool) error {
+	for _, s := range selections {
+		switch s := s.(type) {
+		case *field:
+			if skip, err := e.skipped(s.directives); err != nil || skip {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if _, exists := out.fields[s.alias]; !exists {
+				out.names = append(out.names, s.alias)
+			} else if out.fields[s.alias][0].name != s.name {
+				return errorAt(s.loc, "fields %q and %q both return as %q; use aliases", out.fields[s.alias][0].name, s.name, s.alias)
+			}
+			out.fields[s.alias] = append(out.fields[s.alias], s)
+
+		case *fragmentSpread:
+			if skip, err := e.skipped(s.directives); err != nil || skip || visited[s.name] {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			visited[s.name] = true
+			if f := e.doc.fragments[s.name]; f.on == t.Name {
+				if err := e.collect(t, f.selections, out, visited); err != nil {
+					return err
+				}
+			}
+
+		case *inlineFragment:
+			if skip, err := e.skipped(s.directives); err != nil || skip {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if s.on == "" || s.on == t.Name {
+				if err := e.collect(t, s.selections, out, visited); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// skipped evaluates @skip(if:) and @include(if:)
+func (e *executor) skipped(directives []*directive) (bool, error) {
+	for _, d := range directives {
+		cond, err := e.valueFromAST(NonNull(Boolean), d.args[0].value)
+		if err != nil {
+			return false, errorAt(d.loc, "directive @%s: %v", d.name, err)
+		}
+		if b, _ := cond.(bool); b == (d.name == "skip") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// executeSelections resolves the fields selected on an object, returning
+// false when a non-null field failed, which makes the object null
+func (e *executor) executeSelections(t *Type, source interface{}, selections []selection, path []interface{}) (*orderedMap, bool) {
+	collected := &collectedFields{fields: make(map[string][]*field)}
+	if err := e.collect(t, selections, collected, make(map[string]bool)); err != nil {
+		e.errors = append(e.errors, asError(err))
+		return nil, false
+	}
+
+	result := &orderedMap{values: make(map[string]interface{}, len(collected.names))}
+	for _, name := range collected.names {
+		fields := collected.fields[name]
+		if fields[0].name == "__typename" {
+			result.set(name, t.Name)
+			continue
+		}
+		value, ok := e.executeField(t, source, fields, withPath(path, name))
+		if !ok {
+			return nil, false
+		}
+		result.set(name, value)
+	}
+	return result, true
+}
+
+func (e *executor) executeField(t *Type, source interface{}, fields []*field, path []interface{}) (interface{}, bool) {
+	f := fields[0]
+	def := e.schema.fieldDef(t, f.name)
+	nullable := def.Type.Kind != KindNonNull
+
+	args, err := e.arguments(def, f)
+	if err != nil {
+		e.fieldError(f, path, err)
+		return nil, nullable
+	}
+	var resolved interface{}
+	if def.Resolve != nil {
+		resolved, err = def.Resolve(ResolveParams{Context: e.ctx, Source: source, Args: args})
+		if err != nil {
+			e.fieldError(f, path, err)
+			return nil, nullable
+		}
+	} else if m, ok := source.(map[string]interface{}); ok {
+		resolved = m[def.Name]
+	}
+	return e.complete(def.Type, fields, path, resolved)
+}
+
+// complete converts a resolved value to the output of its type. A null
+// caused by an error in a non-null position is returned as false, and
+// stops at the nearest nullable type, which becomes null.
+func (e *executor) complete(t *Type, fields []*field, path []interface{}, v interface{}) (interface{}, bool) {
+	out, ok := e.completeValue(t, fields, path, v)
+	if !ok && t.Kind != KindNonNull {
+		return nil, true
+	}
+	return out, ok
+}
+
+func (e *executor) completeValue(t *Type, fields []*field, path []interface{}, v interface{}) (interface{}, bool) {
+	if t.Kind == KindNonNull {
+		out, ok := e.completeValue(t.OfType, fields, path, v)
+		if !ok {
+			return nil, false
+		}
+		if out == nil {
+			e.fieldError(fields[0], path, fmt.Errorf("cannot return null for non-nullable field %s", fields[0].name))
+			return nil, false
+		}
+		return out, true
+	}
+	if isNull(v) {
+		return nil, true
+	}
+
+	switch t.Kind {
+	case KindList:
+		list := reflect.ValueOf(v)
+		if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+			e.fieldError(fields[0], path, fmt.Errorf("expected a list for field %s, got %T", fields[0].name, v))
+			return nil, false
+		}
+		out := make([]interface{}, list.Len())
+		for i := range out {
+			item, ok := e.complete(t.OfType, fields, withPath(path, i), list.Index(i).Interface())
+			if !ok {
+				return nil, false
+			}
+			out[i] = item
+		}
+		return out, true
+
+	case KindScalar:
+		out, err := t.Serialize(v)
+		if err != nil {
+			e.fieldError(fields[0], path, err)
+			return nil, false
+		}
+		return out, true
+
+	case KindEnum:
+		s, ok := v.(string)
+		if !ok || !containsString(t.EnumValues, s) {
+			e.fieldError(fields[0], path, fmt.Errorf("enum %s cannot represent %v", t.Name, v))
+			return nil, false
+		}
+		return s, true
+
+	case KindObject:
+		var selections []selection
+		for _, f := range fields {
+			selections = append(selections, f.selections...)
+		}
+		out, ok := e.executeSelections(t, v, selections, path)
+		if !ok {
+			return nil, false
+		}
+		return out, true
+	}
+	e.fieldError(fields[0], path, fmt.Errorf("cannot return a value of type %s", t))
+	return nil, false
+}
+
+// isNull reports whether a resolved value is nil, or a nil pointer, map or
+// slice
+func isNull(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// arguments coerces the arguments of a field, applying defaults
+func (e *executor) arguments(def *Field, f *field) (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(def.Args))
+	for _, arg := range def.Args {
+		var given *value
+		for _, a := range f.args {
+			if a.name == arg.Name {
+				given = a.value
+			}
+		}
+		if given != nil && given.kind == valueVariable {
+			if _, provided := e.variables[given.raw]; !provided {
+				given = nil
+			}
+		}
+		if given == nil {
+			if arg.Default != nil {
+				args[arg.Name] = arg.Default
+			} else if arg.Type.Kind == KindNonNull {
+				return nil, fmt.Errorf("argument %q of type %s is required", arg.Name, arg.Type)
+			}
+			continue
+		}
+		v, err := e.valueFromAST(arg.Type, given)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %v", arg.Name, err)
+		}
+		args[arg.Name] = v
+	}
+	return args, nil
+}
+
+// valueFromAST coerces a literal, or a variable in it, to an input type
+func (e *executor) valueFromAST(t *Type, v *value) (interface{}, error) {
+	if v.kind == valueVariable {
+		value := e.variables[v.raw]
+		if value == nil && t.Kind == KindNonNull {
+			return nil, fmt.Errorf("variable $%s of a non-null position is null", v.raw)
+		}
+		return value, nil
+	}
+	if v.kind == valueNull {
+		if t.Kind == KindNonNull {
+			return nil, fmt.Errorf("expected a value of type %s, found null", t)
+		}
+		return nil, nil
+	}
+
+	switch t.Kind {
+	case KindNonNull:
+		return e.valueFromAST(t.OfType, v)
+
+	case KindList:
+		if v.kind != valueList {
+			item, err := e.valueFromAST(t.OfType, v)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		out := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			coerced, err := e.valueFromAST(t.OfType, item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = coerced
+		}
+		return out, nil
+
+	case KindInputObject:
+		if v.kind != valueObject {
+			return nil, fmt.Errorf("expected an object of type %s", t.Name)
+		}
+		given := make(map[string]*value, len(v.fields))
+		for _, f := range v.fields {
+			if findArgument(t.InputFields, f.name) == nil {
+				return nil, fmt.Errorf("unknown field %q of type %s", f.name, t.Name)
+			}
+			given[f.name] = f.value
+		}
+		out := make(map[string]interface{})
+		for _, f := range t.InputFields {
+			fieldValue, exists := given[f.Name]
+			if exists && fieldValue.kind == valueVariable {
+				_, exists = e.variables[fieldValue.raw]
+			}
+			if !exists {
+				if f.Default != nil {
+					out[f.Name] = f.Default
+				} else if f.Type.Kind == KindNonNull {
+					return nil, fmt.Errorf("field %q of type %s is required", f.Name, t.Name)
+				}
+				continue
+			}
+			coerced, err := e.valueFromAST(f.Type, fieldValue)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %v", f.Name, err)
+			}
+			out[f.Name] = coerced
+		}
+		return out, nil
+
+	case KindEnum:
+		if v.kind != valueEnum || !containsString(t.EnumValues, v.raw) {
+			return nil, fmt.Errorf("enum %s has no value %s", t.Name, v.raw)
+		}
+		return v.raw, nil
+	}
+
+	literal, err := e.literal(v)
+	if err != nil {
+		return nil, err
+	}
+	return t.ParseValue(literal)
+}
+
+// literal converts a literal to the value JSON would decode it to: numbers
+// as float64, lists and objects as []interface{} and maps
+func (e *executor) literal(v *value) (interface{}, error) {
+	switch v.kind {
+	case valueVariable:
+		return e.variables[v.raw], nil
+	case valueInt, valueFloat:
+		return strconv.ParseFloat(v.raw, 64)
+	case valueBoolean:
+		return v.raw == "true", nil
+	case valueNull:
+		return nil, nil
+	case valueString, valueEnum:
+		return v.raw, nil
+	case valueList:
+		out := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			converted, err := e.literal(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = converted
+		}
+		return out, nil
+	}
+	out := make(map[string]interface{}, len(v.fields))
+	for _, f := range v.fields {
+		converted, err := e.literal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		out[f.name] = converted
+	}
+	return out, nil
+}
+
+// coerceVariables coerces the variables of a request, decoded from JSON, to
+// the types the operation declares, applying its defaults
+func coerceVariables(op *operation, provided map[string]interface{}, s *Schema) (map[string]interface{}, error) {
+	literals := &executor{schema: s}
+	variables := make(map[string]interface{}, len(op.variables))
+	for _, def := range op.variables {
+		t, _ := s.typeOf(def.typ) // Checked by the validator
+		v, exists := provided[def.name]
+		if !exists {
+			if def.fallback != nil {
+				coerced, err := literals.valueFromAST(t, def.fallback)
+				if err != nil {
+					return nil, errorAt(def.loc, "variable $%s has an invalid default value: %v", def.name, err)
+				}
+				variables[def.name] = coerced
+			} else if t.Kind == KindNonNull {
+				return nil, errorAt(def.loc, "variable $%s of required type %s was not provided", def.name, t)
+			}
+			continue
+		}
+		coerced, err := coerceInput(t, v)
+		if err != nil {
+			return nil, errorAt(def.loc, "variable $%s got an invalid value: %v", def.name, err)
+		}
+		variables[def.name] = coerced
+	}
+	return variables, nil
+}
+
+// coerceInput coerces a value decoded from JSON to an input type
+func coerceInput(t *Type, v interface{}) (interface{}, error) {
+	if v == nil {
+		if t.Kind == KindNonNull {
+			return nil, fmt.Errorf("expected a value of type %s, found null", t)
+		}
+		return nil, nil
+	}
+	switch t.Kind {
+	case KindNonNull:
+		return coerceInput(t.OfType, v)
+
+	case KindList:
+		list, ok := v.([]interface{})
+		if !ok {
+			item, err := coerceInput(t.OfType, v)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		out := make([]interface{}, len(list))
+		for i, item := range list {
+			coerced, err := coerceInput(t.OfType, item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %v", i, err)
+			}
+			out[i] = coerced
+		}
+		return out, nil
+
+	case KindInputObject:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object of type %s", t.Name)
+		}
+		for name := range m {
+			if findArgument(t.InputFields, name) == nil {
+				return nil, fmt.Errorf("unknown field %q of type %s", name, t.Name)
+			}
+		}
+		out := make(map[string]interface{})
+		for _, f := range t.InputFields {
+			fieldValue, exists := m[f.Name]
+			if !exists {
+				if f.Default != nil {
+					out[f.Name] = f.Default
+				} else if f.Type.Kind == KindNonNull {
+					return nil, fmt.Errorf("field %q of type %s is required", f.Name, t.Name)
+				}
+				continue
+			}
+			coerced, err := coerceInput(f.Type, fieldValue)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %v", f.Name, err)
+			}
+			out[f.Name] = coerced
+		}
+		return out, nil
+
+	case KindEnum:
+		s, ok := v.(string)
+		if !ok || !containsString(t.EnumValues, s) {
+			return nil, fmt.Errorf("enum %s has no value %v", t.Name, v)
+		}
+		return s, nil
+	}
+	return t.ParseValue(v)
+}
+
+// orderedMap is an object of a response, whose fields keep the order of the
+// selections when encoded
+type orderedMap struct {
+	names  []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(name string, v interface{}) {
+	if _, exists := m.values[name]; !exists {
+		m.names = append(m.names, name)
+	}
+	m.values[name] = v
+}
+
+// Get returns the value of a field of the object
+func (m *orderedMap) Get(name string) interface{} {
+	return m.values[name]
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range m.names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,434 @@
+// Package graphql implements the parts of GraphQL a server needs to answer
+// queries and mutations over a schema defined in Go: the query language with
+// variables, aliases, fragments and the @skip and @include directives,
+// validation of documents against the schema, execution with null
+// propagation, and introspection, so GraphQL clients and code generators can
+// read the schema. Subscriptions, interfaces and unions are not supported.
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Kind is the kind of a type, as reported by introspection
+type Kind string
+
+// Kinds of types
+const (
+	KindScalar      Kind = "SCALAR"
+	KindObject      Kind = "OBJECT"
+	KindInputObject Kind = "INPUT_OBJECT"
+	KindEnum        Kind = "ENUM"
+	KindList        Kind = "LIST"
+	KindNonNull     Kind = "NON_NULL"
+)
+
+// Type is a scalar, object, input object or enum type, or a list or
+// non-null wrapper of another type
+type Type struct {
+	Kind        Kind
+	Name        string
+	Description string
+
+	Fields      []*Field    // Of objects, in order
+	InputFields []*Argument // Of input objects
+	EnumValues  []string
+	OfType      *Type // Wrapped by lists and non-null types
+
+	// Serialize converts a resolved value to the output of a scalar, and
+	// ParseValue an input value, decoded from JSON or a literal, to the
+	// value given to resolvers
+	Serialize  func(v interface{}) (interface{}, error)
+	ParseValue func(v interface{}) (interface{}, error)
+}
+
+// Field is a field of an object type
+type Field struct {
+	Name        string
+	Description string
+	Args        []*Argument
+	Type        *Type
+
+	// Resolve returns the value of the field. Without it, the field is
+	// read from a map[string]interface{} source.
+	Resolve func(p ResolveParams) (interface{}, error)
+}
+
+// Argument is an argument of a field or a field of an input object
+type Argument struct {
+	Name        string
+	Description string
+	Type        *Type
+	Default     interface{} // Used when the argument is missing, unless nil
+}
+
+// ResolveParams are the inputs of a resolver
+type ResolveParams struct {
+	Context context.Context
+	Source  interface{}            // The value of the parent object
+	Args    map[string]interface{} // Coerced arguments, with defaults applied
+}
+
+// NewObject returns an object type
+func NewObject(name, description string, fields ...*Field) *Type {
+	return &Type{Kind: KindObject, Name: name, Description: description, Fields: fields}
+}
+
+// NewScalar returns a custom scalar type
+func NewScalar(name, description string, serialize, parseValue func(v interface{}) (interface{}, error)) *Type {
+	return &Type{Kind: KindScalar, Name: name, Description: description, Serialize: serialize, ParseValue: parseValue}
+}
+
+// NewEnum returns an enum type whose values are their names
+func NewEnum(name, description string, values ...string) *Type {
+	return &Type{Kind: KindEnum, Name: name, Description: description, EnumValues: values}
+}
+
+// NonNull wraps a type to exclude null
+func NonNull(t *Type) *Type {
+	return &Type{Kind: KindNonNull, OfType: t}
+}
+
+// ListOf wraps a type in a list
+func ListOf(t *Type) *Type {
+	return &Type{Kind: KindList, OfType: t}
+}
+
+// String returns the type as written in GraphQL, e.g. [String!]!
+func (t *Type) String() string {
+	switch t.Kind {
+	case KindNonNull:
+		return t.OfType.String() + "!"
+	case KindList:
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+// named returns the type without its list and non-null wrappers
+func (t *Type) named() *Type {
+	for t.OfType != nil {
+		t = t.OfType
+	}
+	return t
+}
+
+func (t *Type) field(name string) *Field {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// isLeaf reports whether values of the type are scalars or enums, which
+// take no selections
+func (t *Type) isLeaf() bool {
+	k := t.named().Kind
+	return k == KindScalar || k == KindEnum
+}
+
+func (t *Type) isInput() bool {
+	k := t.named().Kind
+	return k == KindScalar || k == KindEnum || k == KindInputObject
+}
+
+// Built-in scalars
+var (
+	String = NewScalar("String", "UTF-8 text", serializeString, parseString)
+	Int    = NewScalar("Int", "A signed 32-bit integer", serializeInt, parseInt)
+	Float  = NewScalar("Float", "A double-precision floating point number", serializeFloat, parseFloat)
+
+	Boolean = NewScalar("Boolean", "true or false", func(v interface{}) (interface{}, error) {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("Boolean cannot represent %v", v)
+		}
+		return b, nil
+	}, func(v interface{}) (interface{}, error) {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("Boolean cannot represent %v", v)
+		}
+		return b, nil
+	})
+
+	ID = NewScalar("ID", "A unique identifier, serialized as a string", serializeString, func(v interface{}) (interface{}, error) {
+		if n, ok := v.(float64); ok && n == math.Trunc(n) {
+			return fmt.Sprint(int64(n)), nil
+		}
+		return parseString(v)
+	})
+)
+
+func serializeString(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case fmt.Stringer:
+		return v.String(), nil
+	case bool, int, int64, float64:
+		return fmt.Sprint(v), nil
+	}
+	return nil, fmt.Errorf("String cannot represent %v", v)
+}
+
+func parseString(v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("String cannot represent %v", v)
+	}
+	return s, nil
+}
+
+func serializeInt(v interface{}) (interface{}, error) {
+	var n float64
+	switch v := v.(type) {
+	case int:
+		n = float64(v)
+	case int64:
+		n = float64(v)
+	case int32:
+		n = float64(v)
+	case uint64:
+		n = float64(v)
+	case float64:
+		n = v
+	default:
+		return nil, fmt.Errorf("Int cannot represent %v", v)
+	}
+	if n != math.Trunc(n) || n < math.MinInt32 || n > math.MaxInt32 {
+		return nil, fmt.Errorf("Int cannot represent %v", v)
+	}
+	return int(n), nil
+}
+
+func parseInt(v interface{}) (interface{}, error) {
+	n, ok := v.(float64)
+	if !ok || n != math.Trunc(n) || n < math.MinInt32 || n > math.MaxInt32 {
+		return nil, fmt.Errorf("Int cannot represent %v", v)
+	}
+	return int(n), nil
+}
+
+func serializeFloat(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("Float cannot represent %v", v)
+		}
+		return v, nil
+	case float32:
+		return serializeFloat(float64(v))
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	}
+	return nil, fmt.Errorf("Float cannot represent %v", v)
+}
+
+func parseFloat(v interface{}) (interface{}, error) {
+	n, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("Float cannot represent %v", v)
+	}
+	return n, nil
+}
+
+// Schema is a set of types with the root types of queries and mutations
+type Schema struct {
+	Query    *Type
+	Mutation *Type // Nil without mutations
+
+	types       map[string]*Type
+	schemaField *Field // __schema and __type of the query type
+	typeField   *Field
+}
+
+// NewSchema checks a schema and collects its types
+func NewSchema(query, mutation *Type) (*Schema, error) {
+	s := &Schema{Query: query, Mutation: mutation, types: make(map[string]*Type)}
+	s.schemaField = &Field{Name: "__schema", Type: NonNull(schemaType), Resolve: func(ResolveParams) (interface{}, error) {
+		return s, nil
+	}}
+	s.typeField = &Field{
+		Name: "__type",
+		Args: []*Argument{{Name: "name", Type: NonNull(String)}},
+		Type: typeType,
+		Resolve: func(p ResolveParams) (interface{}, error) {
+			return s.types[p.Args["name"].(string)], nil
+		},
+	}
+	for _, t := range []*Type{String, Int, Float, Boolean, ID} {
+		s.types[t.Name] = t
+	}
+	roots := []*Type{query, mutation}
+	roots = append(roots, introspectionTypes...)
+	for _, t := range roots {
+		if t != nil {
+			if err := s.collect(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// collect adds a type and the types it references
+func (s *Schema) collect(t *Type) error {
+	t = t.named()
+	if t.Name == "" {
+		return fmt.Errorf("graphql: unnamed %s type", t.Kind)
+	}
+	if existing, exists := s.types[t.Name]; exists {
+		if existing != t {
+			return fmt.Errorf("graphql: two types named %s", t.Name)
+		}
+		return nil
+	}
+	s.types[t.Name] = t
+
+	for _, f := range t.Fields {
+		if err := s.collect(f.Type); err != nil {
+			return err
+		}
+		for _, arg := range f.Args {
+			if !arg.Type.isInput() {
+				return fmt.Errorf("graphql: argument %s of %s.%s is not an input type", arg.Name, t.Name, f.Name)
+			}
+			if err := s.collect(arg.Type); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range t.InputFields {
+		if !f.Type.isInput() {
+			return fmt.Errorf("graphql: field %s of %s is not an input type", f.Name, t.Name)
+		}
+		if err := s.collect(f.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedTypes returns the types of the schema sorted by name
+func (s *Schema) sortedTypes() []*Type {
+	types := make([]*Type, 0, len(s.types))
+	for _, t := range s.types {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// Request is a GraphQL request
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+
+	Root      interface{} `json:"-"` // Source of the root fields
+	QueryOnly bool        `json:"-"` // Reject mutations, as for GET requests
+}
+
+// Response is the result of a request. Data is nil when the request failed
+// before execution.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is an error of a request, with the locations in the document and
+// the path of the field it concerns, if any. Resolvers may return an *Error
+// to add extensions, such as an error code.
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Location is a position in a document, both counted from 1
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func errorAt(loc Location, format string, args ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+// Operation returns the type of the operation a request runs, query or
+// mutation, so servers can check it before executing
+func (s *Schema) Operation(req Request) (string, error) {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return "", err
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return "", err
+	}
+	return op.kind, nil
+}
+
+// Execute parses, validates and runs a request
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{asError(err)}}
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{asError(err)}}
+	}
+
+	root := s.Query
+	switch op.kind {
+	case "mutation":
+		if s.Mutation == nil {
+			return &Response{Errors: []*Error{errorAt(op.loc, "the schema has no mutations")}}
+		}
+		if req.QueryOnly {
+			return &Response{Errors: []*Error{errorAt(op.loc, "mutations are not allowed in this request")}}
+		}
+		root = s.Mutation
+	case "subscription":
+		return &Response{Errors: []*Error{errorAt(op.loc, "subscriptions are not supported")}}
+	}
+
+	v := &validator{schema: s, doc: doc}
+	v.validate(op, root)
+	if len(v.errors) > 0 {
+		return &Response{Errors: v.errors}
+	}
+
+	e := &executor{schema: s, doc: doc, ctx: ctx}
+	if e.variables, err = coerceVariables(op, req.Variables, s); err != nil {
+		return &Response{Errors: []*Error{asError(err)}}
+	}
+	// Fields run one at a time, which is the serial execution mutations need
+	resp := &Response{}
+	if data, ok := e.executeSelections(root, req.Root, op.selections, nil); ok {
+		resp.Data = data
+	}
+	resp.Errors = e.errors
+	return resp
+}
+
+func asError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Message: err.Error()}
+}
@@ -0,0 +1,347 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testSchema has books with authors, looked up by ID, and a mutation
+// renaming them
+func testSchema(t testing.TB) *Schema {
+	t.Helper()
+	books := map[string]map[string]interface{}{
+		"1": {"id": "1", "title": "Dune", "pages": 412, "genre": "SF", "author": map[string]interface{}{"name": "Herbert"}},
+		"2": {"id": "2", "title": "Emma", "pages": 474, "genre": "NOVEL", "author": nil},
+	}
+	genre := NewEnum("Genre", "", "SF", "NOVEL")
+	author := NewObject("Author", "", &Field{Name: "name", Type: NonNull(String)})
+	book := NewObject("Book", "",
+		&Field{Name: "id", Type: NonNull(ID)},
+		&Field{Name: "title", Type: NonNull(String)},
+		&Field{Name: "pages", Type: Int},
+		&Field{Name: "genre", Type: genre},
+		&Field{Name: "author", Type: author},
+		&Field{Name: "authorName", Type: NonNull(String), Resolve: func(p ResolveParams) (interface{}, error) {
+			if a, ok := p.Source.(map[string]interface{})["author"].(map[string]interface{}); ok {
+				return a["name"], nil
+			}
+			return nil, nil // Null for a non-null field
+		}},
+	)
+	filter := &Type{Kind: KindInputObject, Name: "BookFilter", InputFields: []*Argument{
+		{Name: "genre", Type: genre},
+		{Name: "minPages", Type: Int, Default: 0},
+	}}
+	query := NewObject("Query", "",
+		&Field{
+			Name: "book",
+			Args: []*Argument{{Name: "id", Type: NonNull(ID)}},
+			Type: book,
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				if b, ok := books[p.Args["id"].(string)]; ok {
+					return b, nil
+				}
+				return nil, nil
+			},
+		},
+		&Field{
+			Name: "books",
+			Args: []*Argument{{Name: "filter", Type: filter}, {Name: "limit", Type: Int, Default: 10}},
+			Type: NonNull(ListOf(NonNull(book))),
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				var out []interface{}
+				for _, id := range []string{"1", "2"} {
+					b := books[id]
+					if f, ok := p.Args["filter"].(map[string]interface{}); ok {
+						if g, ok := f["genre"]; ok && g != nil && g != b["genre"] {
+							continue
+						}
+						if b["pages"].(int) < f["minPages"].(int) {
+							continue
+						}
+					}
+					if len(out) < p.Args["limit"].(int) {
+						out = append(out, b)
+					}
+				}
+				return out, nil
+			},
+		},
+		&Field{
+			Name: "fail",
+			Type: String,
+			Resolve: func(ResolveParams) (interface{}, error) {
+				return nil, &Error{Message: "not allowed", Extensions: map[string]interface{}{"code": "forbidden"}}
+			},
+		},
+	)
+	mutation := NewObject("Mutation", "",
+		&Field{
+			Name: "rename",
+			Args: []*Argument{{Name: "id", Type: NonNull(ID)}, {Name: "title", Type: NonNull(String)}},
+			Type: book,
+			Resolve: func(p ResolveParams) (interface{}, error) {
+				b := books[p.Args["id"].(string)]
+				if b == nil {
+					return nil, errors.New("no such book")
+				}
+				b["title"] = p.Args["title"]
+				return b, nil
+			},
+		},
+	)
+	schema, err := NewSchema(query, mutation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// execute runs a request and returns its data as JSON and its error messages
+func execute(t *testing.T, schema *Schema, req Request) (string, []string) {
+	t.Helper()
+	resp := schema.Execute(context.Background(), req)
+	var messages []string
+	for _, e := range resp.Errors {
+		messages = append(messages, e.Message)
+	}
+	if resp.Data == nil {
+		return "", messages
+	}
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), messages
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		operation string
+		want      string
+	}{
+		{
+			name:  "fields in selection order",
+			query: `{ book(id: "1") { title id pages } }`,
+			want:  `{"book":{"title":"Dune","id":"1","pages":412}}`,
+		},
+		{
+			name:  "aliases",
+			query: `{ a: book(id: 1) { t: title } b: book(id: "2") { title } }`,
+			want:  `{"a":{"t":"Dune"},"b":{"title":"Emma"}}`,
+		},
+		{
+			name:  "missing object",
+			query: `{ book(id: "9") { title } }`,
+			want:  `{"book":null}`,
+		},
+		{
+			name:  "nested objects and null",
+			query: `{ books { author { name } } }`,
+			want:  `{"books":[{"author":{"name":"Herbert"}},{"author":null}]}`,
+		},
+		{
+			name:  "input object with defaults and enums",
+			query: `{ books(filter: {genre: NOVEL}) { title genre } }`,
+			want:  `{"books":[{"title":"Emma","genre":"NOVEL"}]}`,
+		},
+		{
+			name:  "argument defaults",
+			query: `{ books(filter: {minPages: 450}, limit: 1) { title } }`,
+			want:  `{"books":[{"title":"Emma"}]}`,
+		},
+		{
+			name:      "variables",
+			query:     `query Q($id: ID!, $f: BookFilter) { book(id: $id) { title } books(filter: $f) { id } }`,
+			variables: map[string]interface{}{"id": "2", "f": map[string]interface{}{"genre": "SF"}},
+			want:      `{"book":{"title":"Emma"},"books":[{"id":"1"}]}`,
+		},
+		{
+			name:  "variable defaults",
+			query: `query ($id: ID = "1", $limit: Int = 1) { book(id: $id) { title } books(limit: $limit) { id } }`,
+			want:  `{"book":{"title":"Dune"},"books":[{"id":"1"}]}`,
+		},
+		{
+			name:  "named fragments",
+			query: `{ book(id: "1") { ...Names } } fragment Names on Book { title ...More } fragment More on Book { author { name } }`,
+			want:  `{"book":{"title":"Dune","author":{"name":"Herbert"}}}`,
+		},
+		{
+			name:  "inline fragments merge with fields",
+			query: `{ book(id: "1") { title ... on Book { id title } ... { pages } } }`,
+			want:  `{"book":{"title":"Dune","id":"1","pages":412}}`,
+		},
+		{
+			name:      "directives",
+			query:     `query ($yes: Boolean!) { book(id: "1") { title @skip(if: $yes) id @include(if: $yes) pages @include(if: false) } }`,
+			variables: map[string]interface{}{"yes": true},
+			want:      `{"book":{"id":"1"}}`,
+		},
+		{
+			name:  "typename",
+			query: `{ __typename book(id: "1") { __typename } }`,
+			want:  `{"__typename":"Query","book":{"__typename":"Book"}}`,
+		},
+		{
+			name:      "operation name",
+			query:     `query A { book(id: "1") { title } } query B { book(id: "2") { title } }`,
+			operation: "B",
+			want:      `{"book":{"title":"Emma"}}`,
+		},
+		{
+			name:  "mutation",
+			query: `mutation { rename(id: "2", title: "Persuasion") { title } }`,
+			want:  `{"rename":{"title":"Persuasion"}}`,
+		},
+		{
+			name:  "block strings and escapes",
+			query: "{ a: book(id: \"\\u0031\") { title } b: book(id: \"\"\"\n    2\n  \"\"\") { title } }",
+			want:  `{"a":{"title":"Dune"},"b":{"title":"Emma"}}`,
+		},
+		{
+			name:  "introspection",
+			query: `{ __type(name: "Genre") { kind name enumValues { name } } }`,
+			want:  `{"__type":{"kind":"ENUM","name":"Genre","enumValues":[{"name":"SF"},{"name":"NOVEL"}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, errs := execute(t, testSchema(t), Request{Query: tt.query, Variables: tt.variables, OperationName: tt.operation})
+			if len(errs) > 0 {
+				t.Fatalf("errors: %q", errs)
+			}
+			if data != tt.want {
+				t.Errorf("data = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		queryOnly bool
+		want      string // An error message containing this
+		data      string // Data of errors raised during execution, "" if none
+	}{
+		{name: "unterminated selection", query: `{ book(id: "1") { title }`, want: "Syntax Error: unexpected end of document"},
+		{name: "unexpected token", query: `{ book(id: "1") { title } ) }`, want: `Syntax Error: unexpected ")"`},
+		{name: "unexpected character", query: `{ book(id: "1") { title % } }`, want: "Syntax Error: unexpected character"},
+		{name: "unterminated string", query: `{ book(id: "1) { title } }`, want: "unterminated string"},
+		{name: "bad escape", query: `{ book(id: "\q") { title } }`, want: `invalid escape \q`},
+		{name: "empty selection", query: `{ book(id: "1") { } }`, want: "Syntax Error: empty selection set"},
+		{name: "no operation", query: `fragment F on Book { title }`, want: "the document has no operation"},
+		{name: "ambiguous operation", query: `query A { __typename } query B { __typename }`, want: "operationName is required"},
+		{name: "deep nesting", query: strings.Repeat("{ a ", 200) + strings.Repeat("}", 200), want: "nested more than"},
+		{name: "unknown field", query: `{ book(id: "1") { isbn } }`, want: `cannot query field "isbn" on type "Book"`},
+		{name: "missing subselection", query: `{ book(id: "1") }`, want: "must have a selection of subfields"},
+		{name: "subselection of a scalar", query: `{ book(id: "1") { title { x } } }`, want: "must not have a selection"},
+		{name: "unknown argument", query: `{ book(id: "1", isbn: "x") { title } }`, want: `unknown argument "isbn"`},
+		{name: "missing argument", query: `{ book { title } }`, want: `argument "id" of type ID! is required`},
+		{name: "argument of the wrong type", query: `{ books(limit: "ten") { id } }`, want: "Int cannot represent"},
+		{name: "unknown enum value", query: `{ books(filter: {genre: POETRY}) { id } }`, want: "enum Genre has no value POETRY"},
+		{name: "unknown input field", query: `{ books(filter: {author: "x"}) { id } }`, want: `unknown field "author" of type BookFilter`},
+		{name: "undefined variable", query: `{ book(id: $id) { title } }`, want: "variable $id is not defined"},
+		{name: "missing variable", query: `query ($id: ID!) { book(id: $id) { title } }`, want: "variable $id of required type ID! was not provided"},
+		{
+			name:      "variable of the wrong type",
+			query:     `query ($limit: Int) { books(limit: $limit) { id } }`,
+			variables: map[string]interface{}{"limit": 1.5},
+			want:      "variable $limit got an invalid value",
+		},
+		{name: "unknown fragment", query: `{ book(id: "1") { ...Missing } }`, want: `unknown fragment "Missing"`},
+		{
+			name:  "fragment cycle",
+			query: `{ book(id: "1") { ...A } } fragment A on Book { ...B } fragment B on Book { ...A }`,
+			want:  "within itself",
+		},
+		{name: "fragment on another type", query: `{ book(id: "1") { ...F } } fragment F on Author { name }`, want: `fragment "F" on Author cannot be spread on type Book`},
+		{name: "unknown directive", query: `{ book(id: "1") { title @deprecated } }`, want: "unknown directive @deprecated"},
+		{name: "conflicting aliases", query: `{ book(id: "1") { x: title x: id } }`, want: "use aliases", data: `{"book":null}`},
+		{name: "mutation of a query-only request", query: `mutation { rename(id: "1", title: "x") { id } }`, queryOnly: true, want: "mutations are not allowed"},
+		{name: "subscription", query: `subscription { book(id: "1") { id } }`, want: "subscriptions are not supported"},
+		{
+			name:  "resolver error",
+			query: `{ fail book(id: "1") { title } }`,
+			want:  "not allowed",
+			data:  `{"fail":null,"book":{"title":"Dune"}}`,
+		},
+		{
+			name:  "null propagation to a nullable field",
+			query: `{ a: book(id: "1") { authorName } b: book(id: "2") { title authorName } }`,
+			want:  "cannot return null for non-nullable field authorName",
+			data:  `{"a":{"authorName":"Herbert"},"b":null}`,
+		},
+		{
+			name:  "null propagation to the data",
+			query: `{ books { title authorName } }`,
+			want:  "cannot return null for non-nullable field authorName",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, errs := execute(t, testSchema(t), Request{Query: tt.query, Variables: tt.variables, QueryOnly: tt.queryOnly})
+			if !strings.Contains(strings.Join(errs, "\n"), tt.want) {
+				t.Errorf("errors %q do not contain %q", errs, tt.want)
+			}
+			if data != tt.data {
+				t.Errorf("data = %s, want %s", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	resp := testSchema(t).Execute(context.Background(), Request{Query: "{\n  book(id: \"1\") {\n    isbn\n  }\n}"})
+	if len(resp.Errors) != 1 {
+		t.Fatalf("errors: %v", resp.Errors)
+	}
+	if loc := resp.Errors[0].Locations; len(loc) != 1 || loc[0] != (Location{Line: 3, Column: 5}) {
+		t.Errorf("error locations = %v, want line 3, column 5", loc)
+	}
+
+	resp = testSchema(t).Execute(context.Background(), Request{Query: `{ fail }`})
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != "forbidden" || len(resp.Errors[0].Path) != 1 || resp.Errors[0].Path[0] != "fail" {
+		t.Errorf("resolver error = %+v, want the code forbidden at path [fail]", resp.Errors)
+	}
+}
+
+func TestOperation(t *testing.T) {
+	schema := testSchema(t)
+	for query, want := range map[string]string{
+		`{ __typename }`:                          "query",
+		`query Q { __typename }`:                  "query",
+		`mutation M { rename(id: 1, title: "") }`: "mutation",
+	} {
+		if kind, err := schema.Operation(Request{Query: query}); err != nil || kind != want {
+			t.Errorf("Operation(%q) = %q, %v, want %q", query, kind, err, want)
+		}
+	}
+}
+
+// FuzzExecute runs arbitrary documents and variables against the test
+// schema, which must answer them without panicking
+func FuzzExecute(f *testing.F) {
+	f.Add(`{ book(id: "1") { title ...F } } fragment F on Book { author { name } }`, `{}`)
+	f.Add(`query ($id: ID!, $f: BookFilter) { book(id: $id) { id } books(filter: $f) { title } }`, `{"id": 1, "f": {"genre": "SF"}}`)
+	f.Add(`mutation { rename(id: "1", title: """block""") { title } }`, `null`)
+	f.Add(`{ __schema { types { name fields { name args { name } } } } }`, `{}`)
+	f.Add(`{ books @include(if: $x) { authorName } }`, `{"x": [true]}`)
+
+	f.Fuzz(func(t *testing.T, query, variables string) {
+		var vars map[string]interface{}
+		_ = json.Unmarshal([]byte(variables), &vars)
+		resp := testSchema(t).Execute(context.Background(), Request{Query: query, Variables: vars})
+		if _, err := json.Marshal(resp); err != nil {
+			t.Fatalf("response cannot be encoded: %v", err)
+		}
+	})
+}
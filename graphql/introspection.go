@@ -0,0 +1,301 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The types of introspection, with the fields the GraphiQL and code
+// generator introspection queries ask for. Their fields are set in init, as
+// the types refer to each other.
+var (
+	schemaType     = NewObject("__Schema", "The types and directives of the schema")
+	typeType       = NewObject("__Type", "A type of the schema")
+	fieldType      = NewObject("__Field", "A field of an object type")
+	inputValueType = NewObject("__InputValue", "An argument, or a field of an input object")
+	enumValueType  = NewObject("__EnumValue", "A value of an enum")
+	directiveType  = NewObject("__Directive", "A directive the server supports")
+
+	typeKindType = NewEnum("__TypeKind", "The kind of a type",
+		"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL")
+	directiveLocationType = NewEnum("__DirectiveLocation", "Where a directive may be used",
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD",
+		"INLINE_FRAGMENT", "VARIABLE_DEFINITION", "SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION",
+		"ARGUMENT_DEFINITION", "INTERFACE", "UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT",
+		"INPUT_FIELD_DEFINITION")
+
+	introspectionTypes = []*Type{schemaType, typeType, fieldType, inputValueType, enumValueType,
+		directiveType, typeKindType, directiveLocationType}
+)
+
+// directiveDef is a directive, as introspection reports it
+type directiveDef struct {
+	name        string
+	description string
+	locations   []string
+	args        []*Argument
+}
+
+// directives are the directives the executor supports
+var directives = []*directiveDef{
+	{"include", "Includes the field or fragment only when the argument is true",
+		[]string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		[]*Argument{{Name: "if", Description: "Included when true", Type: NonNull(Boolean)}}},
+	{"skip", "Skips the field or fragment when the argument is true",
+		[]string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		[]*Argument{{Name: "if", Description: "Skipped when true", Type: NonNull(Boolean)}}},
+}
+
+// includeDeprecated is the argument of the lists that could hide deprecated
+// members. Nothing is deprecated, so it changes nothing.
+var includeDeprecated = []*Argument{{Name: "includeDeprecated", Type: Boolean, Default: false}}
+
+// optional returns a string, or nil when it is empty
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// constant returns a field with a constant value
+func constant(name string, t *Type, v interface{}) *Field {
+	return &Field{Name: name, Type: t, Resolve: func(ResolveParams) (interface{}, error) { return v, nil }}
+}
+
+func init() {
+	nonNullString := NonNull(String)
+	types := NonNull(ListOf(NonNull(typeType)))
+	inputValues := NonNull(ListOf(NonNull(inputValueType)))
+
+	schemaType.Fields = []*Field{
+		constant("description", String, nil),
+		{Name: "types", Type: types, Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Schema).sortedTypes(), nil
+		}},
+		{Name: "queryType", Type: NonNull(typeType), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Schema).Query, nil
+		}},
+		{Name: "mutationType", Type: typeType, Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Schema).Mutation, nil
+		}},
+		constant("subscriptionType", typeType, nil),
+		constant("directives", NonNull(ListOf(NonNull(directiveType))), directives),
+	}
+
+	typeType.Fields = []*Field{
+		{Name: "kind", Type: NonNull(typeKindType), Resolve: func(p ResolveParams) (interface{}, error) {
+			return string(p.Source.(*Type).Kind), nil
+		}},
+		{Name: "name", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optional(p.Source.(*Type).Name), nil
+		}},
+		{Name: "description", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optional(p.Source.(*Type).Description), nil
+		}},
+		constant("specifiedByURL", String, nil),
+		{Name: "fields", Args: includeDeprecated, Type: ListOf(NonNull(fieldType)), Resolve: func(p ResolveParams) (interface{}, error) {
+			if t := p.Source.(*Type); t.Kind == KindObject {
+				return append([]*Field{}, t.Fields...), nil
+			}
+			return nil, nil
+		}},
+		{Name: "interfaces", Type: ListOf(NonNull(typeType)), Resolve: func(p ResolveParams) (interface{}, error) {
+			if p.Source.(*Type).Kind == KindObject {
+				return []*Type{}, nil
+			}
+			return nil, nil
+		}},
+		constant("possibleTypes", ListOf(NonNull(typeType)), nil),
+		{Name: "enumValues", Args: includeDeprecated, Type: ListOf(NonNull(enumValueType)), Resolve: func(p ResolveParams) (interface{}, error) {
+			if t := p.Source.(*Type); t.Kind == KindEnum {
+				return t.EnumValues, nil
+			}
+			return nil, nil
+		}},
+		{Name: "inputFields", Args: includeDeprecated, Type: ListOf(NonNull(inputValueType)), Resolve: func(p ResolveParams) (interface{}, error) {
+			if t := p.Source.(*Type); t.Kind == KindInputObject {
+				return append([]*Argument{}, t.InputFields...), nil
+			}
+			return nil, nil
+		}},
+		{Name: "ofType", Type: typeType, Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Type).OfType, nil
+		}},
+		{Name: "isOneOf", Type: Boolean, Resolve: func(p ResolveParams) (interface{}, error) {
+			if p.Source.(*Type).Kind == KindInputObject {
+				return false, nil
+			}
+			return nil, nil
+		}},
+	}
+
+	fieldType.Fields = []*Field{
+		{Name: "name", Type: nonNullString, Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Field).Name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optional(p.Source.(*Field).Description), nil
+		}},
+		{Name: "args", Args: includeDeprecated, Type: inputValues, Resolve: func(p ResolveParams) (interface{}, error) {
+			return append([]*Argument{}, p.Source.(*Field).Args...), nil
+		}},
+		{Name: "type", Type: NonNull(typeType), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Field).Type, nil
+		}},
+		constant("isDeprecated", NonNull(Boolean), false),
+		constant("deprecationReason", String, nil),
+	}
+
+	inputValueType.Fields = []*Field{
+		{Name: "name", Type: nonNullString, Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Argument).Name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optional(p.Source.(*Argument).Description), nil
+		}},
+		{Name: "type", Type: NonNull(typeType), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*Argument).Type, nil
+		}},
+		{Name: "defaultValue", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			if arg := p.Source.(*Argument); arg.Default != nil {
+				return printValue(arg.Default), nil
+			}
+			return nil, nil
+		}},
+		constant("isDeprecated", NonNull(Boolean), false),
+		constant("deprecationReason", String, nil),
+	}
+
+	enumValueType.Fields = []*Field{
+		{Name: "name", Type: nonNullString, Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source, nil
+		}},
+		constant("description", String, nil),
+		constant("isDeprecated", NonNull(Boolean), false),
+		constant("deprecationReason", String, nil),
+	}
+
+	directiveType.Fields = []*Field{
+		{Name: "name", Type: nonNullString, Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*directiveDef).name, nil
+		}},
+		{Name: "description", Type: String, Resolve: func(p ResolveParams) (interface{}, error) {
+			return optional(p.Source.(*directiveDef).description), nil
+		}},
+		{Name: "locations", Type: NonNull(ListOf(NonNull(directiveLocationType))), Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*directiveDef).locations, nil
+		}},
+		{Name: "args", Args: includeDeprecated, Type: inputValues, Resolve: func(p ResolveParams) (interface{}, error) {
+			return p.Source.(*directiveDef).args, nil
+		}},
+		constant("isRepeatable", NonNull(Boolean), false),
+	}
+}
+
+// printValue prints a default value as a GraphQL literal
+func printValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		quoted, _ := json.Marshal(v)
+		return string(quoted)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = printValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields := make([]string, len(names))
+		for i, name := range names {
+			fields[i] = name + ": " + printValue(v[name])
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+	return fmt.Sprint(v)
+}
+
+// SDL returns the schema in the GraphQL schema definition language, without
+// the built-in scalars and the introspection types
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	for _, t := range s.sortedTypes() {
+		if strings.HasPrefix(t.Name, "__") {
+			continue
+		}
+		switch t {
+		case String, Int, Float, Boolean, ID:
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		printDescription(&b, "", t.Description)
+		switch t.Kind {
+		case KindScalar:
+			fmt.Fprintf(&b, "scalar %s\n", t.Name)
+		case KindEnum:
+			fmt.Fprintf(&b, "enum %s {\n", t.Name)
+			for _, v := range t.EnumValues {
+				fmt.Fprintf(&b, "  %s\n", v)
+			}
+			b.WriteString("}\n")
+		case KindInputObject:
+			fmt.Fprintf(&b, "input %s {\n", t.Name)
+			for _, f := range t.InputFields {
+				printDescription(&b, "  ", f.Description)
+				fmt.Fprintf(&b, "  %s\n", printArgument(f))
+			}
+			b.WriteString("}\n")
+		case KindObject:
+			fmt.Fprintf(&b, "type %s {\n", t.Name)
+			for _, f := range t.Fields {
+				printDescription(&b, "  ", f.Description)
+				b.WriteString("  " + f.Name)
+				if len(f.Args) > 0 {
+					args := make([]string, len(f.Args))
+					for i, arg := range f.Args {
+						args[i] = printArgument(arg)
+					}
+					b.WriteString("(" + strings.Join(args, ", ") + ")")
+				}
+				fmt.Fprintf(&b, ": %s\n", f.Type)
+			}
+			b.WriteString("}\n")
+		}
+	}
+	return b.String()
+}
+
+func printArgument(arg *Argument) string {
+	s := arg.Name + ": " + arg.Type.String()
+	if arg.Default != nil {
+		s += " = " + printValue(arg.Default)
+	}
+	return s
+}
+
+func printDescription(b *strings.Builder, indent, description string) {
+	if description == "" {
+		return
+	}
+	if !strings.Contains(description, "\n") {
+		quoted, _ := json.Marshal(description)
+		fmt.Fprintf(b, "%s%s\n", indent, quoted)
+		return
+	}
+	fmt.Fprintf(b, "%s\"\"\"\n", indent)
+	for _, line := range strings.Split(description, "\n") {
+		fmt.Fprintf(b, "%s%s\n", indent, line)
+	}
+	fmt.Fprintf(b, "%s\"\"\"\n", indent)
+}
@@ -0,0 +1,752 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDepth bounds the nesting of selections and values in a document
+const maxDepth = 64
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // query, mutation or subscription
+	name       string
+	variables  []*variableDefinition
+	selections []selection
+	loc        Location
+}
+
+type variableDefinition struct {
+	name     string
+	typ      *typeRef
+	fallback *value // Default value, nil without one
+	loc      Location
+}
+
+// typeRef is a type as written in a variable definition
+type typeRef struct {
+	name    string
+	list    *typeRef
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.list != nil {
+		s = "[" + t.list.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+type selection interface{}
+
+type field struct {
+	alias      string // The name as returned; the field name without an alias
+	name       string
+	args       []*argument
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	loc        Location
+}
+
+type inlineFragment struct {
+	on         string // Empty without a type condition
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type fragment struct {
+	name       string
+	on         string
+	selections []selection
+	loc        Location
+}
+
+type directive struct {
+	name string
+	args []*argument
+	loc  Location
+}
+
+type argument struct {
+	name  string
+	value *value
+	loc   Location
+}
+
+type valueKind int
+
+const (
+	valueVariable valueKind = iota
+	valueInt
+	valueFloat
+	valueString
+	valueBoolean
+	valueNull
+	valueEnum
+	valueList
+	valueObject
+)
+
+type value struct {
+	kind   valueKind
+	raw    string // Variable name, number, string, boolean or enum value
+	list   []*value
+	fields []*argument // Of objects
+	loc    Location
+}
+
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) != 1 {
+			return nil, fmt.Errorf("operationName is required for documents with %d operations", len(d.operations))
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	loc  Location
+}
+
+// lex splits a document into tokens. Commas, whitespace and comments are
+// ignored.
+func lex(src string) ([]token, error) {
+	src = strings.TrimPrefix(src, "\uFEFF")
+	var tokens []token
+	line, lineStart := 1, 0
+	for i := 0; i < len(src); {
+		c := src[i]
+		loc := Location{Line: line, Column: i - lineStart + 1}
+		switch {
+		case c == '\n':
+			i++
+			line, lineStart = line+1, i
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, token{tokPunct, "...", loc})
+			i += 3
+		case strings.IndexByte("!$&()=:@[]{}|", c) >= 0:
+			tokens = append(tokens, token{tokPunct, string(c), loc})
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && isNameChar(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokName, src[start:i], loc})
+		case c == '-' || c >= '0' && c <= '9':
+			start, kind := i, tokInt
+			if c == '-' {
+				i++
+			}
+			for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+				i++
+			}
+			if i < len(src) && src[i] == '.' {
+				kind = tokFloat
+				i++
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				kind = tokFloat
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			text := src[start:i]
+			if _, err := strconv.ParseFloat(text, 64); err != nil || i < len(src) && isNameChar(src[i]) {
+				return nil, errorAt(loc, "Syntax Error: invalid number %q", text)
+			}
+			tokens = append(tokens, token{kind, text, loc})
+		case strings.HasPrefix(src[i:], `"""`):
+			// Block strings end at the first """ not escaped as \"""
+			end := i + 3
+			for {
+				n := strings.Index(src[end:], `"""`)
+				if n < 0 {
+					return nil, errorAt(loc, "Syntax Error: unterminated block string")
+				}
+				end += n
+				if src[end-1] != '\\' {
+					break
+				}
+				end += 3
+			}
+			raw := src[i+3 : end]
+			if n := strings.Count(raw, "\n"); n > 0 {
+				line += n
+				lineStart = i + 3 + strings.LastIndexByte(raw, '\n') + 1
+			}
+			tokens = append(tokens, token{tokString, blockString(strings.ReplaceAll(raw, `\"""`, `"""`)), loc})
+			i = end + 3
+		case c == '"':
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, errorAt(loc, "Syntax Error: %v", err)
+			}
+			tokens = append(tokens, token{tokString, s, loc})
+			i += n
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, errorAt(loc, "Syntax Error: unexpected character %q", r)
+		}
+	}
+	loc := Location{Line: line, Column: len(src) - lineStart + 1}
+	return append(tokens, token{tokEOF, "", loc}), nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// lexString reads a quoted string with its escapes, returning it and the
+// number of bytes read
+func lexString(src string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(src); {
+		c := src[i]
+		switch c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case '\\':
+			if i+1 >= len(src) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			switch e := src[i+1]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+6 > len(src) {
+					return "", 0, fmt.Errorf("invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(src[i+2:i+6], 16, 32)
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid unicode escape \\u%s", src[i+2:i+6])
+				}
+				b.WriteRune(rune(n))
+				i += 4
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c", e)
+			}
+			i += 2
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// blockString removes the common indentation and the blank first and last
+// lines of a block string
+func blockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// parse parses an executable document: operations and fragments
+func parse(src string) (*document, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.peek().kind != tokEOF {
+		t := p.peek()
+		switch {
+		case t.kind == tokPunct && t.text == "{":
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: selections, loc: t.loc})
+		case t.kind == tokName && (t.text == "query" || t.text == "mutation" || t.text == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			for _, other := range doc.operations {
+				if other.name == op.name {
+					return nil, errorAt(op.loc, "there can be only one operation named %q", op.name)
+				}
+			}
+			doc.operations = append(doc.operations, op)
+		case t.kind == tokName && t.text == "fragment":
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := doc.fragments[f.name]; exists {
+				return nil, errorAt(f.loc, "there can be only one fragment named %q", f.name)
+			}
+			doc.fragments[f.name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document has no operation")
+	}
+	for _, op := range doc.operations {
+		if op.name == "" && len(doc.operations) > 1 {
+			return nil, errorAt(op.loc, "an anonymous operation must be the only one in the document")
+		}
+	}
+	return doc, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return errorAt(t.loc, "Syntax Error: unexpected end of document")
+	}
+	return errorAt(t.loc, "Syntax Error: unexpected %q", t.text)
+}
+
+// punct consumes the punctuator if it is next
+func (p *parser) punct(text string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == text {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.punct(text) {
+		t := p.peek()
+		if t.kind == tokEOF {
+			return errorAt(t.loc, "Syntax Error: expected %q, found end of document", text)
+		}
+		return errorAt(t.loc, "Syntax Error: expected %q, found %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *parser) name() (string, error) {
+	t := p.peek()
+	if t.kind != tokName {
+		return "", p.unexpected()
+	}
+	p.next()
+	return t.text, nil
+}
+
+// enter bounds the nesting of the document
+func (p *parser) enter() error {
+	if p.depth++; p.depth > maxDepth {
+		return errorAt(p.peek().loc, "the document is nested more than %d levels deep", maxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) operation() (*operation, error) {
+	t := p.next()
+	op := &operation{kind: t.text, loc: t.loc}
+	if p.peek().kind == tokName {
+		op.name, _ = p.name()
+	}
+	if p.punct("(") {
+		for !p.punct(")") {
+			def, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, def)
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = selections
+	return op, nil
+}
+
+func (p *parser) variableDefinition() (*variableDefinition, error) {
+	loc := p.peek().loc
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	typ, err := p.typeRef()
+	if err != nil {
+		return nil, err
+	}
+	def := &variableDefinition{name: name, typ: typ, loc: loc}
+	if p.punct("=") {
+		if def.fallback, err = p.value(true); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+func (p *parser) typeRef() (*typeRef, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	t := &typeRef{}
+	if p.punct("[") {
+		inner, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		t.list = inner
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t.name = name
+	}
+	t.nonNull = p.punct("!")
+	return t, nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	loc := p.next().loc
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, errorAt(loc, "Syntax Error: a fragment cannot be named \"on\"")
+	}
+	if on, _ := p.name(); on != "on" {
+		return nil, errorAt(loc, "Syntax Error: expected \"on\" after fragment %s", name)
+	}
+	typeName, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &fragment{name: name, on: typeName, selections: selections, loc: loc}, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []selection
+	for !p.punct("}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+	}
+	if len(selections) == 0 {
+		return nil, errorAt(p.tokens[p.pos-1].loc, "Syntax Error: empty selection set")
+	}
+	return selections, nil
+}
+
+func (p *parser) selection() (selection, error) {
+	loc := p.peek().loc
+	if p.punct("...") {
+		if t := p.peek(); t.kind == tokName && t.text != "on" {
+			p.next()
+			directives, err := p.directives()
+			if err != nil {
+				return nil, err
+			}
+			return &fragmentSpread{name: t.text, directives: directives, loc: loc}, nil
+		}
+		f := &inlineFragment{loc: loc}
+		if t := p.peek(); t.kind == tokName && t.text == "on" {
+			p.next()
+			var err error
+			if f.on, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if f.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		if f.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f := &field{alias: name, name: name, loc: loc}
+	if p.punct(":") {
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if f.args, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokPunct && t.text == "{" {
+		if f.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) arguments(constant bool) ([]*argument, error) {
+	if !p.punct("(") {
+		return nil, nil
+	}
+	var args []*argument
+	for !p.punct(")") {
+		loc := p.peek().loc
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args {
+			if arg.name == name {
+				return nil, errorAt(loc, "there can be only one argument named %q", name)
+			}
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, &argument{name: name, value: v, loc: loc})
+	}
+	return args, nil
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	var directives []*directive
+	for {
+		loc := p.peek().loc
+		if !p.punct("@") {
+			return directives, nil
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments(false)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, &directive{name: name, args: args, loc: loc})
+	}
+}
+
+// value parses an input value; constant values, such as defaults, cannot
+// reference variables
+func (p *parser) value(constant bool) (*value, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	t := p.next()
+	v := &value{raw: t.text, loc: t.loc}
+	switch t.kind {
+	case tokInt:
+		v.kind = valueInt
+	case tokFloat:
+		v.kind = valueFloat
+	case tokString:
+		v.kind = valueString
+	case tokName:
+		switch t.text {
+		case "true", "false":
+			v.kind = valueBoolean
+		case "null":
+			v.kind = valueNull
+		default:
+			v.kind = valueEnum
+		}
+	case tokPunct:
+		switch t.text {
+		case "$":
+			if constant {
+				return nil, errorAt(t.loc, "Syntax Error: unexpected variable in a constant value")
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			v.kind, v.raw = valueVariable, name
+		case "[":
+			v.kind = valueList
+			for !p.punct("]") {
+				item, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				v.list = append(v.list, item)
+			}
+		case "{":
+			v.kind = valueObject
+			for !p.punct("}") {
+				loc := p.peek().loc
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				fieldValue, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				v.fields = append(v.fields, &argument{name: name, value: fieldValue, loc: loc})
+			}
+		default:
+			p.pos--
+			return nil, p.unexpected()
+		}
+	default:
+		return nil, errorAt(t.loc, "Syntax Error: unexpected end of document")
+	}
+	return v, nil
+}
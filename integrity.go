@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// hashSuffix is the last path segment addressing the content hash of the
+// key before it, e.g. /data/indicators/ip-1/_hash
+const hashSuffix = "/_hash"
+
+// EntryHash is the body of GET /data/:key/_hash
+type EntryHash struct {
+	Key       string `json:"key"`
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash"`  // Hash stored when the value was written
+	Valid     bool   `json:"valid"` // Whether the current value still hashes to it
+}
+
+// respondHash returns the stored content hash of key and whether its value
+// still matches it
+func respondHash(c *gin.Context, store *storage.Store, key string) {
+	if !canRead(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	entry, exists := store.Get(key)
+	if !exists {
+		respondError(c, 404, CodeNotFound, "key not found")
+		return
+	}
+	c.JSON(200, EntryHash{
+		Key:       key,
+		Algorithm: "sha256",
+		Hash:      entry.Hash,
+		Valid:     storage.ContentHash(entry.Value) == entry.Hash,
+	})
+}
+
+// handleVerify hashes every entry again and reports those whose value no
+// longer matches the hash stored when it was written. With async=true it
+// runs as a job whose result is the report.
+func handleVerify(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		if runAsync(c) {
+			job := store.StartJob(storage.JobVerify, nil, func(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+				return store.Verify(), nil
+			})
+			respondJobStarted(c, job)
+			return
+		}
+		report := store.Verify()
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, report)
+		} else {
+			c.JSON(200, report)
+		}
+	}
+}
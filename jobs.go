@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// JobsResponse is the result of GET /admin/jobs
+type JobsResponse struct {
+	Jobs []storage.Job `json:"jobs" yaml:"jobs"`
+}
+
+// runAsync reports whether a request asks to run as a background job
+// with ?async=true
+func runAsync(c *gin.Context) bool {
+	return c.Query("async") == "true"
+}
+
+// respondJobStarted answers a request started as a background job with the
+// job to follow at /admin/jobs/:id
+func respondJobStarted(c *gin.Context, job storage.Job) {
+	c.Header("Location", "/admin/jobs/"+job.ID)
+	c.JSON(202, gin.H{"status": job.State, "job": job.ID})
+}
+
+// handleJobs lists the running jobs and the last finished ones, optionally
+// only those of one kind or state
+func handleJobs(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		kind, state := c.Query("kind"), c.Query("state")
+		response := JobsResponse{Jobs: []storage.Job{}}
+		for _, job := range store.Jobs() {
+			if (kind == "" || job.Kind == kind) && (state == "" || job.State == state) {
+				response.Jobs = append(response.Jobs, job)
+			}
+		}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, response)
+		} else {
+			c.JSON(200, response)
+		}
+	}
+}
+
+// handleJob returns the progress or the result of a job
+func handleJob(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		job, exists := store.Job(c.Param("id"))
+		if !exists {
+			respondError(c, 404, CodeNotFound, "job not found")
+			return
+		}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, job)
+		} else {
+			c.JSON(200, job)
+		}
+	}
+}
+
+// handleCancelJob asks a running job to stop
+func handleCancelJob(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		if err := store.CancelJob(c.Param("id")); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		c.JSON(202, gin.H{"status": "cancelling"})
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/k8s"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// k8sRetryDelay is the wait before re-watching after an API error
+const k8sRetryDelay = 5 * time.Second
+
+// K8sChange is a change notification for a mirrored Kubernetes object
+type K8sChange struct {
+	Type            string    `json:"type"` // ADDED, MODIFIED or DELETED
+	Key             string    `json:"key"`
+	ResourceVersion string    `json:"resource_version,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+// K8sChangeFeed fans change notifications out to subscribers
+type K8sChangeFeed struct {
+	sync.Mutex
+	subscribers map[chan K8sChange]struct{}
+}
+
+// NewK8sChangeFeed creates an empty feed
+func NewK8sChangeFeed() *K8sChangeFeed {
+	return &K8sChangeFeed{subscribers: make(map[chan K8sChange]struct{})}
+}
+
+// Subscribe returns a channel receiving future changes
+func (f *K8sChangeFeed) Subscribe() chan K8sChange {
+	ch := make(chan K8sChange, 64)
+	f.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.Unlock()
+	return ch
+}
+
+// Unsubscribe stops delivery to ch
+func (f *K8sChangeFeed) Unsubscribe(ch chan K8sChange) {
+	f.Lock()
+	delete(f.subscribers, ch)
+	f.Unlock()
+}
+
+// Publish delivers a change to every subscriber; slow subscribers miss changes
+// rather than blocking the sync
+func (f *K8sChangeFeed) Publish(change K8sChange) {
+	f.Lock()
+	defer f.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+// k8sSync mirrors one Kubernetes resource into the store
+type k8sSync struct {
+	store     *storage.Store
+	client    *k8s.Client
+	resource  k8s.Resource
+	namespace string
+	selector  string
+	feed      *K8sChangeFeed
+}
+
+// key returns k8s/<resource>/<namespace>/<name>, omitting the namespace for
+// cluster-scoped objects
+func (s *k8sSync) key(obj k8s.Object) string {
+	parts := []string{"k8s", s.resource.Resource}
+	if ns := obj.Namespace(); ns != "" {
+		parts = append(parts, ns)
+	}
+	return strings.Join(append(parts, obj.Name()), "/")
+}
+
+func (s *k8sSync) prefix() string {
+	return "k8s/" + s.resource.Resource + "/"
+}
+
+// k8sDocument converts an object into the stored document: its kind, the
+// useful parts of its metadata and its content. ConfigMap values holding
+// YAML or JSON maps and lists are parsed so their fields are searchable.
+func k8sDocument(obj k8s.Object) map[string]interface{} {
+	doc := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		doc[k] = v
+	}
+
+	metadata := make(map[string]interface{})
+	for _, field := range []string{"name", "namespace", "labels", "annotations", "uid", "resourceVersion", "creationTimestamp"} {
+		if v, ok := obj.Metadata()[field]; ok {
+			metadata[field] = v
+		}
+	}
+	doc["metadata"] = metadata
+
+	if data, ok := obj["data"].(map[string]interface{}); ok && obj["kind"] == "ConfigMap" {
+		parsed := make(map[string]interface{}, len(data))
+		for name, raw := range data {
+			parsed[name] = raw
+			if s, ok := raw.(string); ok {
+				var v interface{}
+				if yaml.Unmarshal([]byte(s), &v) == nil {
+					switch v.(type) {
+					case map[string]interface{}, []interface{}:
+						parsed[name] = v
+					}
+				}
+			}
+		}
+		doc["data"] = parsed
+		delete(doc, "binaryData")
+	}
+
+	return doc
+}
+
+func (s *k8sSync) apply(event k8s.Event) {
+	key := s.key(event.Object)
+	switch event.Type {
+	case "ADDED", "MODIFIED":
+		if err := s.store.Set(key, k8sDocument(event.Object)); err != nil {
+			log.Printf("Kubernetes sync: failed to store %s: %v", key, err)
+			return
+		}
+	case "DELETED":
+		s.store.Delete(key)
+	default:
+		return
+	}
+
+	s.feed.Publish(K8sChange{
+		Type:            event.Type,
+		Key:             key,
+		ResourceVersion: event.Object.ResourceVersion(),
+		Time:            time.Now().UTC(),
+	})
+}
+
+// relist stores every current object, deletes entries for objects that no
+// longer exist and returns the resource version to watch from
+func (s *k8sSync) relist() (string, error) {
+	objects, resourceVersion, err := s.client.List(s.resource, s.namespace, s.selector)
+	if err != nil {
+		return "", err
+	}
+
+	current := make(map[string]struct{}, len(objects))
+	for _, obj := range objects {
+		key := s.key(obj)
+		current[key] = struct{}{}
+
+		if entry, exists := s.store.Get(key); exists {
+			if m, ok := entry.Value.(map[string]interface{}); ok {
+				if md, ok := m["metadata"].(map[string]interface{}); ok && md["resourceVersion"] == obj.ResourceVersion() {
+					continue
+				}
+			}
+		}
+		s.apply(k8s.Event{Type: "MODIFIED", Object: obj})
+	}
+
+	var stale []k8s.Object
+	s.store.Range(func(key string, entry *storage.Entry) bool {
+		if _, ok := current[key]; !ok && strings.HasPrefix(key, s.prefix()) {
+			if m, ok := entry.Value.(map[string]interface{}); ok {
+				if s.namespace == "" || k8s.Object(m).Namespace() == s.namespace {
+					stale = append(stale, k8s.Object(m))
+				}
+			}
+		}
+		return true
+	})
+	for _, obj := range stale {
+		s.apply(k8s.Event{Type: "DELETED", Object: obj})
+	}
+
+	log.Printf("Kubernetes sync: mirrored %d %s", len(objects), s.resource)
+	return resourceVersion, nil
+}
+
+// run lists and then watches the resource until the process exits
+func (s *k8sSync) run() {
+	resourceVersion := ""
+	for {
+		if resourceVersion == "" {
+			rv, err := s.relist()
+			if err != nil {
+				log.Printf("Kubernetes sync: list %s failed: %v", s.resource, err)
+				time.Sleep(k8sRetryDelay)
+				continue
+			}
+			resourceVersion = rv
+		}
+
+		rv, err := s.client.Watch(s.resource, s.namespace, s.selector, resourceVersion, s.apply)
+		switch {
+		case errors.Is(err, k8s.ErrGone):
+			resourceVersion = ""
+		case err != nil:
+			log.Printf("Kubernetes sync: watch %s failed: %v", s.resource, err)
+			resourceVersion = rv
+			time.Sleep(k8sRetryDelay)
+		default:
+			resourceVersion = rv
+		}
+	}
+}
+
+// startK8sSync mirrors a Kubernetes resource into store. An empty apiURL
+// uses the in-cluster service account.
+func startK8sSync(store *storage.Store, apiURL, token, resource, namespace, selector string, feed *K8sChangeFeed) error {
+	r, err := k8s.ParseResource(resource)
+	if err != nil {
+		return err
+	}
+
+	var client *k8s.Client
+	if apiURL == "" {
+		if client, err = k8s.NewInClusterClient(); err != nil {
+			return err
+		}
+	} else {
+		client = k8s.NewClient(apiURL, token)
+	}
+
+	s := &k8sSync{
+		store:     store,
+		client:    client,
+		resource:  r,
+		namespace: namespace,
+		selector:  selector,
+		feed:      feed,
+	}
+	go s.run()
+	return nil
+}
+
+// handleK8sChanges streams change notifications as server-sent events
+func handleK8sChanges(feed *K8sChangeFeed) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if feed == nil {
+			respondError(c, 404, CodeNotFound, "kubernetes sync not enabled")
+			return
+		}
+
+		ch := feed.Subscribe()
+		defer feed.Unsubscribe(ch)
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case change := <-ch:
+				c.SSEvent("change", change)
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
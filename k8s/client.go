@@ -0,0 +1,262 @@
+// Package k8s is a minimal Kubernetes API client for listing and watching
+// resources such as ConfigMaps or custom resources, without client-go.
+package k8s
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Service account files mounted into every pod
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caFile            = serviceAccountDir + "/ca.crt"
+)
+
+// ErrGone is returned by a watch when its resource version has expired and
+// the caller must list again
+var ErrGone = errors.New("resource version expired")
+
+// Object is a Kubernetes object decoded as generic JSON
+type Object map[string]interface{}
+
+// Metadata returns the object's metadata map
+func (o Object) Metadata() map[string]interface{} {
+	m, _ := o["metadata"].(map[string]interface{})
+	return m
+}
+
+// Name returns metadata.name
+func (o Object) Name() string {
+	name, _ := o.Metadata()["name"].(string)
+	return name
+}
+
+// Namespace returns metadata.namespace, empty for cluster-scoped objects
+func (o Object) Namespace() string {
+	ns, _ := o.Metadata()["namespace"].(string)
+	return ns
+}
+
+// ResourceVersion returns metadata.resourceVersion
+func (o Object) ResourceVersion() string {
+	rv, _ := o.Metadata()["resourceVersion"].(string)
+	return rv
+}
+
+// Event is a single watch notification
+type Event struct {
+	Type   string `json:"type"` // ADDED, MODIFIED, DELETED, BOOKMARK or ERROR
+	Object Object `json:"object"`
+}
+
+// Resource identifies a resource collection: "configmaps" for core resources
+// or "group/version/resource" such as "searchyaml.threatflux.io/v1/documents"
+type Resource struct {
+	Group    string
+	Version  string
+	Resource string
+}
+
+// ParseResource parses a resource string
+func ParseResource(s string) (Resource, error) {
+	parts := strings.Split(s, "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return Resource{Version: "v1", Resource: parts[0]}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return Resource{Version: parts[0], Resource: parts[1]}, nil
+	case len(parts) == 3 && parts[0] != "" && parts[1] != "" && parts[2] != "":
+		return Resource{Group: parts[0], Version: parts[1], Resource: parts[2]}, nil
+	}
+	return Resource{}, fmt.Errorf("invalid resource: %q", s)
+}
+
+// String returns the resource in ParseResource form
+func (r Resource) String() string {
+	if r.Group == "" {
+		return r.Resource
+	}
+	return r.Group + "/" + r.Version + "/" + r.Resource
+}
+
+func (r Resource) path(namespace string) string {
+	base := "/api/" + r.Version
+	if r.Group != "" {
+		base = "/apis/" + r.Group + "/" + r.Version
+	}
+	if namespace != "" {
+		base += "/namespaces/" + url.PathEscape(namespace)
+	}
+	return base + "/" + r.Resource
+}
+
+// Client talks to the Kubernetes API server
+type Client struct {
+	// BaseURL is the API server URL, e.g. https://10.0.0.1:443 or the
+	// address of `kubectl proxy`
+	BaseURL string
+	Token   string
+
+	httpClient *http.Client
+}
+
+// NewClient creates a client for an API server. An empty token sends no
+// Authorization header, which suits `kubectl proxy`.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		httpClient: &http.Client{},
+	}
+}
+
+// NewInClusterClient creates a client from the pod's service account
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster: KUBERNETES_SERVICE_HOST is not set")
+	}
+
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %v", err)
+	}
+
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid service account CA")
+	}
+
+	c := NewClient("https://"+host+":"+port, strings.TrimSpace(string(token)))
+	c.httpClient.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}
+	return c, nil
+}
+
+func (c *Client) get(path string, query url.Values, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.BaseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	client := c.httpClient
+	if timeout > 0 {
+		copied := *client
+		copied.Timeout = timeout
+		client = &copied
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, ErrGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes api returned %s for %s", resp.Status, path)
+	}
+	return resp, nil
+}
+
+// List returns all objects of a resource and the collection's resource version
+func (c *Client) List(r Resource, namespace, labelSelector string) ([]Object, string, error) {
+	var objects []Object
+	query := url.Values{"limit": {"500"}}
+	if labelSelector != "" {
+		query.Set("labelSelector", labelSelector)
+	}
+
+	for {
+		resp, err := c.get(r.path(namespace), query, 30*time.Second)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var list struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+				Continue        string `json:"continue"`
+			} `json:"metadata"`
+			Items []Object `json:"items"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode %s list: %v", r, err)
+		}
+
+		objects = append(objects, list.Items...)
+		if list.Metadata.Continue == "" {
+			return objects, list.Metadata.ResourceVersion, nil
+		}
+		query.Set("continue", list.Metadata.Continue)
+	}
+}
+
+// Watch streams changes after resourceVersion to fn until the server closes
+// the watch. It returns the last resource version seen so the caller can
+// resume, or ErrGone when the caller must list again.
+func (c *Client) Watch(r Resource, namespace, labelSelector, resourceVersion string, fn func(Event)) (string, error) {
+	query := url.Values{
+		"watch":               {"1"},
+		"allowWatchBookmarks": {"true"},
+		"resourceVersion":     {resourceVersion},
+		"timeoutSeconds":      {"300"},
+	}
+	if labelSelector != "" {
+		query.Set("labelSelector", labelSelector)
+	}
+
+	resp, err := c.get(r.path(namespace), query, 0)
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return resourceVersion, fmt.Errorf("failed to decode watch event: %v", err)
+		}
+
+		if event.Type == "ERROR" {
+			if code, _ := event.Object["code"].(float64); code == http.StatusGone {
+				return resourceVersion, ErrGone
+			}
+			return resourceVersion, fmt.Errorf("watch error: %v", event.Object["message"])
+		}
+
+		if rv := event.Object.ResourceVersion(); rv != "" {
+			resourceVersion = rv
+		}
+		if event.Type != "BOOKMARK" {
+			fn(event)
+		}
+	}
+	return resourceVersion, scanner.Err()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// reservedKeyPrefix returns the configured reserved prefix key starts with.
+// Keys under reserved prefixes, such as mirrored Kubernetes objects, are
+// written only by the server itself.
+func reservedKeyPrefix(key string) (string, bool) {
+	for _, prefix := range strings.Split(*ReservedPrefixes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" && strings.HasPrefix(key, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// checkWritableKey responds with 400 and returns false when a key may not be
+// written or deleted through the API
+func checkWritableKey(c *gin.Context, key string) bool {
+	if prefix, reserved := reservedKeyPrefix(key); reserved {
+		respondError(c, 400, CodeInvalidKey, fmt.Sprintf("invalid key: prefix %q is reserved", prefix))
+		return false
+	}
+	return true
+}
+
+// dataKey returns the key addressed by /data/*key. Keys may contain slashes,
+// either literally or percent-encoded.
+func dataKey(c *gin.Context) string {
+	return strings.TrimPrefix(c.Param("key"), "/")
+}
+
+// isDirectory reports whether a /data path names a directory of
+// hierarchical keys rather than a key: the root or a path ending in a slash
+func isDirectory(key string) bool {
+	return key == "" || strings.HasSuffix(key, "/")
+}
+
+// listKeys responds with a page of the readable keys starting with prefix.
+// With collapse, keys below the next slash after the prefix are listed once
+// as a subdirectory in Dirs, as a directory listing would show them.
+func listKeys(c *gin.Context, store *storage.Store, prefix string, collapse bool) {
+	limit := defaultKeyListLimit
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			respondError(c, 400, CodeInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	after := c.Query("after")
+	keys, _ := store.Keys(prefix, after, 0)
+	list := KeyList{Keys: make([]string, 0, min(limit, len(keys)))}
+	var count int
+	var last string
+	for _, key := range keys {
+		if !canRead(c, key) {
+			continue
+		}
+
+		item, dir := key, false
+		if collapse {
+			if i := strings.IndexByte(key[len(prefix):], '/'); i >= 0 {
+				item, dir = key[:len(prefix)+i+1], true
+			}
+		}
+		// Keys of a subdirectory sort together; skip those of the
+		// subdirectory just listed or ending the previous page
+		if dir && (item == last || item == after) {
+			continue
+		}
+
+		if count == limit {
+			list.Next = last
+			break
+		}
+		if dir {
+			list.Dirs = append(list.Dirs, item)
+		} else {
+			list.Keys = append(list.Keys, item)
+		}
+		count++
+		last = item
+	}
+
+	c.JSON(200, list)
+}
+
+// directoryExists reports whether any readable key lies below prefix
+func directoryExists(c *gin.Context, store *storage.Store, prefix string) bool {
+	keys, _ := store.Keys(prefix, "", 0)
+	for _, key := range keys {
+		if canRead(c, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteDirectory deletes every key below prefix, which requires
+// ?recursive=true. Nothing is deleted unless the request may delete all of
+// them.
+func deleteDirectory(c *gin.Context, store *storage.Store, prefix string) {
+	if c.Query("recursive") != "true" {
+		respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("%q is a directory; pass recursive=true to delete every key below it", prefix))
+		return
+	}
+	if prefix == "" {
+		respondError(c, 400, CodeInvalidRequest, "refusing to delete every key; delete a directory below the root")
+		return
+	}
+	if store.ReadOnly() {
+		respondStoreError(c, storage.ErrReadOnly)
+		return
+	}
+
+	keys, _ := store.Keys(prefix, "", 0)
+	for _, key := range keys {
+		if !canWrite(c, key) {
+			respondError(c, 403, CodeForbidden, fmt.Sprintf("access denied to %s", key))
+			return
+		}
+		if !checkWritableKey(c, key) {
+			return
+		}
+	}
+
+	deleted := store.DeleteKeys(keys)
+	c.JSON(200, gin.H{"status": "ok", "deleted": deleted})
+}
+
+// handleDataOptions answers OPTIONS on /data/*key with the methods the
+// path allows: directories can be listed and deleted, keys also written
+func handleDataOptions(readOnly bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		methods := []string{"GET", "HEAD"}
+		switch {
+		case readOnly:
+		case isDirectory(dataKey(c)):
+			methods = append(methods, "DELETE")
+		default:
+			methods = append(methods, "POST", "DELETE")
+		}
+		c.Header("Allow", strings.Join(append(methods, "OPTIONS"), ", "))
+		c.Status(204)
+	}
+}
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/threatflux/searchyaml/embedding"
+	"github.com/threatflux/searchyaml/stix"
 	"github.com/threatflux/searchyaml/storage"
 	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,21 +28,182 @@ var (
 	DataFile = flag.String("data", "data.yaml", "Data file path")
 
 	MaxSize      = flag.Int64("maxsize", 512<<20, "Maximum file size in bytes")
+	MaxEntries   = flag.Int("max-entries", 0, "Maximum number of entries (0 for unlimited)")
+	MaxIndexMem  = flag.Int64("max-index-memory", 0, "Estimated memory of all indexes in bytes at which new documents are rejected or evict others (0 for unlimited)")
+	LimitPolicy  = flag.String("limit-policy", storage.LimitReject, "Writes over -max-entries, -max-index-memory or an index limit: reject, or evict the least recently written entries")
 	SyncInterval = flag.Duration("sync", time.Minute, "Sync interval")
+	GCInterval   = flag.Duration("gc-interval", 0, "Interval of sweeps of expired entries (0 sweeps after every sync)")
+	GCMaxEntries = flag.Int("gc-max-entries", 0, "Expired entries removed per sweep (0 for all)")
+	GCPause      = flag.Duration("gc-pause-budget", 0, "Longest a sweep of expired entries blocks reads and writes (0 for unlimited)")
+	SyncWorkers  = flag.Int("sync-workers", 0, "Goroutines encoding large data sets on sync (default: number of CPUs)")
+	ReadOnly     = flag.Bool("readonly", false, "Reject requests that modify data with 403, serving only reads and searches")
+	ReadOnlyFile = flag.Bool("read-only", false, "Open the data file read-only with a shared lock; writes are rejected")
+	ShadowPath   = flag.String("shadow", "", "Mirror the data file to this path after every sync, e.g. on a network mount, for a warm standby")
+	Recover      = flag.Bool("recover", true, "Start with what a damaged data file still holds, reported by /admin/recovery-report, instead of refusing to start")
+	Persistence  = flag.String("persistence", "", "Data file persistence: mmap, file, atomic, or memory to write no files and lose the data on exit (default: mmap, file on Windows and 32-bit platforms)")
+	MLock        = flag.Bool("mlock", false, "Lock the data file mapping into RAM (needs a sufficient ulimit -l)")
+	Warmup       = flag.Bool("warmup", false, "Read every page of the data file on startup to avoid page faults on first reads")
+	HotKeys      = flag.Int("hot-keys", 100, "Number of most read keys tracked for /admin/hotkeys (0 disables)")
+	QueryCache   = flag.Int("query-cache", 1000, "Number of searches whose results are cached until a write changes them (0 disables)")
+	Compress     = flag.Int64("compress-threshold", 0, "Hold document values estimated at this many bytes or more compressed in memory (0 disables)")
+	LazyIndexes  = flag.Bool("lazy-indexes", false, "Serve requests while indexes over existing entries are built in the background")
+	PersistVecs  = flag.Bool("persist-vectors", false, "Keep vector indexes in a file next to the data file so they are not rebuilt on startup")
+	VecPayload   = flag.String("vector-payload", "", "Comma-separated fields kept with the vectors of the embedding index, so vector searches filter on them without other indexes")
+	TenantsFile  = flag.String("tenants", "", "Tenants configuration file (enables multi-tenancy)")
+	RedactFile   = flag.String("redact", "", "Secrets redaction rules file")
+	ACLFile      = flag.String("acl", "", "Access control rules file (enables API key ACLs)")
+	PipelineFile = flag.String("pipelines", "", "Ingest pipelines file")
+	ScorePlugins = flag.String("scoring-plugins", "", "Comma-separated Go plugins registering scorers and combiners for searches")
+	WatchDir     = flag.String("watch-dir", "", "Directory of YAML files to mirror into the store (key = relative path)")
+	MaxDocSize   = flag.Int64("max-doc-size", storage.DefaultDecodeLimits.MaxSize, "Maximum request document size in bytes (0 for unlimited)")
+	MaxYAMLDepth = flag.Int("max-yaml-depth", storage.DefaultDecodeLimits.MaxDepth, "Maximum nesting depth of YAML documents (0 for unlimited)")
+	MaxKeyLength = flag.Int("max-key-length", storage.DefaultMaxKeyLength, "Maximum key length in bytes (0 for unlimited)")
+	MaxYAMLAlias = flag.Int("max-yaml-aliases", storage.DefaultDecodeLimits.MaxAliasExpansion, "Maximum nodes produced by YAML alias expansion (0 for unlimited)")
+	MaxYAMLTime  = flag.Duration("max-yaml-time", storage.DefaultDecodeLimits.Timeout, "Longest a YAML request document may take to parse (0 for unlimited)")
+	MaxParses    = flag.Int("max-yaml-parses", storage.DefaultDecodeLimits.MaxParses, "Maximum YAML documents parsed at once under -max-yaml-time, counting those past it (0 for unlimited)")
+	StrictDecode = flag.Bool("strict-decode", false, "Reject unknown fields in the data file and request bodies (override per request with X-Decode-Mode)")
+	RoundTrip    = flag.Bool("roundtrip", false, "Store YAML request bodies verbatim, preserving order, comments and anchors")
+	GitCacheDir  = flag.String("git-cache", "git-cache", "Directory for repositories cloned by /admin/ingest/git")
+
+	K8sSync      = flag.Bool("k8s", false, "Mirror Kubernetes objects into the store")
+	K8sAPI       = flag.String("k8s-api", "", "Kubernetes API server URL (default: in-cluster service account)")
+	K8sToken     = flag.String("k8s-token", "", "Kubernetes bearer token for -k8s-api")
+	K8sResource  = flag.String("k8s-resource", "configmaps", "Resource to mirror: configmaps or group/version/resource")
+	K8sNamespace = flag.String("k8s-namespace", "", "Namespace to mirror (default: all namespaces)")
+	K8sSelector  = flag.String("k8s-selector", "", "Label selector for mirrored objects")
+
+	Peers       = flag.String("peers", "", "Comma-separated peer URLs; searches fan out to them and merge their results")
+	PeerTimeout = flag.Duration("peer-timeout", 5*time.Second, "Timeout of each request to a peer")
+	PeerAPIKey  = flag.String("peer-api-key", "", "API key sent to peers with federated searches and anti-entropy syncs")
+
+	SyncWithPeer     = flag.String("sync-with-peer", "", "Peer URL to reconcile the data with periodically by anti-entropy")
+	SyncWithInterval = flag.Duration("sync-with-interval", 5*time.Minute, "Interval of anti-entropy syncs with -sync-with-peer")
+
+	ConflictPolicy = flag.String("conflict-policy", "lww", "Resolution of entries from peers that differ from local ones: lww, version or merge")
+	VersionField   = flag.String("conflict-version-field", "version", "Numeric field compared by -conflict-policy version")
+
+	StrictIndexing = flag.Bool("strict-indexing", false, "Reject writes an index cannot accept instead of recording them in /admin/index-errors")
+
+	SlowQueryThreshold = flag.Duration("slowlog-threshold", 0, "Record searches taking at least this long in /admin/slowlog (0 disables)")
+	SlowLogSize        = flag.Int("slowlog-size", storage.DefaultSlowLogSize, "Number of slow queries kept")
+	SlowLogToLog       = flag.Bool("slowlog-log", false, "Also write slow queries to the log as JSON")
+
+	RecordFile    = flag.String("record", "", "Append sanitized requests and responses to this trace file for the replay command")
+	RecordBodies  = flag.String("record-bodies", recordHash, "Bodies recorded with -record: hash (size and SHA-256), full (masked by -redact) or none")
+	RecordSample  = flag.Float64("record-sample", 1, "Fraction of requests recorded with -record")
+	RecordMaxBody = flag.Int64("record-max-body", 64<<10, "Bodies larger than this many bytes are recorded by hash with -record-bodies full")
+
+	StatsInterval  = flag.Duration("stats-interval", time.Minute, "Interval of the stats samples kept for /admin/stats/history (0 disables)")
+	StatsRetention = flag.Duration("stats-retention", storage.DefaultStatsRetention, "How long stats samples are kept for /admin/stats/history")
+
+	AlertFileUsage   = flag.Float64("alert-file-usage", 0.8, "Alert when the data file uses more than this fraction of -maxsize (0 disables)")
+	AlertSyncLatency = flag.Duration("alert-sync-latency", 0, "Alert when syncs take longer than this on average over an interval (0 disables)")
+	AlertErrorRate   = flag.Float64("alert-error-rate", 0, "Alert when more than this fraction of requests fail with a server error over an interval (0 disables)")
+	AlertWebhook     = flag.String("alert-webhook", "", "URL posted a JSON event when an alert fires or resolves")
+	AlertInterval    = flag.Duration("alert-interval", 30*time.Second, "Interval of evaluations of the alert rules")
+
+	BlockProfileRate = flag.Int("block-profile-rate", 0, "Record goroutine blocking events lasting this many nanoseconds for /admin/pprof/block (0 disables)")
+	MutexProfileFrac = flag.Int("mutex-profile-fraction", 0, "Report 1 in N mutex contention events for /admin/pprof/mutex (0 disables)")
+
+	EnrichAttack     = flag.Bool("enrich-attack", false, "Add attack_techniques to documents mentioning ATT&CK technique IDs")
+	ReservedPrefixes = flag.String("reserved-key-prefixes", "", "Comma-separated key prefixes that cannot be written or deleted through the API, e.g. k8s/")
+
+	ScanMaxSize = flag.Int64("scan-max-size", 32<<20, "Maximum content size accepted by /scan in bytes")
+
+	BlobDir      = flag.String("blob-dir", "", "Directory storing blobs uploaded to /data/:key/_blob")
+	BlobS3       = flag.String("blob-s3", "", "S3 bucket storing blobs instead of -blob-dir, as https://host/bucket (credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	BlobS3Region = flag.String("blob-s3-region", "us-east-1", "Region of -blob-s3")
+	MaxBlobSize  = flag.Int64("max-blob-size", 1<<30, "Maximum blob size in bytes (0 for unlimited)")
+
+	OriginSpec    = flag.String("origin", "", "Origin fetched on a miss of GET /data/:key: a URL template such as https://config.internal/v1/{key}, or the path of a script run with get, put or delete and the key")
+	OriginTTL     = flag.Duration("origin-ttl", 5*time.Minute, "TTL of documents cached from -origin (0 for no expiry)")
+	OriginTimeout = flag.Duration("origin-timeout", 10*time.Second, "Timeout of each request to -origin")
+	OriginWrite   = flag.Bool("origin-write-through", false, "Send writes and deletes of /data/:key to -origin before storing them")
+
+	Embedder        = flag.String("embedder", "", "Embedder of chunks written with chunk_field: hash:<dims> for local feature hashing, or the URL of an OpenAI-compatible embeddings API")
+	EmbedderModel   = flag.String("embedder-model", "", "Model name sent to the -embedder API")
+	EmbedderAPIKey  = flag.String("embedder-api-key", "", "Bearer token sent to the -embedder API")
+	EmbedderTimeout = flag.Duration("embedder-timeout", 30*time.Second, "Timeout of each request to the -embedder API")
+	EmbedField      = flag.String("embed-field", "embedding", "Field of chunks holding their embedding, which needs a vector index")
+
+	TAXIIURL      = flag.String("taxii-url", "", "TAXII 2.1 collection URL to poll for STIX objects")
+	TAXIIUser     = flag.String("taxii-user", "", "TAXII basic auth username")
+	TAXIIPassword = flag.String("taxii-password", "", "TAXII basic auth password")
+	TAXIIInterval = flag.Duration("taxii-interval", 15*time.Minute, "TAXII poll interval")
 )
 
+// commands are run by `searchyaml <command> [flags]` instead of the server.
+// They exit with the codes of exitCode when they fail.
+var commands = map[string]func(args []string) error{
+	"seed":      runSeed,
+	"migrate":   runMigrate,
+	"mcp":       runMCP,
+	"replay":    runReplay,
+	"bench-ann": runBenchANN,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Print(err)
+				os.Exit(exitCode(err))
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if !*Debug {
 		gin.SetMode(gin.ReleaseMode)
 	}
+	configureProfiling(*BlockProfileRate, *MutexProfileFrac)
+	if err := LoadScoringPlugins(*ScorePlugins); err != nil {
+		log.Fatalf("Failed to load scoring plugins: %v", err)
+	}
+
 	// Initialize store with options
 	opts := storage.StoreOptions{
 		InitialSize:  *InitialSize,
 		MaxSize:      *MaxSize,
+		MaxEntries:   *MaxEntries,
+		LimitPolicy:  *LimitPolicy,
 		SyncInterval: *SyncInterval,
 		Debug:        *Debug,
+		EnrichAttack: *EnrichAttack,
+		MaxKeyLength: *MaxKeyLength,
+		StrictDecode: *StrictDecode,
+		SyncWorkers:  *SyncWorkers,
+		LazyIndexes:  *LazyIndexes,
+		MLock:        *MLock,
+		Persistence:  *Persistence,
+		ReadOnly:     *ReadOnlyFile,
+		ShadowPath:   *ShadowPath,
+		Recover:      *Recover,
+		Warmup:       *Warmup,
+
+		CompressThreshold: *Compress,
+		MaxIndexMemory:    *MaxIndexMem,
+		HotKeys:           *HotKeys,
+		QueryCacheSize:    *QueryCache,
+		PersistVectors:    *PersistVecs,
+
+		GCInterval:    *GCInterval,
+		GCMaxEntries:  *GCMaxEntries,
+		GCPauseBudget: *GCPause,
+
+		StrictIndexing: *StrictIndexing,
+
+		SlowQueryThreshold: *SlowQueryThreshold,
+		SlowLogSize:        *SlowLogSize,
+		SlowLogToLog:       *SlowLogToLog,
+
+		StatsInterval:  *StatsInterval,
+		StatsRetention: *StatsRetention,
+
+		ConflictPolicy: *ConflictPolicy,
+		VersionField:   *VersionField,
 	}
 
 	store, err := storage.NewStore(*DataFile, opts)
@@ -51,40 +222,256 @@ func main() {
 		log.Fatalf("Failed to create indexes: %v", err)
 	}
 
+	if *WatchDir != "" {
+		if err := startDirWatcher(store, *WatchDir); err != nil {
+			log.Fatalf("Failed to watch directory: %v", err)
+		}
+	}
+
+	var k8sFeed *K8sChangeFeed
+	if *K8sSync {
+		k8sFeed = NewK8sChangeFeed()
+		if err := startK8sSync(store, *K8sAPI, *K8sToken, *K8sResource, *K8sNamespace, *K8sSelector, k8sFeed); err != nil {
+			log.Fatalf("Failed to start Kubernetes sync: %v", err)
+		}
+	}
+
+	if *TAXIIURL != "" {
+		startTAXIIPoller(store, stix.NewTAXIIClient(*TAXIIURL, *TAXIIUser, *TAXIIPassword), *TAXIIInterval)
+	}
+
+	tenants, err := NewTenantRegistry(*TenantsFile, store, opts)
+	if err != nil {
+		log.Fatalf("Failed to load tenants: %v", err)
+	}
+	defer tenants.Close()
+
+	monitor, err := NewMonitor(AlertRules{
+		FileUsage:   *AlertFileUsage,
+		SyncLatency: *AlertSyncLatency,
+		ErrorRate:   *AlertErrorRate,
+		Webhook:     *AlertWebhook,
+		Interval:    *AlertInterval,
+	}, tenants, *MaxSize)
+	if err != nil {
+		log.Fatalf("Failed to configure alerts: %v", err)
+	}
+	monitor.Start()
+
+	redaction, err := LoadRedaction(*RedactFile)
+	if err != nil {
+		log.Fatalf("Failed to load redaction rules: %v", err)
+	}
+
+	recorder, err := NewRecorder(*RecordFile, *RecordBodies, *RecordSample, *RecordMaxBody, redaction)
+	if err != nil {
+		log.Fatalf("Failed to configure recording: %v", err)
+	}
+	defer recorder.Close()
+
+	acl, err := LoadACL(*ACLFile)
+	if err != nil {
+		log.Fatalf("Failed to load ACL: %v", err)
+	}
+	tenants.knownKey = func(key string) bool {
+		return acl.knows(key) || redaction.reveals(key)
+	}
+
+	pipelines, err := LoadPipelines(*PipelineFile)
+	if err != nil {
+		log.Fatalf("Failed to load pipelines: %v", err)
+	}
+
+	blobs, err := openBlobStore()
+	if err != nil {
+		log.Fatalf("Failed to open blob storage: %v", err)
+	}
+
+	origin, err := NewOrigin(*OriginSpec, *OriginTTL, *OriginTimeout, *OriginWrite)
+	if err != nil {
+		log.Fatalf("Failed to configure origin: %v", err)
+	}
+
+	chunker, err := NewChunker(*Embedder, embedding.Options{Model: *EmbedderModel, APIKey: *EmbedderAPIKey, Timeout: *EmbedderTimeout}, *EmbedField)
+	if err != nil {
+		log.Fatalf("Failed to configure embedder: %v", err)
+	}
+
+	gitIngester := NewGitIngester(*GitCacheDir)
+	federation := NewFederation(*Peers, *PeerTimeout, *PeerAPIKey)
+	if federation != nil {
+		log.Printf("Federating searches with %d peers", len(federation.peers))
+	}
+	if *SyncWithPeer != "" {
+		startSyncWith(store, *SyncWithPeer, *PeerAPIKey, *PeerTimeout, *SyncWithInterval)
+	}
+
 	r := gin.New()
-	r.Use(gin.Recovery())
+	r.UseRawPath = true // Match percent-encoded slashes in keys as part of :key
+	r.Use(monitor.countRequests())
+	r.Use(gin.CustomRecovery(func(c *gin.Context, _ interface{}) {
+		respondError(c, 500, CodeInternal, "internal server error")
+	}))
 	if *Debug {
 		r.Use(gin.Logger())
 	}
 
+	// Readiness probe, registered before authentication
+	r.GET("/readyz", handleReady(store, monitor))
+
+	// API documentation and the admin UI, also public; the UI sends the
+	// API key entered by the user with its requests
+	r.GET("/openapi.json", handleOpenAPI(r))
+	r.GET("/docs", handleDocs)
+	r.GET("/ui", handleUI)
+
+	r.Use(bodyLimitMiddleware(*MaxDocSize))
+	r.Use(recordMiddleware(recorder))
+	r.Use(readOnlyMiddleware(*ReadOnly || *ReadOnlyFile))
+	r.Use(tenantMiddleware(tenants))
+	r.Use(aclMiddleware(acl))
+	r.Use(redactionMiddleware(redaction))
+	r.NoRoute(func(c *gin.Context) {
+		respondError(c, 404, CodeNotFound, "route not found")
+	})
+
 	// CRUD endpoints
 	data := r.Group("/data")
 	{
-		data.GET("/:key", handleGet(store))
-		data.POST("/:key", handleSet(store))
-		data.DELETE("/:key", handleDelete(store))
+		data.GET("", handleListKeys(store))
+		data.OPTIONS("", handleOptions("GET"))
+		data.GET("/*key", handleOrigin(store, origin, handleBlob(store, blobs, handleLinks(store, handleTags(store, handleGet(store))))))
+		data.HEAD("/*key", handleOrigin(store, origin, handleBlob(store, blobs, handleLinks(store, handleTags(store, handleHead(store))))))
+		data.POST("/*key", handleOrigin(store, origin, handleBlob(store, blobs, handleLinks(store, handleTags(store, handleDataPost(store, pipelines, chunker))))))
+		data.DELETE("/*key", handleOrigin(store, origin, handleBlob(store, blobs, handleLinks(store, handleTags(store, handleDelete(store))))))
+		data.OPTIONS("/*key", handleDataOptions(*ReadOnly || *ReadOnlyFile))
 	}
 
 	// Search endpoints
 	search := r.Group("/search")
 	{
-		search.POST("/text", handleTextSearch(store))
-		search.POST("/vector", handleVectorSearch(store))
-		search.POST("/combined", handleCombinedSearch(store))
+		search.POST("/text", handleTextSearch(store, federation))
+		search.POST("/vector", handleVectorSearch(store, federation))
+		search.POST("/combined", handleCombinedSearch(store, federation))
+		search.POST("/count", handleCount(store))
+		search.POST("/exists", handleExists(store))
+		search.POST("/aggregate", handleAggregate(store))
+		search.GET("/attack/:technique", handleAttackSearch(store))
+		search.GET("/scorers", handleListScorers())
+	}
+
+	// Materialized views; defining and dropping them is reserved to admins
+	views := r.Group("/views")
+	{
+		views.GET("", handleListViews(store))
+		views.GET("/:name", handleGetView(store))
+		views.PUT("/:name", requireAdmin(), handlePutView(store))
+		views.DELETE("/:name", requireAdmin(), handleDeleteView(store))
 	}
 
+	// Read-only SQL over the indexes
+	r.POST("/sql", handleSQL(store))
+
+	// GraphQL over entries and searches
+	r.GET("/graphql", handleGraphQL(store, pipelines))
+	r.POST("/graphql", handleGraphQL(store, pipelines))
+	r.GET("/graphql/schema", handleGraphQLSchema())
+
+	// MCP tools for LLM agents
+	r.POST("/mcp", handleMCP(store, pipelines))
+	r.GET("/mcp", func(c *gin.Context) {
+		c.Header("Allow", "POST")
+		respondError(c, 405, CodeInvalidRequest, "POST MCP messages; the server does not stream messages of its own")
+	})
+
+	// Tag counts of keyword fields
+	r.GET("/tags", handleTagCloud(store))
+
+	// Traversal of the links between keys
+	r.POST("/graph/traverse", handleTraverse(store))
+
+	// Retriever API for RAG frameworks
+	r.POST("/v1/retrieve", handleRetrieve(store))
+
+	// STIX endpoints
+	stixGroup := r.Group("/stix")
+	{
+		stixGroup.POST("/bundle", handleSTIXIngest(store))
+		stixGroup.POST("/export", handleSTIXExport(store))
+	}
+
+	// Ingest pipeline endpoints
+	pipelineGroup := r.Group("/pipelines", requireAdmin())
+	{
+		pipelineGroup.GET("", handleListPipelines(pipelines))
+		pipelineGroup.GET("/:name", handleGetPipeline(pipelines))
+		pipelineGroup.PUT("/:name", handlePutPipeline(pipelines))
+		pipelineGroup.DELETE("/:name", handleDeletePipeline(pipelines))
+		pipelineGroup.POST("/:name/simulate", handleSimulatePipeline(pipelines))
+	}
+
+	// YARA scanning endpoint
+	r.POST("/scan", handleScan(store))
+
 	// Index management endpoints
-	index := r.Group("/index")
+	index := r.Group("/index", requireAdmin())
 	{
 		index.POST("/create", handleCreateIndex(store))
+		index.POST("/estimate", handleEstimateIndex(store))
+		index.POST("/:field/optimize", handleOptimizeIndex(store))
 		index.DELETE("/remove", handleRemoveIndex(store))
+		index.GET("/coercions", handleCoercions(store))
+		index.GET("/limits", handleIndexLimits(store))
+		index.GET("/tokenizers", handleTokenizers())
 	}
 
+	// Prometheus metrics, for scrapers with an admin API key
+	r.GET("/metrics", requireAdmin(), handleMetrics(store, tenants))
+
 	// Admin endpoints
-	admin := r.Group("/admin")
+	admin := r.Group("/admin", requireServerAdmin())
 	{
 		admin.POST("/sync", handleSync(store))
 		admin.GET("/stats", handleStats(store))
+		admin.GET("/stats/history", handleStatsHistory(store))
+		admin.GET("/alerts", handleAlerts(monitor))
+		admin.POST("/stats/reset", handleResetStats(store))
+		admin.GET("/memory", handleMemory(store))
+		admin.GET("/hotkeys", handleHotKeys(store))
+		admin.DELETE("/hotkeys", handleResetHotKeys(store))
+		admin.GET("/slowlog", handleSlowLog(store))
+		admin.DELETE("/slowlog", handleResetSlowLog(store))
+		admin.GET("/index-errors", handleIndexErrors(store))
+		admin.DELETE("/index-errors", handleResetIndexErrors(store))
+		admin.GET("/index-usage", handleIndexUsage(store))
+		admin.GET("/jobs", handleJobs(store))
+		admin.GET("/jobs/:id", handleJob(store))
+		admin.DELETE("/jobs/:id", handleCancelJob(store))
+		admin.POST("/blobs/gc", handleBlobGC(store, blobs))
+		admin.POST("/verify", handleVerify(store))
+		admin.GET("/conflicts", handleConflicts(store))
+		admin.GET("/recovery-report", handleRecoveryReport(store))
+		admin.DELETE("/conflicts", handleResetConflicts(store))
+		admin.POST("/gc", handleGC(store))
+		admin.GET("/goroutines", handleGoroutines())
+		admin.GET("/pprof/*profile", handlePprof())
+		admin.POST("/pprof/*profile", handlePprof())
+		admin.GET("/tenants", handleTenants(tenants))
+		admin.GET("/acl", handleGetACL(acl))
+		admin.PUT("/acl", handleSetACL(acl))
+		admin.GET("/ingest/git", handleGitSources(gitIngester))
+		admin.POST("/ingest/git", handleGitIngest(gitIngester, store))
+		admin.GET("/k8s/changes", handleK8sChanges(k8sFeed))
+		admin.POST("/merkle", handleMerkle(store))
+		admin.POST("/entries", handleGetEntries(store))
+		admin.PUT("/entries", handlePutEntries(store))
+		admin.POST("/sync-with", handleSyncWith(store))
+		if storage.FaultsEnabled {
+			log.Printf("Warning: fault injection is compiled in; do not use this build in production")
+			admin.GET("/faults", handleFaults())
+			admin.POST("/faults", handleSetFault())
+			admin.DELETE("/faults", handleClearFaults())
+		}
 	}
 
 	log.Printf("Starting server on %s", *Port)
@@ -95,73 +482,339 @@ func main() {
 
 // Handler functions
 
+// defaultKeyListLimit is the page size of GET /data without a limit
+const defaultKeyListLimit = 100
+
+// KeyList is a page of keys returned by GET /data and directory listings
+type KeyList struct {
+	Keys []string `json:"keys"`
+	Dirs []string `json:"dirs,omitempty"` // Subdirectories of a directory listing
+	Next string   `json:"next,omitempty"` // Pass as after to fetch the next page
+}
+
+// handleListKeys lists the readable keys with an optional prefix in sorted
+// order, a page at a time
+func handleListKeys(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		listKeys(c, store, c.Query("prefix"), false)
+	}
+}
+
 func handleGet(store *storage.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		key := c.Param("key")
-		entry, exists := store.Get(key)
+		store := tenantStore(c, store)
+		key := dataKey(c)
+		if isDirectory(key) {
+			listKeys(c, store, key, c.Query("recursive") != "true")
+			return
+		}
+		if key, ok := strings.CutSuffix(key, hashSuffix); ok {
+			respondHash(c, store, key)
+			return
+		}
+		if !canRead(c, key) {
+			respondError(c, 403, CodeForbidden, "access denied")
+			return
+		}
+
+		entry, exists, err := readEntry(c, store, key)
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
 		if !exists {
-			c.JSON(404, gin.H{"error": "key not found"})
+			respondError(c, 404, CodeNotFound, "key not found")
+			return
+		}
+
+		setEntryHeaders(c, key, entry)
+		entry = redactEntry(c, entry)
+		if c.Query("format") == "raw" {
+			writeRawEntry(c, entry)
 			return
 		}
 
 		if c.GetHeader("Accept") == "application/x-yaml" {
-			c.YAML(200, gin.H{key: entry})
+			c.YAML(200, gin.H{key: withoutSource(entry)})
 		} else {
 			c.JSON(200, gin.H{key: entry})
 		}
 	}
 }
 
-func handleSet(store *storage.Store) gin.HandlerFunc {
+// handleHead answers GET /data/*key without the body, so clients can check
+// that a key exists and whether it changed without fetching the document
+func handleHead(store *storage.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		key := c.Param("key")
+		store := tenantStore(c, store)
+		key := dataKey(c)
+		if isDirectory(key) {
+			if !directoryExists(c, store, key) {
+				c.AbortWithStatus(404)
+				return
+			}
+			c.Status(200)
+			return
+		}
+		if !canRead(c, key) {
+			c.AbortWithStatus(403)
+			return
+		}
+
+		entry, exists, err := readEntry(c, store, key)
+		if err != nil {
+			status, _ := errorStatus(err)
+			c.AbortWithStatus(status)
+			return
+		}
+		if !exists {
+			c.AbortWithStatus(404)
+			return
+		}
+		setEntryHeaders(c, key, entry)
+		c.Status(200)
+	}
+}
+
+// readEntry gets the entry of key, moving its expiry to the duration in the
+// X-Touch header from now when the request sets one
+func readEntry(c *gin.Context, store *storage.Store, key string) (*storage.Entry, bool, error) {
+	touch := c.GetHeader("X-Touch")
+	if touch == "" {
+		entry, exists := store.Get(key)
+		return entry, exists, nil
+	}
+
+	ttl, err := storage.ParseTTL(touch)
+	if err != nil {
+		return nil, false, err
+	}
+	entry, exists := store.Touch(key, ttl)
+	return entry, exists, nil
+}
+
+// setEntryHeaders describes an entry in response headers: ETag is a digest
+// of the entry that changes with its value, expiry or metadata, X-Entry-Size
+// the size of its document in bytes and X-TTL the time left before it
+// expires
+func setEntryHeaders(c *gin.Context, key string, entry *storage.Entry) {
+	c.Header("ETag", `"`+storage.EntryDigest(key, entry)[:16]+`"`)
+	c.Header("Last-Modified", time.Unix(entry.Timestamp, 0).UTC().Format(http.TimeFormat))
+	if size := entrySize(entry); size >= 0 {
+		c.Header("X-Entry-Size", strconv.Itoa(size))
+	}
+	if entry.TTL > 0 {
+		left := max(entry.Timestamp+entry.TTL-time.Now().Unix(), 0)
+		c.Header("X-TTL", (time.Duration(left) * time.Second).String())
+	}
+	setMetadataHeaders(c, entry.Metadata)
+}
+
+// entrySize returns the size of an entry's document: its YAML source for
+// round-trip entries, otherwise its JSON encoding, or -1 if it cannot be
+// encoded
+func entrySize(entry *storage.Entry) int {
+	if entry.Source != "" {
+		return len(entry.Source)
+	}
+	raw, err := json.Marshal(entry.Value)
+	if err != nil {
+		return -1
+	}
+	return len(raw)
+}
+
+// handleOptions answers OPTIONS with the methods a route allows
+func handleOptions(methods ...string) gin.HandlerFunc {
+	allow := strings.Join(append(methods, "OPTIONS"), ", ")
+	return func(c *gin.Context) {
+		c.Header("Allow", allow)
+		c.Status(204)
+	}
+}
+
+func handleSet(store *storage.Store, pipelines *Pipelines, chunker *Chunker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		key := dataKey(c)
+		if isDirectory(key) {
+			respondError(c, 400, CodeInvalidKey, "invalid key: keys may not be empty or end with /, which names a directory")
+			return
+		}
+		if !canWrite(c, key) {
+			respondError(c, 403, CodeForbidden, "access denied")
+			return
+		}
+		if !checkWritableKey(c, key) {
+			return
+		}
+
 		var value interface{}
+		var source []byte
 
 		// Parse request body based on content type
-		if err := parseRequestBody(c, &value); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+		if roundTripRequested(c) {
+			if c.Query("pipeline") != "" {
+				respondError(c, 400, CodeInvalidRequest, "pipelines cannot be applied to round-trip documents")
+				return
+			}
+			var err error
+			if source, value, err = readRoundTripBody(c); err != nil {
+				respondBadRequest(c, err)
+				return
+			}
+		} else if err := parseRequestBody(c, &value); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		value, ok := applyPipeline(c, pipelines, value)
+		if !ok {
+			return
+		}
+
+		rules, _, err := parseYARADocument(value)
+		if err != nil {
+			respondBadRequest(c, err)
 			return
 		}
 
-		// Handle TTL if specified
+		// Chunks are embedded before anything is written, so a failing
+		// embedder leaves the stored document as it was
+		chunking, err := requestedChunking(c)
+		if err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		var chunks []map[string]interface{}
+		if chunking != nil {
+			if chunks, err = chunker.split(c.Request.Context(), key, value, chunking); errors.Is(err, errEmbedding) {
+				respondError(c, 502, CodeUpstreamFailed, err.Error())
+				return
+			} else if err != nil {
+				respondBadRequest(c, err)
+				return
+			}
+		}
+
+		// Handle TTL if specified; a sliding TTL is also the initial one
+		var duration, sliding time.Duration
 		if ttl := c.GetHeader("X-TTL"); ttl != "" {
-			duration, err := time.ParseDuration(ttl)
-			if err != nil {
-				c.JSON(400, gin.H{"error": "invalid TTL format"})
+			if duration, err = storage.ParseTTL(ttl); err != nil {
+				respondStoreError(c, err)
 				return
 			}
-			if err := store.SetWithTTL(key, value, duration); err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+		}
+		if ttl := c.GetHeader("X-Sliding-TTL"); ttl != "" {
+			if duration != 0 {
+				respondError(c, 400, CodeInvalidTTL, "set either X-TTL or X-Sliding-TTL")
 				return
 			}
-		} else {
-			if err := store.Set(key, value); err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+			if sliding, err = storage.ParseTTL(ttl); err != nil {
+				respondStoreError(c, err)
 				return
 			}
+			duration = sliding
 		}
 
-		c.JSON(200, gin.H{"status": "ok"})
+		meta, err := requestMetadata(c)
+		if err != nil {
+			respondError(c, 400, CodeInvalidRequest, err.Error())
+			return
+		}
+
+		if source != nil {
+			err = store.SetYAML(key, source, duration, meta)
+		} else {
+			err = store.SetWithMetadata(key, value, duration, meta)
+		}
+		if err == nil && sliding != 0 {
+			err = store.SetSliding(key, sliding)
+		}
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		if err := indexYARARules(store, key, rules); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		if chunking == nil {
+			deleteChunks(store, key)
+			c.JSON(200, gin.H{"status": "ok"})
+			return
+		}
+		if err := writeChunks(store, key, chunks, duration, meta); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "chunks": len(chunks)})
 	}
 }
 
 func handleDelete(store *storage.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		store.Delete(c.Param("key"))
+		store := tenantStore(c, store)
+		key := dataKey(c)
+		if isDirectory(key) {
+			deleteDirectory(c, store, key)
+			return
+		}
+		if !canWrite(c, key) {
+			respondError(c, 403, CodeForbidden, "access denied")
+			return
+		}
+		if !checkWritableKey(c, key) {
+			return
+		}
+
+		if store.ReadOnly() {
+			respondStoreError(c, storage.ErrReadOnly)
+			return
+		}
+
+		store.Delete(key)
+		deleteChunks(store, key)
 		c.JSON(200, gin.H{"status": "ok"})
 	}
 }
 
-func handleTextSearch(store *storage.Store) gin.HandlerFunc {
+// TextSearchRequest is the body of POST /search/text
+type TextSearchRequest struct {
+	Text       string            `json:"text" binding:"required"`
+	MaxResults int               `json:"max_results"`
+	MinScore   float64           `json:"min_score"`
+	Expr       string            `json:"expr"`
+	Fields     map[string]string `json:"fields"`
+	Explain    bool              `json:"explain"`
+	Sample     int               `json:"sample"`
+
+	ReturnValues *bool `json:"return_values"`
+}
+
+// VectorSearchRequest is the body of POST /search/vector
+type VectorSearchRequest struct {
+	Vector      []float32         `json:"vector" binding:"required"`
+	VectorField string            `json:"vector_field"` // Vector index to search, by default every one of the vector's dimensions
+	MaxResults  int               `json:"max_results"`
+	MinScore    float64           `json:"min_score"`
+	Expr        string            `json:"expr"`
+	Fields      map[string]string `json:"fields"`
+	Explain     bool              `json:"explain"`
+
+	ReturnValues *bool `json:"return_values"`
+}
+
+func handleTextSearch(store *storage.Store, federation *Federation) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var query struct {
-			Text       string  `json:"text" binding:"required"`
-			MaxResults int     `json:"max_results"`
-			MinScore   float64 `json:"min_score"`
-		}
+		store := tenantStore(c, store)
+		var query TextSearchRequest
 
 		if err := c.ShouldBindJSON(&query); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			respondBadRequest(c, err)
 			return
 		}
 
@@ -169,69 +822,118 @@ func handleTextSearch(store *storage.Store) gin.HandlerFunc {
 			Text:       query.Text,
 			MaxResults: query.MaxResults,
 			MinScore:   query.MinScore,
-		}
+			Expr:       query.Expr,
+			Fields:     query.Fields,
+			Explain:    query.Explain,
+			Sample:     query.Sample,
 
-		results, err := store.Search(searchQuery)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
+			ReturnValues: query.ReturnValues,
 		}
 
-		c.JSON(200, results)
+		respondSearch(c, store, federation, searchQuery)
 	}
 }
 
-func handleVectorSearch(store *storage.Store) gin.HandlerFunc {
+func handleVectorSearch(store *storage.Store, federation *Federation) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var query struct {
-			Vector     []float32 `json:"vector" binding:"required"`
-			MaxResults int       `json:"max_results"`
-			MinScore   float64   `json:"min_score"`
-		}
+		store := tenantStore(c, store)
+		var query VectorSearchRequest
 
 		if err := c.ShouldBindJSON(&query); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			respondBadRequest(c, err)
 			return
 		}
 
 		searchQuery := storage.SearchQuery{
-			Vector:     query.Vector,
-			MaxResults: query.MaxResults,
-			MinScore:   query.MinScore,
-		}
+			Vector:      query.Vector,
+			VectorField: query.VectorField,
+			MaxResults:  query.MaxResults,
+			MinScore:    query.MinScore,
+			Expr:        query.Expr,
+			Fields:      query.Fields,
+			Explain:     query.Explain,
 
-		results, err := store.Search(searchQuery)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
+			ReturnValues: query.ReturnValues,
 		}
 
-		c.JSON(200, results)
+		respondSearch(c, store, federation, searchQuery)
 	}
 }
 
-func handleCombinedSearch(store *storage.Store) gin.HandlerFunc {
+func handleCombinedSearch(store *storage.Store, federation *Federation) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		store := tenantStore(c, store)
 		var query storage.SearchQuery
 		if err := c.ShouldBindJSON(&query); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			respondBadRequest(c, err)
 			return
 		}
 
-		results, err := store.Search(query)
+		respondSearch(c, store, federation, query)
+	}
+}
+
+// respondSearch runs a search and writes the readable, redacted results.
+// With federation the results of the peers are merged in, except for
+// searches forwarded by another node. Explain queries run locally and return
+// {"results": [...], "explain": {...}} instead of the bare result list.
+// Results are exported as CSV or Parquet when the request asks for them.
+func respondSearch(c *gin.Context, store *storage.Store, federation *Federation, query storage.SearchQuery) {
+	format := exportFormat(c)
+	if format != "" && query.Explain {
+		respondBadRequest(c, fmt.Errorf("explain is not available as %s", format))
+		return
+	}
+	if !query.Explain {
+		results, err := store.SearchReadable(query, readableKeys(c))
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			respondStoreError(c, err)
+			return
+		}
+		if c.GetHeader(federatedHeader) != "" {
+			c.Header(federatedTenantHeader, tenantName(c))
+		} else if federation != nil {
+			peerResults, failed := federation.search(c, query)
+			if len(failed) > 0 {
+				c.Header(federationFailedHeader, strings.Join(failed, ","))
+			}
+			// Peers search with their own API key, so their results are
+			// filtered here, before the merge cuts them to max_results
+			for i := range peerResults {
+				peerResults[i] = filterReadable(c, peerResults[i])
+			}
+			results = mergeFederated(append([][]storage.SearchResult{results}, peerResults...), query.MaxResults)
+			if query.Sample > 0 {
+				// Each node sampled its own matches; sample their union
+				results = storage.SampleResults(results, query.Sample)
+			}
+		}
+		results = redactResults(c, results)
+		if format != "" {
+			rows := searchRows(results)
+			respondExport(c, format, rows, exportColumns(c, rows, true))
 			return
 		}
-
 		c.JSON(200, results)
+		return
+	}
+
+	results, explanation, err := store.Explain(query, readableKeys(c))
+	if err != nil {
+		respondStoreError(c, err)
+		return
 	}
+	c.JSON(200, gin.H{
+		"results": redactResults(c, results),
+		"explain": explanation,
+	})
 }
 
 func handleSync(store *storage.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		store := tenantStore(c, store)
 		if err := store.Sync(); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			respondStoreError(c, err)
 			return
 		}
 		c.JSON(200, gin.H{"status": "ok"})
@@ -240,6 +942,7 @@ func handleSync(store *storage.Store) gin.HandlerFunc {
 
 func handleStats(store *storage.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		store := tenantStore(c, store)
 		stats := store.GetStats()
 		if c.GetHeader("Accept") == "application/x-yaml" {
 			c.YAML(200, stats)
@@ -249,21 +952,217 @@ func handleStats(store *storage.Store) gin.HandlerFunc {
 	}
 }
 
+// handleStatsHistory returns the stats samples of the last ?window=, e.g.
+// 1h, or all those kept
+func handleStatsHistory(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var window time.Duration
+		if w := c.Query("window"); w != "" {
+			d, err := time.ParseDuration(w)
+			if err != nil || d <= 0 {
+				respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("invalid window %q: use a positive duration such as 1h", w))
+				return
+			}
+			window = d
+		}
+
+		history := store.StatsHistory(window)
+		if history == nil {
+			respondError(c, 404, CodeNotFound, "stats history is disabled; start with -stats-interval")
+			return
+		}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, history)
+		} else {
+			c.JSON(200, history)
+		}
+	}
+}
+
+func handleSlowLog(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		queries := store.SlowQueries()
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, queries)
+		} else {
+			c.JSON(200, queries)
+		}
+	}
+}
+
+func handleResetSlowLog(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		store.ResetSlowLog()
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
+
+// handleHotKeys lists the most read keys, hottest first, up to ?limit
+func handleHotKeys(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		limit := 0
+		if l := c.Query("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n <= 0 {
+				respondError(c, 400, CodeInvalidRequest, "limit must be a positive integer")
+				return
+			}
+			limit = n
+		}
+
+		keys := store.HotKeys(limit)
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, gin.H{"keys": keys})
+		} else {
+			c.JSON(200, gin.H{"keys": keys})
+		}
+	}
+}
+
+func handleResetHotKeys(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		store.ResetHotKeys()
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
+
+func handleIndexErrors(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		report := store.IndexErrors()
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, report)
+		} else {
+			c.JSON(200, report)
+		}
+	}
+}
+
+// defaultUnusedDays is the window of /admin/index-usage without ?days=
+const defaultUnusedDays = 7
+
+// handleIndexUsage reports how much searches read each index, flagging
+// those not read in the last ?days=
+func handleIndexUsage(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		days := defaultUnusedDays
+		if d := c.Query("days"); d != "" {
+			n, err := strconv.Atoi(d)
+			if err != nil || n <= 0 {
+				respondError(c, 400, CodeInvalidRequest, "days must be a positive integer")
+				return
+			}
+			days = n
+		}
+
+		report := store.IndexUsage(time.Duration(days) * 24 * time.Hour)
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, report)
+		} else {
+			c.JSON(200, report)
+		}
+	}
+}
+
+func handleResetIndexErrors(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		store.ResetIndexErrors()
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
+
+func handleConflicts(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		report := store.Conflicts()
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, report)
+		} else {
+			c.JSON(200, report)
+		}
+	}
+}
+
+func handleResetConflicts(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		store.ResetConflicts()
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
+
+func handleRecoveryReport(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		report := store.RecoveryReport()
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, report)
+		} else {
+			c.JSON(200, report)
+		}
+	}
+}
+
+// handleReady reports 200 once the data is loaded and every index is built,
+// 503 while indexes are still building. A server with firing alerts is
+// still ready but degraded; the rules firing are listed, their details are
+// reserved to /admin/alerts.
+func handleReady(store *storage.Store, monitor *Monitor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := store.Startup()
+		if !status.Ready() {
+			c.JSON(503, gin.H{"status": "indexing", "startup": status})
+			return
+		}
+		response := gin.H{"status": "ready", "startup": status, "degraded": false}
+		if firing := monitor.Firing(); len(firing) > 0 {
+			rules := make([]string, 0, len(firing))
+			for _, alert := range firing {
+				if len(rules) == 0 || rules[len(rules)-1] != alert.Rule {
+					rules = append(rules, alert.Rule)
+				}
+			}
+			response["degraded"] = true
+			response["alerts"] = rules
+		}
+		c.JSON(200, response)
+	}
+}
+
 // Helper functions
 
 func createDefaultIndexes(store *storage.Store) error {
-	defaults := []struct {
+	type index struct {
 		field string
 		type_ string
-	}{
+	}
+	defaults := []index{
 		{"title", "text"},
 		{"description", "text"},
 		{"tags", "text"},
+		{"tags", "keyword"},
+		{yaraIndexField, "text"},
+		{storage.AttackField, "text"},
 		{"embedding", "vector"},
 	}
+	// Blob metadata is searchable once blobs can be stored
+	if *BlobDir != "" || *BlobS3 != "" {
+		defaults = append(defaults, index{blobSHA256Field, "keyword"}, index{blobContentTypeField, "keyword"})
+	}
 
 	for _, idx := range defaults {
-		if err := store.CreateIndex(idx.field, idx.type_); err != nil {
+		var opts storage.IndexOptions
+		if idx.type_ == "vector" && *VecPayload != "" {
+			opts.Payload = strings.Split(*VecPayload, ",")
+		}
+		if err := store.CreateIndexWithOptions(idx.field, idx.type_, opts); err != nil {
 			return fmt.Errorf("failed to create index %s: %v", idx.field, err)
 		}
 	}
@@ -271,54 +1170,234 @@ func createDefaultIndexes(store *storage.Store) error {
 	return nil
 }
 
+// IndexRequest is the body of POST /index/create and DELETE /index/remove
+type IndexRequest struct {
+	Field      string   `json:"field" binding:"required"`
+	Type       string   `json:"type" binding:"required"` // btree, vector, text, ip, keyword or numeric
+	Coerce     string   `json:"coerce,omitempty"`        // Optional value type: string, int, float or bool
+	Tokenizer  string   `json:"tokenizer,omitempty"`     // Optional tokenizer of text indexes, such as word or ngram:4
+	IgnoreCase bool     `json:"ignore_case,omitempty"`   // Match keyword index values regardless of case
+	Dims       int      `json:"dims,omitempty"`          // Dimensions of vector indexes, by default those of the first vector indexed
+	Payload    []string `json:"payload,omitempty"`       // Fields kept with the vectors of vector indexes to filter vector searches on
+	MaxEntries int      `json:"max_entries,omitempty"`   // Optional limit of documents in the indexes on the field
+	MaxMemory  int64    `json:"max_memory,omitempty"`    // Optional limit of the estimated memory of the indexes on the field
+	Wait       bool     `json:"wait,omitempty"`          // Respond once the existing entries are indexed instead of when the build starts
+}
+
 func handleCreateIndex(store *storage.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var request struct {
-			Field string `json:"field" binding:"required"`
-			Type  string `json:"type" binding:"required"`
+		store := tenantStore(c, store)
+		var request IndexRequest
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		if request.Coerce != "" {
+			if err := store.SetCoercion(request.Field, request.Coerce); err != nil {
+				respondBadRequest(c, err)
+				return
+			}
+		}
+
+		if request.MaxEntries != 0 || request.MaxMemory != 0 {
+			limit := storage.IndexLimit{MaxEntries: request.MaxEntries, MaxMemory: request.MaxMemory}
+			if err := store.SetIndexLimit(request.Field, limit); err != nil {
+				respondBadRequest(c, err)
+				return
+			}
 		}
 
+		opts := storage.IndexOptions{Tokenizer: request.Tokenizer, IgnoreCase: request.IgnoreCase, Dims: request.Dims, Payload: request.Payload}
+		job, err := store.BuildIndex(request.Field, request.Type, opts)
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		if job == nil {
+			c.JSON(200, gin.H{"status": "ok"}) // The index exists
+			return
+		}
+		if request.Wait {
+			// The build goes on if the client gives up waiting
+			if finished, err := store.WaitJob(c.Request.Context(), job.ID); err == nil {
+				c.JSON(200, gin.H{"status": finished.State, "job": job.ID})
+				return
+			}
+		}
+		respondJobStarted(c, *job)
+	}
+}
+
+// handleEstimateIndex predicts the memory, build time and coverage of an
+// index from a sample of the documents, without creating it
+func handleEstimateIndex(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var request storage.IndexEstimateRequest
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			respondBadRequest(c, err)
 			return
 		}
 
-		if err := store.CreateIndex(request.Field, request.Type); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+		estimate, err := store.EstimateIndex(request)
+		if err != nil {
+			respondStoreError(c, err)
 			return
 		}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, estimate)
+		} else {
+			c.JSON(200, estimate)
+		}
+	}
+}
 
-		c.JSON(200, gin.H{"status": "ok"})
+// handleOptimizeIndex partitions a vector index around centroids trained
+// on its vectors and reports the recall and latency of searches of a
+// held-out sample. With async=true it runs as a job whose result is the
+// report.
+func handleOptimizeIndex(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var request storage.VectorOptimizeRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&request); err != nil {
+				respondBadRequest(c, err)
+				return
+			}
+		}
+		field := c.Param("field")
+
+		if runAsync(c) {
+			params := map[string]string{"field": field}
+			job := store.StartJob(storage.JobVectorOptimize, params, func(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+				report, err := store.OptimizeVectorIndex(ctx, field, request, progress)
+				if err != nil {
+					return nil, err
+				}
+				return report, nil
+			})
+			respondJobStarted(c, job)
+			return
+		}
+		report, err := store.OptimizeVectorIndex(c.Request.Context(), field, request, nil)
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, report)
+		} else {
+			c.JSON(200, report)
+		}
 	}
 }
 
 func handleRemoveIndex(store *storage.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var request struct {
-			Field string `json:"field" binding:"required"`
-			Type  string `json:"type" binding:"required"`
-		}
+		store := tenantStore(c, store)
+		var request IndexRequest
 
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			respondBadRequest(c, err)
 			return
 		}
 
 		// We'll need to add RemoveIndex to the Store type
 		if err := store.RemoveIndex(request.Field, request.Type); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			respondStoreError(c, err)
 			return
 		}
+		store.RemoveCoercion(request.Field)
 
 		c.JSON(200, gin.H{"status": "ok"})
 	}
 }
 
+func handleCoercions(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		c.JSON(200, store.Coercions())
+	}
+}
+
+func handleIndexLimits(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		c.JSON(200, store.IndexLimits())
+	}
+}
+
+func handleTokenizers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, storage.Tokenizers())
+	}
+}
+
+// decodeLimits returns the configured limits for request documents
+func decodeLimits() storage.DecodeLimits {
+	return storage.DecodeLimits{
+		MaxSize:           *MaxDocSize,
+		MaxDepth:          *MaxYAMLDepth,
+		MaxAliasExpansion: *MaxYAMLAlias,
+		Timeout:           *MaxYAMLTime,
+		MaxParses:         *MaxParses,
+	}
+}
+
+// bodyLimitMiddleware caps request bodies at -max-doc-size, so no handler
+// reads an unbounded body, such as a search query bound from JSON. Blob
+// uploads and /scan have limits of their own.
+func bodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if limit > 0 && c.Request.Body != nil && !strings.HasSuffix(path, blobSuffix) && path != "/scan" {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Next()
+	}
+}
+
+// strictDecodeRequested reports whether unknown fields in the request body
+// should be rejected. X-Decode-Mode: strict|lenient overrides -strict-decode.
+func strictDecodeRequested(c *gin.Context) bool {
+	switch c.GetHeader("X-Decode-Mode") {
+	case "strict":
+		return true
+	case "lenient":
+		return false
+	}
+	return *StrictDecode
+}
+
 func parseRequestBody(c *gin.Context, value interface{}) error {
+	limits := decodeLimits()
+	strict := strictDecodeRequested(c)
+
 	switch c.GetHeader("Content-Type") {
 	case "application/x-yaml":
-		return c.BindYAML(value)
+		raw, err := limits.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		if strict {
+			return limits.DecodeStrict(raw, value)
+		}
+		return limits.Decode(raw, value)
 	case "application/json", "":
+		if limits.MaxSize > 0 {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxSize)
+		}
+		if strict {
+			decoder := json.NewDecoder(c.Request.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(value); err != nil {
+				return err
+			}
+			return binding.Validator.ValidateStruct(value)
+		}
 		return c.BindJSON(value)
 	default:
 		return fmt.Errorf("unsupported content type")
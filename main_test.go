@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// bodyContext returns a gin context for a POST of body with contentType
+func bodyContext(body []byte, contentType string, headers ...string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/data/key", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", contentType)
+	for i := 0; i+1 < len(headers); i += 2 {
+		c.Request.Header.Set(headers[i], headers[i+1])
+	}
+	return c
+}
+
+// FuzzParseRequestBody parses arbitrary JSON and YAML bodies, leniently and
+// strictly, into a document and a search query
+func FuzzParseRequestBody(f *testing.F) {
+	f.Add([]byte(`{"value": {"name": "x"}, "ttl": 60}`), false, false)
+	f.Add([]byte(`{"text": "phishing", "vector": [0.1, 0.2], "max_results": 5}`), false, true)
+	f.Add([]byte("value:\n  name: &a x\n  alias: *a\n"), true, false)
+	f.Add([]byte("text: phishing\nunknown: field\n"), true, true)
+
+	f.Fuzz(func(t *testing.T, body []byte, isYAML bool, strict bool) {
+		contentType, mode := "application/json", "lenient"
+		if isYAML {
+			contentType = "application/x-yaml"
+		}
+		if strict {
+			mode = "strict"
+		}
+
+		var value interface{}
+		_ = parseRequestBody(bodyContext(body, contentType, "X-Decode-Mode", mode), &value)
+		var query storage.SearchQuery
+		_ = parseRequestBody(bodyContext(body, contentType, "X-Decode-Mode", mode), &query)
+	})
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/mcp"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// defaultMCPResults is the number of search results returned to agents that
+// do not ask for a number, small enough for a model's context
+const defaultMCPResults = 10
+
+// mcpObject returns the JSON Schema of a tool's arguments object
+func mcpObject(required []string, properties map[string]interface{}) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// mcpProperty returns the JSON Schema of an argument
+func mcpProperty(typ, description string) map[string]interface{} {
+	return map[string]interface{}{"type": typ, "description": description}
+}
+
+// mcpArray returns the JSON Schema of an array argument
+func mcpArray(items, description string) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": items}, "description": description}
+}
+
+// mcpError describes an error to an agent with its API error code
+func mcpError(err error) error {
+	return fmt.Errorf("%s: %v", errorCode(err), err)
+}
+
+// projectPaths returns the values at dotted paths of a document, by path,
+// or the document itself without paths
+func projectPaths(value interface{}, paths []string) interface{} {
+	if len(paths) == 0 {
+		return value
+	}
+	projected := make(map[string]interface{}, len(paths))
+	for _, path := range paths {
+		projected[path] = lookupPath(value, path)
+	}
+	return projected
+}
+
+// MCPDocument is a document returned to agents
+type MCPDocument struct {
+	Key         string            `json:"key"`
+	Value       interface{}       `json:"value"`
+	UpdatedAt   string            `json:"updated_at,omitempty"`
+	ExpiresAt   string            `json:"expires_at,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Parent      string            `json:"parent,omitempty"`
+}
+
+// MCPSearchResult is a search match returned to agents
+type MCPSearchResult struct {
+	Key    string                 `json:"key"`
+	Score  float64                `json:"score"`
+	Value  interface{}            `json:"value,omitempty"`
+	Fields map[string]interface{} `json:"fields,omitempty"` // Computed fields
+}
+
+var mcpSearchTool = &mcp.Tool{
+	Name:  "search_documents",
+	Title: "Search documents",
+	Description: "Search the stored documents by full-text query, embedding vector, exact field filters or a filter expression, " +
+		"returning the best matches with their scores. Combine query and vector for a hybrid search.",
+	InputSchema: mcpObject(nil, map[string]interface{}{
+		"query":        mcpProperty("string", "Full-text query"),
+		"text_fields":  mcpArray("string", "Restrict the full-text query to these indexed fields"),
+		"vector":       mcpArray("number", "Embedding to find similar documents to"),
+		"vector_field": mcpProperty("string", "Field of the embeddings to compare the vector with, when documents hold several"),
+		"filters":      mcpProperty("object", "Field to required value, or list of allowed values, on keyword and numeric indexes"),
+		"expr":         mcpProperty("string", "Filter expression over the document, e.g. value.score > 7 && value.status == \"open\""),
+		"max_results":  mcpProperty("integer", fmt.Sprintf("Number of matches to return (default %d)", defaultMCPResults)),
+		"min_score":    mcpProperty("number", "Minimum score of a match"),
+		"paths":        mcpArray("string", "Return only the values at these dotted paths of each document, e.g. title or meta.author"),
+	}),
+	ReadOnly: true,
+	Handler: func(call mcp.Call) (*mcp.Result, error) {
+		var args struct {
+			Query       string                 `json:"query"`
+			TextFields  []string               `json:"text_fields"`
+			Vector      []float32              `json:"vector"`
+			VectorField string                 `json:"vector_field"`
+			Filters     map[string]interface{} `json:"filters"`
+			Expr        string                 `json:"expr"`
+			MaxResults  int                    `json:"max_results"`
+			MinScore    float64                `json:"min_score"`
+			Paths       []string               `json:"paths"`
+		}
+		if err := call.Bind(&args); err != nil {
+			return nil, err
+		}
+		if args.MaxResults <= 0 {
+			args.MaxResults = defaultMCPResults
+		}
+
+		results, err := call.Source.(*requestScope).search(storage.SearchQuery{
+			Text:        args.Query,
+			TextFields:  args.TextFields,
+			Vector:      args.Vector,
+			VectorField: args.VectorField,
+			Filters:     args.Filters,
+			Expr:        args.Expr,
+			MaxResults:  args.MaxResults,
+			MinScore:    args.MinScore,
+		})
+		if err != nil {
+			return nil, mcpError(err)
+		}
+		matches := make([]MCPSearchResult, len(results))
+		for i, result := range results {
+			matches[i] = MCPSearchResult{Key: result.Key, Score: result.Combined, Value: projectPaths(result.Value, args.Paths), Fields: result.Fields}
+		}
+		return mcp.JSONResult(map[string]interface{}{"results": matches})
+	},
+}
+
+var mcpGetTool = &mcp.Tool{
+	Name:        "get_document",
+	Title:       "Get a document",
+	Description: "Read the document stored under a key, with when it was written and when it expires",
+	InputSchema: mcpObject([]string{"key"}, map[string]interface{}{
+		"key":   mcpProperty("string", "Key of the document"),
+		"paths": mcpArray("string", "Return only the values at these dotted paths of the document"),
+	}),
+	ReadOnly: true,
+	Handler: func(call mcp.Call) (*mcp.Result, error) {
+		var args struct {
+			Key   string   `json:"key"`
+			Paths []string `json:"paths"`
+		}
+		if err := call.Bind(&args); err != nil {
+			return nil, err
+		}
+		entry, err := call.Source.(*requestScope).get(args.Key)
+		if err != nil {
+			return nil, mcpError(err)
+		}
+		if entry == nil {
+			return nil, mcpError(&codedError{CodeNotFound, fmt.Sprintf("no document with key %q", args.Key)})
+		}
+
+		doc := MCPDocument{Key: args.Key, Value: projectPaths(entry.Value, args.Paths)}
+		if entry.Timestamp != 0 {
+			doc.UpdatedAt = formatUnix(entry.Timestamp)
+		}
+		if entry.TTL > 0 {
+			doc.ExpiresAt = formatUnix(entry.Timestamp + entry.TTL)
+		}
+		if entry.Metadata != nil {
+			doc.ContentType = entry.Metadata.ContentType
+			doc.Labels = entry.Metadata.Labels
+			doc.Parent = entry.Metadata.Parent
+		}
+		return mcp.JSONResult(doc)
+	},
+}
+
+var mcpUpsertTool = &mcp.Tool{
+	Name:        "upsert_document",
+	Title:       "Store a document",
+	Description: "Store a document under a key, replacing any document already stored there. It is indexed for search at once.",
+	InputSchema: mcpObject([]string{"key", "value"}, map[string]interface{}{
+		"key":          mcpProperty("string", "Key to store the document under; slashes group keys like directories"),
+		"value":        map[string]interface{}{"description": "The document, usually an object"},
+		"ttl":          mcpProperty("string", "Delete the document after this duration, e.g. 24h"),
+		"labels":       mcpProperty("object", "Label names to string values"),
+		"content_type": mcpProperty("string", "Content type recorded with the document"),
+		"pipeline":     mcpProperty("string", "Ingest pipeline to transform the document with before it is stored"),
+	}),
+	Handler: func(call mcp.Call) (*mcp.Result, error) {
+		var args struct {
+			Key         string      `json:"key"`
+			Value       interface{} `json:"value"`
+			TTL         string      `json:"ttl"`
+			Labels      interface{} `json:"labels"`
+			ContentType string      `json:"content_type"`
+			Pipeline    string      `json:"pipeline"`
+		}
+		if err := call.Bind(&args); err != nil {
+			return nil, err
+		}
+		if args.Value == nil {
+			return nil, mcpError(&codedError{CodeInvalidRequest, "value is required"})
+		}
+		w := documentWrite{Key: args.Key, Value: args.Value, TTL: args.TTL, ContentType: args.ContentType, Pipeline: args.Pipeline}
+		if args.Labels != nil {
+			var err error
+			if w.Labels, err = labelsArgument(args.Labels); err != nil {
+				return nil, mcpError(err)
+			}
+		}
+
+		stored, err := call.Source.(*requestScope).set(w)
+		if err != nil {
+			return nil, mcpError(err)
+		}
+		status := "stored"
+		if !stored {
+			status = "dropped by the pipeline"
+		}
+		return mcp.JSONResult(map[string]interface{}{"key": args.Key, "status": status})
+	},
+}
+
+var mcpDeleteTool = &mcp.Tool{
+	Name:        "delete_document",
+	Title:       "Delete a document",
+	Description: "Delete the document stored under a key",
+	InputSchema: mcpObject([]string{"key"}, map[string]interface{}{
+		"key": mcpProperty("string", "Key of the document"),
+	}),
+	Handler: func(call mcp.Call) (*mcp.Result, error) {
+		var args struct {
+			Key string `json:"key"`
+		}
+		if err := call.Bind(&args); err != nil {
+			return nil, err
+		}
+		existed, err := call.Source.(*requestScope).delete(args.Key)
+		if err != nil {
+			return nil, mcpError(err)
+		}
+		return mcp.JSONResult(map[string]interface{}{"key": args.Key, "deleted": existed})
+	},
+}
+
+var mcpListTool = &mcp.Tool{
+	Name:        "list_keys",
+	Title:       "List keys",
+	Description: "List the keys of stored documents in sorted order, a page at a time",
+	InputSchema: mcpObject(nil, map[string]interface{}{
+		"prefix": mcpProperty("string", "Only keys starting with this prefix, e.g. reports/"),
+		"after":  mcpProperty("string", "Start after this key; pass the next of the previous page"),
+		"limit":  mcpProperty("integer", fmt.Sprintf("Keys per page (default %d)", defaultKeyListLimit)),
+	}),
+	ReadOnly: true,
+	Handler: func(call mcp.Call) (*mcp.Result, error) {
+		var args struct {
+			Prefix string `json:"prefix"`
+			After  string `json:"after"`
+			Limit  int    `json:"limit"`
+		}
+		if err := call.Bind(&args); err != nil {
+			return nil, err
+		}
+		if args.Limit == 0 {
+			args.Limit = defaultKeyListLimit
+		}
+		keys, next, err := call.Source.(*requestScope).keys(args.Prefix, args.After, args.Limit)
+		if err != nil {
+			return nil, mcpError(err)
+		}
+		return mcp.JSONResult(KeyList{Keys: keys, Next: next})
+	},
+}
+
+// mcpServer is the MCP server answering at /mcp
+var mcpServer = func() *mcp.Server {
+	s := mcp.NewServer("searchyaml", "1.0", mcpSearchTool, mcpGetTool, mcpUpsertTool, mcpDeleteTool, mcpListTool)
+	s.Instructions = "SearchYAML stores YAML and JSON documents under keys and indexes them for full-text, vector and field search. " +
+		"Use search_documents to find documents relevant to a question, get_document to read one, " +
+		"and upsert_document to remember new information."
+	return s
+}()
+
+// handleMCP answers MCP messages over the Streamable HTTP transport, with
+// the tenant and access rules of the request. The tools read documents
+// through requestScope, which redacts them like the REST API. Responses are
+// plain JSON; the server sends no messages of its own, so there is no event
+// stream to open with GET.
+func handleMCP(store *storage.Store, pipelines *Pipelines) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Reject pages of other sites, which could otherwise reach a
+		// server on localhost through the browser
+		if origin := c.GetHeader("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != c.Request.Host {
+				respondError(c, 403, CodeForbidden, "cross-origin MCP requests are not allowed")
+				return
+			}
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		response := mcpServer.Handle(c.Request.Context(), newRequestScope(c, store, pipelines), body)
+		if response == nil {
+			c.Status(202)
+			return
+		}
+		c.Data(200, "application/json", response)
+	}
+}
+
+// runMCP bridges an MCP client speaking the stdio transport, such as a
+// desktop agent that starts its servers as commands, to the /mcp endpoint
+// of a running server
+func runMCP(args []string) error {
+	fs := flag.NewFlagSet("mcp", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s mcp [flags]\n\nServe MCP over stdin and stdout, forwarding messages to a running server.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	serverURL := fs.String("url", "http://localhost:8080", "URL of the server")
+	apiKey := fs.String("api-key", os.Getenv("SEARCHYAML_API_KEY"), "API key sent to the server (default: $SEARCHYAML_API_KEY)")
+	timeout := fs.Duration("timeout", time.Minute, "Timeout of each request to the server")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(*serverURL, "/") + "/mcp"
+	client := &http.Client{Timeout: *timeout}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64<<10), int(storage.DefaultDecodeLimits.MaxSize))
+	for scanner.Scan() {
+		message := bytes.TrimSpace(scanner.Bytes())
+		if len(message) == 0 {
+			continue
+		}
+		response, err := forwardMCP(client, endpoint, *apiKey, message)
+		if err != nil {
+			// Logged to stderr, which MCP clients show as the server's log
+			log.Printf("mcp: %v", err)
+			response = mcpBridgeError(message, err)
+		}
+		if response != nil {
+			if _, err := os.Stdout.Write(append(response, '\n')); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// forwardMCP posts a message to the server, returning its response, or nil
+// when there is none
+func forwardMCP(client *http.Client, endpoint, apiKey string, message []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return nil, nil
+	case http.StatusOK:
+		return bytes.TrimSpace(body), nil
+	}
+	var apiErr ErrorResponse
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+		return nil, fmt.Errorf("server responded with %s: %s", resp.Status, apiErr.Message)
+	}
+	return nil, fmt.Errorf("server responded with %s", resp.Status)
+}
+
+// mcpBridgeError returns the JSON-RPC error response to a request that could
+// not be forwarded, or nil for notifications, which get no response
+func mcpBridgeError(message []byte, err error) []byte {
+	var request struct {
+		ID *json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(message, &request) != nil || request.ID == nil {
+		return nil
+	}
+	response, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      request.ID,
+		"error":   map[string]interface{}{"code": mcp.CodeInternalError, "message": err.Error()},
+	})
+	return response
+}
@@ -0,0 +1,279 @@
+// Package mcp implements the server side of the Model Context Protocol, so
+// LLM agents can call tools a server defines in Go. Messages are JSON-RPC
+// 2.0; Handle answers the body of a Streamable HTTP POST or a line of a stdio
+// session alike. Only tools are offered; resources, prompts and sampling are
+// not supported.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ProtocolVersion is the latest protocol revision the server speaks
+const ProtocolVersion = "2025-06-18"
+
+// supportedVersions are the revisions the server accepts from clients
+var supportedVersions = map[string]bool{
+	"2025-06-18": true,
+	"2025-03-26": true,
+	"2024-11-05": true,
+}
+
+// JSON-RPC error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Tool is a tool agents can call
+type Tool struct {
+	Name        string
+	Title       string
+	Description string
+	InputSchema map[string]interface{} // JSON Schema of the arguments object
+	ReadOnly    bool                   // Hint that the tool does not modify anything
+
+	// Handler runs the tool. An error is reported to the agent as a failed
+	// call it can read and react to, not as a protocol error.
+	Handler func(call Call) (*Result, error)
+}
+
+// Call is a call of a tool
+type Call struct {
+	Context   context.Context
+	Source    interface{}     // Passed to Handle, e.g. the HTTP request
+	Arguments json.RawMessage // The arguments object, or nil
+}
+
+// Bind decodes the arguments into v
+func (c Call) Bind(v interface{}) error {
+	if len(c.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(c.Arguments, v); err != nil {
+		return fmt.Errorf("invalid arguments: %v", err)
+	}
+	return nil
+}
+
+// Content is an item of a tool result. Only text content is produced.
+type Content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Result is the result of a tool call
+type Result struct {
+	Content           []Content   `json:"content"`
+	StructuredContent interface{} `json:"structuredContent,omitempty"`
+	IsError           bool        `json:"isError,omitempty"`
+}
+
+// TextResult returns a result of text
+func TextResult(text string) *Result {
+	return &Result{Content: []Content{{Type: "text", Text: text}}}
+}
+
+// JSONResult returns a result carrying v as structured content, and as its
+// JSON encoding for clients that only read text
+func JSONResult(v interface{}) (*Result, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	result := TextResult(string(raw))
+	result.StructuredContent = v
+	return result, nil
+}
+
+// Server answers MCP messages with its tools
+type Server struct {
+	Name         string
+	Version      string
+	Instructions string // Told to agents on initialization, if set
+
+	tools  []*Tool
+	byName map[string]*Tool
+}
+
+// NewServer returns a server offering tools
+func NewServer(name, version string, tools ...*Tool) *Server {
+	s := &Server{Name: name, Version: version, tools: tools, byName: make(map[string]*Tool)}
+	for _, t := range tools {
+		s.byName[t.Name] = t
+	}
+	return s
+}
+
+type request struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"` // Nil for notifications
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// Handle answers a message, or a batch of them, returning the encoded
+// response. It returns nil when there is nothing to answer, as for
+// notifications and responses from the client.
+func (s *Server) Handle(ctx context.Context, source interface{}, message []byte) []byte {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(message, &batch); err == nil {
+		if len(batch) == 0 {
+			return encode(errorResponse(nil, CodeInvalidRequest, "empty batch"))
+		}
+		var responses []*response
+		for _, m := range batch {
+			if resp := s.handle(ctx, source, m); resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		return encode(responses)
+	}
+	if resp := s.handle(ctx, source, message); resp != nil {
+		return encode(resp)
+	}
+	return nil
+}
+
+func encode(v interface{}) []byte {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		raw, _ = json.Marshal(errorResponse(nil, CodeInternalError, err.Error()))
+	}
+	return raw
+}
+
+func errorResponse(id json.RawMessage, code int, message string) *response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}}
+}
+
+func (s *Server) handle(ctx context.Context, source interface{}, message json.RawMessage) *response {
+	var req request
+	if err := json.Unmarshal(message, &req); err != nil {
+		return errorResponse(nil, CodeParseError, fmt.Sprintf("parse error: %v", err))
+	}
+	if req.Method == "" {
+		// A response to a server request, which this server never sends
+		return nil
+	}
+	if req.JSONRPC != "2.0" {
+		return errorResponse(idOf(req), CodeInvalidRequest, `jsonrpc must be "2.0"`)
+	}
+
+	result, err := s.call(ctx, source, req.Method, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		if rpcErr, ok := err.(*rpcError); ok {
+			return errorResponse(*req.ID, rpcErr.Code, rpcErr.Message)
+		}
+		return errorResponse(*req.ID, CodeInternalError, err.Error())
+	}
+	return &response{JSONRPC: "2.0", ID: *req.ID, Result: result}
+}
+
+func idOf(req request) json.RawMessage {
+	if req.ID == nil {
+		return nil
+	}
+	return *req.ID
+}
+
+func (s *Server) call(ctx context.Context, source interface{}, method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, &rpcError{CodeInvalidParams, err.Error()}
+			}
+		}
+		version := ProtocolVersion
+		if supportedVersions[p.ProtocolVersion] {
+			version = p.ProtocolVersion
+		}
+		result := map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{"listChanged": false}},
+			"serverInfo":      map[string]interface{}{"name": s.Name, "version": s.Version},
+		}
+		if s.Instructions != "" {
+			result["instructions"] = s.Instructions
+		}
+		return result, nil
+
+	case "ping":
+		return struct{}{}, nil
+
+	case "tools/list":
+		tools := make([]map[string]interface{}, len(s.tools))
+		for i, t := range s.tools {
+			tool := map[string]interface{}{
+				"name":        t.Name,
+				"description": t.Description,
+				"inputSchema": t.InputSchema,
+				"annotations": map[string]interface{}{"readOnlyHint": t.ReadOnly},
+			}
+			if t.Title != "" {
+				tool["title"] = t.Title
+			}
+			tools[i] = tool
+		}
+		return map[string]interface{}{"tools": tools}, nil
+
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{CodeInvalidParams, err.Error()}
+		}
+		t, exists := s.byName[p.Name]
+		if !exists {
+			return nil, &rpcError{CodeInvalidParams, fmt.Sprintf("unknown tool: %s", p.Name)}
+		}
+		result, err := t.Handler(Call{Context: ctx, Source: source, Arguments: p.Arguments})
+		if err != nil {
+			result = TextResult(err.Error())
+			result.IsError = true
+		}
+		return result, nil
+	}
+
+	if strings.HasPrefix(method, "notifications/") {
+		return nil, nil
+	}
+	return nil, &rpcError{CodeMethodNotFound, fmt.Sprintf("method not found: %s", method)}
+}
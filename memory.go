@@ -0,0 +1,86 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// RuntimeMemory is the subset of runtime.MemStats useful to operators
+type RuntimeMemory struct {
+	HeapAlloc    uint64    `json:"heap_alloc" yaml:"heap_alloc"`       // Bytes of live and not yet collected heap objects
+	HeapInuse    uint64    `json:"heap_inuse" yaml:"heap_inuse"`       // Bytes in in-use heap spans
+	HeapIdle     uint64    `json:"heap_idle" yaml:"heap_idle"`         // Bytes in idle heap spans
+	HeapReleased uint64    `json:"heap_released" yaml:"heap_released"` // Idle bytes returned to the OS
+	HeapObjects  uint64    `json:"heap_objects" yaml:"heap_objects"`
+	StackInuse   uint64    `json:"stack_inuse" yaml:"stack_inuse"`
+	Sys          uint64    `json:"sys" yaml:"sys"` // Total bytes obtained from the OS
+	NumGC        uint32    `json:"num_gc" yaml:"num_gc"`
+	PauseTotal   float64   `json:"pause_total" yaml:"pause_total"` // in milliseconds
+	LastGC       time.Time `json:"last_gc" yaml:"last_gc"`
+	Goroutines   int       `json:"goroutines" yaml:"goroutines"`
+}
+
+func readRuntimeMemory() RuntimeMemory {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return RuntimeMemory{
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapIdle:     m.HeapIdle,
+		HeapReleased: m.HeapReleased,
+		HeapObjects:  m.HeapObjects,
+		StackInuse:   m.StackInuse,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+		PauseTotal:   float64(m.PauseTotalNs) / 1e6,
+		LastGC:       time.Unix(0, int64(m.LastGC)),
+		Goroutines:   runtime.NumGoroutine(),
+	}
+}
+
+// handleMemory reports runtime memory statistics and estimates of the
+// memory held by the store's data, indexes and mapping
+func handleMemory(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		report := gin.H{
+			"runtime": readRuntimeMemory(),
+			"store":   store.MemoryUsage(),
+		}
+
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, report)
+		} else {
+			c.JSON(200, report)
+		}
+	}
+}
+
+// handleGC sweeps the expired entries of the tenant, then forces a garbage
+// collection and returns freed memory to the OS
+func handleGC(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		sweep := store.GC()
+
+		before := readRuntimeMemory()
+		start := time.Now()
+		debug.FreeOSMemory()
+		duration := time.Since(start)
+		after := readRuntimeMemory()
+
+		c.JSON(200, gin.H{
+			"status":      "ok",
+			"duration":    float64(duration.Microseconds()) / 1000,
+			"heap_before": before.HeapAlloc,
+			"heap_after":  after.HeapAlloc,
+			"released":    int64(after.HeapReleased) - int64(before.HeapReleased),
+			"expired":     sweep,
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// Headers setting and returning entry metadata
+const (
+	contentTypeHeader = "X-Content-Type"
+	labelsHeader      = "X-Labels"
+	createdByHeader   = "X-Created-By"
+	parentHeader      = "X-Parent"
+)
+
+// maxLabels is the number of labels an entry may carry
+const maxLabels = 64
+
+// apiKeyFingerprint identifies an API key in entry metadata without storing
+// the key itself
+func apiKeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// requestMetadata returns the metadata of an entry written by the request:
+// the fingerprint of its API key, X-Content-Type, the name=value pairs of
+// X-Labels and the parent key of X-Parent
+func requestMetadata(c *gin.Context) (*storage.Metadata, error) {
+	meta := &storage.Metadata{
+		ContentType: strings.TrimSpace(c.GetHeader(contentTypeHeader)),
+		Parent:      c.GetHeader(parentHeader),
+	}
+	if key := apiKeyFrom(c); key != "" {
+		meta.CreatedBy = apiKeyFingerprint(key)
+	}
+
+	labels, err := parseLabels(c.GetHeader(labelsHeader))
+	if err != nil {
+		return nil, err
+	}
+	meta.Labels = labels
+	return meta, nil
+}
+
+// parseLabels parses comma-separated name=value pairs. Names must be
+// non-empty and may not repeat; values may be empty.
+func parseLabels(header string) (map[string]string, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label %q: use name=value", strings.TrimSpace(pair))
+		}
+		if _, exists := labels[name]; exists {
+			return nil, fmt.Errorf("duplicate label %q", name)
+		}
+		labels[name] = value
+	}
+	if len(labels) > maxLabels {
+		return nil, fmt.Errorf("too many labels: %d, at most %d", len(labels), maxLabels)
+	}
+	return labels, nil
+}
+
+// formatLabels formats labels as parsed by parseLabels, sorted by name
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// setMetadataHeaders returns entry metadata in the headers that set it, so
+// raw reads carry it too
+func setMetadataHeaders(c *gin.Context, meta *storage.Metadata) {
+	if meta == nil {
+		return
+	}
+	if meta.ContentType != "" {
+		c.Header(contentTypeHeader, meta.ContentType)
+	}
+	if len(meta.Labels) > 0 {
+		c.Header(labelsHeader, formatLabels(meta.Labels))
+	}
+	if meta.CreatedBy != "" {
+		c.Header(createdByHeader, meta.CreatedBy)
+	}
+	if meta.Parent != "" {
+		c.Header(parentHeader, meta.Parent)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// metricsContentType is the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsWriter writes metrics in the Prometheus text format, each family
+// once with its help and type
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (w *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(&w.b, "# HELP searchyaml_%s %s\n# TYPE searchyaml_%s %s\n", name, help, name, kind)
+}
+
+func (w *metricsWriter) sample(name, labels string, value float64) {
+	fmt.Fprintf(&w.b, "searchyaml_%s{%s} %g\n", name, labels, value)
+}
+
+// handleMetrics exposes store statistics and latency percentiles in the
+// Prometheus text format. Requests of the default tenant get every tenant,
+// others their own.
+func handleMetrics(store *storage.Store, registry *TenantRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tenants []TenantStats
+		if name := tenantName(c); name == DefaultTenant {
+			tenants = registry.Stats()
+		} else {
+			tenants = []TenantStats{{Name: name, Store: tenantStore(c, store).GetStats()}}
+		}
+		sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+
+		var w metricsWriter
+		counters := []struct {
+			name, help string
+			value      func(s storage.StoreStats) uint64
+		}{
+			{"reads_total", "Reads of keys", func(s storage.StoreStats) uint64 { return s.Reads }},
+			{"writes_total", "Writes of keys", func(s storage.StoreStats) uint64 { return s.Writes }},
+			{"deletes_total", "Deletes of keys", func(s storage.StoreStats) uint64 { return s.Deletes }},
+			{"evictions_total", "Entries evicted to make room under a limit", func(s storage.StoreStats) uint64 { return s.Evictions }},
+			{"touches_total", "Reads that extended an expiry", func(s storage.StoreStats) uint64 { return s.Touches }},
+			{"syncs_total", "Writes of the data file", func(s storage.StoreStats) uint64 { return s.SyncCount }},
+			{"shadow_syncs_total", "Mirrors of the data file to the shadow path", func(s storage.StoreStats) uint64 { return s.ShadowSyncs }},
+			{"shadow_failures_total", "Failed mirrors of the data file", func(s storage.StoreStats) uint64 { return s.ShadowFailures }},
+			{"expired_total", "Expired entries removed by sweeps", func(s storage.StoreStats) uint64 { return s.ExpiredCount }},
+			{"lazy_expirations_total", "Expired entries removed after a read found them", func(s storage.StoreStats) uint64 { return s.LazyExpirations }},
+			{"index_errors_total", "Values indexes rejected", func(s storage.StoreStats) uint64 { return s.IndexErrors }},
+			{"conflicts_total", "Entries from peers that differed from local ones", func(s storage.StoreStats) uint64 { return s.Conflicts }},
+			{"query_cache_hits_total", "Searches answered from the result cache", func(s storage.StoreStats) uint64 { return s.QueryCache.Hits }},
+			{"query_cache_misses_total", "Cacheable searches run against the indexes", func(s storage.StoreStats) uint64 { return s.QueryCache.Misses }},
+			{"query_cache_invalidations_total", "Cached searches dropped by writes", func(s storage.StoreStats) uint64 { return s.QueryCache.Invalidations }},
+		}
+		for _, m := range counters {
+			w.family(m.name, "counter", m.help)
+			for _, t := range tenants {
+				w.sample(m.name, tenantLabel(t.Name), float64(m.value(t.Store)))
+			}
+		}
+
+		gauges := []struct {
+			name, help string
+			value      func(s storage.StoreStats) float64
+		}{
+			{"entries", "Entries in the store", func(s storage.StoreStats) float64 { return float64(s.EntryCount) }},
+			{"sliding_entries", "Entries whose expiry slides on read", func(s storage.StoreStats) float64 { return float64(s.SlidingEntries) }},
+			{"compressed_entries", "Entries held compressed in memory", func(s storage.StoreStats) float64 { return float64(s.CompressedEntries) }},
+			{"data_size_bytes", "Size of the data written by the last sync", func(s storage.StoreStats) float64 { return float64(s.DataSize) }},
+			{"file_size_bytes", "Size of the data file", func(s storage.StoreStats) float64 { return float64(s.FileSize) }},
+			{"query_cache_entries", "Searches whose results are cached", func(s storage.StoreStats) float64 { return float64(s.QueryCache.Size) }},
+		}
+		for _, m := range gauges {
+			w.family(m.name, "gauge", m.help)
+			for _, t := range tenants {
+				w.sample(m.name, tenantLabel(t.Name), m.value(t.Store))
+			}
+		}
+
+		operations := []struct {
+			op    string
+			stats func(s storage.StoreStats) storage.LatencyStats
+		}{
+			{"read", func(s storage.StoreStats) storage.LatencyStats { return s.PerformanceStats.Read }},
+			{"write", func(s storage.StoreStats) storage.LatencyStats { return s.PerformanceStats.Write }},
+			{"search", func(s storage.StoreStats) storage.LatencyStats { return s.PerformanceStats.Search }},
+			{"sync", func(s storage.StoreStats) storage.LatencyStats { return s.PerformanceStats.Sync }},
+		}
+		w.family("operation_duration_seconds", "summary", "Latency of store operations since the last reset")
+		for _, t := range tenants {
+			for _, o := range operations {
+				labels := tenantLabel(t.Name) + `,op="` + o.op + `"`
+				l := o.stats(t.Store)
+				for _, q := range []struct {
+					quantile string
+					millis   float64
+				}{{"0.5", l.P50}, {"0.9", l.P90}, {"0.99", l.P99}, {"0.999", l.P999}} {
+					w.sample("operation_duration_seconds", labels+`,quantile="`+q.quantile+`"`, q.millis/1000)
+				}
+				w.sample("operation_duration_seconds_sum", labels, l.Sum/1000)
+				w.sample("operation_duration_seconds_count", labels, float64(l.Count))
+			}
+		}
+		w.family("operation_duration_max_seconds", "gauge", "Longest store operation since the last reset")
+		for _, t := range tenants {
+			for _, o := range operations {
+				w.sample("operation_duration_max_seconds", tenantLabel(t.Name)+`,op="`+o.op+`"`, o.stats(t.Store).Max/1000)
+			}
+		}
+
+		w.family("tenant_ops_total", "counter", "Requests of the tenant")
+		for _, t := range tenants {
+			w.sample("tenant_ops_total", tenantLabel(t.Name), float64(t.Ops))
+		}
+		w.family("tenant_rejected_total", "counter", "Requests of the tenant rejected by its rate limit")
+		for _, t := range tenants {
+			w.sample("tenant_rejected_total", tenantLabel(t.Name), float64(t.Rejected))
+		}
+
+		c.Data(200, metricsContentType, []byte(w.b.String()))
+	}
+}
+
+func tenantLabel(name string) string {
+	return `tenant="` + name + `"`
+}
+
+// handleResetStats forgets the latencies recorded so far, so percentiles
+// describe the traffic from now on
+func handleResetStats(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		store.ResetLatencies()
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
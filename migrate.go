@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// MigrateOutput is the result of the migrate command with -output
+type MigrateOutput struct {
+	Data                    string `json:"data" yaml:"data"`
+	DryRun                  bool   `json:"dry_run" yaml:"dry_run"`
+	storage.MigrationResult `yaml:",inline"`
+}
+
+func (m MigrateOutput) table() ([]string, [][]string) {
+	return []string{"DATA", "FROM", "TO", "ENTRIES", "STEPS", "BACKUP"}, [][]string{{
+		m.Data, strconv.Itoa(m.From), strconv.Itoa(m.To), strconv.Itoa(m.Entries),
+		strconv.Itoa(len(m.Steps)), m.Backup,
+	}}
+}
+
+// runMigrate implements the migrate command, which upgrades a data file to
+// the current format version
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s migrate [flags]\n\nUpgrade a data file to the current format (%d). Stop the server using the file first.\n\n",
+			os.Args[0], storage.CurrentFormat)
+		fs.PrintDefaults()
+	}
+	dataFile := fs.String("data", "data.yaml", "Data file path")
+	target := fs.Int("to", storage.CurrentFormat, "Format version to migrate to")
+	dryRun := fs.Bool("dry-run", false, "Check the migration without writing anything")
+	noBackup := fs.Bool("no-backup", false, "Do not keep the original file as <data>.v<version>.bak")
+	output := outputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	result, err := storage.MigrateFile(*dataFile, *target, *dryRun, *noBackup)
+	if err != nil {
+		return err
+	}
+
+	return printResult(os.Stdout, *output, MigrateOutput{Data: *dataFile, DryRun: *dryRun, MigrationResult: *result}, func() {
+		if len(result.Steps) == 0 {
+			fmt.Printf("%s is at format %d; nothing to do\n", *dataFile, result.From)
+			return
+		}
+		for _, step := range result.Steps {
+			fmt.Printf("  %s\n", step)
+		}
+		switch {
+		case *dryRun:
+			fmt.Printf("%s can be migrated from format %d to %d (%d entries); nothing written\n", *dataFile, result.From, result.To, result.Entries)
+		case result.Backup != "":
+			fmt.Printf("Migrated %s from format %d to %d (%d entries); original kept as %s\n", *dataFile, result.From, result.To, result.Entries, result.Backup)
+		default:
+			fmt.Printf("Migrated %s from format %d to %d (%d entries)\n", *dataFile, result.From, result.To, result.Entries)
+		}
+	})
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/graphql"
+	"github.com/threatflux/searchyaml/pipeline"
+	"github.com/threatflux/searchyaml/stix"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// apiOperation documents a route for the OpenAPI document. Request and
+// Response are values of the body types; their schemas are generated from
+// the Go types.
+type apiOperation struct {
+	Summary  string
+	Request  interface{}
+	Response interface{}
+	Query    map[string]string // Query parameter -> description
+	Headers  map[string]string // Request header -> description
+	YAML     bool              // Accepts application/x-yaml bodies and Accept headers
+}
+
+// StatusResponse is the body of requests that succeed without a result
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// apiOperations documents the routes registered in main. Routes missing
+// here still appear in the document, without schemas.
+var apiOperations = map[string]apiOperation{
+	"GET /readyz": {Summary: "Readiness: 200 once indexes are built, 503 while they are building; degraded lists the alert rules firing", Response: gin.H{}},
+
+	"GET /data": {
+		Summary:  "List keys in sorted order, a page at a time",
+		Response: KeyList{},
+		Query: map[string]string{
+			"prefix": "Only keys starting with this prefix",
+			"after":  "Start after this key, the next value of the previous page",
+			"limit":  "Page size, default 100",
+		},
+	},
+	"GET /data/*key": {
+		Summary:  "Get an entry, or list a directory for paths ending in /",
+		Response: map[string]storage.Entry{},
+		Query: map[string]string{
+			"format":    "raw returns the stored YAML source of round-trip documents",
+			"recursive": "true lists every key below a directory instead of its direct children",
+			"after":     "Directory listings: start after this key or subdirectory",
+			"limit":     "Directory listings: page size, default 100",
+		},
+		Headers: map[string]string{"X-Touch": "Move the expiry of an expiring entry to this duration from now, e.g. 30m"},
+		YAML:    true,
+	},
+	"POST /data/*key": {
+		Summary:  "Store a document",
+		Request:  map[string]interface{}{},
+		Response: StatusResponse{},
+		Query: map[string]string{
+			"pipeline":      "Ingest pipeline to run before storing",
+			"chunk_field":   "Top-level string field to split into chunk entries key#0, key#1, ..., embedded with -embedder",
+			"chunk_size":    "Largest chunk in bytes (default 1000)",
+			"chunk_overlap": "Bytes repeated between consecutive chunks (default 200)",
+		},
+		Headers: map[string]string{
+			"X-TTL":          "Expire the entry after this duration, e.g. 24h",
+			"X-Sliding-TTL":  "Expire the entry after this duration without reads; every read restarts it",
+			"X-Round-Trip":   "true stores application/x-yaml bodies verbatim",
+			"X-Decode-Mode":  "strict or lenient",
+			"X-Content-Type": "MIME type stored in the entry metadata",
+			"X-Labels":       "Labels stored in the entry metadata, e.g. env=prod,team=intel",
+		},
+		YAML: true,
+	},
+	"DELETE /data/*key": {
+		Summary:  "Delete an entry, or every key below a directory path ending in /",
+		Response: StatusResponse{},
+		Query:    map[string]string{"recursive": "true is required to delete a directory"},
+	},
+	"POST /data/_delete_by_query": {
+		Summary:  "Delete every key matching a search query at once",
+		Request:  storage.SearchQuery{},
+		Response: DeleteByQueryResponse{},
+		Query: map[string]string{
+			"dry_run": "true counts the matching keys without deleting them",
+			"async":   "true runs the delete as a job and answers 202 with its ID",
+		},
+	},
+	"POST /data/_update_by_query": {
+		Summary:  "Apply a merge patch or ingest pipeline to every document matching a search query",
+		Request:  UpdateByQueryRequest{},
+		Response: storage.UpdateProgress{},
+		Query: map[string]string{
+			"progress": "true streams server-sent progress events, one per batch, ending with done",
+			"async":    "true runs the update as a job and answers 202 with its ID",
+		},
+	},
+	"POST /data/*key/_copy": {
+		Summary:  "Copy an entry to another key",
+		Request:  CopyRequest{},
+		Response: StatusResponse{},
+		Headers:  map[string]string{"X-Content-Type": "MIME type stored with reset_metadata", "X-Labels": "Labels stored with reset_metadata"},
+	},
+	"POST /data/*key/_rename": {
+		Summary:  "Move an entry to another key",
+		Request:  CopyRequest{},
+		Response: StatusResponse{},
+		Headers:  map[string]string{"X-Content-Type": "MIME type stored with reset_metadata", "X-Labels": "Labels stored with reset_metadata"},
+	},
+	"GET /data/*key/_blob":    {Summary: "Download the blob of a key, with its Content-Type, Content-Length and SHA-256 as ETag"},
+	"GET /data/*key/_hash":    {Summary: "SHA-256 of the value stored when it was written, and whether the value still matches it", Response: EntryHash{}},
+	"HEAD /data/*key/_blob":   {Summary: "Blob headers without the content"},
+	"POST /data/*key/_blob":   {Summary: "Upload the request body as the blob of a key, creating the key if needed", Response: BlobInfo{}},
+	"DELETE /data/*key/_blob": {Summary: "Remove the blob from a key; POST /admin/blobs/gc deletes the content", Response: StatusResponse{}},
+
+	"GET /data/*key/_links":    {Summary: "Links from a key and the links of readable keys to it", Response: LinksResponse{}, YAML: true, Query: map[string]string{"type": "Only links of this type"}},
+	"POST /data/*key/_links":   {Summary: "Add typed links from an existing key to others", Request: LinkRequest{}, Response: gin.H{}},
+	"DELETE /data/*key/_links": {Summary: "Remove the links of a key with a type, a target or both", Response: gin.H{}, Query: map[string]string{"type": "Type of the links to remove", "target": "Target of the links to remove"}},
+
+	"GET /data/*key/_tags":         {Summary: "The tags of a document", Response: TagsResponse{}, YAML: true},
+	"POST /data/*key/_tags":        {Summary: "Add tags to an existing document, ignoring those it has", Request: TagsRequest{}, Response: gin.H{}},
+	"DELETE /data/*key/_tags/:tag": {Summary: "Remove a tag from a document", Response: gin.H{}},
+	"GET /tags": {Summary: "Count the readable documents holding each tag, most used first", Response: TagCloudResponse{}, YAML: true, Query: map[string]string{
+		"field":  "Keyword indexed field to count, default tags",
+		"prefix": "Only tags starting with this prefix",
+		"limit":  "Return at most this many tags",
+	}},
+
+	"HEAD /data/*key": {
+		Summary: "Check an entry: ETag, Last-Modified, X-Entry-Size, X-TTL and metadata headers without the body",
+		Headers: map[string]string{"X-Touch": "Move the expiry of an expiring entry to this duration from now, e.g. 30m"},
+	},
+	"OPTIONS /data/*key": {Summary: "Allowed methods in the Allow header (204)"},
+	"OPTIONS /data":      {Summary: "Allowed methods in the Allow header (204)"},
+
+	"POST /search/text":             {Summary: "Full-text search", Request: TextSearchRequest{}, Response: []storage.SearchResult{}},
+	"POST /search/vector":           {Summary: "Vector similarity search", Request: VectorSearchRequest{}, Response: []storage.SearchResult{}},
+	"POST /search/combined":         {Summary: "Text, vector and filter search", Request: storage.SearchQuery{}, Response: []storage.SearchResult{}},
+	"POST /search/count":            {Summary: "Number of documents matching a query, without returning them", Request: storage.SearchQuery{}, Response: CountResponse{}},
+	"POST /search/exists":           {Summary: "Whether any document matches a query, stopping at the first match", Request: storage.SearchQuery{}, Response: ExistsResponse{}},
+	"POST /search/aggregate":        {Summary: "Count, sum, ranges, percentiles and histogram of a numeric field", Request: storage.AggregateRequest{}, Response: storage.AggregateResult{}},
+	"GET /search/attack/:technique": {Summary: "Documents mentioning an ATT&CK technique", Response: []storage.SearchResult{}, Query: map[string]string{"max_results": "Maximum number of results"}},
+	"GET /search/scorers": {Summary: "Combiners and scorers searches can name", Response: struct {
+		Combiners []string `json:"combiners"`
+		Scorers   []string `json:"scorers"`
+	}{}},
+
+	"GET /views": {Summary: "Views with their queries and number of keys", Response: struct {
+		Views []storage.ViewInfo `json:"views"`
+	}{}, YAML: true},
+	"GET /views/:name":    {Summary: "Entries of a view, sorted by key", Response: ViewResponse{}, YAML: true, Query: map[string]string{"keys_only": "true to return only the keys"}},
+	"PUT /views/:name":    {Summary: "Define or replace a view from filters and expr", Request: storage.SearchQuery{}, Response: storage.ViewInfo{}},
+	"DELETE /views/:name": {Summary: "Drop a view", Response: StatusResponse{}},
+
+	"POST /sql": {Summary: "Answer a read-only SELECT statement from the indexes", Request: SQLRequest{}, Response: SQLResponse{}, YAML: true, Query: map[string]string{
+		"explain": "true to return the plan: the index lookups and the conditions checked on each candidate",
+		"format":  "csv or parquet to export the rows",
+	}},
+
+	"GET /graphql": {Summary: "Run a GraphQL query; mutations need POST", Response: graphql.Response{}, Query: map[string]string{
+		"query":         "the GraphQL document",
+		"operationName": "the operation to run when the document has several",
+		"variables":     "the variables as a JSON object",
+	}},
+	"POST /graphql":       {Summary: "Run a GraphQL query or mutation over entries and searches", Request: graphql.Request{}, Response: graphql.Response{}},
+	"GET /graphql/schema": {Summary: "The GraphQL schema in the schema definition language"},
+
+	"POST /mcp": {Summary: "Answer Model Context Protocol messages, offering search_documents, get_document, upsert_document, delete_document and list_keys to LLM agents", Response: gin.H{}},
+
+	"POST /graph/traverse": {Summary: "Walk the links from some keys breadth first, returning the keys reached and the links followed", Request: storage.TraverseQuery{}, Response: storage.Graph{}, YAML: true},
+
+	"POST /v1/retrieve": {Summary: "Search for documents as RAG frameworks read them: text, metadata and score, optionally in chunks", Request: RetrieveRequest{}, Response: RetrieveResponse{}},
+
+	"POST /stix/bundle": {Summary: "Ingest a STIX 2.1 bundle", Request: stix.Bundle{}, Response: gin.H{}},
+	"POST /stix/export": {Summary: "Export search results as a STIX 2.1 bundle", Request: storage.SearchQuery{}, Response: stix.Bundle{}},
+
+	"GET /pipelines": {Summary: "List ingest pipelines and processor types", Response: struct {
+		Pipelines  map[string]pipeline.Definition `json:"pipelines"`
+		Processors []string                       `json:"processors"`
+	}{}},
+	"GET /pipelines/:name":           {Summary: "Get an ingest pipeline", Response: pipeline.Definition{}},
+	"PUT /pipelines/:name":           {Summary: "Create or replace an ingest pipeline", Request: pipeline.Definition{}, Response: StatusResponse{}, YAML: true},
+	"DELETE /pipelines/:name":        {Summary: "Delete an ingest pipeline", Response: StatusResponse{}},
+	"POST /pipelines/:name/simulate": {Summary: "Run a pipeline on a document without storing it", Request: map[string]interface{}{}, Response: gin.H{}, YAML: true},
+	"POST /scan":                     {Summary: "Scan content with the stored YARA rules", Response: []ScanMatch{}},
+
+	"POST /index/create":    {Summary: "Create an index, building it over the existing entries in the background", Request: IndexRequest{}, Response: StatusResponse{}},
+	"POST /index/estimate":  {Summary: "Predict the memory, build time and coverage of an index from a sample of the documents", Request: storage.IndexEstimateRequest{}, Response: storage.IndexEstimate{}, YAML: true},
+	"DELETE /index/remove":  {Summary: "Remove an index", Request: IndexRequest{}, Response: StatusResponse{}},
+	"GET /index/coercions":  {Summary: "Declared field types", Response: map[string]string{}},
+	"GET /index/tokenizers": {Summary: "Tokenizers of text indexes", Response: []string{}},
+
+	"POST /admin/sync":        {Summary: "Write the data file now", Response: StatusResponse{}},
+	"GET /admin/stats":        {Summary: "Store statistics", Response: storage.StoreStats{}, YAML: true},
+	"POST /admin/stats/reset": {Summary: "Clear the latency histograms", Response: StatusResponse{}},
+	"GET /metrics":            {Summary: "Store statistics and latency percentiles in the Prometheus text format"},
+	"GET /admin/memory":       {Summary: "Runtime and store memory usage", Response: gin.H{}, YAML: true},
+	"GET /admin/alerts":       {Summary: "Alert thresholds and the alerts firing", Response: AlertsResponse{}, YAML: true},
+	"GET /admin/stats/history": {Summary: "Stats samples kept every -stats-interval, oldest first", Response: storage.StatsHistory{}, YAML: true, Query: map[string]string{
+		"window": "Only samples of this last duration, e.g. 1h",
+	}},
+	"GET /admin/jobs": {Summary: "Running jobs and the last finished ones, oldest first", Response: JobsResponse{}, YAML: true, Query: map[string]string{
+		"kind":  "Only jobs of this kind, e.g. index_build",
+		"state": "Only jobs in this state, e.g. running",
+	}},
+	"GET /admin/hotkeys": {Summary: "Most read keys with their estimated recent reads, hottest first", Response: struct {
+		Keys []storage.HotKey `json:"keys"`
+	}{}, YAML: true, Query: map[string]string{"limit": "Maximum number of keys"}},
+	"DELETE /admin/hotkeys":      {Summary: "Forget the reads counted so far", Response: StatusResponse{}},
+	"GET /admin/slowlog":         {Summary: "Slow queries, newest first", Response: []storage.SlowQuery{}, YAML: true},
+	"DELETE /admin/slowlog":      {Summary: "Clear the slow query log", Response: StatusResponse{}},
+	"GET /admin/index-errors":    {Summary: "Values indexes rejected", Response: storage.IndexErrorReport{}, YAML: true},
+	"DELETE /admin/index-errors": {Summary: "Clear the index error report", Response: StatusResponse{}},
+	"GET /admin/index-usage":     {Summary: "Searches that read each index, unused indexes first", Response: storage.IndexUsageReport{}, YAML: true, Query: map[string]string{"days": "Flag indexes not read in this many days, default 7"}},
+	"GET /admin/jobs/:id":        {Summary: "Progress, result or error of a job", Response: storage.Job{}, YAML: true},
+	"DELETE /admin/jobs/:id":     {Summary: "Cancel a running job; cancelled index builds remove the partly built index", Response: StatusResponse{}},
+	"GET /admin/conflicts":       {Summary: "Entries from peers that differed from local ones and how they were resolved", Response: storage.ConflictReport{}, YAML: true},
+	"DELETE /admin/conflicts":    {Summary: "Clear the conflict report", Response: StatusResponse{}},
+	"POST /admin/gc":             {Summary: "Sweep expired entries, then run the garbage collector and release memory to the OS", Response: gin.H{}},
+	"GET /admin/goroutines":      {Summary: "Stack traces of all goroutines (text/plain)"},
+	"GET /admin/pprof/*profile":  {Summary: "Runtime profiles (application/octet-stream)", Query: map[string]string{"seconds": "Duration of CPU profiles and traces"}},
+	"POST /admin/pprof/*profile": {Summary: "Runtime profiles (application/octet-stream)"},
+	"GET /admin/tenants":         {Summary: "Per-tenant usage", Response: []TenantStats{}, YAML: true},
+	"GET /admin/acl":             {Summary: "Access control rules", Response: ACLConfig{}},
+	"PUT /admin/acl":             {Summary: "Replace the access control rules", Request: ACLConfig{}, Response: StatusResponse{}, YAML: true},
+	"GET /admin/ingest/git":      {Summary: "Ingested Git repositories", Response: []GitSourceStatus{}},
+	"POST /admin/ingest/git":     {Summary: "Ingest YAML files from a Git repository", Request: GitIngestRequest{}, Response: GitSourceStatus{}, YAML: true},
+	"GET /admin/k8s/changes":     {Summary: "Kubernetes sync changes (text/event-stream)"},
+	"POST /admin/merkle":         {Summary: "Merkle tree digests below the given nodes", Request: MerkleRequest{}, Response: MerkleResponse{}},
+	"POST /admin/entries":        {Summary: "Entries of the given keys with their timestamps", Request: EntriesRequest{}, Response: map[string]map[string]SyncEntry{}},
+	"PUT /admin/entries":         {Summary: "Apply entries from a peer, keeping the newer of each", Request: PutEntriesRequest{}, Response: map[string][]string{}},
+	"POST /admin/verify":         {Summary: "Hash every entry again and report those that no longer match their stored hash", Response: storage.VerifyReport{}, YAML: true, Query: map[string]string{"async": "true runs the verification as a job whose result is the report"}},
+	"POST /admin/blobs/gc":       {Summary: "Delete blobs no document refers to", Query: map[string]string{"older_than": "Keep blobs younger than this, default 1h", "async": "true runs the collection as a job whose result is the count"}, Response: BlobGCResult{}},
+	"POST /admin/sync-with":      {Summary: "Reconcile the data with a peer by Merkle tree anti-entropy", Request: SyncWithRequest{}, Response: SyncWithResult{}},
+	"GET /admin/faults":          {Summary: "Injected faults (chaos builds only)", Response: []FaultConfig{}},
+	"POST /admin/faults":         {Summary: "Inject a fault, replacing any at the same point", Request: FaultConfig{}, Response: StatusResponse{}},
+	"DELETE /admin/faults":       {Summary: "Remove injected faults", Query: map[string]string{"point": "Remove only the fault at this point"}, Response: StatusResponse{}},
+}
+
+// handleOpenAPI serves the OpenAPI document of the routes registered on r
+// and the actions dispatched by POST /data/*key. The document is generated
+// on the first request, once every route exists.
+func handleOpenAPI(r *gin.Engine) gin.HandlerFunc {
+	var once sync.Once
+	var doc []byte
+	return func(c *gin.Context) {
+		once.Do(func() {
+			routes := r.Routes()
+			for _, action := range append(dataActions, "*key"+copySuffix, "*key"+renameSuffix) {
+				routes = append(routes, gin.RouteInfo{Method: "POST", Path: "/data/" + action})
+			}
+			for _, method := range []string{"GET", "HEAD", "POST", "DELETE"} {
+				routes = append(routes, gin.RouteInfo{Method: method, Path: "/data/*key" + blobSuffix})
+			}
+			routes = append(routes, gin.RouteInfo{Method: "GET", Path: "/data/*key" + hashSuffix})
+			for _, method := range []string{"GET", "POST", "DELETE"} {
+				routes = append(routes, gin.RouteInfo{Method: method, Path: "/data/*key" + linksSuffix})
+			}
+			routes = append(routes,
+				gin.RouteInfo{Method: "GET", Path: "/data/*key" + tagsSuffix},
+				gin.RouteInfo{Method: "POST", Path: "/data/*key" + tagsSuffix},
+				gin.RouteInfo{Method: "DELETE", Path: "/data/*key" + tagsSuffix + "/:tag"})
+			doc, _ = json.Marshal(openAPIDocument(routes))
+		})
+		c.Data(200, "application/json", doc)
+	}
+}
+
+// handleDocs serves Swagger UI for /openapi.json
+func handleDocs(c *gin.Context) {
+	c.Data(200, "text/html; charset=utf-8", docsPage)
+}
+
+// openAPIDocument describes routes as an OpenAPI 3 document
+func openAPIDocument(routes gin.RoutesInfo) map[string]interface{} {
+	g := &schemaGenerator{components: make(map[string]interface{}), names: make(map[reflect.Type]string)}
+	errorRef := g.schema(reflect.TypeOf(ErrorResponse{}))
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		if route.Path == "/openapi.json" || route.Path == "/docs" || route.Path == "/ui" {
+			continue
+		}
+
+		path, params := openAPIPath(route.Path)
+		doc := apiOperations[route.Method+" "+route.Path]
+
+		for name, description := range doc.Query {
+			params = append(params, map[string]interface{}{"name": name, "in": "query", "description": description, "schema": map[string]interface{}{"type": "string"}})
+		}
+		for name, description := range doc.Headers {
+			params = append(params, map[string]interface{}{"name": name, "in": "header", "description": description, "schema": map[string]interface{}{"type": "string"}})
+		}
+		sort.SliceStable(params, func(i, j int) bool {
+			pi, pj := params[i].(map[string]interface{}), params[j].(map[string]interface{})
+			if pi["in"] != pj["in"] {
+				return pi["in"] == "path"
+			}
+			return pi["name"].(string) < pj["name"].(string)
+		})
+
+		ok := map[string]interface{}{"description": "OK"}
+		if doc.Response != nil {
+			ok["content"] = g.content(doc.Response, doc.YAML)
+		}
+		op := map[string]interface{}{
+			"operationId": operationID(route.Method, route.Path),
+			"tags":        []string{strings.Split(strings.TrimPrefix(route.Path, "/"), "/")[0]},
+			"responses": map[string]interface{}{
+				"200":     ok,
+				"default": map[string]interface{}{"description": "Error", "content": map[string]interface{}{"application/json": map[string]interface{}{"schema": errorRef}}},
+			},
+		}
+		if doc.Summary != "" {
+			op["summary"] = doc.Summary
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if doc.Request != nil {
+			op["requestBody"] = map[string]interface{}{"required": true, "content": g.content(doc.Request, doc.YAML)}
+		}
+
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "SearchYAML",
+			"version": "1.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// API keys are required only when ACLs or tenants are configured
+		"security": []interface{}{
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{},
+		},
+	}
+}
+
+// openAPIPath converts gin path parameters (:key, *profile) to OpenAPI
+// templates and returns their parameter objects
+func openAPIPath(path string) (string, []interface{}) {
+	var params []interface{}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		name := segment[1:]
+		segments[i] = "{" + name + "}"
+		params = append(params, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}})
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// operationID derives an identifier such as post_search_text from a route
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.TrimLeft(segment, ":*")
+		if segment != "" {
+			id += "_" + strings.ReplaceAll(segment, "-", "_")
+		}
+	}
+	return id
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// schemaGenerator converts Go types to OpenAPI schemas following the
+// encoding/json rules. Named structs become components referenced by $ref.
+type schemaGenerator struct {
+	components map[string]interface{}
+	names      map[reflect.Type]string
+}
+
+// content returns the media types of a request or response body
+func (g *schemaGenerator) content(v interface{}, yaml bool) map[string]interface{} {
+	schema := map[string]interface{}{"schema": g.schema(reflect.TypeOf(v))}
+	content := map[string]interface{}{"application/json": schema}
+	if yaml {
+		content["application/x-yaml"] = schema
+	}
+	return content
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "description": "Nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name, exists := g.names[t]
+		if !exists {
+			name = g.componentName(t)
+			g.names[t] = name
+			g.components[name] = map[string]interface{}{} // Placeholder for recursive types
+			g.components[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{} // Any value
+}
+
+// componentName returns the type name, qualified with its package when
+// another package already uses it
+func (g *schemaGenerator) componentName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := g.components[name]; taken {
+		pkg := t.PkgPath()
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+	}
+	return name
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	g.addFields(t, properties, &required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields adds the JSON properties of a struct, inlining embedded structs
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(ft, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = g.schema(field.Type)
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// cacheHeader tells whether GET /data/:key found the key in the store (HIT)
+// or fetched it from the origin (MISS)
+const cacheHeader = "X-Cache"
+
+// errOriginNotFound is returned by origin sources that do not have a key
+var errOriginNotFound = errors.New("key not found at origin")
+
+// originSource is an upstream service the store caches documents of
+type originSource interface {
+	// Fetch returns the document of key, or errOriginNotFound
+	Fetch(ctx context.Context, tenant, key string) ([]byte, error)
+	// Put writes the document of key, encoded as contentType
+	Put(ctx context.Context, tenant, key string, body []byte, contentType string) error
+	// Delete removes key; keys the origin does not have are not an error
+	Delete(ctx context.Context, tenant, key string) error
+}
+
+// Origin puts the store in front of a slower upstream service: reads of
+// missing keys fetch, cache and index the document from the origin, and with
+// write-through, writes and deletes go to the origin before the store
+type Origin struct {
+	source       originSource
+	ttl          time.Duration
+	writeThrough bool
+
+	mu       sync.Mutex
+	inflight map[string]*originFetch // Fetches in progress by tenant and key
+}
+
+// originFetch is a fetch from the origin shared by concurrent misses of a key
+type originFetch struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// NewOrigin returns the origin described by spec, a URL template such as
+// https://config.internal/v1/{key} or the path of a script, or nil when spec
+// is empty. Fetched documents expire after ttl, or never if it is 0.
+func NewOrigin(spec string, ttl, timeout time.Duration, writeThrough bool) (*Origin, error) {
+	var source originSource
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		if !strings.Contains(spec, "{key}") {
+			return nil, fmt.Errorf("origin URL %q has no {key}", spec)
+		}
+		source = &httpOrigin{template: spec, client: &http.Client{Timeout: timeout}}
+	default:
+		if _, err := exec.LookPath(spec); err != nil {
+			return nil, fmt.Errorf("origin script: %w", err)
+		}
+		source = &scriptOrigin{path: spec, timeout: timeout}
+	}
+	if ttl < 0 {
+		return nil, fmt.Errorf("origin TTL must not be negative")
+	}
+	return &Origin{
+		source:       source,
+		ttl:          ttl,
+		writeThrough: writeThrough,
+		inflight:     make(map[string]*originFetch),
+	}, nil
+}
+
+// handleOrigin wraps the GET, HEAD, POST and DELETE /data/*key handlers:
+// misses of GET and HEAD are filled from the origin before next serves them,
+// and with write-through, POST and DELETE reach the origin before next
+// applies them to the store
+func handleOrigin(store *storage.Store, origin *Origin, next gin.HandlerFunc) gin.HandlerFunc {
+	if origin == nil {
+		return next
+	}
+	return func(c *gin.Context) {
+		key := dataKey(c)
+		if isDirectory(key) || isActionKey(key) {
+			next(c)
+			return
+		}
+
+		store := tenantStore(c, store)
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			if !readThrough(c, store, origin, key) {
+				return
+			}
+		case http.MethodPost:
+			if origin.writeThrough && !writeThrough(c, origin, key) {
+				return
+			}
+		case http.MethodDelete:
+			if origin.writeThrough && !deleteThrough(c, origin, key) {
+				return
+			}
+		}
+		next(c)
+	}
+}
+
+// readThrough caches the document of key from the origin when the store
+// does not have it. It reports whether the request should go on: keys the
+// origin does not have either are answered with 404 by the next handler.
+func readThrough(c *gin.Context, store *storage.Store, origin *Origin, key string) bool {
+	if !canRead(c, key) || store.ReadOnly() {
+		return true
+	}
+	if _, exists := store.Get(key); exists {
+		c.Header(cacheHeader, "HIT")
+		return true
+	}
+
+	value, err := origin.fetch(c.Request.Context(), tenantName(c), key)
+	switch {
+	case errors.Is(err, errOriginNotFound):
+		c.Header(cacheHeader, "MISS")
+		return true
+	case err != nil:
+		log.Printf("Origin fetch of %s failed: %v", key, err)
+		respondError(c, 502, CodeUpstreamFailed, fmt.Sprintf("origin fetch failed: %v", err))
+		return false
+	}
+
+	// Concurrent misses of the key share the fetch and all store it; the
+	// writes are equal
+	if err := store.SetWithTTL(key, value, origin.ttl); err != nil {
+		respondStoreError(c, err)
+		return false
+	}
+	c.Header(cacheHeader, "MISS")
+	return true
+}
+
+// fetch returns the decoded document of key from the origin, sharing the
+// fetch with concurrent misses of the same key
+func (o *Origin) fetch(ctx context.Context, tenant, key string) (interface{}, error) {
+	id := tenant + "\x00" + key
+	o.mu.Lock()
+	if f, ok := o.inflight[id]; ok {
+		o.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.value, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	f := &originFetch{done: make(chan struct{})}
+	o.inflight[id] = f
+	o.mu.Unlock()
+
+	// The fetch outlives a cancelled request, as other misses may wait on it
+	raw, err := o.source.Fetch(context.WithoutCancel(ctx), tenant, key)
+	if err == nil {
+		err = decodeLimits().Decode(raw, &f.value)
+	}
+	f.err = err
+
+	o.mu.Lock()
+	delete(o.inflight, id)
+	o.mu.Unlock()
+	close(f.done)
+	return f.value, f.err
+}
+
+// writeThrough sends the body of a write to the origin and reports whether
+// the write should go on to the store. Writes the origin rejects are not
+// stored.
+func writeThrough(c *gin.Context, origin *Origin, key string) bool {
+	if !canWrite(c, key) {
+		return true // Rejected by the next handler
+	}
+	if !checkWritableKey(c, key) {
+		return false
+	}
+	raw, err := decodeLimits().ReadAll(c.Request.Body)
+	if err != nil {
+		respondBadRequest(c, err)
+		return false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(raw))
+
+	contentType := c.GetHeader("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	if err := origin.source.Put(c.Request.Context(), tenantName(c), key, raw, contentType); err != nil {
+		log.Printf("Origin write of %s failed: %v", key, err)
+		respondError(c, 502, CodeUpstreamFailed, fmt.Sprintf("origin write failed: %v", err))
+		return false
+	}
+	return true
+}
+
+// deleteThrough deletes key at the origin and reports whether the delete
+// should go on to the store
+func deleteThrough(c *gin.Context, origin *Origin, key string) bool {
+	if !canWrite(c, key) {
+		return true
+	}
+	if !checkWritableKey(c, key) {
+		return false
+	}
+	if err := origin.source.Delete(c.Request.Context(), tenantName(c), key); err != nil {
+		log.Printf("Origin delete of %s failed: %v", key, err)
+		respondError(c, 502, CodeUpstreamFailed, fmt.Sprintf("origin delete failed: %v", err))
+		return false
+	}
+	return true
+}
+
+// httpOrigin reads and writes documents at a URL made from a template by
+// replacing {key}, and {tenant} if present
+type httpOrigin struct {
+	template string
+	client   *http.Client
+}
+
+func (o *httpOrigin) url(tenant, key string) string {
+	// Escape each segment of hierarchical keys, keeping the slashes
+	path := (&url.URL{Path: key}).EscapedPath()
+	return strings.NewReplacer("{key}", path, "{tenant}", url.PathEscape(tenant)).Replace(o.template)
+}
+
+func (o *httpOrigin) do(ctx context.Context, method, tenant, key string, body []byte, contentType string) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, o.url(tenant, key), r)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json, application/x-yaml")
+	return o.client.Do(req)
+}
+
+func (o *httpOrigin) Fetch(ctx context.Context, tenant, key string) ([]byte, error) {
+	resp, err := o.do(ctx, http.MethodGet, tenant, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errOriginNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("origin returned %s", resp.Status)
+	}
+	return decodeLimits().ReadAll(resp.Body)
+}
+
+func (o *httpOrigin) Put(ctx context.Context, tenant, key string, body []byte, contentType string) error {
+	resp, err := o.do(ctx, http.MethodPut, tenant, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("origin returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (o *httpOrigin) Delete(ctx context.Context, tenant, key string) error {
+	resp, err := o.do(ctx, http.MethodDelete, tenant, key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("origin returned %s", resp.Status)
+	}
+	return nil
+}
+
+// scriptOrigin runs a script as `script get|put|delete <key>` with the
+// tenant in SEARCHYAML_TENANT. get prints the document, or nothing if the
+// key does not exist; put reads it from stdin, with its content type in
+// SEARCHYAML_CONTENT_TYPE. A non-zero exit status fails the request.
+type scriptOrigin struct {
+	path    string
+	timeout time.Duration
+}
+
+func (o *scriptOrigin) run(ctx context.Context, op, tenant, key string, stdin []byte, contentType string) ([]byte, error) {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, o.path, op, key)
+	cmd.Env = append(os.Environ(), "SEARCHYAML_TENANT="+tenant)
+	if contentType != "" {
+		cmd.Env = append(cmd.Env, "SEARCHYAML_CONTENT_TYPE="+contentType)
+	}
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s %s: %w: %s", o.path, op, err, msg)
+		}
+		return nil, fmt.Errorf("%s %s: %w", o.path, op, err)
+	}
+	return out, nil
+}
+
+func (o *scriptOrigin) Fetch(ctx context.Context, tenant, key string) ([]byte, error) {
+	out, err := o.run(ctx, "get", tenant, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, errOriginNotFound
+	}
+	if limit := decodeLimits().MaxSize; limit > 0 && int64(len(out)) > limit {
+		return nil, fmt.Errorf("origin document exceeds %d bytes", limit)
+	}
+	return out, nil
+}
+
+func (o *scriptOrigin) Put(ctx context.Context, tenant, key string, body []byte, contentType string) error {
+	_, err := o.run(ctx, "put", tenant, key, body, contentType)
+	return err
+}
+
+func (o *scriptOrigin) Delete(ctx context.Context, tenant, key string) error {
+	_, err := o.run(ctx, "delete", tenant, key, nil, "")
+	return err
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Parquet physical types, repetitions and encodings, from parquet.thrift
+const (
+	parquetBoolean   = 0
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1
+	parquetUTF8     = 0 // ConvertedType of strings
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// Thrift compact protocol field types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// parquetColumn is a column of optional values: booleans, doubles or UTF-8
+// strings. A nil value is a null.
+type parquetColumn struct {
+	name   string
+	kind   int // parquetBoolean, parquetDouble or parquetByteArray
+	values []interface{}
+}
+
+// writeParquet writes the columns, which must have the same length, as a
+// Parquet file with a single row group of uncompressed, PLAIN encoded
+// pages, which every Parquet reader accepts
+func writeParquet(w io.Writer, columns []parquetColumn, rows int) error {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	type chunk struct {
+		offset, size int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, col := range columns {
+		page := encodeParquetPage(col)
+		var header thriftWriter
+		header.begin()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.field(5, thriftStruct)
+		header.begin()
+		header.i32(1, int32(rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunks[i] = chunk{offset: int64(file.Len()), size: int64(header.buf.Len() + len(page))}
+		file.Write(header.buf.Bytes())
+		file.Write(page)
+	}
+
+	var meta thriftWriter
+	meta.begin()
+	meta.i32(1, 1) // version
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.begin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.end()
+	for _, col := range columns {
+		meta.begin()
+		meta.i32(1, int32(col.kind))
+		meta.i32(3, parquetOptional)
+		meta.binary(4, col.name)
+		if col.kind == parquetByteArray {
+			meta.i32(6, parquetUTF8)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(rows))
+
+	var total int64
+	for _, c := range chunks {
+		total += c.size
+	}
+	meta.list(4, thriftStruct, 1)
+	meta.begin()
+	meta.list(1, thriftStruct, len(columns))
+	for i, col := range columns {
+		meta.begin()
+		meta.i64(2, chunks[i].offset)
+		meta.field(3, thriftStruct)
+		meta.begin()
+		meta.i32(1, int32(col.kind))
+		meta.list(2, thriftI32, 2)
+		meta.varint(zigzag(parquetPlain))
+		meta.varint(zigzag(parquetRLE))
+		meta.list(3, thriftBinary, 1)
+		meta.varint(uint64(len(col.name)))
+		meta.buf.WriteString(col.name)
+		meta.i32(4, 0) // UNCOMPRESSED
+		meta.i64(5, int64(rows))
+		meta.i64(6, chunks[i].size)
+		meta.i64(7, chunks[i].size)
+		meta.i64(9, chunks[i].offset)
+		meta.end()
+		meta.end()
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(rows))
+	meta.end()
+	meta.binary(6, "searchyaml")
+	meta.end()
+
+	file.Write(meta.buf.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.buf.Len()))
+	file.WriteString("PAR1")
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// encodeParquetPage encodes the definition levels and the non-null values
+// of a column
+func encodeParquetPage(col parquetColumn) []byte {
+	// Definition levels as bit-packed runs of width 1, 0 for nulls
+	levels := make([]byte, (len(col.values)+7)/8)
+	for i, v := range col.values {
+		if v != nil {
+			levels[i/8] |= 1 << (i % 8)
+		}
+	}
+	var hybrid bytes.Buffer
+	if len(levels) > 0 {
+		hybrid.Write(binary.AppendUvarint(nil, uint64(len(levels))<<1|1))
+		hybrid.Write(levels)
+	}
+
+	var page bytes.Buffer
+	binary.Write(&page, binary.LittleEndian, uint32(hybrid.Len()))
+	page.Write(hybrid.Bytes())
+
+	switch col.kind {
+	case parquetBoolean:
+		var bits []byte
+		n := 0
+		for _, v := range col.values {
+			if v == nil {
+				continue
+			}
+			if n%8 == 0 {
+				bits = append(bits, 0)
+			}
+			if v.(bool) {
+				bits[n/8] |= 1 << (n % 8)
+			}
+			n++
+		}
+		page.Write(bits)
+	case parquetDouble:
+		for _, v := range col.values {
+			if v != nil {
+				binary.Write(&page, binary.LittleEndian, math.Float64bits(v.(float64)))
+			}
+		}
+	default:
+		for _, v := range col.values {
+			if v != nil {
+				s := v.(string)
+				binary.Write(&page, binary.LittleEndian, uint32(len(s)))
+				page.WriteString(s)
+			}
+		}
+	}
+	return page.Bytes()
+}
+
+// thriftWriter encodes the structures of the Parquet footer and page
+// headers in the Thrift compact protocol
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // Last field ID of each open struct
+}
+
+func (t *thriftWriter) begin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0) // Stop field
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	top := &t.last[len(t.last)-1]
+	if delta := id - *top; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.varint(zigzag(int64(id)))
+	}
+	*top = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// list starts a list field of n elements, which the caller writes next
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		t.varint(uint64(n))
+	}
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
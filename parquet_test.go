@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// thriftReader decodes the Thrift compact protocol into maps of field IDs
+// to values, enough to read back the footer and page headers of a Parquet
+// file
+type thriftReader struct {
+	t    *testing.T
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.t.Fatalf("thrift: read past the end at %d", r.pos)
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) bytes(n int) []byte {
+	if n < 0 || r.pos+n > len(r.data) {
+		r.t.Fatalf("thrift: %d bytes at %d past the end", n, r.pos)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("thrift: invalid varint at %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		b := r.byte()
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		if _, dup := fields[id]; dup {
+			r.t.Fatalf("thrift: field %d written twice", id)
+		}
+		fields[id] = r.value(b & 0x0f)
+		last = id
+	}
+}
+
+func (r *thriftReader) value(kind byte) interface{} {
+	switch kind {
+	case 1, 2: // Booleans of struct fields
+		return kind == 1
+	case 3:
+		return int8(r.byte())
+	case 4, thriftI32, thriftI64:
+		return r.zigzag()
+	case 7:
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.bytes(8)))
+	case thriftBinary:
+		return string(r.bytes(int(r.varint())))
+	case thriftList:
+		header := r.byte()
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	r.t.Fatalf("thrift: unsupported type %d at %d", kind, r.pos)
+	return nil
+}
+
+// parquetFile is what readParquet reads back of a file
+type parquetFile struct {
+	meta    map[int16]interface{} // FileMetaData
+	columns []parquetColumn
+}
+
+// readParquet checks the layout of a Parquet file written by writeParquet
+// and decodes its footer and pages
+func readParquet(t *testing.T, data []byte) parquetFile {
+	t.Helper()
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("file of %d bytes does not start and end with PAR1", len(data))
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - footerLen
+	if footerStart < 4 {
+		t.Fatalf("footer length %d exceeds the file", footerLen)
+	}
+	footer := &thriftReader{t: t, data: data[footerStart : len(data)-8]}
+	meta := footer.structure()
+	if footer.pos != footerLen {
+		t.Fatalf("footer holds %d bytes after FileMetaData", footerLen-footer.pos)
+	}
+
+	rows := meta[3].(int64)
+	schema := meta[2].([]interface{})
+	root := schema[0].(map[int16]interface{})
+	if root[4] != "schema" || root[5] != int64(len(schema)-1) {
+		t.Fatalf("schema root %v, want %d children", root, len(schema)-1)
+	}
+	rowGroups := meta[4].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(rowGroups))
+	}
+	rowGroup := rowGroups[0].(map[int16]interface{})
+	chunks := rowGroup[1].([]interface{})
+	if len(chunks) != len(schema)-1 || rowGroup[3] != rows {
+		t.Fatalf("row group of %d chunks and %v rows, want %d and %d", len(chunks), rowGroup[3], len(schema)-1, rows)
+	}
+
+	file := parquetFile{meta: meta}
+	var total int64
+	end := int64(4)
+	for i, element := range schema[1:] {
+		element := element.(map[int16]interface{})
+		column := parquetColumn{name: element[4].(string), kind: int(element[1].(int64))}
+		if element[3] != int64(parquetOptional) {
+			t.Errorf("column %s has repetition %v, want optional", column.name, element[3])
+		}
+		if converted, ok := element[6]; (column.kind == parquetByteArray) != ok || ok && converted != int64(parquetUTF8) {
+			t.Errorf("column %s of type %d has converted type %v", column.name, column.kind, converted)
+		}
+
+		chunk := chunks[i].(map[int16]interface{})
+		chunkMeta := chunk[3].(map[int16]interface{})
+		offset, size := chunk[2].(int64), chunkMeta[6].(int64)
+		want := map[int16]interface{}{
+			1: int64(column.kind),
+			2: []interface{}{int64(parquetPlain), int64(parquetRLE)},
+			3: []interface{}{column.name},
+			4: int64(0),
+			5: rows,
+			6: size,
+			7: size,
+			9: offset,
+		}
+		if !reflect.DeepEqual(chunkMeta, want) {
+			t.Errorf("column %s chunk metadata %v, want %v", column.name, chunkMeta, want)
+		}
+		if offset != end {
+			t.Errorf("column %s starts at %d, want %d right after the previous one", column.name, offset, end)
+		}
+		end = offset + size
+		total += size
+
+		column.values = readParquetPage(t, data[offset:offset+size], column.kind, int(rows))
+		file.columns = append(file.columns, column)
+	}
+	if end != int64(footerStart) || rowGroup[2] != total {
+		t.Errorf("pages end at %d with %v bytes in the row group, want %d and %d", end, rowGroup[2], footerStart, total)
+	}
+	return file
+}
+
+// readParquetPage decodes a data page of a column chunk: its header, the
+// definition levels and the non-null values
+func readParquetPage(t *testing.T, chunk []byte, kind, rows int) []interface{} {
+	t.Helper()
+	r := &thriftReader{t: t, data: chunk}
+	header := r.structure()
+	page := chunk[r.pos:]
+	want := map[int16]interface{}{
+		1: int64(0),
+		2: int64(len(page)),
+		3: int64(len(page)),
+		5: map[int16]interface{}{1: int64(rows), 2: int64(parquetPlain), 3: int64(parquetRLE), 4: int64(parquetRLE)},
+	}
+	if !reflect.DeepEqual(header, want) {
+		t.Fatalf("page header %v, want %v", header, want)
+	}
+
+	// Definition levels of width 1 in the RLE/bit-packed hybrid encoding
+	levelsLen := int(binary.LittleEndian.Uint32(page))
+	levels := &thriftReader{t: t, data: page[4 : 4+levelsLen]}
+	var defined []bool
+	for len(defined) < rows {
+		run := levels.varint()
+		if run&1 == 1 {
+			for _, b := range levels.bytes(int(run >> 1)) {
+				for bit := 0; bit < 8; bit++ {
+					defined = append(defined, b&(1<<bit) != 0)
+				}
+			}
+		} else {
+			value := levels.byte() != 0
+			for range run >> 1 {
+				defined = append(defined, value)
+			}
+		}
+	}
+	if levels.pos != levelsLen {
+		t.Fatalf("%d bytes after the definition levels", levelsLen-levels.pos)
+	}
+
+	r = &thriftReader{t: t, data: page[4+levelsLen:]}
+	values := make([]interface{}, rows)
+	n := 0
+	for i := range values {
+		if !defined[i] {
+			continue
+		}
+		switch kind {
+		case parquetBoolean:
+			if n%8 == 0 {
+				r.byte()
+			}
+			values[i] = r.data[r.pos-1]&(1<<(n%8)) != 0
+		case parquetDouble:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(r.bytes(8)))
+		case parquetByteArray:
+			values[i] = string(r.bytes(int(binary.LittleEndian.Uint32(r.bytes(4)))))
+		}
+		n++
+	}
+	if r.pos != len(r.data) {
+		t.Fatalf("%d bytes after the values", len(r.data)-r.pos)
+	}
+	return values
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	numbers, flags := make([]interface{}, 20), make([]interface{}, 20)
+	for i := range numbers {
+		if i%3 != 0 {
+			numbers[i] = float64(i)
+		}
+		if i%7 != 0 {
+			flags[i] = i%2 == 0
+		}
+	}
+	manyNames, manyValues, manyKinds := make([]string, 16), make([][]interface{}, 16), make([]int, 16)
+	for i := range manyNames {
+		manyNames[i] = fmt.Sprintf("c%d", i)
+		manyValues[i] = []interface{}{manyNames[i]}
+		manyKinds[i] = parquetByteArray
+	}
+
+	tests := []struct {
+		name    string
+		columns []string
+		values  [][]interface{} // Of each column
+		kinds   []int
+		want    [][]interface{} // The values read back, if not values
+	}{
+		{
+			name:    "types",
+			columns: []string{"key", "score", "active", "tags", "empty"},
+			values: [][]interface{}{
+				{"hosts/a", "hosts/b", "hosts/ü"},
+				{1.5, int64(2), nil},
+				{true, nil, false},
+				{[]interface{}{"x", 1}, "plain", map[string]interface{}{"k": "v"}},
+				{nil, nil, nil},
+			},
+			kinds: []int{parquetByteArray, parquetDouble, parquetBoolean, parquetByteArray, parquetByteArray},
+			want: [][]interface{}{
+				{"hosts/a", "hosts/b", "hosts/ü"},
+				{1.5, 2.0, nil},
+				{true, nil, false},
+				{`["x",1]`, "plain", `{"k":"v"}`},
+				{nil, nil, nil},
+			},
+		},
+		{
+			name:    "mixed numbers and strings",
+			columns: []string{"value"},
+			values:  [][]interface{}{{1, "one", 2.5, true}},
+			kinds:   []int{parquetByteArray},
+			want:    [][]interface{}{{"1", "one", "2.5", "true"}},
+		},
+		{
+			name:    "levels across bytes",
+			columns: []string{"n", "flag"},
+			values:  [][]interface{}{numbers, flags},
+			kinds:   []int{parquetDouble, parquetBoolean},
+		},
+		{
+			name:    "special values",
+			columns: []string{"double", "text"},
+			values:  [][]interface{}{{math.Inf(-1), math.SmallestNonzeroFloat64, math.MaxFloat64}, {"", "a\x00b", "quote\"d,"}},
+			kinds:   []int{parquetDouble, parquetByteArray},
+		},
+		{
+			name:    "no rows",
+			columns: []string{"key", "n"},
+			values:  [][]interface{}{{}, {}},
+			kinds:   []int{parquetByteArray, parquetByteArray},
+		},
+		{
+			name:    "many columns",
+			columns: manyNames,
+			values:  manyValues,
+			kinds:   manyKinds,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == nil {
+				want = make([][]interface{}, len(tt.values))
+				for i, values := range tt.values {
+					want[i] = append([]interface{}{}, values...)
+				}
+			}
+			rows := len(tt.values[0])
+			columns := make([]parquetColumn, len(tt.columns))
+			for i, name := range tt.columns {
+				columns[i] = newParquetColumn(name, tt.values[i])
+			}
+			var buf bytes.Buffer
+			if err := writeParquet(&buf, columns, rows); err != nil {
+				t.Fatal(err)
+			}
+
+			file := readParquet(t, buf.Bytes())
+			if file.meta[1] != int64(1) || file.meta[3] != int64(rows) || file.meta[6] != "searchyaml" {
+				t.Errorf("file metadata version %v, rows %v, created by %v", file.meta[1], file.meta[3], file.meta[6])
+			}
+			if len(file.columns) != len(tt.columns) {
+				t.Fatalf("%d columns read back, want %d", len(file.columns), len(tt.columns))
+			}
+			for i, column := range file.columns {
+				if column.name != tt.columns[i] || column.kind != tt.kinds[i] {
+					t.Errorf("column %d is %s of type %d, want %s of type %d", i, column.name, column.kind, tt.columns[i], tt.kinds[i])
+				}
+				if !reflect.DeepEqual(column.values, want[i]) {
+					t.Errorf("column %s values %#v, want %#v", column.name, column.values, want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// Package pipeline implements named ingest pipelines: ordered processors that
+// transform documents before they are written to the store.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/threatflux/searchyaml/expr"
+)
+
+// ErrDropped is returned when a processor drops the document
+var ErrDropped = errors.New("document dropped by pipeline")
+
+// Processor transforms a document. Processors may modify doc in place.
+type Processor interface {
+	Process(doc map[string]interface{}) error
+}
+
+// Factory builds a processor from its configuration
+type Factory func(config map[string]interface{}) (Processor, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a processor type available to pipeline definitions
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// Types returns the registered processor type names
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Definition is the configuration of a pipeline. Each processor entry is a
+// single-key map from processor type to its settings, e.g.
+// {"rename": {"field": "src", "target_field": "src_ip"}}.
+type Definition struct {
+	Description string                              `yaml:"description,omitempty" json:"description,omitempty"`
+	Processors  []map[string]map[string]interface{} `yaml:"processors" json:"processors"`
+}
+
+// Pipeline is a compiled sequence of processors
+type Pipeline struct {
+	Name       string
+	Definition Definition
+	steps      []step
+}
+
+// step wraps a processor with the options common to every processor type
+type step struct {
+	kind          string
+	processor     Processor
+	condition     *expr.Program
+	ignoreFailure bool
+}
+
+// Compile validates a definition and builds its processors
+func Compile(name string, def Definition) (*Pipeline, error) {
+	p := &Pipeline{Name: name, Definition: def}
+
+	for i, entry := range def.Processors {
+		if len(entry) != 1 {
+			return nil, fmt.Errorf("pipeline %s: processor %d must have exactly one type", name, i)
+		}
+
+		for kind, config := range entry {
+			registryMu.RLock()
+			factory, exists := registry[kind]
+			registryMu.RUnlock()
+			if !exists {
+				return nil, fmt.Errorf("pipeline %s: unknown processor type %q", name, kind)
+			}
+
+			if config == nil {
+				config = map[string]interface{}{}
+			}
+			processor, err := factory(config)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline %s: processor %d (%s): %v", name, i, kind, err)
+			}
+
+			s := step{kind: kind, processor: processor}
+			if cond, ok := config["if"].(string); ok && cond != "" {
+				if s.condition, err = expr.Compile(cond); err != nil {
+					return nil, fmt.Errorf("pipeline %s: processor %d (%s): %v", name, i, kind, err)
+				}
+			}
+			s.ignoreFailure, _ = config["ignore_failure"].(bool)
+			p.steps = append(p.steps, s)
+		}
+	}
+
+	return p, nil
+}
+
+// Run applies the pipeline to a copy of value and returns the result. Only map
+// documents can be processed. ErrDropped is returned if a processor drops it.
+func (p *Pipeline) Run(value interface{}) (interface{}, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("pipeline %s: document must be a map", p.Name)
+	}
+	doc := deepCopy(m).(map[string]interface{})
+
+	for _, s := range p.steps {
+		if s.condition != nil {
+			ok, err := s.condition.EvalBool(doc)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline %s: %s condition: %v", p.Name, s.kind, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if err := s.processor.Process(doc); err != nil {
+			if errors.Is(err, ErrDropped) {
+				return nil, ErrDropped
+			}
+			if s.ignoreFailure {
+				continue
+			}
+			return nil, fmt.Errorf("pipeline %s: %s: %v", p.Name, s.kind, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// Registry holds named pipelines
+type Registry struct {
+	sync.RWMutex
+	pipelines map[string]*Pipeline
+}
+
+// NewRegistry compiles the given definitions
+func NewRegistry(defs map[string]Definition) (*Registry, error) {
+	r := &Registry{pipelines: make(map[string]*Pipeline)}
+	for name, def := range defs {
+		if err := r.Put(name, def); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Get returns a pipeline by name
+func (r *Registry) Get(name string) (*Pipeline, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	p, ok := r.pipelines[name]
+	return p, ok
+}
+
+// Put compiles and stores a pipeline, replacing any existing one
+func (r *Registry) Put(name string, def Definition) error {
+	if name == "" || strings.ContainsAny(name, "/ ") {
+		return fmt.Errorf("invalid pipeline name: %q", name)
+	}
+
+	p, err := Compile(name, def)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.pipelines[name] = p
+	return nil
+}
+
+// Delete removes a pipeline and reports whether it existed
+func (r *Registry) Delete(name string) bool {
+	r.Lock()
+	defer r.Unlock()
+	_, ok := r.pipelines[name]
+	delete(r.pipelines, name)
+	return ok
+}
+
+// Definitions returns all pipeline definitions by name
+func (r *Registry) Definitions() map[string]Definition {
+	r.RLock()
+	defer r.RUnlock()
+
+	defs := make(map[string]Definition, len(r.pipelines))
+	for name, p := range r.pipelines {
+		defs[name] = p.Definition
+	}
+	return defs
+}
+
+// getPath resolves a dotted field path
+func getPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	current := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	v, ok := current[parts[len(parts)-1]]
+	return v, ok
+}
+
+// setPath sets a dotted field path, creating intermediate maps
+func setPath(doc map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	current := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+// deletePath removes a dotted field path
+func deletePath(doc map[string]interface{}, path string) {
+	parts := strings.Split(path, ".")
+	current := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		current = next
+	}
+	delete(current, parts[len(parts)-1])
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			out[k] = deepCopy(child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, child := range v {
+			out[i] = deepCopy(child)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,462 @@
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/threatflux/searchyaml/expr"
+)
+
+func init() {
+	Register("rename", newRename)
+	Register("set", newSet)
+	Register("remove", newRemove)
+	Register("lowercase", newCaseProcessor(strings.ToLower))
+	Register("uppercase", newCaseProcessor(strings.ToUpper))
+	Register("regex", newRegex)
+	Register("grok", newGrok)
+	Register("date", newDate)
+	Register("drop", newDrop)
+	Register("script", newScript)
+}
+
+// ProcessorFunc adapts a function to the Processor interface
+type ProcessorFunc func(doc map[string]interface{}) error
+
+// Process calls f(doc)
+func (f ProcessorFunc) Process(doc map[string]interface{}) error {
+	return f(doc)
+}
+
+func stringOption(config map[string]interface{}, name string, required bool) (string, error) {
+	v, exists := config[name]
+	if !exists {
+		if required {
+			return "", fmt.Errorf("missing %s", name)
+		}
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok || required && s == "" {
+		return "", fmt.Errorf("%s must be a non-empty string", name)
+	}
+	return s, nil
+}
+
+func stringListOption(config map[string]interface{}, name string) ([]string, error) {
+	switch v := config[name].(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must contain strings", name)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	case nil:
+		return nil, fmt.Errorf("missing %s", name)
+	}
+	return nil, fmt.Errorf("%s must be a string or list of strings", name)
+}
+
+func boolOption(config map[string]interface{}, name string, def bool) bool {
+	if v, ok := config[name].(bool); ok {
+		return v
+	}
+	return def
+}
+
+// newRename moves field to target_field
+func newRename(config map[string]interface{}) (Processor, error) {
+	field, err := stringOption(config, "field", true)
+	if err != nil {
+		return nil, err
+	}
+	target, err := stringOption(config, "target_field", true)
+	if err != nil {
+		return nil, err
+	}
+	ignoreMissing := boolOption(config, "ignore_missing", false)
+
+	return ProcessorFunc(func(doc map[string]interface{}) error {
+		v, ok := getPath(doc, field)
+		if !ok {
+			if ignoreMissing {
+				return nil
+			}
+			return fmt.Errorf("field %s not found", field)
+		}
+		deletePath(doc, field)
+		setPath(doc, target, v)
+		return nil
+	}), nil
+}
+
+var templatePattern = regexp.MustCompile(`\{\{\s*([\w.@]+)\s*\}\}`)
+
+// newSet assigns value to field. String values may reference other fields
+// with {{path}} templates.
+func newSet(config map[string]interface{}) (Processor, error) {
+	field, err := stringOption(config, "field", true)
+	if err != nil {
+		return nil, err
+	}
+	value, exists := config["value"]
+	if !exists {
+		return nil, fmt.Errorf("missing value")
+	}
+	override := boolOption(config, "override", true)
+
+	return ProcessorFunc(func(doc map[string]interface{}) error {
+		if _, exists := getPath(doc, field); exists && !override {
+			return nil
+		}
+
+		v := value
+		if s, ok := value.(string); ok {
+			v = templatePattern.ReplaceAllStringFunc(s, func(m string) string {
+				path := templatePattern.FindStringSubmatch(m)[1]
+				resolved, _ := getPath(doc, path)
+				if resolved == nil {
+					return ""
+				}
+				return fmt.Sprint(resolved)
+			})
+		}
+		setPath(doc, field, deepCopy(v))
+		return nil
+	}), nil
+}
+
+// newRemove deletes one or more fields
+func newRemove(config map[string]interface{}) (Processor, error) {
+	fields, err := stringListOption(config, "field")
+	if err != nil {
+		return nil, err
+	}
+
+	return ProcessorFunc(func(doc map[string]interface{}) error {
+		for _, field := range fields {
+			deletePath(doc, field)
+		}
+		return nil
+	}), nil
+}
+
+// newCaseProcessor builds lowercase/uppercase processors
+func newCaseProcessor(convert func(string) string) Factory {
+	return func(config map[string]interface{}) (Processor, error) {
+		field, err := stringOption(config, "field", true)
+		if err != nil {
+			return nil, err
+		}
+		target, err := stringOption(config, "target_field", false)
+		if err != nil {
+			return nil, err
+		}
+		if target == "" {
+			target = field
+		}
+		ignoreMissing := boolOption(config, "ignore_missing", false)
+
+		return ProcessorFunc(func(doc map[string]interface{}) error {
+			v, ok := getPath(doc, field)
+			if !ok {
+				if ignoreMissing {
+					return nil
+				}
+				return fmt.Errorf("field %s not found", field)
+			}
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("field %s is not a string", field)
+			}
+			setPath(doc, target, convert(s))
+			return nil
+		}), nil
+	}
+}
+
+// extractor sets fields from the named groups of a regular expression match
+type extractor struct {
+	field         string
+	pattern       *regexp.Regexp
+	groups        map[string]string // group name -> target field path
+	ignoreMissing bool
+}
+
+func (e *extractor) Process(doc map[string]interface{}) error {
+	v, ok := getPath(doc, e.field)
+	if !ok {
+		if e.ignoreMissing {
+			return nil
+		}
+		return fmt.Errorf("field %s not found", e.field)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("field %s is not a string", e.field)
+	}
+
+	match := e.pattern.FindStringSubmatch(s)
+	if match == nil {
+		return fmt.Errorf("field %s does not match pattern", e.field)
+	}
+
+	for i, name := range e.pattern.SubexpNames() {
+		if target, ok := e.groups[name]; ok && match[i] != "" {
+			setPath(doc, target, match[i])
+		}
+	}
+	return nil
+}
+
+// newRegex extracts named groups, e.g. pattern: '(?P<user>\w+) logged in'
+func newRegex(config map[string]interface{}) (Processor, error) {
+	field, err := stringOption(config, "field", true)
+	if err != nil {
+		return nil, err
+	}
+	source, err := stringOption(config, "pattern", true)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := regexp.Compile(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+
+	groups := make(map[string]string)
+	for _, name := range pattern.SubexpNames() {
+		if name != "" {
+			groups[name] = name
+		}
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("pattern has no named groups")
+	}
+
+	return &extractor{
+		field:         field,
+		pattern:       pattern,
+		groups:        groups,
+		ignoreMissing: boolOption(config, "ignore_missing", false),
+	}, nil
+}
+
+// GrokPatterns are the built-in patterns available as %{NAME} in grok processors
+var GrokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?\d+`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d+)?|\.\d+)`,
+	"IPV4":              `(?:\d{1,3}\.){3}\d{1,3}`,
+	"IPV6":              `[0-9a-fA-F:]*:[0-9a-fA-F:.]+`,
+	"IP":                `(?:(?:\d{1,3}\.){3}\d{1,3}|[0-9a-fA-F:]*:[0-9a-fA-F:.]+)`,
+	"HOSTNAME":          `\b(?:[0-9A-Za-z][0-9A-Za-z-]{0,62})(?:\.(?:[0-9A-Za-z][0-9A-Za-z-]{0,62}))*\.?\b`,
+	"EMAILADDRESS":      `[\w.+-]+@[\w-]+(?:\.[\w-]+)+`,
+	"URI":               `[A-Za-z][A-Za-z0-9+.-]*://\S+`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"MD5":               `[a-fA-F0-9]{32}`,
+	"SHA1":              `[a-fA-F0-9]{40}`,
+	"SHA256":            `[a-fA-F0-9]{64}`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|alert|emerg(?:ency)?)`,
+}
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@]+))?\}`)
+
+// newGrok extracts fields with grok syntax, e.g. '%{IP:client} %{WORD:method}'
+func newGrok(config map[string]interface{}) (Processor, error) {
+	field, err := stringOption(config, "field", true)
+	if err != nil {
+		return nil, err
+	}
+	source, err := stringOption(config, "pattern", true)
+	if err != nil {
+		return nil, err
+	}
+
+	custom := make(map[string]string)
+	if defs, ok := config["pattern_definitions"].(map[string]interface{}); ok {
+		for name, def := range defs {
+			s, ok := def.(string)
+			if !ok {
+				return nil, fmt.Errorf("pattern_definitions.%s must be a string", name)
+			}
+			custom[name] = s
+		}
+	}
+
+	groups := make(map[string]string)
+	var expandErr error
+	expanded := grokReference.ReplaceAllStringFunc(source, func(m string) string {
+		ref := grokReference.FindStringSubmatch(m)
+		pattern, ok := custom[ref[1]]
+		if !ok {
+			pattern, ok = GrokPatterns[ref[1]]
+		}
+		if !ok {
+			expandErr = fmt.Errorf("unknown grok pattern %s", ref[1])
+			return ""
+		}
+		if ref[2] == "" {
+			return "(?:" + pattern + ")"
+		}
+		// Field paths may contain dots, which are not valid group names
+		group := "g" + strconv.Itoa(len(groups))
+		groups[group] = ref[2]
+		return "(?P<" + group + ">" + pattern + ")"
+	})
+	if expandErr != nil {
+		return nil, expandErr
+	}
+
+	pattern, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+
+	return &extractor{
+		field:         field,
+		pattern:       pattern,
+		groups:        groups,
+		ignoreMissing: boolOption(config, "ignore_missing", false),
+	}, nil
+}
+
+// dateFormats maps named formats to Go layouts
+var dateFormats = map[string]string{
+	"RFC3339":  time.RFC3339,
+	"ISO8601":  time.RFC3339Nano,
+	"RFC1123":  time.RFC1123,
+	"RFC1123Z": time.RFC1123Z,
+	"RFC822":   time.RFC822,
+	"DATE":     "2006-01-02",
+	"DATETIME": "2006-01-02 15:04:05",
+}
+
+// newDate parses field with the first matching format and writes it as
+// RFC 3339 to target_field (default: field). Formats may be Go layouts,
+// named formats (RFC3339, ISO8601, DATE, ...), UNIX or UNIX_MS.
+func newDate(config map[string]interface{}) (Processor, error) {
+	field, err := stringOption(config, "field", true)
+	if err != nil {
+		return nil, err
+	}
+	target, err := stringOption(config, "target_field", false)
+	if err != nil {
+		return nil, err
+	}
+	if target == "" {
+		target = field
+	}
+	formats, err := stringListOption(config, "formats")
+	if err != nil {
+		return nil, err
+	}
+	ignoreMissing := boolOption(config, "ignore_missing", false)
+
+	return ProcessorFunc(func(doc map[string]interface{}) error {
+		v, ok := getPath(doc, field)
+		if !ok {
+			if ignoreMissing {
+				return nil
+			}
+			return fmt.Errorf("field %s not found", field)
+		}
+
+		for _, format := range formats {
+			if t, ok := parseDate(v, format); ok {
+				setPath(doc, target, t.UTC().Format(time.RFC3339Nano))
+				return nil
+			}
+		}
+		return fmt.Errorf("field %s does not match any date format", field)
+	}), nil
+}
+
+func parseDate(v interface{}, format string) (time.Time, bool) {
+	switch format {
+	case "UNIX", "UNIX_MS":
+		f, ok := expr.ToNumber(v)
+		if !ok {
+			return time.Time{}, false
+		}
+		if format == "UNIX_MS" {
+			return time.UnixMilli(int64(f)), true
+		}
+		return time.Unix(int64(f), int64((f-float64(int64(f)))*1e9)), true
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	if layout, named := dateFormats[format]; named {
+		format = layout
+	}
+	t, err := time.Parse(format, s)
+	return t, err == nil
+}
+
+// newDrop drops the document; combine with if to drop conditionally
+func newDrop(config map[string]interface{}) (Processor, error) {
+	return ProcessorFunc(func(map[string]interface{}) error {
+		return ErrDropped
+	}), nil
+}
+
+// assignment is a single "path = expression" script statement
+type assignment struct {
+	field string
+	value *expr.Program
+}
+
+var assignmentPattern = regexp.MustCompile(`^\s*([\w.@]+)\s*=([^=].*)$`)
+
+// newScript evaluates assignments separated by newlines or semicolons, e.g.
+// "risk = severity * confidence / 100; reviewed = false"
+func newScript(config map[string]interface{}) (Processor, error) {
+	source, err := stringOption(config, "source", true)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []assignment
+	for _, line := range strings.FieldsFunc(source, func(r rune) bool { return r == '\n' || r == ';' }) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		m := assignmentPattern.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("invalid statement %q, expected field = expression", strings.TrimSpace(line))
+		}
+		program, err := expr.Compile(m[2])
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, assignment{field: m[1], value: program})
+	}
+
+	return ProcessorFunc(func(doc map[string]interface{}) error {
+		for _, s := range statements {
+			v, err := s.value.Eval(doc)
+			if err != nil {
+				return err
+			}
+			setPath(doc, s.field, v)
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/pipeline"
+	"gopkg.in/yaml.v3"
+)
+
+// pipelinesConfig is the on-disk format of the -pipelines file
+type pipelinesConfig struct {
+	Pipelines map[string]pipeline.Definition `yaml:"pipelines"`
+}
+
+// Pipelines holds the ingest pipelines and persists changes to the
+// pipelines file when one is configured
+type Pipelines struct {
+	*pipeline.Registry
+	mu   sync.Mutex
+	path string
+}
+
+// LoadPipelines reads pipeline definitions from path. An empty path starts
+// with no pipelines; pipelines created through the API are then kept in memory.
+func LoadPipelines(path string) (*Pipelines, error) {
+	var config pipelinesConfig
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read pipelines file: %v", err)
+		}
+		if err := yaml.Unmarshal(raw, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse pipelines file: %v", err)
+		}
+	}
+
+	registry, err := pipeline.NewRegistry(config.Pipelines)
+	if err != nil {
+		return nil, err
+	}
+	return &Pipelines{Registry: registry, path: path}, nil
+}
+
+// save writes the current definitions back to the pipelines file
+func (p *Pipelines) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	raw, err := yaml.Marshal(pipelinesConfig{Pipelines: p.Definitions()})
+	if err != nil {
+		return fmt.Errorf("failed to encode pipelines: %v", err)
+	}
+	if err := os.WriteFile(p.path, raw, 0644); err != nil {
+		return fmt.Errorf("failed to write pipelines file: %v", err)
+	}
+	return nil
+}
+
+// applyPipeline runs the pipeline named by the ?pipeline query parameter, if
+// any. It writes an error response and returns false when the document should
+// not be stored.
+func applyPipeline(c *gin.Context, pipelines *Pipelines, value interface{}) (interface{}, bool) {
+	name := c.Query("pipeline")
+	if name == "" {
+		return value, true
+	}
+
+	p, exists := pipelines.Get(name)
+	if !exists {
+		respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("unknown pipeline: %s", name))
+		return nil, false
+	}
+
+	processed, err := p.Run(value)
+	if errors.Is(err, pipeline.ErrDropped) {
+		c.JSON(200, gin.H{"status": "dropped"})
+		return nil, false
+	}
+	if err != nil {
+		respondBadRequest(c, err)
+		return nil, false
+	}
+	return processed, true
+}
+
+func handleListPipelines(pipelines *Pipelines) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"pipelines":  pipelines.Definitions(),
+			"processors": pipeline.Types(),
+		})
+	}
+}
+
+func handleGetPipeline(pipelines *Pipelines) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, exists := pipelines.Get(c.Param("name"))
+		if !exists {
+			respondError(c, 404, CodeNotFound, "pipeline not found")
+			return
+		}
+		c.JSON(200, p.Definition)
+	}
+}
+
+func handlePutPipeline(pipelines *Pipelines) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var def pipeline.Definition
+		if err := parseRequestBody(c, &def); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		if err := pipelines.Put(c.Param("name"), def); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		if err := pipelines.save(); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
+
+func handleDeletePipeline(pipelines *Pipelines) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !pipelines.Delete(c.Param("name")) {
+			respondError(c, 404, CodeNotFound, "pipeline not found")
+			return
+		}
+
+		if err := pipelines.save(); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
+
+// handleSimulatePipeline runs a pipeline against the request body without storing it
+func handleSimulatePipeline(pipelines *Pipelines) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, exists := pipelines.Get(c.Param("name"))
+		if !exists {
+			respondError(c, 404, CodeNotFound, "pipeline not found")
+			return
+		}
+
+		var value interface{}
+		if err := parseRequestBody(c, &value); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		processed, err := p.Run(value)
+		if errors.Is(err, pipeline.ErrDropped) {
+			c.JSON(200, gin.H{"dropped": true})
+			return
+		}
+		if err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{"dropped": false, "document": processed})
+	}
+}
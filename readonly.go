@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// readOnlyRoutes are POST routes that do not modify data and stay available
+// in read-only mode
+var readOnlyRoutes = map[string]bool{
+	"/search/text":              true,
+	"/search/vector":            true,
+	"/search/combined":          true,
+	"/search/count":             true,
+	"/search/exists":            true,
+	"/sql":                      true,
+	"/graphql":                  true, // Mutations fail with read_only
+	"/mcp":                      true, // So do writing tools
+	"/v1/retrieve":              true,
+	"/graph/traverse":           true,
+	"/stix/export":              true,
+	"/scan":                     true,
+	"/index/estimate":           true,
+	"/pipelines/:name/simulate": true,
+	"/admin/gc":                 true,
+	"/admin/merkle":             true,
+	"/admin/entries":            true,
+	"/admin/verify":             true,
+	"/admin/jobs/:id":           true, // Cancelling a job, such as a verify, writes nothing
+}
+
+// readOnlyMiddleware rejects requests that modify data with 403 when the
+// server runs read-only. Reads, searches and the routes in readOnlyRoutes
+// are served as usual.
+func readOnlyMiddleware(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled {
+			c.Next()
+			return
+		}
+
+		switch c.Request.Method {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			if route := c.FullPath(); route != "" && !readOnlyRoutes[route] {
+				respondError(c, 403, CodeReadOnly, "server is read-only")
+				return
+			}
+		}
+		c.Next()
+	}
+}
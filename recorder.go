@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Bodies recorded with -record
+const (
+	recordHash = "hash" // SHA-256 and size only
+	recordFull = "full" // Redacted JSON and YAML bodies, others by hash
+	recordNone = "none"
+)
+
+// maxUnreadBody is the part of request bodies their handler did not read
+// that is still captured, as much as net/http reads to reuse the connection
+const maxUnreadBody = 256 << 10
+
+// credentialParams are query parameters whose values are never recorded
+var credentialParams = map[string]struct{}{
+	"api_key":      {},
+	"apikey":       {},
+	"key":          {},
+	"token":        {},
+	"access_token": {},
+	"password":     {},
+	"secret":       {},
+}
+
+// Recorder appends the requests the server answers, and their responses,
+// to a trace file that `searchyaml replay` sends again. Credentials are
+// never recorded: of the headers only the content type, Accept and the
+// tenant are kept.
+type Recorder struct {
+	mu       sync.Mutex
+	file     *os.File
+	bodies   string
+	sample   float64
+	maxBody  int64
+	redactor *storage.Redactor
+}
+
+// NewRecorder opens the trace file at path for appending. An empty path
+// disables recording. Full bodies are masked by the -redact rules.
+func NewRecorder(path, bodies string, sample float64, maxBody int64, redaction *Redaction) (*Recorder, error) {
+	if path == "" {
+		return nil, nil
+	}
+	switch bodies {
+	case recordHash, recordFull, recordNone:
+	default:
+		return nil, fmt.Errorf("unknown -record-bodies %q: use hash, full or none", bodies)
+	}
+	if sample <= 0 || sample > 1 {
+		return nil, fmt.Errorf("-record-sample must be above 0 and at most 1, not %g", sample)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %v", err)
+	}
+	r := &Recorder{file: file, bodies: bodies, sample: sample, maxBody: maxBody}
+	if redaction != nil {
+		r.redactor = redaction.redactor
+	}
+	return r, nil
+}
+
+// Close closes the trace file
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// capture hashes a body as it passes and keeps its first bytes
+type capture struct {
+	hash  hash.Hash
+	size  int64
+	limit int64
+	buf   bytes.Buffer
+}
+
+func newCapture(limit int64) *capture {
+	return &capture{hash: sha256.New(), limit: limit}
+}
+
+func (c *capture) add(p []byte) {
+	c.hash.Write(p)
+	c.size += int64(len(p))
+	if room := c.limit - int64(c.buf.Len()); room > 0 {
+		c.buf.Write(p[:min(int64(len(p)), room)])
+	}
+}
+
+// complete reports whether the whole body was kept
+func (c *capture) complete() bool {
+	return c.size <= c.limit
+}
+
+func (c *capture) sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// captureReader captures a request body as the handler reads it
+type captureReader struct {
+	io.ReadCloser
+	capture *capture
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.capture.add(p[:n])
+	return n, err
+}
+
+// captureWriter captures a response body as the handler writes it
+type captureWriter struct {
+	gin.ResponseWriter
+	capture *capture
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.capture.add(p[:n])
+	return n, err
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.capture.add([]byte(s[:n]))
+	return n, err
+}
+
+// recordMiddleware records the requests of the routes registered after it,
+// with a fraction of -record-sample of them
+func recordMiddleware(r *Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if r == nil || (r.sample < 1 && rand.Float64() >= r.sample) {
+			c.Next()
+			return
+		}
+
+		started := time.Now()
+		limit := r.maxBody
+		if r.bodies != recordFull {
+			limit = 0
+		}
+		request, response := newCapture(limit), newCapture(limit)
+		if c.Request.Body != nil {
+			c.Request.Body = &captureReader{ReadCloser: c.Request.Body, capture: request}
+		}
+		c.Writer = &captureWriter{ResponseWriter: c.Writer, capture: response}
+		c.Next()
+		if c.Request.Body != nil {
+			io.Copy(io.Discard, io.LimitReader(c.Request.Body, maxUnreadBody))
+		}
+
+		record := TraceRecord{
+			Time:        started,
+			Method:      c.Request.Method,
+			Path:        sanitizePath(c.Request.URL, r.redactor),
+			ContentType: c.ContentType(),
+			Accept:      strings.TrimPrefix(c.GetHeader("Accept"), "*/*"),
+			Status:      c.Writer.Status(),
+			Duration:    milliseconds(time.Since(started)),
+		}
+		if tenant := tenantName(c); tenant != DefaultTenant {
+			record.Tenant = tenant
+		}
+		if r.bodies != recordNone {
+			record.Body, record.BodySize, record.BodyHash = r.body(request, record.ContentType)
+			record.Response, record.ResponseSize, record.ResponseHash = r.body(response, c.Writer.Header().Get("Content-Type"))
+		}
+		r.write(record)
+	}
+}
+
+// body returns a captured body as recorded: redacted in full unless it is
+// too large or neither JSON nor YAML, otherwise only its size and hash
+func (r *Recorder) body(captured *capture, contentType string) (json.RawMessage, int64, string) {
+	if captured.size == 0 {
+		return nil, 0, ""
+	}
+	if r.bodies == recordFull && captured.complete() {
+		if body, ok := r.redactBody(captured.buf.Bytes(), contentType); ok {
+			return body, captured.size, ""
+		}
+	}
+	return nil, captured.size, captured.sum()
+}
+
+// redactBody masks the secrets of a JSON or YAML body, returning JSON
+// bodies as JSON and YAML ones as a JSON string
+func (r *Recorder) redactBody(raw []byte, contentType string) (json.RawMessage, bool) {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(mediaType) {
+	case "application/json", "":
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, false
+		}
+		if r.redactor != nil {
+			value = r.redactor.Redact(value)
+		}
+		body, err := json.Marshal(value)
+		return body, err == nil
+	case "application/x-yaml", "application/yaml", "text/yaml":
+		if r.redactor != nil {
+			var value interface{}
+			if err := yaml.Unmarshal(raw, &value); err != nil {
+				return nil, false
+			}
+			redacted, err := yaml.Marshal(r.redactor.Redact(value))
+			if err != nil {
+				return nil, false
+			}
+			raw = redacted
+		}
+		body, err := json.Marshal(string(raw))
+		return body, err == nil
+	}
+	return nil, false
+}
+
+// sanitizePath returns the path and query of a request with credential
+// parameters masked and the others redacted
+func sanitizePath(u *url.URL, redactor *storage.Redactor) string {
+	path := u.EscapedPath()
+	if u.RawQuery == "" {
+		return path
+	}
+	query := u.Query()
+	for name, values := range query {
+		for i, value := range values {
+			if _, secret := credentialParams[strings.ToLower(name)]; secret {
+				values[i] = storage.DefaultRedactionMask
+			} else if redactor != nil {
+				values[i] = fmt.Sprint(redactor.Redact(value))
+			}
+		}
+	}
+	return path + "?" + query.Encode()
+}
+
+// write appends a record to the trace file. Failures are logged, as the
+// request was answered already.
+func (r *Recorder) write(record TraceRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Failed to encode trace record: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(line); err != nil {
+		log.Printf("Failed to write trace record: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// redactionConfig is the on-disk format of the -redact configuration
+type redactionConfig struct {
+	storage.RedactionRules `yaml:",inline"`
+	RevealKeys             []string `yaml:"reveal_keys"` // API keys allowed to see unredacted values
+}
+
+// Redaction applies secret masking to responses for callers without the reveal permission
+type Redaction struct {
+	redactor   *storage.Redactor
+	revealKeys map[string]struct{}
+}
+
+// LoadRedaction reads redaction rules from path. An empty path disables redaction.
+func LoadRedaction(path string) (*Redaction, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redaction file: %v", err)
+	}
+
+	var config redactionConfig
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse redaction file: %v", err)
+	}
+
+	redactor, err := storage.NewRedactor(config.RedactionRules)
+	if err != nil {
+		return nil, err
+	}
+
+	revealKeys := make(map[string]struct{}, len(config.RevealKeys))
+	for _, key := range config.RevealKeys {
+		revealKeys[key] = struct{}{}
+	}
+
+	return &Redaction{redactor: redactor, revealKeys: revealKeys}, nil
+}
+
+// canReveal reports whether the request may see unredacted values
+func (r *Redaction) canReveal(c *gin.Context) bool {
+	if p := principalFrom(c); p != nil && p.Reveal {
+		return true
+	}
+	return r.reveals(apiKeyFrom(c))
+}
+
+// reveals reports whether an API key is one of the reveal keys
+func (r *Redaction) reveals(key string) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.revealKeys[key]
+	return ok
+}
+
+// redactionMiddleware makes the redactor available to handlers unless the
+// caller holds the reveal permission
+func redactionMiddleware(r *Redaction) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if r != nil && !r.canReveal(c) {
+			c.Set("redactor", r.redactor)
+		}
+		c.Next()
+	}
+}
+
+// redactValue returns value with secrets masked, or value itself for
+// callers allowed to see it unredacted. Every handler returning stored
+// values passes them through it.
+func redactValue(c *gin.Context, value interface{}) interface{} {
+	v, exists := c.Get("redactor")
+	if !exists || value == nil {
+		return value
+	}
+	return v.(*storage.Redactor).Redact(value)
+}
+
+// redactEntry returns a copy of entry with its value redacted, or entry
+// itself for callers allowed to see it unredacted. The YAML source of
+// round-trip entries cannot be masked, so the copy has none and its raw
+// form is the redacted value in YAML.
+func redactEntry(c *gin.Context, entry *storage.Entry) *storage.Entry {
+	if _, exists := c.Get("redactor"); !exists || entry == nil {
+		return entry
+	}
+	copied := *entry
+	copied.Value = redactValue(c, entry.Value)
+	copied.Source = ""
+	return &copied
+}
+
+// redactResults masks secrets in search result values in place
+func redactResults(c *gin.Context, results []storage.SearchResult) []storage.SearchResult {
+	if _, exists := c.Get("redactor"); !exists {
+		return results
+	}
+
+	for i := range results {
+		results[i].Value = redactValue(c, results[i].Value)
+		// Chunks are passages of a field of the document, redacted as it
+		for j := range results[i].Chunks {
+			chunk := &results[i].Chunks[j]
+			redacted := redactValue(c, map[string]interface{}{chunk.Field: chunk.Text}).(map[string]interface{})
+			chunk.Text = fmt.Sprint(redacted[chunk.Field])
+		}
+	}
+	return results
+}
+
+// redactViewResults masks secrets in the values of view entries in place
+func redactViewResults(c *gin.Context, results []storage.ViewResult) []storage.ViewResult {
+	for i := range results {
+		results[i].Value = redactValue(c, results[i].Value)
+	}
+	return results
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// redactionRouter serves the read routes of store to callers of the "reader"
+// API key, who may read hosts/* but not see credentials.password, and of
+// the "revealer" key, who may see everything
+func redactionRouter(t *testing.T, store *storage.Store) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	redactor, err := storage.NewRedactor(storage.RedactionRules{Fields: []string{"credentials.password"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl := &ACL{config: ACLConfig{
+		Roles: map[string]Role{
+			"reader": {Read: []string{"hosts/*"}},
+			"admin":  {Admin: true, Reveal: true},
+		},
+		APIKeys: map[string][]string{"reader": {"reader"}, "revealer": {"admin"}},
+	}}
+
+	r := gin.New()
+	r.Use(aclMiddleware(acl))
+	r.Use(redactionMiddleware(&Redaction{redactor: redactor}))
+	r.GET("/data/*key", handleGet(store))
+	r.POST("/graphql", handleGraphQL(store, nil))
+	r.POST("/mcp", handleMCP(store, nil))
+	r.GET("/views/:name", handleGetView(store))
+	return r
+}
+
+// redactionStore holds readable hosts and a secret entry, with a view of the
+// host kinds
+func redactionStore(t *testing.T) *storage.Store {
+	t.Helper()
+	store := storage.NewMemStore()
+	t.Cleanup(func() { store.Close() })
+
+	docs := map[string]interface{}{
+		"hosts/web": map[string]interface{}{
+			"kind":        "host",
+			"name":        "web",
+			"credentials": map[string]interface{}{"user": "admin", "password": "hunter2"},
+		},
+		"secrets/db": map[string]interface{}{"kind": "host", "name": "db"},
+	}
+	for key, doc := range docs {
+		if err := store.Set(key, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A round-trip entry, whose YAML source holds the password too
+	source := "kind: mail # Relay\nname: mail\ncredentials: {user: admin, password: hunter2}\n"
+	if err := store.SetYAML("hosts/mail", []byte(source), 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DefineView("all", storage.SearchQuery{Filters: map[string]interface{}{"kind": "host"}}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// serve sends a request with an API key and returns the response body
+func serve(t *testing.T, r http.Handler, method, target, apiKey, body string) string {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("X-API-Key", apiKey)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s %s: status %d: %s", method, target, w.Code, w.Body)
+	}
+	return w.Body.String()
+}
+
+func TestGetViewRedacts(t *testing.T) {
+	r := redactionRouter(t, redactionStore(t))
+
+	var view ViewResponse
+	if err := json.Unmarshal([]byte(serve(t, r, "GET", "/views/all", "reader", "")), &view); err != nil {
+		t.Fatal(err)
+	}
+	if view.Count != 1 || len(view.Results) != 1 || view.Results[0].Key != "hosts/web" {
+		t.Fatalf("view returns %+v, want only the readable hosts/web", view)
+	}
+	credentials := view.Results[0].Value.(map[string]interface{})["credentials"].(map[string]interface{})
+	if credentials["password"] == "hunter2" || credentials["user"] != "admin" {
+		t.Errorf("view returns credentials %v, want the password masked", credentials)
+	}
+
+	if body := serve(t, r, "GET", "/views/all", "revealer", ""); !strings.Contains(body, "hunter2") {
+		t.Errorf("view for a caller allowed to reveal masks the password: %s", body)
+	}
+}
+
+func TestGetViewExportRedacts(t *testing.T) {
+	r := redactionRouter(t, redactionStore(t))
+
+	for _, format := range []string{exportCSV, exportParquet} {
+		body := serve(t, r, "GET", "/views/all?format="+format+"&columns=key,value.credentials.password,value.name", "reader", "")
+		if strings.Contains(body, "hunter2") {
+			t.Errorf("%s export of the view has the masked password", format)
+		}
+		if strings.Contains(body, "secrets/db") {
+			t.Errorf("%s export of the view has the unreadable secrets/db", format)
+		}
+		if !strings.Contains(body, "hosts/web") {
+			t.Errorf("%s export of the view lacks the readable hosts/web", format)
+		}
+	}
+
+	csv := serve(t, r, "GET", "/views/all?format=csv", "reader", "")
+	if !strings.HasPrefix(csv, "key,credentials,kind,name\n") || !strings.Contains(csv, "hosts/web,") {
+		t.Errorf("CSV export of the view = %q, want the columns of the redacted value", csv)
+	}
+}
+
+// TestReadsRedact reads a masked field through every API returning entries
+func TestReadsRedact(t *testing.T) {
+	r := redactionRouter(t, redactionStore(t))
+
+	reads := []struct {
+		name, method, target, body string
+	}{
+		{"GET", "GET", "/data/hosts/web", ""},
+		{"GET raw", "GET", "/data/hosts/web?format=raw", ""},
+		{"GET YAML source", "GET", "/data/hosts/mail?format=raw", ""},
+		{"GET YAML", "GET", "/data/hosts/mail", ""},
+		{"GraphQL entry", "POST", "/graphql", `{"query": "{ entry(key: \"hosts/web\") { value } }"}`},
+		{"GraphQL entries", "POST", "/graphql", `{"query": "{ entries(keys: [\"hosts/web\"]) { value field(path: \"credentials.password\") } }"}`},
+		{"MCP get_document", "POST", "/mcp", `{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "get_document", "arguments": {"key": "hosts/web"}}}`},
+	}
+	for _, read := range reads {
+		t.Run(read.name, func(t *testing.T) {
+			body := serve(t, r, read.method, read.target, "reader", read.body)
+			if !strings.Contains(body, "admin") {
+				t.Fatalf("response lacks the unmasked credentials.user: %s", body)
+			}
+			if strings.Contains(body, "hunter2") {
+				t.Errorf("response has the masked credentials.password: %s", body)
+			}
+			if body := serve(t, r, read.method, read.target, "revealer", read.body); !strings.Contains(body, "hunter2") {
+				t.Errorf("response for a caller allowed to reveal masks the password: %s", body)
+			}
+		})
+	}
+}
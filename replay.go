@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// slowQueryPrefix starts the slow queries the server logs with -slowlog-log,
+// after the log's timestamp
+const slowQueryPrefix = "slow query: "
+
+// TraceRecord is a request of a trace file, which holds one JSON object per
+// line. Slow queries, one per line or the log lines of -slowlog-log, are
+// read as searches of /search/combined.
+type TraceRecord struct {
+	Time        time.Time       `json:"time"`
+	Method      string          `json:"method"`
+	Path        string          `json:"path"` // With the query string
+	ContentType string          `json:"content_type,omitempty"`
+	Accept      string          `json:"accept,omitempty"`
+	Tenant      string          `json:"tenant,omitempty"`      // Sent as X-Tenant
+	Body        json.RawMessage `json:"body,omitempty"`        // JSON bodies as they were sent, others as a JSON string
+	BodySize    int64           `json:"body_size,omitempty"`   // Recorded bodies only
+	BodyHash    string          `json:"body_sha256,omitempty"` // Of bodies recorded without their content, which cannot be replayed
+	Status      int             `json:"status,omitempty"`      // Recorded response status
+	Duration    float64         `json:"duration_ms,omitempty"` // Recorded response time in milliseconds
+
+	Response     json.RawMessage `json:"response,omitempty"` // Recorded response body, like Body
+	ResponseSize int64           `json:"response_size,omitempty"`
+	ResponseHash string          `json:"response_sha256,omitempty"`
+}
+
+// traceLine is a line of a trace file, either a TraceRecord or a slow query
+type traceLine struct {
+	TraceRecord
+	Query         *storage.SearchQuery `json:"query"`
+	VectorDims    int                  `json:"vector_dims"`
+	QueryDuration float64              `json:"duration"`
+}
+
+// ReplayReport is the result of the replay command
+type ReplayReport struct {
+	Requests   int           `json:"requests" yaml:"requests"`
+	Failed     int           `json:"failed" yaml:"failed"`         // Without a response
+	Skipped    int           `json:"skipped" yaml:"skipped"`       // Recorded with the hash of their body only
+	Mismatched int           `json:"mismatched" yaml:"mismatched"` // Answered with another status than recorded
+	Statuses   map[int]int   `json:"statuses" yaml:"statuses"`
+	Duration   float64       `json:"duration_ms" yaml:"duration_ms"`
+	Lag        float64       `json:"max_lag_ms" yaml:"max_lag_ms"` // Furthest a request was sent behind its schedule
+	Routes     []RouteReport `json:"routes" yaml:"routes"`
+}
+
+// RouteReport is the response times of the requests to a route, in
+// milliseconds
+type RouteReport struct {
+	Route    string  `json:"route" yaml:"route"`
+	Requests int     `json:"requests" yaml:"requests"`
+	Mean     float64 `json:"mean" yaml:"mean"`
+	P50      float64 `json:"p50" yaml:"p50"`
+	P95      float64 `json:"p95" yaml:"p95"`
+	P99      float64 `json:"p99" yaml:"p99"`
+	Max      float64 `json:"max" yaml:"max"`
+	Recorded float64 `json:"recorded_mean,omitempty" yaml:"recorded_mean,omitempty"` // Of the requests with a recorded time
+}
+
+func (r ReplayReport) table() ([]string, [][]string) {
+	rows := make([][]string, len(r.Routes))
+	for i, route := range r.Routes {
+		recorded := "-"
+		if route.Recorded > 0 {
+			recorded = formatMillis(route.Recorded)
+		}
+		rows[i] = []string{route.Route, strconv.Itoa(route.Requests), formatMillis(route.Mean),
+			formatMillis(route.P50), formatMillis(route.P95), formatMillis(route.P99), formatMillis(route.Max), recorded}
+	}
+	return []string{"ROUTE", "REQUESTS", "MEAN", "P50", "P95", "P99", "MAX", "RECORDED"}, rows
+}
+
+func formatMillis(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 2, 64)
+}
+
+// replayResult is the outcome of one replayed request
+type replayResult struct {
+	route    string
+	status   int // 0 without a response
+	recorded TraceRecord
+	duration float64
+}
+
+// runReplay implements the replay command, which sends the requests of a
+// trace to a server at their recorded pace, by default to a server started
+// on a copy of the data file so the original is never written
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay [flags] trace.ndjson [-- server flags]\n\n"+
+			"Replay the requests of a trace and report their response times. Without -url, a server\n"+
+			"is started on a copy of -data with the server flags after --.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	serverURL := fs.String("url", "", "Replay against the running server at this URL instead of a copy of -data")
+	dataFile := fs.String("data", "data.yaml", "Data file copied for the server started for the replay")
+	speed := fs.Float64("speed", 1, "Pace relative to the recorded times: 2 replays twice as fast, 0 as fast as possible")
+	workers := fs.Int("c", 16, "Maximum requests in flight")
+	apiKey := fs.String("api-key", os.Getenv("SEARCHYAML_API_KEY"), "API key sent to the server (default: $SEARCHYAML_API_KEY)")
+	timeout := fs.Duration("timeout", time.Minute, "Timeout of each request")
+	startupTimeout := fs.Duration("startup-timeout", 10*time.Minute, "Time the started server may take to load the copy and build its indexes")
+	serverLog := fs.String("server-log", "", "Write the log of the started server to this file")
+	output := outputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return invalidInput(errors.New("give the trace file to replay"))
+	}
+	if *speed < 0 {
+		return invalidInput(fmt.Errorf("speed must not be negative, not %g", *speed))
+	}
+	serverArgs := fs.Args()[1:]
+	if len(serverArgs) > 0 && serverArgs[0] == "--" {
+		serverArgs = serverArgs[1:]
+	}
+
+	records, err := readTrace(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return invalidInput(fmt.Errorf("%s holds no requests", fs.Arg(0)))
+	}
+
+	target := strings.TrimSuffix(*serverURL, "/")
+	if target == "" {
+		server, err := startReplayServer(*dataFile, serverArgs, *serverLog, *startupTimeout)
+		if err != nil {
+			return err
+		}
+		defer server.stop()
+		target = server.url
+	} else {
+		resp, err := http.Get(target + "/readyz")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+
+	log.Printf("Replaying %d requests to %s", len(records), target)
+	client := &http.Client{Timeout: *timeout}
+	report := replay(client, target, *apiKey, records, *speed, *workers)
+	return printResult(os.Stdout, *output, report, func() {
+		fmt.Printf("Replayed %d requests in %s ms (%d failed, %d with another status than recorded, %d skipped, lag up to %s ms)\n",
+			report.Requests, formatMillis(report.Duration), report.Failed, report.Mismatched, report.Skipped, formatMillis(report.Lag))
+		printResult(os.Stdout, outputTable, report, nil)
+	})
+}
+
+// readTrace reads the records of a trace file in the order they were made
+func readTrace(path string) ([]TraceRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []TraceRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), int(storage.DefaultDecodeLimits.MaxSize))
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if i := bytes.Index(line, []byte(slowQueryPrefix)); i >= 0 && line[0] != '{' {
+			line = line[i+len(slowQueryPrefix):]
+		}
+		if len(line) == 0 || line[0] != '{' {
+			continue // Blank or other log lines
+		}
+		var l traceLine
+		if err := json.Unmarshal(line, &l); err != nil {
+			return nil, invalidInput(fmt.Errorf("%s:%d: %v", path, n, err))
+		}
+		record, ok := l.record()
+		if !ok {
+			return nil, invalidInput(fmt.Errorf("%s:%d: neither a request nor a slow query", path, n))
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+// record returns the request of a trace line. Slow queries lost their
+// vector, which is replaced with a random one of the same dimensions.
+func (l traceLine) record() (TraceRecord, bool) {
+	if l.Method != "" && l.Path != "" {
+		return l.TraceRecord, true
+	}
+	if l.Query == nil {
+		return TraceRecord{}, false
+	}
+	query := *l.Query
+	if len(query.Vector) == 0 && l.VectorDims > 0 {
+		query.Vector = make([]float32, l.VectorDims)
+		for i := range query.Vector {
+			query.Vector[i] = rand.Float32()*2 - 1
+		}
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return TraceRecord{}, false
+	}
+	return TraceRecord{
+		Time:        l.Time,
+		Method:      http.MethodPost,
+		Path:        "/search/combined",
+		ContentType: "application/json",
+		Body:        body,
+		Status:      200,
+		Duration:    l.QueryDuration,
+	}, true
+}
+
+// replay sends the records, each at its recorded offset from the first
+// divided by speed, and reports the response times
+func replay(client *http.Client, target, apiKey string, records []TraceRecord, speed float64, workers int) ReplayReport {
+	results := make(chan replayResult, max(workers, 1))
+	slots := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+
+	report := ReplayReport{Statuses: make(map[int]int)}
+	collected := make(chan struct{})
+	durations := make(map[string][]float64)
+	recorded := make(map[string][]float64)
+	go func() {
+		defer close(collected)
+		for r := range results {
+			report.Requests++
+			if r.status == 0 {
+				report.Failed++
+				continue
+			}
+			report.Statuses[r.status]++
+			if r.recorded.Status != 0 && r.recorded.Status != r.status {
+				report.Mismatched++
+			}
+			durations[r.route] = append(durations[r.route], r.duration)
+			if r.recorded.Duration > 0 {
+				recorded[r.route] = append(recorded[r.route], r.recorded.Duration)
+			}
+		}
+	}()
+
+	start := time.Now()
+	first := records[0].Time
+	for _, record := range records {
+		if len(record.Body) == 0 && record.BodyHash != "" {
+			report.Skipped++ // Only the hash of the body was recorded
+			continue
+		}
+		if speed > 0 && !record.Time.IsZero() && !first.IsZero() {
+			due := start.Add(time.Duration(float64(record.Time.Sub(first)) / speed))
+			time.Sleep(time.Until(due))
+		}
+		slots <- struct{}{}
+		if speed > 0 && !record.Time.IsZero() && !first.IsZero() {
+			due := start.Add(time.Duration(float64(record.Time.Sub(first)) / speed))
+			report.Lag = max(report.Lag, milliseconds(time.Since(due)))
+		}
+		wg.Add(1)
+		go func(record TraceRecord) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results <- sendRecord(client, target, apiKey, record)
+		}(record)
+	}
+	wg.Wait()
+	close(results)
+	<-collected
+	report.Duration = milliseconds(time.Since(start))
+
+	for route, d := range durations {
+		sort.Float64s(d)
+		rr := RouteReport{Route: route, Requests: len(d), Mean: mean(d), Max: d[len(d)-1],
+			P50: percentile(d, 0.50), P95: percentile(d, 0.95), P99: percentile(d, 0.99)}
+		if r := recorded[route]; len(r) > 0 {
+			rr.Recorded = mean(r)
+		}
+		report.Routes = append(report.Routes, rr)
+	}
+	sort.Slice(report.Routes, func(i, j int) bool { return report.Routes[i].Route < report.Routes[j].Route })
+	return report
+}
+
+// sendRecord sends one recorded request and times its response
+func sendRecord(client *http.Client, target, apiKey string, record TraceRecord) replayResult {
+	result := replayResult{route: traceRoute(record), recorded: record}
+
+	var body io.Reader
+	if len(record.Body) > 0 {
+		var text string
+		if record.Body[0] == '"' && json.Unmarshal(record.Body, &text) == nil {
+			body = strings.NewReader(text)
+		} else {
+			body = bytes.NewReader(record.Body)
+		}
+	}
+	req, err := http.NewRequestWithContext(context.Background(), record.Method, target+record.Path, body)
+	if err != nil {
+		return result
+	}
+	if record.ContentType != "" {
+		req.Header.Set("Content-Type", record.ContentType)
+	} else if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if record.Accept != "" {
+		req.Header.Set("Accept", record.Accept)
+	}
+	if record.Tenant != "" {
+		req.Header.Set("X-Tenant", record.Tenant)
+	}
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+
+	began := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	result.duration = milliseconds(time.Since(began))
+	result.status = resp.StatusCode
+	return result
+}
+
+// traceRoute groups the requests of a report: by method and the first two
+// path segments, and every key of /data together
+func traceRoute(record TraceRecord) string {
+	path, _, _ := strings.Cut(record.Path, "?")
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	switch {
+	case segments[0] == "data" && len(segments) > 1:
+		path = "/data/*"
+	case len(segments) > 1:
+		path = "/" + segments[0] + "/" + segments[1]
+	default:
+		path = "/" + segments[0]
+	}
+	return record.Method + " " + path
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// percentile returns the p-th percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	return sorted[min(int(p*float64(len(sorted))), len(sorted)-1)]
+}
+
+// replayServer is a server started on a copy of a data file
+type replayServer struct {
+	url string
+	cmd *exec.Cmd
+	dir string
+}
+
+// startReplayServer copies a data file and the views defined on it to a
+// temporary directory and starts a server on the copy, waiting until it is
+// ready
+func startReplayServer(dataFile string, args []string, logFile string, timeout time.Duration) (*replayServer, error) {
+	dir, err := os.MkdirTemp("", "searchyaml-replay-")
+	if err != nil {
+		return nil, err
+	}
+	copyPath := filepath.Join(dir, filepath.Base(dataFile))
+	if err := copyFile(dataFile, copyPath); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := copyFile(dataFile+".views.json", copyPath+".views.json"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	cmd := exec.Command(executable, append([]string{"-data", copyPath, "-port", addr}, args...)...)
+	if logFile != "" {
+		out, err := os.Create(logFile)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		defer out.Close() // The server has its own descriptor
+		cmd.Stdout, cmd.Stderr = out, out
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	server := &replayServer{url: "http://" + addr, cmd: cmd, dir: dir}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	log.Printf("Starting a server on a copy of %s", dataFile)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exited:
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("server for the replay exited: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if resp, err := http.Get(server.url + "/readyz"); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				return server, nil
+			}
+		}
+	}
+	server.stop()
+	return nil, fmt.Errorf("server for the replay was not ready after %s", timeout)
+}
+
+// stop stops the server and removes the copy
+func (s *replayServer) stop() {
+	s.cmd.Process.Kill()
+	s.cmd.Process.Wait()
+	os.RemoveAll(s.dir)
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
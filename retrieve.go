@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/chunk"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// defaultRetrieveResults is the number of documents retrieved when no top_k
+// is given, the default of LangChain retrievers
+const defaultRetrieveResults = 4
+
+// contentFields are the fields whose value is the text of a document when
+// a retrieve request names no content_field, in order of preference
+var contentFields = []string{"text", "content", "page_content", "body", "description"}
+
+// RetrieveRequest is the body of POST /v1/retrieve
+type RetrieveRequest struct {
+	Query       string                 `json:"query"`
+	Vector      []float32              `json:"vector"`
+	VectorField string                 `json:"vector_field"` // Vector index to search, by default every one of the vector's dimensions
+	TopK        int                    `json:"top_k"`        // Default 4
+	Filters     map[string]interface{} `json:"filters"`
+	TextFields  []string               `json:"text_fields"`
+	Expr        string                 `json:"expr"`
+	MinScore    float64                `json:"min_score"`
+
+	ContentField   string   `json:"content_field"`   // Dotted path of the text; by default text, content, page_content, body or description
+	MetadataFields []string `json:"metadata_fields"` // Dotted paths; by default the other top-level scalar fields
+
+	ChunkSize         int `json:"chunk_size"`          // Split texts into chunks of at most this many bytes
+	ChunkOverlap      int `json:"chunk_overlap"`       // Bytes repeated between consecutive chunks
+	ChunksPerDocument int `json:"chunks_per_document"` // Chunks returned per document, those sharing most query terms; default 1
+
+	Format string `json:"format"` // langchain, llamaindex, or the plain shape by default
+}
+
+// RetrievedDocument is a document or chunk of one in the plain shape
+type RetrievedDocument struct {
+	ID       string                 `json:"id"`
+	Text     string                 `json:"text"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Score    float64                `json:"score"`
+}
+
+// RetrieveResponse is the result of POST /v1/retrieve
+type RetrieveResponse struct {
+	Documents []interface{} `json:"documents"` // RetrievedDocument, or a LangChain Document or LlamaIndex NodeWithScore
+}
+
+// handleRetrieve answers a search with documents shaped for RAG frameworks:
+// text, metadata and score, as LangChain Documents or LlamaIndex nodes on
+// request, so a retriever class of a few lines can use the store. Long texts
+// may be split into chunks, of which the ones sharing most terms with the
+// query are returned in place of the whole text.
+func handleRetrieve(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request RetrieveRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		if request.TopK < 0 || request.ChunksPerDocument < 0 {
+			respondBadRequest(c, fmt.Errorf("top_k and chunks_per_document must not be negative"))
+			return
+		}
+		if request.TopK == 0 {
+			request.TopK = defaultRetrieveResults
+		}
+		if request.ChunksPerDocument == 0 {
+			request.ChunksPerDocument = 1
+		}
+		chunking := chunk.Options{Size: request.ChunkSize, Overlap: request.ChunkOverlap}
+		if request.ChunkSize != 0 || request.ChunkOverlap != 0 {
+			if err := chunking.Validate(); err != nil {
+				respondBadRequest(c, err)
+				return
+			}
+		}
+		switch request.Format {
+		case "", "langchain", "llamaindex":
+		default:
+			respondBadRequest(c, fmt.Errorf("unknown format %q: use langchain or llamaindex", request.Format))
+			return
+		}
+
+		results, err := newRequestScope(c, store, nil).search(storage.SearchQuery{
+			Text:        request.Query,
+			TextFields:  request.TextFields,
+			Vector:      request.Vector,
+			VectorField: request.VectorField,
+			Filters:     request.Filters,
+			Expr:        request.Expr,
+			MaxResults:  request.TopK,
+			MinScore:    request.MinScore,
+		})
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		documents := []interface{}{}
+		for _, result := range results {
+			for _, doc := range retrievedDocuments(result, request, chunking) {
+				documents = append(documents, formatRetrieved(doc, request.Format))
+			}
+		}
+		c.JSON(200, RetrieveResponse{Documents: documents})
+	}
+}
+
+// retrievedDocuments returns a search result as a document, or as its best
+// chunks when the request splits texts
+func retrievedDocuments(result storage.SearchResult, request RetrieveRequest, chunking chunk.Options) []RetrievedDocument {
+	text := documentText(result.Value, request.ContentField)
+	metadata := documentMetadata(result.Value, request.ContentField, request.MetadataFields)
+	metadata["key"] = result.Key
+	// Chunks stored on ingest name their parent and passage
+	if info, ok := lookupPath(result.Value, storage.ChunkField).(map[string]interface{}); ok {
+		metadata["parent"], metadata["chunk"] = info["parent"], info["index"]
+		metadata["start"], metadata["end"] = info["start"], info["end"]
+	}
+	if chunking.Size == 0 {
+		return []RetrievedDocument{{ID: result.Key, Text: text, Metadata: metadata, Score: result.Combined}}
+	}
+
+	tokenizer, _ := storage.NewTokenizer("english")
+	best := chunk.Rank(chunk.Split(text, chunking), request.Query, tokenizer.Tokens, request.ChunksPerDocument)
+	// Return the chosen chunks in text order, as they read
+	sort.Slice(best, func(i, j int) bool { return best[i].Index < best[j].Index })
+	documents := make([]RetrievedDocument, len(best))
+	for i, c := range best {
+		chunkMetadata := make(map[string]interface{}, len(metadata)+3)
+		for name, value := range metadata {
+			chunkMetadata[name] = value
+		}
+		chunkMetadata["chunk"] = c.Index
+		chunkMetadata["start"] = c.Start
+		chunkMetadata["end"] = c.End
+		documents[i] = RetrievedDocument{
+			ID:       fmt.Sprintf("%s#%d", result.Key, c.Index),
+			Text:     c.Text,
+			Metadata: chunkMetadata,
+			Score:    result.Combined,
+		}
+	}
+	return documents
+}
+
+// documentText returns the text of a document: the string at field, or at
+// the first of contentFields present, a string document itself, or else the
+// document encoded as JSON
+func documentText(value interface{}, field string) string {
+	if field != "" {
+		return textOf(lookupPath(value, field))
+	}
+	if m, ok := value.(map[string]interface{}); ok {
+		for _, name := range contentFields {
+			if s, ok := m[name].(string); ok {
+				return s
+			}
+		}
+	}
+	return textOf(value)
+}
+
+func textOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	raw, _ := json.Marshal(value)
+	return string(raw)
+}
+
+// documentMetadata returns the values at paths of a document, or by default
+// its top-level scalar fields other than the text
+func documentMetadata(value interface{}, contentField string, paths []string) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	if len(paths) > 0 {
+		for _, path := range paths {
+			metadata[path] = lookupPath(value, path)
+		}
+		return metadata
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return metadata
+	}
+	text := contentField
+	if text == "" {
+		for _, name := range contentFields {
+			if _, ok := m[name].(string); ok {
+				text = name
+				break
+			}
+		}
+	}
+	for name, v := range m {
+		if name == text {
+			continue
+		}
+		switch v.(type) {
+		case string, float64, int, int64, bool, nil:
+			metadata[name] = v
+		}
+	}
+	return metadata
+}
+
+// formatRetrieved shapes a document for a RAG framework
+func formatRetrieved(doc RetrievedDocument, format string) interface{} {
+	switch format {
+	case "langchain":
+		// Documents have no score; retrievers read it from the metadata
+		doc.Metadata["score"] = doc.Score
+		return gin.H{"id": doc.ID, "page_content": doc.Text, "metadata": doc.Metadata, "type": "Document"}
+	case "llamaindex":
+		return gin.H{"node": gin.H{"id_": doc.ID, "text": doc.Text, "metadata": doc.Metadata}, "score": doc.Score}
+	}
+	return doc
+}
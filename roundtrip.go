@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// roundTripRequested reports whether a write should keep the YAML body
+// verbatim. Only YAML bodies qualify; the X-Round-Trip header overrides the
+// -roundtrip default.
+func roundTripRequested(c *gin.Context) bool {
+	if c.GetHeader("Content-Type") != "application/x-yaml" {
+		return false
+	}
+	switch c.GetHeader("X-Round-Trip") {
+	case "true", "1":
+		return true
+	case "false", "0":
+		return false
+	}
+	return *RoundTrip
+}
+
+// readRoundTripBody reads the raw YAML body and decodes it for indexing
+func readRoundTripBody(c *gin.Context) ([]byte, interface{}, error) {
+	limits := decodeLimits()
+	source, err := limits.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read body: %v", err)
+	}
+
+	var value interface{}
+	if err := limits.Decode(source, &value); err != nil {
+		return nil, nil, err
+	}
+	return source, value, nil
+}
+
+// writeRawEntry writes the entry as a standalone YAML document: the original
+// bytes for round-trip entries, otherwise the encoded value
+func writeRawEntry(c *gin.Context, entry *storage.Entry) {
+	if entry.Source != "" {
+		c.Data(200, "application/x-yaml; charset=utf-8", []byte(entry.Source))
+		return
+	}
+
+	raw, err := yaml.Marshal(entry.Value)
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.Data(200, "application/x-yaml; charset=utf-8", raw)
+}
+
+// withoutSource hides the source of round-trip entries in wrapped responses
+func withoutSource(entry *storage.Entry) *storage.Entry {
+	if entry.Source == "" {
+		return entry
+	}
+	copied := *entry
+	copied.Source = ""
+	return &copied
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzReadRoundTripBody reads arbitrary YAML bodies, which must be kept
+// byte for byte when they decode
+func FuzzReadRoundTripBody(f *testing.F) {
+	f.Add([]byte("# comment\nb: 1\na: &x [1, 2]\nc: *x\n"))
+	f.Add([]byte("--- |\n  literal\n"))
+	f.Add([]byte("a: [unclosed\n"))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		source, _, err := readRoundTripBody(bodyContext(body, "application/x-yaml"))
+		if err == nil && !bytes.Equal(source, body) {
+			t.Fatalf("source %q differs from body %q", source, body)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"plugin"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// LoadScoringPlugins opens the Go plugins of a comma-separated list of
+// paths. A plugin is a package main built with -buildmode=plugin whose init
+// functions call storage.RegisterScorer and storage.RegisterCombiner; it
+// must be built with the same Go version and dependencies as the server.
+func LoadScoringPlugins(paths string) error {
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if _, err := plugin.Open(path); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		log.Printf("Loaded scoring plugin %s", path)
+	}
+	return nil
+}
+
+func handleListScorers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"combiners": storage.Combiners(),
+			"scorers":   storage.Scorers(),
+		})
+	}
+}
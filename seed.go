@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/threatflux/searchyaml/client"
+	"github.com/threatflux/searchyaml/seed"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// seedDocument is a generated document and its key
+// SeedOutput is the result of the seed command with -output
+type SeedOutput struct {
+	Loaded   int64   `json:"loaded" yaml:"loaded"`
+	Duration float64 `json:"duration_ms" yaml:"duration_ms"`
+	Rate     float64 `json:"rate" yaml:"rate"` // Documents per second
+	Seed     int64   `json:"seed" yaml:"seed"`
+}
+
+func (s SeedOutput) table() ([]string, [][]string) {
+	return []string{"LOADED", "DURATION_MS", "RATE", "SEED"}, [][]string{{
+		strconv.FormatInt(s.Loaded, 10), strconv.FormatFloat(s.Duration, 'f', 0, 64),
+		strconv.FormatFloat(s.Rate, 'f', 0, 64), strconv.FormatInt(s.Seed, 10),
+	}}
+}
+
+type seedDocument struct {
+	key   string
+	value map[string]interface{}
+}
+
+// runSeed implements the seed command, which generates fake documents from a
+// schema and loads them into a data file or a running server
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s seed [flags]\n\nGenerate fake documents and load them into a data file or a running server.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	count := fs.Int("n", 1000, "Number of documents to generate")
+	schemaFile := fs.String("schema", "", "Document schema file (default: built-in threat report schema)")
+	embedding := fs.Int("embedding", 0, "Add an embedding field with this many random dimensions")
+	randSeed := fs.Int64("seed", 0, "Random seed for reproducible documents (default: time-based)")
+	start := fs.Int("start", 0, "Number of the first document, substituted for {n} in keys")
+	serverURL := fs.String("url", "", "Load into the server at this URL instead of the data file")
+	workers := fs.Int("c", 8, "Concurrent requests with -url")
+	dataFile := fs.String("data", "data.yaml", "Data file path")
+	maxSize := fs.Int64("maxsize", storage.DefaultOptions.MaxSize, "Maximum file size in bytes")
+	printDocs := fs.Bool("print", false, "Print the documents as YAML instead of loading them")
+	output := outputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	schema := &seed.DefaultSchema
+	if *schemaFile != "" {
+		var err error
+		if schema, err = seed.LoadSchema(*schemaFile); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return invalidInput(err)
+		}
+	} else if err := schema.Validate(); err != nil {
+		return invalidInput(err)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *embedding > 0 {
+		schema.Fields["embedding"] = seed.Field{Type: "vector", Dims: *embedding}
+	}
+	if *randSeed == 0 {
+		*randSeed = time.Now().UnixNano()
+	}
+
+	docs := make(chan seedDocument, 256)
+	go func() {
+		defer close(docs)
+		gen := seed.NewGenerator(schema, *randSeed)
+		for n := *start; n < *start+*count; n++ {
+			docs <- seedDocument{key: gen.Key(n), value: gen.Document()}
+		}
+	}()
+
+	began := time.Now()
+	var loaded int64
+	var err error
+	switch {
+	case *printDocs:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		for doc := range docs {
+			if err := enc.Encode(map[string]interface{}{doc.key: doc.value}); err != nil {
+				return err
+			}
+		}
+		return enc.Close()
+	case *serverURL != "":
+		loaded, err = seedServer(*serverURL, *workers, *count, docs)
+	default:
+		loaded, err = seedFile(*dataFile, *maxSize, *count, docs)
+	}
+
+	elapsed := time.Since(began)
+	result := SeedOutput{
+		Loaded:   loaded,
+		Duration: float64(elapsed.Microseconds()) / 1000,
+		Rate:     float64(loaded) / elapsed.Seconds(),
+		Seed:     *randSeed,
+	}
+	printErr := printResult(os.Stdout, *output, result, func() {
+		log.Printf("Loaded %d documents in %v (%.0f/s, seed %d)", loaded, elapsed.Round(time.Millisecond), result.Rate, *randSeed)
+	})
+	if err == nil {
+		err = printErr
+	}
+	return err
+}
+
+// seedBatchSize is the number of documents seedFile writes and indexes at
+// once
+const seedBatchSize = 1000
+
+// seedFile writes documents straight into a data file, which must not be
+// open in a running server
+func seedFile(path string, maxSize int64, count int, docs <-chan seedDocument) (int64, error) {
+	opts := storage.DefaultOptions
+	opts.MaxSize = maxSize
+	store, err := storage.NewStore(path, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	var loaded int64
+	batch := make([]storage.KeyValue, 0, seedBatchSize)
+	write := func() error {
+		n, err := store.SetMany(batch)
+		for i := 0; i < n; i++ {
+			loaded++
+			logSeedProgress(loaded, count)
+		}
+		batch = batch[:0]
+		return err
+	}
+	for doc := range docs {
+		batch = append(batch, storage.KeyValue{Key: doc.key, Value: doc.value})
+		if len(batch) == seedBatchSize {
+			if err = write(); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = write()
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to load documents: %w", err)
+	}
+	// Drain the generator after a failure
+	for range docs {
+	}
+
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	return loaded, err
+}
+
+// seedServer posts documents to a running server, stopping at the first
+// failed request
+func seedServer(url string, workers, count int, docs <-chan seedDocument) (int64, error) {
+	c := client.NewClient(url)
+
+	var loaded atomic.Int64
+	var failed atomic.Bool
+	var firstErr error
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for doc := range docs {
+				if failed.Load() {
+					continue
+				}
+				if err := c.Set(doc.key, doc.value, 0); err != nil {
+					once.Do(func() { firstErr = fmt.Errorf("failed to set %s: %w", doc.key, err) })
+					failed.Store(true)
+					continue
+				}
+				logSeedProgress(loaded.Add(1), count)
+			}
+		}()
+	}
+	wg.Wait()
+	return loaded.Load(), firstErr
+}
+
+// logSeedProgress logs every tenth of the way through large loads
+func logSeedProgress(loaded int64, count int) {
+	if count >= 10000 && loaded%int64(count/10) == 0 {
+		log.Printf("Loaded %d/%d documents", loaded, count)
+	}
+}
@@ -0,0 +1,219 @@
+package seed
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultWithin is how far back timestamps go by default
+const defaultWithin = 30 * 24 * time.Hour
+
+// Generator produces documents for a schema. A Generator is not safe for
+// concurrent use.
+type Generator struct {
+	schema *Schema
+	rand   *rand.Rand
+	now    time.Time
+}
+
+// NewGenerator returns a generator for a validated schema. Generators with
+// the same seed produce the same documents, apart from timestamps, which are
+// relative to the time the generator was created.
+func NewGenerator(schema *Schema, seed int64) *Generator {
+	return &Generator{
+		schema: schema,
+		rand:   rand.New(rand.NewSource(seed)),
+		now:    time.Now().UTC(),
+	}
+}
+
+// Key returns the key of document n
+func (g *Generator) Key(n int) string {
+	return strings.ReplaceAll(g.schema.Key, "{n}", strconv.Itoa(n))
+}
+
+// Document generates the next document
+func (g *Generator) Document() map[string]interface{} {
+	return g.object(g.schema.Fields)
+}
+
+func (g *Generator) object(fields map[string]Field) map[string]interface{} {
+	doc := make(map[string]interface{}, len(fields))
+	// Sorted so a seed always produces the same documents
+	for _, name := range sortedNames(fields) {
+		f := fields[name]
+		if f.Optional > 0 && g.rand.Float64() < f.Optional {
+			continue
+		}
+		doc[name] = g.value(&f)
+	}
+	return doc
+}
+
+func (g *Generator) value(f *Field) interface{} {
+	r := g.rand
+	switch f.Type {
+	case "name":
+		return g.pick(firstNames) + " " + g.pick(lastNames)
+	case "first_name":
+		return g.pick(firstNames)
+	case "last_name":
+		return g.pick(lastNames)
+	case "username":
+		return g.username()
+	case "email":
+		return g.username() + "@" + g.domain()
+	case "company":
+		return g.pick(lastNames) + " " + g.pick(companySuffixes)
+	case "city":
+		return g.pick(cities)
+	case "country":
+		return g.pick(countries)
+	case "word":
+		return g.pick(words)
+	case "sentence":
+		return g.sentence()
+	case "paragraph":
+		sentences := make([]string, 3+r.Intn(4))
+		for i := range sentences {
+			sentences[i] = g.sentence()
+		}
+		return strings.Join(sentences, " ")
+	case "domain":
+		return g.domain()
+	case "hostname":
+		return g.pick(hostPrefixes) + strconv.Itoa(1+r.Intn(20)) + "." + g.domain()
+	case "url":
+		return "https://" + g.domain() + "/" + g.pick(words) + "/" + g.pick(words)
+	case "ipv4":
+		return net.IPv4(byte(1+r.Intn(223)), byte(r.Intn(256)), byte(r.Intn(256)), byte(1+r.Intn(254))).String()
+	case "ipv6":
+		ip := make(net.IP, net.IPv6len)
+		r.Read(ip)
+		ip[0], ip[1] = 0x20, 0x01
+		return ip.String()
+	case "uuid":
+		b := g.bytes(16)
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	case "md5":
+		sum := md5.Sum(g.bytes(32))
+		return hex.EncodeToString(sum[:])
+	case "sha1":
+		sum := sha1.Sum(g.bytes(32))
+		return hex.EncodeToString(sum[:])
+	case "sha256":
+		sum := sha256.Sum256(g.bytes(32))
+		return hex.EncodeToString(sum[:])
+	case "int":
+		max := f.Max
+		if max == 0 && f.Min == 0 {
+			max = 1000
+		}
+		return int64(f.Min) + r.Int63n(int64(max-f.Min)+1)
+	case "float":
+		max := f.Max
+		if max == 0 && f.Min == 0 {
+			max = 1
+		}
+		// Two decimal places read better than full precision
+		return math.Round((f.Min+r.Float64()*(max-f.Min))*100) / 100
+	case "bool":
+		return r.Intn(2) == 1
+	case "timestamp":
+		within := f.Within
+		if within <= 0 {
+			within = defaultWithin
+		}
+		return g.now.Add(-time.Duration(r.Int63n(int64(within)))).Truncate(time.Second).Format(time.RFC3339)
+	case "enum":
+		return g.pick(f.Values)
+	case "tags":
+		n := g.length(f, 1, 3)
+		if n > len(f.Values) {
+			n = len(f.Values)
+		}
+		tags := make([]string, n)
+		for i, j := range r.Perm(len(f.Values))[:n] {
+			tags[i] = f.Values[j]
+		}
+		return tags
+	case "vector":
+		return g.vector(f.Dims)
+	case "object":
+		return g.object(f.Fields)
+	case "list":
+		items := make([]interface{}, g.length(f, 1, 5))
+		for i := range items {
+			items[i] = g.value(f.Items)
+		}
+		return items
+	}
+	return nil
+}
+
+// length returns a random list length between the field's min and max, or
+// the given defaults when neither is set
+func (g *Generator) length(f *Field, min, max int) int {
+	if f.Min != 0 || f.Max != 0 {
+		min, max = int(f.Min), int(f.Max)
+	}
+	return min + g.rand.Intn(max-min+1)
+}
+
+func (g *Generator) pick(values []string) string {
+	return values[g.rand.Intn(len(values))]
+}
+
+func (g *Generator) bytes(n int) []byte {
+	b := make([]byte, n)
+	g.rand.Read(b)
+	return b
+}
+
+func (g *Generator) username() string {
+	return strings.ToLower(g.pick(firstNames)) + "." + strings.ToLower(g.pick(lastNames))
+}
+
+func (g *Generator) domain() string {
+	return g.pick(words) + "-" + g.pick(words) + "." + g.pick(tlds)
+}
+
+func (g *Generator) sentence() string {
+	n := 6 + g.rand.Intn(9)
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = g.pick(words)
+	}
+	parts[0] = strings.ToUpper(parts[0][:1]) + parts[0][1:]
+	return strings.Join(parts, " ") + "."
+}
+
+// vector returns a random unit vector, the form embeddings usually take
+func (g *Generator) vector(dims int) []float32 {
+	v := make([]float32, dims)
+	var norm float64
+	for i := range v {
+		x := g.rand.NormFloat64()
+		v[i] = float32(x)
+		norm += x * x
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return v
+	}
+	for i := range v {
+		v[i] = float32(float64(v[i]) / norm)
+	}
+	return v
+}
@@ -0,0 +1,169 @@
+// Package seed generates realistic fake documents for demos and capacity
+// testing
+package seed
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema describes the generated documents
+type Schema struct {
+	// Key is the key template; {n} is replaced by the document number
+	Key    string           `yaml:"key"`
+	Fields map[string]Field `yaml:"fields"`
+}
+
+// Field describes how one field is generated. In a schema file a field is
+// either a type name or a mapping with a type and its options; a mapping
+// with fields and no type is a nested object.
+type Field struct {
+	Type   string        `yaml:"type"`
+	Values []string      `yaml:"values"` // enum and tags
+	Min    float64       `yaml:"min"`    // int, float, and tags or list lengths
+	Max    float64       `yaml:"max"`
+	Dims   int           `yaml:"dims"`   // vector
+	Within time.Duration `yaml:"within"` // timestamp: how far back from now, default 30 days
+	// Optional is the probability that the field is left out
+	Optional float64          `yaml:"optional"`
+	Fields   map[string]Field `yaml:"fields"` // object
+	Items    *Field           `yaml:"items"`  // list
+}
+
+// DefaultKey is the key template used when the schema has none
+const DefaultKey = "seed-{n}"
+
+// DefaultSchema generates threat intelligence style reports
+var DefaultSchema = Schema{
+	Key: DefaultKey,
+	Fields: map[string]Field{
+		"title":       {Type: "sentence"},
+		"description": {Type: "paragraph"},
+		"author":      {Type: "name"},
+		"email":       {Type: "email"},
+		"company":     {Type: "company"},
+		"severity":    {Type: "enum", Values: []string{"low", "medium", "high", "critical"}},
+		"score":       {Type: "float", Min: 0, Max: 10},
+		"tags":        {Type: "tags", Values: []string{"malware", "phishing", "ransomware", "apt", "botnet", "c2", "exploit", "credential-theft"}, Min: 1, Max: 3},
+		"created":     {Type: "timestamp"},
+		"active":      {Type: "bool"},
+		"indicators": {Fields: map[string]Field{
+			"ip":     {Type: "ipv4"},
+			"domain": {Type: "domain"},
+			"sha256": {Type: "sha256"},
+		}},
+	},
+}
+
+// fieldTypes are the supported field types
+var fieldTypes = map[string]bool{
+	"name": true, "first_name": true, "last_name": true, "email": true, "username": true,
+	"company": true, "city": true, "country": true,
+	"word": true, "sentence": true, "paragraph": true,
+	"domain": true, "hostname": true, "url": true, "ipv4": true, "ipv6": true,
+	"uuid": true, "md5": true, "sha1": true, "sha256": true,
+	"int": true, "float": true, "bool": true, "timestamp": true,
+	"enum": true, "tags": true, "vector": true, "object": true, "list": true,
+}
+
+// UnmarshalYAML accepts a bare type name as well as a mapping
+func (f *Field) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.Type = node.Value
+		return nil
+	}
+	type plain Field
+	return node.Decode((*plain)(f))
+}
+
+// LoadSchema reads and validates a schema file
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schema Schema
+	if err := yaml.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// Validate checks field types and their options
+func (s *Schema) Validate() error {
+	if s.Key == "" {
+		s.Key = DefaultKey
+	}
+	if !strings.Contains(s.Key, "{n}") {
+		return fmt.Errorf("invalid schema: key template %q has no {n}", s.Key)
+	}
+	if len(s.Fields) == 0 {
+		return fmt.Errorf("invalid schema: no fields")
+	}
+	return validateFields("", s.Fields)
+}
+
+func validateFields(prefix string, fields map[string]Field) error {
+	for _, name := range sortedNames(fields) {
+		f := fields[name]
+		if err := f.validate(prefix + name); err != nil {
+			return err
+		}
+		fields[name] = f
+	}
+	return nil
+}
+
+func (f *Field) validate(path string) error {
+	if f.Type == "" && f.Fields != nil {
+		f.Type = "object"
+	}
+	if !fieldTypes[f.Type] {
+		return fmt.Errorf("invalid schema: field %s has unknown type %q", path, f.Type)
+	}
+	if f.Optional < 0 || f.Optional > 1 {
+		return fmt.Errorf("invalid schema: field %s optional must be between 0 and 1", path)
+	}
+	if f.Max < f.Min {
+		return fmt.Errorf("invalid schema: field %s max is less than min", path)
+	}
+
+	switch f.Type {
+	case "enum", "tags":
+		if len(f.Values) == 0 {
+			return fmt.Errorf("invalid schema: field %s needs values", path)
+		}
+	case "vector":
+		if f.Dims <= 0 {
+			return fmt.Errorf("invalid schema: field %s needs positive dims", path)
+		}
+	case "object":
+		if len(f.Fields) == 0 {
+			return fmt.Errorf("invalid schema: field %s needs fields", path)
+		}
+		return validateFields(path+".", f.Fields)
+	case "list":
+		if f.Items == nil {
+			return fmt.Errorf("invalid schema: field %s needs items", path)
+		}
+		return f.Items.validate(path + "[]")
+	}
+	return nil
+}
+
+func sortedNames(fields map[string]Field) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,46 @@
+package seed
+
+var firstNames = []string{
+	"Ada", "Alan", "Alice", "Amara", "Andre", "Anna", "Ben", "Carlos", "Chen", "Chloe",
+	"Daniel", "Diego", "Elena", "Emma", "Farah", "Grace", "Hana", "Ivan", "James", "Jin",
+	"Julia", "Kai", "Kenji", "Lars", "Leila", "Lucas", "Maria", "Mateo", "Mei", "Nadia",
+	"Noah", "Olga", "Omar", "Priya", "Rafael", "Ravi", "Sara", "Sofia", "Tomas", "Yara",
+}
+
+var lastNames = []string{
+	"Adams", "Ahmed", "Becker", "Brown", "Costa", "Dubois", "Evans", "Fischer", "Garcia", "Gupta",
+	"Hansen", "Ito", "Jensen", "Kim", "Kowalski", "Lee", "Lopez", "Martin", "Meyer", "Moreau",
+	"Nakamura", "Nguyen", "Novak", "Okafor", "Park", "Patel", "Petrov", "Rossi", "Santos", "Schmidt",
+	"Silva", "Singh", "Smith", "Tanaka", "Taylor", "Walker", "Wang", "Weber", "Wilson", "Zhang",
+}
+
+var companySuffixes = []string{"Inc", "LLC", "Labs", "Systems", "Security", "Group", "Technologies", "Networks"}
+
+var cities = []string{
+	"Amsterdam", "Austin", "Berlin", "Boston", "Buenos Aires", "Cairo", "Chicago", "Dublin", "Lagos", "Lisbon",
+	"London", "Madrid", "Melbourne", "Mumbai", "Nairobi", "Oslo", "Paris", "Seoul", "Singapore", "Stockholm",
+	"Sydney", "Tokyo", "Toronto", "Vienna", "Warsaw", "Zurich",
+}
+
+var countries = []string{
+	"Argentina", "Australia", "Brazil", "Canada", "Egypt", "France", "Germany", "India", "Ireland", "Japan",
+	"Kenya", "Netherlands", "Nigeria", "Norway", "Poland", "Portugal", "Singapore", "South Korea", "Spain",
+	"Sweden", "Switzerland", "United Kingdom", "United States",
+}
+
+var tlds = []string{"com", "net", "org", "io", "dev", "info", "biz", "co", "xyz", "cloud"}
+
+var hostPrefixes = []string{"web", "api", "mail", "db", "cache", "edge", "vpn", "build", "ci", "proxy"}
+
+var words = []string{
+	"access", "account", "actor", "agent", "alert", "analysis", "anomaly", "archive", "asset", "attack",
+	"audit", "backup", "beacon", "binary", "breach", "bridge", "buffer", "campaign", "certificate", "channel",
+	"cipher", "client", "cloud", "cluster", "command", "config", "control", "credential", "data", "database",
+	"defense", "delivery", "deploy", "detect", "device", "domain", "dropper", "endpoint", "event", "exploit",
+	"exposure", "firewall", "gateway", "hash", "host", "identity", "incident", "indicator", "infra", "kernel",
+	"key", "loader", "log", "malware", "memory", "monitor", "network", "node", "observed", "operator",
+	"packet", "patch", "payload", "persistence", "phishing", "pipeline", "policy", "port", "process", "protocol",
+	"proxy", "query", "registry", "remote", "report", "request", "response", "risk", "router", "sample",
+	"scan", "script", "secure", "sensor", "server", "service", "session", "signal", "signature", "storage",
+	"stream", "system", "target", "threat", "token", "traffic", "tunnel", "update", "vector", "vulnerability",
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/sqlquery"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// SQLRequest is the body of POST /sql
+type SQLRequest struct {
+	Query string `json:"query" binding:"required"` // A SELECT statement
+}
+
+// SQLResponse is the result of POST /sql
+type SQLResponse struct {
+	Columns []string        `json:"columns" yaml:"columns"`
+	Rows    [][]interface{} `json:"rows" yaml:"rows"`
+	Plan    *sqlquery.Plan  `json:"plan,omitempty" yaml:"plan,omitempty"` // With ?explain=true
+}
+
+// handleSQL answers a read-only SELECT statement over the readable, redacted
+// entries of the tenant. Like searches, the result is exported as CSV or
+// Parquet on request. Statements are local; they are not federated.
+func handleSQL(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		var request SQLRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+		stmt, err := sqlquery.Parse(request.Query)
+		if err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		results, plan, err := stmt.Run(store, readableKeys(c))
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		// Redact before sorting, so the order does not reveal secrets
+		table := stmt.Table(redactResults(c, results))
+
+		if format := exportFormat(c); format != "" {
+			respondTable(c, format, table.Columns, table.Rows)
+			return
+		}
+		response := SQLResponse{Columns: table.Columns, Rows: table.Rows}
+		if explain, _ := strconv.ParseBool(c.Query("explain")); explain {
+			response.Plan = plan
+		}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, response)
+		} else {
+			c.JSON(200, response)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package sqlquery
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/threatflux/searchyaml/expr"
+)
+
+// path is a dotted path naming the key or score of a row, its whole value,
+// or a field of the value, optionally prefixed with value.
+type path struct {
+	parts []string
+}
+
+func (p path) String() string {
+	parts := make([]string, len(p.parts))
+	for i, part := range p.parts {
+		parts[i] = quoteName(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// field returns the path within the value, or ok false for key and score
+func (p path) field() ([]string, bool) {
+	switch {
+	case len(p.parts) == 1 && (p.parts[0] == "key" || p.parts[0] == "score"):
+		return nil, false
+	case p.parts[0] == "value":
+		return p.parts[1:], true
+	}
+	return p.parts, true
+}
+
+// indexField returns the top-level field a path names, which indexes can
+// answer conditions on
+func (p path) indexField() (string, bool) {
+	field, ok := p.field()
+	if !ok || len(field) != 1 {
+		return "", false
+	}
+	return field[0], true
+}
+
+func (p path) isKey() bool {
+	return len(p.parts) == 1 && p.parts[0] == "key"
+}
+
+// row is a candidate of a query
+type row struct {
+	key   string
+	value interface{}
+	score float64
+}
+
+// get resolves a path in the row. Missing fields are nil.
+func (r row) get(p path) interface{} {
+	field, ok := p.field()
+	if !ok {
+		if p.parts[0] == "key" {
+			return r.key
+		}
+		return r.score
+	}
+	current := r.value
+	for _, part := range field {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+// condition is a WHERE condition. Conditions on a missing field or null are
+// false, and so are the NOT of them: fail reports whether the condition is
+// false for a known value, which is when its NOT matches.
+type condition interface {
+	match(r row) bool
+	fail(r row) bool
+	String() string
+}
+
+type andCond struct{ left, right condition }
+
+func (c andCond) match(r row) bool { return c.left.match(r) && c.right.match(r) }
+func (c andCond) fail(r row) bool  { return c.left.fail(r) || c.right.fail(r) }
+func (c andCond) String() string   { return c.left.String() + " AND " + c.right.String() }
+
+type orCond struct{ left, right condition }
+
+func (c orCond) match(r row) bool { return c.left.match(r) || c.right.match(r) }
+func (c orCond) fail(r row) bool  { return c.left.fail(r) && c.right.fail(r) }
+func (c orCond) String() string   { return "(" + c.left.String() + " OR " + c.right.String() + ")" }
+
+type notCond struct{ operand condition }
+
+func (c notCond) match(r row) bool { return c.operand.fail(r) }
+func (c notCond) fail(r row) bool  { return c.operand.match(r) }
+func (c notCond) String() string   { return "NOT (" + c.operand.String() + ")" }
+
+// compareCond compares a field with a value. A list matches if any of its
+// elements does, except for != which needs all of them to differ.
+type compareCond struct {
+	path  path
+	op    string
+	value interface{}
+}
+
+func (c compareCond) match(r row) bool {
+	got := r.get(c.path)
+	if got == nil || c.value == nil {
+		return false
+	}
+	if c.op == "!=" {
+		return !anyElement(got, func(v interface{}) bool { return equal(v, c.value) })
+	}
+	return anyElement(got, func(v interface{}) bool {
+		n, ok := compare(v, c.value)
+		if !ok {
+			return false
+		}
+		switch c.op {
+		case "=":
+			return n == 0
+		case "<":
+			return n < 0
+		case "<=":
+			return n <= 0
+		case ">":
+			return n > 0
+		default:
+			return n >= 0
+		}
+	})
+}
+
+func (c compareCond) fail(r row) bool {
+	return r.get(c.path) != nil && c.value != nil && !c.match(r)
+}
+
+func (c compareCond) String() string {
+	return c.path.String() + " " + c.op + " " + formatLiteral(c.value)
+}
+
+type inCond struct {
+	path   path
+	values []interface{}
+}
+
+func (c inCond) match(r row) bool {
+	got := r.get(c.path)
+	if got == nil {
+		return false
+	}
+	return anyElement(got, func(v interface{}) bool {
+		for _, want := range c.values {
+			if equal(v, want) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (c inCond) fail(r row) bool { return r.get(c.path) != nil && !c.match(r) }
+
+func (c inCond) String() string {
+	values := make([]string, len(c.values))
+	for i, v := range c.values {
+		values[i] = formatLiteral(v)
+	}
+	return c.path.String() + " IN (" + strings.Join(values, ", ") + ")"
+}
+
+// likeCond matches strings against a pattern where % stands for any text
+// and _ for any character
+type likeCond struct {
+	path    path
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newLikeCond(p path, pattern string) likeCond {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return likeCond{path: p, pattern: pattern, re: regexp.MustCompile(b.String())}
+}
+
+// prefix returns the text before a pattern's only wildcard, a trailing %
+func (c likeCond) prefix() (string, bool) {
+	prefix, ok := strings.CutSuffix(c.pattern, "%")
+	if !ok || strings.ContainsAny(prefix, "%_") {
+		return "", false
+	}
+	return prefix, true
+}
+
+func (c likeCond) match(r row) bool {
+	return anyElement(r.get(c.path), func(v interface{}) bool {
+		s, ok := v.(string)
+		return ok && c.re.MatchString(s)
+	})
+}
+
+func (c likeCond) fail(r row) bool { return r.get(c.path) != nil && !c.match(r) }
+
+func (c likeCond) String() string {
+	return c.path.String() + " LIKE " + formatLiteral(c.pattern)
+}
+
+type nullCond struct {
+	path   path
+	negate bool
+}
+
+func (c nullCond) match(r row) bool { return (r.get(c.path) == nil) != c.negate }
+func (c nullCond) fail(r row) bool  { return !c.match(r) }
+
+func (c nullCond) String() string {
+	if c.negate {
+		return c.path.String() + " IS NOT NULL"
+	}
+	return c.path.String() + " IS NULL"
+}
+
+// matchCond is a text search, which only the text indexes answer
+type matchCond struct {
+	field *path
+	text  string
+}
+
+func (c matchCond) match(row) bool { return true }
+func (c matchCond) fail(row) bool  { return false }
+
+func (c matchCond) String() string {
+	if c.field != nil {
+		return "MATCH(" + c.field.String() + ", " + formatLiteral(c.text) + ")"
+	}
+	return "MATCH(" + formatLiteral(c.text) + ")"
+}
+
+// anyElement applies fn to the elements of a list, or to a single value
+func anyElement(v interface{}, fn func(interface{}) bool) bool {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if fn(item) {
+				return true
+			}
+		}
+		return false
+	}
+	return v != nil && fn(v)
+}
+
+// number converts numeric values, but not numeric strings, to float64
+func number(v interface{}) (float64, bool) {
+	switch v.(type) {
+	case string, bool:
+		return 0, false
+	}
+	return expr.ToNumber(v)
+}
+
+// compare orders two values of the same kind: numbers by value, strings
+// lexically and false before true. Values of different kinds compare false.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		switch {
+		case !ok || x == y:
+			return 0, ok
+		case y:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	if n, ok := compare(a, b); ok {
+		return n == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func formatLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// quoteName quotes a name that is not a plain identifier
+func quoteName(name string) string {
+	plain := name != "" && !reserved[strings.ToUpper(name)] && !(name[0] >= '0' && name[0] <= '9')
+	for i := 0; i < len(name) && plain; i++ {
+		plain = isWordChar(name[i])
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
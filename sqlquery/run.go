@@ -0,0 +1,384 @@
+package sqlquery
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// How a statement finds its candidates, see Plan.Lookup
+const (
+	LookupSearch = "search" // A search with the conditions the indexes answer
+	LookupKeys   = "keys"   // The keys of key = 'k', key IN (...) or key LIKE 'prefix%'
+	LookupScan   = "scan"   // Every entry of the store
+)
+
+// Plan describes how a statement is answered
+type Plan struct {
+	Lookup    string               `json:"lookup" yaml:"lookup"`
+	Search    *storage.SearchQuery `json:"search,omitempty" yaml:"search,omitempty"`
+	Keys      []string             `json:"keys,omitempty" yaml:"keys,omitempty"`
+	KeyPrefix string               `json:"key_prefix,omitempty" yaml:"key_prefix,omitempty"`
+	Residual  string               `json:"residual,omitempty" yaml:"residual,omitempty"` // Conditions checked on each candidate
+}
+
+// Table is the result of a statement
+type Table struct {
+	Columns []string        `json:"columns" yaml:"columns"`
+	Rows    [][]interface{} `json:"rows" yaml:"rows"`
+}
+
+// conjuncts splits a condition into the conditions ANDed together in it
+func conjuncts(c condition) []condition {
+	switch c := c.(type) {
+	case nil:
+		return nil
+	case andCond:
+		return append(conjuncts(c.left), conjuncts(c.right)...)
+	}
+	return []condition{c}
+}
+
+// containsMatch reports whether MATCH appears in a condition
+func containsMatch(c condition) bool {
+	switch c := c.(type) {
+	case matchCond:
+		return true
+	case andCond:
+		return containsMatch(c.left) || containsMatch(c.right)
+	case orCond:
+		return containsMatch(c.left) || containsMatch(c.right)
+	case notCond:
+		return containsMatch(c.operand)
+	}
+	return false
+}
+
+// plan translates the WHERE conditions into index lookups where the
+// indexes of store can answer them, returning the others to be checked on
+// each candidate. Conditions answered by an index follow its matching
+// rules, such as case folding of keyword indexes.
+func (s *Statement) plan(store *storage.Store) (*Plan, []condition, error) {
+	query := storage.SearchQuery{Filters: make(map[string]interface{}), Prefixes: make(map[string]string)}
+	var residual []condition
+	var keys []string
+	var keysCond, prefixCond condition // The key conditions looked up
+	keyPrefix := ""
+
+	for _, c := range conjuncts(s.where) {
+		switch c := c.(type) {
+		case matchCond:
+			if query.Text != "" {
+				return nil, nil, fmt.Errorf("%w: only one MATCH is supported", storage.ErrInvalidQuery)
+			}
+			if c.field != nil {
+				field, ok := c.field.indexField()
+				if !ok || !store.HasIndex(field, "text") {
+					return nil, nil, fmt.Errorf("%w: MATCH on %s needs a text index", storage.ErrInvalidQuery, c.field)
+				}
+				query.TextFields = []string{field}
+			}
+			query.Text = c.text
+			continue
+
+		case compareCond:
+			if key, ok := c.value.(string); ok && c.path.isKey() && c.op == "=" && keysCond == nil {
+				keys, keysCond = []string{key}, c
+				continue
+			}
+			if translateCompare(store, &query, c) {
+				continue
+			}
+
+		case inCond:
+			if c.path.isKey() && keysCond == nil {
+				if strs, ok := stringValues(c.values); ok {
+					keys, keysCond = strs, c
+					continue
+				}
+			}
+			field, ok := c.path.indexField()
+			if _, exists := query.Filters[field]; ok && !exists && store.HasIndex(field, "keyword") && scalarValues(c.values) {
+				query.Filters[field] = c.values
+				continue
+			}
+
+		case likeCond:
+			prefix, ok := c.prefix()
+			if !ok {
+				break
+			}
+			if c.path.isKey() && prefixCond == nil {
+				keyPrefix, prefixCond = prefix, c
+				continue
+			}
+			field, ok := c.path.indexField()
+			if _, exists := query.Prefixes[field]; ok && !exists && store.HasIndex(field, "keyword") {
+				query.Prefixes[field] = prefix
+				continue
+			}
+		}
+
+		if containsMatch(c) {
+			return nil, nil, fmt.Errorf("%w: MATCH can only be combined with AND", storage.ErrInvalidQuery)
+		}
+		residual = append(residual, c)
+	}
+
+	plan := &Plan{}
+	switch {
+	case query.Text != "" || len(query.Filters) > 0 || len(query.Prefixes) > 0:
+		// The key conditions are checked on the matches instead
+		for _, c := range []condition{keysCond, prefixCond} {
+			if c != nil {
+				residual = append(residual, c)
+			}
+		}
+		if len(query.Filters) == 0 {
+			query.Filters = nil
+		}
+		if len(query.Prefixes) == 0 {
+			query.Prefixes = nil
+		}
+		plan.Lookup, plan.Search = LookupSearch, &query
+	case keysCond != nil:
+		plan.Lookup, plan.Keys = LookupKeys, keys
+		if prefixCond != nil {
+			residual = append(residual, prefixCond)
+		}
+	case prefixCond != nil:
+		plan.Lookup, plan.KeyPrefix = LookupKeys, keyPrefix
+	default:
+		plan.Lookup = LookupScan
+	}
+	if len(residual) > 0 {
+		conds := residual[0]
+		for _, c := range residual[1:] {
+			conds = andCond{conds, c}
+		}
+		plan.Residual = conds.String()
+	}
+	return plan, residual, nil
+}
+
+// translateCompare adds a comparison to the filters of query if an index
+// on its field answers it: equality on a keyword or numeric index, and
+// ranges on a numeric index. A field with both uses the keyword index for
+// filters, so its ranges are left to be checked on each candidate.
+func translateCompare(store *storage.Store, query *storage.SearchQuery, c compareCond) bool {
+	field, ok := c.path.indexField()
+	if !ok || c.value == nil || c.op == "!=" {
+		return false
+	}
+	existing, exists := query.Filters[field]
+	n, isNumber := c.value.(float64)
+	keyword := store.HasIndex(field, "keyword")
+
+	if c.op == "=" {
+		if exists || !keyword && !(isNumber && store.HasIndex(field, "numeric")) {
+			return false
+		}
+		query.Filters[field] = c.value
+		return true
+	}
+
+	if !isNumber || keyword || !store.HasIndex(field, "numeric") {
+		return false
+	}
+	r, _ := existing.(map[string]interface{})
+	if exists && r == nil {
+		return false
+	}
+	if r == nil {
+		r = make(map[string]interface{})
+	}
+	op := map[string]string{"<": "lt", "<=": "lte", ">": "gt", ">=": "gte"}[c.op]
+	if _, exists := r[op]; exists {
+		return false
+	}
+	r[op] = n
+	query.Filters[field] = r
+	return true
+}
+
+func stringValues(values []interface{}) ([]string, bool) {
+	out := make([]string, len(values))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+		out[i] = s
+	}
+	return out, true
+}
+
+func scalarValues(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Run finds the entries matching the statement, keeping those for which
+// readable returns true; a nil readable keeps every key. The results are
+// ordered by score when the indexes were searched and by key otherwise,
+// and only limited here when Table has nothing left to sort or filter.
+func (s *Statement) Run(store *storage.Store, readable func(key string) bool) ([]storage.SearchResult, *Plan, error) {
+	plan, residual, err := s.plan(store)
+	if err != nil {
+		return nil, nil, err
+	}
+	keep := func(r row) bool {
+		if readable != nil && !readable(r.key) {
+			return false
+		}
+		for _, c := range residual {
+			if !c.match(r) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var results []storage.SearchResult
+	switch plan.Lookup {
+	case LookupSearch:
+		query := *plan.Search
+		if len(residual) == 0 && len(s.OrderBy) == 0 && s.Limit > 0 {
+			query.MaxResults = s.Limit + s.Offset
+		}
+		found, err := store.SearchReadable(query, readable)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, result := range found {
+			if keep(row{key: result.Key, value: result.Value, score: result.Combined}) {
+				results = append(results, result)
+			}
+		}
+		return results, plan, nil
+
+	case LookupKeys:
+		keys := plan.Keys
+		if keys == nil {
+			keys, _ = store.Keys(plan.KeyPrefix, "", 0)
+		}
+		for key, entry := range store.Entries(keys) {
+			if keep(row{key: key, value: entry.Value}) {
+				results = append(results, storage.SearchResult{Key: key, Value: entry.Value})
+			}
+		}
+
+	default:
+		store.Range(func(key string, entry *storage.Entry) bool {
+			if keep(row{key: key, value: entry.Value}) {
+				results = append(results, storage.SearchResult{Key: key, Value: entry.Value})
+			}
+			return true
+		})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	return results, plan, nil
+}
+
+// Table sorts the results by ORDER BY, applies OFFSET and LIMIT and selects
+// the columns. SELECT * returns the key and the top-level fields of the
+// values, sorted.
+func (s *Statement) Table(results []storage.SearchResult) Table {
+	rows := make([]row, len(results))
+	for i, result := range results {
+		rows[i] = row{key: result.Key, value: result.Value, score: result.Combined}
+	}
+	if len(s.OrderBy) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			for _, order := range s.OrderBy {
+				n := compareOrder(rows[i].get(order.Path), rows[j].get(order.Path))
+				if n != 0 {
+					return n < 0 != order.Desc
+				}
+			}
+			return false
+		})
+	}
+	rows = rows[min(s.Offset, len(rows)):]
+	if s.Limit >= 0 && len(rows) > s.Limit {
+		rows = rows[:s.Limit]
+	}
+
+	columns := s.Columns
+	if len(columns) == 0 {
+		columns = starColumns(rows)
+	}
+	table := Table{Columns: make([]string, len(columns)), Rows: make([][]interface{}, len(rows))}
+	for i, column := range columns {
+		table.Columns[i] = column.Name
+	}
+	for i, r := range rows {
+		cells := make([]interface{}, len(columns))
+		for j, column := range columns {
+			cells[j] = r.get(column.Path)
+		}
+		table.Rows[i] = cells
+	}
+	return table
+}
+
+// starColumns returns the key and the sorted top-level fields of the rows.
+// Fields named key or score are reached through value.
+func starColumns(rows []row) []Column {
+	fields := make(map[string]struct{})
+	for _, r := range rows {
+		if m, ok := r.value.(map[string]interface{}); ok {
+			for name := range m {
+				fields[name] = struct{}{}
+			}
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	columns := []Column{{Path: path{parts: []string{"key"}}, Name: "key"}}
+	for _, name := range names {
+		p := path{parts: []string{name}}
+		if name == "key" || name == "score" || name == "value" {
+			p.parts = []string{"value", name}
+		}
+		columns = append(columns, Column{Path: p, Name: p.String()})
+	}
+	return columns
+}
+
+// compareOrder orders values for ORDER BY: nulls first, then booleans,
+// numbers and strings, each compared by value, then other values unordered
+func compareOrder(a, b interface{}) int {
+	ra, rb := orderRank(a), orderRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	n, _ := compare(a, b)
+	return n
+}
+
+func orderRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return 3
+	case map[string]interface{}, []interface{}:
+		return 4
+	}
+	if _, ok := number(v); ok {
+		return 2
+	}
+	return 4
+}
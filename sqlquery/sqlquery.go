@@ -0,0 +1,562 @@
+// Package sqlquery implements a minimal, read-only SQL dialect over a store:
+//
+//	SELECT key, value.title, severity AS sev FROM store
+//	WHERE severity = 'critical' AND score >= 7 AND MATCH('ransomware')
+//	ORDER BY score DESC LIMIT 10 OFFSET 20
+//
+// Conditions the indexes can answer become a storage.SearchQuery: equality
+// and IN on keyword and numeric indexes, ranges and BETWEEN on numeric
+// indexes, LIKE 'prefix%' on keyword indexes and MATCH on text indexes.
+// Conditions on key look the keys up directly. Every other condition is
+// checked on the candidates, which are the whole store when no condition
+// could be looked up.
+package sqlquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Statement is a parsed SELECT statement
+type Statement struct {
+	Columns []Column // Empty for SELECT *
+	From    string   // The table name, which is not checked
+	OrderBy []Order
+	Limit   int // -1 without LIMIT
+	Offset  int
+
+	where condition
+}
+
+// Column is a selected path and the name it is returned under
+type Column struct {
+	Path path
+	Name string
+}
+
+// Order is a sort key of ORDER BY
+type Order struct {
+	Path path
+	Desc bool
+}
+
+// Parse parses a SELECT statement
+func Parse(source string) (*Statement, error) {
+	if len(source) > maxLength {
+		return nil, fmt.Errorf("sql: statement longer than %d bytes", maxLength)
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.statement()
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuoted // "identifier" or `identifier`
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && i+1 < len(src) && src[i+1] == '-':
+			// Comment to the end of the line
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == 'e' || src[i] == 'E' ||
+				(src[i] == '-' || src[i] == '+') && (src[i-1] == 'e' || src[i-1] == 'E')) {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i]})
+		case c == '\'' || c == '"' || c == '`':
+			// Quotes are escaped by doubling them
+			quote := c
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("sql: unterminated %c", quote)
+				}
+				if src[i] == quote {
+					if i+1 < len(src) && src[i+1] == quote {
+						b.WriteByte(quote)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			kind := tokQuoted
+			if quote == '\'' {
+				kind = tokString
+			}
+			tokens = append(tokens, token{kind, b.String()})
+		case c == '_' || c == '@' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && isWordChar(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i]})
+		default:
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "!=", "<>", "<=", ">=":
+					tokens = append(tokens, token{tokOp, two})
+					i += 2
+					continue
+				}
+			}
+			if strings.IndexByte("=<>(),.*;-+", c) < 0 {
+				return nil, fmt.Errorf("sql: unexpected character %q", c)
+			}
+			tokens = append(tokens, token{tokOp, string(c)})
+			i++
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '@' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Bounds of parsed statements, so untrusted input cannot exhaust the stack:
+// conditions chained with AND and OR nest as deeply as the statement is long
+const (
+	maxLength = 64 << 10 // Bytes of a statement
+	maxDepth  = 64       // Nesting of parentheses and NOT
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword consumes the next token if it is one of the keywords, in any case
+func (p *parser) keyword(words ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return "", false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			p.next()
+			return w, true
+		}
+	}
+	return "", false
+}
+
+// op consumes the next token if it is one of the operators
+func (p *parser) op(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(word string) error {
+	if _, ok := p.keyword(word); !ok {
+		return p.unexpected(word)
+	}
+	return nil
+}
+
+func (p *parser) expectOp(op string) error {
+	if _, ok := p.op(op); !ok {
+		return p.unexpected(strconv.Quote(op))
+	}
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return fmt.Errorf("sql: expected %s at end of query", want)
+	}
+	return fmt.Errorf("sql: expected %s, got %q", want, t.text)
+}
+
+// reserved are the keywords that cannot be used as unquoted names
+var reserved = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true, "NOT": true,
+	"IN": true, "LIKE": true, "IS": true, "NULL": true, "BETWEEN": true, "ORDER": true,
+	"BY": true, "ASC": true, "DESC": true, "LIMIT": true, "OFFSET": true, "AS": true,
+	"TRUE": true, "FALSE": true, "MATCH": true,
+}
+
+// name parses an unquoted name that is not a keyword, or a quoted one
+func (p *parser) name(what string) (string, error) {
+	t := p.peek()
+	if t.kind == tokQuoted || t.kind == tokIdent && !reserved[strings.ToUpper(t.text)] {
+		p.next()
+		return t.text, nil
+	}
+	return "", p.unexpected(what)
+}
+
+func (p *parser) statement() (*Statement, error) {
+	stmt := &Statement{Limit: -1}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	if _, ok := p.op("*"); !ok {
+		for {
+			column, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, column)
+			if _, ok := p.op(","); !ok {
+				break
+			}
+		}
+	}
+
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.name("a table name")
+	if err != nil {
+		return nil, err
+	}
+	stmt.From = table
+
+	if _, ok := p.keyword("WHERE"); ok {
+		if stmt.where, err = p.or(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := p.keyword("ORDER"); ok {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			path, err := p.path()
+			if err != nil {
+				return nil, err
+			}
+			dir, _ := p.keyword("ASC", "DESC")
+			stmt.OrderBy = append(stmt.OrderBy, Order{Path: stmt.resolve(path), Desc: dir == "DESC"})
+			if _, ok := p.op(","); !ok {
+				break
+			}
+		}
+	}
+
+	if _, ok := p.keyword("LIMIT"); ok {
+		if stmt.Limit, err = p.count("LIMIT"); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := p.keyword("OFFSET"); ok {
+		if stmt.Offset, err = p.count("OFFSET"); err != nil {
+			return nil, err
+		}
+	}
+
+	p.op(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("sql: unexpected %q", t.text)
+	}
+	return stmt, nil
+}
+
+// resolve returns the path of the column an ORDER BY name is an alias of,
+// or the path itself
+func (s *Statement) resolve(p path) path {
+	if len(p.parts) == 1 {
+		for _, column := range s.Columns {
+			if column.Name == p.parts[0] {
+				return column.Path
+			}
+		}
+	}
+	return p
+}
+
+func (p *parser) column() (Column, error) {
+	path, err := p.path()
+	if err != nil {
+		return Column{}, err
+	}
+	column := Column{Path: path, Name: path.String()}
+	if _, ok := p.keyword("AS"); ok {
+		if column.Name, err = p.name("a column name"); err != nil {
+			return Column{}, err
+		}
+	}
+	return column, nil
+}
+
+// path parses a dotted path of names
+func (p *parser) path() (path, error) {
+	var parts []string
+	for {
+		part, err := p.name("a field name")
+		if err != nil {
+			return path{}, err
+		}
+		parts = append(parts, part)
+		if _, ok := p.op("."); !ok {
+			return path{parts: parts}, nil
+		}
+	}
+}
+
+// count parses the non-negative integer of LIMIT or OFFSET
+func (p *parser) count(clause string) (int, error) {
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != tokNumber || err != nil || n < 0 {
+		return 0, fmt.Errorf("sql: %s takes a non-negative integer, got %q", clause, t.text)
+	}
+	return n, nil
+}
+
+func (p *parser) or() (condition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.keyword("OR"); !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orCond{left, right}
+	}
+}
+
+func (p *parser) and() (condition, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.keyword("AND"); !ok {
+			return left, nil
+		}
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = andCond{left, right}
+	}
+}
+
+// enter bounds the nesting of the conditions
+func (p *parser) enter() error {
+	if p.depth++; p.depth > maxDepth {
+		return fmt.Errorf("sql: conditions nested more than %d levels deep", maxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) not() (condition, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	if _, ok := p.keyword("NOT"); ok {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notCond{operand}, nil
+	}
+	return p.predicate()
+}
+
+func (p *parser) predicate() (condition, error) {
+	if _, ok := p.op("("); ok {
+		c, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expectOp(")")
+	}
+	if _, ok := p.keyword("MATCH"); ok {
+		return p.match()
+	}
+
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.op("=", "!=", "<>", "<", "<=", ">", ">="); ok {
+		value, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		if op == "<>" {
+			op = "!="
+		}
+		return compareCond{path, op, value}, nil
+	}
+	if _, ok := p.keyword("IS"); ok {
+		_, negate := p.keyword("NOT")
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		return nullCond{path, negate}, nil
+	}
+
+	_, negate := p.keyword("NOT")
+	var c condition
+	switch word, _ := p.keyword("IN", "LIKE", "BETWEEN"); word {
+	case "IN":
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		for {
+			value, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if _, ok := p.op(","); !ok {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		c = inCond{path, values}
+	case "LIKE":
+		t := p.next()
+		if t.kind != tokString {
+			return nil, fmt.Errorf("sql: LIKE takes a string pattern, got %q", t.text)
+		}
+		c = newLikeCond(path, t.text)
+	case "BETWEEN":
+		low, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		c = andCond{compareCond{path, ">=", low}, compareCond{path, "<=", high}}
+	default:
+		return nil, p.unexpected("a comparison")
+	}
+	if negate {
+		c = notCond{c}
+	}
+	return c, nil
+}
+
+// match parses MATCH('text') or MATCH(field, 'text')
+func (p *parser) match() (condition, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var c matchCond
+	if p.peek().kind != tokString {
+		field, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		c.field = &field
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+	}
+	t := p.next()
+	if t.kind != tokString {
+		return nil, fmt.Errorf("sql: MATCH takes a string, got %q", t.text)
+	}
+	c.text = t.text
+	return c, p.expectOp(")")
+}
+
+// literal parses a string, number, boolean or NULL
+func (p *parser) literal() (interface{}, error) {
+	sign := ""
+	if op, ok := p.op("-", "+"); ok {
+		sign = op
+	}
+	t := p.next()
+	switch {
+	case t.kind == tokNumber:
+		f, err := strconv.ParseFloat(sign+t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("sql: invalid number %q", t.text)
+		}
+		return f, nil
+	case sign != "":
+		return nil, fmt.Errorf("sql: expected a number after %s", sign)
+	case t.kind == tokString:
+		return t.text, nil
+	case t.kind == tokIdent:
+		switch strings.ToUpper(t.text) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		case "NULL":
+			return nil, nil
+		}
+	case t.kind == tokEOF:
+		return nil, fmt.Errorf("sql: expected a value at end of query")
+	}
+	return nil, fmt.Errorf("sql: expected a value, got %q", t.text)
+}
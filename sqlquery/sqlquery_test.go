@@ -0,0 +1,271 @@
+package sqlquery
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/threatflux/searchyaml/storage"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		sql     string
+		columns []string
+		where   string
+		orderBy string
+		limit   int
+		offset  int
+	}{
+		{sql: "SELECT * FROM store", limit: -1},
+		{sql: "select key, value.title AS t from store;", columns: []string{"key", "t"}, limit: -1},
+		{
+			sql:   "SELECT * FROM store WHERE a = 1 OR b = 2 AND c = 3",
+			where: "(a = 1 OR b = 2 AND c = 3)", limit: -1,
+		},
+		{
+			sql:   "SELECT * FROM store WHERE (a = 1 OR b = 2) AND NOT c <> 'x'",
+			where: "(a = 1 OR b = 2) AND NOT (c != 'x')", limit: -1,
+		},
+		{
+			sql:   "SELECT * FROM store WHERE risk BETWEEN -1.5 AND 1e2 AND tag NOT IN ('a', 'b')",
+			where: "risk >= -1.5 AND risk <= 100 AND NOT (tag IN ('a', 'b'))", limit: -1,
+		},
+		{
+			sql:   "SELECT * FROM store WHERE name LIKE 'it''s%' AND owner IS NOT NULL AND MATCH(body, 'ransomware')",
+			where: "name LIKE 'it''s%' AND owner IS NOT NULL AND MATCH(body, 'ransomware')", limit: -1,
+		},
+		{
+			sql:     "SELECT name AS n, score FROM store ORDER BY n, value.score DESC LIMIT 10 OFFSET 20 -- comment",
+			columns: []string{"n", "score"}, orderBy: "name, value.score DESC", limit: 10, offset: 20,
+		},
+		{sql: "SELECT * FROM `my store` WHERE \"odd field\" = TRUE LIMIT 0", where: `"odd field" = TRUE`},
+	}
+	for _, tt := range tests {
+		stmt, err := Parse(tt.sql)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.sql, err)
+			continue
+		}
+		var columns []string
+		for _, column := range stmt.Columns {
+			columns = append(columns, column.Name)
+		}
+		where := ""
+		if stmt.where != nil {
+			where = stmt.where.String()
+		}
+		var orderBy []string
+		for _, order := range stmt.OrderBy {
+			if order.Desc {
+				orderBy = append(orderBy, order.Path.String()+" DESC")
+			} else {
+				orderBy = append(orderBy, order.Path.String())
+			}
+		}
+		if !reflect.DeepEqual(columns, tt.columns) || where != tt.where || strings.Join(orderBy, ", ") != tt.orderBy ||
+			stmt.Limit != tt.limit || stmt.Offset != tt.offset {
+			t.Errorf("Parse(%q) = columns %q, where %q, order by %q, limit %d, offset %d; want %q, %q, %q, %d, %d",
+				tt.sql, columns, where, strings.Join(orderBy, ", "), stmt.Limit, stmt.Offset,
+				tt.columns, tt.where, tt.orderBy, tt.limit, tt.offset)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		sql, err string
+	}{
+		{"", "expected SELECT at end of query"},
+		{"DELETE FROM store", `expected SELECT, got "DELETE"`},
+		{"SELECT * store", `expected FROM, got "store"`},
+		{"SELECT * FROM store WHERE", "expected a field name at end of query"},
+		{"SELECT * FROM store WHERE a", "expected a comparison at end of query"},
+		{"SELECT * FROM store WHERE a = ", "expected a value at end of query"},
+		{"SELECT * FROM store WHERE a = 'open", "unterminated '"},
+		{"SELECT * FROM store WHERE a = - 'x'", "expected a number after -"},
+		{"SELECT * FROM store WHERE a = 1..2", `invalid number "1..2"`},
+		{"SELECT * FROM store WHERE a LIKE 1", `LIKE takes a string pattern, got "1"`},
+		{"SELECT * FROM store WHERE MATCH(body)", `expected ",", got ")"`},
+		{"SELECT * FROM store WHERE (a = 1", `expected ")" at end of query`},
+		{"SELECT * FROM store WHERE a = 1 # b", `unexpected character '#'`},
+		{"SELECT * FROM store ORDER score", `expected BY, got "score"`},
+		{"SELECT * FROM store LIMIT -1", `LIMIT takes a non-negative integer, got "-"`},
+		{"SELECT * FROM store LIMIT 1.5", `LIMIT takes a non-negative integer, got "1.5"`},
+		{"SELECT * FROM store OFFSET x", `OFFSET takes a non-negative integer, got "x"`},
+		{"SELECT * FROM store LIMIT 1 extra", `unexpected "extra"`},
+		{"SELECT * FROM store WHERE " + strings.Repeat("NOT ", maxDepth) + "a = 1", "nested more than 64 levels deep"},
+		{"SELECT * FROM store WHERE a = '" + strings.Repeat("x", maxLength) + "'", "longer than 65536 bytes"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.sql)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			name := tt.sql
+			if len(name) > 60 {
+				name = name[:60] + "..."
+			}
+			t.Errorf("Parse(%q): error %v, want %q", name, err, tt.err)
+		}
+	}
+}
+
+// testStore returns a store of hosts with keyword, numeric and text indexes
+// on some of their fields
+func testStore(t *testing.T) *storage.Store {
+	t.Helper()
+	store := storage.NewMemStore()
+	t.Cleanup(func() { store.Close() })
+	hosts := []struct {
+		key, severity, owner, notes string
+		risk                        float64
+		tags                        []interface{}
+	}{
+		{"hosts/db", "critical", "alice", "ransomware found on the database", 9.5, []interface{}{"prod", "sql"}},
+		{"hosts/mail", "high", "bob", "phishing campaign", 7, []interface{}{"prod"}},
+		{"hosts/web", "low", "", "patched web server", 2, []interface{}{"dmz"}},
+		{"hosts/dev", "high", "alice", "ransomware test sample", 5, nil},
+		{"users/alice", "", "", "", 0, nil},
+	}
+	for _, h := range hosts {
+		value := map[string]interface{}{}
+		for field, v := range map[string]interface{}{"severity": h.severity, "owner": h.owner, "notes": h.notes} {
+			if v != "" {
+				value[field] = v
+			}
+		}
+		if h.risk != 0 {
+			value["risk"] = h.risk
+		}
+		if h.tags != nil {
+			value["tags"] = h.tags
+		}
+		if err := store.Set(h.key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for field, indexType := range map[string]string{"severity": "keyword", "risk": "numeric", "notes": "text"} {
+		if err := store.CreateIndex(field, indexType); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestRun(t *testing.T) {
+	store := testStore(t)
+	tests := []struct {
+		sql    string
+		lookup string
+		want   string // The rows, one per line, cells separated by spaces
+	}{
+		{"SELECT key FROM store", LookupScan, "hosts/db\nhosts/dev\nhosts/mail\nhosts/web\nusers/alice"},
+		{"SELECT key FROM store WHERE severity = 'high' ORDER BY key", LookupSearch, "hosts/dev\nhosts/mail"},
+		{"SELECT key FROM store WHERE severity = 'high' AND owner = 'alice'", LookupSearch, "hosts/dev"},
+		{"SELECT key FROM store WHERE severity IN ('low', 'critical') ORDER BY key", LookupSearch, "hosts/db\nhosts/web"},
+		{"SELECT key FROM store WHERE risk > 5 AND risk <= 9.5 ORDER BY key", LookupSearch, "hosts/db\nhosts/mail"},
+		{"SELECT key FROM store WHERE risk BETWEEN 2 AND 5 ORDER BY key", LookupSearch, "hosts/dev\nhosts/web"},
+		{"SELECT key FROM store WHERE key = 'hosts/web'", LookupKeys, "hosts/web"},
+		{"SELECT key FROM store WHERE key LIKE 'hosts/%' AND owner = 'alice'", LookupKeys, "hosts/db\nhosts/dev"},
+		{"SELECT key FROM store WHERE owner IS NULL", LookupScan, "hosts/web\nusers/alice"},
+		{"SELECT key FROM store WHERE NOT owner = 'alice'", LookupScan, "hosts/mail"},
+		{"SELECT key FROM store WHERE tags = 'prod'", LookupScan, "hosts/db\nhosts/mail"},
+		{"SELECT key FROM store WHERE tags != 'prod'", LookupScan, "hosts/web"},
+		{"SELECT key FROM store WHERE owner LIKE '_l%' OR risk < 3", LookupScan, "hosts/db\nhosts/dev\nhosts/web"},
+		{"SELECT key FROM store WHERE risk = '7'", LookupScan, ""},
+		{"SELECT key FROM store WHERE MATCH('ransomware') ORDER BY key", LookupSearch, "hosts/db\nhosts/dev"},
+		{"SELECT key FROM store WHERE MATCH(notes, 'ransomware') AND severity = 'high'", LookupSearch, "hosts/dev"},
+
+		{"SELECT key, risk FROM store WHERE risk > 0 ORDER BY risk DESC", LookupSearch, "hosts/db 9.5\nhosts/mail 7\nhosts/dev 5\nhosts/web 2"},
+		{"SELECT key, risk FROM store ORDER BY risk, key DESC", LookupScan, "users/alice <nil>\nhosts/web 2\nhosts/dev 5\nhosts/mail 7\nhosts/db 9.5"},
+		{"SELECT owner AS o, key FROM store WHERE key LIKE 'hosts/%' ORDER BY o DESC, key", LookupKeys, "bob hosts/mail\nalice hosts/db\nalice hosts/dev\n<nil> hosts/web"},
+		{"SELECT key FROM store ORDER BY key LIMIT 2", LookupScan, "hosts/db\nhosts/dev"},
+		{"SELECT key FROM store ORDER BY key LIMIT 2 OFFSET 3", LookupScan, "hosts/web\nusers/alice"},
+		{"SELECT key FROM store LIMIT 0", LookupScan, ""},
+		{"SELECT key FROM store OFFSET 10", LookupScan, ""},
+		{"SELECT key FROM store WHERE severity = 'critical' OR severity = 'low' ORDER BY key LIMIT 1", LookupScan, "hosts/db"},
+		{"SELECT key FROM store WHERE severity = 'critical' LIMIT 1", LookupSearch, "hosts/db"},
+
+		{"SELECT * FROM store WHERE key = 'hosts/mail'", LookupKeys, "hosts/mail phishing campaign bob 7 high [prod]"},
+		{"SELECT value.owner, missing.field FROM store WHERE key = 'hosts/web'", LookupKeys, "<nil> <nil>"},
+	}
+	for _, tt := range tests {
+		stmt, err := Parse(tt.sql)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.sql, err)
+			continue
+		}
+		results, plan, err := stmt.Run(store, nil)
+		if err != nil {
+			t.Errorf("Run(%q): %v", tt.sql, err)
+			continue
+		}
+		if plan.Lookup != tt.lookup {
+			t.Errorf("Run(%q): lookup %s, want %s", tt.sql, plan.Lookup, tt.lookup)
+		}
+		table := stmt.Table(results)
+		rows := make([]string, len(table.Rows))
+		for i, cells := range table.Rows {
+			rows[i] = strings.TrimSuffix(fmt.Sprintln(cells...), "\n")
+		}
+		if got := strings.Join(rows, "\n"); got != tt.want {
+			t.Errorf("%s\ngot:\n%s\nwant:\n%s", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestRunStarColumns(t *testing.T) {
+	store := testStore(t)
+	stmt, err := Parse("SELECT * FROM store WHERE key LIKE 'hosts/%'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _, err := stmt.Run(store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"key", "notes", "owner", "risk", "severity", "tags"}
+	if got := stmt.Table(results).Columns; !reflect.DeepEqual(got, want) {
+		t.Errorf("SELECT * columns %q, want %q", got, want)
+	}
+}
+
+func TestRunReadable(t *testing.T) {
+	store := testStore(t)
+	readable := func(key string) bool { return key != "hosts/db" }
+	for _, sql := range []string{
+		"SELECT key FROM store WHERE severity IN ('critical', 'high') ORDER BY key LIMIT 2",
+		"SELECT key FROM store WHERE key IN ('hosts/db', 'hosts/dev', 'hosts/mail') LIMIT 2",
+		"SELECT key FROM store WHERE owner IS NOT NULL ORDER BY key LIMIT 2",
+	} {
+		stmt, err := Parse(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, _, err := stmt.Run(store, readable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table := stmt.Table(results)
+		want := [][]interface{}{{"hosts/dev"}, {"hosts/mail"}}
+		if !reflect.DeepEqual(table.Rows, want) {
+			t.Errorf("%s: rows %v, want %v without the unreadable key", sql, table.Rows, want)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	store := testStore(t)
+	for _, sql := range []string{
+		"SELECT * FROM store WHERE MATCH('a') AND MATCH('b')",
+		"SELECT * FROM store WHERE MATCH(owner, 'alice')",
+	} {
+		stmt, err := Parse(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := stmt.Run(store, nil); err == nil || !strings.Contains(err.Error(), storage.ErrInvalidQuery.Error()) {
+			t.Errorf("Run(%q): error %v, want an invalid query", sql, err)
+		}
+	}
+}
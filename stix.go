@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/stix"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// ensureSTIXIndexes creates text indexes for the STIX properties we search on
+func ensureSTIXIndexes(store *storage.Store) error {
+	for _, field := range stix.IndexedFields {
+		if err := store.CreateIndex(field, "text"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ingestSTIXObjects stores objects, which must be valid, under their STIX id
+func ingestSTIXObjects(store *storage.Store, objects []stix.Object) (int, error) {
+	if err := ensureSTIXIndexes(store); err != nil {
+		return 0, err
+	}
+
+	values := make([]storage.KeyValue, 0, len(objects))
+	for _, obj := range objects {
+		values = append(values, storage.KeyValue{Key: obj.ID(), Value: map[string]interface{}(obj)})
+	}
+	return store.SetMany(values)
+}
+
+// startTAXIIPoller periodically pulls new objects from a TAXII collection
+func startTAXIIPoller(store *storage.Store, client *stix.TAXIIClient, interval time.Duration) {
+	poll := func() {
+		objects, err := client.Poll()
+		if err != nil {
+			log.Printf("TAXII poll error: %v", err)
+		}
+		if len(objects) == 0 {
+			return
+		}
+
+		objects, skipped := stix.ValidObjects(objects)
+		count, err := ingestSTIXObjects(store, objects)
+		if err != nil {
+			log.Printf("TAXII ingest error: %v", err)
+		}
+		for _, s := range skipped {
+			log.Printf("Skipped STIX object %d (%s) from %s: %s", s.Index, s.ID, client.CollectionURL, s.Error)
+		}
+		log.Printf("Ingested %d STIX objects from %s, skipped %d invalid", count, client.CollectionURL, len(skipped))
+	}
+
+	go func() {
+		poll()
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			poll()
+		}
+	}()
+}
+
+func handleSTIXIngest(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		raw, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		// The objects of the bundle are the valid ones; only those are
+		// written, so only those need to be writable
+		bundle, skipped, err := stix.ParseBundle(raw)
+		if err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		for _, obj := range bundle.Objects {
+			if !canWrite(c, obj.ID()) {
+				respondErrorDetails(c, 403, CodeForbidden, "access denied", gin.H{"id": obj.ID()})
+				return
+			}
+		}
+
+		count, err := ingestSTIXObjects(store, bundle.Objects)
+		if err != nil {
+			respondStoreErrorDetails(c, err, gin.H{"ingested": count, "skipped": skipped})
+			return
+		}
+
+		response := gin.H{"status": "ok", "ingested": count}
+		if len(skipped) > 0 {
+			response["skipped"] = skipped
+		}
+		c.JSON(200, response)
+	}
+}
+
+func handleSTIXExport(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		var query storage.SearchQuery
+		if err := c.ShouldBindJSON(&query); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		results, err := store.SearchReadable(query, readableKeys(c))
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+
+		results = redactResults(c, results)
+
+		objects := make([]stix.Object, 0, len(results))
+		for _, result := range results {
+			if obj, ok := stix.AsObject(result.Value); ok {
+				objects = append(objects, obj)
+			}
+		}
+
+		c.JSON(200, stix.NewBundle(objects))
+	}
+}
@@ -0,0 +1,117 @@
+package stix
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+)
+
+// Object is a generic STIX 2.1 object. Only type and id are required; all
+// other properties are kept as-is.
+type Object map[string]interface{}
+
+// Bundle is a STIX 2.1 bundle
+type Bundle struct {
+	Type    string   `json:"type"`
+	ID      string   `json:"id"`
+	Objects []Object `json:"objects"`
+}
+
+// IndexedFields are the STIX properties SearchYAML indexes for text search
+var IndexedFields = []string{"name", "description", "labels"}
+
+// ID returns the object's STIX identifier
+func (o Object) ID() string {
+	id, _ := o["id"].(string)
+	return id
+}
+
+// Type returns the object's STIX type
+func (o Object) Type() string {
+	t, _ := o["type"].(string)
+	return t
+}
+
+// Validate checks the properties required of every STIX object
+func (o Object) Validate() error {
+	if o.Type() == "" {
+		return fmt.Errorf("stix object missing type")
+	}
+	if o.ID() == "" {
+		return fmt.Errorf("stix object missing id")
+	}
+	return nil
+}
+
+// Skipped describes an invalid object left out of an ingest
+type Skipped struct {
+	Index int    `json:"index"` // Position among the objects
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// ValidObjects returns the valid objects, in order, and describes the others
+func ValidObjects(objects []Object) ([]Object, []Skipped) {
+	valid := make([]Object, 0, len(objects))
+	var skipped []Skipped
+	for i, obj := range objects {
+		if err := obj.Validate(); err != nil {
+			skipped = append(skipped, Skipped{Index: i, ID: obj.ID(), Error: err.Error()})
+			continue
+		}
+		valid = append(valid, obj)
+	}
+	return valid, skipped
+}
+
+// AsObject converts a stored value into a STIX object if it looks like one
+func AsObject(value interface{}) (Object, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	obj := Object(m)
+	if obj.Validate() != nil {
+		return nil, false
+	}
+	return obj, true
+}
+
+// NewBundle wraps objects in a bundle with a fresh identifier
+func NewBundle(objects []Object) Bundle {
+	if objects == nil {
+		objects = []Object{}
+	}
+	return Bundle{
+		Type:    "bundle",
+		ID:      "bundle--" + newUUID(),
+		Objects: objects,
+	}
+}
+
+// ParseBundle decodes a STIX bundle. Invalid objects are left out of the
+// bundle and described in the skipped list, so one bad object does not
+// reject the others.
+func ParseBundle(data []byte) (Bundle, []Skipped, error) {
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return bundle, nil, fmt.Errorf("failed to decode bundle: %v", err)
+	}
+	if bundle.Type != "bundle" {
+		return bundle, nil, fmt.Errorf("unexpected stix type: %s", bundle.Type)
+	}
+	var skipped []Skipped
+	bundle.Objects, skipped = ValidObjects(bundle.Objects)
+	return bundle, skipped, nil
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
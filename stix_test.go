@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// TestSTIXIngestChecksValidObjects ingests bundles for an API key that may
+// only write indicators: invalid objects are skipped whatever their id, and
+// valid ones outside its prefixes reject the bundle
+func TestSTIXIngestChecksValidObjects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := storage.NewMemStore()
+	t.Cleanup(func() { store.Close() })
+	acl := &ACL{config: ACLConfig{
+		Roles:   map[string]Role{"analyst": {Read: []string{"indicator--*"}, Write: []string{"indicator--*"}}},
+		APIKeys: map[string][]string{"analyst": {"analyst"}},
+	}}
+	r := gin.New()
+	r.Use(aclMiddleware(acl))
+	r.POST("/stix/bundle", handleSTIXIngest(store))
+
+	ingest := func(objects string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/stix/bundle", strings.NewReader(`{"type": "bundle", "id": "bundle--1", "objects": [`+objects+`]}`))
+		req.Header.Set("X-API-Key", "analyst")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+		return w.Code, body
+	}
+
+	indicator := `{"type": "indicator", "id": "indicator--1", "name": "bad"}`
+	code, body := ingest(indicator + `, {"id": "malware--1", "name": "untyped"}`)
+	if code != http.StatusOK || body["ingested"] != 1.0 {
+		t.Fatalf("bundle with an invalid object outside the writable prefixes: %d %v, want 1 ingested", code, body)
+	}
+	if skipped, _ := body["skipped"].([]interface{}); len(skipped) != 1 || !strings.Contains(skipped[0].(map[string]interface{})["error"].(string), "missing type") {
+		t.Errorf("skipped = %v, want the untyped malware--1", body["skipped"])
+	}
+	if _, found := store.Get("malware--1"); found {
+		t.Error("the invalid malware--1 was stored")
+	}
+
+	code, body = ingest(indicator + `, {"type": "malware", "id": "malware--2"}`)
+	if details, _ := json.Marshal(body); code != http.StatusForbidden || !strings.Contains(string(details), "malware--2") {
+		t.Errorf("bundle with a valid object outside the writable prefixes: %d %v, want 403 naming malware--2", code, body)
+	}
+	if _, found := store.Get("malware--2"); found {
+		t.Error("the forbidden malware--2 was stored")
+	}
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// maxHistogramBuckets bounds the buckets of an aggregation histogram
+const maxHistogramBuckets = 10000
+
+// AggregateRequest asks for statistics of a field with a numeric index
+type AggregateRequest struct {
+	Field       string           `json:"field" binding:"required"`
+	Query       *SearchQuery     `json:"query,omitempty"`       // Aggregate only the documents matching the query
+	Ranges      []AggregateRange `json:"ranges,omitempty"`      // Count the values in each range
+	Percentiles []float64        `json:"percentiles,omitempty"` // Percentiles from 0 to 100, e.g. 50, 90, 99
+	Interval    float64          `json:"interval,omitempty"`    // Bucket width of a histogram of the values
+}
+
+// AggregateRange is a range of values, from inclusive to exclusive. Nil
+// bounds are open.
+type AggregateRange struct {
+	From  *float64 `json:"from,omitempty"`
+	To    *float64 `json:"to,omitempty"`
+	Count int      `json:"count"`
+}
+
+// HistogramBucket counts the values from Key, inclusive, to Key plus the
+// interval, exclusive
+type HistogramBucket struct {
+	Key   float64 `json:"key"`
+	Count int     `json:"count"`
+}
+
+// AggregateResult holds the statistics of a numeric field
+type AggregateResult struct {
+	Field       string             `json:"field"`
+	Count       int                `json:"count"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Sum         float64            `json:"sum"`
+	Mean        float64            `json:"mean"`
+	Ranges      []AggregateRange   `json:"ranges,omitempty"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"` // Keyed by the requested percentile, e.g. "99.9"
+	Histogram   []HistogramBucket  `json:"histogram,omitempty"`
+}
+
+// validate checks the request before any index is read
+func (req AggregateRequest) validate() error {
+	for _, p := range req.Percentiles {
+		if p < 0 || p > 100 || math.IsNaN(p) {
+			return fmt.Errorf("%w: percentile %v is not between 0 and 100", ErrInvalidQuery, p)
+		}
+	}
+	if req.Interval < 0 || math.IsNaN(req.Interval) || math.IsInf(req.Interval, 0) {
+		return fmt.Errorf("%w: histogram interval must be positive", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// Aggregate computes the count, minimum, maximum, sum and mean of a field,
+// with the requested range counts, percentiles and histogram. The field
+// needs a numeric index, whose sorted values answer ranges and percentiles
+// by binary search. Without a query every indexed document is aggregated;
+// keys for which readable returns false are left out, and a nil readable
+// keeps every key.
+func (s *Store) Aggregate(req AggregateRequest, readable func(key string) bool) (*AggregateResult, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	idx, exists := s.indexes.numericIndex(req.Field)
+	if !exists {
+		return nil, fmt.Errorf("%w: aggregations on %s need a numeric index", ErrInvalidQuery, req.Field)
+	}
+	s.usage.record([]indexRef{{req.Field, "numeric"}})
+
+	var result *AggregateResult
+	var err error
+	switch {
+	case req.Query != nil:
+		var keys []string
+		err = s.eachMatch(*req.Query, readable, func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		result, err = aggregateSorted(req, idx.valuesOf(keys))
+	case readable != nil:
+		result, err = aggregateSorted(req, idx.valuesWhere(readable))
+	default:
+		idx.view(func(sorted []float64) {
+			result, err = aggregateSorted(req, sorted)
+		})
+	}
+	return result, err
+}
+
+// aggregateSorted computes the aggregations of values in ascending order
+func aggregateSorted(req AggregateRequest, values []float64) (*AggregateResult, error) {
+	result := &AggregateResult{Field: req.Field, Count: len(values)}
+
+	for _, r := range req.Ranges {
+		lo, hi := NumericRange{GTE: r.From, LT: r.To}.bounds(values)
+		r.Count = hi - lo
+		result.Ranges = append(result.Ranges, r)
+	}
+	if len(values) == 0 {
+		return result, nil
+	}
+
+	result.Min, result.Max = values[0], values[len(values)-1]
+	for _, v := range values {
+		result.Sum += v
+	}
+	result.Mean = result.Sum / float64(len(values))
+
+	if len(req.Percentiles) > 0 {
+		result.Percentiles = make(map[string]float64, len(req.Percentiles))
+		for _, p := range req.Percentiles {
+			// Nearest rank: the smallest value with at least p% of the values at or below it
+			rank := int(math.Ceil(p / 100 * float64(len(values))))
+			result.Percentiles[strconv.FormatFloat(p, 'f', -1, 64)] = values[max(rank-1, 0)]
+		}
+	}
+
+	if req.Interval > 0 {
+		first := math.Floor(result.Min / req.Interval)
+		last := math.Floor(result.Max / req.Interval)
+		// Beyond 2^53 bucket numbers stop being exact, as do infinite ones
+		if max(math.Abs(first), math.Abs(last)) >= 1<<53 || last-first >= maxHistogramBuckets {
+			return nil, fmt.Errorf("%w: histogram would have more than %d buckets; raise the interval", ErrInvalidQuery, maxHistogramBuckets)
+		}
+		start := 0
+		for b := first; b <= last; b++ {
+			upper := (b + 1) * req.Interval
+			end := start + sort.Search(len(values)-start, func(i int) bool { return values[start+i] >= upper })
+			result.Histogram = append(result.Histogram, HistogramBucket{Key: b * req.Interval, Count: end - start})
+			start = end
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// AttackField is the field added to documents enriched with ATT&CK techniques
+const AttackField = "attack_techniques"
+
+var attackPattern = regexp.MustCompile(`(?i)\bT\d{4}(?:\.\d{3})?\b`)
+
+// IsAttackTechnique reports whether id is a well-formed ATT&CK technique or sub-technique ID
+func IsAttackTechnique(id string) bool {
+	m := attackPattern.FindString(id)
+	return m != "" && len(m) == len(id)
+}
+
+// ExtractAttackTechniques returns the sorted, normalized ATT&CK technique IDs mentioned in text
+func ExtractAttackTechniques(text string) []string {
+	seen := make(map[string]struct{})
+	for _, m := range attackPattern.FindAllString(text, -1) {
+		seen[strings.ToUpper(m)] = struct{}{}
+	}
+
+	techniques := make([]string, 0, len(seen))
+	for t := range seen {
+		techniques = append(techniques, t)
+	}
+	sort.Strings(techniques)
+	return techniques
+}
+
+// EnrichAttackTechniques returns a copy of a map value with an attack_techniques
+// field listing every technique ID mentioned in its string fields. Other values
+// and documents without techniques are returned unchanged.
+func EnrichAttackTechniques(value interface{}) interface{} {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+
+	var text strings.Builder
+	for field, v := range m {
+		if field != AttackField {
+			collectStrings(v, &text)
+		}
+	}
+
+	techniques := ExtractAttackTechniques(text.String())
+	if len(techniques) == 0 {
+		return value
+	}
+
+	enriched := make(map[string]interface{}, len(m)+1)
+	for k, v := range m {
+		enriched[k] = v
+	}
+	list := make([]interface{}, len(techniques))
+	for i, t := range techniques {
+		list[i] = t
+	}
+	enriched[AttackField] = list
+	return enriched
+}
+
+// collectStrings appends every string nested in value to b
+func collectStrings(value interface{}, b *strings.Builder) {
+	switch v := value.(type) {
+	case string:
+		b.WriteString(v)
+		b.WriteByte(' ')
+	case map[string]interface{}:
+		for _, child := range v {
+			collectStrings(child, b)
+		}
+	case []interface{}:
+		for _, child := range v {
+			collectStrings(child, b)
+		}
+	}
+}
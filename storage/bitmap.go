@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// bitmapArrayMax is the number of values above which a bitmap container
+// switches from a sorted array to a bitset. At 4096 values both take 8KB.
+const bitmapArrayMax = 4096
+
+// bitmapWords is the number of 64-bit words in a bitset container
+const bitmapWords = 1 << 16 / 64
+
+// Bitmap is a compressed set of document IDs in the style of a roaring
+// bitmap: IDs are grouped by their high 16 bits into containers holding the
+// low 16 bits, as a sorted array while a container is sparse and as a bitset
+// once it is dense. Intersections then compare arrays or AND words instead
+// of probing a hash map per key. A Bitmap is not safe for concurrent
+// writes; its owner's lock guards it.
+type Bitmap struct {
+	highs      []uint16 // sorted high bits of each container
+	containers []*bitmapContainer
+	n          int
+}
+
+type bitmapContainer struct {
+	n     int
+	array []uint16 // sorted low bits, when bits is nil
+	bits  []uint64 // bitmapWords words, for dense containers
+}
+
+// NewBitmap creates an empty bitmap
+func NewBitmap() *Bitmap {
+	return &Bitmap{}
+}
+
+// Len returns the number of IDs in the bitmap
+func (b *Bitmap) Len() int {
+	return b.n
+}
+
+// container returns the position of the container of high, and whether it
+// exists
+func (b *Bitmap) container(high uint16) (int, bool) {
+	i := sort.Search(len(b.highs), func(i int) bool { return b.highs[i] >= high })
+	return i, i < len(b.highs) && b.highs[i] == high
+}
+
+// Add adds id, reporting whether it was not yet in the bitmap
+func (b *Bitmap) Add(id uint32) bool {
+	high, low := uint16(id>>16), uint16(id)
+	i, exists := b.container(high)
+	if !exists {
+		b.highs = append(b.highs, 0)
+		copy(b.highs[i+1:], b.highs[i:])
+		b.highs[i] = high
+		b.containers = append(b.containers, nil)
+		copy(b.containers[i+1:], b.containers[i:])
+		b.containers[i] = &bitmapContainer{}
+	}
+	if !b.containers[i].add(low) {
+		return false
+	}
+	b.n++
+	return true
+}
+
+// Remove removes id, reporting whether it was in the bitmap
+func (b *Bitmap) Remove(id uint32) bool {
+	i, exists := b.container(uint16(id >> 16))
+	if !exists || !b.containers[i].remove(uint16(id)) {
+		return false
+	}
+	b.n--
+	if b.containers[i].n == 0 {
+		b.highs = append(b.highs[:i], b.highs[i+1:]...)
+		b.containers = append(b.containers[:i], b.containers[i+1:]...)
+	}
+	return true
+}
+
+// Contains reports whether id is in the bitmap
+func (b *Bitmap) Contains(id uint32) bool {
+	i, exists := b.container(uint16(id >> 16))
+	return exists && b.containers[i].contains(uint16(id))
+}
+
+// Each calls fn with every ID in ascending order until fn returns false
+func (b *Bitmap) Each(fn func(id uint32) bool) {
+	for i, c := range b.containers {
+		if !c.each(uint32(b.highs[i])<<16, fn) {
+			return
+		}
+	}
+}
+
+// Clone returns a copy of the bitmap
+func (b *Bitmap) Clone() *Bitmap {
+	out := &Bitmap{highs: append([]uint16(nil), b.highs...), containers: make([]*bitmapContainer, len(b.containers)), n: b.n}
+	for i, c := range b.containers {
+		out.containers[i] = c.clone()
+	}
+	return out
+}
+
+// And returns the IDs in both b and o
+func (b *Bitmap) And(o *Bitmap) *Bitmap {
+	out := &Bitmap{}
+	for i, j := 0, 0; i < len(b.highs) && j < len(o.highs); {
+		switch {
+		case b.highs[i] < o.highs[j]:
+			i++
+		case b.highs[i] > o.highs[j]:
+			j++
+		default:
+			if c := b.containers[i].and(o.containers[j]); c.n > 0 {
+				out.highs = append(out.highs, b.highs[i])
+				out.containers = append(out.containers, c)
+				out.n += c.n
+			}
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// AddAll adds the IDs of o to b
+func (b *Bitmap) AddAll(o *Bitmap) {
+	o.Each(func(id uint32) bool {
+		b.Add(id)
+		return true
+	})
+}
+
+// memoryUsage estimates the size of the bitmap
+func (b *Bitmap) memoryUsage() int64 {
+	size := int64(2*sliceHeaderSize + 8 + 2*len(b.highs))
+	for _, c := range b.containers {
+		size += 8 + 8 + 2*sliceHeaderSize + 2*int64(cap(c.array)) + 8*int64(len(c.bits))
+	}
+	return size
+}
+
+func (c *bitmapContainer) add(low uint16) bool {
+	if c.bits != nil {
+		word, bit := low/64, uint64(1)<<(low%64)
+		if c.bits[word]&bit != 0 {
+			return false
+		}
+		c.bits[word] |= bit
+		c.n++
+		return true
+	}
+
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	if i < len(c.array) && c.array[i] == low {
+		return false
+	}
+	if len(c.array) == bitmapArrayMax {
+		c.toBits()
+		return c.add(low)
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = low
+	c.n++
+	return true
+}
+
+func (c *bitmapContainer) remove(low uint16) bool {
+	if c.bits != nil {
+		word, bit := low/64, uint64(1)<<(low%64)
+		if c.bits[word]&bit == 0 {
+			return false
+		}
+		c.bits[word] &^= bit
+		c.n--
+		// Switch back well below the limit so that a container at the
+		// boundary does not convert on every write
+		if c.n <= bitmapArrayMax/2 {
+			c.toArray()
+		}
+		return true
+	}
+
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	if i == len(c.array) || c.array[i] != low {
+		return false
+	}
+	c.array = append(c.array[:i], c.array[i+1:]...)
+	c.n--
+	return true
+}
+
+func (c *bitmapContainer) contains(low uint16) bool {
+	if c.bits != nil {
+		return c.bits[low/64]&(1<<(low%64)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	return i < len(c.array) && c.array[i] == low
+}
+
+func (c *bitmapContainer) each(high uint32, fn func(id uint32) bool) bool {
+	if c.bits == nil {
+		for _, low := range c.array {
+			if !fn(high | uint32(low)) {
+				return false
+			}
+		}
+		return true
+	}
+	for w, word := range c.bits {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			if !fn(high | uint32(w*64+bit)) {
+				return false
+			}
+			word &= word - 1
+		}
+	}
+	return true
+}
+
+func (c *bitmapContainer) clone() *bitmapContainer {
+	out := &bitmapContainer{n: c.n}
+	if c.bits != nil {
+		out.bits = append([]uint64(nil), c.bits...)
+	} else {
+		out.array = append([]uint16(nil), c.array...)
+	}
+	return out
+}
+
+func (c *bitmapContainer) toBits() {
+	c.bits = make([]uint64, bitmapWords)
+	for _, low := range c.array {
+		c.bits[low/64] |= 1 << (low % 64)
+	}
+	c.array = nil
+}
+
+func (c *bitmapContainer) toArray() {
+	array := make([]uint16, 0, c.n)
+	c.each(0, func(id uint32) bool {
+		array = append(array, uint16(id))
+		return true
+	})
+	c.array, c.bits = array, nil
+}
+
+func (c *bitmapContainer) and(o *bitmapContainer) *bitmapContainer {
+	switch {
+	case c.bits != nil && o.bits != nil:
+		out := &bitmapContainer{bits: make([]uint64, bitmapWords)}
+		for w := range out.bits {
+			out.bits[w] = c.bits[w] & o.bits[w]
+			out.n += bits.OnesCount64(out.bits[w])
+		}
+		if out.n <= bitmapArrayMax {
+			out.toArray()
+		}
+		return out
+	case c.bits != nil:
+		return o.and(c)
+	case o.bits != nil:
+		out := &bitmapContainer{array: make([]uint16, 0, len(c.array))}
+		for _, low := range c.array {
+			if o.contains(low) {
+				out.array = append(out.array, low)
+			}
+		}
+		out.n = len(out.array)
+		return out
+	}
+
+	out := &bitmapContainer{array: make([]uint16, 0, min(len(c.array), len(o.array)))}
+	for i, j := 0, 0; i < len(c.array) && j < len(o.array); {
+		switch {
+		case c.array[i] < o.array[j]:
+			i++
+		case c.array[i] > o.array[j]:
+			j++
+		default:
+			out.array = append(out.array, c.array[i])
+			i++
+			j++
+		}
+	}
+	out.n = len(out.array)
+	return out
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// UpdateBatchSize is the number of documents UpdateByQuery updates under one
+// lock, so reads and writes proceed between batches of large updates
+const UpdateBatchSize = 500
+
+// maxUpdateFailures is the number of failed documents an UpdateProgress lists
+const maxUpdateFailures = 100
+
+// ErrSkipUpdate is returned by an UpdateFunc to leave a document unchanged
+var ErrSkipUpdate = errors.New("update skipped")
+
+// UpdateFunc returns the new value of a document matched by UpdateByQuery.
+// It must not modify value, which readers may hold.
+type UpdateFunc func(key string, value interface{}) (interface{}, error)
+
+// UpdateProgress reports the documents an UpdateByQuery has processed
+type UpdateProgress struct {
+	Matched   int             `json:"matched"`
+	Processed int             `json:"processed"`
+	Updated   int             `json:"updated"`
+	Noops     int             `json:"noops"` // Unchanged, skipped or deleted since the search
+	Failed    int             `json:"failed"`
+	Failures  []UpdateFailure `json:"failures,omitempty"` // The first 100
+}
+
+// UpdateFailure is a document UpdateByQuery could not update
+type UpdateFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// DeleteByQuery deletes every key matching query and returns the matched
+// keys. The search and the deletes run under one lock, so no write lands
+// between them. check, when not nil, is called for every match before
+// anything is deleted, and an error from it aborts the delete. With dryRun
+// the matches are returned and nothing is deleted.
+func (s *Store) DeleteByQuery(query SearchQuery, check func(key string) error, dryRun bool) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.opts.ReadOnly && !dryRun {
+		return nil, ErrReadOnly
+	}
+
+	results, _, err := s.searchLocked(query, nil)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(results))
+	for _, result := range results {
+		if check != nil {
+			if err := check(result.Key); err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, result.Key)
+	}
+	if dryRun || len(keys) == 0 {
+		return keys, nil
+	}
+
+	for _, key := range keys {
+		s.deleteEntry(key)
+	}
+	s.dirty = true
+
+	s.counters.deletes.Add(uint64(len(keys)))
+	return keys, nil
+}
+
+// UpdateByQuery replaces the value of every key matching query with the
+// value update returns for it. check, when not nil, is called for every
+// match before anything is updated, and an error from it aborts the update.
+// Documents are updated in batches of UpdateBatchSize, each under one lock,
+// and progress, when not nil, is called after every batch. A document
+// failing update or validation is counted in Failed and left unchanged;
+// the others are still updated. Updated entries keep their expiry and
+// metadata but lose the original YAML of round-trip entries. Cancelling ctx
+// stops the update between batches with ctx.Err() and the progress so far.
+func (s *Store) UpdateByQuery(ctx context.Context, query SearchQuery, check func(key string) error, update UpdateFunc, progress func(UpdateProgress)) (UpdateProgress, error) {
+	if s.opts.ReadOnly {
+		return UpdateProgress{}, ErrReadOnly
+	}
+
+	results, err := s.Search(query)
+	if err != nil {
+		return UpdateProgress{}, err
+	}
+	p := UpdateProgress{Matched: len(results)}
+	if check != nil {
+		for _, result := range results {
+			if err := check(result.Key); err != nil {
+				return UpdateProgress{}, err
+			}
+		}
+	}
+
+	for start := 0; start < len(results); start += UpdateBatchSize {
+		if err := ctx.Err(); err != nil {
+			return p, err
+		}
+		batch := results[start:min(start+UpdateBatchSize, len(results))]
+		s.updateBatch(batch, update, &p)
+		if progress != nil {
+			progress(p)
+		}
+	}
+	return p, nil
+}
+
+// Update replaces the value of key with the value update returns for it,
+// under one lock so no write lands in between. update gets a nil value for
+// a missing key, whose entry is then created. Existing entries keep their
+// expiry and metadata as with UpdateByQuery. ErrSkipUpdate from update
+// leaves the key unchanged and is not returned.
+func (s *Store) Update(key string, update UpdateFunc) error {
+	if err := s.checkKey(key); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	defer func() {
+		s.updateWriteStats(time.Since(start))
+	}()
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkQuota(key); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	var err error
+	if entry, exists := s.data[key]; exists && !entry.expired(now) {
+		err = s.updateEntry(key, now, update)
+	} else {
+		err = s.createEntry(key, now, update)
+	}
+	if errors.Is(err, ErrSkipUpdate) {
+		return nil
+	}
+	return err
+}
+
+// createEntry writes the value update returns for a missing key. Callers
+// must hold the lock.
+func (s *Store) createEntry(key string, now int64, update UpdateFunc) error {
+	value, err := update(key, nil)
+	if err != nil {
+		return err
+	}
+	if value, err = s.prepare(value); err != nil {
+		return err
+	}
+	if err := s.enforceLimits(key, value); err != nil {
+		return err
+	}
+	s.setEntry(key, &Entry{Value: value, Timestamp: now})
+	s.dirty = true
+
+	s.updateIndexes(key, value)
+	return nil
+}
+
+// updateBatch updates the documents of one batch of UpdateByQuery
+func (s *Store) updateBatch(batch []SearchResult, update UpdateFunc, p *UpdateProgress) {
+	start := time.Now()
+	defer func() {
+		s.updateWriteStats(time.Since(start))
+	}()
+
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now().Unix()
+	for _, result := range batch {
+		p.Processed++
+		err := s.updateEntry(result.Key, now, update)
+		switch {
+		case errors.Is(err, ErrSkipUpdate):
+			p.Noops++
+		case err != nil:
+			p.Failed++
+			if len(p.Failures) < maxUpdateFailures {
+				p.Failures = append(p.Failures, UpdateFailure{Key: result.Key, Error: err.Error()})
+			}
+		default:
+			p.Updated++
+		}
+	}
+}
+
+// updateEntry replaces the value of key with the value update returns for
+// it, keeping its expiry at the same time. Callers must hold the lock.
+func (s *Store) updateEntry(key string, now int64, update UpdateFunc) error {
+	entry, exists := s.data[key]
+	if !exists || entry.expired(now) {
+		return ErrSkipUpdate // Deleted since the search
+	}
+	entry = entry.hydrate()
+
+	value, err := update(key, entry.Value)
+	if err != nil {
+		return err
+	}
+	if value, err = s.prepare(value); err != nil {
+		return err
+	}
+	if reflect.DeepEqual(value, entry.Value) {
+		return ErrSkipUpdate
+	}
+	if err := s.enforceLimits(key, value); err != nil {
+		return err
+	}
+
+	updated := *entry
+	updated.Value = value
+	updated.Source = ""
+	updated.Timestamp = now
+	updated.TTL = entry.ttlAt(now)
+	s.setEntry(key, &updated)
+	s.dirty = true
+
+	s.updateIndexes(key, value)
+	return nil
+}
+
+// KeyValue is a document written by SetMany
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// SetMany writes a batch of documents under one lock, indexing them with one
+// IndexBatch, which loads large imports much faster than a Set per
+// document. Keys are checked and values prepared before anything is
+// written, so an invalid key or a value rejected by StrictIndexing writes
+// nothing. Quotas are checked key by key as with Set: the documents before
+// the first over quota are written. It returns the number of documents
+// written.
+func (s *Store) SetMany(values []KeyValue) (int, error) {
+	for _, kv := range values {
+		if err := s.checkKey(kv.Key); err != nil {
+			return 0, err
+		}
+	}
+
+	start := time.Now()
+	defer func() {
+		s.updateWriteStats(time.Since(start))
+	}()
+
+	s.Lock()
+	defer s.Unlock()
+
+	prepared := make([]interface{}, len(values))
+	for i, kv := range values {
+		value, err := s.prepare(kv.Value)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", kv.Key, err)
+		}
+		prepared[i] = value
+	}
+
+	batch := s.indexes.BeginBatch()
+	defer s.commitIndexes(batch)
+
+	now := time.Now().Unix()
+	for i, kv := range values {
+		if err := s.checkQuota(kv.Key); err != nil {
+			return i, err
+		}
+		if s.limited() {
+			// Limits count the indexed documents, so the batch so far must
+			// be indexed, and evictions must not race its updates
+			s.commitIndexes(batch)
+			if err := s.enforceLimits(kv.Key, prepared[i]); err != nil {
+				return i, err
+			}
+		}
+		s.setEntry(kv.Key, &Entry{
+			Value:     prepared[i],
+			Timestamp: now,
+			Metadata:  s.keepCreator(kv.Key, nil),
+		})
+		s.dirty = true
+		batch.Update(kv.Key, prepared[i])
+	}
+	return len(values), nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+)
+
+// ChunkField is the field of chunk entries describing the passage of a
+// parent document they hold: {"parent": key, "index": n, "field": name,
+// "start": offset, "end": offset}. The passage is the chunk's value of the
+// named field, so text indexes on that field search chunks as well.
+const ChunkField = "_chunk"
+
+// maxGroupedChunks is the number of best chunks kept with a grouped result
+const maxGroupedChunks = 3
+
+// chunkFetchFactor multiplies the matches the indexes return for a query
+// grouping chunks, since several of them may be chunks of one document
+const chunkFetchFactor = 10
+
+// ChunkKey returns the key of chunk i of parent
+func ChunkKey(parent string, i int) string {
+	return parent + "#" + strconv.Itoa(i)
+}
+
+// ChunkMatch is a matching chunk of a grouped result
+type ChunkMatch struct {
+	Key   string  `json:"key"`
+	Index int     `json:"index"`
+	Field string  `json:"field"` // Field of the parent the chunk is a passage of
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
+
+// chunkOf returns the parent key of a chunk entry's value and the chunk as a
+// match, or false when the value is not a chunk
+func chunkOf(key string, value interface{}) (string, ChunkMatch, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return "", ChunkMatch{}, false
+	}
+	info, ok := m[ChunkField].(map[string]interface{})
+	if !ok {
+		return "", ChunkMatch{}, false
+	}
+	parent, _ := info["parent"].(string)
+	if parent == "" {
+		return "", ChunkMatch{}, false
+	}
+	match := ChunkMatch{Key: key}
+	if index, ok := toFloat(info["index"]); ok {
+		match.Index = int(index)
+	}
+	if field, ok := info["field"].(string); ok {
+		match.Field = field
+		match.Text, _ = m[field].(string)
+	}
+	return parent, match, true
+}
+
+// ChunkKeys returns the keys of the chunks of parent, which are numbered
+// from 0 without gaps
+func (s *Store) ChunkKeys(parent string) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	var keys []string
+	for i := 0; ; i++ {
+		key := ChunkKey(parent, i)
+		entry, exists := s.data[key]
+		if !exists {
+			return keys
+		}
+		if p, _, ok := chunkOf(key, entry.hydrate().Value); !ok || p != parent {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+}
+
+// groupChunksLocked replaces the chunks among results by their parents, each
+// scored as its best match and carrying its best chunks. Chunks of deleted
+// parents are dropped. Callers must hold the lock.
+func (s *Store) groupChunksLocked(results []SearchResult, withValues bool) []SearchResult {
+	grouped := make([]SearchResult, 0, len(results))
+	parents := make(map[string]int) // Parent key -> index in grouped
+	add := func(result SearchResult) *SearchResult {
+		if i, exists := parents[result.Key]; exists {
+			existing := &grouped[i]
+			if result.Combined > existing.Combined {
+				existing.TextScore, existing.VecScore, existing.Combined = result.TextScore, result.VecScore, result.Combined
+			}
+			return existing
+		}
+		parents[result.Key] = len(grouped)
+		grouped = append(grouped, result)
+		return &grouped[len(grouped)-1]
+	}
+
+	for _, result := range results {
+		entry := s.data[result.Key]
+		if entry == nil {
+			continue
+		}
+		parent, match, ok := chunkOf(result.Key, entry.hydrate().Value)
+		if !ok {
+			add(result)
+			continue
+		}
+		parentEntry := s.data[parent]
+		if parentEntry == nil {
+			continue
+		}
+		match.Score = result.Combined
+		grouping := SearchResult{Key: parent, TextScore: result.TextScore, VecScore: result.VecScore, Combined: result.Combined}
+		if withValues {
+			grouping.Value = parentEntry.hydrate().Value
+		}
+		added := add(grouping)
+		added.Chunks = append(added.Chunks, match)
+	}
+
+	for i := range grouped {
+		chunks := grouped[i].Chunks
+		sort.SliceStable(chunks, func(a, b int) bool { return chunks[a].Score > chunks[b].Score })
+		if len(chunks) > maxGroupedChunks {
+			grouped[i].Chunks = chunks[:maxGroupedChunks]
+		}
+	}
+	return grouped
+}
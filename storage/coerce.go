@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrCoercion is returned when a field value cannot be converted to its declared type
+var ErrCoercion = errors.New("type coercion failed")
+
+// Coercion types accepted by SetCoercion
+const (
+	CoerceString = "string"
+	CoerceInt    = "int"
+	CoerceFloat  = "float"
+	CoerceBool   = "bool"
+)
+
+// CoerceValue converts value to the given type. Lists are converted element
+// by element and nil is left alone. Booleans accept the YAML 1.1 spellings
+// yes/no, on/off and y/n in addition to true/false.
+func CoerceValue(value interface{}, fieldType string) (interface{}, error) {
+	if list, ok := value.([]interface{}); ok {
+		out := make([]interface{}, len(list))
+		for i, item := range list {
+			v, err := CoerceValue(item, fieldType)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	switch fieldType {
+	case CoerceString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case map[string]interface{}:
+			return nil, fmt.Errorf("%w: cannot convert a map to string", ErrCoercion)
+		default:
+			return fmt.Sprint(v), nil
+		}
+
+	case CoerceInt:
+		f, ok := coerceNumber(value)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("%w: %v is not an integer", ErrCoercion, value)
+		}
+		return int(f), nil
+
+	case CoerceFloat:
+		f, ok := coerceNumber(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v is not a number", ErrCoercion, value)
+		}
+		return f, nil
+
+	case CoerceBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "yes", "y", "on", "1":
+				return true, nil
+			case "false", "no", "n", "off", "0":
+				return false, nil
+			}
+		case int:
+			if v == 0 || v == 1 {
+				return v == 1, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %v is not a boolean", ErrCoercion, value)
+	}
+
+	return nil, fmt.Errorf("unknown coercion type: %s", fieldType)
+}
+
+func coerceNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// SetCoercion declares the type of a top-level field. Values written after
+// the call are converted before they are stored and indexed, so btree and
+// other indexes see consistent types.
+func (s *Store) SetCoercion(field, fieldType string) error {
+	switch fieldType {
+	case CoerceString, CoerceInt, CoerceFloat, CoerceBool:
+	default:
+		return fmt.Errorf("unknown coercion type: %s", fieldType)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.coercions == nil {
+		s.coercions = make(map[string]string)
+	}
+	s.coercions[field] = fieldType
+	s.queryCache.clear() // Filters on the field are coerced differently
+	return nil
+}
+
+// RemoveCoercion removes the declared type of a field
+func (s *Store) RemoveCoercion(field string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.coercions, field)
+	s.queryCache.clear()
+}
+
+// Coercions returns the declared field types
+func (s *Store) Coercions() map[string]string {
+	s.RLock()
+	defer s.RUnlock()
+
+	out := make(map[string]string, len(s.coercions))
+	for field, fieldType := range s.coercions {
+		out[field] = fieldType
+	}
+	return out
+}
+
+// coerce converts the declared fields of a map value. The map is copied
+// before any field is changed. Callers must hold the lock.
+func (s *Store) coerce(value interface{}) (interface{}, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(s.coercions) == 0 {
+		return value, nil
+	}
+
+	fields := make([]string, 0, len(s.coercions))
+	for field := range s.coercions {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var out map[string]interface{}
+	for _, field := range fields {
+		v, exists := m[field]
+		if !exists {
+			continue
+		}
+
+		converted, err := CoerceValue(v, s.coercions[field])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+
+		if out == nil {
+			out = make(map[string]interface{}, len(m))
+			for k, v := range m {
+				out[k] = v
+			}
+		}
+		out[field] = converted
+	}
+
+	if out == nil {
+		return m, nil
+	}
+	return out, nil
+}
+
+// coerceFilters converts filter values on fields with a declared type so
+// they compare equal to the coerced values held by the indexes. The operands
+// of operator filters, such as {"gte": "5"}, are converted one by one.
+// Callers must hold the lock.
+func (s *Store) coerceFilters(filters map[string]interface{}) (map[string]interface{}, error) {
+	if len(s.coercions) == 0 || len(filters) == 0 {
+		return filters, nil
+	}
+
+	out := make(map[string]interface{}, len(filters))
+	for field, v := range filters {
+		if fieldType, ok := s.coercions[field]; ok {
+			var err error
+			if ops, isOps := v.(map[string]interface{}); isOps {
+				converted := make(map[string]interface{}, len(ops))
+				for op, operand := range ops {
+					if converted[op], err = CoerceValue(operand, fieldType); err != nil {
+						break
+					}
+				}
+				v = converted
+			} else {
+				v, err = CoerceValue(v, fieldType)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: filter %s: %v", ErrInvalidQuery, field, err)
+			}
+		}
+		out[field] = v
+	}
+	return out, nil
+}
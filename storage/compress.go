@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// errUncompressible is returned for values holding types compressValue
+// cannot encode; such entries are kept uncompressed
+var errUncompressible = errors.New("value cannot be compressed")
+
+// Type tags of encoded values. FormatBinary data files hold them, so they
+// must not change.
+const (
+	tagNil byte = iota
+	tagFalse
+	tagTrue
+	tagString
+	tagInt
+	tagInt64
+	tagFloat64
+	tagTime
+	tagMap
+	tagSlice
+)
+
+// compress returns the entry to store for entry: with its value compressed
+// when CompressThreshold is set and the value is estimated to hold at least
+// that many bytes in memory. A new value replaces a compressed one. The
+// entry is copied rather than modified. Callers must hold the lock.
+func (s *Store) compress(entry *Entry) *Entry {
+	if entry.Value == nil {
+		return entry // Compressed already, or a null document
+	}
+	if entry.packed != nil {
+		fresh := *entry
+		fresh.packed = nil
+		entry = &fresh
+	}
+	if s.opts.CompressThreshold <= 0 || estimateValueSize(entry.Value) < s.opts.CompressThreshold {
+		return entry
+	}
+
+	packed, err := compressValue(entry.Value)
+	if err != nil {
+		return entry
+	}
+	compressed := *entry
+	compressed.Value = nil
+	compressed.packed = packed
+	return &compressed
+}
+
+// hydrate returns the entry with its value decompressed. Entries that are
+// not compressed are returned as they are.
+func (e *Entry) hydrate() *Entry {
+	if e == nil || e.packed == nil {
+		return e
+	}
+	value, err := decompressValue(e.packed)
+	if err != nil {
+		log.Printf("Failed to decompress entry value: %v", err) // Only corrupted memory gets here
+	}
+	hydrated := *e
+	hydrated.Value = value
+	hydrated.packed = nil
+	return &hydrated
+}
+
+// compressed reports whether the value of the entry is held compressed
+func (e *Entry) compressed() bool {
+	return e.packed != nil
+}
+
+// compressValue encodes a decoded YAML or JSON value and deflates it
+func compressValue(value interface{}) ([]byte, error) {
+	var encoded bytes.Buffer
+	if err := encodeValue(&encoded, value); err != nil {
+		return nil, err
+	}
+
+	var packed bytes.Buffer
+	w, err := flate.NewWriter(&packed, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(encoded.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(packed.Bytes()), nil
+}
+
+// decompressValue reverses compressValue
+func decompressValue(packed []byte) (interface{}, error) {
+	encoded, err := io.ReadAll(flate.NewReader(bytes.NewReader(packed)))
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(encoded)
+	value, err := decodeValue(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("%d trailing bytes after value", r.Len())
+	}
+	return value, nil
+}
+
+// encodeValue writes a value as a type tag followed by its contents,
+// keeping the exact Go types decoding produced so a decompressed value
+// equals the original
+func encodeValue(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(tagNil)
+	case bool:
+		if v {
+			buf.WriteByte(tagTrue)
+		} else {
+			buf.WriteByte(tagFalse)
+		}
+	case string:
+		buf.WriteByte(tagString)
+		writeString(buf, v)
+	case int:
+		buf.WriteByte(tagInt)
+		buf.Write(binary.AppendVarint(nil, int64(v)))
+	case int64:
+		buf.WriteByte(tagInt64)
+		buf.Write(binary.AppendVarint(nil, v))
+	case float64:
+		buf.WriteByte(tagFloat64)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+	case time.Time:
+		text, err := v.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(tagTime)
+		writeString(buf, string(text))
+	case map[string]interface{}:
+		buf.WriteByte(tagMap)
+		buf.Write(binary.AppendUvarint(nil, uint64(len(v))))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // Equal values compress to equal bytes
+		for _, k := range keys {
+			writeString(buf, k)
+			if err := encodeValue(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		buf.WriteByte(tagSlice)
+		buf.Write(binary.AppendUvarint(nil, uint64(len(v))))
+		for _, item := range v {
+			if err := encodeValue(buf, item); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: %T", errUncompressible, value)
+	}
+	return nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	buf.WriteString(s)
+}
+
+// decodeValue reads a value written by encodeValue
+func decodeValue(r *bytes.Reader) (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case tagNil:
+		return nil, nil
+	case tagFalse:
+		return false, nil
+	case tagTrue:
+		return true, nil
+	case tagString:
+		return readString(r)
+	case tagInt:
+		n, err := binary.ReadVarint(r)
+		return int(n), err
+	case tagInt64:
+		return binary.ReadVarint(r)
+	case tagFloat64:
+		var bits [8]byte
+		if _, err := io.ReadFull(r, bits[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(bits[:])), nil
+	case tagTime:
+		text, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		var t time.Time
+		err = t.UnmarshalBinary([]byte(text))
+		return t, err
+	case tagMap:
+		n, err := readLength(r)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for range n {
+			k, err := readString(r)
+			if err != nil {
+				return nil, err
+			}
+			if m[k], err = decodeValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case tagSlice:
+		n, err := readLength(r)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = decodeValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown value tag %d", tag)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return "", err
+	}
+	s := make([]byte, n)
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// readLength reads a length, which cannot exceed the bytes left
+func readLength(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, fmt.Errorf("length %d exceeds the %d bytes left", n, r.Len())
+	}
+	return int(n), nil
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Conflict resolution policies for entries written by replication, such as
+// anti-entropy syncs, to keys whose local copy differs
+const (
+	ConflictLastWriterWins = "lww"     // Keep the entry written last
+	ConflictHighestVersion = "version" // Keep the entry with the higher version field
+	ConflictMerge          = "merge"   // Merge map values, newer fields winning
+)
+
+// DefaultVersionField is the field compared by ConflictHighestVersion when
+// StoreOptions.VersionField is empty
+const DefaultVersionField = "version"
+
+// Resolutions of a conflict
+const (
+	ResolvedLocal  = "local"  // The local entry was kept
+	ResolvedRemote = "remote" // The incoming entry replaced it
+	ResolvedMerged = "merged" // Both were merged into a new entry
+)
+
+// recentConflicts is the number of conflicts kept for the report
+const recentConflicts = 100
+
+// MergeFunc merges the map values of two conflicting entries, older being
+// the value of the entry written first. It must not modify its arguments.
+// Merging the result with either input again must return the result, or
+// replicas merging in a different order do not converge.
+type MergeFunc func(key string, older, newer map[string]interface{}) map[string]interface{}
+
+// MergeMaps is the default MergeFunc. Fields of newer replace those of
+// older, except that fields holding maps on both sides are merged
+// recursively; fields only in older are kept.
+func MergeMaps(key string, older, newer map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(older)+len(newer))
+	for k, v := range older {
+		merged[k] = v
+	}
+	for k, v := range newer {
+		oldMap, oldOK := merged[k].(map[string]interface{})
+		newMap, newOK := v.(map[string]interface{})
+		if oldOK && newOK {
+			merged[k] = MergeMaps(key, oldMap, newMap)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// Conflict is an incoming entry that differed from the local one
+type Conflict struct {
+	Time            time.Time `json:"time" yaml:"time"`
+	Key             string    `json:"key" yaml:"key"`
+	Policy          string    `json:"policy" yaml:"policy"`
+	Resolution      string    `json:"resolution" yaml:"resolution"`
+	LocalTimestamp  int64     `json:"local_timestamp" yaml:"local_timestamp"`
+	RemoteTimestamp int64     `json:"remote_timestamp" yaml:"remote_timestamp"`
+}
+
+// ConflictReport summarizes conflicts since startup or the last reset
+type ConflictReport struct {
+	Policy       string            `json:"policy" yaml:"policy"`
+	Total        uint64            `json:"total" yaml:"total"`
+	ByResolution map[string]uint64 `json:"by_resolution" yaml:"by_resolution"`
+	Recent       []Conflict        `json:"recent" yaml:"recent"` // Newest first
+}
+
+// conflictLog counts conflicts and keeps the most recent ones
+type conflictLog struct {
+	sync.Mutex
+	total        uint64
+	byResolution map[string]uint64
+	recent       []Conflict
+}
+
+func (l *conflictLog) add(c Conflict) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.byResolution == nil {
+		l.byResolution = make(map[string]uint64)
+	}
+	l.total++
+	l.byResolution[c.Resolution]++
+	l.recent = append(l.recent, c)
+	if over := len(l.recent) - recentConflicts; over > 0 {
+		l.recent = append(l.recent[:0], l.recent[over:]...)
+	}
+}
+
+// checkConflictPolicy validates the conflict options
+func checkConflictPolicy(opts StoreOptions) error {
+	switch opts.ConflictPolicy {
+	case "", ConflictLastWriterWins, ConflictHighestVersion, ConflictMerge:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy %q: use %s, %s or %s",
+		opts.ConflictPolicy, ConflictLastWriterWins, ConflictHighestVersion, ConflictMerge)
+}
+
+func (s *Store) conflictPolicy() string {
+	if s.opts.ConflictPolicy == "" {
+		return ConflictLastWriterWins
+	}
+	return s.opts.ConflictPolicy
+}
+
+// resolveConflict picks the entry to keep of a local entry and a differing
+// incoming one. Every policy falls back to last-writer-wins when it cannot
+// decide, so replicas with the same policy settle on the same entry
+// whichever of them resolves the conflict.
+func (s *Store) resolveConflict(key string, local, incoming *Entry) (*Entry, string) {
+	switch s.conflictPolicy() {
+	case ConflictHighestVersion:
+		field := s.opts.VersionField
+		if field == "" {
+			field = DefaultVersionField
+		}
+		localVersion, localOK := entryVersion(local, field)
+		incomingVersion, incomingOK := entryVersion(incoming, field)
+		if localOK && incomingOK && localVersion != incomingVersion {
+			if incomingVersion > localVersion {
+				return incoming, ResolvedRemote
+			}
+			return local, ResolvedLocal
+		}
+
+	case ConflictMerge:
+		localMap, localOK := local.Value.(map[string]interface{})
+		incomingMap, incomingOK := incoming.Value.(map[string]interface{})
+		if localOK && incomingOK {
+			merge := s.opts.Merge
+			if merge == nil {
+				merge = MergeMaps
+			}
+			older, newer := localMap, incomingMap
+			latest := incoming
+			if newerEntry(key, local, incoming) {
+				older, newer = incomingMap, localMap
+				latest = local
+			}
+			merged := &Entry{Value: merge(key, older, newer), Timestamp: latest.Timestamp, TTL: latest.TTL, Metadata: latest.Metadata, Sliding: latest.Sliding}
+
+			switch EntryDigest(key, merged) {
+			case EntryDigest(key, local):
+				return local, ResolvedLocal
+			case EntryDigest(key, incoming):
+				return incoming, ResolvedRemote
+			}
+			return merged, ResolvedMerged
+		}
+	}
+
+	if newerEntry(key, incoming, local) {
+		return incoming, ResolvedRemote
+	}
+	return local, ResolvedLocal
+}
+
+// entryVersion returns the numeric version field of a map value
+func entryVersion(entry *Entry, field string) (float64, bool) {
+	m, ok := entry.Value.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	return coerceNumber(m[field])
+}
+
+// Conflicts returns the conflict report
+func (s *Store) Conflicts() ConflictReport {
+	l := &s.conflicts
+	l.Lock()
+	defer l.Unlock()
+
+	report := ConflictReport{
+		Policy:       s.conflictPolicy(),
+		Total:        l.total,
+		ByResolution: make(map[string]uint64, len(l.byResolution)),
+		Recent:       make([]Conflict, len(l.recent)),
+	}
+	for resolution, count := range l.byResolution {
+		report.ByResolution[resolution] = count
+	}
+	copy(report.Recent, l.recent)
+	sort.SliceStable(report.Recent, func(i, j int) bool {
+		return report.Recent[i].Time.After(report.Recent[j].Time)
+	})
+	return report
+}
+
+// ResetConflicts clears the conflict report
+func (s *Store) ResetConflicts() {
+	l := &s.conflicts
+	l.Lock()
+	defer l.Unlock()
+
+	l.total = 0
+	l.byResolution = nil
+	l.recent = nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrKeyExists is returned when a copy or rename would replace an existing
+// key without CopyOptions.Overwrite
+var ErrKeyExists = errors.New("key exists")
+
+// CopyOptions control the entry Copy and Rename write
+type CopyOptions struct {
+	Overwrite     bool      // Replace an existing destination instead of failing with ErrKeyExists
+	ResetTTL      bool      // The destination never expires instead of keeping the expiry of the source
+	ResetMetadata bool      // The destination gets Metadata instead of the metadata of the source
+	Metadata      *Metadata // Metadata of the destination with ResetMetadata, may be nil
+}
+
+// Copy writes the entry of src to dst as well, under one lock so the
+// indexes of dst match the copied value
+func (s *Store) Copy(src, dst string, opts CopyOptions) error {
+	return s.copyEntry(src, dst, opts, false)
+}
+
+// Rename moves the entry of src to dst, under one lock so no reader sees
+// both keys or neither
+func (s *Store) Rename(src, dst string, opts CopyOptions) error {
+	return s.copyEntry(src, dst, opts, true)
+}
+
+// copyEntry writes the entry of src to dst and, with move, deletes src
+func (s *Store) copyEntry(src, dst string, opts CopyOptions, move bool) error {
+	if err := s.checkKey(dst); err != nil {
+		return err
+	}
+	if src == dst {
+		return fmt.Errorf("%w: source and destination are both %s", ErrInvalidKey, src)
+	}
+
+	start := time.Now()
+	defer func() {
+		s.updateWriteStats(time.Since(start))
+	}()
+
+	s.Lock()
+	defer s.Unlock()
+
+	// A rename adds no entry, so only a copy counts against MaxEntries
+	if move && s.opts.ReadOnly {
+		return ErrReadOnly
+	} else if !move {
+		if err := s.checkQuota(dst); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().Unix()
+	entry, exists := s.data[src]
+	if !exists || entry.expired(now) {
+		return fmt.Errorf("%w: key %s", ErrNotFound, src)
+	}
+	if old, exists := s.data[dst]; exists && !old.expired(now) && !opts.Overwrite {
+		return fmt.Errorf("%w: %s", ErrKeyExists, dst)
+	}
+
+	copied := *entry
+	copied.Timestamp = now
+	copied.TTL = entry.ttlAt(now)
+	if opts.ResetTTL {
+		copied.TTL, copied.Sliding = 0, 0
+	}
+	if opts.ResetMetadata {
+		copied.Metadata = nil
+		if !opts.Metadata.empty() {
+			copied.Metadata = opts.Metadata
+		}
+	}
+	if !move {
+		if err := s.enforceLimits(dst, entry.hydrate().Value); err != nil {
+			return err
+		}
+	}
+	s.setEntry(dst, &copied)
+	s.updateIndexes(dst, entry.hydrate().Value)
+
+	if move {
+		s.deleteEntry(src)
+		s.counters.deletes.Add(1)
+	}
+	s.dirty = true
+	return nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+// Count returns the number of documents matching a query. Unlike Search it
+// neither sorts the matches nor reads their values, unless the query has a
+// filter expression to evaluate. max_results still bounds the readable
+// candidates of each text and vector index, but the count is not truncated
+// to it. Keys for which readable returns false are not counted; a nil
+// readable counts every key.
+func (s *Store) Count(query SearchQuery, readable func(key string) bool) (int, error) {
+	return s.count(query, readable, false)
+}
+
+// Exists reports whether any document matches a query, stopping at the
+// first match
+func (s *Store) Exists(query SearchQuery, readable func(key string) bool) (bool, error) {
+	n, err := s.count(query, readable, true)
+	return n > 0, err
+}
+
+func (s *Store) count(query SearchQuery, readable func(key string) bool, first bool) (int, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	n := 0
+	err := s.eachMatch(query, readable, func(string) bool {
+		n++
+		return !first
+	})
+	return n, err
+}
+
+// eachMatch calls fn with the readable keys of the documents matching a
+// query, in no particular order, until fn returns false. Values are only
+// read to evaluate a filter expression. Callers must hold the lock.
+func (s *Store) eachMatch(query SearchQuery, readable func(key string) bool, fn func(key string) bool) error {
+	scripts, err := compileQueryScripts(query)
+	if err != nil {
+		return err
+	}
+	scoring, err := lookupScoring(query)
+	if err != nil {
+		return err
+	}
+	trace := newQueryTrace()
+	scores, err := s.matchLocked(query, readable, scripts, scoring, trace)
+	if err != nil {
+		return err
+	}
+	s.recordIndexUsage(query, trace)
+
+	for key, result := range scores {
+		entry, exists := s.data[key]
+		if !exists || (readable != nil && !readable(key)) {
+			continue
+		}
+		if scripts != nil {
+			result.Value = entry.hydrate().Value
+			if len(scripts.apply([]SearchResult{*result})) == 0 {
+				continue
+			}
+		}
+		if !fn(key) {
+			break
+		}
+	}
+	return nil
+}
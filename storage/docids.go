@@ -0,0 +1,119 @@
+package storage
+
+import "sync"
+
+// docIDs is a dictionary between keys and internal document IDs. Indexes
+// hold the 4-byte IDs instead of repeating each key in every posting list,
+// leaf and sorted array, and translate back to keys only for results. The
+// indexes of an IndexManager share one dictionary, so the matches of
+// different indexes are bitmaps over the same IDs and intersect directly.
+// IDs of deleted keys are reused, keeping the IDs dense and the bitmap
+// containers full; an ID is stable for as long as its key is indexed.
+type docIDs struct {
+	sync.RWMutex
+	ids  map[string]uint32 // key -> ID
+	keys []string          // ID -> key
+	free []uint32          // released IDs
+}
+
+func newDocIDs() *docIDs {
+	return &docIDs{ids: make(map[string]uint32)}
+}
+
+// assign returns the ID of key, assigning one if it has none
+func (d *docIDs) assign(key string) uint32 {
+	d.Lock()
+	defer d.Unlock()
+
+	if id, exists := d.ids[key]; exists {
+		return id
+	}
+	var id uint32
+	if n := len(d.free); n > 0 {
+		id = d.free[n-1]
+		d.free = d.free[:n-1]
+		d.keys[id] = key
+	} else {
+		id = uint32(len(d.keys))
+		d.keys = append(d.keys, key)
+	}
+	d.ids[key] = id
+	return id
+}
+
+// lookup returns the ID of key, if it has one
+func (d *docIDs) lookup(key string) (uint32, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	id, exists := d.ids[key]
+	return id, exists
+}
+
+// key returns the key of an assigned ID
+func (d *docIDs) key(id uint32) string {
+	d.RLock()
+	defer d.RUnlock()
+	return d.keys[id]
+}
+
+// Len returns the number of keys with an ID
+func (d *docIDs) Len() int {
+	d.RLock()
+	defer d.RUnlock()
+	return len(d.ids)
+}
+
+// release frees the ID of key for reuse. The key must have been removed
+// from every index holding its ID, or the next key assigned it would take
+// over those entries.
+func (d *docIDs) release(key string) {
+	d.Lock()
+	defer d.Unlock()
+
+	id, exists := d.ids[key]
+	if !exists {
+		return
+	}
+	delete(d.ids, key)
+	d.keys[id] = ""
+	d.free = append(d.free, id)
+}
+
+// keysOf returns the keys of the IDs in b, in ID order
+func (d *docIDs) keysOf(b *Bitmap) []string {
+	d.RLock()
+	defer d.RUnlock()
+
+	keys := make([]string, 0, b.Len())
+	b.Each(func(id uint32) bool {
+		keys = append(keys, d.keys[id])
+		return true
+	})
+	return keys
+}
+
+// bitmapOf returns the IDs of keys, leaving out keys without one
+func (d *docIDs) bitmapOf(keys []string) *Bitmap {
+	d.RLock()
+	defer d.RUnlock()
+
+	b := NewBitmap()
+	for _, key := range keys {
+		if id, exists := d.ids[key]; exists {
+			b.Add(id)
+		}
+	}
+	return b
+}
+
+// memoryUsage estimates the size of the dictionary
+func (d *docIDs) memoryUsage() int64 {
+	d.RLock()
+	defer d.RUnlock()
+
+	size := int64(mapHeaderSize + 2*sliceHeaderSize)
+	for key := range d.ids {
+		size += stringHeaderSize + int64(len(key)) + mapEntryOverhead + 4
+	}
+	return size + int64(len(d.keys))*stringHeaderSize + int64(cap(d.free))*4
+}
@@ -3,12 +3,17 @@ package storage
 import (
 	"bytes"
 	"gopkg.in/yaml.v3"
+	"io"
 	"sync"
 )
 
 // FastYAMLEncoder provides optimized YAML encoding with buffer pooling
 type FastYAMLEncoder struct {
 	pool *sync.Pool
+
+	// Strict rejects fields that do not exist in the target struct when
+	// decoding, instead of ignoring them
+	Strict bool
 }
 
 // NewFastYAMLEncoder creates a new encoder with initialized buffer pool
@@ -22,7 +27,8 @@ func NewFastYAMLEncoder() *FastYAMLEncoder {
 	}
 }
 
-// Encode performs YAML encoding with buffer reuse
+// Encode performs YAML encoding with buffer reuse. The returned slice is a
+// copy and stays valid after the buffer is returned to the pool.
 func (f *FastYAMLEncoder) Encode(v interface{}) ([]byte, error) {
 	buf := f.pool.Get().(*bytes.Buffer)
 	defer func() {
@@ -30,20 +36,26 @@ func (f *FastYAMLEncoder) Encode(v interface{}) ([]byte, error) {
 		f.pool.Put(buf)
 	}()
 
-	encoder := yaml.NewEncoder(buf)
+	if err := f.EncodeTo(buf, v); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// EncodeTo streams the YAML encoding of v to w
+func (f *FastYAMLEncoder) EncodeTo(w io.Writer, v interface{}) error {
+	encoder := yaml.NewEncoder(w)
 	encoder.SetIndent(2)
 
 	if err := encoder.Encode(v); err != nil {
-		return nil, err
+		return err
 	}
-
-	encoder.Close()
-	return buf.Bytes(), nil
+	return encoder.Close()
 }
 
 // Decode performs YAML decoding with validation
 func (f *FastYAMLEncoder) Decode(data []byte, v interface{}) error {
 	decoder := yaml.NewDecoder(bytes.NewReader(data))
-	decoder.KnownFields(true)
+	decoder.KnownFields(f.Strict)
 	return decoder.Decode(v)
 }
@@ -0,0 +1,42 @@
+package storage
+
+import "testing"
+
+// FuzzFastYAMLEncoderDecode decodes arbitrary documents that pass the decode
+// limits, as callers check them first, and re-encodes what decoded
+func FuzzFastYAMLEncoderDecode(f *testing.F) {
+	f.Add([]byte("key: value\nlist: [1, 2.5, true, null]\n"))
+	f.Add([]byte("a: &x {b: c}\nd: *x\n<<: *x\n"))
+	f.Add([]byte("--- !!binary aGVsbG8=\n"))
+	f.Add([]byte("{\"json\": [\"also\", \"yaml\"]}"))
+
+	limits := DefaultDecodeLimits
+	limits.Timeout = 0
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := limits.Parse(data); err != nil {
+			return
+		}
+
+		for _, strict := range []bool{false, true} {
+			encoder := NewFastYAMLEncoder()
+			encoder.Strict = strict
+
+			var entry Entry
+			_ = encoder.Decode(data, &entry)
+
+			var value interface{}
+			if err := encoder.Decode(data, &value); err != nil {
+				continue
+			}
+			encoded, err := encoder.Encode(value)
+			if err != nil {
+				// yaml.v3 cannot encode every map key it decodes
+				continue
+			}
+			var again interface{}
+			if err := encoder.Decode(encoded, &again); err != nil {
+				t.Fatalf("re-decoding %q: %v", encoded, err)
+			}
+		}
+	})
+}
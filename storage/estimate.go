@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// DefaultEstimateSample is the number of documents EstimateIndex samples
+// when the request does not say
+const DefaultEstimateSample = 1000
+
+// MaxEstimateSample is the most documents EstimateIndex samples
+const MaxEstimateSample = 100000
+
+// IndexEstimateRequest asks what an index would cost before creating it
+type IndexEstimateRequest struct {
+	Field      string   `json:"field"`
+	Type       string   `json:"type"` // btree, vector, text, ip, keyword or numeric
+	Tokenizer  string   `json:"tokenizer,omitempty"`
+	IgnoreCase bool     `json:"ignore_case,omitempty"`
+	Dims       int      `json:"dims,omitempty"`
+	Payload    []string `json:"payload,omitempty"`
+	Sample     int      `json:"sample,omitempty"` // Documents sampled, default DefaultEstimateSample
+}
+
+// IndexEstimate predicts the size and build time of an index from a sample
+// of the documents, extrapolated linearly to all of them
+type IndexEstimate struct {
+	Field     string  `json:"field" yaml:"field"`
+	Type      string  `json:"type" yaml:"type"`
+	Exists    bool    `json:"exists" yaml:"exists"` // The index exists already; the estimate is of building it again
+	Documents int     `json:"documents" yaml:"documents"`
+	Sampled   int     `json:"sampled" yaml:"sampled"`
+	Coverage  float64 `json:"coverage" yaml:"coverage"` // Fraction of the sampled documents holding the field
+	Rejected  float64 `json:"rejected" yaml:"rejected"` // Fraction of the sampled values the index would reject, see IndexErrors
+	Entries   int     `json:"entries" yaml:"entries"`   // Documents the index would hold
+	Bytes     int64   `json:"bytes" yaml:"bytes"`       // Memory, estimated like MemoryUsage
+	BuildTime float64 `json:"build_time_ms" yaml:"build_time_ms"`
+}
+
+// EstimateIndex builds the requested index over a random sample of the
+// documents, apart from the store, and extrapolates its size and build
+// time to every document. The store is only read.
+func (s *Store) EstimateIndex(req IndexEstimateRequest) (*IndexEstimate, error) {
+	if req.Field == "" {
+		return nil, fmt.Errorf("%w: estimates need a field", ErrInvalidIndex)
+	}
+	if req.Sample < 0 || req.Sample > MaxEstimateSample {
+		return nil, fmt.Errorf("%w: sample must be from 1 to %d", ErrInvalidQuery, MaxEstimateSample)
+	}
+	n := req.Sample
+	if n == 0 {
+		n = DefaultEstimateSample
+	}
+
+	im := NewIndexManager()
+	if err := im.AddIndexWithOptions(req.Field, req.Type, IndexOptions{Tokenizer: req.Tokenizer, IgnoreCase: req.IgnoreCase, Dims: req.Dims, Payload: req.Payload}); err != nil {
+		return nil, err
+	}
+
+	// Reservoir sampling, so every document is as likely to be picked
+	s.RLock()
+	now := time.Now().Unix()
+	sample := make([]string, 0, n)
+	seen := 0
+	for key, entry := range s.data {
+		if entry.expired(now) {
+			continue
+		}
+		seen++
+		if len(sample) < n {
+			sample = append(sample, key)
+		} else if i := rand.IntN(seen); i < n {
+			sample[i] = key
+		}
+	}
+	values := make([]interface{}, len(sample))
+	for i, key := range sample {
+		values[i] = s.data[key].hydrate().Value
+	}
+	s.RUnlock()
+
+	estimate := &IndexEstimate{
+		Field:     req.Field,
+		Type:      req.Type,
+		Exists:    s.indexes.HasIndex(req.Field, req.Type),
+		Documents: seen,
+		Sampled:   len(sample),
+	}
+	if len(sample) == 0 {
+		return estimate, nil
+	}
+
+	holding, rejected := 0, 0
+	start := time.Now()
+	for i, value := range values {
+		if m, ok := value.(map[string]interface{}); ok {
+			if _, exists := m[req.Field]; exists {
+				holding++
+			}
+		}
+		if err := im.Update(sample[i], value); err != nil {
+			rejected++
+		}
+	}
+	elapsed := time.Since(start)
+
+	var entries int
+	var bytes int64
+	for _, m := range im.memoryUsage() {
+		if m.Field == req.Field {
+			entries, bytes = m.Entries, m.Bytes
+		}
+	}
+	scale := float64(seen) / float64(len(sample))
+	estimate.Coverage = float64(holding) / float64(len(sample))
+	if holding > 0 {
+		estimate.Rejected = float64(rejected) / float64(holding)
+	}
+	estimate.Entries = int(float64(entries)*scale + 0.5)
+	estimate.Bytes = int64(float64(bytes) * scale)
+	estimate.BuildTime = milliseconds(elapsed) * scale
+	return estimate, nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import "time"
+
+// expireQueueSize bounds the expired keys waiting for deletion. Keys found
+// expired while the queue is full are left to the next GC sweep.
+const expireQueueSize = 1024
+
+// expireBatch is the most queued keys deleted under one lock
+const expireBatch = 128
+
+// expireLazily queues an expired key found by a read for deletion, without
+// blocking the read
+func (s *Store) expireLazily(key string) {
+	if s.opts.ReadOnly {
+		return
+	}
+	select {
+	case s.expireQueue <- key:
+	default:
+	}
+}
+
+// expireWorker deletes the keys queued by expireLazily, taking the write
+// lock once per batch rather than once per read
+func (s *Store) expireWorker() {
+	batch := make([]string, 0, expireBatch)
+	for {
+		var key string
+		select {
+		case key = <-s.expireQueue:
+		case <-s.stop:
+			return
+		}
+		batch = append(batch[:0], key)
+	drain:
+		for len(batch) < expireBatch {
+			select {
+			case key := <-s.expireQueue:
+				batch = append(batch, key)
+			default:
+				break drain
+			}
+		}
+		s.expireKeys(batch)
+	}
+}
+
+// expireKeys deletes those of keys that are still expired; a key may have
+// been written again, or already deleted, since it was queued
+func (s *Store) expireKeys(keys []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now().Unix()
+	var expired uint64
+	for _, key := range keys {
+		if entry, exists := s.data[key]; exists && entry.expired(now) {
+			s.deleteEntry(key)
+			expired++
+		}
+	}
+	if expired > 0 {
+		s.dirty = true
+		s.counters.lazyExpirations.Add(expired)
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"time"
+)
+
+// QueryStage describes one stage of a search: the time it took, the results
+// it produced and the work it did
+type QueryStage struct {
+	Stage    string  `json:"stage" yaml:"stage"`                     // text, vector, filter, prefix, combine, script, score, sample or sort
+	Index    string  `json:"index,omitempty" yaml:"index,omitempty"` // Field of the index searched
+	Duration float64 `json:"duration" yaml:"duration"`               // in milliseconds
+	Results  int     `json:"results" yaml:"results"`
+
+	Trigrams   int            `json:"trigrams,omitempty" yaml:"trigrams,omitempty"`     // Terms the text index's tokenizer made of the query text, trigrams by default
+	IOCs       int            `json:"iocs,omitempty" yaml:"iocs,omitempty"`             // Indicators extracted from the query text
+	Postings   int            `json:"postings,omitempty" yaml:"postings,omitempty"`     // Posting list entries scanned
+	Matched    int            `json:"matched,omitempty" yaml:"matched,omitempty"`       // Documents matched before min_score and max_results
+	Compared   int            `json:"compared,omitempty" yaml:"compared,omitempty"`     // Vectors compared with the query
+	Skipped    int            `json:"skipped,omitempty" yaml:"skipped,omitempty"`       // Vectors whose payload did not match the filters
+	Failed     int            `json:"failed,omitempty" yaml:"failed,omitempty"`         // Results the scorer failed on, which kept their score
+	Candidates map[string]int `json:"candidates,omitempty" yaml:"candidates,omitempty"` // Keys matching each filter field
+}
+
+// Explanation describes how a search was executed
+type Explanation struct {
+	Duration float64      `json:"duration" yaml:"duration"`             // in milliseconds
+	Plan     *QueryPlan   `json:"plan,omitempty" yaml:"plan,omitempty"` // Stage order chosen by the planner
+	Stages   []QueryStage `json:"stages" yaml:"stages"`
+	Results  int          `json:"results" yaml:"results"`
+}
+
+// Explain runs a search like SearchReadable and also returns a breakdown of
+// its stages
+func (s *Store) Explain(query SearchQuery, readable func(key string) bool) ([]SearchResult, *Explanation, error) {
+	results, trace, err := s.search(query, readable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return results, &Explanation{
+		Duration: milliseconds(time.Since(trace.start)),
+		Plan:     trace.plan,
+		Stages:   trace.stages,
+		Results:  len(results),
+	}, nil
+}
+
+// queryTrace records the stages of a single search
+type queryTrace struct {
+	start  time.Time
+	stages []QueryStage
+	plan   *QueryPlan
+}
+
+func newQueryTrace() *queryTrace {
+	return &queryTrace{start: time.Now()}
+}
+
+// record completes a stage that began at start and adds it to the trace
+func (t *queryTrace) record(stage QueryStage, start time.Time, results int) {
+	stage.Duration = milliseconds(time.Since(start))
+	stage.Results = results
+	t.stages = append(t.stages, stage)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
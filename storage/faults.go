@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Fault injection lets integration tests verify how the store and its
+// clients recover from failures. It is compiled in only with the chaos build
+// tag (go build -tags chaos); otherwise the injection points are no-ops.
+
+// ErrInjectedFault is returned by operations failed by an injected fault
+var ErrInjectedFault = errors.New("injected fault")
+
+// ErrFaultsDisabled is returned when configuring faults in a build without
+// the chaos tag
+var ErrFaultsDisabled = errors.New("fault injection is not compiled in; build with -tags chaos")
+
+// FaultPoint names a place where faults can be injected
+type FaultPoint string
+
+const (
+	// FaultSync delays or fails syncs of the data file
+	FaultSync FaultPoint = "sync"
+	// FaultIndex drops index updates of writes, leaving the indexes stale
+	FaultIndex FaultPoint = "index"
+	// FaultFlush delays or fails flushing written data to disk
+	FaultFlush FaultPoint = "flush"
+)
+
+// Fault configures injection at one point
+type Fault struct {
+	Point FaultPoint    `json:"point" yaml:"point"`
+	Delay time.Duration `json:"delay" yaml:"delay"` // Wait before the operation
+	// Fail fails sync and flush with ErrInjectedFault and drops index updates
+	Fail bool `json:"fail" yaml:"fail"`
+	// Probability is the chance each operation is affected; 0 means always
+	Probability float64 `json:"probability" yaml:"probability"`
+	// Count stops the fault after affecting this many operations; 0 means
+	// no limit
+	Count     int `json:"count" yaml:"count"`
+	Triggered int `json:"triggered" yaml:"triggered"`
+}
+
+func (f *Fault) validate() error {
+	switch f.Point {
+	case FaultSync, FaultIndex, FaultFlush:
+	default:
+		return fmt.Errorf("unknown fault point %q", f.Point)
+	}
+	if f.Delay < 0 || f.Probability < 0 || f.Probability > 1 || f.Count < 0 {
+		return fmt.Errorf("invalid %s fault: delay and count must not be negative and probability must be between 0 and 1", f.Point)
+	}
+	if f.Delay == 0 && !f.Fail {
+		return fmt.Errorf("invalid %s fault: set a delay or fail", f.Point)
+	}
+	return nil
+}
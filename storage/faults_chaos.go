@@ -0,0 +1,82 @@
+//go:build chaos
+
+package storage
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FaultsEnabled reports whether fault injection is compiled in
+const FaultsEnabled = true
+
+var faults struct {
+	sync.Mutex
+	points map[FaultPoint]*Fault
+}
+
+// SetFault installs a fault, replacing any fault at the same point
+func SetFault(f Fault) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	f.Triggered = 0
+
+	faults.Lock()
+	defer faults.Unlock()
+	if faults.points == nil {
+		faults.points = make(map[FaultPoint]*Fault)
+	}
+	faults.points[f.Point] = &f
+	return nil
+}
+
+// ClearFault removes the fault at a point
+func ClearFault(point FaultPoint) {
+	faults.Lock()
+	delete(faults.points, point)
+	faults.Unlock()
+}
+
+// ClearFaults removes every fault
+func ClearFaults() {
+	faults.Lock()
+	faults.points = nil
+	faults.Unlock()
+}
+
+// Faults returns the installed faults ordered by point
+func Faults() []Fault {
+	faults.Lock()
+	defer faults.Unlock()
+
+	list := make([]Fault, 0, len(faults.points))
+	for _, f := range faults.points {
+		list = append(list, *f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Point < list[j].Point })
+	return list
+}
+
+// injectFault applies the fault at a point, if one is installed and due,
+// returning ErrInjectedFault when the operation should fail
+func injectFault(point FaultPoint) error {
+	faults.Lock()
+	f := faults.points[point]
+	if f == nil || (f.Count > 0 && f.Triggered >= f.Count) ||
+		(f.Probability > 0 && rand.Float64() >= f.Probability) {
+		faults.Unlock()
+		return nil
+	}
+	f.Triggered++
+	delay, fail := f.Delay, f.Fail
+	faults.Unlock()
+
+	time.Sleep(delay)
+	if fail {
+		return ErrInjectedFault
+	}
+	return nil
+}
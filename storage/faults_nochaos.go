@@ -0,0 +1,26 @@
+//go:build !chaos
+
+package storage
+
+// FaultsEnabled reports whether fault injection is compiled in
+const FaultsEnabled = false
+
+// SetFault returns ErrFaultsDisabled without the chaos build tag
+func SetFault(f Fault) error {
+	return ErrFaultsDisabled
+}
+
+// ClearFault does nothing without the chaos build tag
+func ClearFault(point FaultPoint) {}
+
+// ClearFaults does nothing without the chaos build tag
+func ClearFaults() {}
+
+// Faults returns no faults without the chaos build tag
+func Faults() []Fault {
+	return nil
+}
+
+func injectFault(FaultPoint) error {
+	return nil
+}
@@ -0,0 +1,11 @@
+package storage
+
+import (
+	"errors"
+)
+
+// ErrFileInUse is returned when another process holds the data file lock
+var ErrFileInUse = errors.New("data file is in use by another process")
+
+// ErrReadOnly is returned by writes to a store opened with ReadOnly
+var ErrReadOnly = errors.New("store is read-only")
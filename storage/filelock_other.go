@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package storage
+
+import (
+	"os"
+)
+
+// lockFile does nothing on platforms without file locking
+func lockFile(file *os.File, shared bool) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory lock on the data file: exclusive for writers,
+// shared for read-only stores. The lock is released when the file is closed.
+func lockFile(file *os.File, shared bool) error {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return fmt.Errorf("%w: %s", ErrFileInUse, file.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock %s: %v", file.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes a lock on the data file: exclusive for writers, shared for
+// read-only stores. Windows byte-range locks are mandatory, so the locked
+// byte lies far beyond any data the store reads or writes. The lock is
+// released when the file is closed.
+func lockFile(file *os.File, shared bool) error {
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if !shared {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+
+	overlapped := &windows.Overlapped{Offset: 0xFFFFFFFF, OffsetHigh: 0x7FFFFFFF}
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, overlapped)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return fmt.Errorf("%w: %s", ErrFileInUse, file.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock %s: %v", file.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// Data file format versions. The version is recorded in a header comment so
+// future layouts can be recognized, and MigrateFile upgrades older files.
+const (
+	// FormatLegacy is the unversioned layout: a single YAML mapping of keys
+	// to entries
+	FormatLegacy = 1
+	// FormatSegmented starts with a version header followed by YAML
+	// mappings of consecutive sorted keys, separated by document markers,
+	// which are decoded concurrently on load
+	FormatSegmented = 2
+	// FormatBinary starts with the version header followed by the entries
+	// in a binary codec, see format_binary.go, which is smaller and faster
+	// to decode than YAML
+	FormatBinary = 3
+
+	// CurrentFormat is the format of new data files and the target of
+	// migrations
+	CurrentFormat = FormatBinary
+)
+
+// formatHeader starts the first line of versioned data files
+const formatHeader = "# searchyaml-format: "
+
+// segmentEntries is the number of entries per segment. It is fixed, rather
+// than derived from the CPU count, so the same data always encodes the same.
+const segmentEntries = 4096
+
+// segmentSeparator separates segments; the encoder indents block scalars,
+// so the marker cannot start a line inside a value
+var segmentSeparator = []byte("---\n")
+
+// segmentBoundary finds a separator at the start of a line
+var segmentBoundary = []byte("\n---\n")
+
+// ErrUnsupportedFormat is returned for data files written by a newer version
+var ErrUnsupportedFormat = errors.New("unsupported data file format")
+
+// FormatVersion returns the format version of encoded data and the data
+// following the version header
+func FormatVersion(content []byte) (int, []byte, error) {
+	if !bytes.HasPrefix(content, []byte(formatHeader)) {
+		return FormatLegacy, content, nil
+	}
+	line, rest, _ := bytes.Cut(content[len(formatHeader):], []byte("\n"))
+	version, err := strconv.Atoi(string(bytes.TrimSpace(line)))
+	if err != nil || version < FormatSegmented {
+		return 0, nil, fmt.Errorf("%w: bad header %q", ErrUnsupportedFormat, formatHeader+string(line))
+	}
+	if version > CurrentFormat {
+		return 0, nil, fmt.Errorf("%w: version %d is newer than this build supports (%d); upgrade searchyaml",
+			ErrUnsupportedFormat, version, CurrentFormat)
+	}
+	return version, rest, nil
+}
+
+// dataEnd returns the length of the data at the start of content, which the
+// mmap persister pads with NUL bytes. Binary data holds NUL bytes of its
+// own, so its records are followed to the terminator instead.
+func dataEnd(content []byte) int {
+	if version, body, err := FormatVersion(content); err == nil && version == FormatBinary {
+		return len(content) - len(body) + binaryEnd(body)
+	}
+	if end := bytes.IndexByte(content, 0); end >= 0 {
+		return end
+	}
+	return len(content)
+}
+
+// decodeData decodes a data file in any supported format and returns its
+// entries and format version
+func (f *FastYAMLEncoder) decodeData(content []byte) (map[string]*Entry, int, error) {
+	version, body, err := FormatVersion(content)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch version {
+	case FormatLegacy:
+		var entries map[string]*Entry
+		if err := f.Decode(body, &entries); err != nil {
+			return nil, version, err
+		}
+		return entries, version, nil
+	case FormatBinary:
+		entries, err := decodeBinary(body)
+		return entries, version, err
+	default:
+		entries, err := f.decodeSegments(body)
+		return entries, version, err
+	}
+}
+
+// decodeSegments decodes segments concurrently and merges them
+func (f *FastYAMLEncoder) decodeSegments(body []byte) (map[string]*Entry, error) {
+	segments := splitSegments(body)
+	decoded := make([]map[string]*Entry, len(segments))
+	errs := make([]error, len(segments))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for i, segment := range segments {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, segment []byte) {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = f.Decode(segment, &decoded[i])
+		}(i, segment)
+	}
+	wg.Wait()
+
+	total := 0
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i+1, err)
+		}
+		total += len(decoded[i])
+	}
+
+	entries := make(map[string]*Entry, total)
+	for i, segment := range decoded {
+		for key, entry := range segment {
+			if _, exists := entries[key]; exists {
+				return nil, fmt.Errorf("segment %d: duplicate key %q", i+1, key)
+			}
+			entries[key] = entry
+		}
+	}
+	return entries, nil
+}
+
+// splitSegments splits the body of a segmented file at separator lines
+func splitSegments(body []byte) [][]byte {
+	var segments [][]byte
+	for {
+		i := bytes.Index(body, segmentBoundary)
+		if i < 0 {
+			return append(segments, body)
+		}
+		segments = append(segments, body[:i+1])
+		body = body[i+len(segmentBoundary):]
+	}
+}
+
+// encodeData writes entries in the given format version. workers bounds the
+// number of segments or shards encoded concurrently.
+func (f *FastYAMLEncoder) encodeData(w io.Writer, entries map[string]*Entry, version, workers int) error {
+	switch version {
+	case FormatLegacy:
+		return f.EncodeEntries(w, entries, workers)
+	case FormatSegmented:
+		if _, err := io.WriteString(w, formatHeader+strconv.Itoa(version)+"\n"); err != nil {
+			return err
+		}
+		segments := max((len(entries)+segmentEntries-1)/segmentEntries, 1)
+		return f.encodeShards(w, entries, segments, workers, segmentSeparator)
+	case FormatBinary:
+		if _, err := io.WriteString(w, formatHeader+strconv.Itoa(version)+"\n"); err != nil {
+			return err
+		}
+		return encodeBinary(w, entries)
+	}
+	return fmt.Errorf("%w: version %d", ErrUnsupportedFormat, version)
+}
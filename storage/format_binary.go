@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FormatBinary bodies hold one record per entry in key order, then a zero
+// byte. A record is the uvarint length of its payload, the payload and the
+// little-endian CRC-32 (IEEE) of the payload. The payload is the key and the
+// fields of the entry, with the value in the encoding of compressed values,
+// so the same data always encodes the same. Values holding types that
+// encoding lacks are written as YAML instead.
+
+// Encodings of entry values in records
+const (
+	valueEncoded byte = iota // encodeValue
+	valueYAML                // A YAML document
+)
+
+// errBinaryData is returned for binary data that cannot be decoded
+var errBinaryData = errors.New("malformed binary data")
+
+// encodeBinary writes entries as FormatBinary records in key order
+func encodeBinary(w io.Writer, entries map[string]*Entry) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	var payload bytes.Buffer
+	var frame []byte
+	for _, key := range keys {
+		payload.Reset()
+		if err := encodeRecord(&payload, key, entries[key]); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+		frame = binary.AppendUvarint(frame[:0], uint64(payload.Len()))
+		bw.Write(frame)
+		bw.Write(payload.Bytes())
+		frame = binary.LittleEndian.AppendUint32(frame[:0], crc32.ChecksumIEEE(payload.Bytes()))
+		bw.Write(frame)
+	}
+	bw.WriteByte(0)
+	return bw.Flush()
+}
+
+// decodeBinary decodes a FormatBinary body. Only NUL padding may follow the
+// terminator.
+func decodeBinary(body []byte) (map[string]*Entry, error) {
+	entries := make(map[string]*Entry)
+	for n := 1; ; n++ {
+		payload, rest, err := nextRecord(body)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", n, err)
+		}
+		if payload == nil {
+			if len(bytes.TrimLeft(rest, "\x00")) > 0 {
+				return nil, fmt.Errorf("%w: data after the last record", errBinaryData)
+			}
+			return entries, nil
+		}
+		key, entry, err := decodeRecord(payload)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", n, err)
+		}
+		if _, exists := entries[key]; exists {
+			return nil, fmt.Errorf("record %d: duplicate key %q", n, key)
+		}
+		entries[key] = entry
+		body = rest
+	}
+}
+
+// nextRecord returns the payload of the record at the start of body and the
+// data after it, or a nil payload at the terminator. A payload failing its
+// checksum is returned with the error.
+func nextRecord(body []byte) ([]byte, []byte, error) {
+	size, n := binary.Uvarint(body)
+	switch {
+	case n <= 0:
+		return nil, nil, fmt.Errorf("%w: truncated before the last record", errBinaryData)
+	case size == 0:
+		return nil, body[n:], nil
+	case size > uint64(len(body)-n) || len(body)-n-int(size) < 4:
+		return nil, nil, fmt.Errorf("%w: truncated record", errBinaryData)
+	}
+	payload := body[n : n+int(size)]
+	rest := body[n+int(size):]
+	if binary.LittleEndian.Uint32(rest) != crc32.ChecksumIEEE(payload) {
+		return payload, rest[4:], fmt.Errorf("%w: checksum mismatch", errBinaryData)
+	}
+	return payload, rest[4:], nil
+}
+
+// binaryEnd returns the length of a FormatBinary body up to and including
+// its terminator, or the whole body when the records run past its end
+func binaryEnd(body []byte) int {
+	offset := 0
+	for offset < len(body) {
+		size, n := binary.Uvarint(body[offset:])
+		if n <= 0 {
+			break
+		}
+		if size == 0 {
+			return offset + n
+		}
+		if size > uint64(len(body)) {
+			break
+		}
+		offset += n + int(size) + 4
+	}
+	return len(body)
+}
+
+// encodeRecord writes the payload of the record of an entry
+func encodeRecord(buf *bytes.Buffer, key string, entry *Entry) error {
+	writeString(buf, key)
+	mark := buf.Len()
+	buf.WriteByte(valueEncoded)
+	if err := encodeValue(buf, entry.Value); errors.Is(err, errUncompressible) {
+		raw, err := yaml.Marshal(entry.Value)
+		if err != nil {
+			return err
+		}
+		buf.Truncate(mark)
+		buf.WriteByte(valueYAML)
+		writeString(buf, string(raw))
+	} else if err != nil {
+		return err
+	}
+	writeString(buf, entry.Source)
+	buf.Write(binary.AppendVarint(nil, entry.Timestamp))
+	buf.Write(binary.AppendVarint(nil, entry.TTL))
+	buf.Write(binary.AppendVarint(nil, entry.Sliding))
+	writeString(buf, entry.Hash)
+
+	m := entry.Metadata
+	if m == nil {
+		buf.WriteByte(0)
+		return nil
+	}
+	buf.WriteByte(1)
+	writeString(buf, m.CreatedBy)
+	writeString(buf, m.ContentType)
+	labels := make([]string, 0, len(m.Labels))
+	for name := range m.Labels {
+		labels = append(labels, name)
+	}
+	sort.Strings(labels)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(labels))))
+	for _, name := range labels {
+		writeString(buf, name)
+		writeString(buf, m.Labels[name])
+	}
+	writeString(buf, m.Parent)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(m.Links))))
+	for _, link := range m.Links {
+		writeString(buf, link.Type)
+		writeString(buf, link.Target)
+	}
+	return nil
+}
+
+// decodeRecord reads the key and entry of a record payload
+func decodeRecord(payload []byte) (string, *Entry, error) {
+	r := bytes.NewReader(payload)
+	key, err := readString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", errBinaryData, err)
+	}
+	entry, err := decodeRecordEntry(r)
+	if err == nil && r.Len() > 0 {
+		err = fmt.Errorf("%d bytes after the entry", r.Len())
+	}
+	if err != nil {
+		return key, nil, fmt.Errorf("%w: key %s: %v", errBinaryData, key, err)
+	}
+	return key, entry, nil
+}
+
+func decodeRecordEntry(r *bytes.Reader) (*Entry, error) {
+	entry := &Entry{}
+	encoding, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch encoding {
+	case valueEncoded:
+		if entry.Value, err = decodeValue(r); err != nil {
+			return nil, err
+		}
+	case valueYAML:
+		raw, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal([]byte(raw), &entry.Value); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown value encoding %d", encoding)
+	}
+
+	if entry.Source, err = readString(r); err != nil {
+		return nil, err
+	}
+	for _, field := range []*int64{&entry.Timestamp, &entry.TTL, &entry.Sliding} {
+		if *field, err = binary.ReadVarint(r); err != nil {
+			return nil, err
+		}
+	}
+	if entry.Hash, err = readString(r); err != nil {
+		return nil, err
+	}
+
+	hasMetadata, err := r.ReadByte()
+	if err != nil || hasMetadata == 0 {
+		return entry, err
+	}
+	m := &Metadata{}
+	if m.CreatedBy, err = readString(r); err != nil {
+		return nil, err
+	}
+	if m.ContentType, err = readString(r); err != nil {
+		return nil, err
+	}
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		m.Labels = make(map[string]string, n)
+	}
+	for range n {
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if m.Labels[name], err = readString(r); err != nil {
+			return nil, err
+		}
+	}
+	if m.Parent, err = readString(r); err != nil {
+		return nil, err
+	}
+	if n, err = readLength(r); err != nil {
+		return nil, err
+	}
+	for range n {
+		var link Link
+		if link.Type, err = readString(r); err != nil {
+			return nil, err
+		}
+		if link.Target, err = readString(r); err != nil {
+			return nil, err
+		}
+		m.Links = append(m.Links, link)
+	}
+	entry.Metadata = m
+	return entry, nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"log"
+	"time"
+)
+
+// gcBudgetCheck is the number of deletes between checks of the pause budget
+const gcBudgetCheck = 256
+
+// GCResult reports a sweep of expired entries
+type GCResult struct {
+	Scanned   int     `json:"scanned" yaml:"scanned"`
+	Removed   int     `json:"removed" yaml:"removed"`
+	Truncated bool    `json:"truncated,omitempty" yaml:"truncated,omitempty"` // Stopped by GCMaxEntries or GCPauseBudget; the next sweep continues
+	Duration  float64 `json:"duration_ms" yaml:"duration_ms"`
+}
+
+// GC sweeps expired entries now rather than at the next scheduled sweep
+func (s *Store) GC() GCResult {
+	return s.sweepExpired()
+}
+
+// periodicGC sweeps expired entries every GCInterval
+func (s *Store) periodicGC(interval time.Duration) {
+	s.every(interval, func(time.Time) {
+		s.sweepExpired()
+	})
+}
+
+// sweepExpired removes expired entries. Expired keys are found under the
+// read lock, so only the deletes block readers and writers; at most
+// GCMaxEntries are removed, and the deletes stop once they have held the
+// write lock for GCPauseBudget.
+func (s *Store) sweepExpired() GCResult {
+	start := time.Now()
+	now := start.Unix()
+
+	var result GCResult
+	var expired []string
+	s.RLock()
+	for key, entry := range s.data {
+		result.Scanned++
+		if !entry.expired(now) {
+			continue
+		}
+		if s.opts.GCMaxEntries > 0 && len(expired) == s.opts.GCMaxEntries {
+			result.Truncated = true
+			break
+		}
+		expired = append(expired, key)
+	}
+	s.RUnlock()
+
+	if len(expired) > 0 {
+		s.Lock()
+		locked := time.Now()
+		for i, key := range expired {
+			if s.opts.GCPauseBudget > 0 && i > 0 && i%gcBudgetCheck == 0 && time.Since(locked) >= s.opts.GCPauseBudget {
+				result.Truncated = true
+				break
+			}
+			// The key may have been written again since the scan
+			if entry, exists := s.data[key]; exists && entry.expired(now) {
+				s.deleteEntry(key)
+				result.Removed++
+			}
+		}
+		if result.Removed > 0 {
+			s.dirty = true
+		}
+		s.Unlock()
+	}
+
+	s.counters.expired.Add(uint64(result.Removed))
+	s.counters.lastGC.Store(time.Now().UnixNano())
+
+	result.Duration = float64(time.Since(start).Microseconds()) / 1000
+	if s.opts.Debug && result.Removed > 0 {
+		log.Printf("GC removed %d of %d entries in %.1fms", result.Removed, result.Scanned, result.Duration)
+	}
+	return result
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Link is a typed edge from an entry to another key, such as an actor that
+// "uses" a malware. Links are kept in the metadata of their source entry.
+type Link struct {
+	Type   string `yaml:"type" json:"type"`
+	Target string `yaml:"target" json:"target"`
+}
+
+// Edge is a link with its source
+type Edge struct {
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	Target string `json:"target"`
+}
+
+// linkIndex maps target keys to the edges pointing at them, so links are
+// followed backwards without a scan
+type linkIndex map[string]map[Edge]struct{}
+
+func (l linkIndex) add(source string, links []Link) {
+	for _, link := range links {
+		if l[link.Target] == nil {
+			l[link.Target] = make(map[Edge]struct{})
+		}
+		l[link.Target][Edge{Source: source, Type: link.Type, Target: link.Target}] = struct{}{}
+	}
+}
+
+func (l linkIndex) remove(source string, links []Link) {
+	for _, link := range links {
+		delete(l[link.Target], Edge{Source: source, Type: link.Type, Target: link.Target})
+		if len(l[link.Target]) == 0 {
+			delete(l, link.Target)
+		}
+	}
+}
+
+// Links returns the links of key and the edges pointing at it, sorted, and
+// whether key exists
+func (s *Store) Links(key string) ([]Link, []Edge, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, exists := s.data[key]
+	if !exists || entry.expired(time.Now().Unix()) {
+		return nil, nil, false
+	}
+	out := []Link{}
+	if entry.Metadata != nil {
+		out = append(out, entry.Metadata.Links...)
+	}
+	return out, s.incomingLocked(key), true
+}
+
+// incomingLocked returns the edges pointing at key, sorted. Callers must
+// hold the lock.
+func (s *Store) incomingLocked(key string) []Edge {
+	in := make([]Edge, 0, len(s.links[key]))
+	for edge := range s.links[key] {
+		in = append(in, edge)
+	}
+	sort.Slice(in, func(i, j int) bool {
+		if in[i].Source != in[j].Source {
+			return in[i].Source < in[j].Source
+		}
+		return in[i].Type < in[j].Type
+	})
+	return in
+}
+
+// AddLinks adds links to the entry of key, which must exist, ignoring those
+// it has already, and returns the number added. At most max links are kept
+// when max is positive.
+func (s *Store) AddLinks(key string, links []Link, max int) (int, error) {
+	return s.updateLinks(key, func(current []Link) ([]Link, int, error) {
+		have := make(map[Link]bool, len(current))
+		for _, link := range current {
+			have[link] = true
+		}
+		updated := append([]Link(nil), current...)
+		for _, link := range links {
+			if link.Type == "" || link.Target == "" {
+				return nil, 0, fmt.Errorf("%w: links need a type and a target", ErrInvalidKey)
+			}
+			if !have[link] {
+				have[link] = true
+				updated = append(updated, link)
+			}
+		}
+		if max > 0 && len(updated) > max {
+			return nil, 0, fmt.Errorf("%w: %s would have %d links, at most %d", ErrQuotaExceeded, key, len(updated), max)
+		}
+		sort.Slice(updated, func(i, j int) bool {
+			if updated[i].Type != updated[j].Type {
+				return updated[i].Type < updated[j].Type
+			}
+			return updated[i].Target < updated[j].Target
+		})
+		return updated, len(updated) - len(current), nil
+	})
+}
+
+// RemoveLinks removes the links of key that match reports, returning the
+// number removed
+func (s *Store) RemoveLinks(key string, match func(Link) bool) (int, error) {
+	return s.updateLinks(key, func(current []Link) ([]Link, int, error) {
+		kept := make([]Link, 0, len(current))
+		for _, link := range current {
+			if !match(link) {
+				kept = append(kept, link)
+			}
+		}
+		return kept, len(current) - len(kept), nil
+	})
+}
+
+// updateLinks replaces the links of the entry of key with those update
+// returns, keeping its value, expiry and the rest of its metadata
+func (s *Store) updateLinks(key string, update func([]Link) ([]Link, int, error)) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.opts.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	entry, exists := s.data[key]
+	if !exists || entry.expired(time.Now().Unix()) {
+		return 0, fmt.Errorf("%w: key %s", ErrNotFound, key)
+	}
+
+	var current []Link
+	if entry.Metadata != nil {
+		current = entry.Metadata.Links
+	}
+	links, changed, err := update(current)
+	if err != nil || changed == 0 {
+		return 0, err
+	}
+
+	meta := Metadata{}
+	if entry.Metadata != nil {
+		meta = *entry.Metadata
+	}
+	meta.Links = links
+	updated := *entry
+	updated.Metadata = &meta
+	if meta.empty() {
+		updated.Metadata = nil
+	}
+	s.setEntry(key, &updated)
+	s.dirty = true
+	return changed, nil
+}
+
+// Traversal directions
+const (
+	TraverseOut  = "out"  // Follow links from source to target
+	TraverseIn   = "in"   // Follow links from target to source
+	TraverseBoth = "both" // Follow links either way
+)
+
+// MaxTraverseDepth is the deepest traversal allowed
+const MaxTraverseDepth = 10
+
+// TraverseQuery describes a breadth-first walk of the links from some keys
+type TraverseQuery struct {
+	Start     []string `json:"start"`
+	Depth     int      `json:"depth,omitempty"`     // Links followed from the start keys, default 1, at most MaxTraverseDepth
+	Types     []string `json:"types,omitempty"`     // Follow only links of these types
+	Direction string   `json:"direction,omitempty"` // out (default), in or both
+	MaxNodes  int      `json:"max_nodes,omitempty"` // Stop after reaching this many keys (0 for no limit)
+
+	ReturnValues bool `json:"return_values,omitempty"` // Return the values of the keys reached
+}
+
+// GraphNode is a key reached by a traversal
+type GraphNode struct {
+	Key   string      `json:"key"`
+	Depth int         `json:"depth"` // Links from the nearest start key
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Graph is the result of a traversal: the keys reached, nearest first, and
+// the links followed between them
+type Graph struct {
+	Nodes     []GraphNode `json:"nodes"`
+	Edges     []Edge      `json:"edges"`
+	Truncated bool        `json:"truncated,omitempty"` // MaxNodes was reached
+}
+
+// Traverse walks the links from the start keys breadth first. Keys that do
+// not exist, and those allow rejects when it is not nil, are neither
+// returned nor walked through.
+func (s *Store) Traverse(query TraverseQuery, allow func(key string) bool) (*Graph, error) {
+	if len(query.Start) == 0 {
+		return nil, fmt.Errorf("%w: traversals need start keys", ErrInvalidQuery)
+	}
+	depth := query.Depth
+	if depth == 0 {
+		depth = 1
+	}
+	if depth < 0 || depth > MaxTraverseDepth {
+		return nil, fmt.Errorf("%w: depth must be from 1 to %d", ErrInvalidQuery, MaxTraverseDepth)
+	}
+	direction := query.Direction
+	switch direction {
+	case "":
+		direction = TraverseOut
+	case TraverseOut, TraverseIn, TraverseBoth:
+	default:
+		return nil, fmt.Errorf("%w: direction must be out, in or both", ErrInvalidQuery)
+	}
+	var types map[string]bool
+	if len(query.Types) > 0 {
+		types = make(map[string]bool, len(query.Types))
+		for _, t := range query.Types {
+			types[t] = true
+		}
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	now := time.Now().Unix()
+	graph := &Graph{Nodes: []GraphNode{}, Edges: []Edge{}}
+	reached := make(map[string]bool)
+	reach := func(key string, d int) bool {
+		if reached[key] {
+			return true
+		}
+		entry, exists := s.data[key]
+		if !exists || entry.expired(now) || (allow != nil && !allow(key)) {
+			return false
+		}
+		if query.MaxNodes > 0 && len(graph.Nodes) >= query.MaxNodes {
+			graph.Truncated = true
+			return false
+		}
+		reached[key] = true
+		node := GraphNode{Key: key, Depth: d}
+		if query.ReturnValues {
+			node.Value = entry.hydrate().Value
+		}
+		graph.Nodes = append(graph.Nodes, node)
+		return true
+	}
+
+	var frontier []string
+	for _, key := range query.Start {
+		if !reached[key] && reach(key, 0) {
+			frontier = append(frontier, key)
+		}
+	}
+
+	seen := make(map[Edge]bool)
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, key := range frontier {
+			var edges []Edge
+			if direction != TraverseIn {
+				if meta := s.data[key].Metadata; meta != nil {
+					for _, link := range meta.Links {
+						edges = append(edges, Edge{Source: key, Type: link.Type, Target: link.Target})
+					}
+				}
+			}
+			if direction != TraverseOut {
+				edges = append(edges, s.incomingLocked(key)...)
+			}
+
+			for _, edge := range edges {
+				if (types != nil && !types[edge.Type]) || seen[edge] {
+					continue
+				}
+				other := edge.Target
+				if other == key && edge.Source != key {
+					other = edge.Source
+				}
+				known := reached[other]
+				if !reach(other, d) {
+					continue
+				}
+				seen[edge] = true
+				graph.Edges = append(graph.Edges, edge)
+				if !known {
+					next = append(next, other)
+				}
+			}
+		}
+		frontier = next
+	}
+	return graph, nil
+}
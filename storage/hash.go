@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// maxVerifyMismatches is the number of mismatches a VerifyReport lists
+const maxVerifyMismatches = 100
+
+// HashMismatch is an entry whose value no longer matches its stored hash
+type HashMismatch struct {
+	Key      string `json:"key" yaml:"key"`
+	Stored   string `json:"stored" yaml:"stored"`
+	Computed string `json:"computed" yaml:"computed"`
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	Scanned    int            `json:"scanned" yaml:"scanned"`
+	Mismatched int            `json:"mismatched" yaml:"mismatched"`
+	Mismatches []HashMismatch `json:"mismatches,omitempty" yaml:"mismatches,omitempty"` // The first 100, sorted by key
+	Duration   float64        `json:"duration_ms" yaml:"duration_ms"`
+}
+
+// ContentHash returns the hex SHA-256 of the canonical encoding of a value:
+// its JSON, which orders map keys, so equal values hash equally whether
+// they were written as YAML or JSON
+func ContentHash(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded = []byte(fmt.Sprintf("%#v", value))
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashed returns the entry with the content hash of its value. Compressed
+// entries are copies of stored entries and keep their hash, as their value
+// has not changed. The entry is copied rather than modified.
+func hashed(entry *Entry) *Entry {
+	if entry.compressed() {
+		return entry
+	}
+	hash := ContentHash(entry.Value)
+	if hash == entry.Hash {
+		return entry
+	}
+	h := *entry
+	h.Hash = hash
+	return &h
+}
+
+// Verify hashes the value of every live entry again and reports the entries
+// whose hash differs from the one stored when they were written, as after
+// the data file was edited by hand. It holds the read lock for the walk.
+func (s *Store) Verify() VerifyReport {
+	start := time.Now()
+
+	s.RLock()
+	defer s.RUnlock()
+
+	var report VerifyReport
+	now := start.Unix()
+	for key, entry := range s.data {
+		if entry.expired(now) {
+			continue
+		}
+		report.Scanned++
+		if computed := ContentHash(entry.hydrate().Value); computed != entry.Hash {
+			report.Mismatched++
+			report.Mismatches = append(report.Mismatches, HashMismatch{Key: key, Stored: entry.Hash, Computed: computed})
+		}
+	}
+
+	sort.Slice(report.Mismatches, func(i, j int) bool {
+		return report.Mismatches[i].Key < report.Mismatches[j].Key
+	})
+	if len(report.Mismatches) > maxVerifyMismatches {
+		report.Mismatches = report.Mismatches[:maxVerifyMismatches]
+	}
+	report.Duration = float64(time.Since(start).Microseconds()) / 1000
+	return report
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// Latencies are recorded in nanoseconds into log-linear buckets, as in an
+// HDR histogram: values below histSub have a bucket each, and every power of
+// two above is split into histSub buckets, so a percentile is within 1/64
+// of the true value. Values above histMaxBits bits (about 18 minutes) are
+// recorded as the largest.
+const (
+	histSubBits = 6
+	histSub     = 1 << histSubBits
+	histMaxBits = 40
+	histBuckets = histSub * (histMaxBits - histSubBits + 1)
+)
+
+// Histogram records a latency distribution. It is safe for concurrent use
+// without locking.
+type Histogram struct {
+	counts [histBuckets]atomic.Uint64
+	sum    atomic.Uint64 // Nanoseconds
+	max    atomic.Uint64 // Nanoseconds
+}
+
+// LatencyStats summarizes a Histogram, in milliseconds
+type LatencyStats struct {
+	Count uint64  `json:"count" yaml:"count"`
+	Mean  float64 `json:"mean" yaml:"mean"`
+	P50   float64 `json:"p50" yaml:"p50"`
+	P90   float64 `json:"p90" yaml:"p90"`
+	P99   float64 `json:"p99" yaml:"p99"`
+	P999  float64 `json:"p999" yaml:"p999"`
+	Max   float64 `json:"max" yaml:"max"`
+	Sum   float64 `json:"sum" yaml:"sum"`
+}
+
+func histIndex(v uint64) int {
+	v = min(v, 1<<histMaxBits-1)
+	if v < histSub {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histSubBits - 1
+	return histSub*(shift+1) + int(v>>shift) - histSub
+}
+
+// histValue returns the highest value recorded in bucket i
+func histValue(i int) uint64 {
+	if i < histSub {
+		return uint64(i)
+	}
+	shift := i/histSub - 1
+	low := uint64(histSub+i%histSub) << shift
+	return low + 1<<shift - 1
+}
+
+// Record adds a latency to the histogram
+func (h *Histogram) Record(d time.Duration) {
+	v := uint64(max(d, 0))
+	h.counts[histIndex(v)].Add(1)
+	h.sum.Add(v)
+	for {
+		m := h.max.Load()
+		if v <= m || h.max.CompareAndSwap(m, v) {
+			return
+		}
+	}
+}
+
+// Reset forgets the recorded latencies. Latencies recorded concurrently may
+// be kept in part.
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.sum.Store(0)
+	h.max.Store(0)
+}
+
+// Stats computes the count, mean, percentiles and maximum of the recorded
+// latencies
+func (h *Histogram) Stats() LatencyStats {
+	var snap histSnapshot
+	snap.add(h)
+	return snap.stats()
+}
+
+// histSnapshot holds the counts of one or more histograms, read once so
+// that percentiles are computed from consistent totals
+type histSnapshot struct {
+	counts   [histBuckets]uint64
+	total    uint64
+	sum, max uint64 // Nanoseconds
+}
+
+// add merges the counts of h into the snapshot
+func (snap *histSnapshot) add(h *Histogram) {
+	for i := range h.counts {
+		n := h.counts[i].Load()
+		snap.counts[i] += n
+		snap.total += n
+	}
+	snap.sum += h.sum.Load()
+	snap.max = max(snap.max, h.max.Load())
+}
+
+func (snap *histSnapshot) stats() LatencyStats {
+	if snap.total == 0 {
+		return LatencyStats{}
+	}
+	quantile := func(q float64) float64 {
+		target := uint64(math.Ceil(q * float64(snap.total)))
+		var seen uint64
+		for i, n := range snap.counts {
+			if seen += n; seen >= target {
+				return nanosToMillis(min(histValue(i), snap.max))
+			}
+		}
+		return nanosToMillis(snap.max)
+	}
+	return LatencyStats{
+		Count: snap.total,
+		Mean:  nanosToMillis(snap.sum) / float64(snap.total),
+		P50:   quantile(0.5),
+		P90:   quantile(0.9),
+		P99:   quantile(0.99),
+		P999:  quantile(0.999),
+		Max:   nanosToMillis(snap.max),
+		Sum:   nanosToMillis(snap.sum),
+	}
+}
+
+// histShards is the number of histograms a shardedHistogram spreads its
+// recordings over
+const histShards = 4
+
+// shardedHistogram records into one of several histograms picked at random,
+// so that concurrent operations seldom update the same sum and maximum
+type shardedHistogram struct {
+	shards [histShards]Histogram
+}
+
+// Record adds a latency to one of the shards
+func (h *shardedHistogram) Record(d time.Duration) {
+	h.shards[rand.Uint32()%histShards].Record(d)
+}
+
+// Reset forgets the latencies recorded in every shard
+func (h *shardedHistogram) Reset() {
+	for i := range h.shards {
+		h.shards[i].Reset()
+	}
+}
+
+// Stats merges the shards and summarizes them
+func (h *shardedHistogram) Stats() LatencyStats {
+	var snap histSnapshot
+	for i := range h.shards {
+		snap.add(&h.shards[i])
+	}
+	return snap.stats()
+}
+
+func nanosToMillis(n uint64) float64 {
+	return float64(n) / 1e6
+}
+
+// latencies holds the latency histograms of the store's operations
+type latencies struct {
+	read   shardedHistogram
+	write  shardedHistogram
+	search shardedHistogram
+	sync   shardedHistogram
+}
+
+// ResetLatencies forgets the latencies recorded so far, starting the
+// percentiles in StoreStats afresh
+func (s *Store) ResetLatencies() {
+	s.latency.read.Reset()
+	s.latency.write.Reset()
+	s.latency.search.Reset()
+	s.latency.sync.Reset()
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Dimensions of the count-min sketch estimating reads per key. The
+// estimates exceed the true counts by at most 2/hotKeyWidth of the reads
+// since the last decay, with probability 1-(1/2)^hotKeyDepth.
+const (
+	hotKeyWidth = 2048
+	hotKeyDepth = 4
+)
+
+// hotKeyDecay is the number of reads, per tracked key, after which every
+// count is halved, so the ranking follows the recent traffic
+const hotKeyDecay = 1000
+
+// HotKey is a key with its estimated number of recent reads
+type HotKey struct {
+	Key   string `json:"key" yaml:"key"`
+	Reads uint64 `json:"reads" yaml:"reads"`
+}
+
+// hotKeys tracks the most read keys in bounded memory: a count-min sketch
+// estimates the reads of every key, and the keys with the highest
+// estimates are kept as candidates. Reads of keys below the candidates
+// only touch the sketch, with atomic increments.
+type hotKeys struct {
+	size   int // Number of candidates kept
+	seed   maphash.Seed
+	counts []uint32 // hotKeyDepth rows of hotKeyWidth counters
+	reads  atomic.Uint64
+	floor  atomic.Uint32 // Lowest estimate among the candidates once full
+
+	mu  sync.Mutex
+	top map[string]uint32
+}
+
+func newHotKeys(size int) *hotKeys {
+	if size <= 0 {
+		return nil
+	}
+	return &hotKeys{
+		size:   size,
+		seed:   maphash.MakeSeed(),
+		counts: make([]uint32, hotKeyWidth*hotKeyDepth),
+		top:    make(map[string]uint32, size+1),
+	}
+}
+
+// record counts a read of key
+func (h *hotKeys) record(key string) {
+	if h == nil {
+		return
+	}
+	// Rows index the sketch by h1 + i*h2, from the halves of one hash
+	sum := maphash.String(h.seed, key)
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	estimate := uint32(^uint32(0))
+	for i := uint32(0); i < hotKeyDepth; i++ {
+		slot := i*hotKeyWidth + (h1+i*h2)%hotKeyWidth
+		estimate = min(estimate, atomic.AddUint32(&h.counts[slot], 1))
+	}
+
+	if h.reads.Add(1)%uint64(hotKeyDecay*h.size) == 0 {
+		h.decay()
+	}
+	if estimate < h.floor.Load() || !h.mu.TryLock() {
+		// Colder than every candidate, or another read is updating the
+		// candidates; the sketch has counted the read either way
+		return
+	}
+	defer h.mu.Unlock()
+
+	h.top[key] = estimate
+	if len(h.top) > h.size {
+		coldest, floor := "", uint32(^uint32(0))
+		for k, n := range h.top {
+			if n < floor {
+				coldest, floor = k, n
+			}
+		}
+		delete(h.top, coldest)
+	}
+	if len(h.top) == h.size {
+		floor := uint32(^uint32(0))
+		for _, n := range h.top {
+			floor = min(floor, n)
+		}
+		h.floor.Store(floor)
+	}
+}
+
+// decay halves every count. Increments racing with it may be lost, which
+// only makes the estimates less exact.
+func (h *hotKeys) decay() {
+	for i := range h.counts {
+		atomic.StoreUint32(&h.counts[i], atomic.LoadUint32(&h.counts[i])/2)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for k, n := range h.top {
+		h.top[k] = n / 2
+	}
+	h.floor.Store(h.floor.Load() / 2)
+}
+
+// list returns up to limit candidates, hottest first; all if limit is 0
+func (h *hotKeys) list(limit int) []HotKey {
+	if h == nil {
+		return []HotKey{}
+	}
+	h.mu.Lock()
+	keys := make([]HotKey, 0, len(h.top))
+	for k, n := range h.top {
+		keys = append(keys, HotKey{Key: k, Reads: uint64(n)})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Reads != keys[j].Reads {
+			return keys[i].Reads > keys[j].Reads
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+func (h *hotKeys) reset() {
+	if h == nil {
+		return
+	}
+	for i := range h.counts {
+		atomic.StoreUint32(&h.counts[i], 0)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.top = make(map[string]uint32, h.size+1)
+	h.floor.Store(0)
+}
+
+// HotKeys returns up to limit of the most read keys with their estimated
+// recent reads, hottest first; all tracked keys if limit is 0. It is empty
+// unless StoreOptions.HotKeys is set.
+func (s *Store) HotKeys(limit int) []HotKey {
+	return s.hotKeys.list(limit)
+}
+
+// ResetHotKeys forgets the reads counted so far
+func (s *Store) ResetHotKeys() {
+	s.hotKeys.reset()
+}
@@ -1,104 +1,505 @@
 package storage
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"github.com/google/btree"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // IndexManager handles multiple index types
 type IndexManager struct {
 	sync.RWMutex
-	trees   map[string]*btree.BTree  // Field-based btree indexes
+	trees   map[string]*btreeIndex   // Field-based btree indexes
 	vectors map[string]*VectorIndex  // Vector indexes
 	text    map[string]*TrigramIndex // Text search indexes
+	ips     map[string]*IPIndex      // IP address indexes
+
+	keywords map[string]*KeywordIndex // Exact value indexes
+	numeric  map[string]*NumericIndex // Sorted numeric indexes
+
+	ids *docIDs // Document IDs of every indexed key, held by all but the vector indexes
+
+	vectorLog *vectorLog // Persists the vector indexes, nil unless StoreOptions.PersistVectors
 }
 
+// ErrInvalidIndex is returned for index types and options that do not exist
+// and for indexes conflicting with an existing one
+var ErrInvalidIndex = errors.New("invalid index")
+
+// IndexOptions configures an index at creation
+type IndexOptions struct {
+	Tokenizer  string   // Tokenizer spec of text indexes, see NewTokenizer; empty for DefaultTokenizer
+	IgnoreCase bool     // Match keyword index values regardless of case
+	Dims       int      // Dimensions of vector indexes; 0 takes those of the first vector indexed
+	Payload    []string // Fields kept with the vectors of vector indexes, so searches filter on them without other indexes
+}
+
+// errNotVector is the index error of values of vector fields that are not
+// lists of numbers
+var errNotVector = errors.New("value is not a numeric vector")
+
 // indexItem represents a single indexed value
 type indexItem struct {
-	key   string
+	id    uint32 // Document ID
 	value interface{}
 }
 
-// Less implements btree.Item interface
+// Less implements btree.Item interface. Items are ordered by value, then by
+// document ID, so documents sharing a value each keep their own item.
+// Values of different types are ordered by type (numbers before strings)
+// instead of panicking, and values of other types are equal.
 func (i indexItem) Less(than btree.Item) bool {
-	switch v := i.value.(type) {
+	other := than.(indexItem)
+	if c := compareIndexValues(i.value, other.value); c != 0 {
+		return c < 0
+	}
+	return i.id < other.id
+}
+
+// compareIndexValues orders two btree values, returning -1, 0 or 1
+func compareIndexValues(a, b interface{}) int {
+	switch v := a.(type) {
 	case string:
-		return v < than.(indexItem).value.(string)
+		if o, ok := b.(string); ok {
+			return strings.Compare(v, o)
+		}
 	case int:
-		return v < than.(indexItem).value.(int)
+		if o, ok := b.(int); ok {
+			return cmp.Compare(v, o)
+		}
 	case float64:
-		return v < than.(indexItem).value.(float64)
+		if o, ok := b.(float64); ok {
+			return cmp.Compare(v, o)
+		}
+	}
+	return cmp.Compare(indexTypeRank(a), indexTypeRank(b))
+}
+
+func indexTypeRank(v interface{}) int {
+	switch v.(type) {
+	case int:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
 	default:
-		return false
+		return 0
 	}
 }
 
+// btreeIndex is a btree of the values of a field, which keeps the value of
+// each document so its item can be found again when the value changes or
+// the document is removed
+type btreeIndex struct {
+	*btree.BTree
+	values map[uint32]interface{} // document ID -> indexed value
+}
+
+func newBTreeIndex() *btreeIndex {
+	return &btreeIndex{BTree: btree.New(32), values: make(map[uint32]interface{})}
+}
+
+// update replaces the item of a document
+func (t *btreeIndex) update(id uint32, value interface{}) {
+	t.remove(id)
+	t.ReplaceOrInsert(indexItem{id, value})
+	t.values[id] = value
+}
+
+// remove deletes the item of a document, if it has one
+func (t *btreeIndex) remove(id uint32) {
+	if old, exists := t.values[id]; exists {
+		t.Delete(indexItem{id, old})
+		delete(t.values, id)
+	}
+}
+
+// holds reports whether the tree has an item of a document
+func (t *btreeIndex) holds(id uint32) bool {
+	_, exists := t.values[id]
+	return exists
+}
+
 // NewIndexManager creates a new index manager
 func NewIndexManager() *IndexManager {
 	return &IndexManager{
-		trees:   make(map[string]*btree.BTree),
+		trees:   make(map[string]*btreeIndex),
 		vectors: make(map[string]*VectorIndex),
 		text:    make(map[string]*TrigramIndex),
+		ips:     make(map[string]*IPIndex),
+
+		keywords: make(map[string]*KeywordIndex),
+		numeric:  make(map[string]*NumericIndex),
+
+		ids: newDocIDs(),
 	}
 }
 
 // AddIndex creates a new index for the specified field
 func (im *IndexManager) AddIndex(field string, indexType string) error {
+	return im.AddIndexWithOptions(field, indexType, IndexOptions{})
+}
+
+// AddIndexWithOptions creates a new index for the specified field with
+// options. An existing index of the type is kept when its options are the
+// same, and is an ErrInvalidIndex otherwise.
+func (im *IndexManager) AddIndexWithOptions(field string, indexType string, opts IndexOptions) error {
+	_, err := im.addIndex(field, indexType, opts)
+	return err
+}
+
+// addIndex is AddIndexWithOptions, also reporting whether the index was
+// created rather than kept, as one step so concurrent callers cannot both
+// see it created
+func (im *IndexManager) addIndex(field string, indexType string, opts IndexOptions) (bool, error) {
+	if opts.Tokenizer != "" && indexType != "text" {
+		return false, fmt.Errorf("%w: only text indexes take a tokenizer", ErrInvalidIndex)
+	}
+	if opts.IgnoreCase && indexType != "keyword" {
+		return false, fmt.Errorf("%w: only keyword indexes ignore case", ErrInvalidIndex)
+	}
+	if opts.Dims < 0 || (opts.Dims > 0 && indexType != "vector") {
+		return false, fmt.Errorf("%w: only vector indexes take dims, which must be positive", ErrInvalidIndex)
+	}
+	if len(opts.Payload) > 0 && indexType != "vector" {
+		return false, fmt.Errorf("%w: only vector indexes take a payload", ErrInvalidIndex)
+	}
+	payload, err := checkPayloadFields(opts.Payload)
+	if err != nil {
+		return false, err
+	}
+
 	im.Lock()
 	defer im.Unlock()
 
 	switch indexType {
 	case "btree":
-		if _, exists := im.trees[field]; !exists {
-			im.trees[field] = btree.New(32)
+		if _, exists := im.trees[field]; exists {
+			return false, nil
 		}
+		im.trees[field] = newBTreeIndex()
 	case "vector":
-		if _, exists := im.vectors[field]; !exists {
-			im.vectors[field] = NewVectorIndex(384) // Default to 384 dimensions
+		idx, exists := im.vectors[field]
+		if !exists {
+			idx = NewVectorIndex(opts.Dims)
+			idx.payload = payload
+			im.vectors[field] = idx
+			return true, nil
+		}
+		if len(payload) > 0 && strings.Join(payload, ",") != strings.Join(idx.payload, ",") {
+			return false, fmt.Errorf("%w: vector index on %s exists with payload [%s]", ErrInvalidIndex, field, strings.Join(idx.payload, ", "))
 		}
+		if opts.Dims > 0 {
+			idx.Lock()
+			defer idx.Unlock()
+			if idx.dim == 0 {
+				idx.dim = opts.Dims // Nothing indexed yet
+			} else if idx.dim != opts.Dims {
+				return false, fmt.Errorf("%w: vector index on %s exists with %d dimensions", ErrInvalidIndex, field, idx.dim)
+			}
+		}
+		return false, nil
 	case "text":
-		if _, exists := im.text[field]; !exists {
-			im.text[field] = NewTrigramIndex()
+		tokenizer, err := NewTokenizer(opts.Tokenizer)
+		if err != nil {
+			return false, err
 		}
+		if idx, exists := im.text[field]; exists {
+			if name := idx.tokenizer.Name(); name != tokenizer.Name() {
+				return false, fmt.Errorf("%w: text index on %s exists with tokenizer %s", ErrInvalidIndex, field, name)
+			}
+			return false, nil
+		}
+		im.text[field] = newTextIndex(tokenizer, im.ids)
+	case "ip":
+		if _, exists := im.ips[field]; exists {
+			return false, nil
+		}
+		im.ips[field] = newIPIndex(im.ids)
+	case "keyword":
+		if idx, exists := im.keywords[field]; exists {
+			if idx.ignoreCase != opts.IgnoreCase {
+				return false, fmt.Errorf("%w: keyword index on %s exists with ignore_case %v", ErrInvalidIndex, field, idx.ignoreCase)
+			}
+			return false, nil
+		}
+		im.keywords[field] = newKeywordIndex(opts.IgnoreCase, im.ids)
+	case "numeric":
+		if _, exists := im.numeric[field]; exists {
+			return false, nil
+		}
+		im.numeric[field] = newNumericIndex(im.ids)
 	default:
-		return fmt.Errorf("unknown index type: %s", indexType)
+		return false, fmt.Errorf("%w: unknown index type %s", ErrInvalidIndex, indexType)
 	}
 
-	return nil
+	return true, nil
 }
 
-// Update updates all indexes for a given key-value pair
+// Update updates all indexes for a given key-value pair. A value an index
+// rejects does not stop the other indexes from being updated; the failures
+// are returned together as an *IndexUpdateError.
 func (im *IndexManager) Update(key string, value interface{}) error {
 	im.Lock()
 	defer im.Unlock()
 
-	// Handle map values
-	if m, ok := value.(map[string]interface{}); ok {
-		for field, tree := range im.trees {
-			if fieldValue, exists := m[field]; exists {
-				tree.ReplaceOrInsert(indexItem{key, fieldValue})
-			}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	im.ids.assign(key)
+
+	var errs []IndexError
+	update := func(field, indexType string) {
+		if _, exists := m[field]; !exists {
+			return
+		}
+		if err := im.updateLocked(field, indexType, key, m); err != nil {
+			errs = append(errs, IndexError{Time: time.Now(), Key: key, Field: field, Type: indexType, Error: err.Error()})
+		}
+	}
+
+	for field, tree := range im.trees {
+		if _, exists := m[field]; !exists {
+			tree.remove(im.ids.assign(key))
+			continue
+		}
+		update(field, "btree")
+	}
+	for field := range im.vectors {
+		update(field, "vector")
+	}
+	for field := range im.text {
+		update(field, "text")
+	}
+	for field := range im.ips {
+		update(field, "ip")
+	}
+	// Filters trust keyword and numeric indexes, so a key leaves them with
+	// its field
+	for field, idx := range im.keywords {
+		if _, exists := m[field]; !exists {
+			idx.Remove(key)
+			continue
+		}
+		update(field, "keyword")
+	}
+	for field, idx := range im.numeric {
+		if _, exists := m[field]; !exists {
+			idx.Remove(key)
+			continue
+		}
+		update(field, "numeric")
+	}
+
+	if len(errs) > 0 {
+		return &IndexUpdateError{Errors: errs}
+	}
+	return nil
+}
+
+// Validate reports the indexes that would reject value, without changing
+// any index
+func (im *IndexManager) Validate(value interface{}) error {
+	im.RLock()
+	defer im.RUnlock()
+
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var errs []IndexError
+	for field, vec := range im.vectors {
+		fieldValue, exists := m[field]
+		if !exists {
+			continue
 		}
+		if err := vec.check(fieldValue); err != nil {
+			errs = append(errs, IndexError{Time: time.Now(), Field: field, Type: "vector", Error: err.Error()})
+		}
+	}
+	for field, idx := range im.numeric {
+		fieldValue, exists := m[field]
+		if !exists {
+			continue
+		}
+		if err := idx.check(fieldValue); err != nil {
+			errs = append(errs, IndexError{Time: time.Now(), Field: field, Type: "numeric", Error: err.Error()})
+		}
+	}
+
+	if len(errs) > 0 {
+		return &IndexUpdateError{Errors: errs}
+	}
+	return nil
+}
+
+// HasIndex reports whether an index of the given type exists on field
+func (im *IndexManager) HasIndex(field string, indexType string) bool {
+	im.RLock()
+	defer im.RUnlock()
+
+	var exists bool
+	switch indexType {
+	case "btree":
+		_, exists = im.trees[field]
+	case "vector":
+		_, exists = im.vectors[field]
+	case "text":
+		_, exists = im.text[field]
+	case "ip":
+		_, exists = im.ips[field]
+	case "keyword":
+		_, exists = im.keywords[field]
+	case "numeric":
+		_, exists = im.numeric[field]
+	}
+	return exists
+}
 
-		for field, vec := range im.vectors {
-			if fieldValue, exists := m[field]; exists {
-				if vectors, ok := fieldValue.([]float32); ok {
-					vec.Update(key, vectors)
-				}
+// fieldLen returns the number of documents in the largest index on field
+func (im *IndexManager) fieldLen(field string) int {
+	im.RLock()
+	defer im.RUnlock()
+
+	n := 0
+	if tree, exists := im.trees[field]; exists {
+		n = max(n, tree.Len())
+	}
+	if idx, exists := im.vectors[field]; exists {
+		n = max(n, idx.Len())
+	}
+	if idx, exists := im.text[field]; exists {
+		n = max(n, idx.Len())
+	}
+	if idx, exists := im.ips[field]; exists {
+		n = max(n, idx.Len())
+	}
+	if idx, exists := im.keywords[field]; exists {
+		n = max(n, idx.Len())
+	}
+	if idx, exists := im.numeric[field]; exists {
+		n = max(n, idx.Len())
+	}
+	return n
+}
+
+// UpdateIndex updates a single index for a given key-value pair
+func (im *IndexManager) UpdateIndex(field string, indexType string, key string, value interface{}) error {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if _, exists := m[field]; !exists {
+		return nil
+	}
+
+	im.Lock()
+	defer im.Unlock()
+	im.ids.assign(key)
+	return im.updateLocked(field, indexType, key, m)
+}
+
+// updateLocked adds the value of field in doc, which holds it, to one
+// index. Callers must hold the lock.
+func (im *IndexManager) updateLocked(field string, indexType string, key string, doc map[string]interface{}) error {
+	fieldValue := doc[field]
+	switch indexType {
+	case "btree":
+		if tree, exists := im.trees[field]; exists {
+			tree.update(im.ids.assign(key), fieldValue)
+		}
+	case "vector":
+		if vec, exists := im.vectors[field]; exists {
+			vector, ok := vectorValue(fieldValue)
+			if !ok {
+				return errNotVector
+			}
+			if err := vec.Update(key, vector); err != nil {
+				return err
+			}
+			vec.setPayload(key, doc)
+			im.logVector(field, key, vec)
+		}
+	case "text":
+		if idx, exists := im.text[field]; exists {
+			if text, ok := textValue(fieldValue); ok {
+				idx.Update(key, text)
 			}
 		}
+	case "ip":
+		if idx, exists := im.ips[field]; exists {
+			idx.Update(key, fieldValue)
+		}
+	case "keyword":
+		if idx, exists := im.keywords[field]; exists {
+			idx.Update(key, fieldValue)
+		}
+	case "numeric":
+		if idx, exists := im.numeric[field]; exists {
+			return idx.Update(key, fieldValue)
+		}
+	}
+	return nil
+}
 
-		for field, idx := range im.text {
-			if fieldValue, exists := m[field]; exists {
-				if text, ok := fieldValue.(string); ok {
-					idx.Update(key, text)
-				}
+// vectorValue converts a list of numbers to a vector. Lists decoded from
+// JSON or YAML hold float64 and int elements.
+func vectorValue(value interface{}) ([]float32, bool) {
+	switch v := value.(type) {
+	case []float32:
+		return v, true
+	case []float64:
+		out := make([]float32, len(v))
+		for i, f := range v {
+			out[i] = float32(f)
+		}
+		return out, true
+	case []interface{}:
+		out := make([]float32, len(v))
+		for i, item := range v {
+			switch n := item.(type) {
+			case float64:
+				out[i] = float32(n)
+			case int:
+				out[i] = float32(n)
+			case float32:
+				out[i] = n
+			default:
+				return nil, false
 			}
 		}
+		return out, true
+	default:
+		return nil, false
 	}
+}
 
-	return nil
+// textValue extracts indexable text from a string or a list of strings
+func textValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []string:
+		return strings.Join(v, " "), len(v) > 0
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				parts = append(parts, str)
+			}
+		}
+		return strings.Join(parts, " "), len(parts) > 0
+	default:
+		return "", false
+	}
 }
 
 // Remove removes a key from all indexes
@@ -107,20 +508,86 @@ func (im *IndexManager) Remove(key string) {
 	defer im.Unlock()
 
 	// Remove from btree indexes
-	for _, tree := range im.trees {
-		tree.Delete(btree.Item(indexItem{key, nil}))
-
+	id, hasID := im.ids.lookup(key)
+	if hasID {
+		for _, tree := range im.trees {
+			tree.remove(id)
+		}
 	}
 
 	// Remove from vector indexes
-	for _, vec := range im.vectors {
-		vec.Remove(key)
+	for field, vec := range im.vectors {
+		if _, exists := vec.get(key); exists {
+			vec.Remove(key)
+			im.vectorLog.remove(field, key)
+		}
 	}
 
 	// Remove from text indexes
 	for _, idx := range im.text {
 		idx.Remove(key)
 	}
+
+	// Remove from IP indexes
+	for _, idx := range im.ips {
+		idx.Remove(key)
+	}
+
+	for _, idx := range im.keywords {
+		idx.Remove(key)
+	}
+	for _, idx := range im.numeric {
+		idx.Remove(key)
+	}
+
+	// No index holds the ID anymore, so it may go to another key. An ID
+	// still held somewhere is never reused, which would hand this key's
+	// entries to that key.
+	if hasID && !im.holdsLocked(id) {
+		im.ids.release(key)
+	}
+}
+
+// holdsLocked reports whether any index other than the vector indexes,
+// which hold keys, still has entries of a document ID. Callers must hold
+// the lock.
+func (im *IndexManager) holdsLocked(id uint32) bool {
+	for _, tree := range im.trees {
+		if tree.holds(id) {
+			return true
+		}
+	}
+	for _, idx := range im.text {
+		if idx.holds(id) {
+			return true
+		}
+	}
+	for _, idx := range im.ips {
+		if idx.holds(id) {
+			return true
+		}
+	}
+	for _, idx := range im.keywords {
+		if idx.holds(id) {
+			return true
+		}
+	}
+	for _, idx := range im.numeric {
+		if idx.holds(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveFromText removes a key from a single text index
+func (im *IndexManager) RemoveFromText(field string, key string) {
+	im.RLock()
+	defer im.RUnlock()
+
+	if idx, exists := im.text[field]; exists {
+		idx.Remove(key)
+	}
 }
 
 // RemoveIndex removes an index of the specified type
@@ -137,6 +604,7 @@ func (im *IndexManager) RemoveIndex(field string, indexType string) error {
 	case "vector":
 		if _, exists := im.vectors[field]; exists {
 			delete(im.vectors, field)
+			im.vectorLog.drop(field)
 			return nil
 		}
 	case "text":
@@ -144,51 +612,141 @@ func (im *IndexManager) RemoveIndex(field string, indexType string) error {
 			delete(im.text, field)
 			return nil
 		}
+	case "ip":
+		if _, exists := im.ips[field]; exists {
+			delete(im.ips, field)
+			return nil
+		}
+	case "keyword":
+		if _, exists := im.keywords[field]; exists {
+			delete(im.keywords, field)
+			return nil
+		}
+	case "numeric":
+		if _, exists := im.numeric[field]; exists {
+			delete(im.numeric, field)
+			return nil
+		}
 	default:
-		return fmt.Errorf("unknown index type: %s", indexType)
+		return fmt.Errorf("%w: unknown index type %s", ErrInvalidIndex, indexType)
 	}
 
-	return fmt.Errorf("index not found: %s (%s)", field, indexType)
+	return fmt.Errorf("%w: index %s (%s)", ErrNotFound, field, indexType)
 }
 
-// Search performs a search across all relevant indexes
+// Search performs a search across all relevant indexes: keyword or numeric
+// indexes when the field has one, then btree and IP indexes. Fields without a
+// filterable index are ignored; the result is nil if no field could be applied.
 func (im *IndexManager) Search(query map[string]interface{}) ([]string, error) {
-	im.RLock()
-	defer im.RUnlock()
+	return im.search(query, nil)
+}
 
-	results := make(map[string]struct{})
-	first := true
+// search is Search, recording the keys matching each field in stage when it
+// is not nil. The matches of each field become a bitmap of document IDs,
+// intersected in the order of their estimated matches, most selective
+// first, stopping once none is left.
+func (im *IndexManager) search(query map[string]interface{}, stage *QueryStage) ([]string, error) {
+	order, err := im.estimateFilters(query)
+	if err != nil {
+		return nil, err
+	}
+	found, err := im.filter(query, order, nil, stage, nil)
+	if err != nil || found == nil {
+		return nil, err
+	}
+	return im.ids.keysOf(found), nil
+}
 
-	for field, value := range query {
-		if tree, exists := im.trees[field]; exists {
-			fieldResults := make(map[string]struct{})
-			tree.AscendGreaterOrEqual(indexItem{"", value}, func(i btree.Item) bool {
-				item := i.(indexItem)
-				if item.value == value {
-					fieldResults[item.key] = struct{}{}
-				}
-				return true
-			})
-
-			if first {
-				results = fieldResults
-				first = false
-			} else {
-				// Intersect results
-				for k := range results {
-					if _, exists := fieldResults[k]; !exists {
-						delete(results, k)
-					}
-				}
+// evaluateFilter returns the IDs of the documents matching the filter on
+// field, or nil if the field has no filterable index. Callers must hold the
+// lock.
+func (im *IndexManager) evaluateFilter(field string, value interface{}) (*Bitmap, error) {
+	if idx, exists := im.keywords[field]; exists {
+		return idx.match(value), nil
+	} else if idx, exists := im.numeric[field]; exists {
+		found, err := idx.searchFilter(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+		return found, nil
+	} else if tree, exists := im.trees[field]; exists {
+		found := NewBitmap()
+		// Equal values are adjacent, ordered by ID from the first
+		tree.AscendGreaterOrEqual(indexItem{0, value}, func(i btree.Item) bool {
+			item := i.(indexItem)
+			if compareIndexValues(item.value, value) != 0 {
+				return false
+			}
+			if item.value == value {
+				found.Add(item.id)
 			}
+			return true
+		})
+		return found, nil
+	} else if idx, exists := im.ips[field]; exists {
+		found, err := idx.search(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", field, err)
 		}
+		return found, nil
 	}
+	return nil, nil
+}
+
+// intersectBitmaps returns the IDs in every bitmap. Starting from the
+// smallest keeps each intersection as small as the result.
+func intersectBitmaps(bitmaps []*Bitmap) *Bitmap {
+	sort.Slice(bitmaps, func(i, j int) bool { return bitmaps[i].Len() < bitmaps[j].Len() })
+	result := bitmaps[0]
+	for _, b := range bitmaps[1:] {
+		if result.Len() == 0 {
+			break
+		}
+		result = result.And(b)
+	}
+	return result
+}
+
+// searchPrefixes returns the keys whose field starts with the prefix given
+// for it, for every field, recording the keys matching each in stage when it
+// is not nil. Every field needs a keyword index.
+func (im *IndexManager) searchPrefixes(prefixes map[string]string, stage *QueryStage) ([]string, error) {
+	im.RLock()
+	defer im.RUnlock()
 
-	// Convert results to slice
-	keys := make([]string, 0, len(results))
-	for k := range results {
-		keys = append(keys, k)
+	var matches []*Bitmap
+	for field, prefix := range prefixes {
+		idx, exists := im.keywords[field]
+		if !exists {
+			return nil, fmt.Errorf("%w: prefix on %s needs a keyword index", ErrInvalidQuery, field)
+		}
+		fieldResults := idx.prefix(prefix)
+		if stage != nil {
+			if stage.Candidates == nil {
+				stage.Candidates = make(map[string]int)
+			}
+			stage.Candidates[field] = fieldResults.Len()
+		}
+		matches = append(matches, fieldResults)
+	}
+	if len(matches) == 0 {
+		return []string{}, nil
 	}
+	return im.ids.keysOf(intersectBitmaps(matches)), nil
+}
 
-	return keys, nil
+// numericIndex returns the numeric index of field
+func (im *IndexManager) numericIndex(field string) (*NumericIndex, bool) {
+	im.RLock()
+	defer im.RUnlock()
+	idx, exists := im.numeric[field]
+	return idx, exists
+}
+
+// keywordIndex returns the keyword index of field
+func (im *IndexManager) keywordIndex(field string) (*KeywordIndex, bool) {
+	im.RLock()
+	defer im.RUnlock()
+	idx, exists := im.keywords[field]
+	return idx, exists
 }
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// IndexBatch collects index updates to apply together with Commit, so that
+// bulk loads take the index locks once per batch instead of once per
+// document, insert into the btrees in key order and add each trigram's
+// postings in one go
+type IndexBatch struct {
+	im      *IndexManager
+	updates []batchUpdate
+}
+
+type batchUpdate struct {
+	key   string
+	value interface{}
+}
+
+// BeginBatch starts a batch of index updates. Nothing is indexed until
+// Commit; a batch that is not committed is simply dropped.
+func (im *IndexManager) BeginBatch() *IndexBatch {
+	return &IndexBatch{im: im}
+}
+
+// Update adds the indexing of a key-value pair to the batch. Later updates
+// of a key in the batch win over earlier ones.
+func (b *IndexBatch) Update(key string, value interface{}) {
+	b.updates = append(b.updates, batchUpdate{key, value})
+}
+
+// Len returns the number of updates in the batch
+func (b *IndexBatch) Len() int {
+	return len(b.updates)
+}
+
+// Commit applies the updates of the batch to every index and empties it.
+// As with Update, values an index rejects do not stop the other updates;
+// the failures are returned together as an *IndexUpdateError.
+func (b *IndexBatch) Commit() error {
+	updates := b.updates
+	b.updates = nil
+	if len(updates) == 0 {
+		return nil
+	}
+
+	im := b.im
+	im.Lock()
+	defer im.Unlock()
+
+	var errs []IndexError
+	fail := func(key, field, indexType string, err error) {
+		errs = append(errs, IndexError{Time: time.Now(), Key: key, Field: field, Type: indexType, Error: err.Error()})
+	}
+	for _, u := range updates {
+		if _, ok := u.value.(map[string]interface{}); ok {
+			im.ids.assign(u.key)
+		}
+	}
+
+	for field, tree := range im.trees {
+		// The last update of each document decides its item, nil when the
+		// document no longer has the field
+		latest := make(map[uint32]*indexItem, len(updates))
+		for _, u := range updates {
+			if _, ok := u.value.(map[string]interface{}); !ok {
+				continue
+			}
+			id := im.ids.assign(u.key)
+			latest[id] = nil
+			if fieldValue, exists := fieldOf(u.value, field); exists {
+				latest[id] = &indexItem{id, fieldValue}
+			}
+		}
+		items := make([]indexItem, 0, len(latest))
+		for id, item := range latest {
+			if item == nil {
+				tree.remove(id)
+			} else {
+				items = append(items, *item)
+			}
+		}
+		commitTree(tree, items)
+	}
+	for field, vec := range im.vectors {
+		for _, u := range updates {
+			fieldValue, exists := fieldOf(u.value, field)
+			if !exists {
+				continue
+			}
+			vector, ok := vectorValue(fieldValue)
+			if !ok {
+				fail(u.key, field, "vector", errNotVector)
+				continue
+			}
+			if err := vec.Update(u.key, vector); err != nil {
+				fail(u.key, field, "vector", err)
+				continue
+			}
+			vec.setPayload(u.key, u.value.(map[string]interface{}))
+			im.logVector(field, u.key, vec)
+		}
+	}
+	for field, idx := range im.text {
+		var docs []batchText
+		for _, u := range updates {
+			if fieldValue, exists := fieldOf(u.value, field); exists {
+				if text, ok := textValue(fieldValue); ok {
+					docs = append(docs, batchText{u.key, text})
+				}
+			}
+		}
+		idx.updateBatch(docs)
+	}
+	for field, idx := range im.ips {
+		for _, u := range updates {
+			if fieldValue, exists := fieldOf(u.value, field); exists {
+				idx.Update(u.key, fieldValue)
+			}
+		}
+	}
+	for field, idx := range im.keywords {
+		for _, u := range updates {
+			if fieldValue, exists := fieldOf(u.value, field); exists {
+				idx.Update(u.key, fieldValue)
+			} else {
+				idx.Remove(u.key)
+			}
+		}
+	}
+	for field, idx := range im.numeric {
+		for _, u := range updates {
+			fieldValue, exists := fieldOf(u.value, field)
+			if !exists {
+				idx.Remove(u.key)
+				continue
+			}
+			if err := idx.Update(u.key, fieldValue); err != nil {
+				fail(u.key, field, "numeric", err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &IndexUpdateError{Errors: errs}
+	}
+	return nil
+}
+
+// fieldOf returns a top-level field of a document
+func fieldOf(value interface{}, field string) (interface{}, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	fieldValue, exists := m[field]
+	return fieldValue, exists
+}
+
+// commitTree inserts items, at most one per document, into tree in
+// ascending order, so consecutive inserts descend the same path and split
+// each node once while it fills
+func commitTree(tree *btreeIndex, items []indexItem) {
+	sort.Slice(items, func(i, j int) bool { return items[i].Less(items[j]) })
+	for _, item := range items {
+		tree.update(item.id, item.value)
+	}
+}
+
+// batchText is a document of a batch update of a text index
+type batchText struct {
+	key  string
+	text string
+}
+
+// updateBatch adds or updates documents under one lock. The trigrams of all
+// documents are grouped first, so each posting list is looked up once per
+// batch rather than once per document containing the trigram.
+func (ti *TrigramIndex) updateBatch(docs []batchText) {
+	if len(docs) == 0 {
+		return
+	}
+	ti.Lock()
+	defer ti.Unlock()
+
+	// Keep the last text of each document, removing the text it replaces
+	latest := make(map[uint32]string, len(docs))
+	for _, doc := range docs {
+		latest[ti.ids.assign(doc.key)] = doc.text
+	}
+	for id, text := range latest {
+		if oldText, exists := ti.docs[id]; exists {
+			ti.removeDocumentTrigrams(id, oldText)
+		}
+		ti.docs[id] = text
+	}
+
+	trigrams := make(map[string][]uint32)
+	iocs := make(map[string][]uint32)
+	for id, text := range latest {
+		for _, trigram := range ti.tokenizer.Tokens(text) {
+			trigrams[trigram] = append(trigrams[trigram], id)
+		}
+		for _, ioc := range ExtractIOCs(text) {
+			iocs[ioc] = append(iocs[ioc], id)
+		}
+	}
+	addPostings(ti.trigrams, trigrams)
+	addPostings(ti.iocs, iocs)
+}
+
+func addPostings(postings map[string]*Bitmap, additions map[string][]uint32) {
+	for term, ids := range additions {
+		docs := postings[term]
+		if docs == nil {
+			docs = NewBitmap()
+			postings[term] = docs
+		}
+		for _, id := range ids {
+			docs.Add(id)
+		}
+	}
+}
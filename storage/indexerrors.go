@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIndexing is returned by writes to a store with StrictIndexing whose
+// value cannot be added to an index
+var ErrIndexing = errors.New("index update failed")
+
+// recentIndexErrors is the number of index errors kept for the report
+const recentIndexErrors = 100
+
+// IndexError is a failure to add one field of a document to an index
+type IndexError struct {
+	Time  time.Time `json:"time" yaml:"time"`
+	Key   string    `json:"key" yaml:"key"`
+	Field string    `json:"field" yaml:"field"`
+	Type  string    `json:"type" yaml:"type"`
+	Error string    `json:"error" yaml:"error"`
+}
+
+// IndexUpdateError lists the indexes a document could not be added to. The
+// document was still added to every other index.
+type IndexUpdateError struct {
+	Errors []IndexError
+}
+
+func (e *IndexUpdateError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, ie := range e.Errors {
+		parts[i] = fmt.Sprintf("%s index on %s: %s", ie.Type, ie.Field, ie.Error)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (e *IndexUpdateError) Unwrap() error {
+	return ErrIndexing
+}
+
+// IndexErrorReport summarizes index errors since startup or the last reset
+type IndexErrorReport struct {
+	Total   uint64            `json:"total" yaml:"total"`
+	ByIndex map[string]uint64 `json:"by_index" yaml:"by_index"` // "field (type)" -> count
+	Recent  []IndexError      `json:"recent" yaml:"recent"`     // Newest first
+}
+
+// indexErrorLog counts index errors and keeps the most recent ones
+type indexErrorLog struct {
+	sync.Mutex
+	total   uint64
+	byIndex map[string]uint64
+	recent  []IndexError
+}
+
+func (l *indexErrorLog) add(errs []IndexError) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.byIndex == nil {
+		l.byIndex = make(map[string]uint64)
+	}
+	for _, ie := range errs {
+		l.total++
+		l.byIndex[fmt.Sprintf("%s (%s)", ie.Field, ie.Type)]++
+		l.recent = append(l.recent, ie)
+	}
+	if over := len(l.recent) - recentIndexErrors; over > 0 {
+		l.recent = append(l.recent[:0], l.recent[over:]...)
+	}
+}
+
+// updateIndexes adds a value to the indexes. Indexes that reject it are
+// recorded in the index error report rather than failing the write, which
+// has already changed the data map. Callers must hold the lock.
+func (s *Store) updateIndexes(key string, value interface{}) {
+	if injectFault(FaultIndex) != nil {
+		return // Dropped by fault injection
+	}
+	s.recordIndexErrors("key "+key, s.indexes.Update(key, value))
+}
+
+// commitIndexes commits a batch of index updates, recording the values
+// indexes reject like updateIndexes. Callers must hold the lock.
+func (s *Store) commitIndexes(batch *IndexBatch) {
+	if injectFault(FaultIndex) != nil {
+		return
+	}
+	n := batch.Len()
+	s.recordIndexErrors(fmt.Sprintf("batch of %d keys", n), batch.Commit())
+}
+
+// recordIndexErrors adds the failures of an index update to the report
+func (s *Store) recordIndexErrors(what string, err error) {
+	var updateErr *IndexUpdateError
+	if errors.As(err, &updateErr) {
+		s.indexErrors.add(updateErr.Errors)
+		if s.opts.Debug {
+			log.Printf("Index errors for %s: %v", what, err)
+		}
+	} else if err != nil {
+		log.Printf("Failed to update indexes for %s: %v", what, err)
+	}
+}
+
+// IndexErrors returns the index error report
+func (s *Store) IndexErrors() IndexErrorReport {
+	l := &s.indexErrors
+	l.Lock()
+	defer l.Unlock()
+
+	report := IndexErrorReport{
+		Total:   l.total,
+		ByIndex: make(map[string]uint64, len(l.byIndex)),
+		Recent:  make([]IndexError, len(l.recent)),
+	}
+	for index, count := range l.byIndex {
+		report.ByIndex[index] = count
+	}
+	copy(report.Recent, l.recent)
+	sort.SliceStable(report.Recent, func(i, j int) bool {
+		return report.Recent[i].Time.After(report.Recent[j].Time)
+	})
+	return report
+}
+
+// ResetIndexErrors clears the index error report
+func (s *Store) ResetIndexErrors() {
+	l := &s.indexErrors
+	l.Lock()
+	defer l.Unlock()
+
+	l.total = 0
+	l.byIndex = nil
+	l.recent = nil
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// indexRef names an index by its field and type
+type indexRef struct {
+	field, kind string
+}
+
+// indexUse is the use of one index since it was created or the store
+// started
+type indexUse struct {
+	since   time.Time
+	queries uint64
+	last    time.Time
+}
+
+// indexUsage counts the searches that read each index
+type indexUsage struct {
+	sync.Mutex
+	started time.Time
+	uses    map[indexRef]*indexUse
+}
+
+func newIndexUsage() *indexUsage {
+	return &indexUsage{started: time.Now(), uses: make(map[indexRef]*indexUse)}
+}
+
+// record counts one use of each index of refs
+func (u *indexUsage) record(refs []indexRef) {
+	if len(refs) == 0 {
+		return
+	}
+	now := time.Now()
+	u.Lock()
+	defer u.Unlock()
+
+	for _, ref := range refs {
+		use := u.uses[ref]
+		if use == nil {
+			use = &indexUse{since: u.started}
+			u.uses[ref] = use
+		}
+		use.queries++
+		use.last = now
+	}
+}
+
+// reset starts counting the uses of an index afresh, as when it is created
+// or removed
+func (u *indexUsage) reset(ref indexRef) {
+	u.Lock()
+	defer u.Unlock()
+	u.uses[ref] = &indexUse{since: time.Now()}
+}
+
+// IndexUsage is how much searches read an index
+type IndexUsage struct {
+	Field    string     `json:"field" yaml:"field"`
+	Type     string     `json:"type" yaml:"type"`
+	Since    time.Time  `json:"since" yaml:"since"` // Uses are counted from the creation of the index or the start of the server
+	Queries  uint64     `json:"queries" yaml:"queries"`
+	LastUsed *time.Time `json:"last_used,omitempty" yaml:"last_used,omitempty"`
+	Entries  int        `json:"entries" yaml:"entries"`
+	Bytes    int64      `json:"bytes" yaml:"bytes"`   // Estimated memory, see MemoryUsage
+	Unused   bool       `json:"unused" yaml:"unused"` // Not read within the window of the report
+}
+
+// IndexUsageReport is the use of every index, unused indexes first
+type IndexUsageReport struct {
+	Window      string       `json:"window" yaml:"window"`
+	Unused      int          `json:"unused" yaml:"unused"`
+	UnusedBytes int64        `json:"unused_bytes" yaml:"unused_bytes"` // Memory reclaimed by removing the unused indexes
+	Indexes     []IndexUsage `json:"indexes" yaml:"indexes"`
+}
+
+// IndexUsage reports the searches, counts, aggregations and tag counts that
+// read each index, flagging those not read within window as unused. An
+// index younger than window is unused only if it was never read. Uses are
+// counted in memory from the start of the server; searches answered from
+// the query cache count when they first run.
+func (s *Store) IndexUsage(window time.Duration) IndexUsageReport {
+	report := IndexUsageReport{Window: window.String(), Indexes: []IndexUsage{}}
+	memory := s.indexes.memoryUsage()
+	cutoff := time.Now().Add(-window)
+
+	s.usage.Lock()
+	defer s.usage.Unlock()
+
+	for _, m := range memory {
+		if m.Field == "" {
+			continue // The shared document IDs
+		}
+		usage := IndexUsage{Field: m.Field, Type: m.Type, Since: s.usage.started, Entries: m.Entries, Bytes: m.Bytes}
+		if use := s.usage.uses[indexRef{m.Field, m.Type}]; use != nil {
+			usage.Since = use.since
+			usage.Queries = use.queries
+			if use.queries > 0 {
+				last := use.last
+				usage.LastUsed = &last
+			}
+		}
+		switch {
+		case usage.LastUsed != nil:
+			usage.Unused = usage.LastUsed.Before(cutoff)
+		default:
+			usage.Unused = usage.Since.Before(cutoff)
+		}
+		if usage.Unused {
+			report.Unused++
+			report.UnusedBytes += usage.Bytes
+		}
+		report.Indexes = append(report.Indexes, usage)
+	}
+	sort.SliceStable(report.Indexes, func(i, j int) bool {
+		return report.Indexes[i].Unused && !report.Indexes[j].Unused
+	})
+	return report
+}
+
+// recordIndexUsage counts the indexes a search read: those its text and
+// vector stages searched, its filters and prefixes
+func (s *Store) recordIndexUsage(query SearchQuery, trace *queryTrace) {
+	var refs []indexRef
+	for _, stage := range trace.stages {
+		if (stage.Stage == "text" || stage.Stage == "vector") && stage.Index != "" {
+			refs = append(refs, indexRef{stage.Index, stage.Stage})
+		}
+	}
+	if trace.plan != nil {
+		for _, e := range trace.plan.filterOrder {
+			refs = append(refs, indexRef{e.field, e.kind})
+		}
+	}
+	for field := range query.Prefixes {
+		refs = append(refs, indexRef{field, "keyword"})
+	}
+	s.usage.record(refs)
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	cvePattern    = regexp.MustCompile(`(?i)\bCVE-\d{4}-\d{4,7}\b`)
+	hashPattern   = regexp.MustCompile(`\b[a-fA-F0-9]{32,128}\b`)
+	ipv4Pattern   = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Pattern   = regexp.MustCompile(`[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7}`)
+	urlPattern    = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>]+`)
+	domainPattern = regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}\b`)
+
+	// defang reverses common indicator defanging such as example[.]com and hxxp://
+	defang = strings.NewReplacer("[.]", ".", "(.)", ".", "[dot]", ".", "hxxp", "http", "[:]", ":")
+)
+
+// hashLengths are the hex lengths of MD5, SHA-1, SHA-256 and SHA-512
+var hashLengths = map[int]struct{}{32: {}, 40: {}, 64: {}, 128: {}}
+
+// ExtractIOCs returns the normalized indicators of compromise found in text:
+// CVE IDs, ATT&CK technique IDs, MD5/SHA hashes, IPv4/IPv6 addresses, URLs and
+// domains. These are indexed as exact tokens instead of being split into trigrams.
+func ExtractIOCs(text string) []string {
+	text = defang.Replace(text)
+	seen := make(map[string]struct{})
+	var iocs []string
+
+	add := func(ioc string) {
+		if _, exists := seen[ioc]; !exists {
+			seen[ioc] = struct{}{}
+			iocs = append(iocs, ioc)
+		}
+	}
+
+	for _, m := range cvePattern.FindAllString(text, -1) {
+		add(strings.ToUpper(m))
+	}
+
+	for _, t := range ExtractAttackTechniques(text) {
+		add(t)
+	}
+
+	for _, m := range hashPattern.FindAllString(text, -1) {
+		if _, ok := hashLengths[len(m)]; ok {
+			add(strings.ToLower(m))
+		}
+	}
+
+	for _, m := range ipv4Pattern.FindAllString(text, -1) {
+		if ip := net.ParseIP(m); ip != nil {
+			add(ip.String())
+		}
+	}
+
+	for _, m := range ipv6Pattern.FindAllString(text, -1) {
+		if ip := net.ParseIP(m); ip != nil && strings.Contains(m, ":") {
+			add(ip.String())
+		}
+	}
+
+	for _, m := range urlPattern.FindAllString(text, -1) {
+		m = strings.TrimRight(m, ".,;:)]}")
+		add(strings.ToLower(m))
+		if u, err := url.Parse(m); err == nil && u.Hostname() != "" {
+			add(strings.ToLower(u.Hostname()))
+		}
+	}
+
+	for _, m := range domainPattern.FindAllString(text, -1) {
+		if net.ParseIP(m) == nil {
+			add(strings.ToLower(m))
+		}
+	}
+
+	return iocs
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// IPIndex stores IP addresses in binary radix trees (one per address family)
+// supporting CIDR and range lookups
+type IPIndex struct {
+	sync.RWMutex
+	ids  *docIDs
+	v4   *ipNode
+	v6   *ipNode
+	docs map[uint32][]net.IP // document ID -> indexed addresses
+}
+
+type ipNode struct {
+	children [2]*ipNode
+	ids      *Bitmap // set on leaves only
+}
+
+// NewIPIndex creates an empty IP index
+func NewIPIndex() *IPIndex {
+	return newIPIndex(newDocIDs())
+}
+
+func newIPIndex(ids *docIDs) *IPIndex {
+	return &IPIndex{
+		ids:  ids,
+		v4:   &ipNode{},
+		v6:   &ipNode{},
+		docs: make(map[uint32][]net.IP),
+	}
+}
+
+// ipValues extracts IP addresses from a string or list of strings
+func ipValues(value interface{}) []net.IP {
+	var ips []net.IP
+	add := func(v interface{}) {
+		if s, ok := v.(string); ok {
+			if ip := net.ParseIP(s); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			add(item)
+		}
+	case []string:
+		for _, item := range v {
+			add(item)
+		}
+	default:
+		add(v)
+	}
+	return ips
+}
+
+// normalizeIP returns the 4-byte form of IPv4 addresses and 16 bytes otherwise
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
+func (idx *IPIndex) root(ip net.IP) *ipNode {
+	if len(ip) == net.IPv4len {
+		return idx.v4
+	}
+	return idx.v6
+}
+
+// Update replaces the addresses indexed for key
+func (idx *IPIndex) Update(key string, value interface{}) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	idx.remove(key)
+
+	ips := ipValues(value)
+	if len(ips) == 0 {
+		return
+	}
+	id := idx.ids.assign(key)
+
+	for i, ip := range ips {
+		ip = normalizeIP(ip)
+		ips[i] = ip
+
+		node := idx.root(ip)
+		for bit := 0; bit < len(ip)*8; bit++ {
+			b := ipBit(ip, bit)
+			if node.children[b] == nil {
+				node.children[b] = &ipNode{}
+			}
+			node = node.children[b]
+		}
+		if node.ids == nil {
+			node.ids = NewBitmap()
+		}
+		node.ids.Add(id)
+	}
+	idx.docs[id] = ips
+}
+
+// Remove deletes a key from the index
+func (idx *IPIndex) Remove(key string) {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.remove(key)
+}
+
+func (idx *IPIndex) remove(key string) {
+	id, exists := idx.ids.lookup(key)
+	if !exists {
+		return
+	}
+	for _, ip := range idx.docs[id] {
+		removeIP(idx.root(ip), ip, 0, id)
+	}
+	delete(idx.docs, id)
+}
+
+// removeIP deletes id from the leaf for ip and reports whether node became empty
+func removeIP(node *ipNode, ip net.IP, bit int, id uint32) bool {
+	if node == nil {
+		return false
+	}
+	if bit == len(ip)*8 {
+		node.ids.Remove(id)
+		return node.ids.Len() == 0
+	}
+
+	b := ipBit(ip, bit)
+	if removeIP(node.children[b], ip, bit+1, id) {
+		node.children[b] = nil
+	}
+	return node.children[0] == nil && node.children[1] == nil && (node.ids == nil || node.ids.Len() == 0)
+}
+
+// Len returns the number of indexed documents
+func (idx *IPIndex) Len() int {
+	idx.RLock()
+	defer idx.RUnlock()
+	return len(idx.docs)
+}
+
+// holds reports whether the index has entries of a document ID
+func (idx *IPIndex) holds(id uint32) bool {
+	idx.RLock()
+	defer idx.RUnlock()
+	_, exists := idx.docs[id]
+	return exists
+}
+
+// SearchCIDR returns keys with an address inside the CIDR block
+func (idx *IPIndex) SearchCIDR(cidr string) ([]string, error) {
+	found, err := idx.searchCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	return idx.ids.keysOf(found), nil
+}
+
+func (idx *IPIndex) searchCIDR(cidr string) (*Bitmap, error) {
+	lo, hi, err := cidrBounds(cidr)
+	if err != nil {
+		return nil, err
+	}
+	return idx.searchRange(lo, hi), nil
+}
+
+// cidrBounds returns the first and last address of a CIDR block
+func cidrBounds(cidr string) (net.IP, net.IP, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cidr: %s", cidr)
+	}
+
+	lo := normalizeIP(network.IP)
+	hi := make(net.IP, len(lo))
+	for i := range lo {
+		hi[i] = lo[i] | ^network.Mask[len(network.Mask)-len(lo)+i]
+	}
+	return lo, hi, nil
+}
+
+// SearchRange returns keys with an address in [from, to]
+func (idx *IPIndex) SearchRange(from, to string) ([]string, error) {
+	found, err := idx.searchFromTo(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return idx.ids.keysOf(found), nil
+}
+
+func (idx *IPIndex) searchFromTo(from, to string) (*Bitmap, error) {
+	lo, hi, err := rangeBounds(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return idx.searchRange(lo, hi), nil
+}
+
+// rangeBounds parses the addresses of an inclusive range
+func rangeBounds(from, to string) (net.IP, net.IP, error) {
+	lo, hi := net.ParseIP(from), net.ParseIP(to)
+	if lo == nil || hi == nil {
+		return nil, nil, fmt.Errorf("invalid ip range: %s - %s", from, to)
+	}
+
+	lo, hi = normalizeIP(lo), normalizeIP(hi)
+	if len(lo) != len(hi) {
+		return nil, nil, fmt.Errorf("ip range mixes address families: %s - %s", from, to)
+	}
+	return lo, hi, nil
+}
+
+func (idx *IPIndex) searchRange(lo, hi net.IP) *Bitmap {
+	idx.RLock()
+	defer idx.RUnlock()
+
+	found := NewBitmap()
+	prefix := make(net.IP, len(lo))
+	collectRange(idx.root(lo), prefix, 0, lo, hi, found)
+	return found
+}
+
+// collectRange walks the subtree whose addresses start with the first bit bits
+// of prefix, pruning subtrees that lie outside [lo, hi]
+func collectRange(node *ipNode, prefix net.IP, bit int, lo, hi net.IP, found *Bitmap) {
+	if node == nil {
+		return
+	}
+
+	first, last := subtreeBounds(prefix, bit)
+	if bytes.Compare(last, lo) < 0 || bytes.Compare(first, hi) > 0 {
+		return
+	}
+
+	if bit == len(prefix)*8 {
+		found.AddAll(node.ids)
+		return
+	}
+
+	for b := 0; b < 2; b++ {
+		setIPBit(prefix, bit, b)
+		collectRange(node.children[b], prefix, bit+1, lo, hi, found)
+	}
+	setIPBit(prefix, bit, 0)
+}
+
+// subtreeBounds returns the lowest and highest address sharing the first bits of prefix
+func subtreeBounds(prefix net.IP, bits int) (net.IP, net.IP) {
+	first := make(net.IP, len(prefix))
+	last := make(net.IP, len(prefix))
+	for i := range prefix {
+		var mask byte
+		switch {
+		case bits >= (i+1)*8:
+			mask = 0xff
+		case bits > i*8:
+			mask = byte(0xff << (8 - (bits - i*8)))
+		}
+		first[i] = prefix[i] & mask
+		last[i] = prefix[i]&mask | ^mask
+	}
+	return first, last
+}
+
+func ipBit(ip net.IP, bit int) int {
+	return int(ip[bit/8]>>(7-uint(bit%8))) & 1
+}
+
+func setIPBit(ip net.IP, bit int, value int) {
+	mask := byte(1 << (7 - uint(bit%8)))
+	if value == 1 {
+		ip[bit/8] |= mask
+	} else {
+		ip[bit/8] &^= mask
+	}
+}
+
+// Search evaluates an IP filter: a single address, {"cidr": "10.0.0.0/8"} or
+// {"from": "10.0.0.1", "to": "10.0.0.99"}
+func (idx *IPIndex) Search(filter interface{}) ([]string, error) {
+	found, err := idx.search(filter)
+	if err != nil {
+		return nil, err
+	}
+	return idx.ids.keysOf(found), nil
+}
+
+// search evaluates an IP filter to the IDs of the matching documents
+func (idx *IPIndex) search(filter interface{}) (*Bitmap, error) {
+	lo, hi, err := parseIPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	return idx.searchRange(lo, hi), nil
+}
+
+// parseIPFilter converts an IP filter to the inclusive range of addresses
+// it matches
+func parseIPFilter(filter interface{}) (net.IP, net.IP, error) {
+	switch f := filter.(type) {
+	case string:
+		ip := net.ParseIP(f)
+		if ip == nil {
+			return nil, nil, fmt.Errorf("invalid ip: %s", f)
+		}
+		return normalizeIP(ip), normalizeIP(ip), nil
+	case map[string]interface{}:
+		if cidr, ok := f["cidr"].(string); ok {
+			return cidrBounds(cidr)
+		}
+		from, okFrom := f["from"].(string)
+		to, okTo := f["to"].(string)
+		if okFrom && okTo {
+			return rangeBounds(from, to)
+		}
+	}
+	return nil, nil, fmt.Errorf("unsupported ip filter: %v", filter)
+}
+
+// probe returns the IDs in within of the documents with an address matching
+// an IP filter, checking the addresses of each document instead of the trees
+func (idx *IPIndex) probe(filter interface{}, within *Bitmap) (*Bitmap, error) {
+	lo, hi, err := parseIPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	idx.RLock()
+	defer idx.RUnlock()
+
+	found := NewBitmap()
+	within.Each(func(id uint32) bool {
+		for _, ip := range idx.docs[id] {
+			if len(ip) == len(lo) && bytes.Compare(ip, lo) >= 0 && bytes.Compare(ip, hi) <= 0 {
+				found.Add(id)
+				break
+			}
+		}
+		return true
+	})
+	return found, nil
+}
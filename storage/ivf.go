@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"time"
+)
+
+// Defaults and bounds of OptimizeVectorIndex
+const (
+	DefaultOptimizeSample = 100
+	DefaultOptimizeK      = 10
+	MaxOptimizeSample     = 10000
+
+	maxTrainVectors  = 100000 // Centroids are trained on a sample of at most this many vectors
+	kmeansIterations = 20
+)
+
+// VectorOptimizeRequest asks to partition a vector index around centroids
+// trained on its vectors, so searches compare only the vectors of the
+// partitions nearest the query, like an IVF index
+type VectorOptimizeRequest struct {
+	Lists      int  `json:"lists,omitempty"`      // Partitions, by default the square root of the vectors
+	Probes     int  `json:"probes,omitempty"`     // Partitions searched per query, by default a tenth of the lists
+	Sample     int  `json:"sample,omitempty"`     // Vectors held out of training to measure recall, default DefaultOptimizeSample
+	K          int  `json:"k,omitempty"`          // Results compared for recall, default DefaultOptimizeK
+	Exhaustive bool `json:"exhaustive,omitempty"` // Drop the partitions and compare every vector again
+}
+
+// VectorOptimizeReport describes the partitions trained for a vector index
+// and how searches of the held-out vectors fared with them
+type VectorOptimizeReport struct {
+	Field      string  `json:"field" yaml:"field"`
+	Vectors    int     `json:"vectors" yaml:"vectors"`
+	Lists      int     `json:"lists" yaml:"lists"` // 0 when searches are exhaustive
+	Probes     int     `json:"probes" yaml:"probes"`
+	TrainedOn  int     `json:"trained_on" yaml:"trained_on"`
+	Iterations int     `json:"iterations" yaml:"iterations"`
+	TrainTime  float64 `json:"train_ms" yaml:"train_ms"`
+
+	HeldOut           int     `json:"held_out" yaml:"held_out"`
+	K                 int     `json:"k" yaml:"k"`
+	Recall            float64 `json:"recall" yaml:"recall"`                               // Mean fraction of the exhaustive top k found
+	Compared          float64 `json:"compared" yaml:"compared"`                           // Mean vectors compared per query
+	Latency           float64 `json:"latency_ms" yaml:"latency_ms"`                       // Mean time of a query
+	ExhaustiveLatency float64 `json:"exhaustive_latency_ms" yaml:"exhaustive_latency_ms"` // Mean time of a query comparing every vector
+}
+
+// ivfPartition assigns the vectors of an index to their nearest centroid
+type ivfPartition struct {
+	centroids [][]float32           // Normalized
+	lists     []map[string]struct{} // Keys of the vectors nearest each centroid
+	assigned  map[string]int
+	probes    int
+}
+
+func newIVFPartition(centroids [][]float32, probes int) *ivfPartition {
+	p := &ivfPartition{
+		centroids: centroids,
+		lists:     make([]map[string]struct{}, len(centroids)),
+		assigned:  make(map[string]int),
+		probes:    probes,
+	}
+	for i := range p.lists {
+		p.lists[i] = make(map[string]struct{})
+	}
+	return p
+}
+
+// nearest returns the list of the centroid most similar to a normalized
+// vector
+func (p *ivfPartition) nearest(vector []float32) int {
+	return nearestCentroid(p.centroids, vector)
+}
+
+func (p *ivfPartition) add(key string, list int) {
+	if old, exists := p.assigned[key]; exists {
+		delete(p.lists[old], key)
+	}
+	p.lists[list][key] = struct{}{}
+	p.assigned[key] = list
+}
+
+func (p *ivfPartition) remove(key string) {
+	if list, exists := p.assigned[key]; exists {
+		delete(p.lists[list], key)
+		delete(p.assigned, key)
+	}
+}
+
+// probe returns the lists of the centroids most similar to a normalized
+// query, most similar first
+func (p *ivfPartition) probe(query []float32) []int {
+	order := make([]int, len(p.centroids))
+	scores := make([]float32, len(p.centroids))
+	for i, centroid := range p.centroids {
+		order[i], scores[i] = i, cosineSimilarity(query, centroid)
+	}
+	sort.Slice(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+	return order[:min(p.probes, len(order))]
+}
+
+func nearestCentroid(centroids [][]float32, vector []float32) int {
+	best, bestScore := 0, float32(math.Inf(-1))
+	for i, centroid := range centroids {
+		if score := cosineSimilarity(vector, centroid); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// snapshot returns the vectors of the index. They are never changed in
+// place, so they may be read without the lock.
+func (vi *VectorIndex) snapshot() map[string][]float32 {
+	vi.RLock()
+	defer vi.RUnlock()
+	vectors := make(map[string][]float32, len(vi.vectors))
+	for key, vector := range vi.vectors {
+		vectors[key] = vector
+	}
+	return vectors
+}
+
+// partition installs p, assigning the vectors of the snapshot to the
+// lists computed for them and vectors written since to their nearest list.
+// A nil p makes searches exhaustive again.
+func (vi *VectorIndex) partition(p *ivfPartition, snapshot map[string][]float32, lists map[string]int) {
+	vi.Lock()
+	defer vi.Unlock()
+	if p != nil {
+		for key, vector := range vi.vectors {
+			if list, ok := lists[key]; ok && sameVector(snapshot[key], vector) {
+				p.add(key, list)
+			} else {
+				p.add(key, p.nearest(vector))
+			}
+		}
+	}
+	vi.ivf = p
+}
+
+// sameVector reports whether a and b are the same stored vector
+func sameVector(a, b []float32) bool {
+	return len(a) > 0 && len(a) == len(b) && &a[0] == &b[0]
+}
+
+// exhaustive returns the k vectors most similar to a normalized query,
+// comparing every vector even if the index is partitioned
+func (vi *VectorIndex) exhaustive(query []float32, k int) []VectorSearchResult {
+	vi.RLock()
+	defer vi.RUnlock()
+	results := make([]VectorSearchResult, 0, len(vi.vectors))
+	for key, vec := range vi.vectors {
+		results = append(results, VectorSearchResult{Key: key, Score: cosineSimilarity(query, vec)})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results[:min(k, len(results))]
+}
+
+// OptimizeVectorIndex trains centroids over the vectors of the vector index
+// on field with k-means, leaving a random sample out, and partitions the
+// index around them: each vector joins the list of its nearest centroid,
+// as do vectors written later, and searches compare only the vectors of
+// the Probes lists nearest the query. The held-out vectors are then
+// searched both ways to report the recall and latency of the partitions.
+// Partitions are not persisted, so they are trained again after a restart.
+func (s *Store) OptimizeVectorIndex(ctx context.Context, field string, req VectorOptimizeRequest, progress func(done, total int)) (*VectorOptimizeReport, error) {
+	if req.Lists < 0 || req.Probes < 0 || req.K < 0 || req.Sample < 0 || req.Sample > MaxOptimizeSample {
+		return nil, fmt.Errorf("%w: lists, probes, k and sample must not be negative, and sample must be at most %d", ErrInvalidQuery, MaxOptimizeSample)
+	}
+	if req.Sample == 0 {
+		req.Sample = DefaultOptimizeSample
+	}
+	if req.K == 0 {
+		req.K = DefaultOptimizeK
+	}
+	s.indexes.RLock()
+	idx, exists := s.indexes.vectors[field]
+	s.indexes.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: index %s (vector)", ErrNotFound, field)
+	}
+
+	snapshot := idx.snapshot()
+	keys := make([]string, 0, len(snapshot))
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	heldOut := keys[:min(req.Sample, len(keys)/2)]
+	train := keys[len(heldOut):]
+	train = train[:min(len(train), maxTrainVectors)]
+	report := &VectorOptimizeReport{Field: field, Vectors: len(keys), HeldOut: len(heldOut), K: req.K}
+
+	if req.Exhaustive {
+		if req.Lists != 0 || req.Probes != 0 {
+			return nil, fmt.Errorf("%w: exhaustive takes no lists or probes", ErrInvalidQuery)
+		}
+		idx.partition(nil, nil, nil)
+	} else {
+		if len(train) == 0 {
+			return nil, fmt.Errorf("%w: the index on %s holds no vectors to train on", ErrInvalidQuery, field)
+		}
+		lists := req.Lists
+		if lists == 0 {
+			lists = max(1, int(math.Sqrt(float64(len(keys)))))
+		}
+		if lists > len(train) {
+			return nil, fmt.Errorf("%w: lists must be at most the %d vectors trained on", ErrInvalidQuery, len(train))
+		}
+		probes := req.Probes
+		if probes == 0 {
+			probes = (lists + 9) / 10
+		}
+		if probes > lists {
+			return nil, fmt.Errorf("%w: probes must be at most the %d lists", ErrInvalidQuery, lists)
+		}
+
+		start := time.Now()
+		vectors := make([][]float32, len(train))
+		for i, key := range train {
+			vectors[i] = snapshot[key]
+		}
+		iterations, err := idx.train(ctx, snapshot, vectors, lists, probes, progress)
+		if err != nil {
+			return nil, err
+		}
+		report.Lists, report.Probes, report.TrainedOn, report.Iterations = lists, probes, len(train), iterations
+		report.TrainTime = milliseconds(time.Since(start))
+	}
+
+	s.queryCache.clear() // Partitioned searches may return other vectors
+	measureRecall(idx, heldOut, snapshot, report)
+	return report, nil
+}
+
+// Partition trains lists centroids on the vectors of the index, at most
+// maxTrainVectors of them, and partitions it around them like
+// Store.OptimizeVectorIndex, returning the k-means iterations run. Lists
+// of 0 makes searches exhaustive again. The store's query cache is not
+// cleared, so Partition suits indexes of the caller's own, such as those
+// of benchmarks.
+func (vi *VectorIndex) Partition(ctx context.Context, lists, probes int, progress func(done, total int)) (int, error) {
+	if lists == 0 {
+		vi.partition(nil, nil, nil)
+		return 0, nil
+	}
+	snapshot := vi.snapshot()
+	vectors := make([][]float32, 0, min(len(snapshot), maxTrainVectors))
+	for _, vector := range snapshot {
+		if len(vectors) == maxTrainVectors {
+			break
+		}
+		vectors = append(vectors, vector)
+	}
+	switch {
+	case lists < 1 || lists > len(vectors):
+		return 0, fmt.Errorf("%w: lists must be between 1 and the %d vectors trained on", ErrInvalidQuery, len(vectors))
+	case probes < 1 || probes > lists:
+		return 0, fmt.Errorf("%w: probes must be between 1 and the %d lists", ErrInvalidQuery, lists)
+	}
+	return vi.train(ctx, snapshot, vectors, lists, probes, progress)
+}
+
+// train trains lists centroids on vectors and partitions the index around
+// them, assigning the vectors of snapshot to their nearest centroid
+func (vi *VectorIndex) train(ctx context.Context, snapshot map[string][]float32, vectors [][]float32, lists, probes int, progress func(done, total int)) (int, error) {
+	centroids, iterations, err := trainCentroids(ctx, vectors, lists, progress)
+	if err != nil {
+		return 0, err
+	}
+	assigned := make(map[string]int, len(snapshot))
+	for key, vector := range snapshot {
+		assigned[key] = nearestCentroid(centroids, vector)
+	}
+	vi.partition(newIVFPartition(centroids, probes), snapshot, assigned)
+	return iterations, nil
+}
+
+// SetProbes changes the lists a partitioned index searches per query,
+// trading recall for speed without training it again
+func (vi *VectorIndex) SetProbes(probes int) error {
+	vi.Lock()
+	defer vi.Unlock()
+	switch {
+	case vi.ivf == nil:
+		return fmt.Errorf("%w: the index is not partitioned", ErrInvalidQuery)
+	case probes < 1 || probes > len(vi.ivf.lists):
+		return fmt.Errorf("%w: probes must be between 1 and the %d lists", ErrInvalidQuery, len(vi.ivf.lists))
+	}
+	vi.ivf.probes = probes
+	return nil
+}
+
+// SearchCompared is Search, also returning the number of vectors compared
+// with the query
+func (vi *VectorIndex) SearchCompared(query []float32, k int) ([]VectorSearchResult, int, error) {
+	var stage QueryStage
+	results, err := vi.search(query, k, nil, nil, &stage)
+	return results, stage.Compared, err
+}
+
+// measureRecall searches the held-out vectors, each of which finds itself
+// first and leaves itself out, exhaustively and through the partitions
+func measureRecall(idx *VectorIndex, heldOut []string, snapshot map[string][]float32, report *VectorOptimizeReport) {
+	if len(heldOut) == 0 {
+		return
+	}
+	var exhaustiveTime, partitionedTime time.Duration
+	var recall float64
+	compared := 0
+	for _, key := range heldOut {
+		query := snapshot[key]
+
+		start := time.Now()
+		truth := idx.exhaustive(query, report.K+1)
+		exhaustiveTime += time.Since(start)
+
+		var stage QueryStage
+		start = time.Now()
+		found, _ := idx.search(query, report.K+1, nil, nil, &stage)
+		partitionedTime += time.Since(start)
+		compared += stage.Compared
+
+		want := make(map[string]struct{}, report.K)
+		for _, r := range truth {
+			if r.Key != key && len(want) < report.K {
+				want[r.Key] = struct{}{}
+			}
+		}
+		if len(want) == 0 {
+			recall++
+			continue
+		}
+		hits := 0
+		for _, r := range found {
+			if _, ok := want[r.Key]; ok {
+				hits++
+			}
+		}
+		recall += float64(hits) / float64(len(want))
+	}
+	n := float64(len(heldOut))
+	report.Recall = recall / n
+	report.Compared = float64(compared) / n
+	report.Latency = milliseconds(partitionedTime) / n
+	report.ExhaustiveLatency = milliseconds(exhaustiveTime) / n
+}
+
+// trainCentroids clusters normalized vectors around lists centroids with
+// spherical k-means, starting from randomly chosen vectors, and returns
+// the centroids and the iterations run
+func trainCentroids(ctx context.Context, vectors [][]float32, lists int, progress func(done, total int)) ([][]float32, int, error) {
+	dim := len(vectors[0])
+	centroids := make([][]float32, lists)
+	for i, j := range rand.Perm(len(vectors))[:lists] {
+		centroids[i] = append([]float32(nil), vectors[j]...)
+	}
+
+	assignment := make([]int, len(vectors))
+	for i := range assignment {
+		assignment[i] = -1
+	}
+	iterations := 0
+	for iterations < kmeansIterations {
+		if err := ctx.Err(); err != nil {
+			return nil, iterations, err
+		}
+		iterations++
+		changed := 0
+		for i, vector := range vectors {
+			if list := nearestCentroid(centroids, vector); list != assignment[i] {
+				assignment[i] = list
+				changed++
+			}
+		}
+		if progress != nil {
+			progress(iterations, kmeansIterations)
+		}
+		if changed == 0 {
+			break
+		}
+
+		sums := make([][]float32, lists)
+		counts := make([]int, lists)
+		for i := range sums {
+			sums[i] = make([]float32, dim)
+		}
+		for i, vector := range vectors {
+			sum := sums[assignment[i]]
+			for d, v := range vector {
+				sum[d] += v
+			}
+			counts[assignment[i]]++
+		}
+		for i, sum := range sums {
+			if counts[i] == 0 {
+				// An empty list starts again from a random vector
+				sum = append(sum[:0], vectors[rand.IntN(len(vectors))]...)
+			}
+			normalizeVector(sum)
+			centroids[i] = sum
+		}
+	}
+	return centroids, iterations, nil
+}
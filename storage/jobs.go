@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// jobsSuffix names the file next to the data file keeping the jobs, so
+// their results outlive a restart
+const jobsSuffix = ".jobs.json"
+
+// maxFinishedJobs is the number of finished jobs kept
+const maxFinishedJobs = 100
+
+// Job states
+const (
+	JobRunning     = "running"
+	JobDone        = "done"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+	JobInterrupted = "interrupted" // The server stopped while the job ran
+)
+
+// Kinds of the jobs the store runs
+const (
+	JobIndexBuild     = "index_build"
+	JobUpdateByQuery  = "update_by_query"
+	JobDeleteByQuery  = "delete_by_query"
+	JobVerify         = "verify"
+	JobVectorOptimize = "vector_optimize"
+	JobBlobGC         = "blob_gc"
+)
+
+// Job is a long operation running in the background, or one of the last
+// finished
+type Job struct {
+	ID       string            `json:"id" yaml:"id"`
+	Kind     string            `json:"kind" yaml:"kind"`
+	Params   map[string]string `json:"params,omitempty" yaml:"params,omitempty"` // What the job works on, such as the field of an index
+	State    string            `json:"state" yaml:"state"`
+	Done     int               `json:"done" yaml:"done"`   // Units of work done, such as documents
+	Total    int               `json:"total" yaml:"total"` // Units of work in all, 0 while unknown
+	Result   interface{}       `json:"result,omitempty" yaml:"result,omitempty"`
+	Error    string            `json:"error,omitempty" yaml:"error,omitempty"`
+	Started  time.Time         `json:"started" yaml:"started"`
+	Finished *time.Time        `json:"finished,omitempty" yaml:"finished,omitempty"`
+
+	transient bool // Listed but not written to the jobs file
+}
+
+// JobFunc does the work of a job, calling progress as it goes. It should
+// stop early and return ctx.Err() once ctx is cancelled; a result returned
+// with that error is kept as the partial result.
+type JobFunc func(ctx context.Context, progress func(done, total int)) (interface{}, error)
+
+// jobRun is a running job
+type jobRun struct {
+	job    Job
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the job stops
+}
+
+// jobQueue runs jobs and keeps the last finished ones, in a file next to
+// the data file unless path is empty
+type jobQueue struct {
+	mu       sync.Mutex
+	path     string
+	running  map[string]*jobRun
+	finished []Job // Oldest first
+}
+
+// jobFile is the on-disk format of the jobs
+type jobFile struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// newJobQueue returns a queue keeping its jobs at path. Jobs the file
+// lists as running were stopped by a restart and are marked interrupted.
+func newJobQueue(path string) *jobQueue {
+	q := &jobQueue{path: path, running: make(map[string]*jobRun)}
+	if path == "" {
+		return q
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read jobs: %v", err)
+		}
+		return q
+	}
+	var file jobFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		log.Printf("Failed to parse jobs: %v", err)
+		return q
+	}
+	for _, job := range file.Jobs {
+		if job.State == JobRunning {
+			job.State = JobInterrupted
+		}
+		q.finished = append(q.finished, job)
+	}
+	if len(q.finished) > maxFinishedJobs {
+		q.finished = q.finished[len(q.finished)-maxFinishedJobs:]
+	}
+	return q
+}
+
+// start registers a running job. Transient jobs are listed like the others
+// but never written to the jobs file.
+func (q *jobQueue) start(kind string, params map[string]string, transient bool) *jobRun {
+	var id [8]byte
+	rand.Read(id[:])
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &jobRun{
+		job: Job{
+			ID:      hex.EncodeToString(id[:]),
+			Kind:    kind,
+			Params:  params,
+			State:   JobRunning,
+			Started: time.Now(),
+
+			transient: transient,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running[run.job.ID] = run
+	if !transient {
+		q.save()
+	}
+	return run
+}
+
+// run does the work of a started job and records how it ended
+func (q *jobQueue) run(run *jobRun, fn JobFunc) {
+	result, err := fn(run.ctx, func(done, total int) {
+		q.mu.Lock()
+		run.job.Done, run.job.Total = done, total
+		q.mu.Unlock()
+	})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	finished := time.Now()
+	job := run.job
+	job.Result = result
+	job.Finished = &finished
+	switch {
+	case err == nil:
+		job.State = JobDone
+	case errors.Is(err, context.Canceled) && run.ctx.Err() != nil:
+		job.State = JobCancelled
+	default:
+		job.State = JobFailed
+		job.Error = err.Error()
+	}
+	delete(q.running, job.ID)
+	q.finished = append(q.finished, job)
+	if len(q.finished) > maxFinishedJobs {
+		q.finished = q.finished[len(q.finished)-maxFinishedJobs:]
+	}
+	if !job.transient {
+		q.save()
+	}
+	run.cancel()
+	close(run.done)
+}
+
+// get returns the job with id, running or among the last finished
+func (q *jobQueue) get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if run, exists := q.running[id]; exists {
+		return run.job, true
+	}
+	for _, job := range q.finished {
+		if job.ID == id {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// list returns the running jobs and the last finished ones, oldest first
+func (q *jobQueue) list() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]Job, 0, len(q.running)+len(q.finished))
+	jobs = append(jobs, q.finished...)
+	for _, run := range q.running {
+		jobs = append(jobs, run.job)
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Started.Before(jobs[j].Started)
+	})
+	return jobs
+}
+
+// cancel asks the running job with id to stop
+func (q *jobQueue) cancel(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if run, exists := q.running[id]; exists {
+		run.cancel()
+		return nil
+	}
+	for _, job := range q.finished {
+		if job.ID == id {
+			return fmt.Errorf("%w: job %s is %s", ErrInvalidQuery, id, job.State)
+		}
+	}
+	return fmt.Errorf("%w: job %s", ErrNotFound, id)
+}
+
+// wait waits for the job with id to stop, or ctx to end, and returns it
+func (q *jobQueue) wait(ctx context.Context, id string) (Job, error) {
+	q.mu.Lock()
+	run, running := q.running[id]
+	q.mu.Unlock()
+	if running {
+		select {
+		case <-run.done:
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		}
+	}
+	job, exists := q.get(id)
+	if !exists {
+		return Job{}, fmt.Errorf("%w: job %s", ErrNotFound, id)
+	}
+	return job, nil
+}
+
+// save writes the jobs next to the data file. Failures are logged, as
+// jobs outlive the requests that started them. Callers must hold mu.
+func (q *jobQueue) save() {
+	if q.path == "" {
+		return
+	}
+	var file jobFile
+	for _, job := range q.finished {
+		if !job.transient {
+			file.Jobs = append(file.Jobs, job)
+		}
+	}
+	for _, run := range q.running {
+		if !run.job.transient {
+			file.Jobs = append(file.Jobs, run.job)
+		}
+	}
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode jobs: %v", err)
+		return
+	}
+
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		log.Printf("Failed to write jobs: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		os.Remove(tmp)
+		log.Printf("Failed to write jobs: %v", err)
+	}
+}
+
+// jobsPath returns the path of the jobs file of a store, or "" for
+// read-only and in-memory stores, which write nothing
+func jobsPath(filepath string, opts StoreOptions) string {
+	if opts.ReadOnly || opts.Persistence == PersistMemory {
+		return ""
+	}
+	return filepath + jobsSuffix
+}
+
+// StartJob runs fn in the background as a job of kind, returning the job
+// to follow with Job and stop with CancelJob
+func (s *Store) StartJob(kind string, params map[string]string, fn JobFunc) Job {
+	run := s.jobs.start(kind, params, false)
+	job := run.job
+	go s.jobs.run(run, fn)
+	return job
+}
+
+// Job returns the job with id, running or among the last finished
+func (s *Store) Job(id string) (Job, bool) {
+	return s.jobs.get(id)
+}
+
+// Jobs returns the running jobs and the last finished ones, including
+// those of earlier runs of the server, oldest first
+func (s *Store) Jobs() []Job {
+	return s.jobs.list()
+}
+
+// CancelJob asks the running job with id to stop. Jobs stop at their next
+// check, such as the end of a batch of documents.
+func (s *Store) CancelJob(id string) error {
+	return s.jobs.cancel(id)
+}
+
+// WaitJob waits for the job with id to stop, or ctx to end, and returns it
+func (s *Store) WaitJob(ctx context.Context, id string) (Job, error) {
+	return s.jobs.wait(ctx, id)
+}
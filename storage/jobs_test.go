@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentIndexBuilds creates the same index from many goroutines at
+// once, which must start a single build
+func TestConcurrentIndexBuilds(t *testing.T) {
+	store, err := NewStore("", StoreOptions{Persistence: PersistMemory, SyncInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := range 100 {
+		if err := store.Set(fmt.Sprintf("k%d", i), map[string]interface{}{"severity": "high"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var started []*Job
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job, err := store.BuildIndex("severity", "keyword", IndexOptions{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			started = append(started, job)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	var jobs []*Job
+	for _, job := range started {
+		if job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("%d builds started, want 1", len(jobs))
+	}
+	if job, err := store.WaitJob(context.Background(), jobs[0].ID); err != nil || job.State != JobDone {
+		t.Fatalf("build = %+v, %v", job, err)
+	}
+	if count, err := store.Count(SearchQuery{Filters: map[string]interface{}{"severity": "high"}}, nil); err != nil || count != 100 {
+		t.Errorf("count after the build = %d, %v; want 100", count, err)
+	}
+}
+
+// TestIndexBuildJobsFile checks that the builds of CreateIndex, which
+// programs run on every start, are listed but not kept in the jobs file,
+// while those of BuildIndex survive a restart
+func TestIndexBuildJobsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.yaml")
+	open := func() *Store {
+		t.Helper()
+		store, err := NewStore(path, StoreOptions{SyncInterval: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	var built string
+	for start := range 3 {
+		store := open()
+		for _, field := range []string{"name", "tags"} {
+			if err := store.CreateIndex(field, "keyword"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if start == 0 {
+			job, err := store.BuildIndex("severity", "keyword", IndexOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.WaitJob(context.Background(), job.ID); err != nil {
+				t.Fatal(err)
+			}
+			built = job.ID
+		}
+
+		jobs := store.Jobs()
+		if want := 3; len(jobs) != want {
+			t.Errorf("start %d: %d jobs listed, want the 2 builds of this start and the one of BuildIndex", start, len(jobs))
+		}
+		if _, found := store.Job(built); !found {
+			t.Errorf("start %d: the build of BuildIndex is not listed", start)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := newJobQueue(path + jobsSuffix)
+	if jobs := q.list(); len(jobs) != 1 || jobs[0].ID != built {
+		t.Errorf("jobs file holds %+v, want only the build of BuildIndex", jobs)
+	}
+}
+
+// TestJobsFileIsBounded loads a jobs file holding more finished jobs than
+// are kept
+func TestJobsFileIsBounded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.yaml"+jobsSuffix)
+	q := newJobQueue(path)
+	for range maxFinishedJobs + 10 {
+		run := q.start(JobVerify, nil, false)
+		q.run(run, func(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+			return nil, nil
+		})
+	}
+	if n := len(newJobQueue(path).list()); n != maxFinishedJobs {
+		t.Errorf("%d jobs loaded, want %d", n, maxFinishedJobs)
+	}
+}
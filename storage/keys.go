@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidKey is returned for keys that are empty, too long or contain
+// characters that cannot be stored safely
+var ErrInvalidKey = errors.New("invalid key")
+
+// DefaultMaxKeyLength is the key length limit used by the server
+const DefaultMaxKeyLength = 1024
+
+// ValidateKey checks that a key is non-empty valid UTF-8 without control
+// characters and, when maxLength is positive, at most maxLength bytes long.
+// Slashes are allowed; keys holding them are addressed over HTTP with the
+// slashes percent-encoded.
+func ValidateKey(key string, maxLength int) error {
+	if key == "" {
+		return fmt.Errorf("%w: key is empty", ErrInvalidKey)
+	}
+	if maxLength > 0 && len(key) > maxLength {
+		return fmt.Errorf("%w: key is %d bytes, the limit is %d", ErrInvalidKey, len(key), maxLength)
+	}
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("%w: key is not valid UTF-8", ErrInvalidKey)
+	}
+	for i, r := range key {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: control character %U at byte %d", ErrInvalidKey, r, i)
+		}
+	}
+	return nil
+}
+
+// checkKey validates a key being written
+func (s *Store) checkKey(key string) error {
+	return ValidateKey(key, s.opts.MaxKeyLength)
+}
+
+// Keys returns up to limit live keys starting with prefix in sorted order,
+// beginning after the key after, and whether more keys follow. A limit of 0
+// returns every key.
+func (s *Store) Keys(prefix, after string, limit int) ([]string, bool) {
+	var keys []string
+	s.Range(func(key string, _ *Entry) bool {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	sort.Strings(keys)
+
+	if limit > 0 && len(keys) > limit {
+		return keys[:limit], true
+	}
+	return keys, false
+}
+
+// DeleteKeys deletes the given keys under one lock and returns how many
+// existed
+func (s *Store) DeleteKeys(keys []string) int {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.opts.ReadOnly {
+		return 0
+	}
+
+	var deleted int
+	for _, key := range keys {
+		if _, exists := s.data[key]; exists {
+			s.deleteEntry(key)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		s.dirty = true
+		s.counters.deletes.Add(uint64(deleted))
+	}
+	return deleted
+}
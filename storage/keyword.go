@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/btree"
+)
+
+// KeywordIndex maps whole field values to the keys holding them, for exact
+// and prefix matching of tags, enums, hostnames and other values that are
+// not text to tokenize. Lists index each of their elements. Values are
+// compared as strings, case-sensitively unless the index ignores case, so
+// numbers and booleans match their string forms.
+type KeywordIndex struct {
+	sync.RWMutex
+	ignoreCase bool
+	ids        *docIDs
+	values     map[string]*Bitmap    // value -> document IDs
+	terms      *btree.BTreeG[string] // the values, ordered for prefix scans
+	docs       map[uint32][]string   // document ID -> indexed values
+}
+
+// NewKeywordIndex creates an empty keyword index. With ignoreCase values
+// and queries are lowercased.
+func NewKeywordIndex(ignoreCase bool) *KeywordIndex {
+	return newKeywordIndex(ignoreCase, newDocIDs())
+}
+
+func newKeywordIndex(ignoreCase bool, ids *docIDs) *KeywordIndex {
+	return &KeywordIndex{
+		ignoreCase: ignoreCase,
+		ids:        ids,
+		values:     make(map[string]*Bitmap),
+		terms:      btree.NewOrderedG[string](32),
+		docs:       make(map[uint32][]string),
+	}
+}
+
+// keywordValues returns the values of a field to index: a scalar, or the
+// scalars of a list. Maps and nested lists are not indexed.
+func keywordValues(value interface{}) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := keywordScalar(item); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	case []string:
+		return v
+	}
+	if s, ok := keywordScalar(value); ok {
+		return []string{s}
+	}
+	return nil
+}
+
+func keywordScalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func (ki *KeywordIndex) normalize(value string) string {
+	if ki.ignoreCase {
+		return strings.ToLower(value)
+	}
+	return value
+}
+
+// Update replaces the values indexed for key
+func (ki *KeywordIndex) Update(key string, value interface{}) {
+	ki.Lock()
+	defer ki.Unlock()
+
+	ki.removeLocked(key)
+	values := keywordValues(value)
+	if len(values) == 0 {
+		return
+	}
+	id := ki.ids.assign(key)
+	indexed := make([]string, 0, len(values))
+	for _, v := range values {
+		v = ki.normalize(v)
+		docs, exists := ki.values[v]
+		if !exists {
+			docs = NewBitmap()
+			ki.values[v] = docs
+			ki.terms.ReplaceOrInsert(v)
+		}
+		if docs.Add(id) {
+			indexed = append(indexed, v)
+		}
+	}
+	ki.docs[id] = indexed
+}
+
+// Remove removes key from the index
+func (ki *KeywordIndex) Remove(key string) {
+	ki.Lock()
+	defer ki.Unlock()
+	ki.removeLocked(key)
+}
+
+func (ki *KeywordIndex) removeLocked(key string) {
+	id, exists := ki.ids.lookup(key)
+	if !exists {
+		return
+	}
+	for _, v := range ki.docs[id] {
+		docs := ki.values[v]
+		docs.Remove(id)
+		if docs.Len() == 0 {
+			delete(ki.values, v)
+			ki.terms.Delete(v)
+		}
+	}
+	delete(ki.docs, id)
+}
+
+// Len returns the number of indexed documents
+func (ki *KeywordIndex) Len() int {
+	ki.RLock()
+	defer ki.RUnlock()
+	return len(ki.docs)
+}
+
+// holds reports whether the index has entries of a document ID
+func (ki *KeywordIndex) holds(id uint32) bool {
+	ki.RLock()
+	defer ki.RUnlock()
+	_, exists := ki.docs[id]
+	return exists
+}
+
+// Match returns the keys holding value, or any element of it if it is a list
+func (ki *KeywordIndex) Match(value interface{}) []string {
+	return ki.ids.keysOf(ki.match(value))
+}
+
+// match returns the IDs of the documents holding value, or any element of
+// it if it is a list
+func (ki *KeywordIndex) match(value interface{}) *Bitmap {
+	ki.RLock()
+	defer ki.RUnlock()
+
+	matches := NewBitmap()
+	for _, v := range keywordValues(value) {
+		if docs, exists := ki.values[ki.normalize(v)]; exists {
+			matches.AddAll(docs)
+		}
+	}
+	return matches
+}
+
+// Prefix returns the keys holding a value that starts with prefix
+func (ki *KeywordIndex) Prefix(prefix string) []string {
+	return ki.ids.keysOf(ki.prefix(prefix))
+}
+
+// prefix returns the IDs of the documents holding a value that starts with
+// prefix
+func (ki *KeywordIndex) prefix(prefix string) *Bitmap {
+	ki.RLock()
+	defer ki.RUnlock()
+
+	prefix = ki.normalize(prefix)
+	matches := NewBitmap()
+	ki.terms.AscendGreaterOrEqual(prefix, func(v string) bool {
+		if !strings.HasPrefix(v, prefix) {
+			return false
+		}
+		matches.AddAll(ki.values[v])
+		return true
+	})
+	return matches
+}
+
+// estimate returns the number of documents holding value, or any element of
+// it, without merging their IDs
+func (ki *KeywordIndex) estimate(value interface{}) int {
+	ki.RLock()
+	defer ki.RUnlock()
+
+	n := 0
+	for _, v := range keywordValues(value) {
+		if docs, exists := ki.values[ki.normalize(v)]; exists {
+			n += docs.Len()
+		}
+	}
+	return min(n, len(ki.docs))
+}
+
+// probe returns the IDs in within of the documents holding value, or any
+// element of it, checking the values of each document
+func (ki *KeywordIndex) probe(value interface{}, within *Bitmap) *Bitmap {
+	ki.RLock()
+	defer ki.RUnlock()
+
+	wanted := make(map[string]struct{})
+	for _, v := range keywordValues(value) {
+		wanted[ki.normalize(v)] = struct{}{}
+	}
+	matches := NewBitmap()
+	within.Each(func(id uint32) bool {
+		for _, v := range ki.docs[id] {
+			if _, ok := wanted[v]; ok {
+				matches.Add(id)
+				break
+			}
+		}
+		return true
+	})
+	return matches
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrDecodeLimit is returned when a document exceeds DecodeLimits
+var ErrDecodeLimit = errors.New("document exceeds decode limits")
+
+// ErrParsesBusy is returned when DecodeLimits.MaxParses documents are
+// already being parsed
+var ErrParsesBusy = errors.New("too many documents being parsed")
+
+// timedParses counts the parses with a timeout still running, including
+// those abandoned after it
+var timedParses atomic.Int64
+
+// DecodeLimits protects decoding of untrusted YAML against oversized
+// documents, deep nesting, alias expansion bombs ("billion laughs") and
+// documents that are slow to parse. Zero values disable the corresponding
+// limit.
+type DecodeLimits struct {
+	MaxSize           int64         // Maximum document size in bytes
+	MaxDepth          int           // Maximum nesting depth, counted through aliases
+	MaxAliasExpansion int           // Maximum number of nodes produced by expanding aliases and merge keys
+	Timeout           time.Duration // Longest parsing and checking a document may take
+	MaxParses         int           // Maximum parses with a Timeout running at once, counting those past it
+}
+
+var DefaultDecodeLimits = DecodeLimits{
+	MaxSize:           16 << 20, // 16MB
+	MaxDepth:          100,
+	MaxAliasExpansion: 100000,
+	Timeout:           10 * time.Second,
+	MaxParses:         64,
+}
+
+// ReadAll reads r, failing once more than MaxSize bytes have been read
+func (l DecodeLimits) ReadAll(r io.Reader) ([]byte, error) {
+	if l.MaxSize <= 0 {
+		return io.ReadAll(r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, l.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > l.MaxSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrDecodeLimit, l.MaxSize)
+	}
+	return data, nil
+}
+
+// Parse parses a YAML document into a node tree and checks it against the
+// limits. Parsing into a node does not expand aliases, so it is safe to run
+// before the checks.
+func (l DecodeLimits) Parse(source []byte) (*yaml.Node, error) {
+	if l.MaxSize > 0 && int64(len(source)) > l.MaxSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrDecodeLimit, l.MaxSize)
+	}
+	if l.Timeout <= 0 {
+		return l.parse(source)
+	}
+
+	// The parser cannot be interrupted, so a document over the timeout is
+	// left to finish in the background. MaxSize bounds the work of each and
+	// MaxParses how many run, so slow documents cannot pile up.
+	if n := timedParses.Add(1); l.MaxParses > 0 && n > int64(l.MaxParses) {
+		timedParses.Add(-1)
+		return nil, fmt.Errorf("%w: %d in progress", ErrParsesBusy, l.MaxParses)
+	}
+	type parsed struct {
+		node *yaml.Node
+		err  error
+	}
+	done := make(chan parsed, 1)
+	go func() {
+		defer timedParses.Add(-1)
+		node, err := l.parse(source)
+		done <- parsed{node, err}
+	}()
+	timer := time.NewTimer(l.Timeout)
+	defer timer.Stop()
+	select {
+	case p := <-done:
+		return p.node, p.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: not parsed within %v", ErrDecodeLimit, l.Timeout)
+	}
+}
+
+// parse parses and checks a document of at most MaxSize bytes
+func (l DecodeLimits) parse(source []byte) (*yaml.Node, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(source, &node); err != nil {
+		return nil, err
+	}
+
+	if err := l.Check(&node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Decode parses source within the limits and decodes it into v
+func (l DecodeLimits) Decode(source []byte, v interface{}) error {
+	node, err := l.Parse(source)
+	if err != nil {
+		return err
+	}
+	if node.Kind == 0 {
+		return nil // Empty document
+	}
+	return node.Decode(v)
+}
+
+// DecodeStrict is like Decode but rejects fields that do not exist in the
+// target struct
+func (l DecodeLimits) DecodeStrict(source []byte, v interface{}) error {
+	if _, err := l.Parse(source); err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(source))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Check verifies the depth and alias expansion of a parsed node tree
+func (l DecodeLimits) Check(node *yaml.Node) error {
+	c := &limitChecker{
+		limits:   l,
+		sizes:    make(map[*yaml.Node]int),
+		heights:  make(map[*yaml.Node]int),
+		visiting: make(map[*yaml.Node]bool),
+	}
+	_, _, err := c.walk(node)
+	return err
+}
+
+// limitChecker computes the expanded size and height of each subtree once,
+// so documents that reuse an anchor many times are checked in linear time
+type limitChecker struct {
+	limits   DecodeLimits
+	expanded int
+	sizes    map[*yaml.Node]int
+	heights  map[*yaml.Node]int
+	visiting map[*yaml.Node]bool
+}
+
+// walk returns the number of nodes and the height of node with aliases expanded
+func (c *limitChecker) walk(node *yaml.Node) (int, int, error) {
+	if size, ok := c.sizes[node]; ok {
+		return size, c.heights[node], nil
+	}
+	if c.visiting[node] {
+		return 0, 0, fmt.Errorf("%w: recursive alias", ErrDecodeLimit)
+	}
+	c.visiting[node] = true
+	defer delete(c.visiting, node)
+
+	size, height := 1, 1
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		targetSize, targetHeight, err := c.walk(node.Alias)
+		if err != nil {
+			return 0, 0, err
+		}
+		c.expanded += targetSize
+		if c.limits.MaxAliasExpansion > 0 && c.expanded > c.limits.MaxAliasExpansion {
+			return 0, 0, fmt.Errorf("%w: aliases expand to more than %d nodes", ErrDecodeLimit, c.limits.MaxAliasExpansion)
+		}
+		size, height = targetSize, targetHeight
+	}
+
+	for _, child := range node.Content {
+		childSize, childHeight, err := c.walk(child)
+		if err != nil {
+			return 0, 0, err
+		}
+		size += childSize
+		if childHeight+1 > height {
+			height = childHeight + 1
+		}
+	}
+
+	// Document nodes wrap the root without adding a level
+	depth := height
+	if node.Kind == yaml.DocumentNode {
+		depth--
+	}
+	if c.limits.MaxDepth > 0 && depth > c.limits.MaxDepth {
+		return 0, 0, fmt.Errorf("%w: nested deeper than %d levels", ErrDecodeLimit, c.limits.MaxDepth)
+	}
+
+	c.sizes[node] = size
+	c.heights[node] = height
+	return size, height, nil
+}
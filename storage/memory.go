@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/google/btree"
+)
+
+// Approximate sizes of Go runtime structures on 64-bit platforms, used to
+// estimate memory held by the store. Estimates ignore allocator rounding and
+// map load factors and are meant for spotting where memory goes, not for
+// exact accounting.
+const (
+	stringHeaderSize = 16
+	sliceHeaderSize  = 24
+	interfaceSize    = 16
+	mapHeaderSize    = 48
+	mapEntryOverhead = 8 // control byte and amortized bucket slack per entry
+	entryStructSize  = 56
+)
+
+// MemoryUsage is an estimate of the memory held by a store
+type MemoryUsage struct {
+	DataBytes  int64         `json:"data_bytes" yaml:"data_bytes"` // Entries, keys and values
+	Entries    int           `json:"entries" yaml:"entries"`
+	IndexBytes int64         `json:"index_bytes" yaml:"index_bytes"`
+	Indexes    []IndexMemory `json:"indexes" yaml:"indexes"`
+	MMapBytes  int64         `json:"mmap_bytes" yaml:"mmap_bytes"` // Size of the mapped file; pages are resident only when touched
+}
+
+// IndexMemory is the estimated memory held by a single index. Keys shared
+// with the data map are counted by their header only.
+type IndexMemory struct {
+	Field   string `json:"field" yaml:"field"`
+	Type    string `json:"type" yaml:"type"`
+	Entries int    `json:"entries" yaml:"entries"`
+	Bytes   int64  `json:"bytes" yaml:"bytes"`
+
+	Dimensions int `json:"dimensions,omitempty" yaml:"dimensions,omitempty"` // Of vector indexes, once known
+	Lists      int `json:"lists,omitempty" yaml:"lists,omitempty"`           // Partitions of vector indexes optimized with OptimizeVectorIndex
+}
+
+// MemoryUsage walks the data map and indexes and estimates their size. It
+// holds the read lock for the walk, which is proportional to the data size.
+func (s *Store) MemoryUsage() MemoryUsage {
+	s.RLock()
+	defer s.RUnlock()
+
+	usage := MemoryUsage{
+		Entries:   len(s.data),
+		DataBytes: mapHeaderSize,
+	}
+	if _, mapped := s.persist.(*mmapPersister); mapped {
+		usage.MMapBytes = s.persist.size()
+	}
+	for key, entry := range s.data {
+		usage.DataBytes += stringHeaderSize + int64(len(key)) + mapEntryOverhead + 8 + entryStructSize +
+			estimateValueSize(entry.Value) + int64(len(entry.packed)) + int64(len(entry.Source))
+	}
+
+	usage.Indexes = s.indexes.memoryUsage()
+	for _, idx := range usage.Indexes {
+		usage.IndexBytes += idx.Bytes
+	}
+	return usage
+}
+
+// estimateValueSize estimates the memory held by a decoded YAML or JSON
+// value, including the interface that holds it
+func estimateValueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case string:
+		return interfaceSize + stringHeaderSize + int64(len(v))
+	case map[string]interface{}:
+		size := int64(interfaceSize + mapHeaderSize)
+		for k, item := range v {
+			size += stringHeaderSize + int64(len(k)) + mapEntryOverhead + estimateValueSize(item)
+		}
+		return size
+	case []interface{}:
+		size := int64(interfaceSize + sliceHeaderSize)
+		for _, item := range v {
+			size += estimateValueSize(item)
+		}
+		return size
+	case []string:
+		size := int64(interfaceSize + sliceHeaderSize)
+		for _, item := range v {
+			size += stringHeaderSize + int64(len(item))
+		}
+		return size
+	case []float32:
+		return interfaceSize + sliceHeaderSize + 4*int64(len(v))
+	case []float64:
+		return interfaceSize + sliceHeaderSize + 8*int64(len(v))
+	default:
+		return interfaceSize + 8 // Scalars are boxed in 8 bytes or less
+	}
+}
+
+// memoryUsage estimates the size of every index, ordered by field and type
+func (im *IndexManager) memoryUsage() []IndexMemory {
+	im.RLock()
+	defer im.RUnlock()
+
+	var out []IndexMemory
+	for field, tree := range im.trees {
+		// Each item is an interface slot in a node holding a boxed ID and
+		// value, and the value is kept again by ID
+		bytes := int64(mapHeaderSize) + int64(tree.Len())*(mapEntryOverhead+4+interfaceSize)
+		tree.Ascend(func(i btree.Item) bool {
+			bytes += interfaceSize + 4 + estimateValueSize(i.(indexItem).value)
+			return true
+		})
+		out = append(out, IndexMemory{Field: field, Type: "btree", Entries: tree.Len(), Bytes: bytes})
+	}
+	for field, idx := range im.vectors {
+		idx.RLock()
+		bytes := int64(mapHeaderSize)
+		for _, vec := range idx.vectors {
+			bytes += stringHeaderSize + mapEntryOverhead + sliceHeaderSize + 4*int64(len(vec))
+		}
+		for _, payload := range idx.payloads {
+			bytes += stringHeaderSize + mapEntryOverhead + estimateValueSize(payload)
+		}
+		lists := 0
+		if idx.ivf != nil {
+			lists = len(idx.ivf.centroids)
+			bytes += int64(lists) * (sliceHeaderSize + 4*int64(idx.dim) + mapHeaderSize)
+			bytes += int64(len(idx.ivf.assigned)) * 2 * (stringHeaderSize + mapEntryOverhead)
+		}
+		out = append(out, IndexMemory{Field: field, Type: "vector", Entries: len(idx.vectors), Bytes: bytes, Dimensions: idx.dim, Lists: lists})
+		idx.RUnlock()
+	}
+	for field, idx := range im.text {
+		entries, bytes := idx.memoryUsage()
+		out = append(out, IndexMemory{Field: field, Type: "text", Entries: entries, Bytes: bytes})
+	}
+	for field, idx := range im.ips {
+		out = append(out, IndexMemory{Field: field, Type: "ip", Entries: idx.Len(), Bytes: idx.memoryUsage()})
+	}
+	for field, idx := range im.keywords {
+		out = append(out, IndexMemory{Field: field, Type: "keyword", Entries: idx.Len(), Bytes: idx.memoryUsage()})
+	}
+	for field, idx := range im.numeric {
+		out = append(out, IndexMemory{Field: field, Type: "numeric", Entries: idx.Len(), Bytes: idx.memoryUsage()})
+	}
+	// The document IDs are shared by every index, so they have no field
+	out = append(out, IndexMemory{Type: "doc_ids", Entries: im.ids.Len(), Bytes: im.ids.memoryUsage()})
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Field != out[j].Field {
+			return out[i].Field < out[j].Field
+		}
+		return out[i].Type < out[j].Type
+	})
+	return out
+}
+
+// postingsSize estimates a map of terms to ID bitmaps
+func postingsSize(postings map[string]*Bitmap) int64 {
+	size := int64(mapHeaderSize)
+	for term, ids := range postings {
+		size += stringHeaderSize + int64(len(term)) + mapEntryOverhead + 8 + ids.memoryUsage()
+	}
+	return size
+}
+
+// memoryUsage returns the number of indexed documents and the estimated
+// size of the trigram index
+func (ti *TrigramIndex) memoryUsage() (int, int64) {
+	ti.RLock()
+	defer ti.RUnlock()
+
+	size := postingsSize(ti.trigrams) + postingsSize(ti.iocs) + mapHeaderSize
+	for _, text := range ti.docs {
+		size += 4 + stringHeaderSize + mapEntryOverhead + int64(len(text))
+	}
+	return len(ti.docs), size
+}
+
+// memoryUsage estimates the size of the keyword index
+func (ki *KeywordIndex) memoryUsage() int64 {
+	ki.RLock()
+	defer ki.RUnlock()
+
+	size := postingsSize(ki.values) + int64(ki.terms.Len())*stringHeaderSize + mapHeaderSize
+	for _, values := range ki.docs {
+		size += 4 + mapEntryOverhead + sliceHeaderSize + int64(len(values))*stringHeaderSize
+	}
+	return size
+}
+
+// memoryUsage estimates the size of the numeric index: the value map and
+// the sorted arrays
+func (ni *NumericIndex) memoryUsage() int64 {
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+
+	size := int64(2*mapHeaderSize + 2*sliceHeaderSize)
+	size += int64(len(ni.values)) * (4 + mapEntryOverhead + 8)
+	return size + int64(len(ni.sorted))*(8+4) + int64(len(ni.changed))*(4+mapEntryOverhead+16)
+}
+
+// memoryUsage estimates the size of the IP index by walking both tries
+func (idx *IPIndex) memoryUsage() int64 {
+	idx.RLock()
+	defer idx.RUnlock()
+
+	size := int64(mapHeaderSize)
+	for _, ips := range idx.docs {
+		size += 4 + mapEntryOverhead + sliceHeaderSize + int64(len(ips))*(sliceHeaderSize+16)
+	}
+	return size + idx.v4.memoryUsage() + idx.v6.memoryUsage()
+}
+
+func (n *ipNode) memoryUsage() int64 {
+	if n == nil {
+		return 0
+	}
+	size := int64(24) // two child pointers and the ID bitmap pointer
+	if n.ids != nil {
+		size += n.ids.memoryUsage()
+	}
+	return size + n.children[0].memoryUsage() + n.children[1].memoryUsage()
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The Merkle tree over the keyspace groups keys by the leading hex digits
+// of their SHA-256, one digit per level, so two stores compare by walking
+// down only the subtrees whose digests differ
+const (
+	MerkleFanout = 16 // Children per node
+	MerkleDepth  = 3  // Levels below the root, giving 4096 leaves
+)
+
+// MerkleTree summarizes the live entries of a store. Nodes are named by
+// their path of hex digits, the root by "". Empty subtrees have the digest
+// "".
+type MerkleTree struct {
+	digests map[string]string            // Node -> digest
+	leaves  map[string]map[string]string // Leaf -> key -> entry digest
+}
+
+// EntryDigest hashes the key, value, expiry and metadata of an entry. The value is
+// hashed as JSON, which orders map keys, so equal values hash equally
+// whether they were written as YAML or JSON, and whether they are held
+// compressed or not.
+func EntryDigest(key string, entry *Entry) string {
+	entry = entry.hydrate()
+	value, err := json.Marshal(entry.Value)
+	if err != nil {
+		value = []byte(fmt.Sprintf("%#v", entry.Value))
+	}
+	// Reads move the expiry of sliding entries, so they hash by their
+	// window instead
+	var expires int64
+	switch {
+	case entry.Sliding > 0:
+		expires = -entry.Sliding
+	case entry.TTL > 0:
+		expires = entry.Timestamp + entry.TTL
+	}
+	var meta []byte
+	if !entry.Metadata.empty() {
+		meta, _ = json.Marshal(entry.Metadata)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write(value)
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(expires, 10)))
+	h.Write([]byte{0})
+	h.Write(meta)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// merkleLeaf returns the leaf a key belongs to
+func merkleLeaf(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:2])[:MerkleDepth]
+}
+
+// MerkleTree builds the tree over the live entries. It hashes every entry,
+// so it costs a pass over the data.
+func (s *Store) MerkleTree() *MerkleTree {
+	t := &MerkleTree{
+		digests: make(map[string]string),
+		leaves:  make(map[string]map[string]string),
+	}
+
+	s.RLock()
+	now := time.Now().Unix()
+	for key, entry := range s.data {
+		if entry.TTL > 0 && now > entry.Timestamp+entry.TTL {
+			continue
+		}
+		leaf := merkleLeaf(key)
+		if t.leaves[leaf] == nil {
+			t.leaves[leaf] = make(map[string]string)
+		}
+		t.leaves[leaf][key] = EntryDigest(key, entry)
+	}
+	s.RUnlock()
+
+	// Hash the leaves, then each level of parents up to the root
+	parents := make(map[string]bool)
+	for leaf, keys := range t.leaves {
+		names := make([]string, 0, len(keys))
+		for key := range keys {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+
+		h := sha256.New()
+		for _, key := range names {
+			h.Write([]byte(key))
+			h.Write([]byte{0})
+			h.Write([]byte(keys[key]))
+			h.Write([]byte{0})
+		}
+		t.digests[leaf] = hex.EncodeToString(h.Sum(nil))
+		parents[leaf[:MerkleDepth-1]] = true
+	}
+	for depth := MerkleDepth - 1; depth >= 0; depth-- {
+		next := make(map[string]bool)
+		for node := range parents {
+			h := sha256.New()
+			for _, digest := range t.children(node) {
+				h.Write([]byte(digest))
+				h.Write([]byte{0})
+			}
+			t.digests[node] = hex.EncodeToString(h.Sum(nil))
+			if depth > 0 {
+				next[node[:depth-1]] = true
+			}
+		}
+		parents = next
+	}
+	return t
+}
+
+// Root returns the digest of the whole keyspace
+func (t *MerkleTree) Root() string {
+	return t.digests[""]
+}
+
+func (t *MerkleTree) children(node string) []string {
+	digests := make([]string, MerkleFanout)
+	for i := range digests {
+		digests[i] = t.digests[node+strconv.FormatInt(int64(i), 16)]
+	}
+	return digests
+}
+
+// Children returns the digests of the children of an inner node, in hex
+// digit order
+func (t *MerkleTree) Children(node string) ([]string, error) {
+	if err := checkMerkleNode(node, MerkleDepth-1); err != nil {
+		return nil, err
+	}
+	return t.children(node), nil
+}
+
+// Leaf returns the entry digests of a leaf by key
+func (t *MerkleTree) Leaf(node string) (map[string]string, error) {
+	if err := checkMerkleNode(node, MerkleDepth); err != nil {
+		return nil, err
+	}
+	if keys := t.leaves[node]; keys != nil {
+		return keys, nil
+	}
+	return map[string]string{}, nil
+}
+
+func checkMerkleNode(node string, maxLen int) error {
+	if len(node) > maxLen {
+		return fmt.Errorf("%w: merkle node %q is too deep", ErrInvalidQuery, node)
+	}
+	for _, c := range node {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("%w: merkle node %q is not lowercase hex", ErrInvalidQuery, node)
+		}
+	}
+	return nil
+}
+
+// Entries returns copies of the live entries of the given keys that exist
+func (s *Store) Entries(keys []string) map[string]*Entry {
+	s.RLock()
+	defer s.RUnlock()
+
+	now := time.Now().Unix()
+	entries := make(map[string]*Entry, len(keys))
+	for _, key := range keys {
+		entry, exists := s.data[key]
+		if !exists || (entry.TTL > 0 && now > entry.Timestamp+entry.TTL) {
+			continue
+		}
+		entries[key] = entry.hydrate()
+		if entries[key] == entry {
+			copied := *entry
+			entries[key] = &copied
+		}
+	}
+	return entries
+}
+
+// PutEntries stores entries copied from another store, keeping their
+// timestamps and expiry. An entry differing from the local one of its key is
+// a conflict, resolved by the store's conflict policy and recorded in the
+// conflict report. It returns the keys that were written.
+func (s *Store) PutEntries(entries map[string]*Entry) ([]string, error) {
+	start := time.Now()
+	defer func() {
+		s.updateWriteStats(time.Since(start))
+	}()
+
+	s.Lock()
+	defer s.Unlock()
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	now := time.Now().Unix()
+	var written []string
+	for _, key := range keys {
+		incoming := entries[key]
+		if incoming == nil || (incoming.TTL > 0 && now > incoming.Timestamp+incoming.TTL) {
+			continue
+		}
+		if err := s.checkKey(key); err != nil {
+			return written, err
+		}
+		var conflict *Conflict
+		if local, exists := s.data[key]; exists {
+			local = local.hydrate()
+			if EntryDigest(key, local) == EntryDigest(key, incoming) {
+				continue
+			}
+			resolved, resolution := s.resolveConflict(key, local, incoming)
+			conflict = &Conflict{
+				Time:            time.Now(),
+				Key:             key,
+				Policy:          s.conflictPolicy(),
+				Resolution:      resolution,
+				LocalTimestamp:  local.Timestamp,
+				RemoteTimestamp: incoming.Timestamp,
+			}
+			if resolution == ResolvedLocal {
+				s.conflicts.add(*conflict)
+				continue
+			}
+			incoming = resolved
+		}
+		if err := s.checkQuota(key); err != nil {
+			return written, err
+		}
+
+		value, err := s.prepare(incoming.Value)
+		if err != nil {
+			return written, fmt.Errorf("key %s: %w", key, err)
+		}
+		if err := s.enforceLimits(key, value); err != nil {
+			return written, fmt.Errorf("key %s: %w", key, err)
+		}
+		s.setEntry(key, &Entry{Value: value, Timestamp: incoming.Timestamp, TTL: incoming.TTL, Metadata: incoming.Metadata, Sliding: incoming.Sliding})
+		s.dirty = true
+		s.updateIndexes(key, value)
+		if conflict != nil {
+			s.conflicts.add(*conflict)
+		}
+		written = append(written, key)
+	}
+	return written, nil
+}
+
+// newerEntry reports whether entry a should replace entry b
+func newerEntry(key string, a, b *Entry) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp > b.Timestamp
+	}
+	return EntryDigest(key, a) > EntryDigest(key, b)
+}
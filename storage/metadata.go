@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LabelFilterPrefix marks search filters on entry labels rather than on
+// document fields, e.g. "labels.env": "prod"
+const LabelFilterPrefix = "labels."
+
+// Metadata is optional information about an entry, kept apart from its
+// value so it is neither indexed nor part of search results
+type Metadata struct {
+	CreatedBy   string            `yaml:"created_by,omitempty" json:"created_by,omitempty"`     // Fingerprint of the API key that created the key
+	ContentType string            `yaml:"content_type,omitempty" json:"content_type,omitempty"` // MIME type of the document
+	Labels      map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Parent      string            `yaml:"parent,omitempty" json:"parent,omitempty"` // Key of the parent document, see SearchQuery.HasChild
+	Links       []Link            `yaml:"links,omitempty" json:"links,omitempty"`   // Typed links to other keys, see AddLinks
+}
+
+// empty reports whether m carries no metadata
+func (m *Metadata) empty() bool {
+	return m == nil || (m.CreatedBy == "" && m.ContentType == "" && len(m.Labels) == 0 && m.Parent == "" && len(m.Links) == 0)
+}
+
+// SetWithMetadata stores a value with metadata, which may be nil. A ttl of 0
+// never expires. Overwriting a key keeps the CreatedBy of its entry, so it
+// names the creator of the key rather than the last writer, and its links,
+// which are managed apart from the document.
+func (s *Store) SetWithMetadata(key string, value interface{}, ttl time.Duration, meta *Metadata) error {
+	if ttl < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidTTL, ttl)
+	}
+	if err := s.checkKey(key); err != nil {
+		return err
+	}
+	if meta != nil && meta.Parent == key {
+		return fmt.Errorf("%w: %s cannot be its own parent", ErrInvalidKey, key)
+	}
+
+	start := time.Now()
+	defer func() {
+		s.updateWriteStats(time.Since(start))
+	}()
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkQuota(key); err != nil {
+		return err
+	}
+
+	value, err := s.prepare(value)
+	if err != nil {
+		return err
+	}
+	if err := s.enforceLimits(key, value); err != nil {
+		return err
+	}
+	s.setEntry(key, &Entry{
+		Value:     value,
+		Timestamp: time.Now().Unix(),
+		TTL:       int64(ttl.Seconds()),
+		Metadata:  s.keepCreator(key, meta),
+	})
+	s.dirty = true
+
+	s.updateIndexes(key, value)
+
+	return nil
+}
+
+// keepCreator returns meta with the creator and links of the existing entry
+// of key, or nil when there is no metadata at all. Callers must hold the
+// lock.
+func (s *Store) keepCreator(key string, meta *Metadata) *Metadata {
+	old, exists := s.data[key]
+	if !exists || old.Metadata == nil || (old.Metadata.CreatedBy == "" && len(old.Metadata.Links) == 0) {
+		if meta.empty() {
+			return nil
+		}
+		return meta
+	}
+
+	kept := Metadata{CreatedBy: old.Metadata.CreatedBy, Links: old.Metadata.Links}
+	if kept.CreatedBy == "" && meta != nil {
+		kept.CreatedBy = meta.CreatedBy
+	}
+	if meta != nil {
+		kept.ContentType = meta.ContentType
+		kept.Labels = meta.Labels
+		kept.Parent = meta.Parent
+	}
+	return &kept
+}
+
+// setEntry replaces the entry of key, hashing its value and compressing it
+// if it is large, and keeps the label, child and link indexes, the views and
+// the counts of sliding and compressed entries current. Callers must hold the
+// lock.
+func (s *Store) setEntry(key string, entry *Entry) {
+	s.updateViews(key, entry)
+	s.vectorLog.forget(key)
+	old, exists := s.data[key]
+	s.invalidateQueries(key, old, entry)
+	s.pendingSize += int64(len(key)+len(entry.Source)) + estimateValueSize(entry.Value)
+	entry = s.compress(hashed(entry))
+	if exists && old.Metadata != nil {
+		s.labels.remove(key, old.Metadata.Labels)
+		s.children.remove(key, old.Metadata.Parent)
+		s.links.remove(key, old.Metadata.Links)
+	}
+	s.data[key] = entry
+	if entry.Metadata != nil {
+		s.labels.add(key, entry.Metadata.Labels)
+		s.children.add(key, entry.Metadata.Parent)
+		s.links.add(key, entry.Metadata.Links)
+	}
+
+	if wasSliding, sliding := exists && old.Sliding > 0, entry.Sliding > 0; wasSliding != sliding {
+		if sliding {
+			s.counters.sliding.Add(1)
+		} else {
+			s.counters.sliding.Add(-1)
+		}
+	}
+	if wasCompressed, compressed := exists && old.compressed(), entry.compressed(); wasCompressed != compressed {
+		if compressed {
+			s.counters.compressed.Add(1)
+		} else {
+			s.counters.compressed.Add(-1)
+		}
+	}
+}
+
+// deleteEntry removes the entry of key from the data and every index.
+// Callers must hold the lock.
+func (s *Store) deleteEntry(key string) {
+	old, exists := s.data[key]
+	if exists && old.Metadata != nil {
+		s.labels.remove(key, old.Metadata.Labels)
+		s.children.remove(key, old.Metadata.Parent)
+		s.links.remove(key, old.Metadata.Links)
+	}
+	if exists && old.Sliding > 0 {
+		s.counters.sliding.Add(-1)
+	}
+	if exists && old.compressed() {
+		s.counters.compressed.Add(-1)
+	}
+	s.full = false // The next write measures whether the data fits again
+	s.invalidateQueries(key, old, nil)
+	s.indexMemory.changes++
+	delete(s.data, key)
+	s.vectorLog.forget(key)
+	s.indexes.Remove(key)
+	s.removeFromViews(key)
+}
+
+// labelIndex maps "name=value" to the keys whose entries carry that label
+type labelIndex map[string]map[string]struct{}
+
+func (l labelIndex) add(key string, labels map[string]string) {
+	for name, value := range labels {
+		label := name + "=" + value
+		if l[label] == nil {
+			l[label] = make(map[string]struct{})
+		}
+		l[label][key] = struct{}{}
+	}
+}
+
+func (l labelIndex) remove(key string, labels map[string]string) {
+	for name, value := range labels {
+		label := name + "=" + value
+		delete(l[label], key)
+		if len(l[label]) == 0 {
+			delete(l, label)
+		}
+	}
+}
+
+// splitLabelFilters separates filters on labels from filters on fields,
+// returning the wanted label values by name
+func splitLabelFilters(filters map[string]interface{}) (map[string]interface{}, map[string]string) {
+	var labels map[string]string
+	fields := filters
+	for field, v := range filters {
+		name, ok := strings.CutPrefix(field, LabelFilterPrefix)
+		if !ok {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+			fields = make(map[string]interface{}, len(filters))
+			for f, fv := range filters {
+				if !strings.HasPrefix(f, LabelFilterPrefix) {
+					fields[f] = fv
+				}
+			}
+		}
+		labels[name] = fmt.Sprint(v)
+	}
+	return fields, labels
+}
+
+// matchLabels returns the keys carrying every label, recording the keys
+// matching each in stage when it is not nil. Callers must hold the lock.
+func (s *Store) matchLabels(labels map[string]string, stage *QueryStage) []string {
+	var results map[string]struct{}
+	for name, value := range labels {
+		keys := s.labels[name+"="+value]
+		if stage != nil {
+			if stage.Candidates == nil {
+				stage.Candidates = make(map[string]int)
+			}
+			stage.Candidates[LabelFilterPrefix+name] = len(keys)
+		}
+
+		if results == nil {
+			results = make(map[string]struct{}, len(keys))
+			for k := range keys {
+				results[k] = struct{}{}
+			}
+			continue
+		}
+		for k := range results {
+			if _, exists := keys[k]; !exists {
+				delete(results, k)
+			}
+		}
+	}
+
+	matched := make([]string, 0, len(results))
+	for k := range results {
+		matched = append(matched, k)
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// intersectKeys returns the keys in both a and b, treating nil as no
+// filter
+func intersectKeys(a, b []string) []string {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	in := make(map[string]struct{}, len(b))
+	for _, k := range b {
+		in[k] = struct{}{}
+	}
+	both := make([]string, 0, min(len(a), len(b)))
+	for _, k := range a {
+		if _, ok := in[k]; ok {
+			both = append(both, k)
+		}
+	}
+	return both
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Migration upgrades encoded data from one format version to the next
+type Migration struct {
+	From        int
+	Description string
+	Apply       func(f *FastYAMLEncoder, content []byte) ([]byte, error)
+}
+
+// migrations holds one step per format version below CurrentFormat
+var migrations = []Migration{
+	{From: FormatLegacy, Description: "add the format header and split entries into segments", Apply: reencode(FormatSegmented)},
+	{From: FormatSegmented, Description: "encode entries with the binary codec", Apply: reencode(FormatBinary)},
+}
+
+// reencode returns a migration step that decodes the data and encodes it in
+// another format, for layouts that differ only in how entries are encoded
+func reencode(to int) func(f *FastYAMLEncoder, content []byte) ([]byte, error) {
+	return func(f *FastYAMLEncoder, content []byte) ([]byte, error) {
+		entries, _, err := f.decodeData(content)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := f.encodeData(&buf, entries, to, 0); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// MigrationResult describes a migration of a data file
+type MigrationResult struct {
+	From    int      `json:"from" yaml:"from"`
+	To      int      `json:"to" yaml:"to"`
+	Steps   []string `json:"steps" yaml:"steps"`
+	Entries int      `json:"entries" yaml:"entries"`
+	Backup  string   `json:"backup,omitempty" yaml:"backup,omitempty"` // Copy of the original file
+}
+
+// MigrateFile upgrades the data file at path to the target format version,
+// or to CurrentFormat when target is 0. The file must not be open in a
+// running server. The migrated data is written to a temporary file and
+// checked before it replaces the original, which is kept as a backup unless
+// noBackup is set. With dryRun nothing is written.
+func MigrateFile(path string, target int, dryRun, noBackup bool) (*MigrationResult, error) {
+	if target == 0 {
+		target = CurrentFormat
+	}
+	if target < FormatLegacy || target > CurrentFormat {
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedFormat, target)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	// Hold the lock for the whole migration so a server cannot open the
+	// file meanwhile
+	file, err := openDataFile(path, StoreOptions{ReadOnly: dryRun})
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Mapped data files are zero-padded after the data
+	data = data[:dataEnd(data)]
+
+	if len(data) == 0 {
+		// Stores write empty files in the current format
+		return &MigrationResult{From: CurrentFormat, To: CurrentFormat}, nil
+	}
+
+	encoder := NewFastYAMLEncoder()
+	original, from, err := encoder.decodeData(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	result := &MigrationResult{From: from, To: from, Entries: len(original)}
+	if from > target {
+		return nil, fmt.Errorf("%s is at format %d; downgrading to %d is not supported", path, from, target)
+	}
+
+	content := data
+	for _, m := range migrations {
+		if m.From < from || m.From >= target {
+			continue
+		}
+		if content, err = m.Apply(encoder, content); err != nil {
+			return nil, fmt.Errorf("migration from format %d failed: %w", m.From, err)
+		}
+		result.To = m.From + 1
+		result.Steps = append(result.Steps, fmt.Sprintf("%d -> %d: %s", m.From, m.From+1, m.Description))
+	}
+	if result.To == from {
+		return result, nil
+	}
+
+	// Check the result holds every entry before touching the original
+	migrated, version, err := encoder.decodeData(content)
+	if err != nil {
+		return nil, fmt.Errorf("migrated data does not decode: %w", err)
+	}
+	if version != result.To || len(migrated) != len(original) {
+		return nil, fmt.Errorf("migrated data has format %d and %d entries, expected format %d and %d entries",
+			version, len(migrated), result.To, len(original))
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".migrate-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	if !noBackup {
+		result.Backup = fmt.Sprintf("%s.v%d.bak", path, from)
+		if err := os.WriteFile(result.Backup, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write backup: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import "errors"
+
+// mmapWriter is an io.Writer over the mapping of an mmapPersister, from an
+// offset past the current contents. It grows the file as writes reach its
+// end, up to the maximum size; bytes beyond the maximum size are kept in an
+// overflow buffer, so the caller learns the full size of the encoding
+// without having overwritten the previous one.
+type mmapWriter struct {
+	p        *mmapPersister
+	start    int    // Offset of the encoding in the mapping
+	n        int    // Bytes written to the mapping
+	overflow []byte // Bytes beyond the maximum size of the file
+}
+
+func (w *mmapWriter) Write(b []byte) (int, error) {
+	if len(w.overflow) == 0 {
+		end := int64(w.start + w.n + len(b))
+		if w.p.maxSize > 0 && end > w.p.maxSize {
+			end = w.p.maxSize
+		}
+		if end > int64(len(w.p.mm)) {
+			if err := w.p.grow(end); err != nil && !errors.Is(err, ErrStoreFull) {
+				return 0, err
+			}
+		}
+		if w.start+w.n < len(w.p.mm) {
+			copied := copy(w.p.mm[w.start+w.n:], b)
+			w.n += copied
+			if copied == len(b) {
+				return len(b), nil
+			}
+			w.overflow = append(w.overflow, b[copied:]...)
+			return len(b), nil
+		}
+	}
+	w.overflow = append(w.overflow, b...)
+	return len(b), nil
+}
+
+// mapped returns the bytes written to the mapping
+func (w *mmapWriter) mapped() []byte {
+	if w.n == 0 {
+		return nil
+	}
+	return w.p.mm[w.start : w.start+w.n]
+}
+
+// Len returns the total number of bytes written
+func (w *mmapWriter) Len() int {
+	return w.n + len(w.overflow)
+}
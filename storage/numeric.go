@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// errNotNumber is the index error of values of numeric fields that are not
+// numbers
+var errNotNumber = errors.New("value is not a number")
+
+// numericMergeLimit is the number of changed keys merged into the sorted
+// arrays one by one; more are merged in a single pass
+const numericMergeLimit = 8
+
+// NumericIndex keeps the values of a numeric field in a sorted array, so
+// range filters, range counts and percentiles take a binary search instead
+// of a scan of the documents. Writes are collected and merged into the array
+// on the next read, so bulk loads do not shift it once per key.
+type NumericIndex struct {
+	mu      sync.Mutex
+	ids     *docIDs
+	values  map[uint32]float64    // document ID -> value
+	sorted  []float64             // values in ascending order, as of the last merge
+	docs    []uint32              // document ID of each sorted value
+	changed map[uint32]numericOld // documents written since the last merge
+}
+
+// numericOld is the value a changed document had in the sorted arrays
+type numericOld struct {
+	value  float64
+	sorted bool // The document is in the sorted arrays
+}
+
+// NewNumericIndex creates an empty numeric index
+func NewNumericIndex() *NumericIndex {
+	return newNumericIndex(newDocIDs())
+}
+
+func newNumericIndex(ids *docIDs) *NumericIndex {
+	return &NumericIndex{
+		ids:     ids,
+		values:  make(map[uint32]float64),
+		changed: make(map[uint32]numericOld),
+	}
+}
+
+// numericValue converts a field value to the number a numeric index holds
+func numericValue(value interface{}) (float64, bool) {
+	f, ok := toFloat(value)
+	if !ok || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// check reports whether the index would reject value
+func (ni *NumericIndex) check(value interface{}) error {
+	if _, ok := numericValue(value); !ok {
+		return errNotNumber
+	}
+	return nil
+}
+
+// Update replaces the value indexed for key. A value that is not a number
+// removes the key and is rejected.
+func (ni *NumericIndex) Update(key string, value interface{}) error {
+	f, ok := numericValue(value)
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+
+	if !ok {
+		ni.removeLocked(key)
+		return errNotNumber
+	}
+	id := ni.ids.assign(key)
+	ni.touch(id)
+	ni.values[id] = f
+	return nil
+}
+
+// Remove removes key from the index
+func (ni *NumericIndex) Remove(key string) {
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+	ni.removeLocked(key)
+}
+
+func (ni *NumericIndex) removeLocked(key string) {
+	id, exists := ni.ids.lookup(key)
+	if !exists {
+		return
+	}
+	if _, exists := ni.values[id]; !exists {
+		return
+	}
+	ni.touch(id)
+	delete(ni.values, id)
+}
+
+// touch records the value of a document in the sorted arrays before its
+// first change since the last merge
+func (ni *NumericIndex) touch(id uint32) {
+	if _, seen := ni.changed[id]; seen {
+		return
+	}
+	old, exists := ni.values[id]
+	ni.changed[id] = numericOld{value: old, sorted: exists}
+}
+
+// Len returns the number of indexed documents
+func (ni *NumericIndex) Len() int {
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+	return len(ni.values)
+}
+
+// holds reports whether the index has entries of a document ID
+func (ni *NumericIndex) holds(id uint32) bool {
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+	_, exists := ni.values[id]
+	return exists
+}
+
+// merge brings the sorted arrays up to date with the changed documents. A few
+// changes are moved into place by binary search; more are merged in one
+// pass over the arrays. Callers must hold the lock.
+func (ni *NumericIndex) merge() {
+	if len(ni.changed) == 0 {
+		return
+	}
+	if len(ni.changed) <= numericMergeLimit {
+		for id, old := range ni.changed {
+			if old.sorted {
+				i := ni.search(old.value, id)
+				ni.sorted = slices.Delete(ni.sorted, i, i+1)
+				ni.docs = slices.Delete(ni.docs, i, i+1)
+			}
+			if f, exists := ni.values[id]; exists {
+				i := ni.search(f, id)
+				ni.sorted = slices.Insert(ni.sorted, i, f)
+				ni.docs = slices.Insert(ni.docs, i, id)
+			}
+		}
+		clear(ni.changed)
+		return
+	}
+
+	// Sort the new values of the changed documents, then merge them with
+	// the unchanged entries
+	added := make([]uint32, 0, len(ni.changed))
+	for id := range ni.changed {
+		if _, exists := ni.values[id]; exists {
+			added = append(added, id)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return numericLess(ni.values[added[i]], added[i], ni.values[added[j]], added[j])
+	})
+
+	sorted := make([]float64, 0, len(ni.values))
+	docs := make([]uint32, 0, len(ni.values))
+	j := 0
+	for i, id := range ni.docs {
+		if _, changed := ni.changed[id]; changed {
+			continue
+		}
+		for ; j < len(added) && numericLess(ni.values[added[j]], added[j], ni.sorted[i], id); j++ {
+			sorted = append(sorted, ni.values[added[j]])
+			docs = append(docs, added[j])
+		}
+		sorted = append(sorted, ni.sorted[i])
+		docs = append(docs, id)
+	}
+	for ; j < len(added); j++ {
+		sorted = append(sorted, ni.values[added[j]])
+		docs = append(docs, added[j])
+	}
+	ni.sorted, ni.docs = sorted, docs
+	clear(ni.changed)
+}
+
+// numericLess orders entries by value, then document ID
+func numericLess(a float64, aID uint32, b float64, bID uint32) bool {
+	if a != b {
+		return a < b
+	}
+	return aID < bID
+}
+
+// search returns the position of the entry (value, id) in the sorted
+// arrays, or where it would be inserted
+func (ni *NumericIndex) search(value float64, id uint32) int {
+	return sort.Search(len(ni.sorted), func(i int) bool {
+		return !numericLess(ni.sorted[i], ni.docs[i], value, id)
+	})
+}
+
+// NumericRange bounds the values of a numeric filter. Nil bounds are open.
+type NumericRange struct {
+	GT, GTE, LT, LTE *float64
+}
+
+// bounds returns the positions in sorted of the first value in the range
+// and of the first value past it
+func (r NumericRange) bounds(sorted []float64) (int, int) {
+	lo, hi := 0, len(sorted)
+	if r.GTE != nil {
+		lo = max(lo, sort.SearchFloat64s(sorted, *r.GTE))
+	}
+	if r.GT != nil {
+		lo = max(lo, sort.Search(len(sorted), func(i int) bool { return sorted[i] > *r.GT }))
+	}
+	if r.LT != nil {
+		hi = min(hi, sort.SearchFloat64s(sorted, *r.LT))
+	}
+	if r.LTE != nil {
+		hi = min(hi, sort.Search(len(sorted), func(i int) bool { return sorted[i] > *r.LTE }))
+	}
+	return lo, max(lo, hi)
+}
+
+// contains reports whether f lies in the range
+func (r NumericRange) contains(f float64) bool {
+	return (r.GT == nil || f > *r.GT) && (r.GTE == nil || f >= *r.GTE) &&
+		(r.LT == nil || f < *r.LT) && (r.LTE == nil || f <= *r.LTE)
+}
+
+// parseNumericFilter converts a filter to a range: a number matches that
+// value, and a map takes any of "gt", "gte", "lt" and "lte"
+func parseNumericFilter(filter interface{}) (NumericRange, error) {
+	if f, ok := numericValue(filter); ok {
+		return NumericRange{GTE: &f, LTE: &f}, nil
+	}
+	m, ok := filter.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		return NumericRange{}, fmt.Errorf("%w: unsupported numeric filter: %v", ErrInvalidQuery, filter)
+	}
+	var r NumericRange
+	for op, v := range m {
+		f, ok := numericValue(v)
+		if !ok {
+			return NumericRange{}, fmt.Errorf("%w: numeric filter %s: %v is not a number", ErrInvalidQuery, op, v)
+		}
+		switch op {
+		case "gt":
+			r.GT = &f
+		case "gte":
+			r.GTE = &f
+		case "lt":
+			r.LT = &f
+		case "lte":
+			r.LTE = &f
+		default:
+			return NumericRange{}, fmt.Errorf("%w: unknown numeric filter operator %q", ErrInvalidQuery, op)
+		}
+	}
+	return r, nil
+}
+
+// Search evaluates a numeric filter: a number, or a range such as
+// {"gte": 10, "lt": 20}
+func (ni *NumericIndex) Search(filter interface{}) ([]string, error) {
+	found, err := ni.searchFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	return ni.ids.keysOf(found), nil
+}
+
+// searchFilter evaluates a numeric filter to the IDs of the matching
+// documents
+func (ni *NumericIndex) searchFilter(filter interface{}) (*Bitmap, error) {
+	r, err := parseNumericFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+	ni.merge()
+
+	lo, hi := r.bounds(ni.sorted)
+	found := NewBitmap()
+	for _, id := range ni.docs[lo:hi] {
+		found.Add(id)
+	}
+	return found, nil
+}
+
+// probe returns the IDs in within of the documents matching a numeric
+// filter, checking the value of each document instead of the sorted arrays
+func (ni *NumericIndex) probe(filter interface{}, within *Bitmap) (*Bitmap, error) {
+	r, err := parseNumericFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+
+	found := NewBitmap()
+	within.Each(func(id uint32) bool {
+		if f, exists := ni.values[id]; exists && r.contains(f) {
+			found.Add(id)
+		}
+		return true
+	})
+	return found, nil
+}
+
+// Count returns the number of documents whose value lies in r
+func (ni *NumericIndex) Count(r NumericRange) int {
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+	ni.merge()
+
+	lo, hi := r.bounds(ni.sorted)
+	return hi - lo
+}
+
+// view calls fn with every indexed value in ascending order. fn must not
+// keep or change the slice.
+func (ni *NumericIndex) view(fn func(sorted []float64)) {
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+	ni.merge()
+	fn(ni.sorted)
+}
+
+// valuesWhere returns the values of the keys for which keep returns true,
+// in ascending order
+func (ni *NumericIndex) valuesWhere(keep func(key string) bool) []float64 {
+	ni.mu.Lock()
+	defer ni.mu.Unlock()
+	ni.merge()
+
+	ni.ids.RLock()
+	defer ni.ids.RUnlock()
+	var values []float64
+	for i, id := range ni.docs {
+		if keep(ni.ids.keys[id]) {
+			values = append(values, ni.sorted[i])
+		}
+	}
+	return values
+}
+
+// valuesOf returns the values of keys, skipping keys without one, in
+// ascending order
+func (ni *NumericIndex) valuesOf(keys []string) []float64 {
+	ni.mu.Lock()
+	values := make([]float64, 0, len(keys))
+	for _, key := range keys {
+		if id, exists := ni.ids.lookup(key); exists {
+			if f, exists := ni.values[id]; exists {
+				values = append(values, f)
+			}
+		}
+	}
+	ni.mu.Unlock()
+
+	sort.Float64s(values)
+	return values
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sort"
+)
+
+// parallelShardMin is the smallest number of entries worth encoding on a
+// separate goroutine
+const parallelShardMin = 4096
+
+// EncodeEntries writes entries to w as a single YAML mapping. Large maps are
+// split into shards of disjoint keys that are encoded concurrently into
+// pooled buffers and written to w in order; each shard encodes as a block
+// mapping at the top level, so the concatenation is one valid mapping.
+// workers <= 0 uses GOMAXPROCS. Small maps are streamed to w directly.
+func (f *FastYAMLEncoder) EncodeEntries(w io.Writer, entries map[string]*Entry, workers int) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	shards := min(workers, len(entries)/parallelShardMin)
+	if shards <= 1 {
+		return f.EncodeTo(w, entries)
+	}
+	return f.encodeShards(w, entries, shards, workers, nil)
+}
+
+// encodeShards splits entries into shards of consecutive sorted keys,
+// encodes up to workers of them concurrently into pooled buffers and writes
+// them to w in order, with sep between shards
+func (f *FastYAMLEncoder) encodeShards(w io.Writer, entries map[string]*Entry, shards, workers int, sep []byte) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	type result struct {
+		buf *bytes.Buffer
+		err error
+	}
+	results := make([]chan result, shards)
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	sem := make(chan struct{}, workers)
+	go func() {
+		for i := range results {
+			lo, hi := i*len(keys)/shards, (i+1)*len(keys)/shards
+			sem <- struct{}{}
+			go func(shardKeys []string, out chan<- result) {
+				defer func() { <-sem }()
+				shard := make(map[string]*Entry, len(shardKeys))
+				for _, key := range shardKeys {
+					shard[key] = entries[key]
+				}
+				buf := f.pool.Get().(*bytes.Buffer)
+				out <- result{buf, f.EncodeTo(buf, shard)}
+			}(keys[lo:hi], results[i])
+		}
+	}()
+
+	// Write shards in order as they finish, returning every buffer to the
+	// pool even after an error
+	var firstErr error
+	for i, ch := range results {
+		r := <-ch
+		if firstErr == nil {
+			firstErr = r.err
+		}
+		if firstErr == nil && i > 0 && sep != nil {
+			_, firstErr = w.Write(sep)
+		}
+		if firstErr == nil {
+			_, firstErr = w.Write(r.buf.Bytes())
+		}
+		r.buf.Reset()
+		f.pool.Put(r.buf)
+	}
+	return firstErr
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// childIndex maps parent keys to the keys whose entries name them in
+// Metadata.Parent
+type childIndex map[string]map[string]struct{}
+
+func (c childIndex) add(key, parent string) {
+	if parent == "" {
+		return
+	}
+	if c[parent] == nil {
+		c[parent] = make(map[string]struct{})
+	}
+	c[parent][key] = struct{}{}
+}
+
+func (c childIndex) remove(key, parent string) {
+	if parent == "" {
+		return
+	}
+	delete(c[parent], key)
+	if len(c[parent]) == 0 {
+		delete(c, parent)
+	}
+}
+
+// Children returns the keys of the children of parent, sorted
+func (s *Store) Children(parent string) []string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.childrenLocked(parent)
+}
+
+func (s *Store) childrenLocked(parent string) []string {
+	keys := make([]string, 0, len(s.children[parent]))
+	for key := range s.children[parent] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hasJoin reports whether a query selects documents by their parents or
+// children
+func (q SearchQuery) hasJoin() bool {
+	return q.Parent != "" || q.HasChild != nil || q.HasParent != nil
+}
+
+// selects reports whether a query selects candidates of its own, as the
+// inner queries of joins must
+func (q SearchQuery) selects() bool {
+	return q.Text != "" || len(q.Vector) > 0 || len(q.Filters) > 0 || len(q.Prefixes) > 0 || q.hasJoin()
+}
+
+// joinLocked returns the keys selected by the parent, has_child and
+// has_parent conditions of a query, sorted: the children of Parent, the
+// parents of the matches of HasChild, and the children of the matches of
+// HasParent, all of which must hold. Parents that do not exist are not
+// returned. Callers must hold the lock.
+func (s *Store) joinLocked(query SearchQuery, trace *queryTrace) ([]string, error) {
+	var results []string
+	if query.Parent != "" {
+		start := time.Now()
+		results = s.childrenLocked(query.Parent)
+		trace.record(QueryStage{Stage: "parent"}, start, len(results))
+	}
+
+	if query.HasChild != nil && (results == nil || len(results) > 0) {
+		matches, err := s.innerLocked("has_child", *query.HasChild)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		parents := make(map[string]struct{})
+		for _, m := range matches {
+			entry := s.data[m.Key]
+			if entry == nil || entry.Metadata == nil || entry.Metadata.Parent == "" {
+				continue
+			}
+			if _, exists := s.data[entry.Metadata.Parent]; exists {
+				parents[entry.Metadata.Parent] = struct{}{}
+			}
+		}
+		keys := make([]string, 0, len(parents))
+		for key := range parents {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		trace.record(QueryStage{Stage: "has_child"}, start, len(keys))
+		results = intersectKeys(results, keys)
+	}
+
+	if query.HasParent != nil && (results == nil || len(results) > 0) {
+		matches, err := s.innerLocked("has_parent", *query.HasParent)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		var keys []string
+		for _, m := range matches {
+			keys = append(keys, s.childrenLocked(m.Key)...)
+		}
+		sort.Strings(keys)
+		trace.record(QueryStage{Stage: "has_parent"}, start, len(keys))
+		results = intersectKeys(results, keys)
+	}
+	return results, nil
+}
+
+// innerLocked returns the matches of the inner query of a join, without
+// their values. Its max_results bounds the matches joined, and is needed
+// by vector searches as in any query. Callers must hold the lock.
+func (s *Store) innerLocked(join string, inner SearchQuery) ([]SearchResult, error) {
+	if !inner.selects() {
+		return nil, fmt.Errorf("%w: %s needs text, vector, filters, prefixes or a join to select documents", ErrInvalidQuery, join)
+	}
+	if inner.Sample > 0 || inner.GroupChunks || inner.Explain {
+		return nil, fmt.Errorf("%w: %s does not take sample, group_chunks or explain", ErrInvalidQuery, join)
+	}
+	noValues := false
+	inner.ReturnValues = &noValues
+	results, _, err := s.searchLocked(inner, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", join, err)
+	}
+	return results, nil
+}
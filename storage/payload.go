@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// maxPayloadFields is the most fields a vector index keeps with each vector
+const maxPayloadFields = 16
+
+// checkPayloadFields validates the payload fields of IndexOptions and
+// returns them sorted
+func checkPayloadFields(fields []string) ([]string, error) {
+	if len(fields) > maxPayloadFields {
+		return nil, fmt.Errorf("%w: at most %d payload fields", ErrInvalidIndex, maxPayloadFields)
+	}
+	sorted := append([]string(nil), fields...)
+	sort.Strings(sorted)
+	for i, field := range sorted {
+		if field == "" || (i > 0 && field == sorted[i-1]) {
+			return nil, fmt.Errorf("%w: payload fields must be distinct and not empty", ErrInvalidIndex)
+		}
+	}
+	return sorted, nil
+}
+
+// payloadOf returns the payload of a document: the scalars and lists of
+// scalars among its fields, nil if it holds none
+func payloadOf(fields []string, doc map[string]interface{}) map[string]interface{} {
+	var payload map[string]interface{}
+	for _, field := range fields {
+		value, ok := payloadValue(doc[field])
+		if !ok {
+			continue
+		}
+		if payload == nil {
+			payload = make(map[string]interface{}, len(fields))
+		}
+		payload[field] = value
+	}
+	return payload
+}
+
+// payloadValue converts a field value to the form kept in payloads, where
+// numbers are float64 and times RFC 3339 strings, so they compare like the
+// filters decoded from JSON
+func payloadValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string, bool:
+		return v, true
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), true
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				continue
+			}
+			if scalar, ok := payloadValue(item); ok {
+				values = append(values, scalar)
+			}
+		}
+		return values, len(values) > 0
+	}
+	if f, ok := numericValue(value); ok {
+		return f, true
+	}
+	return nil, false
+}
+
+// payloadFilters returns the filters on fields every index keeps in its
+// payload, nil if there are none
+func payloadFilters(indexes map[string]*VectorIndex, filters map[string]interface{}) map[string]interface{} {
+	if len(indexes) == 0 {
+		return nil
+	}
+	var covered map[string]interface{}
+	for field, filter := range filters {
+		held := true
+		for _, idx := range indexes {
+			held = held && containsString(idx.payload, field)
+		}
+		if !held {
+			continue
+		}
+		if covered == nil {
+			covered = make(map[string]interface{})
+		}
+		covered[field] = filter
+	}
+	return covered
+}
+
+// checkPayloadFilter rejects filters a payload cannot evaluate: ranges
+// must be maps of gt, gte, lt and lte to numbers or strings
+func checkPayloadFilter(field string, filter interface{}) error {
+	ops, ok := filter.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if len(ops) == 0 {
+		return fmt.Errorf("%w: field %s: empty range filter", ErrInvalidQuery, field)
+	}
+	for op, bound := range ops {
+		switch op {
+		case "gt", "gte", "lt", "lte":
+		default:
+			return fmt.Errorf("%w: field %s: unknown range filter operator %q", ErrInvalidQuery, field, op)
+		}
+		if _, isString := bound.(string); !isString {
+			if _, isNumber := numericValue(bound); !isNumber {
+				return fmt.Errorf("%w: field %s: range filter %s: %v is neither a number nor a string", ErrInvalidQuery, field, op, bound)
+			}
+		}
+	}
+	return nil
+}
+
+// matchPayload reports whether a payload matches every filter. A list in
+// the payload matches when any of its values does.
+func matchPayload(payload, filters map[string]interface{}) bool {
+	for field, filter := range filters {
+		value, exists := payload[field]
+		if !exists {
+			return false
+		}
+		values, isList := value.([]interface{})
+		if !isList {
+			values = []interface{}{value}
+		}
+		matched := false
+		for _, v := range values {
+			if matchPayloadValue(v, filter) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPayloadValue matches one payload value with a filter: a value it
+// must equal, a list of allowed values, or a range such as
+// {"gte": "2024-01-01", "lt": "2024-02-01"}, which compares numbers with
+// numbers and strings, such as dates, with strings
+func matchPayloadValue(value, filter interface{}) bool {
+	switch f := filter.(type) {
+	case []interface{}:
+		for _, allowed := range f {
+			if matchPayloadValue(value, allowed) {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		for op, bound := range f {
+			c, ok := comparePayload(value, bound)
+			if !ok {
+				return false
+			}
+			switch {
+			case op == "gt" && c <= 0, op == "gte" && c < 0, op == "lt" && c >= 0, op == "lte" && c > 0:
+				return false
+			}
+		}
+		return true
+	}
+	if c, ok := comparePayload(value, filter); ok {
+		return c == 0
+	}
+	v, _ := keywordScalar(value)
+	want, ok := keywordScalar(filter)
+	return ok && v == want
+}
+
+// comparePayload orders a payload value against a filter value of the same
+// kind, number or string, reporting false for other kinds
+func comparePayload(value, filter interface{}) (int, bool) {
+	if v, ok := value.(string); ok {
+		f, ok := filter.(string)
+		switch {
+		case !ok:
+			return 0, false
+		case v < f:
+			return -1, true
+		case v > f:
+			return 1, true
+		}
+		return 0, true
+	}
+	v, ok := numericValue(value)
+	if !ok {
+		return 0, false
+	}
+	f, ok := numericValue(filter)
+	switch {
+	case !ok:
+		return 0, false
+	case v < f:
+		return -1, true
+	case v > f:
+		return 1, true
+	}
+	return 0, true
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Persistence modes for StoreOptions.Persistence
+const (
+	PersistMMap   = "mmap"   // Memory-mapped data file
+	PersistFile   = "file"   // Buffered file I/O, for platforms where mapping is unsuitable
+	PersistAtomic = "atomic" // Each sync written to a temporary file renamed over the data file
+	PersistMemory = "memory" // No files at all; the data is lost with the store
+)
+
+// persister stores the encoded data set in the data file
+type persister interface {
+	// read returns the encoded data, which ends at the first NUL byte
+	read() ([]byte, error)
+	// write replaces the stored data with the output of encode and returns
+	// its size
+	write(encode func(w io.Writer) error) (int, error)
+	// size returns the size of the data file
+	size() int64
+	close() error
+}
+
+// openPersister opens the data file in the configured persistence mode. When
+// no mode is set the platform default is used, and a file that cannot be
+// mapped, for example because it exceeds the address space, falls back to
+// buffered file I/O.
+func openPersister(path string, opts StoreOptions) (persister, error) {
+	mode := opts.Persistence
+	if mode == "" {
+		mode = defaultPersistence
+	}
+
+	switch mode {
+	case PersistMMap:
+		p, err := openMMapPersister(path, opts)
+		if err == nil || opts.Persistence == PersistMMap || errors.Is(err, ErrFileInUse) {
+			return p, err
+		}
+		log.Printf("Warning: %v; falling back to buffered file I/O", err)
+		return openFilePersister(path, opts)
+	case PersistFile:
+		return openFilePersister(path, opts)
+	case PersistAtomic:
+		return openAtomicPersister(path, opts)
+	case PersistMemory:
+		return &memoryPersister{maxSize: opts.MaxSize}, nil
+	}
+	return nil, fmt.Errorf("unknown persistence mode: %s", mode)
+}
+
+// openDataFile opens and locks the data file, read-only and shared when
+// opts.ReadOnly is set
+func openDataFile(path string, opts StoreOptions) (*os.File, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	if err := lockFile(file, opts.ReadOnly); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// quotaWriter fails once more than max bytes have been written, so an
+// encoding larger than StoreOptions.MaxSize stops early
+type quotaWriter struct {
+	w   io.Writer
+	n   int64
+	max int64
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if q.max > 0 && q.n+int64(len(p)) > q.max {
+		return 0, fmt.Errorf("%w: data exceeds max size %d", ErrStoreFull, q.max)
+	}
+	n, err := q.w.Write(p)
+	q.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// atomicSuffix names the file each sync of the atomic persister is written
+// to before it replaces the data file
+const atomicSuffix = ".sync"
+
+// atomicPersister writes each encoding to a temporary file, flushes it and
+// renames it over the data file, so the file on disk is always a complete
+// document: a crash during a sync leaves the previous one in place. The
+// current file is mapped read-only and mapped again after every rename.
+// The temporary file is locked before the rename, so the lock on the data
+// file carries over to the new one.
+type atomicPersister struct {
+	path    string
+	file    *os.File
+	mm      mmap.MMap // nil while the file is empty
+	maxSize int64
+}
+
+func openAtomicPersister(path string, opts StoreOptions) (*atomicPersister, error) {
+	if runtime.GOOS == "windows" {
+		// Windows cannot rename over a file that is open or mapped
+		return nil, errors.New("atomic persistence is not supported on windows")
+	}
+	file, err := openDataFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	p := &atomicPersister{path: path, file: file, maxSize: opts.MaxSize}
+	if err := p.remap(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// remap maps the current file read-only
+func (p *atomicPersister) remap() error {
+	info, err := p.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %v", err)
+	}
+	if info.Size() == 0 {
+		p.mm = nil // Empty files cannot be mapped
+		return nil
+	}
+	mm, err := mmap.Map(p.file, mmap.RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to map file: %v", err)
+	}
+	p.mm = mm
+	return nil
+}
+
+// read returns the mapped data without copying it. Files written by the mmap
+// persister are padded with NUL bytes, which are left out.
+func (p *atomicPersister) read() ([]byte, error) {
+	return p.mm[:dataEnd(p.mm)], nil
+}
+
+// write streams the encoding to a temporary file next to the data file,
+// flushes it to disk and renames it over the data file. Until the rename the
+// data file is untouched, so a failed or interrupted write loses nothing.
+func (p *atomicPersister) write(encode func(w io.Writer) error) (int, error) {
+	tmpPath := p.path + atomicSuffix
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %v", tmpPath, err)
+	}
+	fail := func(err error) (int, error) {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	if err := lockFile(tmp, false); err != nil {
+		return fail(err)
+	}
+
+	buf := bufio.NewWriterSize(tmp, fileWriteBuffer)
+	w := &quotaWriter{w: buf, max: p.maxSize}
+	if err := encode(w); err != nil {
+		return fail(err)
+	}
+	if err := buf.Flush(); err != nil {
+		return fail(fmt.Errorf("failed to write file: %v", err))
+	}
+	if err := injectFault(FaultFlush); err != nil {
+		return fail(fmt.Errorf("failed to flush to disk: %w", err))
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(fmt.Errorf("failed to flush to disk: %v", err))
+	}
+	if err := os.Rename(tmpPath, p.path); err != nil {
+		return fail(fmt.Errorf("failed to replace data file: %v", err))
+	}
+	// Make the rename durable; a crash before this may still bring back
+	// the previous file, which is complete too
+	if dir, err := os.Open(filepath.Dir(p.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	// The old file is no longer linked; closing it releases its lock while
+	// the new one is already held
+	if p.mm != nil {
+		p.mm.Unmap()
+	}
+	p.file.Close()
+	p.file = tmp
+	if err := p.remap(); err != nil {
+		// The data is on disk; only reads of it, by the shadow mirror,
+		// are unavailable until the next sync maps it
+		log.Printf("Warning: %v", err)
+		p.mm = nil
+	}
+	return int(w.n), nil
+}
+
+func (p *atomicPersister) size() int64 {
+	return int64(len(p.mm))
+}
+
+func (p *atomicPersister) close() error {
+	if p.mm != nil {
+		if err := p.mm.Unmap(); err != nil {
+			p.file.Close()
+			return fmt.Errorf("failed to unmap on close: %v", err)
+		}
+	}
+	return p.file.Close()
+}
@@ -0,0 +1,6 @@
+//go:build !windows && !386 && !arm && !mips && !mipsle
+
+package storage
+
+// defaultPersistence maps the data file on 64-bit Unix platforms
+const defaultPersistence = PersistMMap
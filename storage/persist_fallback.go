@@ -0,0 +1,8 @@
+//go:build windows || 386 || arm || mips || mipsle
+
+package storage
+
+// defaultPersistence uses buffered file I/O on Windows, where a mapped file
+// cannot be resized and mappings hold the file open against other tools,
+// and on 32-bit platforms, where large data files exceed the address space
+const defaultPersistence = PersistFile
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fileWriteBuffer is the write buffer used when streaming an encoding to the file
+const fileWriteBuffer = 1 << 20
+
+// filePersister keeps the data file closed to mapping and writes each
+// encoding through a buffered writer. It reads files written by the mmap
+// persister, which pads the data with NUL bytes, and vice versa.
+type filePersister struct {
+	file    *os.File
+	maxSize int64
+}
+
+func openFilePersister(path string, opts StoreOptions) (*filePersister, error) {
+	file, err := openDataFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &filePersister{file: file, maxSize: opts.MaxSize}, nil
+}
+
+func (p *filePersister) read() ([]byte, error) {
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek: %v", err)
+	}
+	data, err := io.ReadAll(p.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	return data[:dataEnd(data)], nil
+}
+
+// write streams the encoding over the start of the file and truncates the
+// file to its size
+func (p *filePersister) write(encode func(w io.Writer) error) (int, error) {
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek: %v", err)
+	}
+
+	buf := bufio.NewWriterSize(p.file, fileWriteBuffer)
+	w := &quotaWriter{w: buf, max: p.maxSize}
+	if err := encode(w); err != nil {
+		return 0, err
+	}
+	if err := buf.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to write file: %v", err)
+	}
+
+	if err := p.file.Truncate(w.n); err != nil {
+		return 0, fmt.Errorf("failed to truncate: %v", err)
+	}
+	if err := injectFault(FaultFlush); err != nil {
+		return 0, fmt.Errorf("failed to flush to disk: %w", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to flush to disk: %v", err)
+	}
+	return int(w.n), nil
+}
+
+func (p *filePersister) size() int64 {
+	info, err := p.file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (p *filePersister) close() error {
+	return p.file.Close()
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+)
+
+// memoryPersister keeps the encoded data in memory instead of a file, for
+// stores that must not touch the filesystem such as those of NewMemStore.
+// Syncs still encode the data, so MaxSize and encoding failures behave as
+// with a file, and nothing outlives the store.
+type memoryPersister struct {
+	data    []byte
+	maxSize int64
+}
+
+func (p *memoryPersister) read() ([]byte, error) {
+	return p.data, nil
+}
+
+func (p *memoryPersister) write(encode func(w io.Writer) error) (int, error) {
+	var buf bytes.Buffer
+	w := &quotaWriter{w: &buf, max: p.maxSize}
+	if err := encode(w); err != nil {
+		return 0, err
+	}
+	p.data = buf.Bytes()
+	return len(p.data), nil
+}
+
+func (p *memoryPersister) size() int64 {
+	return int64(len(p.data))
+}
+
+func (p *memoryPersister) close() error {
+	p.data = nil
+	return nil
+}
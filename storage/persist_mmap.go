@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// mmapPersister maps the data file into memory and encodes into the mapping
+// directly. The file is grown by doubling when an encoding does not fit;
+// the remainder of the file is zero.
+type mmapPersister struct {
+	file        *os.File
+	mm          mmap.MMap
+	maxSize     int64
+	mlock       bool
+	contentSize int  // length of the encoded data at the start of mm
+	warmupSink  byte // Keeps the reads made by warmup from being optimized away
+}
+
+func openMMapPersister(path string, opts StoreOptions) (*mmapPersister, error) {
+	file, err := openDataFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat file: %v", err)
+	}
+
+	prot := mmap.RDWR
+	if opts.ReadOnly {
+		prot = mmap.RDONLY
+	} else if info.Size() < opts.InitialSize {
+		if err := file.Truncate(opts.InitialSize); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to truncate file: %v", err)
+		}
+	}
+
+	mm, err := mmap.Map(file, prot, 0)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to map file: %v", err)
+	}
+
+	p := &mmapPersister{file: file, mm: mm, maxSize: opts.MaxSize, mlock: opts.MLock}
+	if err := p.lock(); err != nil {
+		p.close()
+		return nil, err
+	}
+	if opts.Warmup && !opts.MLock { // Locking already faults every page in
+		p.warmup(path)
+	}
+	return p, nil
+}
+
+// read returns the mapped data without copying it
+func (p *mmapPersister) read() ([]byte, error) {
+	p.contentSize = p.findContentSize()
+	return p.mm[:p.contentSize], nil
+}
+
+// findContentSize finds the end of the data before the NUL padding
+func (p *mmapPersister) findContentSize() int {
+	return dataEnd(p.mm)
+}
+
+// write streams the encoding into the mapping after the current contents,
+// growing the file as it goes, and moves it to the start once it is
+// complete and known to fit. An encoding that fails, or exceeds the maximum
+// size, leaves the previous contents intact. Only the part of an encoding
+// that does not fit next to the previous one within the maximum size is
+// buffered in memory; the file may keep the room of both.
+func (p *mmapPersister) write(encode func(w io.Writer) error) (int, error) {
+	// A NUL byte separates the encoding from the current contents, so a
+	// crash before the move leaves them readable
+	w := &mmapWriter{p: p, start: p.contentSize + 1}
+	if err := encode(&quotaWriter{w: w, max: p.maxSize}); err != nil {
+		clear(w.mapped())
+		return 0, err
+	}
+
+	size := w.Len()
+	if size > len(p.mm) {
+		if err := p.grow(int64(size)); err != nil {
+			clear(w.mapped())
+			return 0, fmt.Errorf("failed to grow file: %w", err)
+		}
+	}
+	copy(p.mm, w.mapped())
+	copy(p.mm[w.n:], w.overflow)
+
+	// Zero whatever remains of the previous encoding and of the copy
+	if end := min(w.start+w.n, len(p.mm)); end > size {
+		clear(p.mm[size:end])
+	}
+
+	if err := injectFault(FaultFlush); err != nil {
+		return 0, fmt.Errorf("failed to flush to disk: %w", err)
+	}
+	if err := p.mm.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to flush to disk: %v", err)
+	}
+
+	p.contentSize = size
+	return size, nil
+}
+
+// resize grows or shrinks the mapped file, preserving its contents
+func (p *mmapPersister) resize(newSize int64) error {
+	if err := p.mm.Flush(); err != nil {
+		return fmt.Errorf("failed to flush before resize: %v", err)
+	}
+
+	// Unmap current file
+	if err := p.mm.Unmap(); err != nil {
+		return fmt.Errorf("failed to unmap: %v", err)
+	}
+
+	// Resize file
+	if err := p.file.Truncate(newSize); err != nil {
+		return fmt.Errorf("failed to truncate: %v", err)
+	}
+
+	// Remap file
+	mm, err := mmap.Map(p.file, mmap.RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to remap: %v", err)
+	}
+
+	p.mm = mm
+
+	// The lock ends with the old mapping. Failing to lock the larger one
+	// leaves it pageable but usable, so the write goes ahead.
+	if err := p.lock(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return nil
+}
+
+// grow increases the file size to accommodate new data
+func (p *mmapPersister) grow(requiredSize int64) error {
+	currentSize := int64(len(p.mm))
+	newSize := currentSize * 2
+
+	for newSize < requiredSize {
+		newSize *= 2
+	}
+
+	if p.maxSize > 0 && newSize > p.maxSize {
+		if requiredSize > p.maxSize {
+			return fmt.Errorf("%w: %d bytes required, max size is %d", ErrStoreFull, requiredSize, p.maxSize)
+		}
+		newSize = p.maxSize
+	}
+
+	return p.resize(newSize)
+}
+
+// lock locks the mapping into RAM when StoreOptions.MLock is set, so reads
+// and syncs never wait for pages to be read back from disk
+func (p *mmapPersister) lock() error {
+	if !p.mlock {
+		return nil
+	}
+	if err := p.mm.Lock(); err != nil {
+		return fmt.Errorf("failed to lock %d bytes into memory (check the locked memory limit, ulimit -l): %v", len(p.mm), err)
+	}
+	return nil
+}
+
+// warmup reads one byte of every page of the mapping so the first reads
+// after a restart do not page-fault
+func (p *mmapPersister) warmup(path string) {
+	start := time.Now()
+	pageSize := os.Getpagesize()
+
+	var sum byte
+	pages := 0
+	for i := 0; i < len(p.mm); i += pageSize {
+		sum += p.mm[i]
+		pages++
+	}
+	p.warmupSink = sum
+
+	log.Printf("Warmed up %d pages of %s in %v", pages, path, time.Since(start))
+}
+
+func (p *mmapPersister) size() int64 {
+	return int64(len(p.mm))
+}
+
+func (p *mmapPersister) close() error {
+	if err := p.mm.Unmap(); err != nil {
+		p.file.Close()
+		return fmt.Errorf("failed to unmap on close: %v", err)
+	}
+	return p.file.Close()
+}
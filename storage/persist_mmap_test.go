@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// writeMMap writes data through the persister in chunks, as the encoder does
+func writeMMap(p *mmapPersister, data []byte) (int, error) {
+	return p.write(func(w io.Writer) error {
+		for _, chunk := range bytes.SplitAfter(data, []byte("\n")) {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// mmapContents reopens the data file and returns its contents
+func mmapContents(t *testing.T, path string) []byte {
+	t.Helper()
+	p, err := openMMapPersister(path, StoreOptions{InitialSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+	data, err := p.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Clone(data)
+}
+
+func TestMMapPersisterWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.yaml")
+	p, err := openMMapPersister(path, StoreOptions{InitialSize: 4096, MaxSize: 64 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.read(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := func(n int, line string) []byte { return bytes.Repeat([]byte(line+"\n"), n) }
+	writes := [][]byte{
+		lines(10, "small"),
+		lines(1000, "grows the file past its initial size"),
+		lines(770, "fits the maximum size only without the previous one"), // Overflows
+		lines(3, "shrinks"),
+	}
+	for i, data := range writes {
+		size, err := writeMMap(p, data)
+		if err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+		if size != len(data) {
+			t.Fatalf("write %d: size %d, want %d", i, size, len(data))
+		}
+		if !bytes.Equal(p.mm[:size], data) || bytes.IndexFunc(p.mm[size:], func(r rune) bool { return r != 0 }) >= 0 {
+			t.Fatalf("write %d: the mapping does not hold the encoding followed by zeros", i)
+		}
+	}
+	last := writes[len(writes)-1]
+
+	// Neither an encoding larger than the maximum size nor a failing one
+	// touches the previous contents
+	if _, err := writeMMap(p, lines(3000, "exceeds the maximum size")); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("write beyond the maximum size: error %v, want ErrStoreFull", err)
+	}
+	failure := errors.New("encoding failed")
+	if _, err := p.write(func(w io.Writer) error {
+		w.Write(lines(500, "partial"))
+		return failure
+	}); !errors.Is(err, failure) {
+		t.Fatalf("failing encoding: error %v, want %v", err, failure)
+	}
+	if int64(len(p.mm)) > p.maxSize {
+		t.Errorf("file grew to %d bytes, past the maximum size %d", len(p.mm), p.maxSize)
+	}
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := mmapContents(t, path); !bytes.Equal(got, last) {
+		t.Fatalf("reopened file holds %d bytes, want the %d of the last successful write", len(got), len(last))
+	}
+}
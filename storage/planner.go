@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// planProbeLimit is the number of candidates at or below which the
+// remaining keyword, numeric and IP filters are checked on each candidate
+// instead of reading their postings
+const planProbeLimit = 1024
+
+// QueryPlan records how the planner ordered the stages of a search
+type QueryPlan struct {
+	Order        []string       `json:"order" yaml:"order"`                                     // Stages in execution order: filter, text and vector
+	Reason       string         `json:"reason" yaml:"reason"`                                   // Why the stages run in this order
+	Estimates    map[string]int `json:"estimates,omitempty" yaml:"estimates,omitempty"`         // Estimated matches of each stage, and vectors compared by vector
+	FilterOrder  []string       `json:"filter_order,omitempty" yaml:"filter_order,omitempty"`   // Indexed filter fields, most selective first
+	Probed       []string       `json:"probed,omitempty" yaml:"probed,omitempty"`               // Filter fields checked on each candidate
+	Skipped      []string       `json:"skipped,omitempty" yaml:"skipped,omitempty"`             // Filter fields not evaluated because nothing matched
+	Restricted   []string       `json:"restricted,omitempty" yaml:"restricted,omitempty"`       // Stages that scored only the candidates of earlier stages
+	ShortCircuit string         `json:"short_circuit,omitempty" yaml:"short_circuit,omitempty"` // Stage after which nothing matched and the rest was skipped
+	Payload      []string       `json:"payload,omitempty" yaml:"payload,omitempty"`             // Filter fields checked on the payloads of the vector indexes
+
+	filters     map[string]interface{} // Coerced field filters
+	labels      map[string]string
+	filterOrder []filterEstimate
+	payload     map[string]interface{} // Filters the vector stage checks on the payloads
+}
+
+// filterEstimate is the estimated matches of a filter on an indexed field
+type filterEstimate struct {
+	field string
+	kind  string // Type of the index evaluating the filter
+	n     int
+	probe bool // The index can check single documents
+}
+
+// estimateFilters estimates the matches of each filter on an indexed field
+// from the index sizes and postings, and returns them most selective first.
+// Fields without a filterable index are left out, as search ignores them.
+// Filters the indexes cannot evaluate are rejected here, so stopping early
+// never hides an invalid filter.
+func (im *IndexManager) estimateFilters(filters map[string]interface{}) ([]filterEstimate, error) {
+	im.RLock()
+	defer im.RUnlock()
+
+	order := make([]filterEstimate, 0, len(filters))
+	for field, value := range filters {
+		e := filterEstimate{field: field, probe: true}
+		if idx, exists := im.keywords[field]; exists {
+			e.kind, e.n = "keyword", idx.estimate(value)
+		} else if idx, exists := im.numeric[field]; exists {
+			r, err := parseNumericFilter(value)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field, err)
+			}
+			e.kind, e.n = "numeric", idx.Count(r)
+		} else if tree, exists := im.trees[field]; exists {
+			// Equal values are only found by a scan, so assume the worst
+			e.kind, e.n, e.probe = "btree", tree.Len(), false
+		} else if idx, exists := im.ips[field]; exists {
+			if _, _, err := parseIPFilter(value); err != nil {
+				return nil, fmt.Errorf("field %s: %v", field, err)
+			}
+			e.kind, e.n = "ip", idx.Len()
+		} else {
+			continue
+		}
+		order = append(order, e)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if order[i].n != order[j].n {
+			return order[i].n < order[j].n
+		}
+		return order[i].field < order[j].field
+	})
+	return order, nil
+}
+
+// filter evaluates the filters in order to the IDs in within matching all
+// of them, a nil within standing for every document. Once the matches are
+// at most planProbeLimit, filters whose index can check single documents
+// are checked on each match, and evaluation stops when nothing matches.
+// The result is nil if no filter was evaluated. Probed and skipped fields
+// are recorded in plan when it is not nil.
+func (im *IndexManager) filter(filters map[string]interface{}, order []filterEstimate, within *Bitmap, stage *QueryStage, plan *QueryPlan) (*Bitmap, error) {
+	im.RLock()
+	defer im.RUnlock()
+
+	var result *Bitmap
+	for i, e := range order {
+		candidates := result
+		if candidates == nil {
+			candidates = within
+		}
+		if candidates != nil && candidates.Len() == 0 {
+			if plan != nil {
+				for _, skipped := range order[i:] {
+					plan.Skipped = append(plan.Skipped, skipped.field)
+				}
+			}
+			break
+		}
+
+		var found *Bitmap
+		var err error
+		probe := candidates != nil && candidates.Len() <= planProbeLimit && e.probe
+		if probe {
+			found, err = im.probeFilter(e.field, filters[e.field], candidates)
+			if plan != nil {
+				plan.Probed = append(plan.Probed, e.field)
+			}
+		} else {
+			found, err = im.evaluateFilter(e.field, filters[e.field])
+		}
+		if err != nil {
+			return nil, err
+		}
+		if found == nil {
+			continue // The index was removed since the estimate
+		}
+
+		if stage != nil {
+			if stage.Candidates == nil {
+				stage.Candidates = make(map[string]int)
+			}
+			stage.Candidates[e.field] = found.Len()
+		}
+		if candidates != nil && !probe {
+			found = found.And(candidates)
+		}
+		result = found
+	}
+	return result, nil
+}
+
+// probeFilter returns the IDs in within matching the filter on field,
+// checking each document, or nil if the field has no such index. Callers
+// must hold the lock.
+func (im *IndexManager) probeFilter(field string, value interface{}, within *Bitmap) (*Bitmap, error) {
+	if idx, exists := im.keywords[field]; exists {
+		return idx.probe(value, within), nil
+	} else if idx, exists := im.numeric[field]; exists {
+		found, err := idx.probe(value, within)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+		return found, nil
+	} else if idx, exists := im.ips[field]; exists {
+		found, err := idx.probe(value, within)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", field, err)
+		}
+		return found, nil
+	}
+	return im.evaluateFilter(field, value)
+}
+
+// planQuery orders the stages of a query by their estimated matches. The
+// filters run first when they are estimated to match fewer documents than
+// the text, or when a vector search follows, so the text and vector stages
+// score only their candidates; otherwise the text runs first and the
+// filters are checked against its matches. A vector search whose filters
+// are all on payload fields of the vector indexes checks them while
+// comparing vectors instead, unless an indexed filter is selective enough
+// to probe, and otherwise still checks the payload fields without an
+// index. Callers must hold the lock.
+func (s *Store) planQuery(query SearchQuery) (*QueryPlan, error) {
+	plan := &QueryPlan{Estimates: make(map[string]int)}
+	hasText, hasVector := query.Text != "", len(query.Vector) > 0
+	hasFilter := len(query.Filters) > 0 || len(query.Prefixes) > 0 || query.hasJoin()
+
+	if hasFilter {
+		// Without any estimate the filters may match every entry
+		estimate := len(s.data)
+		if len(query.Filters) > 0 {
+			fields, labels := splitLabelFilters(query.Filters)
+			filters, err := s.coerceFilters(fields)
+			if err != nil {
+				return nil, err
+			}
+			order, err := s.indexes.estimateFilters(filters)
+			if err != nil {
+				return nil, fmt.Errorf("filter search error: %w", err)
+			}
+			plan.filters, plan.labels, plan.filterOrder = filters, labels, order
+			for _, e := range order {
+				plan.FilterOrder = append(plan.FilterOrder, e.field)
+				estimate = min(estimate, e.n)
+			}
+			for name, value := range labels {
+				estimate = min(estimate, len(s.labels[name+"="+value]))
+			}
+		}
+		s.indexes.RLock()
+		for field := range query.Prefixes {
+			idx, exists := s.indexes.keywords[field]
+			if !exists {
+				s.indexes.RUnlock()
+				return nil, fmt.Errorf("%w: prefix on %s needs a keyword index", ErrInvalidQuery, field)
+			}
+			estimate = min(estimate, idx.Len())
+		}
+		s.indexes.RUnlock()
+		if query.Parent != "" {
+			estimate = min(estimate, len(s.children[query.Parent]))
+		}
+		plan.Estimates["filter"] = estimate
+	}
+
+	s.indexes.RLock()
+	if hasText {
+		n := 0
+		for field, idx := range s.indexes.text {
+			if len(query.TextFields) == 0 || containsString(query.TextFields, field) {
+				n += idx.estimate(query.Text)
+			}
+		}
+		plan.Estimates["text"] = n
+	}
+	// Filters on payload fields of every vector index searched can be
+	// checked while comparing the vectors
+	var payload map[string]interface{}
+	if hasVector {
+		n := 0
+		targets, _ := s.indexes.vectorTargets(query) // The vector stage fails on errors
+		for _, idx := range targets {
+			n += idx.Len()
+		}
+		plan.Estimates["vector"] = n
+		if !hasText {
+			payload = payloadFilters(targets, plan.filters)
+		}
+	}
+	s.indexes.RUnlock()
+
+	// The postings of a selective indexed filter narrow the vectors compared
+	// more cheaply than checking the payload of every vector; the payloads
+	// then check only the filters no index evaluates
+	payloadOnly := len(payload) > 0 && len(payload) == len(plan.filters) && len(plan.labels) == 0 && len(query.Prefixes) == 0 &&
+		!query.hasJoin() && (len(plan.filterOrder) == 0 || plan.filterOrder[0].n > planProbeLimit)
+	if !payloadOnly {
+		for _, e := range plan.filterOrder {
+			delete(payload, e.field)
+		}
+	}
+	for field, filter := range payload {
+		if err := checkPayloadFilter(field, filter); err != nil {
+			return nil, err
+		}
+		plan.Payload = append(plan.Payload, field)
+	}
+	sort.Strings(plan.Payload)
+	plan.payload = payload
+
+	switch {
+	case !hasFilter:
+		plan.Reason = "no filters"
+	case hasText && !hasVector && plan.Estimates["text"] < plan.Estimates["filter"]:
+		plan.Order = []string{"text", "filter"}
+		plan.Reason = "text estimated to match fewer documents than the filters"
+		return plan, nil
+	case payloadOnly:
+		plan.Order = []string{"vector"}
+		plan.Reason = "filters checked on the payloads of the vector indexes"
+		return plan, nil
+	case hasVector:
+		plan.Order = []string{"filter"}
+		plan.Reason = "filters narrow the vectors compared"
+	case hasText:
+		plan.Order = []string{"filter"}
+		plan.Reason = "filters estimated to match fewer documents than the text"
+	default:
+		plan.Order = []string{"filter"}
+		plan.Reason = "filters only"
+	}
+	if hasText {
+		plan.Order = append(plan.Order, "text")
+	}
+	if hasVector {
+		plan.Order = append(plan.Order, "vector")
+	}
+	return plan, nil
+}
+
+// filterLocked runs the filter and prefix stages of a plan, checking the
+// field filters against within when it is not nil. The result is nil if no
+// filter applied. Callers must hold the lock.
+func (s *Store) filterLocked(query SearchQuery, plan *QueryPlan, within *Bitmap, trace *queryTrace) ([]string, error) {
+	var results []string
+	if len(query.Filters) > 0 {
+		stage := QueryStage{Stage: "filter"}
+		start := time.Now()
+		found, err := s.indexes.filter(plan.filters, plan.filterOrder, within, &stage, plan)
+		if err != nil {
+			return nil, fmt.Errorf("filter search error: %w", err)
+		}
+		if found != nil {
+			results = s.indexes.ids.keysOf(found)
+		}
+		if plan.labels != nil && (results == nil || len(results) > 0) {
+			results = intersectKeys(results, s.matchLabels(plan.labels, &stage))
+		}
+		trace.record(stage, start, len(results))
+	}
+
+	if len(query.Prefixes) > 0 && (results == nil || len(results) > 0) {
+		stage := QueryStage{Stage: "prefix"}
+		start := time.Now()
+		found, err := s.indexes.searchPrefixes(query.Prefixes, &stage)
+		if err != nil {
+			return nil, err
+		}
+		trace.record(stage, start, len(found))
+		results = intersectKeys(results, found)
+	}
+
+	if query.hasJoin() && (results == nil || len(results) > 0) {
+		found, err := s.joinLocked(query, trace)
+		if err != nil {
+			return nil, err
+		}
+		results = intersectKeys(results, found)
+	}
+	return results, nil
+}
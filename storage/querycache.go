@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"container/list"
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// queryCacheMaxResults bounds the results of a cached search, so filter-only
+// queries without max_results do not pin large result sets in memory
+const queryCacheMaxResults = 10000
+
+// queryCacheAny is the dependency of queries that read fields no index
+// declares, such as those of expr and computed fields. Every write
+// invalidates them.
+const queryCacheAny = "*"
+
+// QueryCacheStats reports the effectiveness of the search result cache
+type QueryCacheStats struct {
+	Size          int     `json:"size" yaml:"size"`         // Cached queries
+	Capacity      int     `json:"capacity" yaml:"capacity"` // StoreOptions.QueryCacheSize
+	Hits          uint64  `json:"hits" yaml:"hits"`
+	Misses        uint64  `json:"misses" yaml:"misses"`
+	Invalidations uint64  `json:"invalidations" yaml:"invalidations"` // Cached queries dropped by writes
+	HitRate       float64 `json:"hit_rate" yaml:"hit_rate"`           // Hits over hits and misses
+}
+
+// queryCache keeps the results of recent searches in LRU order, keyed by a
+// hash of the normalized query. Each cached query records the fields it
+// depends on and the keys it returned; a write drops the queries returning
+// the written key or depending on a field of its old or new value, and
+// index or coercion changes drop everything.
+type queryCache struct {
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List // *queryCacheEntry, most recently used first
+	entries map[uint64]*list.Element
+	byField map[string]map[*queryCacheEntry]struct{}
+	byKey   map[string]map[*queryCacheEntry]struct{}
+
+	generation    atomic.Uint64 // Incremented by every invalidation
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+type queryCacheEntry struct {
+	hash    uint64
+	query   string // Normalized query, to tell hash collisions apart
+	fields  []string
+	results []SearchResult
+}
+
+func newQueryCache(size int) *queryCache {
+	if size <= 0 {
+		return nil
+	}
+	return &queryCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[uint64]*list.Element, size),
+		byField: make(map[string]map[*queryCacheEntry]struct{}),
+		byKey:   make(map[string]map[*queryCacheEntry]struct{}),
+	}
+}
+
+// normalizeQuery returns the canonical form of a cacheable query and its
+// hash. Queries with a sample are random and not cacheable, nor are those
+// with a scorer, which may depend on more than the data, such as the time.
+func normalizeQuery(query SearchQuery) (string, uint64, bool) {
+	if query.Sample > 0 || query.Scorer != "" {
+		return "", 0, false
+	}
+	query.Explain = false
+	if len(query.TextFields) > 1 {
+		query.TextFields = slices.Clone(query.TextFields)
+		slices.Sort(query.TextFields)
+	}
+	// Maps are encoded with sorted keys, so equal queries encode equally
+	b, err := json.Marshal(query)
+	if err != nil {
+		return "", 0, false
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return string(b), h.Sum64(), true
+}
+
+// get returns a copy of the cached results of a query
+func (c *queryCache) get(hash uint64, query string) ([]SearchResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[hash]
+	if !exists || elem.Value.(*queryCacheEntry).query != query {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits.Add(1)
+	return slices.Clone(elem.Value.(*queryCacheEntry).results), true
+}
+
+// put caches the results of a query, unless queries were invalidated since
+// generation was read. Writes to the indexes outside the store lock
+// invalidate while searches run, and a search that began before such a
+// write may have missed it.
+func (c *queryCache) put(generation, hash uint64, query string, fields []string, results []SearchResult) {
+	if len(results) > queryCacheMaxResults {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation.Load() != generation {
+		return
+	}
+	if elem, exists := c.entries[hash]; exists {
+		c.removeLocked(elem.Value.(*queryCacheEntry))
+	}
+	e := &queryCacheEntry{hash: hash, query: query, fields: fields, results: slices.Clone(results)}
+	c.entries[hash] = c.lru.PushFront(e)
+	for _, field := range fields {
+		addQueryRef(c.byField, field, e)
+	}
+	for _, r := range e.results {
+		addQueryRef(c.byKey, r.Key, e)
+	}
+	for c.lru.Len() > c.size {
+		c.removeLocked(c.lru.Back().Value.(*queryCacheEntry))
+	}
+}
+
+func addQueryRef(refs map[string]map[*queryCacheEntry]struct{}, name string, e *queryCacheEntry) {
+	if refs[name] == nil {
+		refs[name] = make(map[*queryCacheEntry]struct{})
+	}
+	refs[name][e] = struct{}{}
+}
+
+func removeQueryRef(refs map[string]map[*queryCacheEntry]struct{}, name string, e *queryCacheEntry) {
+	delete(refs[name], e)
+	if len(refs[name]) == 0 {
+		delete(refs, name)
+	}
+}
+
+// removeLocked drops a cached query. Callers must hold c.mu.
+func (c *queryCache) removeLocked(e *queryCacheEntry) {
+	c.lru.Remove(c.entries[e.hash])
+	delete(c.entries, e.hash)
+	for _, field := range e.fields {
+		removeQueryRef(c.byField, field, e)
+	}
+	for _, r := range e.results {
+		removeQueryRef(c.byKey, r.Key, e)
+	}
+}
+
+// empty reports whether no query is cached
+func (c *queryCache) empty() bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len() == 0
+}
+
+// invalidate drops the queries returning key or depending on any of fields
+func (c *queryCache) invalidate(key string, fields []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation.Add(1)
+	var stale []*queryCacheEntry
+	for e := range c.byKey[key] {
+		stale = append(stale, e)
+	}
+	for _, field := range append(fields, queryCacheAny) {
+		for e := range c.byField[field] {
+			stale = append(stale, e)
+		}
+	}
+	for _, e := range stale {
+		if elem, cached := c.entries[e.hash]; cached && elem.Value == e {
+			c.removeLocked(e)
+			c.invalidations.Add(1)
+		}
+	}
+}
+
+// clear drops every cached query
+func (c *queryCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation.Add(1)
+	c.invalidations.Add(uint64(c.lru.Len()))
+	c.lru.Init()
+	clear(c.entries)
+	clear(c.byField)
+	clear(c.byKey)
+}
+
+func (c *queryCache) stats() QueryCacheStats {
+	if c == nil {
+		return QueryCacheStats{}
+	}
+	c.mu.Lock()
+	stats := QueryCacheStats{Size: c.lru.Len(), Capacity: c.size}
+	c.mu.Unlock()
+
+	stats.Hits = c.hits.Load()
+	stats.Misses = c.misses.Load()
+	stats.Invalidations = c.invalidations.Load()
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// cachedSearch answers a query from the result cache, running and caching
+// it on a miss
+func (s *Store) cachedSearch(query SearchQuery) ([]SearchResult, error) {
+	canonical, hash, ok := normalizeQuery(query)
+	if !ok {
+		results, _, err := s.search(query, nil)
+		return results, err
+	}
+
+	start := time.Now()
+	s.RLock()
+	defer s.RUnlock()
+
+	if results, hit := s.queryCache.get(hash, canonical); hit {
+		s.latency.search.Record(time.Since(start))
+		return results, nil
+	}
+	generation := s.queryCache.generation.Load()
+	results, _, err := s.searchLocked(query, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Entries added by index builds bypass the writes that invalidate
+	// queries, so nothing is cached until the builds finish
+	s.startupMu.Lock()
+	building := len(s.builds) > 0
+	s.startupMu.Unlock()
+	if !building {
+		s.queryCache.put(generation, hash, canonical, s.queryFields(query), results)
+	}
+	return results, nil
+}
+
+// queryFields returns the fields whose writes can change the results of a
+// query. Callers must hold the lock.
+func (s *Store) queryFields(query SearchQuery) []string {
+	// Joins and grouped chunks depend on other entries than the matches
+	if query.Expr != "" || len(query.Fields) > 0 || query.hasJoin() || query.GroupChunks {
+		return []string{queryCacheAny}
+	}
+	var fields []string
+	for field := range query.Filters {
+		fields = append(fields, field)
+	}
+	for field := range query.Prefixes {
+		fields = append(fields, field)
+	}
+	if query.Text != "" {
+		for field := range s.indexes.text {
+			if len(query.TextFields) == 0 || containsString(query.TextFields, field) {
+				fields = append(fields, field)
+			}
+		}
+	}
+	if len(query.Vector) > 0 {
+		for field := range s.indexes.vectors {
+			// A query naming its vector field reads no other
+			if query.VectorField == "" || query.VectorField == field {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// invalidateQueries drops the cached queries a write of key can change,
+// from the fields and labels of its old and new entries, either of which
+// may be nil. Callers must hold the lock.
+func (s *Store) invalidateQueries(key string, old, entry *Entry) {
+	if s.queryCache.empty() {
+		return
+	}
+	var fields []string
+	for _, e := range []*Entry{old, entry} {
+		if e == nil {
+			continue
+		}
+		if m, ok := e.hydrate().Value.(map[string]interface{}); ok {
+			for field := range m {
+				fields = append(fields, field)
+			}
+		}
+		if e.Metadata != nil {
+			for name := range e.Metadata.Labels {
+				fields = append(fields, LabelFilterPrefix+name)
+			}
+		}
+	}
+	s.queryCache.invalidate(key, fields)
+}
+
+// QueryCacheStats returns the hits, misses and size of the search result
+// cache
+func (s *Store) QueryCacheStats() QueryCacheStats {
+	return s.queryCache.stats()
+}
+
+// ClearQueryCache drops every cached search result
+func (s *Store) ClearQueryCache() {
+	s.queryCache.clear()
+}
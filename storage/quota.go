@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Policies for writes over a limit, see StoreOptions.LimitPolicy
+const (
+	LimitReject = "reject" // Reject the write with ErrQuotaExceeded
+	LimitEvict  = "evict"  // Evict the least recently written entries to make room
+)
+
+// indexMemoryRefresh is the minimum number of writes and deletes between
+// estimates of the index memory, which walk every index
+const indexMemoryRefresh = 1024
+
+// indexGrowthFactor is the assumed growth of the indexes by a write, times
+// the estimated size of its value, between walks of the indexes. It errs
+// high, so a limit is never crossed unnoticed; crossing the grown estimate
+// walks the indexes to check.
+const indexGrowthFactor = 8
+
+// evictBatchDivisor sets the smallest eviction to this fraction of the
+// entries it chooses from, so a store at its limit does not scan for the
+// oldest entries on every write
+const evictBatchDivisor = 100
+
+// IndexLimit bounds the indexes on a field. A write adding a document to
+// them is rejected or makes room by eviction, following
+// StoreOptions.LimitPolicy, once they hold MaxEntries documents or their
+// estimated memory reaches MaxMemory. Updates of documents already holding
+// the field are not limited.
+type IndexLimit struct {
+	MaxEntries int   `json:"max_entries,omitempty" yaml:"max_entries,omitempty"` // 0 for unlimited
+	MaxMemory  int64 `json:"max_memory,omitempty" yaml:"max_memory,omitempty"`   // Bytes as estimated by MemoryUsage, 0 for unlimited
+}
+
+// indexMemoryEstimate caches the estimated memory of the indexes between
+// walks of them
+type indexMemoryEstimate struct {
+	valid   bool
+	total   int64
+	fields  map[string]int64
+	changes int // Writes and deletes since the estimate
+}
+
+func checkLimitPolicy(opts StoreOptions) error {
+	switch opts.LimitPolicy {
+	case "", LimitReject, LimitEvict:
+		return nil
+	}
+	return fmt.Errorf("unknown limit policy %q: use %s or %s", opts.LimitPolicy, LimitReject, LimitEvict)
+}
+
+// SetIndexLimit limits the indexes on field; a zero limit removes it
+func (s *Store) SetIndexLimit(field string, limit IndexLimit) error {
+	if limit.MaxEntries < 0 || limit.MaxMemory < 0 {
+		return fmt.Errorf("index limits of %s must not be negative", field)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if limit == (IndexLimit{}) {
+		delete(s.indexLimits, field)
+		return nil
+	}
+	if s.indexLimits == nil {
+		s.indexLimits = make(map[string]IndexLimit)
+	}
+	s.indexLimits[field] = limit
+	return nil
+}
+
+// IndexLimits returns the limits of the indexes by field
+func (s *Store) IndexLimits() map[string]IndexLimit {
+	s.RLock()
+	defer s.RUnlock()
+
+	out := make(map[string]IndexLimit, len(s.indexLimits))
+	for field, limit := range s.indexLimits {
+		out[field] = limit
+	}
+	return out
+}
+
+// limited reports whether writes are checked by enforceLimits. Callers must
+// hold the lock.
+func (s *Store) limited() bool {
+	return s.opts.MaxEntries > 0 && s.opts.LimitPolicy == LimitEvict ||
+		s.opts.MaxIndexMemory > 0 || len(s.indexLimits) > 0
+}
+
+// enforceLimits checks a prepared write of key against MaxEntries under
+// LimitEvict, MaxIndexMemory and the index limits, evicting entries or
+// rejecting the write as LimitPolicy says. Only writes adding a document,
+// or a field to one, count against a limit, so updates of a store at its
+// limits still succeed. Callers must hold the lock.
+func (s *Store) enforceLimits(key string, value interface{}) error {
+	if !s.limited() {
+		return nil
+	}
+	old, exists := s.data[key]
+
+	if !exists && s.opts.MaxEntries > 0 && len(s.data) >= s.opts.MaxEntries {
+		// Only reached under LimitEvict, as checkQuota rejects otherwise
+		if s.evict(key, "", len(s.data)-s.opts.MaxEntries+1) == 0 {
+			return fmt.Errorf("%w: entry limit %d reached", ErrQuotaExceeded, s.opts.MaxEntries)
+		}
+	}
+	if !exists && s.opts.MaxIndexMemory > 0 {
+		if err := s.limitIndexMemory(key, "", s.opts.MaxIndexMemory); err != nil {
+			return err
+		}
+	}
+
+	m, _ := value.(map[string]interface{})
+	var oldFields map[string]interface{}
+	if exists {
+		oldFields, _ = old.hydrate().Value.(map[string]interface{})
+	}
+	for field, limit := range s.indexLimits {
+		if _, adds := m[field]; !adds {
+			continue
+		}
+		if _, had := oldFields[field]; had {
+			continue
+		}
+		if n := s.indexes.fieldLen(field); limit.MaxEntries > 0 && n >= limit.MaxEntries {
+			if s.opts.LimitPolicy != LimitEvict || s.evict(key, field, n-limit.MaxEntries+1) == 0 {
+				return fmt.Errorf("%w: index limit of %d entries on %s reached", ErrQuotaExceeded, limit.MaxEntries, field)
+			}
+		}
+		if limit.MaxMemory > 0 {
+			if err := s.limitIndexMemory(key, field, limit.MaxMemory); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.indexMemory.changes++
+	if s.indexMemory.valid {
+		s.indexMemory.total += indexGrowthFactor * estimateValueSize(value)
+		for field := range s.indexLimits {
+			if fieldValue, exists := m[field]; exists {
+				s.indexMemory.fields[field] += indexGrowthFactor * estimateValueSize(fieldValue)
+			}
+		}
+	}
+	return nil
+}
+
+// limitIndexMemory rejects a write of key, or evicts entries for it, while
+// the estimated memory of the indexes on field, or of every index when
+// field is empty, is at limit. Callers must hold the lock.
+func (s *Store) limitIndexMemory(key, field string, limit int64) error {
+	used := s.indexMemoryUsed(field)
+	if used >= limit && s.indexMemory.changes > 0 {
+		// The data changed since the estimate, so check again before
+		// rejecting or evicting
+		s.estimateIndexMemory()
+		used = s.indexMemoryUsed(field)
+	}
+	if used < limit {
+		return nil
+	}
+
+	entries := len(s.data)
+	if field != "" {
+		entries = s.indexes.fieldLen(field)
+	}
+	if s.opts.LimitPolicy == LimitEvict && entries > 0 {
+		// Assume the evicted entries take their share of the memory
+		perEntry := max(used/int64(entries), 1)
+		n := int((used-limit)/perEntry) + 1
+		if evicted := s.evict(key, field, n); evicted > 0 {
+			freed := perEntry * int64(evicted)
+			s.indexMemory.total -= freed
+			if field != "" {
+				s.indexMemory.fields[field] -= freed
+			}
+			return nil
+		}
+	}
+	if field == "" {
+		return fmt.Errorf("%w: index memory limit of %d bytes reached", ErrQuotaExceeded, limit)
+	}
+	return fmt.Errorf("%w: index memory limit of %d bytes on %s reached", ErrQuotaExceeded, limit, field)
+}
+
+// indexMemoryUsed returns the estimated memory of the indexes on field, or
+// of every index when field is empty, estimating it again once enough
+// writes have changed the indexes. Callers must hold the lock.
+func (s *Store) indexMemoryUsed(field string) int64 {
+	if !s.indexMemory.valid || s.indexMemory.changes >= max(indexMemoryRefresh, len(s.data)/8) {
+		s.estimateIndexMemory()
+	}
+	if field == "" {
+		return s.indexMemory.total
+	}
+	return s.indexMemory.fields[field]
+}
+
+// estimateIndexMemory walks the indexes to estimate their memory. Callers
+// must hold the lock.
+func (s *Store) estimateIndexMemory() {
+	estimate := indexMemoryEstimate{valid: true, fields: make(map[string]int64)}
+	for _, idx := range s.indexes.memoryUsage() {
+		estimate.total += idx.Bytes
+		if idx.Field != "" {
+			estimate.fields[idx.Field] += idx.Bytes
+		}
+	}
+	s.indexMemory = estimate
+}
+
+// invalidateIndexMemory discards the estimated index memory, as after an
+// index was created or removed
+func (s *Store) invalidateIndexMemory() {
+	s.Lock()
+	s.indexMemory.valid = false
+	s.Unlock()
+}
+
+// evict deletes at least n of the least recently written entries other than
+// except, of those holding field when it is not empty, and returns the
+// number deleted. Callers must hold the lock.
+func (s *Store) evict(except, field string, n int) int {
+	type candidate struct {
+		key       string
+		timestamp int64
+	}
+	var candidates []candidate
+	for key, entry := range s.data {
+		if key == except {
+			continue
+		}
+		if field != "" {
+			if _, exists := fieldOf(entry.hydrate().Value, field); !exists {
+				continue
+			}
+		}
+		candidates = append(candidates, candidate{key, entry.Timestamp})
+	}
+	n = min(max(n, len(candidates)/evictBatchDivisor), len(candidates))
+	if n == 0 {
+		return 0
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].timestamp != candidates[j].timestamp {
+			return candidates[i].timestamp < candidates[j].timestamp
+		}
+		return candidates[i].key < candidates[j].key
+	})
+
+	for _, c := range candidates[:n] {
+		s.deleteEntry(c.key)
+	}
+	s.dirty = true
+	s.counters.evictions.Add(uint64(n))
+	return n
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMaxSizeBetweenSyncs fills a store that never syncs by itself, then
+// frees room with deletes
+func TestMaxSizeBetweenSyncs(t *testing.T) {
+	const maxSize = 100 << 10
+	store, err := NewStore("", StoreOptions{Persistence: PersistMemory, SyncInterval: time.Hour, MaxSize: maxSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	doc := map[string]interface{}{"text": strings.Repeat("x", 1000)}
+
+	written := 0
+	for ; written < 1000; written++ {
+		if err = store.Set(fmt.Sprintf("k%d", written), doc); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("writing 1000 documents of 1 KB: error %v, want ErrStoreFull", err)
+	}
+	if written < 80 || written > maxSize/1000 {
+		t.Fatalf("%d documents of 1 KB fit in %d bytes", written, maxSize)
+	}
+	if size := store.GetStats().DataSize; size > maxSize {
+		t.Fatalf("data size %d exceeds the maximum size %d", size, maxSize)
+	}
+
+	for i := range 10 {
+		store.Delete(fmt.Sprintf("k%d", i))
+	}
+	for i := range 1000 {
+		if err := store.Set("k0", doc); err != nil {
+			t.Fatalf("update %d after deletes: %v", i, err)
+		}
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
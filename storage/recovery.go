@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// recoveryCopySuffix is appended, with the time of the recovery, to the path
+// of the copy of a damaged data file
+const recoveryCopySuffix = ".corrupt-"
+
+// RecoveryReport describes a data file that could not be decoded on startup
+// and what was recovered from it
+type RecoveryReport struct {
+	Recovered bool       `json:"recovered" yaml:"recovered"` // False when the data file loaded normally
+	Time      *time.Time `json:"time,omitempty" yaml:"time,omitempty"`
+	Error     string     `json:"error,omitempty" yaml:"error,omitempty"`         // Decode error that started the recovery
+	Preserved string     `json:"preserved,omitempty" yaml:"preserved,omitempty"` // Copy of the damaged file, kept for inspection
+	Salvaged  int        `json:"salvaged" yaml:"salvaged"`                       // Entries recovered from the data file
+	Fallback  string     `json:"fallback,omitempty" yaml:"fallback,omitempty"`   // Shadow or backup file entries were restored from
+	Restored  []string   `json:"restored,omitempty" yaml:"restored,omitempty"`   // Keys restored from the fallback, sorted
+	Lost      []string   `json:"lost,omitempty" yaml:"lost,omitempty"`           // Damaged keys that could not be restored, sorted
+	Unnamed   int        `json:"unnamed" yaml:"unnamed"`                         // Damaged entries whose key could not be read
+}
+
+// recoverData salvages the entries of a data file that failed to decode.
+// Segments that still decode are kept whole; the others are split into
+// their top-level entries, which are decoded one by one and kept if they
+// match their content hash. An entry without a hash is kept unless it ends
+// the file, where a truncated write would have cut it short. Binary files
+// are salvaged record by record, see salvageRecords. Damaged keys are
+// restored from the newest shadow or migration backup that decodes, and
+// when nothing could be salvaged that file replaces the data file entirely.
+// Unless the store is read-only, the damaged file is copied aside first and
+// the recovered data is written back on the next sync. Files of a newer
+// format are not recovered. Callers must hold the lock.
+func (s *Store) recoverData(content []byte, cause error) (map[string]*Entry, int, error) {
+	if errors.Is(cause, ErrUnsupportedFormat) {
+		return nil, 0, cause
+	}
+	version, body, err := FormatVersion(content)
+	if err != nil {
+		return nil, 0, cause
+	}
+	report, err := s.beginRecovery(content, cause)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries map[string]*Entry
+	var lost []string
+	var unnamed int
+	if version == FormatBinary {
+		entries, lost, unnamed = s.salvageRecords(body)
+	} else {
+		entries, lost, unnamed = s.salvageEntries(body)
+	}
+	report.Salvaged = len(entries)
+	report.Unnamed = unnamed
+	if len(lost) > 0 || unnamed > 0 || len(entries) == 0 {
+		if path, fallback := s.recoveryFallback(); fallback != nil {
+			report.Fallback = path
+			if len(entries) == 0 {
+				// Nothing was salvaged, so restore the fallback entirely
+				lost = lost[:0]
+				for key := range fallback {
+					lost = append(lost, key)
+				}
+			}
+			var remaining []string
+			for _, key := range lost {
+				if entry, exists := fallback[key]; exists {
+					entries[key] = entry
+					report.Restored = append(report.Restored, key)
+				} else {
+					remaining = append(remaining, key)
+				}
+			}
+			lost = remaining
+		}
+	}
+	sort.Strings(report.Restored)
+	sort.Strings(lost)
+	report.Lost = lost
+
+	log.Printf("Warning: %s could not be decoded (%v); recovered %d entries, restored %d from %q, lost %d and %d unnamed",
+		s.filepath, cause, report.Salvaged, len(report.Restored), report.Fallback, len(report.Lost), report.Unnamed)
+	return entries, version, nil
+}
+
+// beginRecovery starts the recovery report, copying the damaged file aside
+// unless the store is read-only. Callers must hold the lock.
+func (s *Store) beginRecovery(content []byte, cause error) (*RecoveryReport, error) {
+	if s.recovery != nil {
+		return s.recovery, nil
+	}
+	now := time.Now()
+	report := &RecoveryReport{Recovered: true, Time: &now, Error: cause.Error()}
+	if !s.opts.ReadOnly {
+		// The recovered data replaces the file on the next sync, so keep
+		// the original for inspection; without a copy, refuse to start
+		report.Preserved = s.filepath + recoveryCopySuffix + now.UTC().Format("20060102T150405Z")
+		if err := os.WriteFile(report.Preserved, content, 0644); err != nil {
+			return nil, fmt.Errorf("%w; failed to preserve the file for recovery: %v", cause, err)
+		}
+		s.dirty = true
+	}
+	s.recovery = report
+	return report, nil
+}
+
+// salvageEntries decodes what it can of a damaged data file body, returning
+// the valid entries, the keys of damaged entries, and the number of damaged
+// entries whose key could not be read
+func (s *Store) salvageEntries(body []byte) (map[string]*Entry, []string, int) {
+	entries := make(map[string]*Entry)
+	var lost []string
+	unnamed := 0
+
+	segments := splitSegments(body)
+	for i, segment := range segments {
+		// The last segment is split even if it decodes, as a truncated
+		// entry can still be valid YAML
+		var decoded map[string]*Entry
+		if i < len(segments)-1 && s.encoder.Decode(segment, &decoded) == nil {
+			for key, entry := range decoded {
+				if s.validEntry(entry, true) {
+					addSalvaged(entries, key, entry)
+				} else {
+					lost = append(lost, key)
+				}
+			}
+			continue
+		}
+
+		chunks := entryChunks(segment)
+		for j, chunk := range chunks {
+			complete := i < len(segments)-1 || j < len(chunks)-1
+			var one map[string]*Entry
+			if err := s.encoder.Decode(chunk, &one); err == nil && len(one) == 1 {
+				for key, entry := range one {
+					if s.validEntry(entry, complete) {
+						addSalvaged(entries, key, entry)
+					} else {
+						lost = append(lost, key)
+					}
+				}
+			} else if key, ok := chunkKey(chunk); ok {
+				lost = append(lost, key)
+			} else {
+				unnamed++
+			}
+		}
+	}
+
+	// A key both salvaged and damaged was written twice; the salvaged
+	// entry stands
+	kept := lost[:0]
+	for _, key := range lost {
+		if _, exists := entries[key]; !exists {
+			kept = append(kept, key)
+		}
+	}
+	return entries, kept, unnamed
+}
+
+// salvageRecords is salvageEntries for FormatBinary bodies. A record whose
+// checksum fails is kept out, and reading stops there unless the record
+// still decodes, as its length cannot be trusted to find the next one.
+func (s *Store) salvageRecords(body []byte) (map[string]*Entry, []string, int) {
+	entries := make(map[string]*Entry)
+	var lost []string
+	unnamed := 0
+
+	for {
+		payload, rest, err := nextRecord(body)
+		if err == nil && payload == nil {
+			break // The terminator
+		}
+		if payload == nil {
+			// Truncated; its key may have been written
+			if _, n := binary.Uvarint(body); n > 0 {
+				if key, keyErr := readString(bytes.NewReader(body[n:])); keyErr == nil && key != "" {
+					lost = append(lost, key)
+					break
+				}
+			}
+			unnamed++
+			break
+		}
+
+		key, entry, decodeErr := decodeRecord(payload)
+		switch {
+		case err == nil && decodeErr == nil && s.validEntry(entry, true):
+			addSalvaged(entries, key, entry)
+		case decodeErr == nil:
+			lost = append(lost, key)
+		default:
+			unnamed++
+		}
+		if err != nil && decodeErr != nil {
+			break
+		}
+		body = rest
+	}
+
+	kept := lost[:0]
+	for _, key := range lost {
+		if _, exists := entries[key]; !exists {
+			kept = append(kept, key)
+		}
+	}
+	return entries, kept, unnamed
+}
+
+// addSalvaged keeps the first entry of a key found twice in a damaged file
+func addSalvaged(entries map[string]*Entry, key string, entry *Entry) {
+	if _, exists := entries[key]; !exists {
+		entries[key] = entry
+	}
+}
+
+// validEntry reports whether a salvaged entry is intact: its value matches
+// its content hash, or it has no hash and complete is set
+func (s *Store) validEntry(entry *Entry, complete bool) bool {
+	if entry == nil {
+		return false
+	}
+	if entry.Hash == "" {
+		return complete
+	}
+	value := entry.Value
+	if entry.Source != "" {
+		_, decoded, err := DecodeDocument([]byte(entry.Source))
+		if err != nil {
+			return false
+		}
+		value = s.enrich(decoded)
+	}
+	return ContentHash(value) == entry.Hash
+}
+
+// entryChunks splits a segment into its top-level entries, each starting
+// with a line that is not indented, blank or a comment
+func entryChunks(segment []byte) [][]byte {
+	var chunks [][]byte
+	start := -1
+	for offset := 0; offset < len(segment); {
+		end := bytes.IndexByte(segment[offset:], '\n')
+		if end < 0 {
+			end = len(segment)
+		} else {
+			end += offset + 1
+		}
+		if c := segment[offset]; c != ' ' && c != '\t' && c != '\n' && c != '#' {
+			if start >= 0 {
+				chunks = append(chunks, segment[start:offset])
+			}
+			start = offset
+		}
+		offset = end
+	}
+	if start >= 0 {
+		chunks = append(chunks, segment[start:])
+	}
+	return chunks
+}
+
+// chunkKey reads the key from the first line of a damaged entry
+func chunkKey(chunk []byte) (string, bool) {
+	line, _, _ := bytes.Cut(chunk, []byte("\n"))
+	var m map[string]interface{}
+	if err := NewFastYAMLEncoder().Decode(line, &m); err != nil || len(m) != 1 {
+		return "", false
+	}
+	for key := range m {
+		return key, true
+	}
+	return "", false
+}
+
+// recoveryFallback returns the entries of the newest shadow file or
+// migration backup that decodes in full, and its path
+func (s *Store) recoveryFallback() (string, map[string]*Entry) {
+	candidates, _ := filepath.Glob(s.filepath + ".v*.bak")
+	if s.opts.ShadowPath != "" {
+		candidates = append(candidates, s.opts.ShadowPath)
+	}
+	modified := make(map[string]time.Time, len(candidates))
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil {
+			modified[path] = info.ModTime()
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return modified[candidates[i]].After(modified[candidates[j]])
+	})
+
+	for _, path := range candidates {
+		content, err := os.ReadFile(path)
+		if err != nil || len(content) == 0 {
+			continue
+		}
+		entries, _, err := s.encoder.decodeData(content[:dataEnd(content)])
+		if err != nil {
+			log.Printf("Recovery fallback %s could not be decoded either: %v", path, err)
+			continue
+		}
+		return path, entries
+	}
+	return "", nil
+}
+
+// RecoveryReport describes what was recovered of a damaged data file on
+// startup; Recovered is false when the file loaded normally
+func (s *Store) RecoveryReport() RecoveryReport {
+	s.RLock()
+	defer s.RUnlock()
+	if s.recovery == nil {
+		return RecoveryReport{}
+	}
+	report := *s.recovery
+	return report
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultRedactionMask replaces redacted values when no mask is configured
+const DefaultRedactionMask = "[REDACTED]"
+
+// RedactionPattern is a named regular expression whose matches are masked
+type RedactionPattern struct {
+	Name  string `yaml:"name" json:"name"`
+	Regex string `yaml:"regex" json:"regex"`
+}
+
+// RedactionRules configures which parts of a value are masked
+type RedactionRules struct {
+	Fields   []string           `yaml:"fields" json:"fields"`     // Dotted field paths, e.g. credentials.password
+	Patterns []RedactionPattern `yaml:"patterns" json:"patterns"` // Applied to every string value
+	Mask     string             `yaml:"mask,omitempty" json:"mask,omitempty"`
+}
+
+// Redactor masks secrets in values according to RedactionRules
+type Redactor struct {
+	fields   map[string]struct{}
+	patterns []*regexp.Regexp
+	mask     string
+}
+
+// NewRedactor compiles the given rules
+func NewRedactor(rules RedactionRules) (*Redactor, error) {
+	r := &Redactor{
+		fields: make(map[string]struct{}, len(rules.Fields)),
+		mask:   rules.Mask,
+	}
+	if r.mask == "" {
+		r.mask = DefaultRedactionMask
+	}
+
+	for _, field := range rules.Fields {
+		r.fields[field] = struct{}{}
+	}
+
+	for _, p := range rules.Patterns {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %s: %v", p.Name, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+// Redact returns a copy of value with configured fields and patterns masked.
+// The original value is never modified.
+func (r *Redactor) Redact(value interface{}) interface{} {
+	return r.redact("", value)
+}
+
+func (r *Redactor) redact(path string, value interface{}) interface{} {
+	if path != "" {
+		if _, masked := r.fields[path]; masked {
+			return r.mask
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			out[key] = r.redact(joinPath(path, key), child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, child := range v {
+			out[i] = r.redact(path, child)
+		}
+		return out
+	case string:
+		for _, re := range r.patterns {
+			v = re.ReplaceAllString(v, r.mask)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func joinPath(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return strings.Join([]string{parent, key}, ".")
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DecodeDocument parses a single YAML document into its node tree, which
+// keeps key order, comments, anchors and scalar styles, and into a plain
+// value for indexing
+func DecodeDocument(source []byte) (*yaml.Node, interface{}, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(source, &node); err != nil {
+		return nil, nil, err
+	}
+
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, nil, err
+	}
+	return &node, value, nil
+}
+
+// Node returns the YAML node tree of a round-trip entry, or nil for entries
+// stored without their source
+func (e *Entry) Node() (*yaml.Node, error) {
+	if e.Source == "" {
+		return nil, nil
+	}
+	node, _, err := DecodeDocument([]byte(e.Source))
+	return node, err
+}
+
+// SetYAML stores a YAML document in round-trip mode: the source is kept
+// byte-for-byte and returned unchanged by readers of Entry.Source, while the
+// decoded value is indexed as usual. A ttl of 0 never expires. meta is as
+// for SetWithMetadata.
+func (s *Store) SetYAML(key string, source []byte, ttl time.Duration, meta *Metadata) error {
+	if ttl < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidTTL, ttl)
+	}
+	if err := s.checkKey(key); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	defer func() {
+		s.updateWriteStats(time.Since(start))
+	}()
+
+	_, value, err := DecodeDocument(source)
+	if err != nil {
+		return fmt.Errorf("failed to decode document: %v", err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkQuota(key); err != nil {
+		return err
+	}
+
+	if value, err = s.prepare(value); err != nil {
+		return err
+	}
+	if err := s.enforceLimits(key, value); err != nil {
+		return err
+	}
+	s.setEntry(key, &Entry{
+		Value:     value,
+		Source:    string(source),
+		Timestamp: time.Now().Unix(),
+		TTL:       int64(ttl.Seconds()),
+		Metadata:  s.keepCreator(key, meta),
+	})
+	s.dirty = true
+
+	s.updateIndexes(key, value)
+
+	return nil
+}
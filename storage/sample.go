@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// checkSample rejects sampled queries whose candidates would be biased
+// towards the top scored: those limiting the candidates of each index with
+// max_results, and vector searches, which have no score threshold and
+// return their nearest neighbours only.
+func checkSample(query SearchQuery) error {
+	switch {
+	case query.Sample < 0:
+		return fmt.Errorf("%w: sample must not be negative", ErrInvalidQuery)
+	case query.Sample == 0:
+		return nil
+	case query.MaxResults > 0:
+		return fmt.Errorf("%w: set either sample or max_results", ErrInvalidQuery)
+	case len(query.Vector) > 0:
+		return fmt.Errorf("%w: sample takes text or filters, not a vector", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// SampleResults moves n results chosen uniformly at random to the front, in
+// random order, and returns them; all results if there are no more than n
+func SampleResults(results []SearchResult, n int) []SearchResult {
+	if n >= len(results) {
+		rand.Shuffle(len(results), func(i, j int) { results[i], results[j] = results[j], results[i] })
+		return results
+	}
+	for i := 0; i < n; i++ {
+		j := i + rand.IntN(len(results)-i)
+		results[i], results[j] = results[j], results[i]
+	}
+	return results[:n]
+}
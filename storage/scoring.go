@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScoreInput is a search result as a scorer sees it
+type ScoreInput struct {
+	Key         string
+	Value       interface{} // The document
+	TextScore   float64
+	VectorScore float64
+	Score       float64                // Combined score of the text and vector stages
+	Params      map[string]interface{} // SearchQuery.ScoreParams
+}
+
+// ScoreFunc rescores a search result. Results for which it fails keep the
+// score they had.
+type ScoreFunc func(in ScoreInput) (float64, error)
+
+// CombineFunc merges the text and vector scores of a key matched by both,
+// given SearchQuery.ScoreParams
+type CombineFunc func(text, vector float64, params map[string]interface{}) float64
+
+var (
+	scoringMu sync.RWMutex
+	scorers   = make(map[string]ScoreFunc)
+	combiners = make(map[string]CombineFunc)
+)
+
+// RegisterScorer makes a scorer available to searches naming it in
+// SearchQuery.Scorer. Programs embedding the store, and Go plugins loaded
+// by the server, register theirs from init functions.
+func RegisterScorer(name string, fn ScoreFunc) {
+	scoringMu.Lock()
+	defer scoringMu.Unlock()
+	scorers[name] = fn
+}
+
+// RegisterCombiner makes a combiner available to searches naming it in
+// SearchQuery.Combiner
+func RegisterCombiner(name string, fn CombineFunc) {
+	scoringMu.Lock()
+	defer scoringMu.Unlock()
+	combiners[name] = fn
+}
+
+// Scorers returns the registered scorer names
+func Scorers() []string {
+	scoringMu.RLock()
+	defer scoringMu.RUnlock()
+	return sortedNames(scorers)
+}
+
+// Combiners returns the registered combiner names
+func Combiners() []string {
+	scoringMu.RLock()
+	defer scoringMu.RUnlock()
+	return sortedNames(combiners)
+}
+
+func sortedNames[T any](registry map[string]T) []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// queryScoring holds the scorer and combiner a search query names
+type queryScoring struct {
+	scorer   ScoreFunc
+	combiner CombineFunc
+	params   map[string]interface{}
+}
+
+// lookupScoring returns the scorer and combiner of a query, with the
+// average of the text and vector scores as the default combiner
+func lookupScoring(query SearchQuery) (*queryScoring, error) {
+	scoringMu.RLock()
+	defer scoringMu.RUnlock()
+
+	scoring := &queryScoring{combiner: averageScores, params: query.ScoreParams}
+	if query.Combiner != "" {
+		combiner, exists := combiners[query.Combiner]
+		if !exists {
+			return nil, fmt.Errorf("%w: unknown combiner %q", ErrInvalidQuery, query.Combiner)
+		}
+		scoring.combiner = combiner
+	}
+	if query.Scorer != "" {
+		scorer, exists := scorers[query.Scorer]
+		if !exists {
+			return nil, fmt.Errorf("%w: unknown scorer %q", ErrInvalidQuery, query.Scorer)
+		}
+		scoring.scorer = scorer
+	}
+	return scoring, nil
+}
+
+// apply rescores the results with the scorer, counting those it failed on
+func (qs *queryScoring) apply(results []SearchResult) (failed int) {
+	for i := range results {
+		score, err := qs.scorer(ScoreInput{
+			Key:         results[i].Key,
+			Value:       results[i].Value,
+			TextScore:   results[i].TextScore,
+			VectorScore: float64(results[i].VecScore),
+			Score:       results[i].Combined,
+			Params:      qs.params,
+		})
+		if err != nil || math.IsNaN(score) {
+			failed++
+			continue
+		}
+		results[i].Combined = score
+	}
+	return failed
+}
+
+// The built-in combiners and scorers
+func init() {
+	RegisterCombiner("average", averageScores)
+	RegisterCombiner("max", func(text, vector float64, _ map[string]interface{}) float64 {
+		return math.Max(text, vector)
+	})
+	RegisterCombiner("weighted", weightedScores)
+	RegisterScorer("boost", boostScore)
+	RegisterScorer("decay", decayScore)
+}
+
+func averageScores(text, vector float64, _ map[string]interface{}) float64 {
+	return (text + vector) / 2
+}
+
+// weightedScores weighs the scores with the params text_weight and
+// vector_weight, 1 by default
+func weightedScores(text, vector float64, params map[string]interface{}) float64 {
+	textWeight, vectorWeight := scoreParam(params, "text_weight", 1), scoreParam(params, "vector_weight", 1)
+	if textWeight+vectorWeight == 0 {
+		return 0
+	}
+	return (text*textWeight + vector*vectorWeight) / (textWeight + vectorWeight)
+}
+
+// boostScore multiplies the score by 1 + factor * the number in the field
+// named by the params field and factor, 1 by default, such as a severity
+func boostScore(in ScoreInput) (float64, error) {
+	value, err := scoreField(in)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := numericValue(value)
+	if !ok {
+		return 0, fmt.Errorf("field %v is not a number", in.Params["field"])
+	}
+	return in.Score * (1 + scoreParam(in.Params, "factor", 1)*n), nil
+}
+
+// decayScore halves the score for every half_life, a duration such as
+// "168h", between now and the time in the field named by the params field,
+// so recent documents rank first
+func decayScore(in ScoreInput) (float64, error) {
+	value, err := scoreField(in)
+	if err != nil {
+		return 0, err
+	}
+	halfLife, ok := in.Params["half_life"].(string)
+	if !ok {
+		return 0, errors.New("decay needs a half_life")
+	}
+	period, err := time.ParseDuration(halfLife)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("half_life %q is not a positive duration", halfLife)
+	}
+	var at time.Time
+	switch v := value.(type) {
+	case time.Time:
+		at = v
+	case string:
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("field %v is not a time", in.Params["field"])
+	}
+	age := max(time.Since(at), 0)
+	return in.Score * math.Exp2(-float64(age)/float64(period)), nil
+}
+
+// scoreField returns the value of the document field named by the params
+// field
+func scoreField(in ScoreInput) (interface{}, error) {
+	field, ok := in.Params["field"].(string)
+	if !ok || field == "" {
+		return nil, errors.New("score_params needs a field")
+	}
+	doc, ok := in.Value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("value is not a document")
+	}
+	value, exists := doc[field]
+	if !exists {
+		return nil, fmt.Errorf("document has no field %s", field)
+	}
+	return value, nil
+}
+
+// scoreParam returns a number of the params, or def when it is missing
+func scoreParam(params map[string]interface{}, name string, def float64) float64 {
+	if f, ok := numericValue(params[name]); ok {
+		return f
+	}
+	return def
+}
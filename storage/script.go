@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/threatflux/searchyaml/expr"
+)
+
+// reservedScriptNames are the variables every query expression can reference
+var reservedScriptNames = []string{"key", "value", "score"}
+
+// queryScripts holds the compiled expressions of a search query
+type queryScripts struct {
+	filter *expr.Program
+	names  []string // computed field names in evaluation order
+	fields map[string]*expr.Program
+}
+
+// compileQueryScripts compiles SearchQuery.Expr and SearchQuery.Fields. It
+// returns nil when the query uses neither.
+func compileQueryScripts(query SearchQuery) (*queryScripts, error) {
+	if query.Expr == "" && len(query.Fields) == 0 {
+		return nil, nil
+	}
+
+	scripts := &queryScripts{fields: make(map[string]*expr.Program, len(query.Fields))}
+	for name, source := range query.Fields {
+		if containsString(reservedScriptNames, name) {
+			return nil, fmt.Errorf("%w: computed field name %q is reserved", ErrInvalidQuery, name)
+		}
+		program, err := expr.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("%w: computed field %s: %v", ErrInvalidQuery, name, err)
+		}
+		scripts.names = append(scripts.names, name)
+		scripts.fields[name] = program
+	}
+	sort.Strings(scripts.names)
+
+	if query.Expr != "" {
+		program, err := expr.Compile(query.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		scripts.filter = program
+	}
+
+	return scripts, nil
+}
+
+// apply computes the derived fields of each result and drops results for
+// which the filter expression is false or fails to evaluate. Computed fields
+// are visible to the filter by name; fields that fail to evaluate are null.
+func (qs *queryScripts) apply(results []SearchResult) []SearchResult {
+	filtered := results[:0]
+	for _, result := range results {
+		env := map[string]interface{}{
+			"key":   result.Key,
+			"value": result.Value,
+			"score": result.Combined,
+		}
+
+		if len(qs.names) > 0 {
+			result.Fields = make(map[string]interface{}, len(qs.names))
+			for _, name := range qs.names {
+				v, err := qs.fields[name].Eval(env)
+				if err != nil {
+					v = nil
+				}
+				result.Fields[name] = v
+				env[name] = v
+			}
+		}
+
+		if qs.filter != nil {
+			if ok, err := qs.filter.EvalBool(env); err != nil || !ok {
+				continue
+			}
+		}
+		filtered = append(filtered, result)
+	}
+	return filtered
+}
@@ -1,105 +1,379 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"sort"
+	"time"
 )
 
+// ErrInvalidQuery is returned when a search query cannot be evaluated as given
+var ErrInvalidQuery = errors.New("invalid query")
+
 // SearchQuery represents a combined search query
 type SearchQuery struct {
-	Text       string                 `json:"text,omitempty"`
-	Vector     []float32              `json:"vector,omitempty"`
-	Filters    map[string]interface{} `json:"filters,omitempty"`
-	MaxResults int                    `json:"max_results,omitempty"`
-	MinScore   float64                `json:"min_score,omitempty"`
+	Text        string                 `json:"text,omitempty"`
+	TextFields  []string               `json:"text_fields,omitempty"` // Restrict text search to these indexed fields
+	Vector      []float32              `json:"vector,omitempty"`
+	VectorField string                 `json:"vector_field,omitempty"` // Vector index to search; by default every one of the vector's dimensions
+	Filters     map[string]interface{} `json:"filters,omitempty"`
+	Prefixes    map[string]string      `json:"prefixes,omitempty"` // Field -> prefix of its value; the fields need keyword indexes
+	MaxResults  int                    `json:"max_results,omitempty"`
+	MinScore    float64                `json:"min_score,omitempty"`
+	Expr        string                 `json:"expr,omitempty"`    // Filter candidates, e.g. "value.price * value.qty > 100"
+	Fields      map[string]string      `json:"fields,omitempty"`  // Computed fields: name -> expression
+	Explain     bool                   `json:"explain,omitempty"` // Return a breakdown of the search stages with the results
+	Sample      int                    `json:"sample,omitempty"`  // Return this many matches chosen uniformly at random instead of the top scored
+
+	// Scoring functions registered with RegisterCombiner and RegisterScorer
+	Combiner    string                 `json:"combiner,omitempty"`     // Merges the text and vector scores of keys matched by both, by default "average"
+	Scorer      string                 `json:"scorer,omitempty"`       // Rescores each result with its value
+	ScoreParams map[string]interface{} `json:"score_params,omitempty"` // Passed to the combiner and scorer
+
+	GroupChunks bool `json:"group_chunks,omitempty"` // Return the parents of matching chunks, with the best chunks, see ChunkField
+
+	// Joins on Metadata.Parent, which filter like Filters
+	Parent    string       `json:"parent,omitempty"`     // Only children of this key
+	HasChild  *SearchQuery `json:"has_child,omitempty"`  // Only parents of a document matching this query
+	HasParent *SearchQuery `json:"has_parent,omitempty"` // Only children of a document matching this query
+
+	ReturnValues *bool `json:"return_values,omitempty"` // false returns keys and scores without values
+}
+
+// returnValues reports whether results should carry their values
+func (q SearchQuery) returnValues() bool {
+	return q.ReturnValues == nil || *q.ReturnValues
 }
 
 // SearchResult represents a combined search result
 type SearchResult struct {
-	Key       string      `json:"key"`
-	Value     interface{} `json:"value"`
-	TextScore float64     `json:"text_score,omitempty"`
-	VecScore  float32     `json:"vector_score,omitempty"`
-	Combined  float64     `json:"combined_score"`
+	Key       string                 `json:"key"`
+	Value     interface{}            `json:"value,omitempty"`
+	TextScore float64                `json:"text_score,omitempty"`
+	VecScore  float32                `json:"vector_score,omitempty"`
+	Combined  float64                `json:"combined_score"`
+	Fields    map[string]interface{} `json:"fields,omitempty"` // Computed fields
+	Source    string                 `json:"source,omitempty"` // Federation peer that returned the result
+	Chunks    []ChunkMatch           `json:"chunks,omitempty"` // Best matching chunks, with SearchQuery.GroupChunks
 }
 
-// Search performs a combined search across all indexes
+// Search performs a combined search across all indexes. With
+// StoreOptions.QueryCacheSize repeated queries are answered from the result
+// cache until a write changes their results.
 func (s *Store) Search(query SearchQuery) ([]SearchResult, error) {
+	return s.SearchReadable(query, nil)
+}
+
+// SearchReadable is Search keeping only the keys for which readable returns
+// true, before max_results and sample pick the results, so unreadable
+// matches do not take their place; a nil readable keeps every key. Such
+// searches bypass the result cache.
+func (s *Store) SearchReadable(query SearchQuery, readable func(key string) bool) ([]SearchResult, error) {
+	if s.queryCache != nil && readable == nil {
+		return s.cachedSearch(query)
+	}
+	results, _, err := s.search(query, readable)
+	return results, err
+}
+
+// search runs a query, recording the time and work of each stage
+func (s *Store) search(query SearchQuery, readable func(key string) bool) ([]SearchResult, *queryTrace, error) {
 	s.RLock()
 	defer s.RUnlock()
 
+	return s.searchLocked(query, readable)
+}
+
+// searchLocked runs a query like search. Callers must hold the lock.
+func (s *Store) searchLocked(query SearchQuery, readable func(key string) bool) ([]SearchResult, *queryTrace, error) {
+	scripts, err := compileQueryScripts(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkSample(query); err != nil {
+		return nil, nil, err
+	}
+	scoring, err := lookupScoring(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trace := newQueryTrace()
+	scores, err := s.matchLocked(query, readable, scripts, scoring, trace)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.recordIndexUsage(query, trace)
+
+	// Get values for results. Without scripts to filter the matches, a
+	// sample is drawn first so that only its values are read, and queries
+	// not returning values read none.
+	start := time.Now()
+	combined := make([]SearchResult, 0, len(scores))
+	for key, result := range scores {
+		if _, exists := s.data[key]; exists && (readable == nil || readable(key)) {
+			combined = append(combined, *result)
+		}
+	}
+	if query.Sample > 0 && scripts == nil && scoring.scorer == nil {
+		combined = SampleResults(combined, query.Sample)
+	}
+	if query.returnValues() || scripts != nil || scoring.scorer != nil {
+		for i := range combined {
+			combined[i].Value = s.data[combined[i].Key].hydrate().Value
+		}
+	}
+	trace.record(QueryStage{Stage: "combine"}, start, len(combined))
+
+	// Scripts only run over candidates selected by the indexes
+	if scripts != nil {
+		start = time.Now()
+		combined = scripts.apply(combined)
+		trace.record(QueryStage{Stage: "script"}, start, len(combined))
+	}
+
+	// The scorer sees the values of the results passing the scripts
+	if scoring.scorer != nil {
+		start = time.Now()
+		failed := scoring.apply(combined)
+		trace.record(QueryStage{Stage: "score", Failed: failed}, start, len(combined))
+	}
+	if !query.returnValues() && (scripts != nil || scoring.scorer != nil) {
+		for i := range combined {
+			combined[i].Value = nil
+		}
+	}
+
+	if query.GroupChunks {
+		start = time.Now()
+		combined = s.groupChunksLocked(combined, query.returnValues())
+		if readable != nil {
+			combined = slices.DeleteFunc(combined, func(r SearchResult) bool { return !readable(r.Key) })
+		}
+		trace.record(QueryStage{Stage: "group_chunks"}, start, len(combined))
+	}
+
+	if query.Sample > 0 {
+		start = time.Now()
+		combined = SampleResults(combined, query.Sample)
+		trace.record(QueryStage{Stage: "sample"}, start, len(combined))
+	} else {
+		// Sort and limit results
+		start = time.Now()
+		sortSearchResults(combined)
+		if query.MaxResults > 0 && len(combined) > query.MaxResults {
+			combined = combined[:query.MaxResults]
+		}
+		trace.record(QueryStage{Stage: "sort"}, start, len(combined))
+	}
+
+	s.latency.search.Record(time.Since(trace.start))
+	s.recordSlowQuery(query, trace, len(combined))
+	return combined, trace, nil
+}
+
+// matchLocked runs the index stages of a query and merges their scores by
+// key, without the values. With readable the text and vector stages keep
+// their best readable matches. Callers must hold the lock.
+func (s *Store) matchLocked(query SearchQuery, readable func(key string) bool, scripts *queryScripts, scoring *queryScoring, trace *queryTrace) (map[string]*SearchResult, error) {
+	if scripts != nil && !query.selects() {
+		return nil, fmt.Errorf("%w: expr and fields require text, vector, filters, prefixes or a join to select candidates", ErrInvalidQuery)
+	}
+	if query.VectorField != "" && len(query.Vector) == 0 {
+		return nil, fmt.Errorf("%w: vector_field needs a vector", ErrInvalidQuery)
+	}
+
+	plan, err := s.planQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	trace.plan = plan
+
 	var textResults []TextSearchResult
 	var vectorResults []VectorSearchResult
 	var filterResults []string
+	var within *Bitmap // Matches of the earlier stages restricting the later ones
 
-	// Perform text search if query contains text
-	if query.Text != "" {
-		for _, idx := range s.indexes.text {
-			textResults = append(textResults, idx.FuzzySearch(query.Text, query.MinScore, query.MaxResults)...)
-		}
-	}
+run:
+	for i, step := range plan.Order {
+		last := i == len(plan.Order)-1
+		switch step {
+		case "filter":
+			filterResults, err = s.filterLocked(query, plan, within, trace)
+			if err != nil {
+				return nil, err
+			}
+			if filterResults != nil {
+				if len(filterResults) == 0 && !last {
+					plan.ShortCircuit = step
+					break run
+				}
+				within = s.indexes.ids.bitmapOf(filterResults)
+			}
 
-	// Perform vector search if query contains vector
-	if len(query.Vector) > 0 {
-		for _, idx := range s.indexes.vectors {
-			results, err := idx.Search(query.Vector, query.MaxResults)
+		case "text":
+			if within != nil {
+				plan.Restricted = append(plan.Restricted, step)
+			}
+			for field, idx := range s.indexes.text {
+				if len(query.TextFields) > 0 && !containsString(query.TextFields, field) {
+					continue
+				}
+				stage := QueryStage{Stage: "text", Index: field}
+				start := time.Now()
+				// The filters still to run would cut the text matches, so
+				// the best ones are kept only after them
+				maxResults := query.MaxResults
+				if !last {
+					maxResults = 0
+				} else if query.GroupChunks {
+					maxResults *= chunkFetchFactor
+				}
+				var results []TextSearchResult
+				if readable == nil {
+					results = idx.fuzzySearch(query.Text, query.MinScore, maxResults, within, &stage)
+				} else {
+					results = idx.fuzzySearch(query.Text, query.MinScore, 0, within, &stage)
+					results = slices.DeleteFunc(results, func(r TextSearchResult) bool { return !readable(r.Key) })
+					if maxResults > 0 && len(results) > maxResults {
+						results = results[:maxResults]
+					}
+				}
+				trace.record(stage, start, len(results))
+				textResults = append(textResults, results...)
+			}
+			if len(query.Vector) == 0 && !last {
+				if len(textResults) == 0 {
+					plan.ShortCircuit = step
+					break run
+				}
+				keys := make([]string, len(textResults))
+				for i, r := range textResults {
+					keys[i] = r.Key
+				}
+				within = s.indexes.ids.bitmapOf(keys)
+			}
+
+		case "vector":
+			if filterResults != nil {
+				plan.Restricted = append(plan.Restricted, step)
+			}
+			k := query.MaxResults
+			if query.GroupChunks {
+				k *= chunkFetchFactor
+			}
+			indexes, err := s.indexes.vectorTargets(query)
 			if err != nil {
-				return nil, fmt.Errorf("vector search error: %v", err)
+				return nil, fmt.Errorf("vector search error: %w", err)
+			}
+			for field, idx := range indexes {
+				stage := QueryStage{Stage: "vector", Index: field}
+				start := time.Now()
+				limit := k
+				if readable != nil {
+					limit = math.MaxInt
+				}
+				results, err := idx.search(query.Vector, limit, filterResults, plan.payload, &stage)
+				if err != nil {
+					return nil, fmt.Errorf("vector search error: %w", err)
+				}
+				if readable != nil {
+					results = slices.DeleteFunc(results, func(r VectorSearchResult) bool { return !readable(r.Key) })
+					results = results[:min(k, len(results))]
+				}
+				trace.record(stage, start, len(results))
+				vectorResults = append(vectorResults, results...)
 			}
-			vectorResults = append(vectorResults, results...)
 		}
 	}
 
-	// Apply filters if present
-	if len(query.Filters) > 0 {
-		results, err := s.indexes.Search(query.Filters)
-		if err != nil {
-			return nil, fmt.Errorf("filter search error: %v", err)
+	// Filter-only queries return every key matching the filters
+	if query.Text == "" && len(query.Vector) == 0 {
+		textResults = make([]TextSearchResult, 0, len(filterResults))
+		for _, key := range filterResults {
+			textResults = append(textResults, TextSearchResult{Key: key})
 		}
-		filterResults = results
 	}
 
-	// Combine results
-	combined := s.combineResults(textResults, vectorResults, filterResults)
+	return combineScores(textResults, vectorResults, filterResults, scoring), nil
+}
 
-	// Sort and limit results
-	sortSearchResults(combined)
-	if query.MaxResults > 0 && len(combined) > query.MaxResults {
-		combined = combined[:query.MaxResults]
+// vectorTargets returns the vector indexes a query searches: the index of
+// its VectorField, or else every index holding vectors of the query's
+// dimensions, so documents may carry several embeddings of different
+// models. Callers must hold the lock.
+func (im *IndexManager) vectorTargets(query SearchQuery) (map[string]*VectorIndex, error) {
+	if query.VectorField != "" {
+		idx, exists := im.vectors[query.VectorField]
+		if !exists {
+			return nil, fmt.Errorf("%w: no vector index on %s", ErrInvalidQuery, query.VectorField)
+		}
+		return map[string]*VectorIndex{query.VectorField: idx}, nil
 	}
 
-	return combined, nil
+	targets := make(map[string]*VectorIndex)
+	expected, first := 0, "" // Dimensions of the first index by field, for the error if none matches
+	for field, idx := range im.vectors {
+		switch dim := idx.Dimensions(); dim {
+		case len(query.Vector):
+			targets[field] = idx
+		case 0: // Nothing indexed yet
+		default:
+			if first == "" || field < first {
+				expected, first = dim, field
+			}
+		}
+	}
+	if len(targets) == 0 && expected > 0 {
+		return nil, fmt.Errorf("%w: query expected %d, got %d", ErrDimensionMismatch, expected, len(query.Vector))
+	}
+	return targets, nil
 }
 
-// combineResults merges results from different search types
-func (s *Store) combineResults(text []TextSearchResult, vector []VectorSearchResult, filters []string) []SearchResult {
+// combineScores merges results from different search types by key, with
+// the combiner of scoring for keys matched by text and vector
+func combineScores(text []TextSearchResult, vector []VectorSearchResult, filters []string, scoring *queryScoring) map[string]*SearchResult {
 	scores := make(map[string]*SearchResult)
 
 	// Process text results
 	for _, r := range text {
-		result := &SearchResult{
+		// Keep the best score when several text indexes match the same key
+		if existing, exists := scores[r.Key]; exists && existing.TextScore >= r.Score {
+			continue
+		}
+		scores[r.Key] = &SearchResult{
 			Key:       r.Key,
 			TextScore: r.Score,
+			Combined:  r.Score,
 		}
-		scores[r.Key] = result
 	}
 
-	// Process vector results
+	// Process vector results, keeping the best score of each key across
+	// the vector fields searched before combining it with the text score
+	best := make(map[string]float32, len(vector))
 	for _, r := range vector {
-		if result, exists := scores[r.Key]; exists {
-			result.VecScore = r.Score
-			result.Combined = calculateCombinedScore(result.TextScore, float64(r.Score))
+		if score, exists := best[r.Key]; !exists || r.Score > score {
+			best[r.Key] = r.Score
+		}
+	}
+	for key, score := range best {
+		if result, exists := scores[key]; exists {
+			result.VecScore = score
+			result.Combined = scoring.combiner(result.TextScore, float64(score), scoring.params)
 		} else {
-			scores[r.Key] = &SearchResult{
-				Key:      r.Key,
-				VecScore: r.Score,
-				Combined: float64(r.Score),
+			scores[key] = &SearchResult{
+				Key:      key,
+				VecScore: score,
+				Combined: float64(score),
 			}
 		}
 	}
 
-	// Apply filters
-	if len(filters) > 0 {
+	// Apply filters; nil means no filter applied, empty means nothing matched
+	if filters != nil {
 		filtered := make(map[string]*SearchResult)
 		for _, key := range filters {
 			if result, exists := scores[key]; exists {
@@ -108,24 +382,18 @@ func (s *Store) combineResults(text []TextSearchResult, vector []VectorSearchRes
 		}
 		scores = filtered
 	}
-
-	// Get values for results
-	results := make([]SearchResult, 0, len(scores))
-	for key, result := range scores {
-		if entry, exists := s.data[key]; exists {
-			result.Value = entry.Value
-			results = append(results, *result)
-		}
-	}
-
-	return results
+	return scores
 }
 
 // Helper functions
 
-func calculateCombinedScore(textScore, vectorScore float64) float64 {
-	// Simple weighted average - can be adjusted based on requirements
-	return (textScore + vectorScore) / 2
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
 
 func sortSearchResults(results []SearchResult) {
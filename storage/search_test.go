@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSearchReadableFillsMaxResults checks that unreadable matches ranking
+// first do not take the places of readable ones
+func TestSearchReadableFillsMaxResults(t *testing.T) {
+	store, err := NewStore("", StoreOptions{Persistence: PersistMemory, SyncInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for field, indexType := range map[string]string{"notes": "text", "embedding": "vector", "kind": "keyword"} {
+		if err := store.CreateIndex(field, indexType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The secrets sort first by key and match the queries best
+	docs := map[string]interface{}{
+		"a/secret1": map[string]interface{}{"kind": "host", "notes": "alpha beta", "embedding": []interface{}{1.0, 0.0}},
+		"a/secret2": map[string]interface{}{"kind": "host", "notes": "alpha beta", "embedding": []interface{}{1.0, 0.01}},
+		"a/secret3": map[string]interface{}{"kind": "host", "notes": "alpha beta", "embedding": []interface{}{1.0, 0.02}},
+		"z/host1":   map[string]interface{}{"kind": "host", "notes": "alpha", "embedding": []interface{}{1.0, 0.5}},
+		"z/host2":   map[string]interface{}{"kind": "host", "notes": "alpha gamma", "embedding": []interface{}{1.0, 0.6}},
+		"z/host3":   map[string]interface{}{"kind": "host", "notes": "alpha delta", "embedding": []interface{}{0.0, 1.0}},
+	}
+	for key, doc := range docs {
+		if err := store.Set(key, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readable := func(key string) bool { return strings.HasPrefix(key, "z/") }
+
+	tests := []struct {
+		name   string
+		query  SearchQuery
+		ranked bool // Whether the secrets rank first
+	}{
+		{"text", SearchQuery{Text: "alpha beta", MaxResults: 2}, true},
+		{"text and filter", SearchQuery{Text: "alpha beta", Filters: map[string]interface{}{"kind": "host"}, MaxResults: 2}, true},
+		{"filter", SearchQuery{Filters: map[string]interface{}{"kind": "host"}, MaxResults: 2}, false},
+		{"vector", SearchQuery{Vector: []float32{1, 0}, MaxResults: 2}, true},
+		{"grouped", SearchQuery{Text: "alpha beta", MaxResults: 2, GroupChunks: true}, true},
+	}
+	for _, tt := range tests {
+		// Cache the unfiltered results first, which must not be reused
+		all, err := store.Search(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.ranked && (len(all) != 2 || readable(all[0].Key) || readable(all[1].Key)) {
+			t.Fatalf("%s: Search returns %v, want the secrets first", tt.name, resultKeys(all))
+		}
+
+		results, err := store.SearchReadable(tt.query, readable)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if keys := resultKeys(results); len(keys) != 2 || !readable(keys[0]) || !readable(keys[1]) {
+			t.Errorf("%s: SearchReadable returns %v, want 2 readable results", tt.name, keys)
+		}
+
+		results, _, err = store.Explain(tt.query, readable)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if keys := resultKeys(results); len(keys) != 2 || !readable(keys[0]) || !readable(keys[1]) {
+			t.Errorf("%s: Explain returns %v, want 2 readable results", tt.name, keys)
+		}
+	}
+
+	count, err := store.Count(SearchQuery{Text: "alpha beta", MaxResults: 2}, readable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Count of the readable text matches = %d, want 2", count)
+	}
+}
+
+func resultKeys(results []SearchResult) []string {
+	keys := make([]string, len(results))
+	for i, r := range results {
+		keys[i] = r.Key
+	}
+	return keys
+}
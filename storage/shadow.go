@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// shadowSuffix is appended to the shadow path while a mirror is written
+const shadowSuffix = ".tmp"
+
+// checkShadowPath rejects a shadow path that is the data file itself
+func checkShadowPath(path, shadow string) error {
+	if shadow == "" {
+		return nil
+	}
+	dataPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	shadowPath, err := filepath.Abs(shadow)
+	if err != nil {
+		return err
+	}
+	if dataPath == shadowPath {
+		return fmt.Errorf("shadow path %s is the data file", shadow)
+	}
+	return nil
+}
+
+// syncShadow mirrors the data just synced, and the view definitions, to
+// StoreOptions.ShadowPath. The mirror is written to a temporary file and
+// renamed over the previous one, so a standby started from the shadow path
+// always finds a complete data file. A failed mirror does not fail the
+// sync; it is logged, counted, and retried on the next sync even if the
+// data has not changed since. Callers must hold the lock.
+func (s *Store) syncShadow() {
+	if s.opts.ShadowPath == "" {
+		return
+	}
+	content, err := s.persist.read()
+	if err == nil {
+		err = writeShadow(s.opts.ShadowPath, content)
+	}
+	if err == nil {
+		err = s.shadowViews()
+	}
+	if err != nil {
+		s.shadowStale = true
+		s.counters.shadowFailures.Add(1)
+		log.Printf("Failed to mirror data file to %s: %v", s.opts.ShadowPath, err)
+		return
+	}
+	s.shadowStale = false
+	s.counters.shadowSyncs.Add(1)
+	s.counters.lastShadowSync.Store(time.Now().UnixNano())
+}
+
+// shadowViews mirrors the view definitions next to the shadow data file, or
+// removes a mirrored copy once the store has none
+func (s *Store) shadowViews() error {
+	path := s.opts.ShadowPath + viewsSuffix
+	raw, err := os.ReadFile(s.viewsPath())
+	if os.IsNotExist(err) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return writeShadow(path, raw)
+}
+
+// writeShadow replaces the file at path with content, flushed to disk
+// before the rename so a crash leaves either the old or the new mirror
+func writeShadow(path string, content []byte) error {
+	tmp := path + shadowSuffix
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create mirror: %v", err)
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write mirror: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to flush mirror: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write mirror: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace mirror: %v", err)
+	}
+	// Make the rename itself durable; not every platform or network file
+	// system can sync a directory, so this is best effort
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
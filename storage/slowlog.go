@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultSlowLogSize is the number of slow queries kept when
+// StoreOptions.SlowLogSize is not set
+const DefaultSlowLogSize = 128
+
+// SlowQuery is a search that took longer than StoreOptions.SlowQueryThreshold.
+// The query vector is replaced by its dimension count.
+type SlowQuery struct {
+	Time       time.Time    `json:"time" yaml:"time"`
+	Query      SearchQuery  `json:"query" yaml:"query"`
+	VectorDims int          `json:"vector_dims,omitempty" yaml:"vector_dims,omitempty"`
+	Duration   float64      `json:"duration" yaml:"duration"` // in milliseconds
+	Stages     []QueryStage `json:"stages" yaml:"stages"`
+	Results    int          `json:"results" yaml:"results"`
+}
+
+// slowLog is a fixed-size ring of the most recent slow queries
+type slowLog struct {
+	sync.Mutex
+	entries []SlowQuery
+	next    int
+	full    bool
+}
+
+func (l *slowLog) add(q SlowQuery, size int) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.entries == nil {
+		l.entries = make([]SlowQuery, size)
+	}
+	l.entries[l.next] = q
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// recordSlowQuery adds a finished search to the slow query log when it took
+// at least the configured threshold
+func (s *Store) recordSlowQuery(query SearchQuery, trace *queryTrace, results int) {
+	elapsed := time.Since(trace.start)
+	if s.opts.SlowQueryThreshold <= 0 || elapsed < s.opts.SlowQueryThreshold {
+		return
+	}
+
+	q := SlowQuery{
+		Time:       trace.start,
+		Query:      query,
+		VectorDims: len(query.Vector),
+		Duration:   milliseconds(elapsed),
+		Stages:     trace.stages,
+		Results:    results,
+	}
+	q.Query.Vector = nil
+
+	size := s.opts.SlowLogSize
+	if size <= 0 {
+		size = DefaultSlowLogSize
+	}
+	s.slowlog.add(q, size)
+
+	if s.opts.SlowLogToLog {
+		if line, err := json.Marshal(q); err == nil {
+			log.Printf("slow query: %s", line)
+		}
+	}
+}
+
+// SlowQueries returns the logged slow queries, newest first
+func (s *Store) SlowQueries() []SlowQuery {
+	l := &s.slowlog
+	l.Lock()
+	defer l.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+
+	out := make([]SlowQuery, 0, count)
+	for i := 1; i <= count; i++ {
+		out = append(out, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return out
+}
+
+// ResetSlowLog clears the slow query log
+func (s *Store) ResetSlowLog() {
+	l := &s.slowlog
+	l.Lock()
+	defer l.Unlock()
+
+	l.entries = nil
+	l.next = 0
+	l.full = false
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// Index builds index existing entries in batches, releasing the store lock
+// between batches so writes are not blocked for the whole build
+const (
+	indexBuildBatch    = 1000
+	indexBuildLogEvery = 100000
+)
+
+// StartupStatus reports progress loading the data file and building indexes
+type StartupStatus struct {
+	LoadedBytes    int64        `json:"loaded_bytes" yaml:"loaded_bytes"`
+	LoadedEntries  int          `json:"loaded_entries" yaml:"loaded_entries"`
+	LoadDuration   float64      `json:"load_duration" yaml:"load_duration"` // in milliseconds
+	PendingIndexes []IndexBuild `json:"pending_indexes" yaml:"pending_indexes"`
+}
+
+// IndexBuild is the progress of an index being built from existing entries
+type IndexBuild struct {
+	Job     string    `json:"job" yaml:"job"` // ID of the job building the index
+	Field   string    `json:"field" yaml:"field"`
+	Type    string    `json:"type" yaml:"type"`
+	Indexed int       `json:"indexed" yaml:"indexed"`
+	Total   int       `json:"total" yaml:"total"`
+	Started time.Time `json:"started" yaml:"started"`
+}
+
+// Ready reports whether every index has been built
+func (s StartupStatus) Ready() bool {
+	return len(s.PendingIndexes) == 0
+}
+
+// Startup returns the load statistics and the index builds in progress
+func (s *Store) Startup() StartupStatus {
+	s.startupMu.Lock()
+	defer s.startupMu.Unlock()
+
+	status := s.startup
+	status.PendingIndexes = make([]IndexBuild, 0, len(s.builds))
+	for build := range s.builds {
+		status.PendingIndexes = append(status.PendingIndexes, *build)
+	}
+	sort.Slice(status.PendingIndexes, func(i, j int) bool {
+		return status.PendingIndexes[i].Started.Before(status.PendingIndexes[j].Started)
+	})
+	return status
+}
+
+// CreateIndex creates a new index of the specified type and indexes the
+// existing entries. With LazyIndexes the existing entries are indexed in the
+// background and searches see partial results until Startup reports ready.
+func (s *Store) CreateIndex(field string, indexType string) error {
+	return s.CreateIndexWithOptions(field, indexType, IndexOptions{})
+}
+
+// CreateIndexWithOptions is CreateIndex with index options, such as the
+// tokenizer of a text index. The build is listed among the jobs but not
+// written to the jobs file, which the indexes created on every start would
+// otherwise fill.
+func (s *Store) CreateIndexWithOptions(field string, indexType string, opts IndexOptions) error {
+	build, run, err := s.addIndex(field, indexType, opts, true)
+	if err != nil || build == nil {
+		return err
+	}
+	if s.opts.LazyIndexes {
+		go s.jobs.run(run, s.indexBuildJob(build))
+	} else {
+		s.jobs.run(run, s.indexBuildJob(build))
+	}
+	return nil
+}
+
+// BuildIndex creates an index like CreateIndexWithOptions but always
+// indexes the existing entries in the background, returning the job to
+// follow with Job and stop with CancelJob. It returns nil if the index
+// exists already.
+func (s *Store) BuildIndex(field string, indexType string, opts IndexOptions) (*Job, error) {
+	build, run, err := s.addIndex(field, indexType, opts, false)
+	if err != nil || build == nil {
+		return nil, err
+	}
+	job := run.job
+	go s.jobs.run(run, s.indexBuildJob(build))
+	return &job, nil
+}
+
+// addIndex adds an empty index and starts the job building it, or returns
+// nil if the index exists already
+func (s *Store) addIndex(field string, indexType string, opts IndexOptions, transient bool) (*IndexBuild, *jobRun, error) {
+	created, err := s.indexes.addIndex(field, indexType, opts)
+	if err != nil || !created {
+		return nil, nil, err
+	}
+
+	run := s.jobs.start(JobIndexBuild, map[string]string{"field": field, "type": indexType}, transient)
+	build := &IndexBuild{Job: run.job.ID, Field: field, Type: indexType, Started: run.job.Started}
+	s.startupMu.Lock()
+	if s.builds == nil {
+		s.builds = make(map[*IndexBuild]struct{})
+	}
+	s.builds[build] = struct{}{}
+	s.startupMu.Unlock()
+	s.queryCache.clear()
+	s.invalidateIndexMemory()
+	s.usage.reset(indexRef{field, indexType})
+	return build, run, nil
+}
+
+// indexBuildJob returns the job building an index
+func (s *Store) indexBuildJob(build *IndexBuild) JobFunc {
+	return func(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+		return nil, s.buildIndex(ctx, build, progress)
+	}
+}
+
+// buildIndex adds the existing entries to a newly created index. Each entry
+// is read under the store lock, so writes made during the build, which
+// update the index themselves, are never overwritten with stale values.
+// Cancelling ctx stops the build after its current batch and removes the
+// partly built index.
+func (s *Store) buildIndex(ctx context.Context, build *IndexBuild, progress func(done, total int)) error {
+	s.RLock()
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		keys = append(keys, key)
+	}
+	s.RUnlock()
+
+	s.startupMu.Lock()
+	build.Total = len(keys)
+	s.startupMu.Unlock()
+	progress(0, len(keys))
+
+	for start := 0; start < len(keys) && ctx.Err() == nil; start += indexBuildBatch {
+		end := min(start+indexBuildBatch, len(keys))
+
+		var errs []IndexError
+		s.RLock()
+		for _, key := range keys[start:end] {
+			entry, exists := s.data[key]
+			if !exists {
+				continue
+			}
+			value := entry.hydrate().Value
+			if build.Type == "vector" {
+				// Vectors from the log were normalized by the last run
+				doc, _ := value.(map[string]interface{})
+				if vector, ok := s.vectorLog.takeSaved(build.Field, key); ok && s.indexes.restoreVector(build.Field, key, vector, doc) {
+					continue
+				}
+			}
+			if err := s.indexes.UpdateIndex(build.Field, build.Type, key, value); err != nil {
+				errs = append(errs, IndexError{Time: time.Now(), Key: key, Field: build.Field, Type: build.Type, Error: err.Error()})
+			}
+		}
+		s.RUnlock()
+		s.indexErrors.add(errs)
+
+		s.startupMu.Lock()
+		build.Indexed = end
+		s.startupMu.Unlock()
+		progress(end, len(keys))
+
+		if end%indexBuildLogEvery == 0 {
+			log.Printf("Building %s index on %s: %d/%d entries", build.Type, build.Field, end, len(keys))
+		}
+	}
+
+	if build.Type == "vector" {
+		s.vectorLog.release(build.Field)
+	}
+	err := ctx.Err()
+	if err != nil {
+		// A partial index would answer searches with partial results
+		if err := s.RemoveIndex(build.Field, build.Type); err != nil {
+			log.Printf("Failed to remove cancelled %s index on %s: %v", build.Type, build.Field, err)
+		}
+		log.Printf("Cancelled build of %s index on %s after %d/%d entries", build.Type, build.Field, build.Indexed, len(keys))
+	} else if len(keys) >= indexBuildLogEvery {
+		log.Printf("Built %s index on %s: %d entries in %v", build.Type, build.Field, len(keys), time.Since(build.Started))
+	}
+
+	s.startupMu.Lock()
+	delete(s.builds, build)
+	s.startupMu.Unlock()
+	s.queryCache.clear()
+	return err
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// storeCounters are the statistics operations update. They are atomics, so
+// reads and writes share no lock to count themselves; the most frequently
+// updated sit on cache lines of their own.
+type storeCounters struct {
+	reads  paddedCounter
+	writes paddedCounter
+
+	deletes         atomic.Uint64
+	touches         atomic.Uint64
+	syncs           atomic.Uint64
+	expired         atomic.Uint64 // Removed by GC sweeps
+	lazyExpirations atomic.Uint64
+	sliding         atomic.Int64 // Entries with a sliding TTL
+	compressed      atomic.Int64 // Entries held compressed
+	dataSize        atomic.Int64
+	fileSize        atomic.Int64
+	lastSync        atomic.Int64 // Unix nanoseconds, 0 before the first sync
+	lastGC          atomic.Int64 // Unix nanoseconds, 0 before the first sweep
+	shadowSyncs     atomic.Uint64
+	shadowFailures  atomic.Uint64
+	lastShadowSync  atomic.Int64 // Unix nanoseconds, 0 before the first mirror
+	evictions       atomic.Uint64
+}
+
+// paddedCounter is a counter filling a cache line, so that updating it does
+// not invalidate its neighbours in other cores' caches
+type paddedCounter struct {
+	atomic.Uint64
+	_ [56]byte
+}
+
+// unixNanoTime converts a time stored as Unix nanoseconds, 0 being the zero
+// time
+func unixNanoTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// GetStats returns a snapshot of the store statistics. Counters are read
+// one at a time, so a snapshot taken during writes may be off by the
+// operations in flight.
+func (s *Store) GetStats() StoreStats {
+	stats := StoreStats{
+		Reads:        s.counters.reads.Load(),
+		Writes:       s.counters.writes.Load(),
+		Deletes:      s.counters.deletes.Load(),
+		Evictions:    s.counters.evictions.Load(),
+		Touches:      s.counters.touches.Load(),
+		SyncCount:    s.counters.syncs.Load(),
+		LastSyncTime: unixNanoTime(s.counters.lastSync.Load()),
+
+		ShadowSyncs:    s.counters.shadowSyncs.Load(),
+		ShadowFailures: s.counters.shadowFailures.Load(),
+		LastShadowSync: unixNanoTime(s.counters.lastShadowSync.Load()),
+
+		DataSize:          s.counters.dataSize.Load(),
+		FileSize:          s.counters.fileSize.Load(),
+		ExpiredCount:      s.counters.expired.Load(),
+		SlidingEntries:    uint64(max(s.counters.sliding.Load(), 0)),
+		LazyExpirations:   s.counters.lazyExpirations.Load(),
+		CompressedEntries: uint64(max(s.counters.compressed.Load(), 0)),
+
+		IndexErrors: s.IndexErrors().Total,
+		Conflicts:   s.Conflicts().Total,
+	}
+
+	s.RLock()
+	stats.EntryCount = uint64(len(s.data))
+	stats.FormatVersion = s.format
+	s.RUnlock()
+
+	im := s.indexes
+	im.RLock()
+	stats.IndexStats.TextIndexes.Count = len(im.text)
+	stats.IndexStats.VectorIndexes.Count = len(im.vectors)
+	stats.IndexStats.BTreeIndexes.Count = len(im.trees)
+	stats.IndexStats.IPIndexes.Count = len(im.ips)
+	stats.IndexStats.KeywordIndexes.Count = len(im.keywords)
+	stats.IndexStats.NumericIndexes.Count = len(im.numeric)
+	for _, idx := range im.text {
+		stats.IndexStats.TextIndexes.EntryCount += idx.Len()
+	}
+	for _, idx := range im.vectors {
+		stats.IndexStats.VectorIndexes.EntryCount += idx.Len()
+	}
+	for _, tree := range im.trees {
+		stats.IndexStats.BTreeIndexes.EntryCount += tree.Len()
+	}
+	for _, idx := range im.ips {
+		stats.IndexStats.IPIndexes.EntryCount += idx.Len()
+	}
+	for _, idx := range im.keywords {
+		stats.IndexStats.KeywordIndexes.EntryCount += idx.Len()
+	}
+	for _, idx := range im.numeric {
+		stats.IndexStats.NumericIndexes.EntryCount += idx.Len()
+	}
+	im.RUnlock()
+	stats.QueryCache = s.queryCache.stats()
+
+	stats.PerformanceStats.Read = s.latency.read.Stats()
+	stats.PerformanceStats.Write = s.latency.write.Stats()
+	stats.PerformanceStats.Search = s.latency.search.Stats()
+	stats.PerformanceStats.Sync = s.latency.sync.Stats()
+	stats.PerformanceStats.LastGC = unixNanoTime(s.counters.lastGC.Load())
+	return stats
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultStatsRetention is how long stats samples are kept when
+// StoreOptions.StatsRetention is not set
+const DefaultStatsRetention = 24 * time.Hour
+
+// maxStatsSamples bounds the samples kept, whatever the resolution and
+// retention
+const maxStatsSamples = 100000
+
+// StatsSample is a snapshot of the store statistics taken every
+// StoreOptions.StatsInterval. Counters are totals since startup or the last
+// ResetStats; rates and latencies cover the interval since the previous
+// sample.
+type StatsSample struct {
+	Time time.Time `json:"time" yaml:"time"`
+
+	EntryCount  uint64 `json:"entry_count" yaml:"entry_count"`
+	DataSize    int64  `json:"data_size" yaml:"data_size"`
+	FileSize    int64  `json:"file_size" yaml:"file_size"`
+	IndexErrors uint64 `json:"index_errors" yaml:"index_errors"`
+	Conflicts   uint64 `json:"conflicts" yaml:"conflicts"`
+
+	Reads    uint64 `json:"reads" yaml:"reads"`
+	Writes   uint64 `json:"writes" yaml:"writes"`
+	Deletes  uint64 `json:"deletes" yaml:"deletes"`
+	Searches uint64 `json:"searches" yaml:"searches"`
+	Syncs    uint64 `json:"syncs" yaml:"syncs"`
+
+	ReadRate   float64 `json:"read_rate" yaml:"read_rate"` // Per second
+	WriteRate  float64 `json:"write_rate" yaml:"write_rate"`
+	SearchRate float64 `json:"search_rate" yaml:"search_rate"`
+
+	ReadLatency   float64 `json:"read_latency_ms" yaml:"read_latency_ms"` // Mean
+	WriteLatency  float64 `json:"write_latency_ms" yaml:"write_latency_ms"`
+	SearchLatency float64 `json:"search_latency_ms" yaml:"search_latency_ms"`
+	SyncLatency   float64 `json:"sync_latency_ms" yaml:"sync_latency_ms"`
+}
+
+// StatsHistory is the result of Store.StatsHistory
+type StatsHistory struct {
+	Interval  string        `json:"interval" yaml:"interval"`
+	Retention string        `json:"retention" yaml:"retention"`
+	Samples   []StatsSample `json:"samples" yaml:"samples"` // Oldest first
+}
+
+// statsHistory is a fixed-size ring of the most recent stats samples
+type statsHistory struct {
+	sync.Mutex
+	samples []StatsSample
+	next    int
+	full    bool
+	last    StoreStats // Of the previous sample, for rates and latencies
+}
+
+func newStatsHistory(interval, retention time.Duration) *statsHistory {
+	if interval <= 0 {
+		return nil
+	}
+	if retention <= 0 {
+		retention = DefaultStatsRetention
+	}
+	size := min(max(int(retention/interval), 1), maxStatsSamples)
+	return &statsHistory{samples: make([]StatsSample, size)}
+}
+
+// add records a sample of stats taken at now
+func (h *statsHistory) add(now time.Time, stats StoreStats) {
+	h.Lock()
+	defer h.Unlock()
+
+	sample := StatsSample{
+		Time:        now,
+		EntryCount:  stats.EntryCount,
+		DataSize:    stats.DataSize,
+		FileSize:    stats.FileSize,
+		IndexErrors: stats.IndexErrors,
+		Conflicts:   stats.Conflicts,
+		Reads:       stats.Reads,
+		Writes:      stats.Writes,
+		Deletes:     stats.Deletes,
+		Searches:    stats.PerformanceStats.Search.Count,
+		Syncs:       stats.SyncCount,
+	}
+	if h.next > 0 || h.full {
+		previous := h.samples[(h.next-1+len(h.samples))%len(h.samples)]
+		seconds := now.Sub(previous.Time).Seconds()
+		last := h.last.PerformanceStats
+		current := stats.PerformanceStats
+		sample.ReadRate = rate(sample.Reads, previous.Reads, seconds)
+		sample.WriteRate = rate(sample.Writes, previous.Writes, seconds)
+		sample.SearchRate = rate(sample.Searches, previous.Searches, seconds)
+		sample.ReadLatency = intervalMean(current.Read, last.Read)
+		sample.WriteLatency = intervalMean(current.Write, last.Write)
+		sample.SearchLatency = intervalMean(current.Search, last.Search)
+		sample.SyncLatency = intervalMean(current.Sync, last.Sync)
+	}
+	h.last = stats
+
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// since returns the samples taken at or after start, oldest first
+func (h *statsHistory) since(start time.Time) []StatsSample {
+	h.Lock()
+	defer h.Unlock()
+
+	count := h.next
+	first := 0
+	if h.full {
+		count = len(h.samples)
+		first = h.next
+	}
+	out := []StatsSample{}
+	for i := 0; i < count; i++ {
+		sample := h.samples[(first+i)%len(h.samples)]
+		if !sample.Time.Before(start) {
+			out = append(out, sample)
+		}
+	}
+	return out
+}
+
+// rate is the change of a counter per second. A counter lower than before
+// was reset, so all of it counts.
+func rate(current, previous uint64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	if current < previous {
+		previous = 0
+	}
+	return float64(current-previous) / seconds
+}
+
+// intervalMean is the mean latency of the operations between two latency
+// snapshots
+func intervalMean(current, previous LatencyStats) float64 {
+	if current.Count < previous.Count {
+		previous = LatencyStats{}
+	}
+	if current.Count == previous.Count {
+		return 0
+	}
+	return (current.Sum - previous.Sum) / float64(current.Count-previous.Count)
+}
+
+// periodicStats samples the store statistics every interval
+func (s *Store) periodicStats(interval time.Duration) {
+	s.every(interval, func(now time.Time) {
+		s.history.add(now, s.GetStats())
+	})
+}
+
+// StatsHistory returns the stats samples of the last window, or all those
+// kept when window is 0. It returns nil when StoreOptions.StatsInterval is
+// not set.
+func (s *Store) StatsHistory(window time.Duration) *StatsHistory {
+	if s.history == nil {
+		return nil
+	}
+	start := time.Time{}
+	if window > 0 {
+		start = time.Now().Add(-window)
+	}
+	retention := s.opts.StatsRetention
+	if retention <= 0 {
+		retention = DefaultStatsRetention
+	}
+	return &StatsHistory{
+		Interval:  s.opts.StatsInterval.String(),
+		Retention: retention.String(),
+		Samples:   s.history.since(start),
+	}
+}
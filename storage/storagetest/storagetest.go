@@ -0,0 +1,228 @@
+// Package storagetest helps test code that embeds a storage.Store without
+// touching the filesystem: in-memory stores closed with the test, fixtures
+// and checks of the invariants the store keeps
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/threatflux/searchyaml/seed"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// maxViolations is the number of violations Check reports
+const maxViolations = 20
+
+// NewStore returns an in-memory store that is closed when the test ends
+func NewStore(t testing.TB) *storage.Store {
+	t.Helper()
+	return NewStoreWithOptions(t, storage.StoreOptions{})
+}
+
+// NewStoreWithOptions returns an in-memory store with opts that is closed
+// when the test ends. The persistence is always storage.PersistMemory.
+func NewStoreWithOptions(t testing.TB, opts storage.StoreOptions) *storage.Store {
+	t.Helper()
+	opts.Persistence = storage.PersistMemory
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = time.Minute
+	}
+	store, err := storage.NewStore("", opts)
+	if err != nil {
+		t.Fatalf("storagetest: creating store: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := store.Close(); err != nil {
+			t.Errorf("storagetest: closing store: %v", err)
+		}
+	})
+	return store
+}
+
+// Load writes docs to store by key, failing the test on the first error
+func Load(t testing.TB, store *storage.Store, docs map[string]interface{}) {
+	t.Helper()
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // Writes in a stable order, for stores with limits
+	for _, key := range keys {
+		if err := store.Set(key, docs[key]); err != nil {
+			t.Fatalf("storagetest: writing %s: %v", key, err)
+		}
+	}
+}
+
+// LoadYAML writes the documents of a YAML mapping of keys to documents,
+// such as a fixture kept next to the test:
+//
+//	storagetest.LoadYAML(t, store, `
+//	host-1: {name: web, ip: 10.0.0.1}
+//	host-2: {name: db, ip: 10.0.0.2}
+//	`)
+func LoadYAML(t testing.TB, store *storage.Store, source string) {
+	t.Helper()
+	var docs map[string]interface{}
+	if err := yaml.Unmarshal([]byte(source), &docs); err != nil {
+		t.Fatalf("storagetest: parsing fixture: %v", err)
+	}
+	Load(t, store, docs)
+}
+
+// Seed writes n documents of seed.DefaultSchema, threat reports keyed
+// seed-1 to seed-n, and returns their keys. The same randSeed writes the
+// same documents, apart from their timestamps.
+func Seed(t testing.TB, store *storage.Store, n int, randSeed int64) []string {
+	t.Helper()
+	gen := seed.NewGenerator(&seed.DefaultSchema, randSeed)
+	keys := make([]string, n)
+	values := make([]storage.KeyValue, n)
+	for i := range values {
+		keys[i] = gen.Key(i + 1)
+		values[i] = storage.KeyValue{Key: keys[i], Value: gen.Document()}
+	}
+	if _, err := store.SetMany(values); err != nil {
+		t.Fatalf("storagetest: seeding: %v", err)
+	}
+	return keys
+}
+
+// CheckInvariants fails the test with the violations Check finds
+func CheckInvariants(t testing.TB, store *storage.Store) {
+	t.Helper()
+	if err := Check(store); err != nil {
+		t.Error(err)
+	}
+}
+
+// Check verifies the invariants the store keeps between its entries and
+// indexes, and returns the first violations found:
+//
+//   - every value still matches the content hash stored with it
+//   - every live entry is returned by Get, with the same value, and listed
+//     by Keys, which lists nothing else
+//   - every entry is found by a filter on each of its fields with a
+//     keyword, btree or numeric index
+//
+// Check searches once per indexed field of every entry, so it suits the
+// small stores of tests rather than production data. Entries must not be
+// written while it runs.
+func Check(store *storage.Store) error {
+	var violations []string
+	report := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	for _, m := range store.Verify().Mismatches {
+		report("%s: stored hash %s, value hashes to %s", m.Key, m.Stored, m.Computed)
+	}
+
+	values := make(map[string]interface{})
+	store.Range(func(key string, entry *storage.Entry) bool {
+		values[key] = entry.Value
+		return true
+	})
+	ranged := make([]string, 0, len(values))
+	for key, value := range values {
+		ranged = append(ranged, key)
+		entry, exists := store.Get(key)
+		switch {
+		case !exists:
+			report("%s: ranged over but not found by Get", key)
+		case storage.ContentHash(entry.Value) != storage.ContentHash(value):
+			report("%s: Get returns another value than Range", key)
+		}
+	}
+	sort.Strings(ranged)
+	listed, _ := store.Keys("", "", 0)
+	if missing, extra := difference(ranged, listed), difference(listed, ranged); len(missing) > 0 || len(extra) > 0 {
+		report("Keys lists %d keys, of which %d are not entries (%s), and misses %d entries (%s)",
+			len(listed), len(extra), strings.Join(first(extra), ", "), len(missing), strings.Join(first(missing), ", "))
+	}
+
+	noValues := false
+	for _, idx := range store.MemoryUsage().Indexes {
+		if idx.Type != "keyword" && idx.Type != "btree" && idx.Type != "numeric" {
+			continue
+		}
+		for _, key := range ranged {
+			if len(violations) >= maxViolations {
+				break
+			}
+			value, ok := lookup(values[key], idx.Field)
+			if !ok {
+				continue
+			}
+			results, err := store.Search(storage.SearchQuery{
+				Filters:      map[string]interface{}{idx.Field: value},
+				MaxResults:   len(ranged),
+				ReturnValues: &noValues,
+			})
+			if err != nil {
+				report("%s: filter on %s index %s = %v failed: %v", key, idx.Type, idx.Field, value, err)
+				continue
+			}
+			if !containsKey(results, key) {
+				report("%s: not found by a filter on %s index %s = %v", key, idx.Type, idx.Field, value)
+			}
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	if len(violations) > maxViolations {
+		violations = violations[:maxViolations]
+	}
+	return errors.New("storagetest: invariants violated:\n\t" + strings.Join(violations, "\n\t"))
+}
+
+// lookup returns the scalar at a dotted field path of a document
+func lookup(doc interface{}, field string) (interface{}, bool) {
+	for _, part := range strings.Split(field, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	switch doc.(type) {
+	case string, bool, int, int64, float64:
+		return doc, true
+	}
+	return nil, false
+}
+
+func containsKey(results []storage.SearchResult, key string) bool {
+	for _, r := range results {
+		if r.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// difference returns the keys of sorted a missing from sorted b
+func difference(a, b []string) []string {
+	var missing []string
+	for _, key := range a {
+		if i := sort.SearchStrings(b, key); i == len(b) || b[i] != key {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// first returns the first few keys, for messages
+func first(keys []string) []string {
+	return keys[:min(len(keys), 5)]
+}
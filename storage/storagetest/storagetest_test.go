@@ -0,0 +1,95 @@
+package storagetest
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// TestCloseStopsGoroutines closes stores running every background worker
+// and checks none of their goroutines outlive them
+func TestCloseStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	t.Run("stores", func(t *testing.T) {
+		for range 3 {
+			store := NewStoreWithOptions(t, storage.StoreOptions{
+				SyncInterval:  time.Millisecond,
+				GCInterval:    time.Millisecond,
+				StatsInterval: time.Millisecond,
+			})
+			LoadYAML(t, store, `
+host-1: {name: web, ip: 10.0.0.1}
+host-2: {name: db, ip: 10.0.0.2}
+`)
+		}
+		mem := storage.NewMemStore()
+		if err := mem.Set("host-1", map[string]interface{}{"name": "web"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := mem.Close(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // Lets the workers tick
+	})
+
+	// Goroutines of the test framework may take a moment to exit
+	after := runtime.NumGoroutine()
+	for deadline := time.Now().Add(time.Second); after > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+	if after > before {
+		buf := make([]byte, 1<<16)
+		t.Fatalf("%d goroutines before the stores, %d after closing them:\n%s",
+			before, after, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// TestCloseTwice checks that Close and Sync after Close succeed
+func TestCloseTwice(t *testing.T) {
+	store := storage.NewMemStore()
+	for _, op := range []func() error{store.Close, store.Close, store.Sync} {
+		if err := op(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	store := NewStore(t)
+	if err := store.CreateIndex("name", "keyword"); err != nil {
+		t.Fatal(err)
+	}
+	LoadYAML(t, store, `
+host-1: {name: web, ip: 10.0.0.1}
+host-2: {name: db, ip: 10.0.0.2}
+`)
+	if err := Check(store); err != nil {
+		t.Fatalf("Check of a consistent store: %v", err)
+	}
+
+	// Changing a value in place, behind the store, leaves its hash and
+	// index entries stale
+	entry, _ := store.Get("host-1")
+	entry.Value.(map[string]interface{})["name"] = "mail"
+
+	err := Check(store)
+	if err == nil {
+		t.Fatal("Check of a store with a value changed in place returned nil")
+	}
+	for _, want := range []string{
+		"host-1: stored hash",
+		"host-1: not found by a filter on keyword index name = mail",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Check error %q does not report %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "host-2") {
+		t.Errorf("Check error %q reports the untouched host-2", err)
+	}
+}
@@ -1,8 +1,9 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
-	"github.com/edsrzf/mmap-go"
+	"io"
 	"log"
 	"os"
 	"sync"
@@ -12,20 +13,59 @@ import (
 // Entry represents a single value in the store with metadata
 type Entry struct {
 	Value     interface{} `yaml:"value"`
+	Source    string      `yaml:"source,omitempty" json:"-"` // Original YAML of round-trip entries
 	Timestamp int64       `yaml:"timestamp,omitempty"`
 	TTL       int64       `yaml:"ttl,omitempty"`
+	Metadata  *Metadata   `yaml:"metadata,omitempty" json:",omitempty"`
+	Sliding   int64       `yaml:"sliding,omitempty" json:",omitempty"` // Seconds the TTL extends from each read, see SetSliding
+	Hash      string      `yaml:"hash,omitempty" json:",omitempty"`    // ContentHash of the value when it was written, see Verify
+
+	packed []byte // Compressed value, which is then nil, see StoreOptions.CompressThreshold
 }
 
 // Store represents an enhanced memory-mapped key-value store
 type Store struct {
 	sync.RWMutex
-	mm       mmap.MMap
-	filepath string
-	data     map[string]*Entry
-	dirty    bool
-	stats    StoreStats
-	encoder  *FastYAMLEncoder
-	indexes  *IndexManager
+	persist     persister
+	filepath    string
+	data        map[string]*Entry
+	coercions   map[string]string // field -> declared type
+	dirty       bool
+	shadowStale bool // StoreOptions.ShadowPath lacks the last sync
+	format      int  // Data file format version
+	opts        StoreOptions
+	counters    storeCounters
+	encoder     *FastYAMLEncoder
+	indexes     *IndexManager
+	startupMu   sync.Mutex
+	startup     StartupStatus
+	builds      map[*IndexBuild]struct{} // Index builds in progress
+	slowlog     slowLog
+	indexErrors indexErrorLog
+	conflicts   conflictLog
+	labels      labelIndex
+	children    childIndex
+	links       linkIndex
+	expireQueue chan string // Expired keys found by reads, see expireLazily
+	views       map[string]*view
+	hotKeys     *hotKeys        // Nil unless StoreOptions.HotKeys is set
+	queryCache  *queryCache     // Nil unless StoreOptions.QueryCacheSize is set
+	vectorLog   *vectorLog      // Nil unless StoreOptions.PersistVectors is set
+	recovery    *RecoveryReport // Nil unless the data file was recovered on load
+	indexLimits map[string]IndexLimit
+	indexMemory indexMemoryEstimate
+	latency     latencies
+	history     *statsHistory // Nil unless StoreOptions.StatsInterval is set
+	usage       *indexUsage
+	jobs        *jobQueue
+
+	stop     chan struct{} // Closed by Close to stop the background workers
+	stopOnce sync.Once
+	workers  sync.WaitGroup // The background workers, which Close waits for
+	closed   bool           // Close has released the files; sync does nothing
+
+	pendingSize int64 // Estimated size of the entries written since the last sync
+	full        bool  // The last sync exceeded MaxSize
 }
 
 // StoreOptions configures the store initialization
@@ -34,8 +74,56 @@ type StoreOptions struct {
 	MaxSize      int64
 	SyncInterval time.Duration
 	Debug        bool
+	MaxEntries   int    // Maximum number of entries, 0 for unlimited
+	LimitPolicy  string // LimitReject (default) or LimitEvict, for writes over MaxEntries, MaxIndexMemory or an IndexLimit
+	MaxKeyLength int    // Maximum key length in bytes, 0 for unlimited
+	EnrichAttack bool   // Add attack_techniques to documents mentioning ATT&CK technique IDs
+	StrictDecode bool   // Reject unknown fields when loading the data file
+	SyncWorkers  int    // Goroutines encoding large data sets on sync, 0 for GOMAXPROCS
+	LazyIndexes  bool   // Build indexes over existing entries in the background
+	MLock        bool   // Lock the mapped file into RAM
+	Warmup       bool   // Touch every page of the mapped file on startup
+	Persistence  string // PersistMMap, PersistFile, PersistAtomic or PersistMemory, empty for the platform default
+	ReadOnly     bool   // Open the data file shared and reject writes with ErrReadOnly
+	ShadowPath   string // Mirror the data file here after every sync, for a warm standby; empty disables
+	Recover      bool   // Salvage what a data file that fails to decode still holds instead of failing, see RecoveryReport
+
+	CompressThreshold int64 // Hold values estimated at this many bytes or more compressed in memory, 0 disables
+	MaxIndexMemory    int64 // Estimated memory of all indexes at which new documents are rejected or evict others, 0 for unlimited
+	HotKeys           int   // Number of most read keys tracked for Store.HotKeys, 0 disables
+	QueryCacheSize    int   // Number of searches whose results are cached for repeats, 0 disables
+	PersistVectors    bool  // Keep the vector indexes in a file next to the data file instead of rebuilding them on startup; ignored with ReadOnly
+
+	GCInterval    time.Duration // Interval of sweeps of expired entries, 0 to sweep after every periodic sync
+	GCMaxEntries  int           // Expired entries removed per sweep, 0 for all
+	GCPauseBudget time.Duration // Longest a sweep holds the write lock, 0 for unlimited
+
+	StrictIndexing bool // Reject writes an index cannot accept instead of reporting them in IndexErrors
+
+	SlowQueryThreshold time.Duration // Log searches taking at least this long, 0 disables
+	SlowLogSize        int           // Slow queries kept, 0 for DefaultSlowLogSize
+	SlowLogToLog       bool          // Also write slow queries to the process log
+
+	StatsInterval  time.Duration // Interval of the stats samples kept for StatsHistory, 0 disables
+	StatsRetention time.Duration // How long stats samples are kept, 0 for DefaultStatsRetention
+
+	ConflictPolicy string    // Resolution of replicated writes: ConflictLastWriterWins (default), ConflictHighestVersion or ConflictMerge
+	VersionField   string    // Field compared by ConflictHighestVersion, empty for DefaultVersionField
+	Merge          MergeFunc // Merge of map values under ConflictMerge, nil for MergeMaps
 }
 
+// ErrQuotaExceeded is returned when a write of a new key would exceed MaxEntries
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrStoreFull is returned when the data would exceed MaxSize
+var ErrStoreFull = errors.New("store is full")
+
+// ErrNotFound is returned when a key or index does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidTTL is returned for TTLs that are not positive durations
+var ErrInvalidTTL = errors.New("invalid TTL")
+
 var DefaultOptions = StoreOptions{
 	InitialSize:  32 << 20,  // 32MB
 	MaxSize:      512 << 20, // 512MB
@@ -45,48 +133,87 @@ var DefaultOptions = StoreOptions{
 
 // NewStore creates a new memory-mapped store with the given options
 func NewStore(filepath string, opts StoreOptions) (*Store, error) {
-	file, err := os.OpenFile(filepath, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %v", err)
+	if err := checkConflictPolicy(opts); err != nil {
+		return nil, err
 	}
-	defer file.Close()
-
-	info, err := file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("failed to stat file: %v", err)
+	if err := checkLimitPolicy(opts); err != nil {
+		return nil, err
 	}
-
-	if info.Size() < opts.InitialSize {
-		if err := file.Truncate(opts.InitialSize); err != nil {
-			return nil, fmt.Errorf("failed to truncate file: %v", err)
-		}
+	if err := checkShadowPath(filepath, opts.ShadowPath); err != nil {
+		return nil, err
 	}
-
-	mm, err := mmap.Map(file, mmap.RDWR, 0)
+	persist, err := openPersister(filepath, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to map file: %v", err)
+		return nil, err
+	}
+	if _, mapped := persist.(*mmapPersister); !mapped && (opts.MLock || opts.Warmup) {
+		log.Printf("Warning: mlock and warmup apply only to mmap persistence and are ignored")
 	}
 
 	store := &Store{
-		mm:       mm,
+		persist:  persist,
 		filepath: filepath,
 		data:     make(map[string]*Entry, 1000),
+		opts:     opts,
 		encoder:  NewFastYAMLEncoder(),
 		indexes:  NewIndexManager(),
+		format:   CurrentFormat,
+		labels:   make(labelIndex),
+		children: make(childIndex),
+		links:    make(linkIndex),
+
+		expireQueue: make(chan string, expireQueueSize),
+		hotKeys:     newHotKeys(opts.HotKeys),
+		queryCache:  newQueryCache(opts.QueryCacheSize),
+		history:     newStatsHistory(opts.StatsInterval, opts.StatsRetention),
+		usage:       newIndexUsage(),
+		jobs:        newJobQueue(jobsPath(filepath, opts)),
+		stop:        make(chan struct{}),
+		shadowStale: opts.ShadowPath != "", // Mirror on the first sync even if nothing changed
 	}
 
+	store.encoder.Strict = opts.StrictDecode
+
 	// Initialize file size stat
-	store.stats.FileSize = info.Size()
+	store.counters.fileSize.Store(persist.size())
 
 	if err := store.load(); err != nil && !os.IsNotExist(err) {
+		store.vectorLog.close()
+		persist.close()
 		return nil, fmt.Errorf("error loading existing data: %v", err)
 	}
+	store.Lock()
+	err = store.loadViews()
+	store.Unlock()
+	if err != nil {
+		store.vectorLog.close()
+		persist.close()
+		return nil, err
+	}
 
-	go store.periodicSync(opts.SyncInterval)
+	store.periodicSync(opts.SyncInterval)
+	store.startWorker(store.expireWorker)
+	if opts.GCInterval > 0 {
+		store.periodicGC(opts.GCInterval)
+	}
+	if store.history != nil {
+		store.periodicStats(opts.StatsInterval)
+	}
 
 	return store, nil
 }
 
+// NewMemStore returns a store that keeps its data in memory only, without a
+// data file or any of the files kept next to it, for tests of code that
+// embeds the store. NewStore with PersistMemory takes other options.
+func NewMemStore() *Store {
+	store, err := NewStore("", StoreOptions{SyncInterval: time.Minute, Persistence: PersistMemory})
+	if err != nil {
+		panic(err) // Nothing is read and the options are valid
+	}
+	return store
+}
+
 // CRUD Operations with performance tracking
 
 func (s *Store) Get(key string) (*Entry, bool) {
@@ -94,70 +221,149 @@ func (s *Store) Get(key string) (*Entry, bool) {
 	defer func() {
 		s.updateReadStats(time.Since(start))
 	}()
+	s.hotKeys.record(key)
 
 	s.RLock()
-	defer s.RUnlock()
-
 	entry, exists := s.data[key]
+	s.RUnlock()
+
 	if exists {
-		if entry.TTL > 0 && time.Now().Unix() > entry.Timestamp+entry.TTL {
-			go s.Delete(key) // Async cleanup
+		if entry.expired(time.Now().Unix()) {
+			s.expireLazily(key)
 			return nil, false
 		}
+		if entry.Sliding > 0 {
+			s.Lock()
+			entry, exists = s.touch(key, time.Duration(entry.Sliding)*time.Second)
+			s.Unlock()
+		}
 	}
 
-	return entry, exists
+	return entry.hydrate(), exists
 }
 
 func (s *Store) Set(key string, value interface{}) error {
-	start := time.Now()
-	defer func() {
-		s.updateWriteStats(time.Since(start))
-	}()
-
-	s.Lock()
-	defer s.Unlock()
+	return s.SetWithMetadata(key, value, 0, nil)
+}
 
-	entry := &Entry{
-		Value:     value,
-		Timestamp: time.Now().Unix(),
+// checkQuota rejects writes to read-only stores, writes of new keys beyond
+// MaxEntries unless they evict others, and any write once the data size has
+// reached MaxSize. The data size is that of the last sync plus an estimate
+// of the entries written since, which overestimates updates and ignores
+// deletes, so a write reaching MaxSize by the estimate syncs to measure it.
+// Callers must hold the lock.
+func (s *Store) checkQuota(key string) error {
+	if s.opts.ReadOnly {
+		return ErrReadOnly
 	}
 
-	s.data[key] = entry
-	s.dirty = true
+	if _, exists := s.data[key]; !exists && s.opts.MaxEntries > 0 && len(s.data) >= s.opts.MaxEntries && s.opts.LimitPolicy != LimitEvict {
+		return fmt.Errorf("%w: entry limit %d reached", ErrQuotaExceeded, s.opts.MaxEntries)
+	}
 
-	if err := s.indexes.Update(key, value); err != nil {
-		return fmt.Errorf("failed to update indexes: %v", err)
+	if s.opts.MaxSize <= 0 {
+		return nil
+	}
+	if !s.full && s.dirty && s.counters.dataSize.Load()+s.pendingSize >= s.opts.MaxSize {
+		if err := s.sync(); err != nil && !errors.Is(err, ErrStoreFull) {
+			return err
+		}
+	}
+	if s.full || s.counters.dataSize.Load()+s.pendingSize >= s.opts.MaxSize {
+		return fmt.Errorf("%w: data size limit %d bytes reached", ErrStoreFull, s.opts.MaxSize)
 	}
 
 	return nil
 }
 
-// periodicSync runs a background goroutine that periodically syncs data to disk
-func (s *Store) periodicSync(interval time.Duration) {
-	ticker := time.NewTicker(interval)
+// prepare applies field coercions and enrichments to a value being written
+// and, with StrictIndexing, checks that every index accepts it. Callers must
+// hold the lock.
+func (s *Store) prepare(value interface{}) (interface{}, error) {
+	value, err := s.coerce(value)
+	if err != nil {
+		return nil, err
+	}
+	value = s.enrich(value)
+
+	if s.opts.StrictIndexing {
+		if err := s.indexes.Validate(value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// enrich applies the configured ingest enrichments to a value
+func (s *Store) enrich(value interface{}) interface{} {
+	if s.opts.EnrichAttack {
+		value = EnrichAttackTechniques(value)
+	}
+	return value
+}
+
+// startWorker runs a background worker, which returns once s.stop is closed
+func (s *Store) startWorker(worker func()) {
+	s.workers.Add(1)
 	go func() {
+		defer s.workers.Done()
+		worker()
+	}()
+}
+
+// every starts a background worker calling fn at every tick of interval
+// until the store is closed
+func (s *Store) every(interval time.Duration, fn func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	s.startWorker(func() {
+		defer ticker.Stop()
 		for {
 			select {
-			case <-ticker.C:
-				start := time.Now()
-				if err := s.sync(); err != nil {
-					log.Printf("Error during periodic sync: %v", err)
-				}
-				// Update sync latency statistics
-				s.updateSyncStats(time.Since(start))
-
-				// Perform garbage collection of expired entries
-				s.gcExpiredEntries()
+			case now := <-ticker.C:
+				fn(now)
+			case <-s.stop:
+				return
 			}
 		}
-	}()
+	})
+}
+
+// periodicSync runs a background goroutine that periodically syncs data to disk
+func (s *Store) periodicSync(interval time.Duration) {
+	s.every(interval, func(time.Time) {
+		start := time.Now()
+		if err := s.Sync(); err != nil {
+			log.Printf("Error during periodic sync: %v", err)
+		}
+		// Update sync latency statistics
+		s.updateSyncStats(time.Since(start))
+
+		// Sweep expired entries unless they have a schedule of their own
+		if s.opts.GCInterval <= 0 {
+			s.sweepExpired()
+		}
+	})
 }
 
-// sync writes the current data to the memory-mapped file with optimized YAML encoding
+// sync streams the current data into the data file. Callers must
+// hold the lock.
 func (s *Store) sync() error {
+	if s.opts.ReadOnly || s.closed {
+		return nil // The file must not be written, or is closed
+	}
 	if !s.dirty {
-		return nil // Skip sync if no changes
+		// The data file is current, so are the vectors logged since the
+		// last sync, as by index builds
+		if err := s.vectorLog.commit(); err != nil {
+			log.Printf("Failed to commit vector log: %v", err)
+		}
+		if s.shadowStale {
+			s.syncShadow()
+		}
+		return nil
+	}
+	if err := injectFault(FaultSync); err != nil {
+		return fmt.Errorf("sync failed: %w", err)
 	}
 
 	// Create a map without expired entries
@@ -166,137 +372,100 @@ func (s *Store) sync() error {
 
 	for key, entry := range s.data {
 		if entry.TTL == 0 || now <= entry.Timestamp+entry.TTL {
+			// Round-trip entries persist only their source; the value is
+			// decoded from it again on load
+			if entry.Source != "" {
+				stripped := *entry
+				stripped.Value, stripped.packed = nil, nil
+				entry = &stripped
+			} else {
+				entry = entry.hydrate()
+			}
 			cleanData[key] = entry
 		}
 	}
 
-	// Encode the data
-	data, err := s.encoder.Encode(cleanData)
+	var checksum func() uint32
+	size, err := s.persist.write(func(w io.Writer) error {
+		w, checksum = s.vectorLog.crcWriter(w)
+		return s.encoder.encodeData(w, cleanData, s.format, s.opts.SyncWorkers)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to encode data: %v", err)
+		s.full = errors.Is(err, ErrStoreFull)
+		return fmt.Errorf("failed to write data: %w", err)
 	}
-
-	// Check if we need to grow the file
-	if len(data) > len(s.mm) {
-		if err := s.growFile(int64(len(data))); err != nil {
-			return fmt.Errorf("failed to grow file: %v", err)
-		}
-	}
-
-	// Write YAML data and pad with zeros
-	copy(s.mm, data)
-	for i := len(data); i < len(s.mm); i++ {
-		s.mm[i] = 0
-	}
-
-	if err := s.mm.Flush(); err != nil {
-		return fmt.Errorf("failed to flush to disk: %v", err)
+	s.full, s.pendingSize = false, 0
+	if err := s.vectorLog.synced(int64(size), checksum(), s.indexes); err != nil {
+		log.Printf("Failed to commit vector log: %v", err)
 	}
 
 	s.dirty = false
-	s.updateStats(int64(len(data)))
-
-	return nil
-}
-
-// resize grows or shrinks the memory-mapped file
-func (s *Store) resize(newSize int64) error {
-	s.Lock()
-	defer s.Unlock()
-
-	// Save current data
-	if err := s.sync(); err != nil {
-		return fmt.Errorf("failed to sync before resize: %v", err)
-	}
-
-	// Unmap current file
-	if err := s.mm.Unmap(); err != nil {
-		return fmt.Errorf("failed to unmap: %v", err)
-	}
-
-	// Resize file
-	file, err := os.OpenFile(s.filepath, os.O_RDWR, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open file for resize: %v", err)
-	}
-	defer file.Close()
-
-	if err := file.Truncate(newSize); err != nil {
-		return fmt.Errorf("failed to truncate: %v", err)
-	}
-
-	// Remap file
-	mm, err := mmap.Map(file, mmap.RDWR, 0)
-	if err != nil {
-		return fmt.Errorf("failed to remap: %v", err)
-	}
+	s.updateStats(int64(size))
+	s.syncShadow()
 
-	s.mm = mm
 	return nil
 }
 
-// growFile increases the file size to accommodate new data
-func (s *Store) growFile(requiredSize int64) error {
-	currentSize := int64(len(s.mm))
-	newSize := currentSize * 2
-
-	for newSize < requiredSize {
-		newSize *= 2
+// ParseTTL parses a TTL such as "90s" or "24h"
+func ParseTTL(ttl string) (time.Duration, error) {
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidTTL, ttl)
 	}
-
-	return s.resize(newSize)
+	return d, nil
 }
 
+// SetWithTTL stores a value that expires after ttl
 func (s *Store) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
-	s.Lock()
-	defer s.Unlock()
-
-	entry := &Entry{
-		Value:     value,
-		Timestamp: time.Now().Unix(),
-		TTL:       int64(ttl.Seconds()),
-	}
-
-	s.data[key] = entry
-	s.dirty = true
-
-	if err := s.indexes.Update(key, value); err != nil {
-		return fmt.Errorf("failed to update indexes: %v", err)
+	if ttl <= 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidTTL, ttl)
 	}
-
-	s.stats.Lock()
-	s.stats.Writes++
-	s.stats.Unlock()
-
-	return nil
+	return s.SetWithMetadata(key, value, ttl, nil)
 }
 
 func (s *Store) Delete(key string) {
 	s.Lock()
 	defer s.Unlock()
 
+	if s.opts.ReadOnly {
+		return // The file is never rewritten, so the entry would return on restart
+	}
+
 	if _, exists := s.data[key]; exists {
-		delete(s.data, key)
+		s.deleteEntry(key)
 		s.dirty = true
-		s.indexes.Remove(key)
 
-		s.stats.Lock()
-		s.stats.Deletes++
-		s.stats.Unlock()
+		s.counters.deletes.Add(1)
 	}
 }
 
-// Close ensures all data is synced and resources are released
+// ReadOnly reports whether the store was opened with ReadOnly
+func (s *Store) ReadOnly() bool {
+	return s.opts.ReadOnly
+}
+
+// Close stops the background workers, syncs the data and releases the
+// files. Closing a closed store does nothing.
 func (s *Store) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.workers.Wait()
+
 	s.Lock()
 	defer s.Unlock()
+	if s.closed {
+		return nil
+	}
 
 	if err := s.sync(); err != nil {
 		return fmt.Errorf("failed to sync on close: %v", err)
 	}
 
-	if err := s.mm.Unmap(); err != nil {
-		return fmt.Errorf("failed to unmap on close: %v", err)
+	s.closed = true
+	if err := s.vectorLog.close(); err != nil {
+		return err
+	}
+	if err := s.persist.close(); err != nil {
+		return err
 	}
 
 	return nil
@@ -304,57 +473,158 @@ func (s *Store) Close() error {
 
 // Sync forces a sync to disk
 func (s *Store) Sync() error {
+	s.Lock()
+	defer s.Unlock()
 	return s.sync()
 }
 
-// CreateIndex creates a new index of the specified type
-func (s *Store) CreateIndex(field string, indexType string) error {
-	return s.indexes.AddIndex(field, indexType)
-}
-
 // AddToIndex adds or updates a value in the specified index
 func (s *Store) AddToIndex(field string, key string, value interface{}) error {
+	defer s.queryCache.invalidate(key, []string{field})
 	return s.indexes.Update(key, map[string]interface{}{field: value})
 }
 
+// RemoveFromIndex removes a key from the text index of the given field
+func (s *Store) RemoveFromIndex(field string, key string) {
+	defer s.queryCache.invalidate(key, []string{field})
+	s.indexes.RemoveFromText(field, key)
+}
+
+// Range calls fn for each live entry until fn returns false
+func (s *Store) Range(fn func(key string, entry *Entry) bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	now := time.Now().Unix()
+	for key, entry := range s.data {
+		if entry.TTL > 0 && now > entry.Timestamp+entry.TTL {
+			continue
+		}
+		if !fn(key, entry.hydrate()) {
+			return
+		}
+	}
+}
+
+// HasIndex reports whether an index of the given type exists on field
+func (s *Store) HasIndex(field string, indexType string) bool {
+	return s.indexes.HasIndex(field, indexType)
+}
+
 // RemoveIndex removes an index of the specified type
 func (s *Store) RemoveIndex(field string, indexType string) error {
+	defer s.queryCache.clear()
+	defer s.invalidateIndexMemory()
+	defer s.usage.reset(indexRef{field, indexType})
 	return s.indexes.RemoveIndex(field, indexType)
 }
 
-// load reads the YAML data from the memory-mapped file with optimized parsing
+// load reads the YAML data from the data file with optimized parsing
 func (s *Store) load() error {
 	s.Lock()
 	defer s.Unlock()
 
 	// Find valid YAML content
-	size := s.findContentSize()
+	content, err := s.persist.read()
+	if err != nil {
+		return err
+	}
+	if s.opts.PersistVectors && !s.opts.ReadOnly && s.opts.Persistence != PersistMemory {
+		s.vectorLog, err = openVectorLog(s.filepath+vectorLogSuffix, int64(len(content)), dataChecksum(content))
+		if err != nil {
+			return err
+		}
+		s.indexes.vectorLog = s.vectorLog
+	}
+	size := len(content)
 	if size == 0 {
 		return nil // Empty file is valid
 	}
 
-	// Create a temporary map to hold the data
-	var tempData map[string]*Entry
+	start := time.Now()
+	log.Printf("Loading %s (%d bytes)", s.filepath, size)
 
-	// Use the encoder to decode the data
-	if err := s.encoder.Decode(s.mm[:size], &tempData); err != nil {
-		return fmt.Errorf("failed to decode YAML: %v", err)
+	// Decode into a temporary map, in whichever format the file has
+	tempData, format, err := s.encoder.decodeData(content)
+	if err != nil && s.opts.Recover {
+		tempData, format, err = s.recoverData(content, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode YAML: %w", err)
+	}
+	// Keep writing the file's format so older versions can still read it
+	// until it is migrated
+	s.format = format
+	if format < CurrentFormat {
+		log.Printf("%s uses data file format %d; run `searchyaml migrate -data %s` to upgrade to format %d",
+			s.filepath, format, s.filepath, CurrentFormat)
 	}
 
 	// Update indexes for all entries
+	var unhashed int
 	for key, entry := range tempData {
 		if entry.TTL > 0 && time.Now().Unix() > entry.Timestamp+entry.TTL {
 			// Skip expired entries
 			continue
 		}
 
-		if err := s.indexes.Update(key, entry.Value); err != nil {
-			return fmt.Errorf("failed to update indexes for key %s: %v", key, err)
+		if entry.Source != "" {
+			_, value, err := DecodeDocument([]byte(entry.Source))
+			if err != nil && s.opts.Recover {
+				cause := fmt.Errorf("failed to decode source of key %s: %v", key, err)
+				report, err := s.beginRecovery(content, cause)
+				if err != nil {
+					return err
+				}
+				report.Lost = append(report.Lost, key)
+				log.Printf("Warning: %v; the entry is dropped", cause)
+				delete(tempData, key)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to decode source of key %s: %v", key, err)
+			}
+			entry.Value = s.enrich(value)
+		}
+		// Entries written before values were hashed are trusted on first load
+		if entry.Hash == "" {
+			entry.Hash = ContentHash(entry.Value)
+			unhashed++
 		}
+
+		s.updateIndexes(key, entry.Value)
+	}
+	if unhashed > 0 {
+		log.Printf("Hashed %d entries of %s stored without a content hash", unhashed, s.filepath)
+		s.dirty = true
 	}
 
 	// Only update the main data map after all processing is successful
 	s.data = tempData
+	var sliding, compressed int64
+	for key, entry := range tempData {
+		if entry.Metadata != nil {
+			s.labels.add(key, entry.Metadata.Labels)
+			s.children.add(key, entry.Metadata.Parent)
+			s.links.add(key, entry.Metadata.Links)
+		}
+		if entry.Sliding > 0 {
+			sliding++
+		}
+		if entry = s.compress(entry); entry.compressed() {
+			tempData[key] = entry
+			compressed++
+		}
+	}
+	s.counters.sliding.Store(sliding)
+	s.counters.compressed.Store(compressed)
+
+	s.startupMu.Lock()
+	s.startup.LoadedBytes = int64(size)
+	s.startup.LoadedEntries = len(tempData)
+	s.startup.LoadDuration = float64(time.Since(start).Microseconds()) / 1000
+	s.startupMu.Unlock()
+	log.Printf("Loaded %d entries from %s in %v", len(tempData), s.filepath, time.Since(start))
 
 	// Update statistics
 	s.updateStats(int64(size))
@@ -362,115 +632,24 @@ func (s *Store) load() error {
 	return nil
 }
 
-// findContentSize efficiently finds the content size by looking for YAML document end or null terminator
-func (s *Store) findContentSize() int {
-	// Look for null terminator first (faster)
-	for i := 0; i < len(s.mm); i++ {
-		if s.mm[i] == 0 {
-			return i
-		}
-	}
-	return len(s.mm)
-}
-
 func (s *Store) updateReadStats(duration time.Duration) {
-	s.stats.Lock()
-	defer s.stats.Unlock()
-
-	currentAvg := s.stats.PerformanceStats.AvgReadLatency
-	newLatency := duration.Seconds() * 1000 // Convert to milliseconds
-	alpha := 0.1                            // Smoothing factor
-
-	s.stats.PerformanceStats.AvgReadLatency = (alpha * newLatency) + ((1 - alpha) * currentAvg)
-	s.stats.Reads++
+	s.latency.read.Record(duration)
+	s.counters.reads.Add(1)
 }
 
 func (s *Store) updateWriteStats(duration time.Duration) {
-	s.stats.Lock()
-	defer s.stats.Unlock()
-
-	currentAvg := s.stats.PerformanceStats.AvgWriteLatency
-	newLatency := duration.Seconds() * 1000 // Convert to milliseconds
-	alpha := 0.1                            // Smoothing factor
-
-	s.stats.PerformanceStats.AvgWriteLatency = (alpha * newLatency) + ((1 - alpha) * currentAvg)
-	s.stats.Writes++
-	s.stats.EntryCount = uint64(len(s.data))
+	s.latency.write.Record(duration)
+	s.counters.writes.Add(1)
 }
 
 func (s *Store) updateSyncStats(duration time.Duration) {
-	s.stats.Lock()
-	defer s.stats.Unlock()
-
-	currentAvg := s.stats.PerformanceStats.AvgSyncLatency
-	newLatency := duration.Seconds() * 1000 // Convert to milliseconds
-	alpha := 0.1                            // Smoothing factor
-
-	s.stats.PerformanceStats.AvgSyncLatency = (alpha * newLatency) + ((1 - alpha) * currentAvg)
-	s.stats.LastSyncTime = time.Now()
-	s.stats.SyncCount++
-	s.stats.FileSize = int64(len(s.mm))
+	s.latency.sync.Record(duration)
+	s.counters.syncs.Add(1)
+	s.counters.lastSync.Store(time.Now().UnixNano())
+	s.counters.fileSize.Store(s.persist.size())
 }
 
 func (s *Store) updateStats(dataSize int64) {
-	s.stats.Lock()
-	defer s.stats.Unlock()
-
-	s.stats.DataSize = dataSize
-	s.stats.EntryCount = uint64(len(s.data))
-	s.stats.FileSize = int64(len(s.mm))
-}
-
-// gcExpiredEntries removes expired entries and updates statistics
-func (s *Store) gcExpiredEntries() {
-	s.Lock()
-	defer s.Unlock()
-
-	now := time.Now().Unix()
-	expiredCount := uint64(0)
-
-	for key, entry := range s.data {
-		if entry.TTL > 0 && now > entry.Timestamp+entry.TTL {
-			delete(s.data, key)
-			s.indexes.Remove(key)
-			expiredCount++
-			s.dirty = true
-		}
-	}
-
-	if expiredCount > 0 {
-		s.stats.Lock()
-		s.stats.ExpiredCount += expiredCount
-		s.stats.EntryCount = uint64(len(s.data))
-		s.stats.PerformanceStats.LastGC = time.Now()
-		s.stats.Unlock()
-	}
-}
-
-// GetStats returns enhanced statistics
-func (s *Store) GetStats() StoreStats {
-	s.stats.Lock()
-	defer s.stats.Unlock()
-
-	// Update current stats
-	s.stats.EntryCount = uint64(len(s.data))
-	s.stats.FileSize = int64(len(s.mm))
-
-	// Update index stats
-	s.stats.IndexStats.TextIndexes.Count = len(s.indexes.text)
-	s.stats.IndexStats.VectorIndexes.Count = len(s.indexes.vectors)
-	s.stats.IndexStats.BTreeIndexes.Count = len(s.indexes.trees)
-
-	// Count entries in indexes
-	for _, idx := range s.indexes.text {
-		s.stats.IndexStats.TextIndexes.EntryCount += len(idx.docs)
-	}
-	for _, idx := range s.indexes.vectors {
-		s.stats.IndexStats.VectorIndexes.EntryCount += len(idx.vectors)
-	}
-	for _, tree := range s.indexes.trees {
-		s.stats.IndexStats.BTreeIndexes.EntryCount += tree.Len()
-	}
-
-	return s.stats
+	s.counters.dataSize.Store(dataSize)
+	s.counters.fileSize.Store(s.persist.size())
 }
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TagCount is a value of a keyword field and the number of documents
+// holding it
+type TagCount struct {
+	Tag   string `json:"tag" yaml:"tag"`
+	Count int    `json:"count" yaml:"count"`
+}
+
+// TagCounts counts the documents holding each value of field, which needs a
+// keyword index, for tag clouds. Values are sorted by descending count, then
+// by value, and only those starting with prefix are counted. Keys for which
+// readable returns false are left out, and a nil readable keeps every key.
+func (s *Store) TagCounts(field, prefix string, readable func(key string) bool) ([]TagCount, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	idx, exists := s.indexes.keywordIndex(field)
+	if !exists {
+		return nil, fmt.Errorf("%w: tag counts of %s need a keyword index", ErrInvalidQuery, field)
+	}
+	s.usage.record([]indexRef{{field, "keyword"}})
+
+	counts := idx.counts(prefix, readable)
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Tag < counts[j].Tag
+	})
+	return counts, nil
+}
+
+// counts returns the number of documents holding each value starting with
+// prefix, in value order, counting only the keys keep accepts when it is
+// not nil
+func (ki *KeywordIndex) counts(prefix string, keep func(key string) bool) []TagCount {
+	ki.RLock()
+	defer ki.RUnlock()
+
+	prefix = ki.normalize(prefix)
+	counts := []TagCount{}
+	ki.terms.AscendGreaterOrEqual(prefix, func(v string) bool {
+		if !strings.HasPrefix(v, prefix) {
+			return false
+		}
+		n := ki.values[v].Len()
+		if keep != nil {
+			n = 0
+			ki.values[v].Each(func(id uint32) bool {
+				if keep(ki.ids.key(id)) {
+					n++
+				}
+				return true
+			})
+		}
+		if n > 0 {
+			counts = append(counts, TagCount{Tag: v, Count: n})
+		}
+		return true
+	})
+	return counts
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// DefaultTokenizer is the tokenizer of text indexes created without one
+const DefaultTokenizer = "trigram"
+
+// Tokenizer splits text into the terms a text index stores for documents
+// and looks up for queries. A document matches a query by the share of the
+// query's terms it contains, so tokenizers producing more, shorter terms
+// find more misspelled and partial matches at the cost of a larger index.
+// Implementations must be safe for concurrent use.
+type Tokenizer interface {
+	// Name returns the spec the tokenizer is created from, as given to
+	// NewTokenizer
+	Name() string
+	// Tokens returns the terms of text
+	Tokens(text string) []string
+}
+
+// TokenizerFactory creates a tokenizer from the argument of a spec, the
+// part after the colon in "ngram:4", which is empty when there is none
+type TokenizerFactory func(arg string) (Tokenizer, error)
+
+var (
+	tokenizersMu sync.RWMutex
+	tokenizers   = map[string]TokenizerFactory{
+		"trigram":    func(arg string) (Tokenizer, error) { return newNGramFactory(3)(arg) },
+		"ngram":      func(arg string) (Tokenizer, error) { return newNGramFactory(0)(arg) },
+		"whitespace": newWhitespaceTokenizer,
+		"word":       newWordTokenizer,
+		"english":    newEnglishTokenizer,
+	}
+)
+
+// RegisterTokenizer makes a tokenizer available to text indexes under name.
+// Registering an existing name replaces it for indexes created afterwards.
+func RegisterTokenizer(name string, factory TokenizerFactory) {
+	tokenizersMu.Lock()
+	defer tokenizersMu.Unlock()
+	tokenizers[name] = factory
+}
+
+// Tokenizers returns the names of the registered tokenizers, sorted
+func Tokenizers() []string {
+	tokenizersMu.RLock()
+	defer tokenizersMu.RUnlock()
+	names := make([]string, 0, len(tokenizers))
+	for name := range tokenizers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTokenizer creates the tokenizer described by spec: a registered name,
+// optionally followed by a colon and an argument, such as "ngram:4". An
+// empty spec is DefaultTokenizer.
+func NewTokenizer(spec string) (Tokenizer, error) {
+	if spec == "" {
+		spec = DefaultTokenizer
+	}
+	name, arg, _ := strings.Cut(spec, ":")
+	tokenizersMu.RLock()
+	factory, ok := tokenizers[name]
+	tokenizersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown tokenizer %q", ErrInvalidIndex, name)
+	}
+	tok, err := factory(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: tokenizer %s: %v", ErrInvalidIndex, spec, err)
+	}
+	return tok, nil
+}
+
+// ngramTokenizer splits lowercased text into overlapping runs of n bytes,
+// spaces and punctuation included. Text shorter than n is one term.
+type ngramTokenizer struct {
+	n int
+}
+
+// newNGramFactory returns the factory of n-gram tokenizers, whose n is
+// the argument, or fixed if it is not 0
+func newNGramFactory(fixed int) TokenizerFactory {
+	return func(arg string) (Tokenizer, error) {
+		if fixed > 0 {
+			if arg != "" {
+				return nil, fmt.Errorf("takes no argument")
+			}
+			return ngramTokenizer{fixed}, nil
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > 16 {
+			return nil, fmt.Errorf("n must be an integer from 1 to 16")
+		}
+		return ngramTokenizer{n}, nil
+	}
+}
+
+func (t ngramTokenizer) Name() string {
+	if t.n == 3 {
+		return "trigram"
+	}
+	return "ngram:" + strconv.Itoa(t.n)
+}
+
+func (t ngramTokenizer) Tokens(text string) []string {
+	text = strings.ToLower(text)
+	if len(text) < t.n {
+		return []string{text}
+	}
+	grams := make([]string, 0, len(text)-t.n+1)
+	for i := 0; i <= len(text)-t.n; i++ {
+		grams = append(grams, text[i:i+t.n])
+	}
+	return grams
+}
+
+// whitespaceTokenizer splits text at whitespace, keeping case and
+// punctuation, for identifiers, paths and other exact tokens
+type whitespaceTokenizer struct{}
+
+func newWhitespaceTokenizer(arg string) (Tokenizer, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("takes no argument")
+	}
+	return whitespaceTokenizer{}, nil
+}
+
+func (whitespaceTokenizer) Name() string { return "whitespace" }
+
+func (whitespaceTokenizer) Tokens(text string) []string {
+	return strings.Fields(text)
+}
+
+// wordTokenizer splits lowercased text into runs of letters and digits
+type wordTokenizer struct{}
+
+func newWordTokenizer(arg string) (Tokenizer, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("takes no argument")
+	}
+	return wordTokenizer{}, nil
+}
+
+func (wordTokenizer) Name() string { return "word" }
+
+func (wordTokenizer) Tokens(text string) []string {
+	return words(text)
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// englishTokenizer splits text into words, drops English stop words and
+// reduces the others to a stem, so "scanning", "scanned" and "scans" match
+type englishTokenizer struct{}
+
+func newEnglishTokenizer(arg string) (Tokenizer, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("takes no argument")
+	}
+	return englishTokenizer{}, nil
+}
+
+func (englishTokenizer) Name() string { return "english" }
+
+func (englishTokenizer) Tokens(text string) []string {
+	all := words(text)
+	terms := all[:0]
+	for _, w := range all {
+		if _, stop := englishStopWords[w]; !stop {
+			terms = append(terms, englishStem(w))
+		}
+	}
+	return terms
+}
+
+var englishStopWords = func() map[string]struct{} {
+	m := make(map[string]struct{})
+	for _, w := range strings.Fields(`a an and are as at be but by for from has have in is it its
+		of on or that the this to was were will with`) {
+		m[w] = struct{}{}
+	}
+	return m
+}()
+
+// englishStem strips common inflectional suffixes. It is far simpler than
+// a Porter stemmer, but documents and queries are stemmed alike, so only
+// words whose stems collide are confused.
+func englishStem(w string) string {
+	for _, rule := range [...]struct{ suffix, replace string }{
+		{"ies", "y"}, {"sses", "ss"}, {"ing", ""}, {"edly", ""}, {"ed", ""}, {"ly", ""}, {"s", ""},
+	} {
+		if strings.HasSuffix(w, rule.suffix) && len(w)-len(rule.suffix) >= 3 {
+			if rule.suffix == "s" && strings.HasSuffix(w, "ss") {
+				return w
+			}
+			stem := w[:len(w)-len(rule.suffix)] + rule.replace
+			if n := len(stem); rule.replace == "" && rule.suffix != "s" && n >= 4 && stem[n-1] == stem[n-2] &&
+				!strings.ContainsRune("aeioulsz", rune(stem[n-1])) {
+				stem = stem[:n-1] // scanning -> scann -> scan
+			}
+			return stem
+		}
+	}
+	return w
+}
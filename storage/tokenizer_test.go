@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzTrigramTokens splits arbitrary text into trigrams and indexes, searches
+// and removes it
+func FuzzTrigramTokens(f *testing.F) {
+	f.Add("Hello, World")
+	f.Add("ab")
+	f.Add("")
+	f.Add("C2 at 203.0.113.7, hash d41d8cd98f00b204e9800998ecf8427e, CVE-2024-3094")
+	f.Add("İstanbul \xff\xfe ǅ")
+
+	f.Fuzz(func(t *testing.T, text string) {
+		lower := strings.ToLower(text)
+		grams := ngramTokenizer{3}.Tokens(text)
+		if len(lower) < 3 {
+			if len(grams) != 1 || grams[0] != lower {
+				t.Fatalf("Tokens(%q) = %q, want the whole text", text, grams)
+			}
+		} else if len(grams) != len(lower)-2 {
+			t.Fatalf("Tokens(%q) has %d trigrams, want %d", text, len(grams), len(lower)-2)
+		}
+		for _, gram := range grams {
+			if !strings.Contains(lower, gram) {
+				t.Fatalf("Tokens(%q) has %q, which is not in the text", text, gram)
+			}
+		}
+
+		index := NewTrigramIndex()
+		index.Update("doc", text)
+		index.Search(text, 10)
+		index.Remove("doc")
+		if index.Len() != 0 {
+			t.Fatalf("index of %q keeps %d documents after removal", text, index.Len())
+		}
+		if len(index.trigrams) != 0 || len(index.iocs) != 0 {
+			t.Fatalf("index of %q keeps terms after removal", text)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Touch returns the entry of key like Get and, if the entry expires, moves
+// its expiry to ttl from now. Entries without a TTL never expire and are
+// returned unchanged.
+func (s *Store) Touch(key string, ttl time.Duration) (*Entry, bool) {
+	start := time.Now()
+	defer func() {
+		s.updateReadStats(time.Since(start))
+	}()
+	s.hotKeys.record(key)
+
+	s.Lock()
+	entry, exists := s.touch(key, ttl)
+	s.Unlock()
+
+	return entry.hydrate(), exists
+}
+
+// SetSliding makes the entry of key expire window after it was last read:
+// it expires window from now, and every Get moves its expiry to window from
+// the read
+func (s *Store) SetSliding(key string, window time.Duration) error {
+	if window < time.Second {
+		return fmt.Errorf("%w: sliding window %v is shorter than a second", ErrInvalidTTL, window)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	entry, exists := s.data[key]
+	if !exists || entry.expired(time.Now().Unix()) {
+		return fmt.Errorf("%w: key %s", ErrNotFound, key)
+	}
+
+	touched := *entry
+	touched.Sliding = int64(window.Seconds())
+	s.setEntry(key, &touched)
+	s.touch(key, window)
+	s.dirty = true
+	return nil
+}
+
+// touch moves the expiry of an expiring entry to ttl from now. The entry is
+// replaced rather than modified, as readers may hold the old one. Callers
+// must hold the lock.
+func (s *Store) touch(key string, ttl time.Duration) (*Entry, bool) {
+	entry, exists := s.data[key]
+	if !exists {
+		return nil, false
+	}
+	now := time.Now().Unix()
+	if entry.expired(now) {
+		s.deleteEntry(key)
+		s.dirty = true
+		return nil, false
+	}
+	if entry.TTL == 0 || s.opts.ReadOnly {
+		return entry, true
+	}
+
+	touched := *entry
+	touched.TTL = now - entry.Timestamp + int64(ttl.Seconds())
+	if touched.TTL == entry.TTL {
+		return entry, true
+	}
+	s.data[key] = &touched // Labels are unchanged, so the label index is too
+	s.dirty = true
+
+	s.counters.touches.Add(1)
+	return &touched, true
+}
+
+// expired reports whether the entry has expired at the Unix time now
+func (e *Entry) expired(now int64) bool {
+	return e.TTL > 0 && now > e.Timestamp+e.TTL
+}
+
+// ttlAt returns the TTL that keeps the expiry of the entry for a write at
+// the Unix time now
+func (e *Entry) ttlAt(now int64) int64 {
+	if e.TTL == 0 {
+		return 0
+	}
+	return max(e.Timestamp+e.TTL-now, 1)
+}
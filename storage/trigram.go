@@ -2,15 +2,18 @@ package storage
 
 import (
 	"sort"
-	"strings"
 	"sync"
 )
 
-// TrigramIndex provides text search using trigram-based indexing
+// TrigramIndex provides text search over the terms of a Tokenizer,
+// trigrams unless the index was created with another
 type TrigramIndex struct {
 	sync.RWMutex
-	trigrams map[string]map[string]struct{} // trigram -> document keys
-	docs     map[string]string              // document key -> original text
+	tokenizer Tokenizer
+	ids       *docIDs
+	trigrams  map[string]*Bitmap // term -> document IDs
+	iocs      map[string]*Bitmap // exact indicator token -> document IDs
+	docs      map[uint32]string  // document ID -> original text
 }
 
 // TextSearchResult represents a single text search result with score
@@ -22,9 +25,21 @@ type TextSearchResult struct {
 
 // NewTrigramIndex creates a new trigram-based text index
 func NewTrigramIndex() *TrigramIndex {
+	return NewTextIndex(ngramTokenizer{3})
+}
+
+// NewTextIndex creates a text index splitting text with tokenizer
+func NewTextIndex(tokenizer Tokenizer) *TrigramIndex {
+	return newTextIndex(tokenizer, newDocIDs())
+}
+
+func newTextIndex(tokenizer Tokenizer, ids *docIDs) *TrigramIndex {
 	return &TrigramIndex{
-		trigrams: make(map[string]map[string]struct{}),
-		docs:     make(map[string]string),
+		tokenizer: tokenizer,
+		ids:       ids,
+		trigrams:  make(map[string]*Bitmap),
+		iocs:      make(map[string]*Bitmap),
+		docs:      make(map[uint32]string),
 	}
 }
 
@@ -34,19 +49,28 @@ func (ti *TrigramIndex) Update(key string, text string) {
 	defer ti.Unlock()
 
 	// Remove old trigrams if document exists
-	if oldText, exists := ti.docs[key]; exists {
-		ti.removeDocumentTrigrams(key, oldText)
+	id := ti.ids.assign(key)
+	if oldText, exists := ti.docs[id]; exists {
+		ti.removeDocumentTrigrams(id, oldText)
 	}
 
 	// Store original text
-	ti.docs[key] = text
+	ti.docs[id] = text
 
 	// Generate and store trigrams
-	for _, trigram := range generateTrigrams(text) {
+	for _, trigram := range ti.tokenizer.Tokens(text) {
 		if ti.trigrams[trigram] == nil {
-			ti.trigrams[trigram] = make(map[string]struct{})
+			ti.trigrams[trigram] = NewBitmap()
 		}
-		ti.trigrams[trigram][key] = struct{}{}
+		ti.trigrams[trigram].Add(id)
+	}
+
+	// Index indicators exactly so hashes, IPs and CVE IDs match as a whole
+	for _, ioc := range ExtractIOCs(text) {
+		if ti.iocs[ioc] == nil {
+			ti.iocs[ioc] = NewBitmap()
+		}
+		ti.iocs[ioc].Add(id)
 	}
 }
 
@@ -55,42 +79,110 @@ func (ti *TrigramIndex) Remove(key string) {
 	ti.Lock()
 	defer ti.Unlock()
 
-	if text, exists := ti.docs[key]; exists {
-		ti.removeDocumentTrigrams(key, text)
-		delete(ti.docs, key)
+	id, exists := ti.ids.lookup(key)
+	if !exists {
+		return
+	}
+	if text, exists := ti.docs[id]; exists {
+		ti.removeDocumentTrigrams(id, text)
+		delete(ti.docs, id)
 	}
 }
 
+// Len returns the number of indexed documents
+func (ti *TrigramIndex) Len() int {
+	ti.RLock()
+	defer ti.RUnlock()
+	return len(ti.docs)
+}
+
+// holds reports whether the index has entries of a document ID
+func (ti *TrigramIndex) holds(id uint32) bool {
+	ti.RLock()
+	defer ti.RUnlock()
+	_, exists := ti.docs[id]
+	return exists
+}
+
 // Search performs a fuzzy text search using trigrams
 func (ti *TrigramIndex) Search(query string, maxResults int) []TextSearchResult {
+	return ti.search(query, maxResults, nil, nil)
+}
+
+// search is Search, counting the trigrams, indicators and postings scanned
+// in stage when it is not nil. With within only those documents are scored.
+func (ti *TrigramIndex) search(query string, maxResults int, within *Bitmap, stage *QueryStage) []TextSearchResult {
 	ti.RLock()
 	defer ti.RUnlock()
 
 	// Generate query trigrams
-	queryTrigrams := generateTrigrams(query)
+	queryTrigrams := ti.tokenizer.Tokens(query)
 
 	// Count trigram matches per document
-	scores := make(map[string]int)
+	scores := make(map[uint32]int)
+	postings := 0
 	for _, trigram := range queryTrigrams {
 		if docs, exists := ti.trigrams[trigram]; exists {
-			for doc := range docs {
-				scores[doc]++
+			if within != nil {
+				docs = docs.And(within)
+			}
+			postings += docs.Len()
+			docs.Each(func(id uint32) bool {
+				scores[id]++
+				return true
+			})
+		}
+	}
+
+	// Count exact indicator matches per document
+	queryIOCs := ExtractIOCs(query)
+	iocScores := make(map[uint32]int)
+	for _, ioc := range queryIOCs {
+		if docs, exists := ti.iocs[ioc]; exists {
+			if within != nil {
+				docs = docs.And(within)
 			}
+			postings += docs.Len()
+			docs.Each(func(id uint32) bool {
+				iocScores[id]++
+				if _, exists := scores[id]; !exists {
+					scores[id] = 0
+				}
+				return true
+			})
 		}
 	}
 
 	// Convert to results slice and calculate normalized scores
 	results := make([]TextSearchResult, 0, len(scores))
 	maxQueryTrigrams := len(queryTrigrams)
-	for doc, matches := range scores {
-		score := float64(matches) / float64(maxQueryTrigrams)
+	ti.ids.RLock()
+	defer ti.ids.RUnlock()
+	for id, matches := range scores {
+		doc := ti.ids.keys[id]
+		var score float64
+		if maxQueryTrigrams > 0 { // Queries of stop words only have no terms
+			score = float64(matches) / float64(maxQueryTrigrams)
+		}
+		if len(queryIOCs) > 0 {
+			// For indicator queries, exact hits weigh as much as trigram overlap so
+			// CVE-2024-1234 ranks above CVE-2024-12345
+			score = (score + float64(iocScores[id])/float64(len(queryIOCs))) / 2
+		}
 		results = append(results, TextSearchResult{
 			Key:   doc,
 			Score: score,
-			Text:  ti.docs[doc],
+			Text:  ti.docs[id],
 		})
 	}
 
+	if stage != nil {
+		stage.Trigrams = len(queryTrigrams)
+		stage.IOCs = len(queryIOCs)
+		stage.Postings = postings
+		stage.Matched = len(results)
+	}
+
 	// Sort by score
 	sort.Slice(results, func(i, j int) bool {
 		return results[i].Score > results[j].Score
@@ -106,33 +198,33 @@ func (ti *TrigramIndex) Search(query string, maxResults int) []TextSearchResult
 
 // Helper functions
 
-func (ti *TrigramIndex) removeDocumentTrigrams(key string, text string) {
-	for _, trigram := range generateTrigrams(text) {
+func (ti *TrigramIndex) removeDocumentTrigrams(id uint32, text string) {
+	for _, trigram := range ti.tokenizer.Tokens(text) {
 		if docs, exists := ti.trigrams[trigram]; exists {
-			delete(docs, key)
-			if len(docs) == 0 {
+			docs.Remove(id)
+			if docs.Len() == 0 {
 				delete(ti.trigrams, trigram)
 			}
 		}
 	}
-}
 
-func generateTrigrams(text string) []string {
-	text = strings.ToLower(text)
-	if len(text) < 3 {
-		return []string{text}
-	}
-
-	trigrams := make([]string, 0, len(text)-2)
-	for i := 0; i <= len(text)-3; i++ {
-		trigrams = append(trigrams, text[i:i+3])
+	for _, ioc := range ExtractIOCs(text) {
+		if docs, exists := ti.iocs[ioc]; exists {
+			docs.Remove(id)
+			if docs.Len() == 0 {
+				delete(ti.iocs, ioc)
+			}
+		}
 	}
-	return trigrams
 }
 
 // FuzzySearch performs fuzzy text search with configurable parameters
 func (ti *TrigramIndex) FuzzySearch(query string, minScore float64, maxResults int) []TextSearchResult {
-	results := ti.Search(query, 0) // Get all results first
+	return ti.fuzzySearch(query, minScore, maxResults, nil, nil)
+}
+
+func (ti *TrigramIndex) fuzzySearch(query string, minScore float64, maxResults int, within *Bitmap, stage *QueryStage) []TextSearchResult {
+	results := ti.search(query, 0, within, stage) // Get all results first
 
 	// Filter by minimum score
 	filtered := make([]TextSearchResult, 0, len(results))
@@ -149,3 +241,23 @@ func (ti *TrigramIndex) FuzzySearch(query string, minScore float64, maxResults i
 
 	return filtered
 }
+
+// estimate returns an upper bound of the documents matching query: the
+// length of its postings, at most the number of documents
+func (ti *TrigramIndex) estimate(query string) int {
+	ti.RLock()
+	defer ti.RUnlock()
+
+	n := 0
+	for _, trigram := range ti.tokenizer.Tokens(query) {
+		if docs, exists := ti.trigrams[trigram]; exists {
+			n += docs.Len()
+		}
+	}
+	for _, ioc := range ExtractIOCs(query) {
+		if docs, exists := ti.iocs[ioc]; exists {
+			n += docs.Len()
+		}
+	}
+	return min(n, len(ti.docs))
+}
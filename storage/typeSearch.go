@@ -1,28 +1,40 @@
 package storage
 
 import (
-	"sync"
 	"time"
 )
 
 // StoreStats tracks operational statistics for monitoring.
 type StoreStats struct {
-	sync.Mutex
 	// Basic Operations
 	Reads        uint64    `json:"reads" yaml:"reads"`
 	Writes       uint64    `json:"writes" yaml:"writes"`
 	Deletes      uint64    `json:"deletes" yaml:"deletes"`
+	Evictions    uint64    `json:"evictions" yaml:"evictions"` // Entries evicted to make room under LimitEvict
+	Touches      uint64    `json:"touches" yaml:"touches"`     // Reads that extended a TTL, see Store.Touch
 	SyncCount    uint64    `json:"sync_count" yaml:"sync_count"`
 	LastSyncTime time.Time `json:"last_sync_time" yaml:"last_sync_time"`
 
+	// Mirrors to StoreOptions.ShadowPath
+	ShadowSyncs    uint64    `json:"shadow_syncs" yaml:"shadow_syncs"`
+	ShadowFailures uint64    `json:"shadow_failures" yaml:"shadow_failures"`
+	LastShadowSync time.Time `json:"last_shadow_sync" yaml:"last_shadow_sync"`
+
 	// Storage Stats
-	DataSize     int64  `json:"data_size" yaml:"data_size"`         // Current size of YAML data
-	FileSize     int64  `json:"file_size" yaml:"file_size"`         // Total size of mmap file
-	EntryCount   uint64 `json:"entry_count" yaml:"entry_count"`     // Number of active entries
-	ExpiredCount uint64 `json:"expired_count" yaml:"expired_count"` // Number of expired entries
+	DataSize       int64  `json:"data_size" yaml:"data_size"`             // Current size of YAML data
+	FileSize       int64  `json:"file_size" yaml:"file_size"`             // Total size of mmap file
+	EntryCount     uint64 `json:"entry_count" yaml:"entry_count"`         // Number of active entries
+	ExpiredCount   uint64 `json:"expired_count" yaml:"expired_count"`     // Number of expired entries removed by GC sweeps
+	SlidingEntries uint64 `json:"sliding_entries" yaml:"sliding_entries"` // Entries whose TTL slides on read
+	FormatVersion  int    `json:"format_version" yaml:"format_version"`   // Data file format, see CurrentFormat
+
+	LazyExpirations   uint64 `json:"lazy_expirations" yaml:"lazy_expirations"`     // Expired entries removed after a read found them
+	CompressedEntries uint64 `json:"compressed_entries" yaml:"compressed_entries"` // Entries whose value is held compressed, see StoreOptions.CompressThreshold
 
 	// Index Stats
-	IndexStats struct {
+	IndexErrors uint64 `json:"index_errors" yaml:"index_errors"` // Values indexes rejected, see Store.IndexErrors
+	Conflicts   uint64 `json:"conflicts" yaml:"conflicts"`       // Replicated writes that differed, see Store.Conflicts
+	IndexStats  struct {
 		TextIndexes struct {
 			Count      int `json:"count" yaml:"count"`
 			EntryCount int `json:"entry_count" yaml:"entry_count"`
@@ -35,13 +47,27 @@ type StoreStats struct {
 			Count      int `json:"count" yaml:"count"`
 			EntryCount int `json:"entry_count" yaml:"entry_count"`
 		} `json:"btree_indexes" yaml:"btree_indexes"`
+		IPIndexes struct {
+			Count      int `json:"count" yaml:"count"`
+			EntryCount int `json:"entry_count" yaml:"entry_count"`
+		} `json:"ip_indexes" yaml:"ip_indexes"`
+		KeywordIndexes struct {
+			Count      int `json:"count" yaml:"count"`
+			EntryCount int `json:"entry_count" yaml:"entry_count"`
+		} `json:"keyword_indexes" yaml:"keyword_indexes"`
+		NumericIndexes struct {
+			Count      int `json:"count" yaml:"count"`
+			EntryCount int `json:"entry_count" yaml:"entry_count"`
+		} `json:"numeric_indexes" yaml:"numeric_indexes"`
 	} `json:"index_stats" yaml:"index_stats"`
+	QueryCache QueryCacheStats `json:"query_cache" yaml:"query_cache"` // See StoreOptions.QueryCacheSize
 
 	// Performance Stats
 	PerformanceStats struct {
-		AvgReadLatency  float64   `json:"avg_read_latency" yaml:"avg_read_latency"`   // in milliseconds
-		AvgWriteLatency float64   `json:"avg_write_latency" yaml:"avg_write_latency"` // in milliseconds
-		AvgSyncLatency  float64   `json:"avg_sync_latency" yaml:"avg_sync_latency"`   // in milliseconds
-		LastGC          time.Time `json:"last_gc" yaml:"last_gc"`                     // Last time expired entries were cleaned
+		Read   LatencyStats `json:"read" yaml:"read"`       // Get and Touch
+		Write  LatencyStats `json:"write" yaml:"write"`     // Writes of one or more keys
+		Search LatencyStats `json:"search" yaml:"search"`   // Searches, including those of bulk updates and deletes
+		Sync   LatencyStats `json:"sync" yaml:"sync"`       // Writes of the data file
+		LastGC time.Time    `json:"last_gc" yaml:"last_gc"` // Last time expired entries were cleaned
 	} `json:"performance_stats" yaml:"performance_stats"`
 }
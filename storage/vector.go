@@ -1,17 +1,29 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"sort"
 	"sync"
 )
 
+// ErrDimensionMismatch is returned for vectors whose length differs from the
+// dimensions of the index
+var ErrDimensionMismatch = errors.New("vector dimension mismatch")
+
 // VectorIndex provides vector similarity search capabilities
 type VectorIndex struct {
 	sync.RWMutex
 	vectors map[string][]float32
-	dim     int
+	dim     int // Zero until the first vector of an index without configured dimensions
+
+	// Document fields kept with the vectors, so searches filter on them
+	// while comparing vectors, see IndexOptions.Payload
+	payload  []string
+	payloads map[string]map[string]interface{}
+
+	ivf *ivfPartition // Partitions searches compare the nearest of, nil to compare every vector
 }
 
 // VectorSearchResult represents a single search result with score
@@ -20,22 +32,45 @@ type VectorSearchResult struct {
 	Score float32
 }
 
-// NewVectorIndex creates a new vector index with specified dimensions
+// NewVectorIndex creates a new vector index with specified dimensions. With
+// 0 dimensions the index takes those of the first vector added to it.
 func NewVectorIndex(dimensions int) *VectorIndex {
 	return &VectorIndex{
-		vectors: make(map[string][]float32),
-		dim:     dimensions,
+		vectors:  make(map[string][]float32),
+		dim:      dimensions,
+		payloads: make(map[string]map[string]interface{}),
 	}
 }
 
+// Dimensions returns the length of the vectors of the index, 0 while an
+// index detecting them holds none
+func (vi *VectorIndex) Dimensions() int {
+	vi.RLock()
+	defer vi.RUnlock()
+	return vi.dim
+}
+
+// fits returns the error of adding a vector of n dimensions, nil if the
+// index takes it. Callers must hold a lock.
+func (vi *VectorIndex) fits(n int) error {
+	switch {
+	case n == 0:
+		return errNotVector
+	case vi.dim != 0 && n != vi.dim:
+		return fmt.Errorf("%w: expected %d, got %d", ErrDimensionMismatch, vi.dim, n)
+	}
+	return nil
+}
+
 // Update adds or updates a vector for a given key
 func (vi *VectorIndex) Update(key string, vector []float32) error {
 	vi.Lock()
 	defer vi.Unlock()
 
-	if len(vector) != vi.dim {
-		return fmt.Errorf("vector dimension mismatch: expected %d, got %d", vi.dim, len(vector))
+	if err := vi.fits(len(vector)); err != nil {
+		return err
 	}
+	vi.dim = len(vector)
 
 	// Normalize vector before storing
 	normalized := make([]float32, len(vector))
@@ -43,23 +78,81 @@ func (vi *VectorIndex) Update(key string, vector []float32) error {
 	normalizeVector(normalized)
 
 	vi.vectors[key] = normalized
+	if vi.ivf != nil {
+		vi.ivf.add(key, vi.ivf.nearest(normalized))
+	}
 	return nil
 }
 
+// setPayload keeps the payload fields of the document of key
+func (vi *VectorIndex) setPayload(key string, doc map[string]interface{}) {
+	if len(vi.payload) == 0 {
+		return
+	}
+	payload := payloadOf(vi.payload, doc)
+	vi.Lock()
+	defer vi.Unlock()
+	if payload == nil {
+		delete(vi.payloads, key)
+	} else {
+		vi.payloads[key] = payload
+	}
+}
+
+// check reports whether Update would reject a field value
+func (vi *VectorIndex) check(value interface{}) error {
+	vector, ok := vectorValue(value)
+	if !ok {
+		return errNotVector
+	}
+	vi.RLock()
+	defer vi.RUnlock()
+	return vi.fits(len(vector))
+}
+
 // Remove deletes a vector from the index
 func (vi *VectorIndex) Remove(key string) {
 	vi.Lock()
 	defer vi.Unlock()
 	delete(vi.vectors, key)
+	delete(vi.payloads, key)
+	if vi.ivf != nil {
+		vi.ivf.remove(key)
+	}
+}
+
+// get returns the normalized vector of key
+func (vi *VectorIndex) get(key string) ([]float32, bool) {
+	vi.RLock()
+	defer vi.RUnlock()
+	vector, exists := vi.vectors[key]
+	return vector, exists
+}
+
+// Len returns the number of indexed vectors
+func (vi *VectorIndex) Len() int {
+	vi.RLock()
+	defer vi.RUnlock()
+	return len(vi.vectors)
 }
 
 // Search performs approximate nearest neighbor search
 func (vi *VectorIndex) Search(query []float32, k int) ([]VectorSearchResult, error) {
+	return vi.search(query, k, nil, nil, nil)
+}
+
+// search is Search, counting the vectors compared in stage when it is not
+// nil. With candidates only the vectors of those keys are compared, and
+// with filters only those whose payload matches them.
+func (vi *VectorIndex) search(query []float32, k int, candidates []string, filters map[string]interface{}, stage *QueryStage) ([]VectorSearchResult, error) {
 	vi.RLock()
 	defer vi.RUnlock()
 
+	if vi.dim == 0 {
+		return nil, nil // Nothing indexed to detect the dimensions from
+	}
 	if len(query) != vi.dim {
-		return nil, fmt.Errorf("query dimension mismatch: expected %d, got %d", vi.dim, len(query))
+		return nil, fmt.Errorf("%w: query expected %d, got %d", ErrDimensionMismatch, vi.dim, len(query))
 	}
 
 	// Normalize query vector
@@ -67,14 +160,42 @@ func (vi *VectorIndex) Search(query []float32, k int) ([]VectorSearchResult, err
 	copy(normalized, query)
 	normalizeVector(normalized)
 
-	// Calculate cosine similarity with all vectors
-	results := make([]VectorSearchResult, 0, len(vi.vectors))
-	for key, vec := range vi.vectors {
-		similarity := cosineSimilarity(normalized, vec)
-		results = append(results, VectorSearchResult{
-			Key:   key,
-			Score: similarity,
-		})
+	// Calculate cosine similarity with all vectors, or those of candidates
+	// or of the partitions nearest the query, skipping those whose payload
+	// does not match the filters
+	var results []VectorSearchResult
+	skipped := 0
+	compare := func(key string, vec []float32) {
+		if filters != nil && !matchPayload(vi.payloads[key], filters) {
+			skipped++
+			return
+		}
+		results = append(results, VectorSearchResult{Key: key, Score: cosineSimilarity(normalized, vec)})
+	}
+	switch {
+	case candidates != nil:
+		results = make([]VectorSearchResult, 0, min(len(candidates), len(vi.vectors)))
+		for _, key := range candidates {
+			if vec, exists := vi.vectors[key]; exists {
+				compare(key, vec)
+			}
+		}
+	case vi.ivf != nil:
+		for _, list := range vi.ivf.probe(normalized) {
+			for key := range vi.ivf.lists[list] {
+				compare(key, vi.vectors[key])
+			}
+		}
+	default:
+		results = make([]VectorSearchResult, 0, len(vi.vectors))
+		for key, vec := range vi.vectors {
+			compare(key, vec)
+		}
+	}
+
+	if stage != nil {
+		stage.Compared = len(results)
+		stage.Skipped = skipped
 	}
 
 	// Sort by similarity score
@@ -0,0 +1,547 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// vectorLogSuffix names the file next to the data file persisting the
+// vector indexes, see StoreOptions.PersistVectors
+const vectorLogSuffix = ".vectors"
+
+// The vector log starts with a header of vectorLogHeaderSize bytes: the
+// magic, the length of the committed records, and the size and CRC-32C of
+// the data file they were committed with. Records follow, each a uint32
+// length of the rest, the operation, a uint16 field length, a uint32 key
+// length, a uint32 number of dimensions, the field, the key and the vector
+// as float32, all little-endian.
+const (
+	vectorLogMagic       = "SYVECLG1"
+	vectorLogHeaderSize  = 32
+	vectorRecordHeader   = 4 + 1 + 2 + 4 + 4
+	vectorLogInitialSize = 1 << 20
+)
+
+// Operations of vector log records
+const (
+	vectorPut    byte = 1 // The normalized vector of a key in the index of a field
+	vectorRemove byte = 2 // A key removed from the index of a field
+	vectorDrop   byte = 3 // The index of a field removed
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// dataChecksum returns the checksum of data file content the vector log
+// records to recognize the data it was committed with
+func dataChecksum(content []byte) uint32 {
+	return crc32.Checksum(content, castagnoli)
+}
+
+// errBadVectorRecord is returned for records that run past the committed
+// length of the log
+var errBadVectorRecord = errors.New("truncated vector log record")
+
+// vectorLog persists the vector indexes in a mapped file next to the data
+// file. Every change to a vector index appends a record; the records are
+// committed by each sync of the data file, recording the size and checksum
+// of the data written, so a log only replays on top of the data it matches.
+// Records appended after the last sync, which the data file does not have
+// either, are ignored. The log is rewritten from the indexes once it has
+// doubled in size since it was last written whole.
+//
+// Replayed vectors wait in saved until the index of their field is
+// created, which then takes them instead of converting and normalizing the
+// vectors of the documents again. Writes of a key discard its saved
+// vectors, which may no longer match the document.
+type vectorLog struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	mm        mmap.MMap
+	used      int64 // End of the last record
+	committed int64 // End of the records matching the synced data file
+	compacted int64 // Length of the log when it was last written whole
+	dataSize  int64 // Size and checksum of the data file last synced
+	dataCRC   uint32
+	failed    bool // An append failed; records are dropped until the log is rewritten
+
+	saved map[string]map[string][]float32 // field -> key -> vector
+}
+
+// openVectorLog opens the vector log at path and replays its committed
+// records when they were committed with a data file of dataSize bytes with
+// checksum dataCRC. Otherwise the log is emptied and the indexes are built
+// from the documents as without a log.
+func openVectorLog(path string, dataSize int64, dataCRC uint32) (*vectorLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vector log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat vector log: %v", err)
+	}
+	if info.Size() < vectorLogInitialSize {
+		if err := file.Truncate(vectorLogInitialSize); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to truncate vector log: %v", err)
+		}
+	}
+	mm, err := mmap.Map(file, mmap.RDWR, 0)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to map vector log: %v", err)
+	}
+
+	l := &vectorLog{path: path, file: file, mm: mm, dataSize: dataSize, dataCRC: dataCRC}
+	if reason := l.replay(); reason != "" {
+		if info.Size() > 0 {
+			log.Printf("Ignoring vector log %s: %s; vector indexes are built from the documents", path, reason)
+		}
+		l.reset()
+	}
+	l.compacted = l.used
+	return l, nil
+}
+
+// replay reads the header and the committed records into saved. It returns
+// why the log cannot be used, or an empty string.
+func (l *vectorLog) replay() string {
+	if string(l.mm[:len(vectorLogMagic)]) != vectorLogMagic {
+		return "not a vector log"
+	}
+	committed := int64(binary.LittleEndian.Uint64(l.mm[8:]))
+	if committed < vectorLogHeaderSize || committed > int64(len(l.mm)) {
+		return "corrupt header"
+	}
+	if int64(binary.LittleEndian.Uint64(l.mm[16:])) != l.dataSize || binary.LittleEndian.Uint32(l.mm[24:]) != l.dataCRC {
+		return "written with a different data file"
+	}
+
+	l.saved = make(map[string]map[string][]float32)
+	for off := int64(vectorLogHeaderSize); off < committed; {
+		op, field, key, vector, next, err := decodeVectorRecord(l.mm[off:committed])
+		if err != nil {
+			l.saved = nil
+			return err.Error()
+		}
+		off += next
+
+		switch op {
+		case vectorPut:
+			if l.saved[field] == nil {
+				l.saved[field] = make(map[string][]float32)
+			}
+			l.saved[field][key] = vector
+		case vectorRemove:
+			delete(l.saved[field], key)
+		case vectorDrop:
+			delete(l.saved, field)
+		default:
+			l.saved = nil
+			return fmt.Sprintf("unknown operation %d", op)
+		}
+	}
+	l.used, l.committed = committed, committed
+	return ""
+}
+
+// decodeVectorRecord decodes the record at the start of b and returns its
+// length. The vector is copied out of b.
+func decodeVectorRecord(b []byte) (op byte, field, key string, vector []float32, n int64, err error) {
+	if len(b) < vectorRecordHeader {
+		return 0, "", "", nil, 0, errBadVectorRecord
+	}
+	length := int64(binary.LittleEndian.Uint32(b)) + 4
+	fieldLen := int64(binary.LittleEndian.Uint16(b[5:]))
+	keyLen := int64(binary.LittleEndian.Uint32(b[7:]))
+	dims := int64(binary.LittleEndian.Uint32(b[11:]))
+	if length > int64(len(b)) || length != vectorRecordHeader+fieldLen+keyLen+4*dims {
+		return 0, "", "", nil, 0, errBadVectorRecord
+	}
+
+	op = b[4]
+	pos := int64(vectorRecordHeader)
+	field = string(b[pos : pos+fieldLen])
+	pos += fieldLen
+	key = string(b[pos : pos+keyLen])
+	pos += keyLen
+	if dims > 0 {
+		vector = make([]float32, dims)
+		for i := range vector {
+			vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[pos:]))
+			pos += 4
+		}
+	}
+	return op, field, key, vector, length, nil
+}
+
+// encodeVectorRecord writes a record into b, which must have room for it
+func encodeVectorRecord(b []byte, op byte, field, key string, vector []float32) {
+	binary.LittleEndian.PutUint32(b, uint32(vectorRecordSize(field, key, vector)-4))
+	b[4] = op
+	binary.LittleEndian.PutUint16(b[5:], uint16(len(field)))
+	binary.LittleEndian.PutUint32(b[7:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(b[11:], uint32(len(vector)))
+	pos := vectorRecordHeader
+	pos += copy(b[pos:], field)
+	pos += copy(b[pos:], key)
+	for _, v := range vector {
+		binary.LittleEndian.PutUint32(b[pos:], math.Float32bits(v))
+		pos += 4
+	}
+}
+
+func vectorRecordSize(field, key string, vector []float32) int {
+	return vectorRecordHeader + len(field) + len(key) + 4*len(vector)
+}
+
+// reset empties the log, keeping the file
+func (l *vectorLog) reset() {
+	l.saved = nil
+	l.used = vectorLogHeaderSize
+	l.writeHeader(vectorLogHeaderSize)
+}
+
+// writeHeader commits the records up to committed with the current data
+// file size and checksum
+func (l *vectorLog) writeHeader(committed int64) {
+	copy(l.mm, vectorLogMagic)
+	binary.LittleEndian.PutUint64(l.mm[8:], uint64(committed))
+	binary.LittleEndian.PutUint64(l.mm[16:], uint64(l.dataSize))
+	binary.LittleEndian.PutUint32(l.mm[24:], l.dataCRC)
+	l.committed = committed
+}
+
+// append adds a record, growing the file when it is full. Callers must hold
+// mu. A failure is logged and stops the logging until the next rewrite, as
+// the records after it would replay on top of a missing one.
+func (l *vectorLog) append(op byte, field, key string, vector []float32) {
+	if l.failed || l.mm == nil {
+		return
+	}
+	size := int64(vectorRecordSize(field, key, vector))
+	if l.used+size > int64(len(l.mm)) {
+		if err := l.grow(l.used + size); err != nil {
+			log.Printf("Vector log %s: %v; vector indexes will be rebuilt on startup", l.path, err)
+			l.failed = true
+			return
+		}
+	}
+	encodeVectorRecord(l.mm[l.used:], op, field, key, vector)
+	l.used += size
+}
+
+// grow doubles the file until it holds required bytes and maps it again
+func (l *vectorLog) grow(required int64) error {
+	newSize := int64(len(l.mm)) * 2
+	for newSize < required {
+		newSize *= 2
+	}
+	if err := l.mm.Unmap(); err != nil {
+		return fmt.Errorf("failed to unmap: %v", err)
+	}
+	if err := l.file.Truncate(newSize); err != nil {
+		return fmt.Errorf("failed to grow: %v", err)
+	}
+	mm, err := mmap.Map(l.file, mmap.RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to remap: %v", err)
+	}
+	l.mm = mm
+	return nil
+}
+
+// put records the vector of key in the index of field
+func (l *vectorLog) put(field, key string, vector []float32) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.saved[field], key)
+	l.append(vectorPut, field, key, vector)
+}
+
+// remove records the removal of key from the index of field
+func (l *vectorLog) remove(field, key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.append(vectorRemove, field, key, nil)
+}
+
+// drop records the removal of the index of field
+func (l *vectorLog) drop(field string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.saved, field)
+	l.append(vectorDrop, field, "", nil)
+}
+
+// forget discards the saved vectors of a key being written or deleted,
+// which were read for its previous document
+func (l *vectorLog) forget(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for field, vectors := range l.saved {
+		if _, ok := vectors[key]; ok {
+			delete(vectors, key)
+			l.append(vectorRemove, field, key, nil)
+		}
+	}
+}
+
+// takeSaved returns and discards the saved vector of key for field
+func (l *vectorLog) takeSaved(field, key string) ([]float32, bool) {
+	if l == nil {
+		return nil, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	vector, ok := l.saved[field][key]
+	if ok {
+		delete(l.saved[field], key)
+	}
+	return vector, ok
+}
+
+// release discards the saved vectors of field left after its index was
+// built, whose keys no longer exist
+func (l *vectorLog) release(field string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.saved, field)
+}
+
+// commit makes the records appended so far part of the log, as they match
+// the data file: either it has just been written or it has not changed
+// since. The store lock must be held, so no record is appended for a write
+// the data file does not have.
+func (l *vectorLog) commit() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed || l.mm == nil {
+		return nil // Left for synced to rewrite, or closed
+	}
+	if l.used == l.committed && int64(binary.LittleEndian.Uint64(l.mm[16:])) == l.dataSize &&
+		binary.LittleEndian.Uint32(l.mm[24:]) == l.dataCRC {
+		return nil
+	}
+	l.writeHeader(l.used)
+	return l.mm.Flush()
+}
+
+// synced commits the log after a sync wrote dataSize bytes with checksum
+// dataCRC to the data file, rewriting it from the indexes when it has grown
+// too long or an append failed. Callers must hold the store lock.
+func (l *vectorLog) synced(dataSize int64, dataCRC uint32, im *IndexManager) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.mm == nil {
+		l.mu.Unlock()
+		return nil // Closed
+	}
+	l.dataSize, l.dataCRC = dataSize, dataCRC
+	rewrite := l.failed || (l.used > vectorLogInitialSize && l.used >= 2*l.compacted)
+	l.mu.Unlock()
+
+	if rewrite {
+		return l.rewrite(im)
+	}
+	return l.commit()
+}
+
+// rewrite replaces the log with the vectors of the indexes and the saved
+// vectors not yet taken. The new log is written beside the old one and
+// renamed over it, so a crash leaves one or the other. Callers must hold
+// the store lock, so the indexes do not change meanwhile.
+func (l *vectorLog) rewrite(im *IndexManager) error {
+	tmp := l.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite vector log: %v", err)
+	}
+	fail := func(err error) error {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rewrite vector log: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := bufio.NewWriter(file)
+	var record []byte
+	length := int64(vectorLogHeaderSize)
+	writeRecord := func(field, key string, vector []float32) error {
+		size := vectorRecordSize(field, key, vector)
+		if cap(record) < size {
+			record = make([]byte, size)
+		}
+		encodeVectorRecord(record[:size], vectorPut, field, key, vector)
+		length += int64(size)
+		_, err := w.Write(record[:size])
+		return err
+	}
+
+	if _, err := w.Write(make([]byte, vectorLogHeaderSize)); err != nil {
+		return fail(err)
+	}
+	if err := im.eachVector(writeRecord); err != nil {
+		return fail(err)
+	}
+	for field, vectors := range l.saved {
+		for key, vector := range vectors {
+			if err := writeRecord(field, key, vector); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+
+	var header [vectorLogHeaderSize]byte
+	copy(header[:], vectorLogMagic)
+	binary.LittleEndian.PutUint64(header[8:], uint64(length))
+	binary.LittleEndian.PutUint64(header[16:], uint64(l.dataSize))
+	binary.LittleEndian.PutUint32(header[24:], l.dataCRC)
+	if _, err := file.WriteAt(header[:], 0); err != nil {
+		return fail(err)
+	}
+	if err := file.Truncate(max(2*length, vectorLogInitialSize)); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	mm, err := mmap.Map(file, mmap.RDWR, 0)
+	if err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		mm.Unmap()
+		return fail(err)
+	}
+
+	l.mm.Unmap()
+	l.file.Close()
+	l.file, l.mm = file, mm
+	l.used, l.committed, l.compacted = length, length, length
+	l.failed = false
+	return nil
+}
+
+// close flushes and unmaps the log. The methods of a closed log do nothing.
+func (l *vectorLog) close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.mm == nil {
+		return nil
+	}
+	if err := l.mm.Flush(); err != nil {
+		return fmt.Errorf("failed to flush vector log: %v", err)
+	}
+	if err := l.mm.Unmap(); err != nil {
+		return fmt.Errorf("failed to unmap vector log: %v", err)
+	}
+	l.mm = nil
+	return l.file.Close()
+}
+
+// crcWriter wraps w to compute the checksum of what is written to it when
+// the store keeps a vector log
+func (l *vectorLog) crcWriter(w io.Writer) (io.Writer, func() uint32) {
+	if l == nil {
+		return w, func() uint32 { return 0 }
+	}
+	h := crc32.New(castagnoli)
+	return io.MultiWriter(w, h), h.Sum32
+}
+
+// logVector records the vector of key just added to the index of field.
+// Callers must hold the lock.
+func (im *IndexManager) logVector(field, key string, vec *VectorIndex) {
+	if im.vectorLog == nil {
+		return
+	}
+	if vector, exists := vec.get(key); exists {
+		im.vectorLog.put(field, key, vector)
+	}
+}
+
+// restoreVector adds a normalized vector read from the vector log to the
+// index of field without logging it again, with the payload of doc. It
+// reports false when the index does not exist or has other dimensions, and
+// the vector must be taken from the document instead.
+func (im *IndexManager) restoreVector(field, key string, vector []float32, doc map[string]interface{}) bool {
+	im.RLock()
+	defer im.RUnlock()
+	vec, exists := im.vectors[field]
+	if !exists {
+		return false
+	}
+	vec.Lock()
+	defer vec.Unlock()
+	if vec.fits(len(vector)) != nil {
+		return false
+	}
+	vec.dim = len(vector)
+	vec.vectors[key] = vector
+	if vec.ivf != nil {
+		vec.ivf.add(key, vec.ivf.nearest(vector))
+	}
+	if payload := payloadOf(vec.payload, doc); payload != nil {
+		vec.payloads[key] = payload
+	}
+	return true
+}
+
+// eachVector calls fn with every vector of every vector index until it
+// returns an error
+func (im *IndexManager) eachVector(fn func(field, key string, vector []float32) error) error {
+	im.RLock()
+	defer im.RUnlock()
+	for field, vec := range im.vectors {
+		vec.RLock()
+		for key, vector := range vec.vectors {
+			if err := fn(field, key, vector); err != nil {
+				vec.RUnlock()
+				return err
+			}
+		}
+		vec.RUnlock()
+	}
+	return nil
+}
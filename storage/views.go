@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/threatflux/searchyaml/expr"
+)
+
+// ErrViewNotFound is returned for views that were never defined or were
+// dropped. It wraps ErrNotFound.
+var ErrViewNotFound = fmt.Errorf("view %w", ErrNotFound)
+
+// viewsSuffix names the file next to the data file persisting the view
+// definitions
+const viewsSuffix = ".views.json"
+
+// ViewInfo describes a view
+type ViewInfo struct {
+	Name    string      `json:"name" yaml:"name"`
+	Query   SearchQuery `json:"query" yaml:"query"`
+	Count   int         `json:"count" yaml:"count"` // Keys in the view, including expired ones not yet removed
+	Created time.Time   `json:"created" yaml:"created"`
+}
+
+// ViewResult is an entry of a view
+type ViewResult struct {
+	Key   string      `json:"key" yaml:"key"`
+	Value interface{} `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// view is a query whose matching keys are kept up to date by every write,
+// so reading it costs the size of its result instead of a search
+type view struct {
+	query   SearchQuery
+	created time.Time
+	fields  map[string]interface{}
+	labels  map[string]string
+	filter  *expr.Program
+	keys    map[string]struct{}
+}
+
+// viewFile is the on-disk format of the view definitions
+type viewFile struct {
+	Views map[string]viewDefinition `json:"views"`
+}
+
+type viewDefinition struct {
+	Query   SearchQuery `json:"query"`
+	Created time.Time   `json:"created"`
+}
+
+// compileView checks that a query can be maintained incrementally: views
+// take filters and a filter expression, which are evaluated against each
+// written document, but no text or vector search, whose scores depend on
+// the other documents. Filters compare document fields directly, whether or
+// not they are indexed. Callers must hold the lock.
+func (s *Store) compileView(query SearchQuery) (*view, error) {
+	if query.Text != "" || len(query.Vector) > 0 || len(query.Fields) > 0 || len(query.Prefixes) > 0 || query.MaxResults > 0 || query.MinScore > 0 || query.hasJoin() || query.GroupChunks ||
+		query.Combiner != "" || query.Scorer != "" {
+		return nil, fmt.Errorf("%w: views take only filters and expr", ErrInvalidQuery)
+	}
+	if len(query.Filters) == 0 && query.Expr == "" {
+		return nil, fmt.Errorf("%w: views need filters or expr", ErrInvalidQuery)
+	}
+
+	v := &view{query: query, keys: make(map[string]struct{})}
+	fields, labels := splitLabelFilters(query.Filters)
+	fields, err := s.coerceFilters(fields)
+	if err != nil {
+		return nil, err
+	}
+	v.fields, v.labels = fields, labels
+	if query.Expr != "" {
+		if v.filter, err = expr.Compile(query.Expr); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+	}
+	return v, nil
+}
+
+// matches reports whether the entry of key belongs to the view. The entry
+// must not be compressed.
+func (v *view) matches(key string, entry *Entry) bool {
+	if len(v.fields) > 0 {
+		m, ok := entry.Value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for field, want := range v.fields {
+			got, exists := m[field]
+			if !exists || !viewValueEqual(got, want) {
+				return false
+			}
+		}
+	}
+	for name, want := range v.labels {
+		if entry.Metadata == nil || entry.Metadata.Labels[name] != want {
+			return false
+		}
+	}
+	if v.filter != nil {
+		env := map[string]interface{}{"key": key, "value": entry.Value, "score": 0.0}
+		if ok, err := v.filter.EvalBool(env); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// viewValueEqual compares a document field to a filter value, numbers by
+// value whatever their type
+func viewValueEqual(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// updateViews adds key to the views its new entry matches and removes it
+// from the others. Callers must hold the lock.
+func (s *Store) updateViews(key string, entry *Entry) {
+	if len(s.views) == 0 {
+		return
+	}
+	entry = entry.hydrate()
+	for _, v := range s.views {
+		if v.matches(key, entry) {
+			v.keys[key] = struct{}{}
+		} else {
+			delete(v.keys, key)
+		}
+	}
+}
+
+// removeFromViews removes a deleted key from every view. Callers must hold
+// the lock.
+func (s *Store) removeFromViews(key string) {
+	for _, v := range s.views {
+		delete(v.keys, key)
+	}
+}
+
+// DefineView defines or replaces the view name, computing its keys with a
+// pass over the data. Definitions are saved next to the data file, except
+// for read-only stores, where they last until the store is closed.
+func (s *Store) DefineView(name string, query SearchQuery) (ViewInfo, error) {
+	if name == "" {
+		return ViewInfo{}, fmt.Errorf("%w: view name is empty", ErrInvalidQuery)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	v, err := s.compileView(query)
+	if err != nil {
+		return ViewInfo{}, err
+	}
+	v.created = time.Now().UTC()
+	s.fillView(v)
+
+	previous, replaced := s.views[name]
+	if s.views == nil {
+		s.views = make(map[string]*view)
+	}
+	s.views[name] = v
+	if err := s.saveViews(); err != nil {
+		if replaced {
+			s.views[name] = previous
+		} else {
+			delete(s.views, name)
+		}
+		return ViewInfo{}, err
+	}
+	return v.info(name), nil
+}
+
+// fillView adds the keys of the live entries matching the view. Callers
+// must hold the lock.
+func (s *Store) fillView(v *view) {
+	now := time.Now().Unix()
+	for key, entry := range s.data {
+		if !entry.expired(now) && v.matches(key, entry.hydrate()) {
+			v.keys[key] = struct{}{}
+		}
+	}
+}
+
+// DropView removes the view name
+func (s *Store) DropView(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	v, exists := s.views[name]
+	if !exists {
+		return ErrViewNotFound
+	}
+	delete(s.views, name)
+	if err := s.saveViews(); err != nil {
+		s.views[name] = v
+		return err
+	}
+	return nil
+}
+
+// Views describes the defined views, sorted by name
+func (s *Store) Views() []ViewInfo {
+	s.RLock()
+	defer s.RUnlock()
+
+	infos := make([]ViewInfo, 0, len(s.views))
+	for name, v := range s.views {
+		infos = append(infos, v.info(name))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+func (v *view) info(name string) ViewInfo {
+	return ViewInfo{Name: name, Query: v.query, Count: len(v.keys), Created: v.created}
+}
+
+// View returns the live entries of the view name sorted by key, without
+// their values unless withValues is set
+func (s *Store) View(name string, withValues bool) ([]ViewResult, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	v, exists := s.views[name]
+	if !exists {
+		return nil, ErrViewNotFound
+	}
+
+	now := time.Now().Unix()
+	results := make([]ViewResult, 0, len(v.keys))
+	for key := range v.keys {
+		entry, exists := s.data[key]
+		if !exists || entry.expired(now) {
+			continue
+		}
+		result := ViewResult{Key: key}
+		if withValues {
+			result.Value = entry.hydrate().Value
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	return results, nil
+}
+
+// viewsPath returns the path of the view definitions file
+func (s *Store) viewsPath() string {
+	return s.filepath + viewsSuffix
+}
+
+// saveViews writes the view definitions next to the data file. Callers must
+// hold the lock.
+func (s *Store) saveViews() error {
+	if s.opts.ReadOnly || s.opts.Persistence == PersistMemory {
+		return nil
+	}
+	file := viewFile{Views: make(map[string]viewDefinition, len(s.views))}
+	for name, v := range s.views {
+		file.Views[name] = viewDefinition{Query: v.query, Created: v.created}
+	}
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode views: %v", err)
+	}
+
+	tmp := s.viewsPath() + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return fmt.Errorf("failed to write views: %v", err)
+	}
+	if err := os.Rename(tmp, s.viewsPath()); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write views: %v", err)
+	}
+	return nil
+}
+
+// loadViews defines the views saved next to the data file. Callers must
+// hold the lock.
+func (s *Store) loadViews() error {
+	if s.opts.Persistence == PersistMemory {
+		return nil
+	}
+	raw, err := os.ReadFile(s.viewsPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read views: %v", err)
+	}
+	var file viewFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return fmt.Errorf("failed to parse views: %v", err)
+	}
+
+	s.views = make(map[string]*view, len(file.Views))
+	for name, def := range file.Views {
+		v, err := s.compileView(def.Query)
+		if err != nil {
+			return fmt.Errorf("view %s: %w", name, err)
+		}
+		v.created = def.Created
+		s.fillView(v)
+		s.views[name] = v
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// tagsSuffix is the path segment addressing the tags of the key before it,
+// e.g. POST /data/actors/apt28/_tags or DELETE /data/actors/apt28/_tags/apt
+const tagsSuffix = "/_tags"
+
+// tagsField is the document field holding tags, indexed as keywords by
+// default
+const tagsField = "tags"
+
+// maxTags is the number of tags a document may carry
+const maxTags = 256
+
+// tagPattern is what tags may look like, e.g. apt, tlp:white or
+// ransomware-2024. Slashes would make DELETE paths ambiguous.
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_.:+@#-]{1,64}$`)
+
+// TagsRequest is the body of POST /data/:key/_tags
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// TagsResponse is the result of GET /data/:key/_tags
+type TagsResponse struct {
+	Key  string   `json:"key" yaml:"key"`
+	Tags []string `json:"tags" yaml:"tags"`
+}
+
+// TagCloudResponse is the result of GET /tags
+type TagCloudResponse struct {
+	Field string             `json:"field" yaml:"field"`
+	Tags  []storage.TagCount `json:"tags" yaml:"tags"`
+}
+
+// tagsPath splits a /data path addressing tags into the key and, for
+// paths naming one tag, the tag
+func tagsPath(path string) (key, tag string, ok bool) {
+	if key, ok := strings.CutSuffix(path, tagsSuffix); ok {
+		return key, "", true
+	}
+	i := strings.LastIndex(path, tagsSuffix+"/")
+	if i < 0 {
+		return "", "", false
+	}
+	tag = path[i+len(tagsSuffix)+1:]
+	if tag == "" || strings.Contains(tag, "/") {
+		return "", "", false
+	}
+	return path[:i], tag, true
+}
+
+// handleTags serves /data/:key/_tags paths and passes others to next
+func handleTags(store *storage.Store, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, tag, ok := tagsPath(dataKey(c))
+		if !ok {
+			next(c)
+			return
+		}
+		if isDirectory(key) {
+			respondError(c, 400, CodeInvalidKey, "invalid key: directories have no tags")
+			return
+		}
+
+		store := tenantStore(c, store)
+		switch {
+		case tag == "" && (c.Request.Method == "GET" || c.Request.Method == "HEAD"):
+			readTags(c, store, key)
+		case tag == "" && c.Request.Method == "POST":
+			addTags(c, store, key)
+		case tag != "" && c.Request.Method == "DELETE":
+			removeTag(c, store, key, tag)
+		default:
+			respondError(c, 405, CodeInvalidRequest, "tags are read with GET and added with POST on /_tags, and removed with DELETE on /_tags/<tag>")
+		}
+	}
+}
+
+func readTags(c *gin.Context, store *storage.Store, key string) {
+	if !canRead(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	entry, exists := store.Get(key)
+	if !exists {
+		respondError(c, 404, CodeNotFound, "key not found")
+		return
+	}
+	tags, err := documentTags(entry.Value)
+	if err != nil {
+		respondError(c, 400, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	response := TagsResponse{Key: key, Tags: tags}
+	if c.GetHeader("Accept") == "application/x-yaml" {
+		c.YAML(200, response)
+	} else {
+		c.JSON(200, response)
+	}
+}
+
+func addTags(c *gin.Context, store *storage.Store, key string) {
+	if !canWrite(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	if !checkWritableKey(c, key) {
+		return
+	}
+	var request TagsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBadRequest(c, err)
+		return
+	}
+	if len(request.Tags) == 0 {
+		respondError(c, 400, CodeInvalidRequest, "give the tags to add")
+		return
+	}
+	for _, tag := range request.Tags {
+		if !tagPattern.MatchString(tag) {
+			respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("invalid tag %q: use up to 64 letters, digits and _.:+@#-", tag))
+			return
+		}
+	}
+
+	added := 0
+	err := store.Update(key, func(key string, value interface{}) (interface{}, error) {
+		tags, doc, err := updatableTags(key, value)
+		if err != nil {
+			return nil, err
+		}
+		have := make(map[string]bool, len(tags))
+		for _, tag := range tags {
+			have[tag] = true
+		}
+		for _, tag := range request.Tags {
+			if !have[tag] {
+				have[tag] = true
+				tags = append(tags, tag)
+				added++
+			}
+		}
+		if added == 0 {
+			return nil, storage.ErrSkipUpdate
+		}
+		if len(tags) > maxTags {
+			return nil, fmt.Errorf("%w: %s would have %d tags, at most %d", storage.ErrQuotaExceeded, key, len(tags), maxTags)
+		}
+		sort.Strings(tags)
+		doc[tagsField] = tagList(tags)
+		return doc, nil
+	})
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"status": "ok", "added": added})
+}
+
+func removeTag(c *gin.Context, store *storage.Store, key, tag string) {
+	if !canWrite(c, key) {
+		respondError(c, 403, CodeForbidden, "access denied")
+		return
+	}
+	if !checkWritableKey(c, key) {
+		return
+	}
+
+	removed := 0
+	err := store.Update(key, func(key string, value interface{}) (interface{}, error) {
+		tags, doc, err := updatableTags(key, value)
+		if err != nil {
+			return nil, err
+		}
+		kept := tags[:0]
+		for _, t := range tags {
+			if t != tag {
+				kept = append(kept, t)
+			}
+		}
+		if removed = len(tags) - len(kept); removed == 0 {
+			return nil, storage.ErrSkipUpdate
+		}
+		if len(kept) == 0 {
+			delete(doc, tagsField)
+		} else {
+			doc[tagsField] = tagList(kept)
+		}
+		return doc, nil
+	})
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"status": "ok", "removed": removed})
+}
+
+// documentTags returns the tags of a document: a list of strings, a single
+// string, or none
+func documentTags(value interface{}) ([]string, error) {
+	m, _ := value.(map[string]interface{})
+	switch v := m[tagsField].(type) {
+	case nil:
+		return []string{}, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		tags := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("the %s field must hold strings, not %T", tagsField, item)
+			}
+			tags = append(tags, s)
+		}
+		return tags, nil
+	default:
+		return nil, fmt.Errorf("the %s field must be a list of strings, not %T", tagsField, v)
+	}
+}
+
+// updatableTags returns the tags of the document of key and a copy of the
+// document to update. Only existing map documents have tags.
+func updatableTags(key string, value interface{}) ([]string, map[string]interface{}, error) {
+	if value == nil {
+		return nil, nil, fmt.Errorf("%w: key %s", storage.ErrNotFound, key)
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: only map documents have tags", storage.ErrInvalidQuery)
+	}
+	tags, err := documentTags(m)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", storage.ErrInvalidQuery, err)
+	}
+	doc := make(map[string]interface{}, len(m)+1)
+	for k, v := range m {
+		doc[k] = v
+	}
+	return tags, doc, nil
+}
+
+func tagList(tags []string) []interface{} {
+	list := make([]interface{}, len(tags))
+	for i, tag := range tags {
+		list[i] = tag
+	}
+	return list
+}
+
+// handleTagCloud counts the readable documents holding each tag, most used
+// first. The field, default tags, needs a keyword index.
+func handleTagCloud(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		field := c.DefaultQuery("field", tagsField)
+		limit := 0
+		if l := c.Query("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 0 {
+				respondError(c, 400, CodeInvalidRequest, fmt.Sprintf("invalid limit: %q", l))
+				return
+			}
+			limit = n
+		}
+
+		counts, err := store.TagCounts(field, c.Query("prefix"), readableKeys(c))
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		if limit > 0 && len(counts) > limit {
+			counts = counts[:limit]
+		}
+
+		response := TagCloudResponse{Field: field, Tags: counts}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, response)
+		} else {
+			c.JSON(200, response)
+		}
+	}
+}
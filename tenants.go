@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTenant is the tenant used for requests that carry no tenant credentials
+const DefaultTenant = "default"
+
+var tenantNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// TenantConfig describes a single tenant and its quotas
+type TenantConfig struct {
+	APIKeys      []string `yaml:"api_keys" json:"-"`
+	TenantQuotas `yaml:",inline"`
+}
+
+// TenantQuotas are the settings of a tenant other than its API keys, which
+// /admin/tenants reports in every format
+type TenantQuotas struct {
+	DataFile     string  `yaml:"data_file,omitempty" json:"data_file,omitempty"`
+	MaxEntries   int     `yaml:"max_entries,omitempty" json:"max_entries,omitempty"`
+	MaxSize      int64   `yaml:"max_size,omitempty" json:"max_size,omitempty"`
+	OpsPerSecond float64 `yaml:"ops_per_second,omitempty" json:"ops_per_second,omitempty"`
+
+	MaxIndexMemory int64                         `yaml:"max_index_memory,omitempty" json:"max_index_memory,omitempty"`
+	LimitPolicy    string                        `yaml:"limit_policy,omitempty" json:"limit_policy,omitempty"` // reject or evict
+	IndexLimits    map[string]storage.IndexLimit `yaml:"index_limits,omitempty" json:"index_limits,omitempty"` // By field
+}
+
+// tenantsConfig is the on-disk format of the -tenants configuration
+type tenantsConfig struct {
+	Tenants   map[string]TenantConfig `yaml:"tenants"`
+	AdminKeys []string                `yaml:"admin_keys"` // API keys of the default tenant allowed on admin routes
+}
+
+// Tenant holds a tenant's isolated store along with its quota state
+type Tenant struct {
+	Name   string
+	Config TenantConfig
+	Store  *storage.Store
+
+	mu       sync.Mutex
+	tokens   float64
+	lastFill time.Time
+	ops      uint64
+	rejected uint64
+}
+
+// TenantStats reports per-tenant usage for /admin/tenants
+type TenantStats struct {
+	Name     string             `json:"name" yaml:"name"`
+	Quotas   TenantQuotas       `json:"quotas" yaml:"quotas"`
+	Ops      uint64             `json:"ops" yaml:"ops"`
+	Rejected uint64             `json:"rejected" yaml:"rejected"`
+	Store    storage.StoreStats `json:"store" yaml:"store"`
+}
+
+// allow consumes one operation from the tenant's token bucket
+func (t *Tenant) allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ops++
+	if t.Config.OpsPerSecond <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	t.tokens += now.Sub(t.lastFill).Seconds() * t.Config.OpsPerSecond
+	if t.tokens > t.Config.OpsPerSecond {
+		t.tokens = t.Config.OpsPerSecond
+	}
+	t.lastFill = now
+
+	if t.tokens < 1 {
+		t.rejected++
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+// TenantRegistry maps API keys and tenant names to isolated stores
+type TenantRegistry struct {
+	tenants   map[string]*Tenant
+	apiKeys   map[string]*Tenant
+	adminKeys map[string]struct{}
+
+	// knownKey reports whether an API key of no tenant is known elsewhere,
+	// such as to the ACL, and so selects the default tenant
+	knownKey func(key string) bool
+}
+
+// NewTenantRegistry opens one store per configured tenant. The default store
+// serves requests without tenant credentials.
+func NewTenantRegistry(configPath string, defaultStore *storage.Store, opts storage.StoreOptions) (*TenantRegistry, error) {
+	registry := &TenantRegistry{
+		tenants: map[string]*Tenant{
+			DefaultTenant: {Name: DefaultTenant, Store: defaultStore},
+		},
+		apiKeys:   make(map[string]*Tenant),
+		adminKeys: make(map[string]struct{}),
+	}
+
+	if configPath == "" {
+		return registry, nil
+	}
+
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %v", err)
+	}
+
+	var config tenantsConfig
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file: %v", err)
+	}
+
+	for _, key := range config.AdminKeys {
+		registry.adminKeys[key] = struct{}{}
+	}
+
+	for name, tc := range config.Tenants {
+		if !tenantNamePattern.MatchString(name) || name == DefaultTenant {
+			registry.Close()
+			return nil, fmt.Errorf("invalid tenant name: %q", name)
+		}
+
+		tenantOpts := opts
+		if tc.MaxEntries > 0 {
+			tenantOpts.MaxEntries = tc.MaxEntries
+		}
+		if tc.MaxSize > 0 {
+			tenantOpts.MaxSize = tc.MaxSize
+		}
+		if tc.MaxIndexMemory > 0 {
+			tenantOpts.MaxIndexMemory = tc.MaxIndexMemory
+		}
+		if tc.LimitPolicy != "" {
+			tenantOpts.LimitPolicy = tc.LimitPolicy
+		}
+		if opts.ShadowPath != "" {
+			// Each tenant mirrors to its own file next to the default one
+			tenantOpts.ShadowPath = tenantDataFile(opts.ShadowPath, name)
+		}
+
+		dataFile := tc.DataFile
+		if dataFile == "" {
+			dataFile = tenantDataFile(*DataFile, name)
+		}
+
+		store, err := storage.NewStore(dataFile, tenantOpts)
+		if err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("failed to open store for tenant %s: %v", name, err)
+		}
+		if err := createDefaultIndexes(store); err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("failed to create indexes for tenant %s: %v", name, err)
+		}
+		for field, limit := range tc.IndexLimits {
+			if err := store.SetIndexLimit(field, limit); err != nil {
+				store.Close()
+				registry.Close()
+				return nil, fmt.Errorf("tenant %s: %v", name, err)
+			}
+		}
+
+		tenant := &Tenant{
+			Name:     name,
+			Config:   tc,
+			Store:    store,
+			tokens:   tc.OpsPerSecond,
+			lastFill: time.Now(),
+		}
+		registry.tenants[name] = tenant
+
+		for _, key := range tc.APIKeys {
+			_, admin := registry.adminKeys[key]
+			if _, exists := registry.apiKeys[key]; exists || admin {
+				registry.Close()
+				return nil, fmt.Errorf("api key assigned to more than one tenant")
+			}
+			registry.apiKeys[key] = tenant
+		}
+	}
+
+	return registry, nil
+}
+
+// tenantDataFile derives a per-tenant file next to the default data file,
+// e.g. data.yaml -> data.team-a.yaml
+func tenantDataFile(base string, tenant string) string {
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + tenant + ext
+}
+
+// secured reports whether the configuration has API keys, so requests with
+// other keys are refused and admin routes need an admin key
+func (r *TenantRegistry) secured() bool {
+	return len(r.apiKeys) > 0 || len(r.adminKeys) > 0
+}
+
+// isAdmin reports whether a request may use the admin routes of its
+// tenant's store and, with server set, those of the whole server, as far
+// as tenancy is concerned. Once the configuration has API keys, the routes
+// of a store need the key of its tenant or an admin key, and those of the
+// server an admin key.
+func (r *TenantRegistry) isAdmin(c *gin.Context, server bool) bool {
+	if !r.secured() {
+		return true
+	}
+	key := apiKeyFrom(c)
+	if _, admin := r.adminKeys[key]; admin {
+		return true
+	}
+	_, tenantKey := r.apiKeys[key]
+	return tenantKey && !server
+}
+
+// Resolve finds the tenant for a request from its API key or X-Tenant
+// header. Once the configuration has API keys, a key that neither it nor
+// knownKey knows is refused rather than served as the default tenant.
+func (r *TenantRegistry) Resolve(c *gin.Context) (*Tenant, error) {
+	if key := apiKeyFrom(c); key != "" {
+		if tenant, exists := r.apiKeys[key]; exists {
+			return tenant, nil
+		}
+		_, admin := r.adminKeys[key]
+		if !admin && r.secured() && (r.knownKey == nil || !r.knownKey(key)) {
+			return nil, fmt.Errorf("unknown API key")
+		}
+	}
+
+	if name := c.GetHeader("X-Tenant"); name != "" {
+		tenant, exists := r.tenants[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown tenant: %s", name)
+		}
+		if name != DefaultTenant && len(tenant.Config.APIKeys) > 0 {
+			return nil, fmt.Errorf("tenant %s requires an API key", name)
+		}
+		return tenant, nil
+	}
+
+	return r.tenants[DefaultTenant], nil
+}
+
+// Stats returns usage for every tenant
+func (r *TenantRegistry) Stats() []TenantStats {
+	stats := make([]TenantStats, 0, len(r.tenants))
+	for _, tenant := range r.tenants {
+		tenant.mu.Lock()
+		ts := TenantStats{
+			Name:     tenant.Name,
+			Quotas:   tenant.Config.TenantQuotas,
+			Ops:      tenant.ops,
+			Rejected: tenant.rejected,
+		}
+		tenant.mu.Unlock()
+		ts.Store = tenant.Store.GetStats()
+		stats = append(stats, ts)
+	}
+	return stats
+}
+
+// Close closes all tenant stores except the default one, which is owned by main
+func (r *TenantRegistry) Close() {
+	for name, tenant := range r.tenants {
+		if name == DefaultTenant {
+			continue
+		}
+		if err := tenant.Store.Close(); err != nil {
+			log.Printf("Failed to close store for tenant %s: %v", name, err)
+		}
+	}
+}
+
+// tenantMiddleware resolves the request tenant, enforces its ops quota and
+// stores it in the gin context for handlers
+func tenantMiddleware(registry *TenantRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, err := registry.Resolve(c)
+		if err != nil {
+			respondError(c, 401, CodeUnauthorized, err.Error())
+			return
+		}
+
+		if !tenant.allow() {
+			respondError(c, 429, CodeRateLimited, "tenant ops quota exceeded")
+			return
+		}
+
+		c.Set("tenant", tenant)
+		c.Set("tenantAdmin", registry.isAdmin(c, false))
+		c.Set("serverAdmin", registry.isAdmin(c, true))
+		c.Next()
+	}
+}
+
+// tenantStore returns the store of the request's tenant, falling back to the
+// given default store when tenancy is not in use
+func tenantStore(c *gin.Context, fallback *storage.Store) *storage.Store {
+	if v, exists := c.Get("tenant"); exists {
+		return v.(*Tenant).Store
+	}
+	return fallback
+}
+
+// tenantName returns the name of the request's tenant
+func tenantName(c *gin.Context) string {
+	if v, exists := c.Get("tenant"); exists {
+		return v.(*Tenant).Name
+	}
+	return DefaultTenant
+}
+
+// apiKeyFrom extracts the API key from X-API-Key or a bearer Authorization header
+func apiKeyFrom(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+func handleTenants(registry *TenantRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tenantName(c) != DefaultTenant {
+			respondError(c, 403, CodeForbidden, "tenant listing requires the default tenant")
+			return
+		}
+
+		stats := registry.Stats()
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, stats)
+		} else {
+			c.JSON(200, stats)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+func TestTenantAdminRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := storage.NewMemStore()
+	defer store.Close()
+	teamA := &Tenant{Name: "team-a", Config: TenantConfig{APIKeys: []string{"a-secret"}}, Store: store}
+	registry := &TenantRegistry{
+		tenants:   map[string]*Tenant{DefaultTenant: {Name: DefaultTenant, Store: store}, "team-a": teamA},
+		apiKeys:   map[string]*Tenant{"a-secret": teamA},
+		adminKeys: map[string]struct{}{"ops-secret": {}},
+		knownKey:  func(key string) bool { return key == "acl-key" },
+	}
+
+	r := gin.New()
+	r.Use(tenantMiddleware(registry))
+	ok := func(c *gin.Context) { c.Status(200) }
+	r.GET("/data/x", ok)
+	r.GET("/index/limits", requireAdmin(), ok)
+	r.GET("/admin/stats", requireServerAdmin(), ok)
+
+	tests := []struct {
+		key, target string
+		want        int
+	}{
+		{"", "/data/x", 200},
+		{"", "/index/limits", 403},
+		{"", "/admin/stats", 403},
+		{"unknown", "/data/x", 401},
+		{"unknown", "/admin/stats", 401},
+		{"acl-key", "/data/x", 200},
+		{"a-secret", "/index/limits", 200},
+		{"a-secret", "/admin/stats", 403},
+		{"ops-secret", "/index/limits", 200},
+		{"ops-secret", "/admin/stats", 200},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Header.Set("X-API-Key", tt.key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("GET %s with key %q: status %d, want %d", tt.target, tt.key, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadProfile paces requests at a target rate through ramp-up, steady and
+// ramp-down phases instead of running a fixed number of operations
+type LoadProfile struct {
+	RPS      float64       `yaml:"rps"`       // Target requests per second in the steady phase
+	RampUp   time.Duration `yaml:"ramp_up"`   // Linear increase from 0 to RPS
+	Steady   time.Duration `yaml:"steady"`    // Constant RPS
+	RampDown time.Duration `yaml:"ramp_down"` // Linear decrease from RPS to 0
+}
+
+// PhaseResult summarizes the requests issued during one phase
+type PhaseResult struct {
+	Name      string  `yaml:"name"`
+	Seconds   float64 `yaml:"seconds"`
+	TargetRPS float64 `yaml:"target_rps"` // Average target rate
+	Achieved  float64 `yaml:"achieved_rps"`
+	Requests  int     `yaml:"requests"`
+	Errors    int     `yaml:"errors"`
+	Dropped   int     `yaml:"dropped"` // Requests not sent because every worker was busy
+	scheduled int
+}
+
+// phaseNames are the load phases in order
+var phaseNames = []string{"ramp-up", "steady", "ramp-down"}
+
+// Duration returns the total length of the profile
+func (p LoadProfile) Duration() time.Duration {
+	return p.RampUp + p.Steady + p.RampDown
+}
+
+// schedule returns the phase of the nth request and when to send it,
+// measured from the start of the run, or an empty phase once the profile is
+// over. Send times invert the number of requests the target rate adds up to,
+// so ramps hold their shape even where the rate is close to zero.
+func (p LoadProfile) schedule(n int) (string, time.Duration) {
+	k := float64(n)
+	rampUp, steady, rampDown := p.RampUp.Seconds(), p.Steady.Seconds(), p.RampDown.Seconds()
+
+	// During ramp-up, rps*t^2/(2*rampUp) requests have been sent by time t
+	total := p.RPS * rampUp / 2
+	if k < total {
+		return "ramp-up", seconds(math.Sqrt(2 * k * rampUp / p.RPS))
+	}
+	k -= total
+
+	total = p.RPS * steady
+	if k < total {
+		return "steady", seconds(rampUp + k/p.RPS)
+	}
+	k -= total
+
+	// During ramp-down, rps*(t - t^2/(2*rampDown)) requests have been sent
+	total = p.RPS * rampDown / 2
+	if k < total {
+		return "ramp-down", seconds(rampUp + steady + rampDown*(1-math.Sqrt(1-2*k/(p.RPS*rampDown))))
+	}
+	return "", 0
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// LoadReport holds the results of a test run
+type LoadReport struct {
+	Durations map[string][]float64 // Operation -> latencies in ms
+	Requests  map[string]int       // Operation -> requests sent, including failures
+	Errors    map[string]int       // Operation -> failed requests
+	Phases    []PhaseResult
+}
+
+// loadCollector gathers results from concurrent requests
+type loadCollector struct {
+	sync.Mutex
+	report  LoadReport
+	phases  map[string]*PhaseResult
+	samples []error
+}
+
+func newLoadCollector() *loadCollector {
+	c := &loadCollector{
+		report: LoadReport{
+			Durations: make(map[string][]float64),
+			Requests:  make(map[string]int),
+			Errors:    make(map[string]int),
+		},
+		phases: make(map[string]*PhaseResult),
+	}
+	for _, name := range phaseNames {
+		c.phases[name] = &PhaseResult{Name: name}
+	}
+	return c
+}
+
+func (c *loadCollector) add(phase string, result TestResult) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.report.Requests[result.Operation]++
+	c.phases[phase].Requests++
+	if result.Error != nil {
+		c.report.Errors[result.Operation]++
+		c.phases[phase].Errors++
+		if len(c.samples) < 5 {
+			c.samples = append(c.samples, fmt.Errorf("%s error: %v", result.Operation, result.Error))
+		}
+		return
+	}
+	c.report.Durations[result.Operation] = append(c.report.Durations[result.Operation], result.Duration)
+}
+
+func (c *loadCollector) drop(phase string) {
+	c.Lock()
+	c.phases[phase].Dropped++
+	c.Unlock()
+}
+
+// runLoad sends requests for the given operations in turn, paced by the
+// profile. At most config.Concurrency requests are in flight; requests
+// scheduled while every worker is busy are dropped and counted, so a server
+// that cannot keep up shows as a gap between target and achieved rate.
+func runLoad(config Config, profile LoadProfile, ops []string) LoadReport {
+	client := &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConns:        config.Concurrency,
+			MaxIdleConnsPerHost: config.Concurrency,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		Timeout: 5 * time.Second,
+	}
+
+	warmup(config, client)
+
+	testFuncs := map[string]func(*http.Client, Config, int) TestResult{
+		"create": testSearchYAMLCreate,
+		"read":   testSearchYAMLRead,
+		"search": testSearchYAMLSearch,
+	}
+
+	// Reads and searches target keys created by warmup or earlier creates
+	var nextKey, created atomic.Int64
+	nextKey.Store(int64(config.WarmupIterations))
+	created.Store(int64(config.WarmupIterations))
+
+	collector := newLoadCollector()
+	slots := make(chan struct{}, config.Concurrency)
+	var wg sync.WaitGroup
+
+	log.Printf("Running load profile: %v ramp-up, %v steady at %.0f rps, %v ramp-down",
+		profile.RampUp, profile.Steady, profile.RPS, profile.RampDown)
+
+	start := time.Now()
+	for n := 0; ; n++ {
+		phase, at := profile.schedule(n)
+		if phase == "" {
+			break
+		}
+		collector.phases[phase].scheduled++
+
+		time.Sleep(time.Until(start.Add(at)))
+
+		op := ops[n%len(ops)]
+		index := 0
+		if op == "create" {
+			index = int(nextKey.Add(1) - 1)
+		} else if c := created.Load(); c > 0 {
+			index = rand.Intn(int(c))
+		}
+
+		select {
+		case slots <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := testFuncs[op](client, config, index)
+				<-slots
+				if op == "create" && result.Error == nil {
+					created.Add(1)
+				}
+				collector.add(phase, result)
+			}()
+		default:
+			collector.drop(phase)
+		}
+	}
+	wg.Wait()
+
+	report := collector.report
+	for _, name := range phaseNames {
+		p := collector.phases[name]
+		if p.scheduled == 0 {
+			continue
+		}
+		switch name {
+		case "ramp-up":
+			p.Seconds = profile.RampUp.Seconds()
+		case "steady":
+			p.Seconds = profile.Steady.Seconds()
+		case "ramp-down":
+			p.Seconds = profile.RampDown.Seconds()
+		}
+		p.TargetRPS = float64(p.scheduled) / p.Seconds
+		p.Achieved = float64(p.Requests-p.Errors) / p.Seconds
+		report.Phases = append(report.Phases, *p)
+	}
+
+	ops = append([]string(nil), ops...)
+	sort.Strings(ops)
+	for _, op := range ops {
+		if report.Errors[op] > 0 {
+			log.Printf("Operation %s had %d errors", op, report.Errors[op])
+		}
+	}
+	for _, err := range collector.samples {
+		log.Printf("  %v", err)
+	}
+	return report
+}
+
+// achievedRPS returns the successful request rate of the steady phase, or
+// of the whole run when the profile has no steady phase
+func (r LoadReport) achievedRPS() float64 {
+	var requests int
+	var seconds float64
+	for _, p := range r.Phases {
+		if p.Name == "steady" {
+			return p.Achieved
+		}
+		requests += p.Requests - p.Errors
+		seconds += p.Seconds
+	}
+	if seconds == 0 {
+		return 0
+	}
+	return float64(requests) / seconds
+}
+
+func printPhases(phases []PhaseResult) {
+	fmt.Println("\nLoad Phases:")
+	for _, p := range phases {
+		fmt.Printf("  %-9s %6.1fs  target %8.1f rps  achieved %8.1f rps  requests %d  errors %d  dropped %d\n",
+			p.Name, p.Seconds, p.TargetRPS, p.Achieved, p.Requests, p.Errors, p.Dropped)
+	}
+}
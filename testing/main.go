@@ -53,6 +53,9 @@ type Config struct {
 	CooldownSeconds  int      `yaml:"cooldown_seconds"`
 	BaseURL          string   `yaml:"base_url"`
 	TestData         TestData `yaml:"test_data"`
+
+	Load *LoadProfile `yaml:"load,omitempty"`
+	SLOs []string     `yaml:"slos,omitempty"`
 }
 
 func calculateStats(times []float64) Stats {
@@ -238,11 +241,12 @@ func testSearchYAMLSearch(client *http.Client, config Config, i int) TestResult
 	return TestResult{Duration: duration, Operation: "search"}
 }
 
-func runTests(config Config, testType string) (map[string][]float64, error) {
+func runTests(config Config, testType string) (LoadReport, error) {
 	results := make(map[string][]float64)
 	results["create"] = make([]float64, 0, config.NumOperations)
 	results["read"] = make([]float64, 0, config.NumOperations)
 	results["search"] = make([]float64, 0, config.NumOperations)
+	requests := make(map[string]int)
 
 	transport := &http.Transport{
 		MaxIdleConns:        config.Concurrency,
@@ -290,8 +294,10 @@ func runTests(config Config, testType string) (map[string][]float64, error) {
 
 	var errors []error
 	errorCount := make(map[string]int)
+	report := LoadReport{Durations: results, Requests: requests, Errors: errorCount}
 
 	for result := range resultChan {
+		requests[result.Operation]++
 		if result.Error != nil {
 			errors = append(errors, fmt.Errorf("%s error: %v", result.Operation, result.Error))
 			errorCount[result.Operation]++
@@ -313,10 +319,10 @@ func runTests(config Config, testType string) (map[string][]float64, error) {
 				}
 			}
 		}
-		return results, fmt.Errorf("encountered errors during testing: %d total errors", len(errors))
+		return report, fmt.Errorf("encountered errors during testing: %d total errors", len(errors))
 	}
 
-	return results, nil
+	return report, nil
 }
 
 func main() {
@@ -326,8 +332,18 @@ func main() {
 	cooldown := flag.Int("cooldown", 2, "cooldown time between tests in seconds")
 	baseURL := flag.String("url", "http://localhost:8080", "base URL for SearchYAML")
 	testType := flag.String("type", "", "test type (create/read/search/empty for all)")
+	rps := flag.Float64("rps", 0, "target requests per second; paces a timed load profile instead of running -n operations")
+	rampUp := flag.Duration("ramp-up", 0, "time to ramp from 0 to -rps")
+	steady := flag.Duration("steady", time.Minute, "time to hold -rps")
+	rampDown := flag.Duration("ramp-down", 0, "time to ramp from -rps to 0")
+	sloSpecs := flag.String("slo", "", "comma-separated SLOs that fail the run when violated, e.g. search.p99<10ms,error_rate<1%")
 	flag.Parse()
 
+	slos, err := parseSLOs(*sloSpecs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	config := Config{
 		NumOperations:    *numOps,
 		Concurrency:      *concurrency,
@@ -351,49 +367,86 @@ func main() {
 			},
 		},
 	}
+	for _, slo := range slos {
+		config.SLOs = append(config.SLOs, slo.Spec)
+	}
 
 	log.Printf("Starting YAML performance tests with configuration:")
-	log.Printf("  Operations: %d", config.NumOperations)
+	if *rps > 0 {
+		config.Load = &LoadProfile{RPS: *rps, RampUp: *rampUp, Steady: *steady, RampDown: *rampDown}
+		log.Printf("  Target: %.0f rps for %v", config.Load.RPS, config.Load.Duration())
+	} else {
+		log.Printf("  Operations: %d", config.NumOperations)
+	}
 	log.Printf("  Concurrency: %d", config.Concurrency)
 	log.Printf("  URL: %s", config.BaseURL)
 
-	results, err := runTests(config, *testType)
-	if err != nil {
-		log.Fatalf("Error running tests: %v", err)
+	var report LoadReport
+	if config.Load != nil {
+		if config.Load.Duration() <= 0 {
+			log.Fatal("-rps needs a positive -ramp-up, -steady or -ramp-down")
+		}
+		ops := []string{"create", "read", "search"}
+		if *testType != "" {
+			ops = []string{*testType}
+		}
+		report = runLoad(config, *config.Load, ops)
+	} else {
+		report, err = runTests(config, *testType)
+		if err != nil {
+			log.Fatalf("Error running tests: %v", err)
+		}
 	}
 
 	fmt.Println("\nYAML Performance Test Results")
 	fmt.Println("============================")
 
-	for op, times := range results {
+	for op, times := range report.Durations {
 		if len(times) > 0 {
 			stats := calculateStats(times)
 			printStats(stats, op)
 		}
 	}
+	if len(report.Phases) > 0 {
+		printPhases(report.Phases)
+	}
 
-	if err := saveResults(results, config); err != nil {
+	sloResults, passed := checkSLOs(slos, report)
+
+	if err := saveResults(report, sloResults, config); err != nil {
 		log.Printf("Error saving results: %v", err)
 	}
+
+	// Exit code 2 distinguishes SLO violations from failed runs
+	if !passed {
+		fmt.Println("\nSLOs violated")
+		os.Exit(2)
+	}
 }
 
-func saveResults(results map[string][]float64, config Config) error {
+func saveResults(report LoadReport, sloResults []SLOResult, config Config) error {
 	timestamp := time.Now().Format("20060102_150405")
 	filename := fmt.Sprintf("searchyaml_test_results_%s.yaml", timestamp)
 
 	stats := make(map[string]Stats)
-	for op, times := range results {
+	for op, times := range report.Durations {
 		stats[op] = calculateStats(times)
 	}
 
 	output := struct {
 		Config  Config               `yaml:"config"`
 		Stats   map[string]Stats     `yaml:"stats"`
+		Errors  map[string]int       `yaml:"errors,omitempty"`
+		Phases  []PhaseResult        `yaml:"phases,omitempty"`
+		SLOs    []SLOResult          `yaml:"slos,omitempty"`
 		Results map[string][]float64 `yaml:"raw_results"`
 	}{
 		Config:  config,
 		Stats:   stats,
-		Results: results,
+		Errors:  report.Errors,
+		Phases:  report.Phases,
+		SLOs:    sloResults,
+		Results: report.Durations,
 	}
 
 	file, err := os.Create(filename)
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SLO is an assertion on the results, such as search.p99<10ms. Latency
+// metrics are min, max, mean, median (or p50), p95, p99 and stddev; error_rate takes
+// a fraction or percentage; rps is the achieved rate of the steady phase.
+// Metrics without an operation prefix cover every operation.
+type SLO struct {
+	Spec      string
+	Operation string
+	Metric    string
+	Op        string
+	Threshold float64 // Milliseconds for latencies, a fraction for error_rate
+}
+
+// SLOResult is the outcome of checking an SLO
+type SLOResult struct {
+	SLO    string  `yaml:"slo"`
+	Value  float64 `yaml:"value"`
+	Passed bool    `yaml:"passed"`
+}
+
+var latencyMetrics = map[string]func(Stats) float64{
+	"min":    func(s Stats) float64 { return s.Min },
+	"max":    func(s Stats) float64 { return s.Max },
+	"mean":   func(s Stats) float64 { return s.Mean },
+	"median": func(s Stats) float64 { return s.Median },
+	"p50":    func(s Stats) float64 { return s.Median },
+	"p95":    func(s Stats) float64 { return s.P95 },
+	"p99":    func(s Stats) float64 { return s.P99 },
+	"stddev": func(s Stats) float64 { return s.StdDev },
+}
+
+// parseSLOs parses a comma-separated list of SLOs, e.g.
+// "search.p99<10ms,create.p95<=5ms,error_rate<1%,rps>=450"
+func parseSLOs(specs string) ([]SLO, error) {
+	var slos []SLO
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		slo, err := parseSLO(spec)
+		if err != nil {
+			return nil, err
+		}
+		slos = append(slos, slo)
+	}
+	return slos, nil
+}
+
+func parseSLO(spec string) (SLO, error) {
+	slo := SLO{Spec: spec}
+
+	i := strings.IndexAny(spec, "<>")
+	if i <= 0 {
+		return slo, fmt.Errorf("invalid SLO %q: expected metric<threshold or metric>threshold", spec)
+	}
+	slo.Op = spec[i : i+1]
+	value := spec[i+1:]
+	if strings.HasPrefix(value, "=") {
+		slo.Op += "="
+		value = value[1:]
+	}
+
+	metric := strings.TrimSpace(spec[:i])
+	if op, m, found := strings.Cut(metric, "."); found {
+		slo.Operation, metric = op, m
+	}
+	slo.Metric = metric
+	value = strings.TrimSpace(value)
+
+	var err error
+	switch {
+	case latencyMetrics[metric] != nil:
+		var d time.Duration
+		if d, err = time.ParseDuration(value); err == nil {
+			slo.Threshold = float64(d) / float64(time.Millisecond)
+		}
+	case metric == "error_rate":
+		if strings.HasSuffix(value, "%") {
+			slo.Threshold, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			slo.Threshold /= 100
+		} else {
+			slo.Threshold, err = strconv.ParseFloat(value, 64)
+		}
+	case metric == "rps":
+		if slo.Operation != "" {
+			return slo, fmt.Errorf("invalid SLO %q: rps covers every operation", spec)
+		}
+		slo.Threshold, err = strconv.ParseFloat(value, 64)
+	default:
+		return slo, fmt.Errorf("invalid SLO %q: unknown metric %s", spec, metric)
+	}
+	if err != nil {
+		return slo, fmt.Errorf("invalid SLO %q: bad threshold %s", spec, value)
+	}
+	return slo, nil
+}
+
+// value computes the metric of the SLO from a report
+func (s SLO) value(report LoadReport) float64 {
+	var durations []float64
+	var requests, errors int
+	ops := make([]string, 0, len(report.Requests))
+	for op := range report.Requests {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		if s.Operation == "" || s.Operation == op {
+			durations = append(durations, report.Durations[op]...)
+			requests += report.Requests[op]
+			errors += report.Errors[op]
+		}
+	}
+
+	switch s.Metric {
+	case "error_rate":
+		if requests == 0 {
+			return 0
+		}
+		return float64(errors) / float64(requests)
+	case "rps":
+		return report.achievedRPS()
+	default:
+		return latencyMetrics[s.Metric](calculateStats(durations))
+	}
+}
+
+func (s SLO) check(report LoadReport) SLOResult {
+	v := s.value(report)
+	var passed bool
+	switch s.Op {
+	case "<":
+		passed = v < s.Threshold
+	case "<=":
+		passed = v <= s.Threshold
+	case ">":
+		passed = v > s.Threshold
+	case ">=":
+		passed = v >= s.Threshold
+	}
+	return SLOResult{SLO: s.Spec, Value: v, Passed: passed}
+}
+
+// checkSLOs checks every SLO and prints the outcome, returning false if any
+// failed
+func checkSLOs(slos []SLO, report LoadReport) ([]SLOResult, bool) {
+	if len(slos) == 0 {
+		return nil, true
+	}
+
+	fmt.Println("\nSLOs:")
+	results := make([]SLOResult, 0, len(slos))
+	ok := true
+	for _, slo := range slos {
+		result := slo.check(report)
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+			ok = false
+		}
+
+		unit := "ms"
+		switch slo.Metric {
+		case "error_rate":
+			result.Value *= 100
+			unit = "%"
+		case "rps":
+			unit = " rps"
+		}
+		fmt.Printf("  %s  %-24s %.2f%s\n", status, slo.Spec, result.Value, unit)
+		if slo.Metric == "error_rate" {
+			result.Value /= 100
+		}
+		results = append(results, result)
+	}
+	return results, ok
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	_ "embed"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed ui.html
+var uiPage []byte
+
+// handleUI serves the admin web UI: a single page browsing keys, showing
+// documents as highlighted YAML, running searches and showing statistics
+// through the HTTP API
+func handleUI(c *gin.Context) {
+	c.Data(200, "text/html; charset=utf-8", uiPage)
+}
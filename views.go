@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// ViewResponse is the body of GET /views/:name
+type ViewResponse struct {
+	Name    string               `json:"name" yaml:"name"`
+	Count   int                  `json:"count" yaml:"count"`
+	Results []storage.ViewResult `json:"results" yaml:"results"`
+}
+
+// handleListViews lists the views of the tenant with their queries and sizes
+func handleListViews(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		views := store.Views()
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, gin.H{"views": views})
+		} else {
+			c.JSON(200, gin.H{"views": views})
+		}
+	}
+}
+
+// handleGetView returns the readable entries of a view, sorted by key and
+// redacted like search results, and only their keys with ?keys_only=true.
+// Like search results, they are exported as CSV or Parquet on request.
+func handleGetView(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		name := c.Param("name")
+
+		results, err := store.View(name, c.Query("keys_only") != "true")
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		readable := results[:0]
+		for _, result := range results {
+			if canRead(c, result.Key) {
+				readable = append(readable, result)
+			}
+		}
+		// Exports too, and before their columns are computed from the values
+		readable = redactViewResults(c, readable)
+
+		if format := exportFormat(c); format != "" {
+			rows := viewRows(readable)
+			respondExport(c, format, rows, exportColumns(c, rows, false))
+			return
+		}
+
+		response := ViewResponse{Name: name, Count: len(readable), Results: readable}
+		if c.GetHeader("Accept") == "application/x-yaml" {
+			c.YAML(200, response)
+		} else {
+			c.JSON(200, response)
+		}
+	}
+}
+
+// handlePutView defines or replaces a view from a search query body
+func handlePutView(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		var query storage.SearchQuery
+		if err := parseRequestBody(c, &query); err != nil {
+			respondBadRequest(c, err)
+			return
+		}
+
+		info, err := store.DefineView(c.Param("name"), query)
+		if err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		c.JSON(200, info)
+	}
+}
+
+func handleDeleteView(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+		if err := store.DropView(c.Param("name")); err != nil {
+			respondStoreError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/threatflux/searchyaml/storage"
+)
+
+// dirSource mirrors the YAML files of a directory tree into the store. Each
+// file is one entry keyed by its slash-separated path relative to root.
+type dirSource struct {
+	mu     sync.Mutex
+	store  *storage.Store
+	root   string
+	prefix string              // prepended to every key
+	keys   map[string]struct{} // keys currently loaded from the directory
+}
+
+func newDirSource(store *storage.Store, root, prefix string) *dirSource {
+	return &dirSource{
+		store:  store,
+		root:   root,
+		prefix: prefix,
+		keys:   make(map[string]struct{}),
+	}
+}
+
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// skipDir reports whether a directory should not be mirrored, e.g. .git
+func skipDir(name string) bool {
+	return len(name) > 1 && strings.HasPrefix(name, ".")
+}
+
+func (d *dirSource) key(path string) (string, error) {
+	rel, err := filepath.Rel(d.root, path)
+	if err != nil {
+		return "", err
+	}
+	return d.prefix + filepath.ToSlash(rel), nil
+}
+
+// load stores the contents of a single YAML file
+func (d *dirSource) load(path string) error {
+	key, err := d.key(path)
+	if err != nil {
+		return err
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	var value interface{}
+	if err := decodeLimits().Decode(raw, &value); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	if err := d.store.Set(key, value); err != nil {
+		return fmt.Errorf("failed to store %s: %v", key, err)
+	}
+
+	d.mu.Lock()
+	d.keys[key] = struct{}{}
+	d.mu.Unlock()
+	return nil
+}
+
+// remove deletes the entry for path, or every entry below it if path was a directory
+func (d *dirSource) remove(path string) {
+	key, err := d.key(path)
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k := range d.keys {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			d.store.Delete(k)
+			delete(d.keys, k)
+		}
+	}
+}
+
+// loadTree loads every YAML file under root and returns the keys it loaded.
+// Files that fail to load are logged and skipped.
+func (d *dirSource) loadTree(root string) (map[string]struct{}, error) {
+	loaded := make(map[string]struct{})
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != root && skipDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isYAMLFile(path) {
+			return nil
+		}
+
+		if err := d.load(path); err != nil {
+			log.Printf("Watch: %v", err)
+			return nil
+		}
+		key, _ := d.key(path)
+		loaded[key] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return loaded, fmt.Errorf("failed to scan %s: %v", root, err)
+	}
+	return loaded, nil
+}
+
+// sync loads every YAML file under the source root and deletes entries whose
+// files are gone. It returns the number of files loaded.
+func (d *dirSource) sync() (int, error) {
+	loaded, err := d.loadTree(d.root)
+	if err != nil {
+		return len(loaded), err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k := range d.keys {
+		if _, ok := loaded[k]; !ok {
+			d.store.Delete(k)
+			delete(d.keys, k)
+		}
+	}
+	return len(loaded), nil
+}
+
+// startDirWatcher loads dir into the store and keeps it in sync with file
+// system changes until the process exits
+func startDirWatcher(store *storage.Store, dir string) error {
+	source := newDirSource(store, filepath.Clean(dir), "")
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %v", err)
+	}
+
+	// fsnotify is not recursive, so every directory is watched individually
+	addTree := func(root string) error {
+		return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			if path != source.root && skipDir(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return watcher.Add(path)
+		})
+	}
+
+	if err := addTree(source.root); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %v", dir, err)
+	}
+
+	count, err := source.sync()
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	log.Printf("Loaded %d YAML files from %s", count, dir)
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				handleWatchEvent(source, event, addTree)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Watch error: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func handleWatchEvent(source *dirSource, event fsnotify.Event, addTree func(string) error) {
+	switch {
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		source.remove(event.Name)
+
+	case event.Has(fsnotify.Create) || event.Has(fsnotify.Write):
+		info, err := os.Stat(event.Name)
+		if err != nil {
+			return
+		}
+
+		if info.IsDir() {
+			if skipDir(info.Name()) {
+				return
+			}
+			if err := addTree(event.Name); err != nil {
+				log.Printf("Watch: failed to watch %s: %v", event.Name, err)
+			}
+			// Files may have been created before the watch was added
+			if _, err := source.loadTree(event.Name); err != nil {
+				log.Printf("Watch: %v", err)
+			}
+			return
+		}
+
+		if isYAMLFile(event.Name) {
+			if err := source.load(event.Name); err != nil {
+				log.Printf("Watch: %v", err)
+			}
+		}
+	}
+}
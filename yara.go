@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/threatflux/searchyaml/storage"
+	"github.com/threatflux/searchyaml/yara"
+)
+
+// yaraIndexField is the text index holding searchable YARA rule text
+const yaraIndexField = "yara"
+
+// ScanMatch is a rule from a stored document that matched scanned content
+type ScanMatch struct {
+	Key string `json:"key"`
+	yara.Match
+}
+
+// yaraSource returns the rule source of a document tagged as a YARA rule:
+// a map with "yara" in its tags and the source in its rule field
+func yaraSource(value interface{}) (string, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+
+	source, ok := m["rule"].(string)
+	if !ok {
+		return "", false
+	}
+
+	tags, _ := m["tags"].([]interface{})
+	for _, tag := range tags {
+		if tag == "yara" {
+			return source, true
+		}
+	}
+	return "", false
+}
+
+// parseYARADocument parses the rules of a YARA document, if value is one
+func parseYARADocument(value interface{}) ([]*yara.Rule, bool, error) {
+	source, ok := yaraSource(value)
+	if !ok {
+		return nil, false, nil
+	}
+	rules, err := yara.Parse(source)
+	return rules, true, err
+}
+
+// indexYARARules makes rule names, meta and strings searchable via the yara index
+func indexYARARules(store *storage.Store, key string, rules []*yara.Rule) error {
+	if len(rules) == 0 {
+		store.RemoveFromIndex(yaraIndexField, key)
+		return nil
+	}
+
+	text := ""
+	for _, rule := range rules {
+		text += rule.Text() + " "
+	}
+	return store.AddToIndex(yaraIndexField, key, text)
+}
+
+func handleScan(store *storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := tenantStore(c, store)
+
+		data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, *ScanMaxSize))
+		if err != nil {
+			respondError(c, 413, CodeTooLarge, err.Error())
+			return
+		}
+
+		// Collect readable rule documents first so matching runs without the store lock
+		sources := make(map[string]string)
+		store.Range(func(key string, entry *storage.Entry) bool {
+			if source, ok := yaraSource(entry.Value); ok && canRead(c, key) {
+				sources[key] = source
+			}
+			return true
+		})
+
+		matches := make([]ScanMatch, 0)
+		for key, source := range sources {
+			rules, err := yara.Parse(source)
+			if err != nil {
+				continue
+			}
+			for _, m := range yara.Scan(rules, data) {
+				matches = append(matches, ScanMatch{Key: key, Match: m})
+			}
+		}
+
+		c.JSON(200, matches)
+	}
+}
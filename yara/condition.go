@@ -0,0 +1,400 @@
+package yara
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// node is an evaluable condition expression
+type node interface {
+	eval(ctx *scanContext, r *Rule) bool
+}
+
+// numeric is an expression producing an integer
+type numeric interface {
+	value(ctx *scanContext) int64
+}
+
+type boolNode bool
+
+func (n boolNode) eval(*scanContext, *Rule) bool { return bool(n) }
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(ctx *scanContext, r *Rule) bool {
+	return n.left.eval(ctx, r) && n.right.eval(ctx, r)
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(ctx *scanContext, r *Rule) bool {
+	return n.left.eval(ctx, r) || n.right.eval(ctx, r)
+}
+
+type notNode struct{ inner node }
+
+func (n notNode) eval(ctx *scanContext, r *Rule) bool { return !n.inner.eval(ctx, r) }
+
+type stringRef struct{ s *String }
+
+func (n stringRef) eval(ctx *scanContext, _ *Rule) bool { return ctx.count(n.s) > 0 }
+
+// ofNode implements "any/all/none/N of (set)"
+type ofNode struct {
+	quantifier string // any, all, none or a number
+	set        []*String
+}
+
+func (n ofNode) eval(ctx *scanContext, _ *Rule) bool {
+	matched := 0
+	for _, s := range n.set {
+		if ctx.count(s) > 0 {
+			matched++
+		}
+	}
+	switch n.quantifier {
+	case "any":
+		return matched > 0
+	case "all":
+		return matched == len(n.set)
+	case "none":
+		return matched == 0
+	default:
+		want, _ := strconv.Atoi(n.quantifier)
+		return matched >= want
+	}
+}
+
+type compareNode struct {
+	op          string
+	left, right numeric
+}
+
+func (n compareNode) eval(ctx *scanContext, _ *Rule) bool {
+	l, r := n.left.value(ctx), n.right.value(ctx)
+	switch n.op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	case "==":
+		return l == r
+	default:
+		return l != r
+	}
+}
+
+type intValue int64
+
+func (v intValue) value(*scanContext) int64 { return int64(v) }
+
+type filesizeValue struct{}
+
+func (filesizeValue) value(ctx *scanContext) int64 { return int64(len(ctx.data)) }
+
+type countValue struct{ s *String }
+
+func (v countValue) value(ctx *scanContext) int64 { return int64(ctx.count(v.s)) }
+
+// Bounds of rule conditions, so untrusted rules cannot exhaust the stack:
+// terms chained with and and or nest as deeply as the condition is long
+const (
+	maxConditionLength = 64 << 10 // Bytes of a condition
+	maxConditionDepth  = 64       // Nesting of parentheses and not
+)
+
+// condParser is a recursive descent parser over condition tokens
+type condParser struct {
+	tokens []string
+	pos    int
+	depth  int
+	rule   *Rule
+}
+
+func parseCondition(src string, r *Rule) (node, error) {
+	if len(src) > maxConditionLength {
+		return nil, fmt.Errorf("condition longer than %d bytes", maxConditionLength)
+	}
+	tokens, err := tokenizeCondition(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty condition")
+	}
+
+	p := &condParser{tokens: tokens, rule: r}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unsupported condition syntax near %q", p.tokens[p.pos])
+	}
+	return n, nil
+}
+
+func tokenizeCondition(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, string(c))
+			i++
+		case strings.ContainsRune("<>=!", rune(c)):
+			if i+1 < len(src) && src[i+1] == '=' {
+				tokens = append(tokens, src[i:i+2])
+				i += 2
+			} else if c == '<' || c == '>' {
+				tokens = append(tokens, string(c))
+				i++
+			} else {
+				return nil, fmt.Errorf("unexpected %q in condition", c)
+			}
+		case c == '$' || c == '#' || isIdentChar(c):
+			start := i
+			i++
+			for i < len(src) && (isIdentChar(src[i]) || src[i] == '*') {
+				i++
+			}
+			tokens = append(tokens, src[start:i])
+		default:
+			return nil, fmt.Errorf("unsupported %q in condition", c)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *condParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *condParser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *condParser) and() (node, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.next()
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+// enter bounds the nesting of the condition
+func (p *condParser) enter() error {
+	if p.depth++; p.depth > maxConditionDepth {
+		return fmt.Errorf("condition nested more than %d levels deep", maxConditionDepth)
+	}
+	return nil
+}
+
+func (p *condParser) leave() {
+	p.depth--
+}
+
+func (p *condParser) not() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	if p.peek() == "not" {
+		p.next()
+		inner, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	return p.primary()
+}
+
+func (p *condParser) primary() (node, error) {
+	tok := p.peek()
+	switch {
+	case tok == "(":
+		p.next()
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return n, nil
+	case tok == "true" || tok == "false":
+		p.next()
+		return boolNode(tok == "true"), nil
+	case tok == "any" || tok == "all" || tok == "none" || isNumber(tok) && p.lookahead(1) == "of":
+		return p.of()
+	case strings.HasPrefix(tok, "$"):
+		p.next()
+		s := p.lookup(tok)
+		if s == nil {
+			return nil, fmt.Errorf("undefined string %s", tok)
+		}
+		return stringRef{s}, nil
+	}
+
+	left, err := p.numeric()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("expected comparison after %s", tok)
+	}
+	right, err := p.numeric()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *condParser) lookahead(n int) string {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return ""
+}
+
+func (p *condParser) of() (node, error) {
+	quantifier := p.next()
+	if p.next() != "of" {
+		return nil, fmt.Errorf("expected of after %s", quantifier)
+	}
+
+	if p.peek() == "them" {
+		p.next()
+		return ofNode{quantifier: quantifier, set: p.rule.Strings}, nil
+	}
+
+	if p.next() != "(" {
+		return nil, fmt.Errorf("expected them or ( after of")
+	}
+	var set []*String
+	for {
+		ref := p.next()
+		matched := p.expand(ref)
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("undefined string %s", ref)
+		}
+		set = append(set, matched...)
+
+		sep := p.next()
+		if sep == ")" {
+			break
+		}
+		if sep != "," {
+			return nil, fmt.Errorf("expected , or ) in string set")
+		}
+	}
+	return ofNode{quantifier: quantifier, set: set}, nil
+}
+
+func (p *condParser) numeric() (numeric, error) {
+	tok := p.next()
+	switch {
+	case tok == "filesize":
+		return filesizeValue{}, nil
+	case strings.HasPrefix(tok, "#"):
+		s := p.lookup("$" + tok[1:])
+		if s == nil {
+			return nil, fmt.Errorf("undefined string $%s", tok[1:])
+		}
+		return countValue{s}, nil
+	case isNumber(tok):
+		return parseNumber(tok)
+	}
+	return nil, fmt.Errorf("unsupported condition syntax near %q", tok)
+}
+
+func (p *condParser) lookup(id string) *String {
+	for _, s := range p.rule.Strings {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// expand resolves a string reference that may end in a * wildcard
+func (p *condParser) expand(ref string) []*String {
+	prefix, wildcard := strings.CutSuffix(ref, "*")
+	if !wildcard {
+		if s := p.lookup(ref); s != nil {
+			return []*String{s}
+		}
+		return nil
+	}
+
+	var matched []*String
+	for _, s := range p.rule.Strings {
+		if strings.HasPrefix(s.ID, prefix) {
+			matched = append(matched, s)
+		}
+	}
+	return matched
+}
+
+func isNumber(tok string) bool {
+	return tok != "" && tok[0] >= '0' && tok[0] <= '9'
+}
+
+func parseNumber(tok string) (numeric, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(tok, "KB"):
+		multiplier, tok = 1<<10, strings.TrimSuffix(tok, "KB")
+	case strings.HasSuffix(tok, "MB"):
+		multiplier, tok = 1<<20, strings.TrimSuffix(tok, "MB")
+	}
+	v, err := strconv.ParseInt(tok, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", tok)
+	}
+	return intValue(v * multiplier), nil
+}
@@ -0,0 +1,532 @@
+package yara
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Parse compiles YARA source into rules. Import statements are accepted and
+// ignored; modules themselves are not supported.
+func Parse(source string) ([]*Rule, error) {
+	p := &parser{src: source}
+	var rules []*Rule
+
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return rules, nil
+		}
+
+		word := p.ident()
+		switch word {
+		case "import", "include":
+			p.skipSpace()
+			if _, err := p.quoted(); err != nil {
+				return nil, err
+			}
+		case "private", "global", "rule":
+			rule, err := p.rule(word)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		case "":
+			return nil, p.errorf("unexpected character %q", p.peek())
+		default:
+			return nil, p.errorf("unexpected keyword %q", word)
+		}
+	}
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("yara: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skipSpace skips whitespace and comments
+func (p *parser) skipSpace() {
+	for !p.eof() {
+		switch {
+		case unicode.IsSpace(rune(p.peek())):
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				p.pos = len(p.src)
+				return
+			}
+			p.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (p *parser) ident() string {
+	start := p.pos
+	for !p.eof() && isIdentChar(p.peek()) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// quoted reads a double-quoted string with YARA escapes
+func (p *parser) quoted() (string, error) {
+	if p.peek() != '"' {
+		return "", p.errorf("expected string")
+	}
+	start := p.pos
+	p.pos++
+	for !p.eof() && p.peek() != '"' {
+		if p.peek() == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.eof() {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+
+	value, err := unescape(p.src[start+1 : p.pos-1])
+	if err != nil {
+		return "", p.errorf("%v", err)
+	}
+	return value, nil
+}
+
+func unescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'x':
+			if i+3 > len(s) {
+				return "", fmt.Errorf("invalid hex escape")
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid hex escape")
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func (p *parser) rule(word string) (*Rule, error) {
+	r := &Rule{Meta: make(map[string]string)}
+
+	for word != "rule" {
+		switch word {
+		case "private":
+			r.Private = true
+		case "global":
+			r.Global = true
+		default:
+			return nil, p.errorf("expected rule, got %q", word)
+		}
+		p.skipSpace()
+		word = p.ident()
+	}
+
+	p.skipSpace()
+	r.Name = p.ident()
+	if r.Name == "" {
+		return nil, p.errorf("missing rule name")
+	}
+
+	p.skipSpace()
+	if p.peek() == ':' {
+		p.pos++
+		for {
+			p.skipSpace()
+			tag := p.ident()
+			if tag == "" {
+				break
+			}
+			r.Tags = append(r.Tags, tag)
+		}
+	}
+
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("rule %s: missing }", r.Name)
+		}
+		if p.peek() == '}' {
+			p.pos++
+			break
+		}
+
+		section := p.ident()
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+
+		var err error
+		switch section {
+		case "meta":
+			err = p.meta(r)
+		case "strings":
+			err = p.strings(r)
+		case "condition":
+			err = p.condition(r)
+		default:
+			err = p.errorf("unknown section %q", section)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.condition == nil {
+		return nil, p.errorf("rule %s has no condition", r.Name)
+	}
+	return r, nil
+}
+
+// atSection reports whether the cursor is at the next section or rule end
+func (p *parser) atSection() bool {
+	p.skipSpace()
+	if p.eof() || p.peek() == '}' {
+		return true
+	}
+	rest := p.src[p.pos:]
+	for _, s := range []string{"meta", "strings", "condition"} {
+		if strings.HasPrefix(rest, s) {
+			after := strings.TrimLeft(rest[len(s):], " \t")
+			if strings.HasPrefix(after, ":") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *parser) meta(r *Rule) error {
+	for !p.atSection() {
+		key := p.ident()
+		if key == "" {
+			return p.errorf("invalid meta key")
+		}
+		if err := p.expect('='); err != nil {
+			return err
+		}
+		p.skipSpace()
+
+		if p.peek() == '"' {
+			value, err := p.quoted()
+			if err != nil {
+				return err
+			}
+			r.Meta[key] = value
+			continue
+		}
+
+		start := p.pos
+		if p.peek() == '-' {
+			p.pos++
+		}
+		p.ident()
+		if p.pos == start {
+			return p.errorf("invalid meta value for %s", key)
+		}
+		r.Meta[key] = p.src[start:p.pos]
+	}
+	return nil
+}
+
+func (p *parser) strings(r *Rule) error {
+	for !p.atSection() {
+		if p.peek() != '$' {
+			return p.errorf("expected string identifier")
+		}
+		p.pos++
+		s := &String{ID: "$" + p.ident()}
+		if err := p.expect('='); err != nil {
+			return err
+		}
+		p.skipSpace()
+
+		switch p.peek() {
+		case '"':
+			value, err := p.quoted()
+			if err != nil {
+				return err
+			}
+			s.Kind, s.Value = "text", value
+		case '{':
+			end := strings.IndexByte(p.src[p.pos:], '}')
+			if end < 0 {
+				return p.errorf("unterminated hex string")
+			}
+			s.Kind, s.Value = "hex", strings.TrimSpace(p.src[p.pos+1:p.pos+end])
+			p.pos += end + 1
+		case '/':
+			value, err := p.regex()
+			if err != nil {
+				return err
+			}
+			s.Kind, s.Value = "regex", value
+		default:
+			return p.errorf("invalid value for %s", s.ID)
+		}
+
+		for {
+			save := p.pos
+			p.skipSpace()
+			mod := p.ident()
+			switch mod {
+			case "nocase", "wide", "ascii", "fullword", "private":
+				s.Modifiers = append(s.Modifiers, mod)
+				continue
+			}
+			p.pos = save
+			break
+		}
+
+		pattern, err := compileString(s)
+		if err != nil {
+			return p.errorf("%s: %v", s.ID, err)
+		}
+		s.pattern = pattern
+		r.Strings = append(r.Strings, s)
+	}
+	return nil
+}
+
+// regex reads /pattern/flags and returns a Go regexp source
+func (p *parser) regex() (string, error) {
+	p.pos++
+	start := p.pos
+	for !p.eof() && p.peek() != '/' {
+		if p.peek() == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.eof() {
+		return "", p.errorf("unterminated regex")
+	}
+	body := p.src[start:p.pos]
+	p.pos++
+
+	flags := ""
+	for !p.eof() && (p.peek() == 'i' || p.peek() == 's') {
+		flags += string(p.peek())
+		p.pos++
+	}
+	if flags != "" {
+		body = "(?" + flags + ")" + body
+	}
+	return body, nil
+}
+
+func (p *parser) condition(r *Rule) error {
+	start := p.pos
+	depth := 0
+	for !p.eof() {
+		c := p.peek()
+		if c == '"' {
+			if _, err := p.quoted(); err != nil {
+				return err
+			}
+			continue
+		}
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			depth--
+		} else if c == '}' && depth == 0 {
+			break
+		}
+		p.pos++
+	}
+
+	node, err := parseCondition(p.src[start:p.pos], r)
+	if err != nil {
+		return p.errorf("rule %s: %v", r.Name, err)
+	}
+	r.condition = node
+	return nil
+}
+
+func hasModifier(s *String, mod string) bool {
+	for _, m := range s.Modifiers {
+		if m == mod {
+			return true
+		}
+	}
+	return false
+}
+
+// compileString builds a regexp over Latin-1 decoded data for a string
+func compileString(s *String) (*regexp.Regexp, error) {
+	var expr string
+	switch s.Kind {
+	case "text":
+		ascii := regexp.QuoteMeta(latin1([]byte(s.Value)))
+		var variants []string
+		if !hasModifier(s, "wide") || hasModifier(s, "ascii") {
+			variants = append(variants, ascii)
+		}
+		if hasModifier(s, "wide") {
+			var wide strings.Builder
+			for _, c := range []byte(s.Value) {
+				wide.WriteString(regexp.QuoteMeta(string(rune(c))))
+				wide.WriteString(`\x00`)
+			}
+			variants = append(variants, wide.String())
+		}
+		expr = "(?:" + strings.Join(variants, "|") + ")"
+	case "hex":
+		hex, err := hexToRegexp(s.Value)
+		if err != nil {
+			return nil, err
+		}
+		expr = hex
+	case "regex":
+		expr = s.Value
+	}
+
+	if hasModifier(s, "fullword") {
+		expr = `\b` + expr + `\b`
+	}
+	if hasModifier(s, "nocase") {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile("(?s)" + expr)
+}
+
+// hexToRegexp converts a YARA hex string body into regexp source
+func hexToRegexp(hex string) (string, error) {
+	var b strings.Builder
+	fields := strings.Fields(strings.NewReplacer("[", " [", "]", "] ", "(", " ( ", ")", " ) ", "|", " | ").Replace(hex))
+
+	for _, f := range fields {
+		switch {
+		case f == "(":
+			b.WriteString("(?:")
+		case f == ")":
+			b.WriteString(")")
+		case f == "|":
+			b.WriteString("|")
+		case strings.HasPrefix(f, "["):
+			jump := strings.Trim(f, "[]")
+			lo, hi, ranged := strings.Cut(jump, "-")
+			if !ranged {
+				hi = lo
+			}
+			if lo == "" {
+				lo = "0"
+			}
+			if !isJump(lo) || hi != "" && !isJump(hi) {
+				return "", fmt.Errorf("invalid jump %q", f)
+			}
+			b.WriteString(".{" + lo + "," + hi + "}")
+		default:
+			if len(f)%2 != 0 {
+				return "", fmt.Errorf("invalid hex token %q", f)
+			}
+			for i := 0; i < len(f); i += 2 {
+				tok, err := hexByte(f[i : i+2])
+				if err != nil {
+					return "", err
+				}
+				b.WriteString(tok)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// isJump reports whether s is a jump length, which regexp would otherwise
+// take literally if it is not a number
+func isJump(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 16)
+	return err == nil
+}
+
+// hexByte converts a two-character hex byte, possibly with ? nibbles
+func hexByte(pair string) (string, error) {
+	switch {
+	case pair == "??":
+		return ".", nil
+	case pair[1] == '?':
+		hi, err := strconv.ParseUint(pair[:1], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid hex byte %q", pair)
+		}
+		return fmt.Sprintf(`[\x{%02x}-\x{%02x}]`, hi<<4, hi<<4|0xf), nil
+	case pair[0] == '?':
+		lo, err := strconv.ParseUint(pair[1:], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid hex byte %q", pair)
+		}
+		alts := make([]string, 16)
+		for i := range alts {
+			alts[i] = fmt.Sprintf(`\x{%02x}`, uint64(i)<<4|lo)
+		}
+		return "[" + strings.Join(alts, "") + "]", nil
+	default:
+		v, err := strconv.ParseUint(pair, 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid hex byte %q", pair)
+		}
+		return fmt.Sprintf(`\x{%02x}`, v), nil
+	}
+}
@@ -0,0 +1,126 @@
+// Package yara implements parsing and matching for a practical subset of the
+// YARA rule language: text, hex and regex strings with the nocase, wide,
+// ascii and fullword modifiers, and conditions built from string references,
+// counts, "of" expressions, filesize and boolean operators.
+package yara
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Rule is a single compiled YARA rule
+type Rule struct {
+	Name      string            `json:"name"`
+	Tags      []string          `json:"tags,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	Strings   []*String         `json:"strings,omitempty"`
+	Private   bool              `json:"private,omitempty"`
+	Global    bool              `json:"global,omitempty"`
+	condition node
+}
+
+// String is a named pattern declared in a rule's strings section
+type String struct {
+	ID        string   `json:"id"`
+	Kind      string   `json:"kind"` // text, hex or regex
+	Value     string   `json:"value"`
+	Modifiers []string `json:"modifiers,omitempty"`
+	pattern   *regexp.Regexp
+}
+
+// Match describes a rule that matched scanned data
+type Match struct {
+	Rule    string   `json:"rule"`
+	Tags    []string `json:"tags,omitempty"`
+	Strings []string `json:"strings,omitempty"` // Identifiers of strings found in the data
+}
+
+// Match evaluates the rule against data
+func (r *Rule) Match(data []byte) (Match, bool) {
+	ctx := newScanContext(data)
+	if !r.condition.eval(ctx, r) {
+		return Match{}, false
+	}
+
+	m := Match{Rule: r.Name, Tags: r.Tags}
+	for _, s := range r.Strings {
+		if ctx.count(s) > 0 {
+			m.Strings = append(m.Strings, s.ID)
+		}
+	}
+	return m, true
+}
+
+// Text returns the searchable text of the rule: name, tags, meta values and
+// text string contents
+func (r *Rule) Text() string {
+	parts := []string{r.Name}
+	parts = append(parts, r.Tags...)
+	for key, value := range r.Meta {
+		parts = append(parts, key, value)
+	}
+	for _, s := range r.Strings {
+		if s.Kind == "text" {
+			parts = append(parts, s.Value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Scan matches data against every non-private rule. Global rules must all
+// match for any other rule to be reported.
+func Scan(rules []*Rule, data []byte) []Match {
+	var matches []Match
+	for _, r := range rules {
+		if r.Global {
+			if _, ok := r.Match(data); !ok {
+				return nil
+			}
+		}
+	}
+	for _, r := range rules {
+		if r.Private {
+			continue
+		}
+		if m, ok := r.Match(data); ok {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// scanContext caches per-string match counts for a single scan
+type scanContext struct {
+	data   []byte
+	text   string // data decoded as Latin-1 so every byte maps to one rune
+	counts map[*String]int
+}
+
+func newScanContext(data []byte) *scanContext {
+	return &scanContext{
+		data:   data,
+		text:   latin1(data),
+		counts: make(map[*String]int),
+	}
+}
+
+func (ctx *scanContext) count(s *String) int {
+	if n, ok := ctx.counts[s]; ok {
+		return n
+	}
+	n := len(s.pattern.FindAllStringIndex(ctx.text, -1))
+	ctx.counts[s] = n
+	return n
+}
+
+// latin1 maps each byte to the rune of the same value so patterns can match
+// arbitrary binary data with Go's UTF-8 based regexp engine
+func latin1(data []byte) string {
+	var b strings.Builder
+	b.Grow(len(data))
+	for _, c := range data {
+		b.WriteRune(rune(c))
+	}
+	return b.String()
+}
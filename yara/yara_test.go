@@ -0,0 +1,271 @@
+package yara
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseRule parses source holding a single rule
+func parseRule(t *testing.T, source string) *Rule {
+	t.Helper()
+	rules, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("Parse returned %d rules, want 1", len(rules))
+	}
+	return rules[0]
+}
+
+func TestParse(t *testing.T) {
+	rules, err := Parse(`
+import "pe"
+
+// Comments are skipped
+rule first : malware apt29 {
+	meta:
+		author = "analyst \"one\""
+		severity = 8
+		offset = -1
+		active = true
+	strings:
+		$text = "cmd.exe" nocase wide ascii
+		$hex = { 4D 5A ?? 00 }
+		$re = /https?:\/\/[a-z]+/is fullword
+	condition:
+		any of them
+}
+
+/* Modifiers of the rule */
+private global rule second { condition: true }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Parse returned %d rules, want 2", len(rules))
+	}
+
+	first := rules[0]
+	if first.Name != "first" || !reflect.DeepEqual(first.Tags, []string{"malware", "apt29"}) || first.Private || first.Global {
+		t.Errorf("first rule: name %q, tags %q, private %v, global %v", first.Name, first.Tags, first.Private, first.Global)
+	}
+	wantMeta := map[string]string{"author": `analyst "one"`, "severity": "8", "offset": "-1", "active": "true"}
+	if !reflect.DeepEqual(first.Meta, wantMeta) {
+		t.Errorf("meta %q, want %q", first.Meta, wantMeta)
+	}
+	wantStrings := []String{
+		{ID: "$text", Kind: "text", Value: "cmd.exe", Modifiers: []string{"nocase", "wide", "ascii"}},
+		{ID: "$hex", Kind: "hex", Value: "4D 5A ?? 00"},
+		{ID: "$re", Kind: "regex", Value: `(?is)https?:\/\/[a-z]+`, Modifiers: []string{"fullword"}},
+	}
+	if len(first.Strings) != len(wantStrings) {
+		t.Fatalf("%d strings, want %d", len(first.Strings), len(wantStrings))
+	}
+	for i, want := range wantStrings {
+		got := *first.Strings[i]
+		got.pattern = nil
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("string %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if text := first.Text(); !strings.Contains(text, "first malware apt29") || !strings.Contains(text, "cmd.exe") {
+		t.Errorf("Text() = %q, want the name, tags and text strings", text)
+	}
+
+	second := rules[1]
+	if second.Name != "second" || !second.Private || !second.Global || len(second.Strings) != 0 {
+		t.Errorf("second rule: name %q, private %v, global %v, %d strings", second.Name, second.Private, second.Global, len(second.Strings))
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		strings   string // The strings section
+		condition string
+		data      string
+		want      bool
+		found     []string // Identifiers of the strings found, when matched
+	}{
+		{"text", `$a = "evil"`, "$a", "an evil payload", true, []string{"$a"}},
+		{"text missing", `$a = "evil"`, "$a", "an EVIL payload", false, nil},
+		{"text nocase", `$a = "evil" nocase`, "$a", "an EVIL payload", true, []string{"$a"}},
+		{"text escapes", `$a = "a\tb\x00\"c\\"`, "$a", "a\tb\x00\"c\\", true, []string{"$a"}},
+		{"text metacharacters", `$a = "a.b*c"`, "$a", "axbbc", false, nil},
+		{"wide", `$a = "MZ" wide`, "$a", "xM\x00Z\x00", true, []string{"$a"}},
+		{"wide only", `$a = "MZ" wide`, "$a", "MZ", false, nil},
+		{"wide ascii", `$a = "MZ" wide ascii`, "$a", "MZ", true, []string{"$a"}},
+		{"fullword", `$a = "dom" fullword`, "$a", "www.dom.com", true, []string{"$a"}},
+		{"fullword inside word", `$a = "dom" fullword`, "$a", "random", false, nil},
+		{"binary data", `$a = "\xff\xfe"`, "$a", "\x00\xff\xfe\x00", true, []string{"$a"}},
+
+		{"hex", `$h = { 4D 5A 90 00 }`, "$h", "xxMZ\x90\x00", true, []string{"$h"}},
+		{"hex lowercase and no spaces", `$h = { 4d5a9000 }`, "$h", "MZ\x90\x00", true, []string{"$h"}},
+		{"hex mismatch", `$h = { 4D 5A 90 00 }`, "$h", "MZ\x91\x00", false, nil},
+		{"hex wildcard", `$h = { 4D ?? 90 }`, "$h", "M\xff\x90", true, []string{"$h"}},
+		{"hex high nibble", `$h = { 4D 5? }`, "$h", "MZ", true, []string{"$h"}},
+		{"hex high nibble mismatch", `$h = { 4D 5? }`, "$h", "MJ", false, nil},
+		{"hex low nibble", `$h = { 4D ?A }`, "$h", "MJ", true, []string{"$h"}},
+		{"hex low nibble mismatch", `$h = { 4D ?A }`, "$h", "MK", false, nil},
+		{"hex jump", `$h = { 4D [2-4] 00 }`, "$h", "Mab\x00", true, []string{"$h"}},
+		{"hex jump too short", `$h = { 4D [2-4] 00 }`, "$h", "Ma\x00", false, nil},
+		{"hex jump too long", `$h = { 4D [2-4] 00 }`, "$h", "Mabcde\x00", false, nil},
+		{"hex exact jump", `$h = { 4D [1] 00 }`, "$h", "Ma\x00", true, []string{"$h"}},
+		{"hex open jump", `$h = { 4D [2-] 00 }`, "$h", "Mabcdefgh\x00", true, []string{"$h"}},
+		{"hex alternatives", `$h = { 4D ( 5A | 4A ) 00 }`, "$h", "MJ\x00", true, []string{"$h"}},
+		{"hex alternatives mismatch", `$h = { 4D ( 5A | 4A ) 00 }`, "$h", "MK\x00", false, nil},
+		{"hex newline", "$h = { 4D 5A\n\t\t90 00 }", "$h", "MZ\x90\x00", true, []string{"$h"}},
+
+		{"regex", `$r = /ev[a-z]l/`, "$r", "an eval", true, []string{"$r"}},
+		{"regex flags", `$r = /EV.L/is`, "$r", "an ev\nl", true, []string{"$r"}},
+		{"regex escaped slash", `$r = /a\/b/`, "$r", "a/b", true, []string{"$r"}},
+
+		{"and", `$a = "one" $b = "two"`, "$a and $b", "one two", true, []string{"$a", "$b"}},
+		{"and missing", `$a = "one" $b = "two"`, "$a and $b", "one", false, nil},
+		{"or", `$a = "one" $b = "two"`, "$a or $b", "two", true, []string{"$b"}},
+		{"not", `$a = "one"`, "not $a", "two", true, nil},
+		{"precedence", `$a = "a" $b = "b" $c = "c"`, "$a or $b and $c", "a", true, []string{"$a"}},
+		{"parentheses", `$a = "a" $b = "b" $c = "c"`, "($a or $b) and $c", "a", false, nil},
+		{"not binds tightest", `$a = "a" $b = "b"`, "not $a and $b", "b", true, []string{"$b"}},
+		{"any of them", `$a = "a" $b = "b"`, "any of them", "b", true, []string{"$b"}},
+		{"all of them", `$a = "a" $b = "b"`, "all of them", "b", false, nil},
+		{"none of them", `$a = "a" $b = "b"`, "none of them", "c", true, nil},
+		{"n of set", `$a = "a" $b = "b" $c = "c"`, "2 of ($a, $c)", "a c", true, []string{"$a", "$c"}},
+		{"n of set short", `$a = "a" $b = "b" $c = "c"`, "2 of ($a, $c)", "a b", false, nil},
+		{"of wildcard", `$x1 = "1" $x2 = "2" $y = "y"`, "all of ($x*)", "1 2", true, []string{"$x1", "$x2"}},
+		{"count", `$a = "ab"`, "#a == 3", "ab ab ab", true, []string{"$a"}},
+		{"count greater", `$a = "ab"`, "#a > 3", "ab ab ab", false, nil},
+		{"filesize", `$a = "a"`, "$a and filesize < 10", "a", true, []string{"$a"}},
+		{"filesize units", ``, "filesize >= 1KB and filesize < 1MB", strings.Repeat("x", 1024), true, nil},
+		{"filesize units too small", ``, "filesize >= 1KB", strings.Repeat("x", 1023), false, nil},
+		{"hex number", ``, "filesize == 0x10", strings.Repeat("x", 16), true, nil},
+		{"not equal", ``, "filesize != 0", "", false, nil},
+		{"true", ``, "true", "", true, nil},
+		{"false", ``, "false or false", "anything", false, nil},
+		{"condition comment", `$a = "a"`, "$a // trailing comment", "a", true, []string{"$a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "rule test {\n"
+			if tt.strings != "" {
+				source += "strings:\n" + tt.strings + "\n"
+			}
+			source += "condition:\n" + tt.condition + "\n}"
+			r := parseRule(t, source)
+			m, ok := r.Match([]byte(tt.data))
+			if ok != tt.want {
+				t.Fatalf("Match(%q) = %v, want %v", tt.data, ok, tt.want)
+			}
+			if ok && !reflect.DeepEqual(m.Strings, tt.found) {
+				t.Errorf("Match(%q) found strings %q, want %q", tt.data, m.Strings, tt.found)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	rules, err := Parse(`
+private rule has_mz { strings: $mz = "MZ" condition: $mz }
+rule packed : packer { strings: $upx = "UPX!" condition: $upx }
+rule small { condition: filesize < 100 }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range Scan(rules, []byte("MZ UPX!")) {
+		names = append(names, m.Rule)
+	}
+	if want := []string{"packed", "small"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Scan matched %q, want %q without the private rule", names, want)
+	}
+	if matches := Scan(rules, []byte("UPX!")); len(matches) != 2 || !reflect.DeepEqual(matches[0].Tags, []string{"packer"}) {
+		t.Errorf("Scan = %+v, want packed with its tags and small", matches)
+	}
+
+	global, err := Parse(`global rule only_pe { strings: $mz = "MZ" condition: $mz }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules = append(global, rules...)
+	if matches := Scan(rules, []byte("UPX!")); matches != nil {
+		t.Errorf("Scan with a failing global rule = %+v, want nothing", matches)
+	}
+	if matches := Scan(rules, []byte("MZ UPX!")); len(matches) != 3 {
+		t.Errorf("Scan with a matching global rule = %+v, want it and the two others", matches)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name, source, err string
+	}{
+		{"unknown keyword", `strings rule a { condition: true }`, `line 1: unexpected keyword "strings"`},
+		{"unexpected character", `{ }`, `unexpected character '{'`},
+		{"bad import", `import pe`, "expected string"},
+		{"missing name", `rule { condition: true }`, "missing rule name"},
+		{"missing brace", `rule a condition: true }`, `expected '{'`},
+		{"unknown section", `rule a { options: x condition: true }`, `unknown section "options"`},
+		{"missing colon", `rule a { condition true }`, `expected ':'`},
+		{"no condition", `rule a { strings: $a = "x" }`, "rule a has no condition"},
+		{"unterminated rule", `rule a { condition: true`, "rule a: missing }"},
+		{"bad modifier keyword", `private rule`, "missing rule name"},
+		{"meta key", "rule a {\nmeta:\n = \"x\"\ncondition: true }", `line 3: invalid meta key`},
+		{"meta value", `rule a { meta: x = ! condition: true }`, "invalid meta value for x"},
+		{"unterminated meta", `rule a { meta: x = "open`, "unterminated string"},
+		{"bad hex escape", `rule a { strings: $a = "\xZZ" condition: $a }`, "invalid hex escape"},
+		{"string identifier", `rule a { strings: a = "x" condition: true }`, "expected string identifier"},
+		{"string value", `rule a { strings: $a = x condition: true }`, "invalid value for $a"},
+		{"unterminated hex", `rule a { strings: $a = { 4D 5A`, "unterminated hex string"},
+		{"odd hex", `rule a { strings: $a = { 4D 5 } condition: $a }`, `$a: invalid hex token "5"`},
+		{"bad hex byte", `rule a { strings: $a = { 4G } condition: $a }`, `invalid hex byte "4G"`},
+		{"bad hex nibble", `rule a { strings: $a = { G? } condition: $a }`, `invalid hex byte "G?"`},
+		{"bad jump", `rule a { strings: $a = { 4D [x] 00 } condition: $a }`, `$a: invalid jump "[x]"`},
+		{"bad jump range", `rule a { strings: $a = { 4D [1-y] 00 } condition: $a }`, `$a: invalid jump "[1-y]"`},
+		{"inverted jump", `rule a { strings: $a = { 4D [4-2] 00 } condition: $a }`, "$a: error parsing regexp"},
+		{"unterminated regex", `rule a { strings: $a = /abc`, "unterminated regex"},
+		{"bad regex", `rule a { strings: $a = /a(b/ condition: $a }`, "$a: error parsing regexp"},
+		{"empty condition", `rule a { condition: }`, "rule a: empty condition"},
+		{"undefined string", `rule a { condition: $a }`, "undefined string $a"},
+		{"undefined count", `rule a { condition: #a > 1 }`, "undefined string $a"},
+		{"undefined set member", `rule a { strings: $a = "x" condition: any of ($b) }`, "undefined string $b"},
+		{"bad set", `rule a { strings: $a = "x" condition: any of ($a $a) }`, "expected , or ) in string set"},
+		{"of without set", `rule a { strings: $a = "x" condition: any of $a }`, "expected them or ( after of"},
+		{"quantifier without of", `rule a { strings: $a = "x" condition: any $a }`, "expected of after any"},
+		{"missing paren", `rule a { strings: $a = "x" condition: ($a $a) }`, "missing )"},
+		{"missing comparison", `rule a { condition: filesize }`, "expected comparison after filesize"},
+		{"bad operand", `rule a { condition: filesize < foo }`, `unsupported condition syntax near "foo"`},
+		{"bad number", `rule a { condition: filesize < 9z }`, `invalid number "9z"`},
+		{"trailing tokens", `rule a { strings: $a = "x" condition: $a $a }`, `unsupported condition syntax near "$a"`},
+		{"bad operator", `rule a { condition: filesize = 1 }`, `unexpected '=' in condition`},
+		{"bad character", `rule a { condition: filesize < 1 + 1 }`, `unsupported '+' in condition`},
+		{"nesting", `rule a { condition: ` + strings.Repeat("not ", maxConditionDepth) + `true }`, "nested more than 64 levels deep"},
+		{"long condition", `rule a { condition: ` + strings.Repeat("true and ", maxConditionLength/9+1) + `true }`, "condition longer than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse: error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// FuzzParse checks that parsing and matching arbitrary rules neither panics
+// nor exhausts the stack
+func FuzzParse(f *testing.F) {
+	f.Add(`rule a : t { meta: k = "v" strings: $a = "x" nocase $h = { 4D ?? [1-2] ( 5A | ?A ) } $r = /a+/i condition: any of them and #a < 3 }`, "xMZ\x00Za")
+	f.Add(`private global rule b { strings: $x1 = "1" wide condition: not (all of ($x*) or filesize > 1KB) }`, "1\x00")
+	f.Add(`rule c { condition: ((((true)))) }`, "")
+	f.Fuzz(func(t *testing.T, source, data string) {
+		rules, err := Parse(source)
+		if err != nil {
+			return
+		}
+		Scan(rules, []byte(data))
+	})
+}